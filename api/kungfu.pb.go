@@ -0,0 +1,1177 @@
+// The control api of kungfu, served by the gateway on admin.grpc, the typed and streaming
+// counterpart of the admin http apis for the external controllers and the GUIs.
+//
+// The Go code is generated by protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative api/kungfu.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: api/kungfu.proto
+
+package api
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{0}
+}
+
+type WatchStatsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// interval_ms is the interval of the stats, default 1000, at least 100
+	IntervalMs    uint32 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchStatsRequest) Reset() {
+	*x = WatchStatsRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchStatsRequest) ProtoMessage() {}
+
+func (x *WatchStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchStatsRequest.ProtoReflect.Descriptor instead.
+func (*WatchStatsRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *WatchStatsRequest) GetIntervalMs() uint32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+type Stats struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// time is the unix time in milliseconds
+	Time       int64  `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
+	TcpConns   uint32 `protobuf:"varint,2,opt,name=tcp_conns,json=tcpConns,proto3" json:"tcp_conns,omitempty"`
+	UdpConns   uint32 `protobuf:"varint,3,opt,name=udp_conns,json=udpConns,proto3" json:"udp_conns,omitempty"`
+	UdpTunnels uint32 `protobuf:"varint,4,opt,name=udp_tunnels,json=udpTunnels,proto3" json:"udp_tunnels,omitempty"`
+	// upload and download is the bytes relayed by the active connections
+	Upload    int64       `protobuf:"varint,5,opt,name=upload,proto3" json:"upload,omitempty"`
+	Download  int64       `protobuf:"varint,6,opt,name=download,proto3" json:"download,omitempty"`
+	Outbounds []*Outbound `protobuf:"bytes,7,rep,name=outbounds,proto3" json:"outbounds,omitempty"`
+	// queries is the queries of today by outcome, counted by the dns servers
+	Queries map[string]int64 `protobuf:"bytes,8,rep,name=queries,proto3" json:"queries,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// top_domains is the domains of the most bytes relayed today
+	TopDomains    []*Count `protobuf:"bytes,9,rep,name=top_domains,json=topDomains,proto3" json:"top_domains,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_api_kungfu_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Stats) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Stats) GetTcpConns() uint32 {
+	if x != nil {
+		return x.TcpConns
+	}
+	return 0
+}
+
+func (x *Stats) GetUdpConns() uint32 {
+	if x != nil {
+		return x.UdpConns
+	}
+	return 0
+}
+
+func (x *Stats) GetUdpTunnels() uint32 {
+	if x != nil {
+		return x.UdpTunnels
+	}
+	return 0
+}
+
+func (x *Stats) GetUpload() int64 {
+	if x != nil {
+		return x.Upload
+	}
+	return 0
+}
+
+func (x *Stats) GetDownload() int64 {
+	if x != nil {
+		return x.Download
+	}
+	return 0
+}
+
+func (x *Stats) GetOutbounds() []*Outbound {
+	if x != nil {
+		return x.Outbounds
+	}
+	return nil
+}
+
+func (x *Stats) GetQueries() map[string]int64 {
+	if x != nil {
+		return x.Queries
+	}
+	return nil
+}
+
+func (x *Stats) GetTopDomains() []*Count {
+	if x != nil {
+		return x.TopDomains
+	}
+	return nil
+}
+
+type Outbound struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Strategy      string                 `protobuf:"bytes,2,opt,name=strategy,proto3" json:"strategy,omitempty"`
+	Upstreams     []*Upstream            `protobuf:"bytes,3,rep,name=upstreams,proto3" json:"upstreams,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Outbound) Reset() {
+	*x = Outbound{}
+	mi := &file_api_kungfu_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Outbound) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Outbound) ProtoMessage() {}
+
+func (x *Outbound) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Outbound.ProtoReflect.Descriptor instead.
+func (*Outbound) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Outbound) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Outbound) GetStrategy() string {
+	if x != nil {
+		return x.Strategy
+	}
+	return ""
+}
+
+func (x *Outbound) GetUpstreams() []*Upstream {
+	if x != nil {
+		return x.Upstreams
+	}
+	return nil
+}
+
+type Upstream struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// proxy is the scheme and the host of the proxy url, the credentials removed
+	Proxy     string `protobuf:"bytes,1,opt,name=proxy,proto3" json:"proxy,omitempty"`
+	Alive     bool   `protobuf:"varint,2,opt,name=alive,proto3" json:"alive,omitempty"`
+	LatencyMs int64  `protobuf:"varint,3,opt,name=latency_ms,json=latencyMs,proto3" json:"latency_ms,omitempty"`
+	// udp is whether the proxy relay udp
+	Udp           bool `protobuf:"varint,4,opt,name=udp,proto3" json:"udp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Upstream) Reset() {
+	*x = Upstream{}
+	mi := &file_api_kungfu_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Upstream) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Upstream) ProtoMessage() {}
+
+func (x *Upstream) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Upstream.ProtoReflect.Descriptor instead.
+func (*Upstream) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Upstream) GetProxy() string {
+	if x != nil {
+		return x.Proxy
+	}
+	return ""
+}
+
+func (x *Upstream) GetAlive() bool {
+	if x != nil {
+		return x.Alive
+	}
+	return false
+}
+
+func (x *Upstream) GetLatencyMs() int64 {
+	if x != nil {
+		return x.LatencyMs
+	}
+	return 0
+}
+
+func (x *Upstream) GetUdp() bool {
+	if x != nil {
+		return x.Udp
+	}
+	return false
+}
+
+type Count struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Count         int64                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Count) Reset() {
+	*x = Count{}
+	mi := &file_api_kungfu_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Count) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Count) ProtoMessage() {}
+
+func (x *Count) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Count.ProtoReflect.Descriptor instead.
+func (*Count) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Count) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Count) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type ListRulesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// outbound is the name of the outbound, the proxy domain set if empty
+	Outbound      string `protobuf:"bytes,1,opt,name=outbound,proto3" json:"outbound,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRulesRequest) Reset() {
+	*x = ListRulesRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRulesRequest) ProtoMessage() {}
+
+func (x *ListRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRulesRequest.ProtoReflect.Descriptor instead.
+func (*ListRulesRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListRulesRequest) GetOutbound() string {
+	if x != nil {
+		return x.Outbound
+	}
+	return ""
+}
+
+type RulesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// outbound is the name of the outbound, the proxy domain set if empty
+	Outbound      string   `protobuf:"bytes,1,opt,name=outbound,proto3" json:"outbound,omitempty"`
+	Domains       []string `protobuf:"bytes,2,rep,name=domains,proto3" json:"domains,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RulesRequest) Reset() {
+	*x = RulesRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RulesRequest) ProtoMessage() {}
+
+func (x *RulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RulesRequest.ProtoReflect.Descriptor instead.
+func (*RulesRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RulesRequest) GetOutbound() string {
+	if x != nil {
+		return x.Outbound
+	}
+	return ""
+}
+
+func (x *RulesRequest) GetDomains() []string {
+	if x != nil {
+		return x.Domains
+	}
+	return nil
+}
+
+type Rules struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// domains is the domains listed, or added and removed
+	Domains       []string `protobuf:"bytes,1,rep,name=domains,proto3" json:"domains,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Rules) Reset() {
+	*x = Rules{}
+	mi := &file_api_kungfu_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Rules) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rules) ProtoMessage() {}
+
+func (x *Rules) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rules.ProtoReflect.Descriptor instead.
+func (*Rules) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Rules) GetDomains() []string {
+	if x != nil {
+		return x.Domains
+	}
+	return nil
+}
+
+type Conn struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// network is tcp or udp
+	Network  string `protobuf:"bytes,2,opt,name=network,proto3" json:"network,omitempty"`
+	Client   string `protobuf:"bytes,3,opt,name=client,proto3" json:"client,omitempty"`
+	FakeIp   string `protobuf:"bytes,4,opt,name=fake_ip,json=fakeIp,proto3" json:"fake_ip,omitempty"`
+	Domain   string `protobuf:"bytes,5,opt,name=domain,proto3" json:"domain,omitempty"`
+	Target   string `protobuf:"bytes,6,opt,name=target,proto3" json:"target,omitempty"`
+	Outbound string `protobuf:"bytes,7,opt,name=outbound,proto3" json:"outbound,omitempty"`
+	Upload   int64  `protobuf:"varint,8,opt,name=upload,proto3" json:"upload,omitempty"`
+	Download int64  `protobuf:"varint,9,opt,name=download,proto3" json:"download,omitempty"`
+	// start is the unix time in milliseconds
+	Start         int64 `protobuf:"varint,10,opt,name=start,proto3" json:"start,omitempty"`
+	DurationMs    int64 `protobuf:"varint,11,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Conn) Reset() {
+	*x = Conn{}
+	mi := &file_api_kungfu_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Conn) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Conn) ProtoMessage() {}
+
+func (x *Conn) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Conn.ProtoReflect.Descriptor instead.
+func (*Conn) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Conn) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Conn) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *Conn) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+func (x *Conn) GetFakeIp() string {
+	if x != nil {
+		return x.FakeIp
+	}
+	return ""
+}
+
+func (x *Conn) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *Conn) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Conn) GetOutbound() string {
+	if x != nil {
+		return x.Outbound
+	}
+	return ""
+}
+
+func (x *Conn) GetUpload() int64 {
+	if x != nil {
+		return x.Upload
+	}
+	return 0
+}
+
+func (x *Conn) GetDownload() int64 {
+	if x != nil {
+		return x.Download
+	}
+	return 0
+}
+
+func (x *Conn) GetStart() int64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *Conn) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+type ListConnsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListConnsRequest) Reset() {
+	*x = ListConnsRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConnsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConnsRequest) ProtoMessage() {}
+
+func (x *ListConnsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConnsRequest.ProtoReflect.Descriptor instead.
+func (*ListConnsRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{10}
+}
+
+type Conns struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Conns         []*Conn                `protobuf:"bytes,1,rep,name=conns,proto3" json:"conns,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Conns) Reset() {
+	*x = Conns{}
+	mi := &file_api_kungfu_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Conns) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Conns) ProtoMessage() {}
+
+func (x *Conns) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Conns.ProtoReflect.Descriptor instead.
+func (*Conns) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Conns) GetConns() []*Conn {
+	if x != nil {
+		return x.Conns
+	}
+	return nil
+}
+
+type WatchConnsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchConnsRequest) Reset() {
+	*x = WatchConnsRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchConnsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchConnsRequest) ProtoMessage() {}
+
+func (x *WatchConnsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchConnsRequest.ProtoReflect.Descriptor instead.
+func (*WatchConnsRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{12}
+}
+
+type ConnEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// type is open or close
+	Type          string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Conn          *Conn  `protobuf:"bytes,2,opt,name=conn,proto3" json:"conn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnEvent) Reset() {
+	*x = ConnEvent{}
+	mi := &file_api_kungfu_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnEvent) ProtoMessage() {}
+
+func (x *ConnEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnEvent.ProtoReflect.Descriptor instead.
+func (*ConnEvent) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ConnEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *ConnEvent) GetConn() *Conn {
+	if x != nil {
+		return x.Conn
+	}
+	return nil
+}
+
+type KillConnRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KillConnRequest) Reset() {
+	*x = KillConnRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KillConnRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KillConnRequest) ProtoMessage() {}
+
+func (x *KillConnRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KillConnRequest.ProtoReflect.Descriptor instead.
+func (*KillConnRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *KillConnRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type KillConnReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KillConnReply) Reset() {
+	*x = KillConnReply{}
+	mi := &file_api_kungfu_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KillConnReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KillConnReply) ProtoMessage() {}
+
+func (x *KillConnReply) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KillConnReply.ProtoReflect.Descriptor instead.
+func (*KillConnReply) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *KillConnReply) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ReloadConfigRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReloadConfigRequest) Reset() {
+	*x = ReloadConfigRequest{}
+	mi := &file_api_kungfu_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReloadConfigRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReloadConfigRequest) ProtoMessage() {}
+
+func (x *ReloadConfigRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReloadConfigRequest.ProtoReflect.Descriptor instead.
+func (*ReloadConfigRequest) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{16}
+}
+
+type ReloadConfigReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReloadConfigReply) Reset() {
+	*x = ReloadConfigReply{}
+	mi := &file_api_kungfu_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReloadConfigReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReloadConfigReply) ProtoMessage() {}
+
+func (x *ReloadConfigReply) ProtoReflect() protoreflect.Message {
+	mi := &file_api_kungfu_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReloadConfigReply.ProtoReflect.Descriptor instead.
+func (*ReloadConfigReply) Descriptor() ([]byte, []int) {
+	return file_api_kungfu_proto_rawDescGZIP(), []int{17}
+}
+
+var File_api_kungfu_proto protoreflect.FileDescriptor
+
+const file_api_kungfu_proto_rawDesc = "" +
+	"\n" +
+	"\x10api/kungfu.proto\x12\x06kungfu\"\x0e\n" +
+	"\fStatsRequest\"4\n" +
+	"\x11WatchStatsRequest\x12\x1f\n" +
+	"\vinterval_ms\x18\x01 \x01(\rR\n" +
+	"intervalMs\"\xfc\x02\n" +
+	"\x05Stats\x12\x12\n" +
+	"\x04time\x18\x01 \x01(\x03R\x04time\x12\x1b\n" +
+	"\ttcp_conns\x18\x02 \x01(\rR\btcpConns\x12\x1b\n" +
+	"\tudp_conns\x18\x03 \x01(\rR\budpConns\x12\x1f\n" +
+	"\vudp_tunnels\x18\x04 \x01(\rR\n" +
+	"udpTunnels\x12\x16\n" +
+	"\x06upload\x18\x05 \x01(\x03R\x06upload\x12\x1a\n" +
+	"\bdownload\x18\x06 \x01(\x03R\bdownload\x12.\n" +
+	"\toutbounds\x18\a \x03(\v2\x10.kungfu.OutboundR\toutbounds\x124\n" +
+	"\aqueries\x18\b \x03(\v2\x1a.kungfu.Stats.QueriesEntryR\aqueries\x12.\n" +
+	"\vtop_domains\x18\t \x03(\v2\r.kungfu.CountR\n" +
+	"topDomains\x1a:\n" +
+	"\fQueriesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"j\n" +
+	"\bOutbound\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bstrategy\x18\x02 \x01(\tR\bstrategy\x12.\n" +
+	"\tupstreams\x18\x03 \x03(\v2\x10.kungfu.UpstreamR\tupstreams\"g\n" +
+	"\bUpstream\x12\x14\n" +
+	"\x05proxy\x18\x01 \x01(\tR\x05proxy\x12\x14\n" +
+	"\x05alive\x18\x02 \x01(\bR\x05alive\x12\x1d\n" +
+	"\n" +
+	"latency_ms\x18\x03 \x01(\x03R\tlatencyMs\x12\x10\n" +
+	"\x03udp\x18\x04 \x01(\bR\x03udp\"1\n" +
+	"\x05Count\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x03R\x05count\".\n" +
+	"\x10ListRulesRequest\x12\x1a\n" +
+	"\boutbound\x18\x01 \x01(\tR\boutbound\"D\n" +
+	"\fRulesRequest\x12\x1a\n" +
+	"\boutbound\x18\x01 \x01(\tR\boutbound\x12\x18\n" +
+	"\adomains\x18\x02 \x03(\tR\adomains\"!\n" +
+	"\x05Rules\x12\x18\n" +
+	"\adomains\x18\x01 \x03(\tR\adomains\"\x98\x02\n" +
+	"\x04Conn\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x18\n" +
+	"\anetwork\x18\x02 \x01(\tR\anetwork\x12\x16\n" +
+	"\x06client\x18\x03 \x01(\tR\x06client\x12\x17\n" +
+	"\afake_ip\x18\x04 \x01(\tR\x06fakeIp\x12\x16\n" +
+	"\x06domain\x18\x05 \x01(\tR\x06domain\x12\x16\n" +
+	"\x06target\x18\x06 \x01(\tR\x06target\x12\x1a\n" +
+	"\boutbound\x18\a \x01(\tR\boutbound\x12\x16\n" +
+	"\x06upload\x18\b \x01(\x03R\x06upload\x12\x1a\n" +
+	"\bdownload\x18\t \x01(\x03R\bdownload\x12\x14\n" +
+	"\x05start\x18\n" +
+	" \x01(\x03R\x05start\x12\x1f\n" +
+	"\vduration_ms\x18\v \x01(\x03R\n" +
+	"durationMs\"\x12\n" +
+	"\x10ListConnsRequest\"+\n" +
+	"\x05Conns\x12\"\n" +
+	"\x05conns\x18\x01 \x03(\v2\f.kungfu.ConnR\x05conns\"\x13\n" +
+	"\x11WatchConnsRequest\"A\n" +
+	"\tConnEvent\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12 \n" +
+	"\x04conn\x18\x02 \x01(\v2\f.kungfu.ConnR\x04conn\"!\n" +
+	"\x0fKillConnRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"\x1f\n" +
+	"\rKillConnReply\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"\x15\n" +
+	"\x13ReloadConfigRequest\"\x13\n" +
+	"\x11ReloadConfigReply2\x87\x04\n" +
+	"\aControl\x12/\n" +
+	"\bGetStats\x12\x14.kungfu.StatsRequest\x1a\r.kungfu.Stats\x128\n" +
+	"\n" +
+	"WatchStats\x12\x19.kungfu.WatchStatsRequest\x1a\r.kungfu.Stats0\x01\x124\n" +
+	"\tListRules\x12\x18.kungfu.ListRulesRequest\x1a\r.kungfu.Rules\x12/\n" +
+	"\bAddRules\x12\x14.kungfu.RulesRequest\x1a\r.kungfu.Rules\x122\n" +
+	"\vRemoveRules\x12\x14.kungfu.RulesRequest\x1a\r.kungfu.Rules\x124\n" +
+	"\tListConns\x12\x18.kungfu.ListConnsRequest\x1a\r.kungfu.Conns\x12<\n" +
+	"\n" +
+	"WatchConns\x12\x19.kungfu.WatchConnsRequest\x1a\x11.kungfu.ConnEvent0\x01\x12:\n" +
+	"\bKillConn\x12\x17.kungfu.KillConnRequest\x1a\x15.kungfu.KillConnReply\x12F\n" +
+	"\fReloadConfig\x12\x1b.kungfu.ReloadConfigRequest\x1a\x19.kungfu.ReloadConfigReplyB\x1fZ\x1dgithub.com/yinheli/kungfu/apib\x06proto3"
+
+var (
+	file_api_kungfu_proto_rawDescOnce sync.Once
+	file_api_kungfu_proto_rawDescData []byte
+)
+
+func file_api_kungfu_proto_rawDescGZIP() []byte {
+	file_api_kungfu_proto_rawDescOnce.Do(func() {
+		file_api_kungfu_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_kungfu_proto_rawDesc), len(file_api_kungfu_proto_rawDesc)))
+	})
+	return file_api_kungfu_proto_rawDescData
+}
+
+var file_api_kungfu_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
+var file_api_kungfu_proto_goTypes = []any{
+	(*StatsRequest)(nil),        // 0: kungfu.StatsRequest
+	(*WatchStatsRequest)(nil),   // 1: kungfu.WatchStatsRequest
+	(*Stats)(nil),               // 2: kungfu.Stats
+	(*Outbound)(nil),            // 3: kungfu.Outbound
+	(*Upstream)(nil),            // 4: kungfu.Upstream
+	(*Count)(nil),               // 5: kungfu.Count
+	(*ListRulesRequest)(nil),    // 6: kungfu.ListRulesRequest
+	(*RulesRequest)(nil),        // 7: kungfu.RulesRequest
+	(*Rules)(nil),               // 8: kungfu.Rules
+	(*Conn)(nil),                // 9: kungfu.Conn
+	(*ListConnsRequest)(nil),    // 10: kungfu.ListConnsRequest
+	(*Conns)(nil),               // 11: kungfu.Conns
+	(*WatchConnsRequest)(nil),   // 12: kungfu.WatchConnsRequest
+	(*ConnEvent)(nil),           // 13: kungfu.ConnEvent
+	(*KillConnRequest)(nil),     // 14: kungfu.KillConnRequest
+	(*KillConnReply)(nil),       // 15: kungfu.KillConnReply
+	(*ReloadConfigRequest)(nil), // 16: kungfu.ReloadConfigRequest
+	(*ReloadConfigReply)(nil),   // 17: kungfu.ReloadConfigReply
+	nil,                         // 18: kungfu.Stats.QueriesEntry
+}
+var file_api_kungfu_proto_depIdxs = []int32{
+	3,  // 0: kungfu.Stats.outbounds:type_name -> kungfu.Outbound
+	18, // 1: kungfu.Stats.queries:type_name -> kungfu.Stats.QueriesEntry
+	5,  // 2: kungfu.Stats.top_domains:type_name -> kungfu.Count
+	4,  // 3: kungfu.Outbound.upstreams:type_name -> kungfu.Upstream
+	9,  // 4: kungfu.Conns.conns:type_name -> kungfu.Conn
+	9,  // 5: kungfu.ConnEvent.conn:type_name -> kungfu.Conn
+	0,  // 6: kungfu.Control.GetStats:input_type -> kungfu.StatsRequest
+	1,  // 7: kungfu.Control.WatchStats:input_type -> kungfu.WatchStatsRequest
+	6,  // 8: kungfu.Control.ListRules:input_type -> kungfu.ListRulesRequest
+	7,  // 9: kungfu.Control.AddRules:input_type -> kungfu.RulesRequest
+	7,  // 10: kungfu.Control.RemoveRules:input_type -> kungfu.RulesRequest
+	10, // 11: kungfu.Control.ListConns:input_type -> kungfu.ListConnsRequest
+	12, // 12: kungfu.Control.WatchConns:input_type -> kungfu.WatchConnsRequest
+	14, // 13: kungfu.Control.KillConn:input_type -> kungfu.KillConnRequest
+	16, // 14: kungfu.Control.ReloadConfig:input_type -> kungfu.ReloadConfigRequest
+	2,  // 15: kungfu.Control.GetStats:output_type -> kungfu.Stats
+	2,  // 16: kungfu.Control.WatchStats:output_type -> kungfu.Stats
+	8,  // 17: kungfu.Control.ListRules:output_type -> kungfu.Rules
+	8,  // 18: kungfu.Control.AddRules:output_type -> kungfu.Rules
+	8,  // 19: kungfu.Control.RemoveRules:output_type -> kungfu.Rules
+	11, // 20: kungfu.Control.ListConns:output_type -> kungfu.Conns
+	13, // 21: kungfu.Control.WatchConns:output_type -> kungfu.ConnEvent
+	15, // 22: kungfu.Control.KillConn:output_type -> kungfu.KillConnReply
+	17, // 23: kungfu.Control.ReloadConfig:output_type -> kungfu.ReloadConfigReply
+	15, // [15:24] is the sub-list for method output_type
+	6,  // [6:15] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_api_kungfu_proto_init() }
+func file_api_kungfu_proto_init() {
+	if File_api_kungfu_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_kungfu_proto_rawDesc), len(file_api_kungfu_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   19,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_kungfu_proto_goTypes,
+		DependencyIndexes: file_api_kungfu_proto_depIdxs,
+		MessageInfos:      file_api_kungfu_proto_msgTypes,
+	}.Build()
+	File_api_kungfu_proto = out.File
+	file_api_kungfu_proto_goTypes = nil
+	file_api_kungfu_proto_depIdxs = nil
+}
@@ -0,0 +1,466 @@
+// The control api of kungfu, served by the gateway on admin.grpc, the typed and streaming
+// counterpart of the admin http apis for the external controllers and the GUIs.
+//
+// The Go code is generated by protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative api/kungfu.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: api/kungfu.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Control_GetStats_FullMethodName     = "/kungfu.Control/GetStats"
+	Control_WatchStats_FullMethodName   = "/kungfu.Control/WatchStats"
+	Control_ListRules_FullMethodName    = "/kungfu.Control/ListRules"
+	Control_AddRules_FullMethodName     = "/kungfu.Control/AddRules"
+	Control_RemoveRules_FullMethodName  = "/kungfu.Control/RemoveRules"
+	Control_ListConns_FullMethodName    = "/kungfu.Control/ListConns"
+	Control_WatchConns_FullMethodName   = "/kungfu.Control/WatchConns"
+	Control_KillConn_FullMethodName     = "/kungfu.Control/KillConn"
+	Control_ReloadConfig_FullMethodName = "/kungfu.Control/ReloadConfig"
+)
+
+// ControlClient is the client API for Control service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Control is the control api of the gateway, the admin token or basic auth is required as the
+// authorization metadata if configured, the same as the admin http apis
+type ControlClient interface {
+	// GetStats return the stats of the gateway
+	GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error)
+	// WatchStats stream the stats every interval until canceled
+	WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Stats], error)
+	// ListRules list the domains of the proxy domain set, or the rules of the outbound
+	ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*Rules, error)
+	// AddRules add the domains to the proxy domain set or the rules of the outbound, the dns
+	// servers and the gateways reload the rules
+	AddRules(ctx context.Context, in *RulesRequest, opts ...grpc.CallOption) (*Rules, error)
+	// RemoveRules remove the domains from the proxy domain set or the rules of the outbound
+	RemoveRules(ctx context.Context, in *RulesRequest, opts ...grpc.CallOption) (*Rules, error)
+	// ListConns return the active relayed connections
+	ListConns(ctx context.Context, in *ListConnsRequest, opts ...grpc.CallOption) (*Conns, error)
+	// WatchConns stream the connections opened and closed until canceled
+	WatchConns(ctx context.Context, in *WatchConnsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConnEvent], error)
+	// KillConn close the relayed connection
+	KillConn(ctx context.Context, in *KillConnRequest, opts ...grpc.CallOption) (*KillConnReply, error)
+	// ReloadConfig reload config.yml, the same as SIGHUP, the running config is kept if invalid
+	ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigReply, error)
+}
+
+type controlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewControlClient(cc grpc.ClientConnInterface) ControlClient {
+	return &controlClient{cc}
+}
+
+func (c *controlClient) GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Control_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Stats], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Control_ServiceDesc.Streams[0], Control_WatchStats_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchStatsRequest, Stats]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Control_WatchStatsClient = grpc.ServerStreamingClient[Stats]
+
+func (c *controlClient) ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*Rules, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, Control_ListRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) AddRules(ctx context.Context, in *RulesRequest, opts ...grpc.CallOption) (*Rules, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, Control_AddRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) RemoveRules(ctx context.Context, in *RulesRequest, opts ...grpc.CallOption) (*Rules, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, Control_RemoveRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ListConns(ctx context.Context, in *ListConnsRequest, opts ...grpc.CallOption) (*Conns, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Conns)
+	err := c.cc.Invoke(ctx, Control_ListConns_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) WatchConns(ctx context.Context, in *WatchConnsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConnEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Control_ServiceDesc.Streams[1], Control_WatchConns_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchConnsRequest, ConnEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Control_WatchConnsClient = grpc.ServerStreamingClient[ConnEvent]
+
+func (c *controlClient) KillConn(ctx context.Context, in *KillConnRequest, opts ...grpc.CallOption) (*KillConnReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(KillConnReply)
+	err := c.cc.Invoke(ctx, Control_KillConn_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReloadConfigReply)
+	err := c.cc.Invoke(ctx, Control_ReloadConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ControlServer is the server API for Control service.
+// All implementations must embed UnimplementedControlServer
+// for forward compatibility.
+//
+// Control is the control api of the gateway, the admin token or basic auth is required as the
+// authorization metadata if configured, the same as the admin http apis
+type ControlServer interface {
+	// GetStats return the stats of the gateway
+	GetStats(context.Context, *StatsRequest) (*Stats, error)
+	// WatchStats stream the stats every interval until canceled
+	WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[Stats]) error
+	// ListRules list the domains of the proxy domain set, or the rules of the outbound
+	ListRules(context.Context, *ListRulesRequest) (*Rules, error)
+	// AddRules add the domains to the proxy domain set or the rules of the outbound, the dns
+	// servers and the gateways reload the rules
+	AddRules(context.Context, *RulesRequest) (*Rules, error)
+	// RemoveRules remove the domains from the proxy domain set or the rules of the outbound
+	RemoveRules(context.Context, *RulesRequest) (*Rules, error)
+	// ListConns return the active relayed connections
+	ListConns(context.Context, *ListConnsRequest) (*Conns, error)
+	// WatchConns stream the connections opened and closed until canceled
+	WatchConns(*WatchConnsRequest, grpc.ServerStreamingServer[ConnEvent]) error
+	// KillConn close the relayed connection
+	KillConn(context.Context, *KillConnRequest) (*KillConnReply, error)
+	// ReloadConfig reload config.yml, the same as SIGHUP, the running config is kept if invalid
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigReply, error)
+	mustEmbedUnimplementedControlServer()
+}
+
+// UnimplementedControlServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedControlServer struct{}
+
+func (UnimplementedControlServer) GetStats(context.Context, *StatsRequest) (*Stats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedControlServer) WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[Stats]) error {
+	return status.Error(codes.Unimplemented, "method WatchStats not implemented")
+}
+func (UnimplementedControlServer) ListRules(context.Context, *ListRulesRequest) (*Rules, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListRules not implemented")
+}
+func (UnimplementedControlServer) AddRules(context.Context, *RulesRequest) (*Rules, error) {
+	return nil, status.Error(codes.Unimplemented, "method AddRules not implemented")
+}
+func (UnimplementedControlServer) RemoveRules(context.Context, *RulesRequest) (*Rules, error) {
+	return nil, status.Error(codes.Unimplemented, "method RemoveRules not implemented")
+}
+func (UnimplementedControlServer) ListConns(context.Context, *ListConnsRequest) (*Conns, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListConns not implemented")
+}
+func (UnimplementedControlServer) WatchConns(*WatchConnsRequest, grpc.ServerStreamingServer[ConnEvent]) error {
+	return status.Error(codes.Unimplemented, "method WatchConns not implemented")
+}
+func (UnimplementedControlServer) KillConn(context.Context, *KillConnRequest) (*KillConnReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method KillConn not implemented")
+}
+func (UnimplementedControlServer) ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedControlServer) mustEmbedUnimplementedControlServer() {}
+func (UnimplementedControlServer) testEmbeddedByValue()                 {}
+
+// UnsafeControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ControlServer will
+// result in compilation errors.
+type UnsafeControlServer interface {
+	mustEmbedUnimplementedControlServer()
+}
+
+func RegisterControlServer(s grpc.ServiceRegistrar, srv ControlServer) {
+	// If the following call panics, it indicates UnimplementedControlServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Control_ServiceDesc, srv)
+}
+
+func _Control_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetStats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_WatchStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControlServer).WatchStats(m, &grpc.GenericServerStream[WatchStatsRequest, Stats]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Control_WatchStatsServer = grpc.ServerStreamingServer[Stats]
+
+func _Control_ListRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ListRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ListRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ListRules(ctx, req.(*ListRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_AddRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).AddRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_AddRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).AddRules(ctx, req.(*RulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_RemoveRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).RemoveRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_RemoveRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).RemoveRules(ctx, req.(*RulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ListConns_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConnsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ListConns(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ListConns_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ListConns(ctx, req.(*ListConnsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_WatchConns_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchConnsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControlServer).WatchConns(m, &grpc.GenericServerStream[WatchConnsRequest, ConnEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Control_WatchConnsServer = grpc.ServerStreamingServer[ConnEvent]
+
+func _Control_KillConn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KillConnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).KillConn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_KillConn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).KillConn(ctx, req.(*KillConnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ReloadConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ReloadConfig(ctx, req.(*ReloadConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Control_ServiceDesc is the grpc.ServiceDesc for Control service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Control_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kungfu.Control",
+	HandlerType: (*ControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStats",
+			Handler:    _Control_GetStats_Handler,
+		},
+		{
+			MethodName: "ListRules",
+			Handler:    _Control_ListRules_Handler,
+		},
+		{
+			MethodName: "AddRules",
+			Handler:    _Control_AddRules_Handler,
+		},
+		{
+			MethodName: "RemoveRules",
+			Handler:    _Control_RemoveRules_Handler,
+		},
+		{
+			MethodName: "ListConns",
+			Handler:    _Control_ListConns_Handler,
+		},
+		{
+			MethodName: "KillConn",
+			Handler:    _Control_KillConn_Handler,
+		},
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _Control_ReloadConfig_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStats",
+			Handler:       _Control_WatchStats_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchConns",
+			Handler:       _Control_WatchConns_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/kungfu.proto",
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+	"github.com/yinheli/kungfu/netfilter"
+)
+
+const (
+	// doctorDomain is the proxied domain tested if not given
+	doctorDomain = "www.google.com"
+	// doctorTimeout is the timeout of the dns query and the connection
+	doctorTimeout = time.Duration(time.Second * 10)
+)
+
+var (
+	// run execute the command and return the combined output, replaced in test
+	run = func(name string, args ...string) (string, error) {
+		out, err := exec.Command(name, args...).CombinedOutput()
+		return string(out), err
+	}
+	// dialTLS handshake with the domain via the address, replaced in test
+	dialTLS = func(address string, domain string) error {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: doctorTimeout}, "tcp", address,
+			&tls.Config{ServerName: domain})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	// goos is the os checked, replaced in test
+	goos = runtime.GOOS
+)
+
+// doctorCheck print the result of the stage, ok, warn or fail
+type doctorCheck struct {
+	failed []string
+}
+
+func (d *doctorCheck) ok(stage string, format string, args ...interface{}) {
+	fmt.Printf("[ok]   %-8s %s\n", stage, fmt.Sprintf(format, args...))
+}
+
+func (d *doctorCheck) warn(stage string, format string, args ...interface{}) {
+	fmt.Printf("[warn] %-8s %s\n", stage, fmt.Sprintf(format, args...))
+}
+
+func (d *doctorCheck) fail(stage string, format string, args ...interface{}) {
+	fmt.Printf("[fail] %-8s %s\n", stage, fmt.Sprintf(format, args...))
+	d.failed = append(d.failed, stage)
+}
+
+// doctor check the interception end to end, the fake ip answered by the dns server, the route
+// and the firewall rules of the fake ip, and the connection relayed through the proxy
+func doctor(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("invalid doctor command, %s", strings.Join(args, " "))
+	}
+	domain := doctorDomain
+	if len(args) == 1 {
+		domain = args[0]
+	}
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	d := &doctorCheck{}
+	err := d.diagnose(domain)
+	if len(d.failed) == 0 {
+		fmt.Println("\nall checks passed")
+	}
+	return err
+}
+
+// diagnose check the stages in order, return the diagnosis of the failed checks
+func (d *doctorCheck) diagnose(domain string) error {
+	config, err := internal.ParseConfig(*c)
+	if err != nil {
+		d.fail("config", "%v", err)
+		return fmt.Errorf("diagnosis: fix the config file first")
+	}
+	d.ok("config", "%s", *c)
+
+	network := doctorNetwork(d, config, domain)
+
+	ip, err := doctorQuery(config, domain)
+	if err != nil {
+		d.fail("dns", "%v", err)
+		return fmt.Errorf("diagnosis: the dns server is not running or not reachable, check kungfu-dns-server and -dns")
+	}
+	if network == nil || !network.Subnet.Contains(ip) {
+		d.fail("dns", "%s -> %s, not a fake ip", domain, ip)
+		return fmt.Errorf("diagnosis: the domain is answered directly, check the proxy rules and the fake ip network")
+	}
+	d.ok("dns", "%s -> %s", domain, ip)
+
+	doctorRoute(d, network, ip)
+	doctorFirewall(d, config, network)
+
+	if err := dialTLS(net.JoinHostPort(ip.String(), "443"), domain); err != nil {
+		d.fail("connect", "tls handshake with %s via %s error, %v", domain, ip, err)
+		if len(d.failed) > 1 {
+			return fmt.Errorf("diagnosis: the fake ip is not routed to the gateway, fix the failed checks above")
+		}
+		return fmt.Errorf("diagnosis: the gateway or the proxy failed, check the gateway log, the proxy (kungfu:proxy) and /api/conns of the gateway")
+	}
+	d.ok("connect", "tls handshake with %s via %s", domain, ip)
+
+	if len(d.failed) > 0 {
+		return fmt.Errorf("diagnosis: the connection is relayed, but fix the failed checks above")
+	}
+	return nil
+}
+
+// doctorNetwork get the fake ip network and test the rules of the domain, nil if the store is
+// not reachable
+func doctorNetwork(d *doctorCheck, config *internal.Config, domain string) *internal.Network {
+	value := config.Pool.Network
+	if config.Store == "memory" {
+		if value == "" {
+			value = config.Memory.Keys["network"]
+		}
+		d.warn("store", "memory store is local to the dns server, the rules are not checked")
+	} else {
+		store := internal.NewStore(config)
+		defer store.Close()
+
+		var err error
+		if value, err = store.Get(internal.GetRedisNetworkKey()); err != nil {
+			d.fail("store", "get %s error, %v", internal.GetRedisNetworkKey(), err)
+			return nil
+		}
+		d.ok("store", "%s", config.Redis.Addr)
+
+		domains, _ := store.SMembers(internal.GetRedisProxyDomainSetKey())
+		rules, _ := store.SMembers(internal.GetRedisProxyRuleSetKey())
+		if matcher, err := gfwlist.NewMatcher(append(domains, rules...)); err != nil {
+			d.fail("rules", "%v", err)
+		} else if !matcher.Match(domain) {
+			d.warn("rules", "%s is not proxied, add it by: kungfu rules add %s", domain, domain)
+		} else {
+			d.ok("rules", "%s is proxied", domain)
+		}
+	}
+
+	network, err := internal.ParseNetwork(value)
+	if err != nil {
+		d.fail("network", "invalid network %q, %v", value, err)
+		return nil
+	}
+	d.ok("network", "%s, relay ip %s", network.Subnet, network.RelayIp)
+	return network
+}
+
+// doctorQuery query the A record of the domain from the dns server
+func doctorQuery(config *internal.Config, domain string) (net.IP, error) {
+	server := *dnsServer
+	if server == "" {
+		port := "53"
+		if _, p, err := net.SplitHostPort(config.DNS.Listen); err == nil {
+			port = p
+		}
+		server = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	client := &dns.Client{Timeout: doctorTimeout}
+	resp, _, err := client.Exchange(m, server)
+	if err != nil {
+		return nil, fmt.Errorf("query %s from %s error, %v", domain, server, err)
+	}
+
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			return a.A, nil
+		}
+	}
+	return nil, fmt.Errorf("no A record of %s from %s, rcode %s", domain, server, dns.RcodeToString[resp.Rcode])
+}
+
+// doctorRoute check the fake ip is routed to the gateway
+func doctorRoute(d *doctorCheck, network *internal.Network, ip net.IP) {
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if local, _, err := net.ParseCIDR(addr.String()); err == nil && local.Equal(network.RelayIp) {
+			d.ok("route", "the gateway is running on this host, relay ip %s", network.RelayIp)
+			return
+		}
+	}
+
+	if goos != "linux" {
+		d.warn("route", "the gateway is not on this host, make sure %s is routed to the gateway", network.Subnet)
+		return
+	}
+
+	out, err := run("ip", "route", "get", ip.String())
+	route := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	if err != nil {
+		d.fail("route", "no route of %s, %s", ip, route)
+		return
+	}
+	d.warn("route", "the gateway is not on this host, make sure the route is to the gateway: %s", route)
+}
+
+// doctorFirewall check the iptables rules installed by the gateway -setup-firewall, linux only
+func doctorFirewall(d *doctorCheck, config *internal.Config, network *internal.Network) {
+	if goos != "linux" {
+		return
+	}
+
+	table := "filter"
+	if config.Gateway.Mode == internal.GatewayModeTProxy {
+		table = "mangle"
+	}
+
+	out, err := run("iptables", "-t", table, "-S", netfilter.Chain)
+	switch {
+	case err == nil && strings.Contains(out, network.Subnet.String()):
+		d.ok("firewall", "chain %s of table %s", netfilter.Chain, table)
+	case config.Gateway.Mode == internal.GatewayModeTProxy:
+		d.fail("firewall", "no tproxy rules of %s in table %s, start the gateway with -setup-firewall", network.Subnet, table)
+	default:
+		d.warn("firewall", "no rules of %s in chain %s, the forwarding may be dropped, see -setup-firewall", network.Subnet, netfilter.Chain)
+	}
+}
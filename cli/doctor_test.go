@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startDoctorDNS answer the A record of the ip, return the address of the server
+func startDoctorDNS(t *testing.T, ip string) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A " + ip)
+			msg.Answer = append(msg.Answer, rr)
+			w.WriteMsg(msg)
+		})}
+	go srv.ActivateAndServe()
+	<-started
+	return pc.LocalAddr().String(), func() { srv.Shutdown() }
+}
+
+func TestDoctor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kungfu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(r func(string, ...string) (string, error), d func(string, string) error, o string, config string, server string) {
+		run, dialTLS, goos, *c, *dnsServer = r, d, o, config, server
+	}(run, dialTLS, goos, *c, *dnsServer)
+	goos = "linux"
+
+	tests := []struct {
+		name string
+		mode string
+		// answer is the ip answered by the dns server, not running if empty
+		answer   string
+		route    error
+		firewall string
+		connect  error
+		// diagnosis is the error expected, nil if empty
+		diagnosis string
+		// failed is the stages failed
+		failed string
+	}{
+		{name: "invalid config", mode: "bridge", diagnosis: "fix the config file first", failed: "config"},
+		{name: "dns down", diagnosis: "the dns server is not running", failed: "dns"},
+		{name: "not fake ip", answer: "1.2.3.4", diagnosis: "the domain is answered directly", failed: "dns"},
+		{name: "not routed", answer: "10.85.0.2", route: errors.New("exit status 2"), connect: errors.New("timeout"),
+			diagnosis: "the fake ip is not routed to the gateway", failed: "route,connect"},
+		{name: "proxy failed", answer: "10.85.0.2", firewall: "-A KUNGFU -d 10.85.0.0/16 -j ACCEPT", connect: errors.New("reset"),
+			diagnosis: "the gateway or the proxy failed", failed: "connect"},
+		{name: "no tproxy rules", mode: "tproxy", answer: "10.85.0.2",
+			diagnosis: "the connection is relayed, but fix the failed checks above", failed: "firewall"},
+		{name: "passed", answer: "10.85.0.2", firewall: "-A KUNGFU -d 10.85.0.0/16 -j ACCEPT"},
+		// the forwarding rules are optional in the tun mode
+		{name: "no tun rules", answer: "10.85.0.2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*c = filepath.Join(dir, "config.yml")
+			config := "store: memory\npool:\n  network: 10.85.0.1/16\n"
+			if test.mode != "" {
+				config += "gateway:\n  mode: " + test.mode + "\n"
+			}
+			ioutil.WriteFile(*c, []byte(config), 0644)
+
+			*dnsServer = deadAddr(t)
+			if test.answer != "" {
+				addr, stop := startDoctorDNS(t, test.answer)
+				defer stop()
+				*dnsServer = addr
+			}
+
+			var cmds []string
+			run = func(name string, args ...string) (string, error) {
+				cmd := name + " " + strings.Join(args, " ")
+				cmds = append(cmds, cmd)
+				if name == "ip" {
+					return "10.85.0.2 via 192.168.1.2 dev eth0", test.route
+				}
+				return test.firewall, nil
+			}
+			dialTLS = func(address string, domain string) error {
+				if address != net.JoinHostPort(test.answer, "443") || domain != doctorDomain {
+					t.Fatal("unexpected connect", address, domain)
+				}
+				return test.connect
+			}
+
+			d := &doctorCheck{}
+			err := d.diagnose(doctorDomain)
+			if strings.Join(d.failed, ",") != test.failed {
+				t.Fatal("unexpected failed checks", d.failed)
+			}
+			if test.diagnosis == "" && err != nil {
+				t.Fatal("the checks should pass", err)
+			}
+			if test.diagnosis != "" && (err == nil || !strings.Contains(err.Error(), test.diagnosis)) {
+				t.Fatal("unexpected diagnosis", err)
+			}
+
+			// the firewall of the mode is checked
+			if test.answer == "10.85.0.2" {
+				table := "filter"
+				if test.mode == "tproxy" {
+					table = "mangle"
+				}
+				if len(cmds) != 2 || cmds[1] != "iptables -t "+table+" -S KUNGFU" {
+					t.Fatal("unexpected commands", cmds)
+				}
+			}
+		})
+	}
+}
+
+// deadAddr return the udp address nothing listening
+func deadAddr(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yinheli/kungfu"
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	build string
+
+	c            = flag.String("c", "config.yml", "config file")
+	admin        = flag.String("admin", "", "admin api address of the running dns server, default admin.listen of config")
+	gatewayAdmin = flag.String("gateway-admin", "", "admin api address of the running gateway, default admin.gateway of config")
+	dnsServer    = flag.String("dns", "", "dns server address of doctor, default 127.0.0.1 and the port of dns.listen of config")
+	remote       = flag.String("remote", "", "admin api addresses of the remote dns servers, comma separated, e.g. https://router:9155,vps:9155, the admin commands run on each node")
+	token        = flag.String("token", "", "bearer token of the admin api, default admin.token of config")
+)
+
+const usage = `
+commands:
+  cache list                  list the domain -> fake ip mappings
+  cache lookup <ip>           get the domain of the fake ip
+  cache flush <domain>        flush the mapping of the domain
+  cache flush-all             flush all the mappings and caches
+  cache export [file]         export the mappings snapshot, csv if the file ends with .csv
+  cache import <file>         import the mappings snapshot, csv if the file ends with .csv
+  rules add <domain>...       add the domains to proxy domain set
+  rules remove <domain>...    remove the domains from proxy domain set
+  rules test <domain>         test whether the domain is proxied
+  rules dnsmasq <server> [ipset]
+                              export the proxy domains as dnsmasq config, server is ip or ip#port
+                              of the kungfu dns server, add the answers to the ipset if set
+  trace <domain> [type]       simulate the query of the dns server, print the decision path, the
+                              matched rules, the cache, the fake ip, the upstream and the outbound
+  stats top [kind] [n]        list the top n (default 20) domains of today, kind is proxied
+                              (default), blocked or bytes (relayed by the gateway)
+  stats hourly [range]        list the queries by outcome per hour, range is today (default) or
+                              the duration, e.g. 24h
+  check                       validate the config file (-c) and print the effective config
+  doctor [domain]             check the interception end to end with the proxied domain, default
+                              www.google.com, the dns answer, the route, the firewall and the
+                              relayed connection
+  conns                       list the connections relayed by the gateway
+  conns kill <id>             kill the connection of the gateway
+
+the cache, rules, trace, stats and conns commands run on each node of -remote by the admin api
+of the dns servers (the conns by the proxy to the gateway), except cache export and import of
+one node only and rules dnsmasq, the token and the certificate of admin of config are used
+`
+
+func main() {
+	u := flag.Usage
+	flag.Usage = func() {
+		fmt.Printf("\n%s cli %v\n", kungfu.Name, getVersion())
+		fmt.Print("  maintained by yinheli<hi@yinheli.com>\n")
+		fmt.Printf("\nusage: %s [options] <command> [args]\n", os.Args[0])
+		u()
+		fmt.Print(usage)
+	}
+
+	flag.Var(&internal.ConfigOverrides, "set", "override the config value, key=value, e.g. redis.addr=127.0.0.1:6379, repeatable, over the KUNGFU_* env vars")
+	flag.Parse()
+
+	args := flag.Args()
+	// check, doctor and conns list without the sub command
+	if len(args) == 0 || (len(args) < 2 && args[0] != "conns" && args[0] != "check" && args[0] != "doctor") {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "check":
+		err = checkConfig(args[1:])
+	case "doctor":
+		err = doctor(args[1:])
+	case "conns":
+		err = connsCommand(args[1:])
+	case "cache":
+		err = cacheCommand(args[1], args[2:])
+	case "rules":
+		err = rulesCommand(args[1], args[2:])
+	case "trace":
+		err = traceCommand(args[1:])
+	case "stats":
+		err = statsCommand(args[1], args[2:])
+	default:
+		err = fmt.Errorf("unknown command %s", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// checkConfig validate the config file and print the effective config with the defaults filled,
+// the passwords are masked
+func checkConfig(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("invalid check command, %s", strings.Join(args, " "))
+	}
+
+	config, err := internal.ParseConfig(*c)
+	if err != nil {
+		return err
+	}
+	config.Normalize()
+
+	data, err := yaml.Marshal(config.Redacted())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "config %s is valid\n", *c)
+	os.Stdout.Write(data)
+	return nil
+}
+
+func cacheCommand(cmd string, args []string) error {
+	switch {
+	case cmd == "list" && len(args) == 0:
+		return adminRequest(http.MethodGet, "/api/mappings", nil)
+	case cmd == "lookup" && len(args) == 1:
+		return adminRequest(http.MethodGet, "/api/ip/"+args[0], nil)
+	case cmd == "flush" && len(args) == 1:
+		return adminRequest(http.MethodDelete, "/api/domain/"+args[0], nil)
+	case cmd == "flush-all" && len(args) == 0:
+		return adminRequest(http.MethodDelete, "/api/cache", nil)
+	case cmd == "export" && len(args) == 0:
+		return adminRequest(http.MethodGet, "/api/mappings", nil)
+	case cmd == "export" && len(args) == 1:
+		return exportMappings(args[0])
+	case cmd == "import" && len(args) == 1:
+		return importMappings(args[0])
+	}
+	return fmt.Errorf("invalid cache command, %s %s", cmd, strings.Join(args, " "))
+}
+
+// traceCommand trace the query of the domain by the admin api of the dns server
+func traceCommand(args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("invalid trace command, %s", strings.Join(args, " "))
+	}
+
+	query := url.Values{"domain": {args[0]}}
+	if len(args) == 2 {
+		query.Set("type", args[1])
+	}
+	return adminRequest(http.MethodGet, "/api/trace?"+query.Encode(), nil)
+}
+
+func statsCommand(cmd string, args []string) error {
+	switch {
+	case cmd == "top" && len(args) <= 2:
+		query := url.Values{}
+		if len(args) > 0 {
+			query.Set("kind", args[0])
+		}
+		if len(args) > 1 {
+			query.Set("n", args[1])
+		}
+		return adminRequest(http.MethodGet, "/api/stats/top?"+query.Encode(), nil)
+	case cmd == "hourly" && len(args) <= 1:
+		query := url.Values{}
+		if len(args) > 0 {
+			query.Set("range", args[0])
+		}
+		return adminRequest(http.MethodGet, "/api/stats/hourly?"+query.Encode(), nil)
+	}
+	return fmt.Errorf("invalid stats command, %s %s", cmd, strings.Join(args, " "))
+}
+
+// snapshotFormat return the snapshot format by the file extension
+func snapshotFormat(file string) string {
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+func exportMappings(file string) error {
+	data, err := adminCall(http.MethodGet, "/api/mappings?format="+snapshotFormat(file), nil)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("export mappings to %s\n", file)
+	return nil
+}
+
+func importMappings(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return adminRequest(http.MethodPost, "/api/mappings?format="+snapshotFormat(file), f)
+}
+
+func connsCommand(args []string) error {
+	switch {
+	case len(args) == 0:
+		return gatewayRequest(http.MethodGet, "/api/conns")
+	case args[0] == "kill" && len(args) == 2:
+		return gatewayRequest(http.MethodDelete, "/api/conns/"+args[1])
+	}
+	return fmt.Errorf("invalid conns command, %s", strings.Join(args, " "))
+}
+
+func rulesCommand(cmd string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("invalid rules command, %s", cmd)
+	}
+	if len(remoteNodes()) > 0 {
+		return remoteRulesCommand(cmd, args)
+	}
+
+	store, err := newStore()
+	if err != nil {
+		return err
+	}
+
+	key := internal.GetRedisProxyDomainSetKey()
+	switch cmd {
+	case "add":
+		if err = store.SAdd(key, args...); err != nil {
+			return err
+		}
+	case "remove":
+		if err = store.SRem(key, args...); err != nil {
+			return err
+		}
+	case "test":
+		return testRule(store, args[0])
+	case "dnsmasq":
+		if len(args) > 2 {
+			return fmt.Errorf("invalid rules command, %s %s", cmd, strings.Join(args, " "))
+		}
+		ipset := ""
+		if len(args) == 2 {
+			ipset = args[1]
+		}
+		return exportDnsmasq(store, args[0], ipset)
+	default:
+		return fmt.Errorf("invalid rules command, %s", cmd)
+	}
+
+	// notify the dns server reload the rules
+	if err = store.Publish(internal.GetRedisProxyDomainChannelKey(), cmd); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s\n", cmd, strings.Join(args, ", "))
+	return nil
+}
+
+// testRule match the domain with the same rules as the dns server
+func testRule(store internal.Store, domain string) error {
+	domains, err := store.SMembers(internal.GetRedisProxyDomainSetKey())
+	if err != nil {
+		return err
+	}
+
+	rules, err := store.SMembers(internal.GetRedisProxyRuleSetKey())
+	if err != nil {
+		return err
+	}
+
+	matcher, err := gfwlist.NewMatcher(append(domains, rules...))
+	if err != nil {
+		return err
+	}
+
+	if matcher.Match(domain) {
+		fmt.Printf("%s: proxy\n", domain)
+	} else {
+		fmt.Printf("%s: direct\n", domain)
+	}
+	return nil
+}
+
+// exportDnsmasq print the dnsmasq config of the proxy domains and rules
+func exportDnsmasq(store internal.Store, server string, ipset string) error {
+	domains, err := store.SMembers(internal.GetRedisProxyDomainSetKey())
+	if err != nil {
+		return err
+	}
+
+	rules, err := store.SMembers(internal.GetRedisProxyRuleSetKey())
+	if err != nil {
+		return err
+	}
+
+	skipped, err := gfwlist.Dnsmasq(os.Stdout, domains, rules, server, ipset)
+	if err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skip %d keyword, regex and wildcard rules not supported by dnsmasq\n", skipped)
+	}
+	return nil
+}
+
+func newStore() (internal.Store, error) {
+	config, err := internal.ParseConfig(*c)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Store == "memory" {
+		return nil, fmt.Errorf("rules commands require redis store, memory store is local to the dns server")
+	}
+	return internal.NewStore(config), nil
+}
+
+// adminRequest call the admin api of the running dns server and print the response
+func adminRequest(method string, path string, body io.Reader) error {
+	if nodes := remoteNodes(); len(nodes) > 1 {
+		return remoteRequest(nodes, method, path, body)
+	}
+
+	data, err := adminCall(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(data))
+	return nil
+}
+
+// gatewayRequest call the admin api of the running gateway and print the response
+func gatewayRequest(method string, path string) error {
+	if len(remoteNodes()) > 0 {
+		// the remote gateway is reached by the proxy of the admin api of the dns server
+		return adminRequest(method, "/api/gateway"+path, nil)
+	}
+
+	conf, err := adminConfig(*gatewayAdmin)
+	if err != nil {
+		return err
+	}
+	addr := *gatewayAdmin
+	if addr == "" {
+		addr = conf.Gateway
+	}
+
+	data, err := call(conf, addr, method, path, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(data))
+	return nil
+}
+
+// adminCall call the admin api of the running dns server and return the response body
+func adminCall(method string, path string, body io.Reader) ([]byte, error) {
+	addr := *admin
+	if nodes := remoteNodes(); len(nodes) > 1 {
+		return nil, fmt.Errorf("the command runs on one node, but %d remote nodes given", len(nodes))
+	} else if len(nodes) == 1 {
+		addr = nodes[0]
+	}
+
+	conf, err := adminConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	if addr == "" {
+		addr = conf.Listen
+	}
+	return call(conf, addr, method, path, body)
+}
+
+// adminConfig return the admin config of the config file for the addresses, the auth and the
+// tls, the config file is optional if the address is given by the flag, the token of the flag
+// takes precedence
+func adminConfig(addr string) (internal.Admin, error) {
+	var conf internal.Admin
+	config, err := internal.ParseConfig(*c)
+	if err == nil {
+		conf = config.Admin
+	} else if addr == "" {
+		return conf, err
+	}
+
+	if *token != "" {
+		conf.Token = *token
+	}
+	return conf, nil
+}
+
+// call the admin api of the addr with the auth of the config and return the response body
+func call(conf internal.Admin, addr string, method string, path string, body io.Reader) ([]byte, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("admin api address is not configured")
+	}
+
+	req, err := http.NewRequest(method, conf.URL(addr)+path, body)
+	if err != nil {
+		return nil, err
+	}
+	conf.Authorize(req)
+
+	transport, err := conf.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Duration(time.Second * 10), Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct{ Error string }
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s", e.Error)
+		}
+		return nil, fmt.Errorf("admin api status %d", resp.StatusCode)
+	}
+
+	return data, nil
+}
+
+func getVersion() string {
+	if build == "" {
+		return fmt.Sprintf("%s", kungfu.Version)
+	}
+	return fmt.Sprintf("%s build: %s", kungfu.Version, build)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// remoteNodes return the admin api addresses of the remote nodes of the -remote flag, empty for
+// the local dns server
+func remoteNodes() []string {
+	var nodes []string
+	for _, node := range strings.Split(*remote, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// remoteRequest call the admin api of each remote node and print the responses headed by the
+// node, the nodes failed are skipped and reported at last
+func remoteRequest(nodes []string, method string, path string, body io.Reader) error {
+	conf, err := adminConfig(*remote)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if body != nil {
+		if data, err = ioutil.ReadAll(body); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, node := range nodes {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(data)
+		}
+
+		fmt.Printf("==> %s <==\n", node)
+		resp, err := call(conf, node, method, path, r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s, %v\n", node, err)
+			failed++
+			continue
+		}
+		fmt.Print(string(resp))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d nodes failed", failed, len(nodes))
+	}
+	return nil
+}
+
+// remoteRulesCommand manage the proxy domains of the remote nodes by the admin api, instead of
+// the redis of the local config
+func remoteRulesCommand(cmd string, args []string) error {
+	query := url.Values{"domain": args}
+	switch {
+	case cmd == "add":
+		return adminRequest(http.MethodPut, "/api/rules/domains?"+query.Encode(), nil)
+	case cmd == "remove":
+		return adminRequest(http.MethodDelete, "/api/rules/domains?"+query.Encode(), nil)
+	case cmd == "test" && len(args) == 1:
+		return adminRequest(http.MethodGet, "/api/rules/test?"+query.Encode(), nil)
+	case cmd == "dnsmasq":
+		return fmt.Errorf("rules dnsmasq reads the redis of the config, not supported on the remote nodes")
+	}
+	return fmt.Errorf("invalid rules command, %s %s", cmd, strings.Join(args, " "))
+}
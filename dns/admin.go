@@ -0,0 +1,422 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// mapping is the domain -> fake ip mapping
+type mapping struct {
+	Domain string `json:"domain"`
+	IP     string `json:"ip"`
+	TTL    int64  `json:"ttl"`
+}
+
+// adminHandler is the http api for runtime inspection and control
+//
+//	GET    /                      the dashboard
+//	GET    /healthz               the process is alive, for the liveness probe
+//	GET    /readyz                the store is reachable, an upstream is healthy and the rules
+//	                              are loaded, 503 if not, for the readiness probe
+//	GET    /api/stats             get the qps, the queries by outcome, the top domains, the pool
+//	                              utilization and the rule counts
+//	GET    /api/stats/top?kind=&range=&n= get the top domains of the kind (proxied, blocked or
+//	                              bytes) in the range (today or the duration, e.g. 24h)
+//	GET    /api/stats/hourly?range= get the queries by outcome per hour in the range
+//	GET    /api/mappings          list the domain -> fake ip mappings, ?format=csv for csv
+//	POST   /api/mappings          import the mappings snapshot (json or csv)
+//	GET    /api/ip/<ip>           get the domain of the fake ip
+//	DELETE /api/domain/<domain>   flush the mapping of the domain
+//	DELETE /api/cache             flush all the mappings and caches
+//	GET    /api/rules             get the proxy rule count
+//	GET    /api/rules/domains     list the domains of the proxy domain set
+//	PUT    /api/rules/domains?domain= add the domains to the proxy domain set, repeatable
+//	DELETE /api/rules/domains?domain= remove the domains from the proxy domain set, repeatable
+//	GET    /api/rules/test?domain= test whether the domain is rejected, proxied or direct
+//	GET    /api/trace?domain=&type=&client= simulate the query of the client, get the decision
+//	                              path, the matched rules, the cache, the fake ip, the upstream
+//	                              and the outbound
+//	GET    /api/debug             get the debug log status
+//	PUT    /api/debug?enable=     toggle debug log
+//	GET    /api/log               get the log level and the level of the modules
+//	PUT    /api/log?level=&module= set the log level, of the module if given, empty level to
+//	                              remove the module level
+//	GET    /events?types=         websocket streaming the events as json, query and rule, all
+//	                              types if empty
+//	*      /api/gateway/<path>    proxy to the admin api of the gateway, e.g. /api/gateway/api/conns
+//	GET    /debug/<path>          the pprof, expvar and dump endpoints guarded by the debug token,
+//	                              see internal.DebugHandler
+type adminHandler struct {
+	server *Server
+}
+
+func newAdminHandler(server *Server) http.Handler {
+	a := &adminHandler{server: server}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/mappings", a.mappings)
+	mux.HandleFunc("/api/ip/", a.ip)
+	mux.HandleFunc("/api/domain/", a.domain)
+	mux.HandleFunc("/api/cache", a.cache)
+	mux.HandleFunc("/api/rules", a.rules)
+	mux.HandleFunc("/api/debug", a.debug)
+	mux.HandleFunc("/api/log", a.logLevel)
+	mux.HandleFunc("/api/stats", a.stats)
+	mux.HandleFunc("/api/stats/top", a.statsTop)
+	mux.HandleFunc("/api/stats/hourly", a.statsHourly)
+	mux.HandleFunc("/api/rules/test", a.ruleTest)
+	mux.HandleFunc("/api/rules/domains", a.ruleDomains)
+	mux.HandleFunc("/api/trace", a.trace)
+	mux.HandleFunc("/api/gateway/", a.gateway)
+	mux.HandleFunc("/events", a.events)
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.HandleFunc("/readyz", a.readyz)
+	mux.Handle("/debug/", internal.DebugHandler(server.debugToken(), nil))
+	mux.HandleFunc("/", a.dashboard)
+	return mux
+}
+
+// serveAdmin start the admin http server if configured
+func (server *Server) serveAdmin() {
+	if server.Config == nil || server.Config.Admin.Listen == "" {
+		return
+	}
+
+	admin := server.Config.Admin
+	srv := &http.Server{
+		Addr:    admin.Listen,
+		Handler: admin.Protect(newAdminHandler(server)),
+	}
+	server.serveHTTP("admin", srv, admin.Cert, admin.Key)
+}
+
+// debugToken return the token of the debug endpoints, empty if disabled
+func (server *Server) debugToken() string {
+	if server.Config == nil {
+		return ""
+	}
+	return server.Config.Admin.DebugToken
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	return true
+}
+
+// domainKeys return the domain mapping keys of both ipv4 and ipv6
+func (server *Server) domainKeys() ([]string, error) {
+	keys, err := server.Store.Keys(internal.GetRedisDomainKey("*"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys6, err := server.Store.Keys(internal.GetRedisDomain6Key("*"))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(keys, keys6...), nil
+}
+
+// domainOfKey return the domain of the mapping key
+func domainOfKey(key string) string {
+	prefix := internal.GetRedisDomainKey("")
+	if strings.HasPrefix(key, internal.GetRedisDomain6Key("")) {
+		prefix = internal.GetRedisDomain6Key("")
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".")
+}
+
+func (a *adminHandler) mappings(w http.ResponseWriter, r *http.Request) {
+	format := snapshotFormat(r.URL.Query().Get("format"), r.Header.Get("Content-Type"))
+
+	switch r.Method {
+	case http.MethodGet:
+		mappings, err := a.server.listMappings()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if format == snapshotCSV {
+			w.Header().Set("Content-Type", "text/csv")
+			encodeMappings(w, format, mappings)
+			return
+		}
+		writeJSON(w, http.StatusOK, mappings)
+	case http.MethodPost:
+		a.importMappings(w, r, format)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// importMappings restore the mappings from the json or csv snapshot
+func (a *adminHandler) importMappings(w http.ResponseWriter, r *http.Request, format string) {
+	mappings, err := decodeMappings(r.Body, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	imported, skipped := 0, 0
+	for _, m := range mappings {
+		ok, err := a.server.restoreMapping(m)
+		if err != nil {
+			log.Warning("import mapping %s -> %s error, %v", m.Domain, m.IP, err)
+		}
+
+		if ok {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+
+	log.Info("import %d mappings, skipped %d by admin api", imported, skipped)
+	writeJSON(w, http.StatusOK, map[string]int{"imported": imported, "skipped": skipped})
+}
+
+func (a *adminHandler) ip(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	ip := net.ParseIP(strings.TrimPrefix(r.URL.Path, "/api/ip/"))
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "invalid ip")
+		return
+	}
+
+	domain, err := a.server.Store.Get(internal.GetRedisIpKey(ip.String()))
+	if err == internal.ErrNil {
+		writeError(w, http.StatusNotFound, "ip is not allocated")
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"ip": ip.String(), "domain": domain})
+}
+
+func (a *adminHandler) domain(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	domain := strings.TrimPrefix(r.URL.Path, "/api/domain/")
+	if domain == "" {
+		writeError(w, http.StatusBadRequest, "empty domain")
+		return
+	}
+
+	qname := dns.Fqdn(strings.ToLower(domain))
+	keys := []string{getDomainKey(dns.TypeA, qname), getDomainKey(dns.TypeAAAA, qname)}
+	for _, key := range keys {
+		if err := a.server.flushMapping(key); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	log.Info("flush domain %s by admin api", qname)
+	writeJSON(w, http.StatusOK, map[string]string{"domain": strings.TrimSuffix(qname, ".")})
+}
+
+func (a *adminHandler) cache(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	keys, err := a.server.domainKeys()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, key := range keys {
+		if err := a.server.flushMapping(key); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	a.server.handler.cache.flush()
+	a.server.handler.negative.flush()
+	a.server.handler.answers.flush()
+	a.server.Store.Publish(internal.GetRedisCacheChannelKey(), cacheFlushAll)
+
+	log.Info("flush all %d mappings by admin api", len(keys))
+	writeJSON(w, http.StatusOK, map[string]int{"flushed": len(keys)})
+}
+
+func (a *adminHandler) rules(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"rules": a.server.getRules().Len()})
+}
+
+// ruleDomains list, add or remove the domains of the proxy domain set, the dns servers reload
+// the rules on change, so the rules of the remote nodes are managed by the admin api
+func (a *adminHandler) ruleDomains(w http.ResponseWriter, r *http.Request) {
+	store := a.server.Store
+	key := internal.GetRedisProxyDomainSetKey()
+
+	var domains []string
+	for _, domain := range r.URL.Query()["domain"] {
+		if domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), ".")); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+
+	var err error
+	var cmd string
+	switch r.Method {
+	case http.MethodGet:
+		list, err := store.SMembers(key)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sort.Strings(list)
+		writeJSON(w, http.StatusOK, list)
+		return
+	case http.MethodPut:
+		cmd = "add"
+		if len(domains) > 0 {
+			err = store.SAdd(key, domains...)
+		}
+	case http.MethodDelete:
+		cmd = "remove"
+		if len(domains) > 0 {
+			err = store.SRem(key, domains...)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if len(domains) == 0 {
+		writeError(w, http.StatusBadRequest, "empty domain")
+		return
+	}
+	if err == nil {
+		err = store.Publish(internal.GetRedisProxyDomainChannelKey(), cmd)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Info("%s proxy domains %s by admin api", cmd, strings.Join(domains, ", "))
+	writeJSON(w, http.StatusOK, map[string][]string{cmd: domains})
+}
+
+func (a *adminHandler) debug(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		switch r.URL.Query().Get("enable") {
+		case "true":
+			kungfu.SetLogLevelDebug()
+		case "false":
+			kungfu.SetLogLevelInfo()
+		default:
+			writeError(w, http.StatusBadRequest, "enable should be true or false")
+			return
+		}
+		log.Info("debug log enabled: %v by admin api", kungfu.IsLogLevelDebug())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"debug": kungfu.IsLogLevelDebug()})
+}
+
+func (a *adminHandler) logLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		module := r.URL.Query().Get("module")
+		level := r.URL.Query().Get("level")
+		if err := kungfu.SetLogLevel(module, level); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Info("log level of module %q set to %q by admin api", module, level)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"level":   kungfu.GetLogLevel(""),
+		"modules": kungfu.GetModuleLogLevels(),
+	})
+}
+
+// flushMapping remove the domain mapping and release the fake ip, the domain is re-resolved on next query
+func (server *Server) flushMapping(key string) error {
+	ip, err := server.Store.Get(key)
+	if err == internal.ErrNil {
+		server.handler.cache.remove(key)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = server.Store.Del(key); err != nil {
+		return err
+	}
+	server.handler.cache.remove(key)
+	server.Store.Publish(internal.GetRedisCacheChannelKey(), key)
+
+	qtype := dns.TypeA
+	if strings.HasPrefix(key, internal.GetRedisDomain6Key("")) {
+		qtype = dns.TypeAAAA
+	}
+	server.getPool(qtype).release(ip)
+	return nil
+}
+
+// cacheFlushAll is the cache channel message of flushing all the mappings
+const cacheFlushAll = "*"
+
+// invalidateCache drop the local cache of the mapping flushed by other instance
+func (server *Server) invalidateCache(key string) {
+	if key == cacheFlushAll {
+		server.handler.cache.flush()
+		server.handler.negative.flush()
+		server.handler.answers.flush()
+		return
+	}
+	server.handler.cache.remove(key)
+}
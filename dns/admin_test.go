@@ -0,0 +1,288 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestAdminHandler(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	server := &Server{
+		Store: store,
+		pool:  newAllocator(store, "current-ip"),
+		pool6: newAllocator(store, "current-ip6"),
+	}
+	server.pool.reset(net.ParseIP("10.85.0.2").To4(), 10)
+	server.handler = &handler{
+		server:   server,
+		cache:    newDomainCache(domainCacheSize, domainCacheTTL, 0),
+		negative: newNegativeCache(0),
+	}
+
+	ip, err := server.pool.allocate(testDomainKey("google.com"), "google.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := getDomainKey(dns.TypeA, "google.com.")
+	store.Set(key, ip.String(), time.Hour)
+
+	admin := newAdminHandler(server)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := do(http.MethodGet, "/api/mappings")
+	var mappings []mapping
+	if err := json.Unmarshal(w.Body.Bytes(), &mappings); err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 1 || mappings[0].Domain != "google.com" || mappings[0].IP != ip.String() {
+		t.Fatalf("unexpected mappings %s", w.Body.String())
+	}
+
+	w = do(http.MethodGet, "/api/ip/"+ip.String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("ip lookup status %d, %s", w.Code, w.Body.String())
+	}
+
+	if w = do(http.MethodDelete, "/api/domain/google.com"); w.Code != http.StatusOK {
+		t.Fatalf("flush domain status %d, %s", w.Code, w.Body.String())
+	}
+
+	if _, err := store.Get(key); err != internal.ErrNil {
+		t.Fatal("domain mapping should be removed")
+	}
+
+	if w = do(http.MethodGet, "/api/ip/"+ip.String()); w.Code != http.StatusNotFound {
+		t.Fatalf("released ip lookup status %d", w.Code)
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	admin := newAdminHandler(newSnapshotTestServer())
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	defer kungfu.SetLogLevel("dns", "")
+	if w := do(http.MethodPut, "/api/log?module=dns&level=warning"); w.Code != http.StatusOK {
+		t.Fatalf("set module log level status %d, %s", w.Code, w.Body.String())
+	}
+
+	if level := kungfu.GetLogLevel("dns"); level != kungfu.LevelWarning {
+		t.Fatal("dns log level should be warning, got", level)
+	}
+
+	if w := do(http.MethodPut, "/api/log?level=verbose"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid log level status %d", w.Code)
+	}
+}
+
+func TestAdminReadyz(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	server := &Server{Store: store}
+	admin := newAdminHandler(server)
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := do("/healthz"); w.Code != http.StatusOK {
+		t.Fatalf("healthz status %d", w.Code)
+	}
+
+	if w := do("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("not started server should not be ready, %s", w.Body.String())
+	}
+
+	server.handler = &handler{server: server, nameserver: parseUpstreams("119.29.29.29", time.Second)}
+	server.loadRules()
+	if w := do("/readyz"); w.Code != http.StatusOK {
+		t.Fatalf("readyz status %d, %s", w.Code, w.Body.String())
+	}
+
+	// all the upstreams are down
+	server.handler.nameserver[0].downUntil = time.Now().Add(time.Minute).UnixNano()
+	w := do("/readyz")
+	var result struct {
+		Checks map[string]string
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusServiceUnavailable || result.Checks["upstream"] == probeOK {
+		t.Fatalf("unhealthy upstreams should not be ready, %s", w.Body.String())
+	}
+}
+
+func TestAdminTrace(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	store.SAdd(internal.GetRedisProxyDomainSetKey(), "google.com")
+	store.SAdd(internal.GetRedisRejectKey("ads"), "ads.example.com")
+	store.Set(internal.GetRedisOutboundKey("stream"), "socks5://127.0.0.1:1080", 0)
+	store.SAdd(internal.GetRedisOutboundRuleKey("stream"), "keyword:video")
+
+	server := &Server{
+		Store: store,
+		pool:  newAllocator(store, "current-ip"),
+		pool6: newAllocator(store, "current-ip6"),
+	}
+	server.pool.reset(net.ParseIP("10.85.0.2").To4(), 10)
+	server.handler = &handler{
+		server:   server,
+		cache:    newDomainCache(domainCacheSize, domainCacheTTL, 0),
+		negative: newNegativeCache(0),
+	}
+	server.loadRules()
+
+	admin := newAdminHandler(server)
+	trace := func(path string) *queryTrace {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("trace %s status %d, %s", path, w.Code, w.Body.String())
+		}
+		result := new(queryTrace)
+		if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	step := func(result *queryTrace, stage string) traceStep {
+		for _, s := range result.Steps {
+			if s.Stage == stage {
+				return s
+			}
+		}
+		t.Fatalf("no %s step in %+v", stage, result.Steps)
+		return traceStep{}
+	}
+
+	if result := trace("/api/trace?domain=x.ads.example.com"); result.Decision != "reject" {
+		t.Fatalf("unexpected decision %+v", result)
+	}
+
+	result := trace("/api/trace?domain=video.google.com")
+	if result.Decision != "internal" || step(result, "rule").Detail != "rule google.com" {
+		t.Fatalf("unexpected decision %+v", result)
+	}
+	if s := step(result, "ip"); s.Result != "allocate" {
+		t.Fatalf("the ip should be allocated on the query, %+v", s)
+	}
+	if s := step(result, "outbound"); s.Result != "stream" {
+		t.Fatalf("unexpected outbound %+v", s)
+	}
+	if _, err := store.Get(getDomainKey(dns.TypeA, "video.google.com.")); err != internal.ErrNil {
+		t.Fatal("trace should not allocate the fake ip")
+	}
+
+	ip, err := server.pool.allocate(testDomainKey("www.google.com"), "www.google.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set(getDomainKey(dns.TypeA, "www.google.com."), ip.String(), time.Hour)
+	result = trace("/api/trace?domain=www.google.com&type=a")
+	if s := step(result, "ip"); s.Result != ip.String() || step(result, "outbound").Result != internal.OutboundDefault {
+		t.Fatalf("unexpected mapping %+v", result)
+	}
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/trace?domain=google.com&type=BAD", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid type status %d", w.Code)
+	}
+}
+
+func TestAdminStatsReports(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	server := &Server{Store: store, hourly: internal.NewHourlyStats(0)}
+
+	now := time.Now()
+	server.recordHourly("www.google.com.", "internal", now)
+	server.recordHourly("WWW.Google.com.", "internal", now)
+	server.recordHourly("ads.example.com.", "reject", now)
+	server.recordHourly("www.example.com.", "upstream", now)
+	server.flushHourly()
+
+	admin := newAdminHandler(server)
+	get := func(path string, result interface{}) int {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code
+	}
+
+	var top []internal.StatsCount
+	if code := get("/api/stats/top?range=1h", &top); code != http.StatusOK {
+		t.Fatalf("stats top status %d", code)
+	}
+	if len(top) != 1 || top[0] != (internal.StatsCount{Name: "www.google.com", Count: 2}) {
+		t.Fatal("unexpected top proxied domains", top)
+	}
+
+	if get("/api/stats/top?kind=blocked&range=1h&n=5", &top); len(top) != 1 || top[0].Name != "ads.example.com" {
+		t.Fatal("unexpected top blocked domains", top)
+	}
+
+	var hourly []hourlyQueries
+	if code := get("/api/stats/hourly?range=1h", &hourly); code != http.StatusOK {
+		t.Fatalf("stats hourly status %d", code)
+	}
+	var queries int64
+	for _, h := range hourly {
+		queries += h.Queries
+	}
+	if queries != 4 {
+		t.Fatal("unexpected hourly queries", hourly)
+	}
+
+	for _, path := range []string{"/api/stats/top?kind=x", "/api/stats/top?range=yesterday", "/api/stats/top?n=0"} {
+		if code := get(path, &top); code != http.StatusBadRequest {
+			t.Fatalf("%s should be bad request, got %d", path, code)
+		}
+	}
+}
+
+func TestAdminRuleDomains(t *testing.T) {
+	server := newSnapshotTestServer()
+	admin := newAdminHandler(server)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := do(http.MethodPut, "/api/rules/domains?domain=Google.com.&domain=youtube.com"); w.Code != http.StatusOK {
+		t.Fatalf("add domains status %d, %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodDelete, "/api/rules/domains?domain=youtube.com"); w.Code != http.StatusOK {
+		t.Fatalf("remove domains status %d, %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/api/rules/domains"); w.Code != http.StatusBadRequest {
+		t.Fatal("add without domain should be bad request", w.Code)
+	}
+
+	w := do(http.MethodGet, "/api/rules/domains")
+	var domains []string
+	if err := json.Unmarshal(w.Body.Bytes(), &domains); err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 1 || domains[0] != "google.com" {
+		t.Fatalf("unexpected domains %s", w.Body.String())
+	}
+}
@@ -0,0 +1,343 @@
+package dns
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// allocatorMaxProbe max candidates to try before report the pool is exhausted
+	allocatorMaxProbe = 64
+	// allocatorWarnUtilization log warning when the pool utilization is higher than this
+	allocatorWarnUtilization = 0.9
+)
+
+var errPoolNotConfigured = errors.New("ip pool not configured")
+
+// allocator allocate fake ip from the pool, ip is reused after it's mapping expired or released
+type allocator struct {
+	store  internal.Store
+	cursor string
+
+	lock  sync.Mutex
+	first net.IP
+	size  uint64
+	// used is the ip known in use, ip -> expire time of it's mapping
+	used map[string]*usedIP
+	// expiry is the used ip ordered by the expire time, only the head is collected
+	expiry expiryHeap
+	// free is the released or expired ip, ready for reuse
+	free []string
+	// reserved is the ip never allocated, e.g. the relay ip
+	reserved map[string]bool
+	// sticky derive the ip from the hash of domain first, stable across restarts and instances
+	sticky bool
+	warned bool
+}
+
+func newAllocator(store internal.Store, cursor string) *allocator {
+	return &allocator{
+		store:  store,
+		cursor: internal.GetRedisKey(cursor),
+		used:   make(map[string]*usedIP),
+	}
+}
+
+// reset the pool, first is the first ip can be allocated, the reserved ips in the pool are skipped
+func (a *allocator) reset(first net.IP, size uint64, reserved ...net.IP) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.first = first
+	a.size = size
+	a.used = make(map[string]*usedIP)
+	a.expiry = nil
+	a.free = nil
+	a.reserved = make(map[string]bool, len(reserved))
+	for _, ip := range reserved {
+		a.reserved[ip.String()] = true
+	}
+}
+
+// setSticky enable or disable the domain hash based allocation
+func (a *allocator) setSticky(sticky bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.sticky = sticky
+}
+
+func (a *allocator) configured() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.size > 0
+}
+
+// allocate ip for domain, the domain key and the reverse mapping (ip -> domain) are saved
+// atomically with ttl, the ip already mapped is returned if the domain is mapped by other
+// instance sharing the store
+func (a *allocator) allocate(domainKey string, domain string, ttl time.Duration) (net.IP, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.size == 0 {
+		return nil, errPoolNotConfigured
+	}
+
+	now := time.Now()
+	a.collect(now)
+
+	if a.sticky {
+		h := fnv.New64a()
+		h.Write([]byte(domain))
+		ip := internal.AddToIp(a.first, h.Sum64()%a.size).String()
+		if !a.reserved[ip] {
+			if mapped, err := a.claim(ip, domainKey, domain, ttl, now); err != nil || mapped != nil {
+				return mapped, err
+			}
+		}
+	}
+
+	for len(a.free) > 0 {
+		n := len(a.free) - 1
+		ip := a.free[n]
+		a.free = a.free[:n]
+
+		if mapped, err := a.claim(ip, domainKey, domain, ttl, now); err != nil || mapped != nil {
+			return mapped, err
+		}
+	}
+
+	if uint64(len(a.used)+len(a.reserved)) >= a.size {
+		return nil, fmt.Errorf("ip pool exhausted, used %d of %d", len(a.used), a.size)
+	}
+
+	for i := 0; i < allocatorMaxProbe; i++ {
+		// the cursor is only a hint of the next free ip, it's safe to skip some
+		n, err := a.store.Incr(a.cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		ip := internal.AddToIp(a.first, uint64(n-1)%a.size).String()
+		if _, ok := a.used[ip]; ok || a.reserved[ip] {
+			continue
+		}
+
+		if mapped, err := a.claim(ip, domainKey, domain, ttl, now); err != nil || mapped != nil {
+			return mapped, err
+		}
+	}
+
+	return nil, fmt.Errorf("ip pool exhausted, no available ip after %d probes, used %d of %d",
+		allocatorMaxProbe, len(a.used), a.size)
+}
+
+// claim map the domain to ip if the ip is not used by other domain, return nil if the ip is used,
+// or the ip already mapped to the domain
+func (a *allocator) claim(ip string, domainKey string, domain string, ttl time.Duration, now time.Time) (net.IP, error) {
+	ipKey := internal.GetRedisIpKey(ip)
+	mapped, err := a.store.MapDomain(domainKey, ipKey, domain, ip, ttl)
+	if err == nil {
+		if mapped == ip {
+			a.track(ip, now.Add(ttl))
+			a.checkUtilization()
+		} else {
+			// the domain is mapped to other ip, the ip is still free
+			a.free = append(a.free, ip)
+		}
+		return net.ParseIP(mapped), nil
+	}
+
+	if err != internal.ErrNil {
+		return nil, err
+	}
+
+	// still used, maybe allocated by other instance, track it until expire
+	remain, err := a.store.TTL(ipKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if remain > 0 {
+		a.track(ip, now.Add(remain))
+	}
+	return nil, nil
+}
+
+// adopt map the domain to the given ip, used to restore the mapping from snapshot, report false
+// if the ip is used by other domain or the domain is mapped to other ip
+func (a *allocator) adopt(ip net.IP, domainKey string, domain string, ttl time.Duration) (bool, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.size == 0 {
+		return false, errPoolNotConfigured
+	}
+
+	if !a.contains(ip) {
+		return false, fmt.Errorf("ip %s is out of the pool", ip)
+	}
+
+	s := ip.String()
+	if a.reserved[s] {
+		return false, fmt.Errorf("ip %s is reserved", s)
+	}
+
+	mapped, err := a.claim(s, domainKey, domain, ttl, time.Now())
+	if err != nil || !mapped.Equal(ip) {
+		return false, err
+	}
+
+	// already restored, refresh the ttl
+	a.store.Expire(domainKey, ttl)
+	a.store.Expire(internal.GetRedisIpKey(s), ttl)
+	return true, nil
+}
+
+// owns report whether the ip is in the pool, the nil or not configured pool owns nothing
+func (a *allocator) owns(ip net.IP) bool {
+	if a == nil {
+		return false
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.size > 0 && a.contains(ip)
+}
+
+// contains report whether the ip is in the pool, the lock should be held
+func (a *allocator) contains(ip net.IP) bool {
+	if len(a.first) == net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+
+	if ip == nil {
+		return false
+	}
+
+	// the pool size is at most 2^64, only the low 8 bytes may differ
+	n := len(ip) - 8
+	if n < 0 {
+		n = 0
+	}
+
+	for i := 0; i < n; i++ {
+		if ip[i] != a.first[i] {
+			return false
+		}
+	}
+
+	var v, first uint64
+	for i := n; i < len(ip); i++ {
+		v = v<<8 | uint64(ip[i])
+		first = first<<8 | uint64(a.first[i])
+	}
+	return v >= first && v-first < a.size
+}
+
+// touch extend the tracked expire time of ip
+func (a *allocator) touch(ip string, ttl time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if u, ok := a.used[ip]; ok {
+		u.expire = time.Now().Add(ttl)
+		heap.Fix(&a.expiry, u.index)
+	}
+}
+
+// release the ip and it's reverse mapping, the ip is ready for reuse
+func (a *allocator) release(ip string) {
+	a.store.Del(internal.GetRedisIpKey(ip))
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if u, ok := a.used[ip]; ok {
+		heap.Remove(&a.expiry, u.index)
+		delete(a.used, ip)
+		a.free = append(a.free, ip)
+	}
+}
+
+// track mark the ip used until expire, the lock should be held
+func (a *allocator) track(ip string, expire time.Time) {
+	if u, ok := a.used[ip]; ok {
+		u.expire = expire
+		heap.Fix(&a.expiry, u.index)
+		return
+	}
+
+	u := &usedIP{ip: ip, expire: expire}
+	a.used[ip] = u
+	heap.Push(&a.expiry, u)
+}
+
+// collect move the expired ip to free list, the earliest expired ip is at the head of expiry
+func (a *allocator) collect(now time.Time) {
+	for len(a.expiry) > 0 && now.After(a.expiry[0].expire) {
+		u := heap.Pop(&a.expiry).(*usedIP)
+		delete(a.used, u.ip)
+		a.free = append(a.free, u.ip)
+	}
+}
+
+func (a *allocator) checkUtilization() {
+	high := float64(len(a.used)) >= float64(a.size)*allocatorWarnUtilization
+	if high && !a.warned {
+		log.Warning("ip pool utilization is high, used %d of %d", len(a.used), a.size)
+	}
+	a.warned = high
+}
+
+// utilization return used count and the pool size
+func (a *allocator) utilization() (used uint64, size uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.collect(time.Now())
+	return uint64(len(a.used)), a.size
+}
+
+// usedIP is the ip in use, index is the position in the expiry heap
+type usedIP struct {
+	ip     string
+	expire time.Time
+	index  int
+}
+
+// expiryHeap is the min heap of used ip by the expire time
+type expiryHeap []*usedIP
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expire.Before(h[j].expire) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	u := x.(*usedIP)
+	u.index = len(*h)
+	*h = append(*h, u)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	u := old[n]
+	old[n] = nil
+	*h = old[:n]
+	return u
+}
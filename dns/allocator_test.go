@@ -0,0 +1,232 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func testDomainKey(domain string) string {
+	return getDomainKey(dns.TypeA, dns.Fqdn(domain))
+}
+
+func TestAllocator(t *testing.T) {
+	a := newAllocator(internal.NewMemoryStore(&internal.Memory{}), "current-ip")
+
+	if _, err := a.allocate(testDomainKey("a.com"), "a.com", time.Hour); err != errPoolNotConfigured {
+		t.Fatal("pool should not configured")
+	}
+
+	a.reset(net.ParseIP("10.85.0.2").To4(), 3)
+
+	seen := make(map[string]bool)
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		ip, err := a.allocate(testDomainKey(domain), domain, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seen[ip.String()] {
+			t.Fatalf("duplicate ip %s", ip)
+		}
+		seen[ip.String()] = true
+	}
+
+	if _, err := a.allocate(testDomainKey("d.com"), "d.com", time.Hour); err == nil {
+		t.Fatal("pool should be exhausted")
+	}
+
+	if used, size := a.utilization(); used != 3 || size != 3 {
+		t.Fatalf("utilization should be 3/3, got %d/%d", used, size)
+	}
+
+	a.release("10.85.0.3")
+
+	ip, err := a.allocate(testDomainKey("d.com"), "d.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip.String() != "10.85.0.3" {
+		t.Fatalf("released ip should be reused, got %s", ip)
+	}
+}
+
+func TestAllocatorSkipUsed(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	a := newAllocator(store, "current-ip")
+	a.reset(net.ParseIP("10.85.0.2").To4(), 2)
+
+	// allocated by other instance
+	store.SetNX(internal.GetRedisIpKey("10.85.0.2"), "a.com", time.Hour)
+
+	ip, err := a.allocate(testDomainKey("b.com"), "b.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip.String() != "10.85.0.3" {
+		t.Fatalf("used ip should be skipped, got %s", ip)
+	}
+}
+
+func TestAllocatorReserved(t *testing.T) {
+	a := newAllocator(internal.NewMemoryStore(&internal.Memory{}), "current-ip")
+	a.reset(net.ParseIP("10.85.0.1").To4(), 3, net.ParseIP("10.85.0.1"))
+
+	for _, domain := range []string{"a.com", "b.com"} {
+		ip, err := a.allocate(testDomainKey(domain), domain, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ip.String() == "10.85.0.1" {
+			t.Fatal("reserved ip should not be allocated")
+		}
+	}
+
+	if _, err := a.allocate(testDomainKey("c.com"), "c.com", time.Hour); err == nil {
+		t.Fatal("pool should be exhausted")
+	}
+}
+
+func TestAllocatorSticky(t *testing.T) {
+	first := net.ParseIP("10.85.0.2").To4()
+	allocate := func(domain string) net.IP {
+		a := newAllocator(internal.NewMemoryStore(&internal.Memory{}), "current-ip")
+		a.reset(first, 1000)
+		a.setSticky(true)
+		ip, err := a.allocate(testDomainKey(domain), domain, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ip
+	}
+
+	// same domain same ip across instances sharing no state
+	if a, b := allocate("google.com"), allocate("google.com"); !a.Equal(b) {
+		t.Fatalf("sticky ip should be stable, got %s and %s", a, b)
+	}
+
+	a := newAllocator(internal.NewMemoryStore(&internal.Memory{}), "current-ip")
+	a.reset(first, 2)
+	a.setSticky(true)
+
+	ip1, err := a.allocate(testDomainKey("a.com"), "a.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// collision in the pool of 2 fallback to the allocator
+	ip2, err := a.allocate(testDomainKey("b.com"), "b.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip1.Equal(ip2) {
+		t.Fatalf("collision should fallback, got the same ip %s", ip1)
+	}
+
+	// released and allocated again, the hashed ip is preferred
+	a.release(ip1.String())
+	a.store.Del(testDomainKey("a.com"))
+	ip, err := a.allocate(testDomainKey("a.com"), "a.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ip.Equal(ip1) {
+		t.Fatalf("sticky ip should be reused, expect %s, got %s", ip1, ip)
+	}
+}
+
+func TestAllocatorMapped(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	a := newAllocator(store, "current-ip")
+	a.reset(net.ParseIP("10.85.0.2").To4(), 10)
+	b := newAllocator(store, "current-ip")
+	b.reset(net.ParseIP("10.85.0.2").To4(), 10)
+
+	ip1, err := a.allocate(testDomainKey("a.com"), "a.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mapped by other instance, the same ip is answered
+	ip2, err := b.allocate(testDomainKey("a.com"), "a.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ip1.Equal(ip2) {
+		t.Fatalf("expect the mapped ip %s, got %s", ip1, ip2)
+	}
+
+	if domain, _ := store.Get(internal.GetRedisIpKey(ip1.String())); domain != "a.com" {
+		t.Fatalf("reverse mapping should be saved, got %s", domain)
+	}
+}
+
+func TestAllocatorCollect(t *testing.T) {
+	a := newAllocator(internal.NewMemoryStore(&internal.Memory{}), "current-ip")
+	a.reset(net.ParseIP("10.85.0.2").To4(), 10)
+
+	ips := make(map[string]string)
+	for i, domain := range []string{"a.com", "b.com", "c.com", "d.com"} {
+		ip, err := a.allocate(testDomainKey(domain), domain, time.Duration(i+1)*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ips[domain] = ip.String()
+	}
+
+	a.touch(ips["a.com"], time.Hour)
+	a.release(ips["d.com"])
+
+	a.lock.Lock()
+	a.collect(time.Now().Add(150 * time.Second))
+	a.lock.Unlock()
+
+	if len(a.used) != 2 || len(a.expiry) != 2 {
+		t.Fatalf("expect 2 used, got %d, expiry %d", len(a.used), len(a.expiry))
+	}
+
+	for _, domain := range []string{"a.com", "c.com"} {
+		if _, ok := a.used[ips[domain]]; !ok {
+			t.Errorf("ip of %s should still be used", domain)
+		}
+	}
+
+	if a.expiry[0].ip != ips["c.com"] {
+		t.Errorf("expect %s at the head of expiry, got %s", ips["c.com"], a.expiry[0].ip)
+	}
+
+	if len(a.free) != 2 {
+		t.Errorf("expect the released and expired ip in the free list, got %v", a.free)
+	}
+}
+
+func TestUpdateNetwork6Reserved(t *testing.T) {
+	server := &Server{
+		pool6:     newAllocator(internal.NewMemoryStore(&internal.Memory{}), "current-ip6"),
+		localArpa: make(map[string]bool),
+	}
+	// the relay ip is not the first ip of the subnet
+	server.updateNetwork6("fd00::2/126")
+
+	for _, domain := range []string{"a.com", "b.com"} {
+		ip, err := server.pool6.allocate(testDomainKey(domain), domain, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip.String() == "fd00::2" {
+			t.Fatal("the relay ip should not be allocated")
+		}
+	}
+	if _, err := server.pool6.allocate(testDomainKey("c.com"), "c.com", time.Hour); err == nil {
+		t.Fatal("pool should be exhausted")
+	}
+}
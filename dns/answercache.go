@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	answerCacheSize = 4096
+	// prefetchMinTTL is the min ttl of the answers refreshed before expire
+	prefetchMinTTL = time.Duration(time.Second * 10)
+)
+
+// answerCache cache the positive answers of upstream by the record ttl, the expired answers
+// are served within the stale period if the upstreams fail, and the answers queried near the
+// expiry are refreshed ahead in background
+type answerCache struct {
+	cache *msgCache
+	stale time.Duration
+
+	lock       sync.Mutex
+	refreshing map[string]bool
+}
+
+func newAnswerCache(stale time.Duration) *answerCache {
+	return &answerCache{
+		cache:      newMsgCache(answerCacheSize),
+		stale:      stale,
+		refreshing: make(map[string]bool),
+	}
+}
+
+// get return the cached answer of the request with the remaining ttl, the remaining ttl <= 0
+// if the answer is stale, refresh is true if the answer should be refreshed ahead
+func (c *answerCache) get(r *dns.Msg) (msg *dns.Msg, remain time.Duration, refresh bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	key := msgCacheKey(r)
+	msg, remain, ttl := c.cache.lookup(key, r, c.stale)
+	if msg == nil || remain <= 0 || ttl < prefetchMinTTL || remain > ttl/10 {
+		return msg, remain, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.refreshing[key] {
+		return msg, remain, false
+	}
+
+	c.refreshing[key] = true
+	return msg, remain, true
+}
+
+// refreshed mark the refresh of the request is done
+func (c *answerCache) refreshed(r *dns.Msg) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.refreshing, msgCacheKey(r))
+}
+
+// set cache the positive answer of the request by the min ttl of the answer records
+func (c *answerCache) set(r *dns.Msg, msg *dns.Msg) {
+	if c == nil || msg.Rcode != dns.RcodeSuccess || msg.Truncated || len(msg.Answer) == 0 {
+		return
+	}
+
+	ttl := msg.Answer[0].Header().Ttl
+	for _, rr := range msg.Answer {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+
+	c.cache.store(msgCacheKey(r), msg, time.Duration(ttl)*time.Second)
+}
+
+func (c *answerCache) flush() {
+	if c == nil {
+		return
+	}
+	c.cache.flush()
+}
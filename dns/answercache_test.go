@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newTestAnswer(t *testing.T, r *dns.Msg, ttl uint32) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+
+	rr, err := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr.Header().Ttl = ttl
+	msg.Answer = append(msg.Answer, rr)
+	return msg
+}
+
+func TestAnswerCache(t *testing.T) {
+	c := newAnswerCache(time.Minute)
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	c.set(r, newTestAnswer(t, r, 300))
+
+	msg, remain, refresh := c.get(r)
+	if msg == nil || remain <= 0 || refresh {
+		t.Fatal("answer should be cached")
+	}
+
+	do := r.Copy()
+	do.SetEdns0(ednsUDPSize, true)
+	if msg, _, _ = c.get(do); msg != nil {
+		t.Fatal("answer should vary on DO")
+	}
+
+	empty := new(dns.Msg)
+	empty.SetRcode(r, dns.RcodeNameError)
+	c.flush()
+	c.set(r, empty)
+	if msg, _, _ = c.get(r); msg != nil {
+		t.Fatal("negative answer should not be cached")
+	}
+}
+
+func TestAnswerCacheStale(t *testing.T) {
+	c := newAnswerCache(time.Minute)
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	c.cache.store(msgCacheKey(r), newTestAnswer(t, r, 300), time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	msg, remain, _ := c.get(r)
+	if msg == nil || remain > 0 {
+		t.Fatal("stale answer should be returned in stale period")
+	}
+
+	if ttl := msg.Answer[0].Header().Ttl; ttl != staleTTL {
+		t.Fatal("stale answer ttl should be", staleTTL, "got", ttl)
+	}
+
+	c.stale = 0
+	if msg, _, _ = c.get(r); msg != nil {
+		t.Fatal("stale answer should be removed out of stale period")
+	}
+}
+
+func TestAnswerCacheRefresh(t *testing.T) {
+	c := newAnswerCache(time.Minute)
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	key := msgCacheKey(r)
+	c.cache.store(key, newTestAnswer(t, r, 300), prefetchMinTTL)
+
+	// near expiry
+	entry := c.cache.entries[key].Value.(*msgCacheEntry)
+	entry.expire = time.Now().Add(prefetchMinTTL / 20)
+
+	if _, _, refresh := c.get(r); !refresh {
+		t.Fatal("answer near expiry should be refreshed")
+	}
+
+	if _, _, refresh := c.get(r); refresh {
+		t.Fatal("answer should be refreshed once at a time")
+	}
+
+	c.refreshed(r)
+	if _, _, refresh := c.get(r); !refresh {
+		t.Fatal("answer should be refreshed again after done")
+	}
+}
@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// domainCacheSize max entries of the in memory domain cache
+	domainCacheSize = 4096
+	// domainCacheTTL how long the in memory entry is trusted before recheck redis
+	domainCacheTTL = time.Duration(time.Second * 30)
+)
+
+// domainCache is a small lru cache in front of redis, for domain -> ip lookups, the expired
+// entries are kept for the stale period, served if redis is unavailable
+type domainCache struct {
+	size  int
+	ttl   time.Duration
+	stale time.Duration
+
+	lock    sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type domainCacheEntry struct {
+	key string
+	ip  net.IP
+	// deadline is when the redis entry expires
+	deadline time.Time
+	// expire is when this entry should be rechecked with redis
+	expire time.Time
+}
+
+func newDomainCache(size int, ttl time.Duration, stale time.Duration) *domainCache {
+	return &domainCache{
+		size:    size,
+		ttl:     ttl,
+		stale:   stale,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get return the cached ip and the remaining ttl of redis entry
+func (c *domainCache) get(key string) (net.IP, time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, 0
+	}
+
+	entry := e.Value.(*domainCacheEntry)
+	now := time.Now()
+	if now.After(entry.expire) {
+		if now.After(entry.expire.Add(c.stale)) {
+			c.removeElement(e)
+		}
+		return nil, 0
+	}
+
+	c.ll.MoveToFront(e)
+	return entry.ip, entry.deadline.Sub(now)
+}
+
+// getStale return the cached ip expired within the stale period since last checked with redis
+func (c *domainCache) getStale(key string) net.IP {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := e.Value.(*domainCacheEntry)
+	if time.Now().After(entry.expire.Add(c.stale)) {
+		c.removeElement(e)
+		return nil
+	}
+	return entry.ip
+}
+
+// set cache the ip, ttl is the remaining ttl of redis entry
+func (c *domainCache) set(key string, ip net.IP, ttl time.Duration) {
+	now := time.Now()
+	deadline := now.Add(ttl)
+	expire := now.Add(c.ttl)
+	if deadline.Before(expire) {
+		expire = deadline
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*domainCacheEntry)
+		entry.ip = ip
+		entry.deadline = deadline
+		entry.expire = expire
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	e := c.ll.PushFront(&domainCacheEntry{
+		key:      key,
+		ip:       ip,
+		deadline: deadline,
+		expire:   expire,
+	})
+	c.entries[key] = e
+
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *domainCache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// flush remove all the entries
+func (c *domainCache) flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+}
+
+func (c *domainCache) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.entries, e.Value.(*domainCacheEntry).key)
+}
@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDomainCache(t *testing.T) {
+	c := newDomainCache(2, time.Minute, 0)
+
+	ip := net.ParseIP("10.85.0.2")
+	c.set("a", ip, time.Hour)
+	c.set("b", ip, time.Hour)
+
+	if v, ttl := c.get("a"); !v.Equal(ip) || ttl <= 0 {
+		t.Fatal("a should be cached")
+	}
+
+	// b is the least recently used one
+	c.set("c", ip, time.Hour)
+
+	if v, _ := c.get("b"); v != nil {
+		t.Fatal("b should be evicted")
+	}
+
+	if v, _ := c.get("a"); v == nil {
+		t.Fatal("a should not be evicted")
+	}
+
+	// redis entry expires before cache ttl
+	c.set("d", ip, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	if v, _ := c.get("d"); v != nil {
+		t.Fatal("d should be expired")
+	}
+
+	c.remove("a")
+	if v, _ := c.get("a"); v != nil {
+		t.Fatal("a should be removed")
+	}
+}
+
+func TestDomainCacheStale(t *testing.T) {
+	c := newDomainCache(2, time.Millisecond, time.Minute)
+
+	ip := net.ParseIP("10.85.0.2")
+	c.set("a", ip, time.Hour)
+	time.Sleep(time.Millisecond * 5)
+
+	if v, _ := c.get("a"); v != nil {
+		t.Fatal("a should be rechecked with redis")
+	}
+
+	if v := c.getStale("a"); !v.Equal(ip) {
+		t.Fatal("a should be served stale")
+	}
+
+	c.stale = 0
+	if v := c.getStale("a"); v != nil {
+		t.Fatal("a should not be served out of stale period")
+	}
+}
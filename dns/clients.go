@@ -0,0 +1,141 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// clientGroup is the rule profile of the clients in the subnets or the devices, e.g. the guests
+// blocked the ads and proxied, the iot devices resolved direct only
+type clientGroup struct {
+	name    string
+	subnets []*net.IPNet
+	// direct resolve all the domains by the upstream nameservers, no fake ip or proxied query
+	direct bool
+	// rejects is the reject lists applied, nil for all the lists
+	rejects map[string]bool
+	// nolog skip the query log and the query events
+	nolog bool
+}
+
+// clientGroups is the client groups in the order of the config, the devices are identified by
+// the mac of the dhcp leases
+type clientGroups struct {
+	groups []*clientGroup
+	// devices is the group of the device mac
+	devices map[string]*clientGroup
+	leases  *dhcpLeases
+}
+
+// newClientGroups create the client groups of the config, nil if not configured
+func newClientGroups(config *internal.Config) (*clientGroups, error) {
+	if config == nil || len(config.DNS.Clients) == 0 {
+		return nil, nil
+	}
+
+	groups := &clientGroups{
+		groups:  make([]*clientGroup, 0, len(config.DNS.Clients)),
+		devices: make(map[string]*clientGroup),
+		leases:  newDHCPLeases(config.DNS.DHCPLeases),
+	}
+	for _, c := range config.DNS.Clients {
+		subnets, err := internal.ParseSubnets(c.Subnets)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns client group %s subnets, %v", c.Name, err)
+		}
+
+		g := &clientGroup{name: c.Name, subnets: subnets, direct: c.Direct, nolog: c.NoLog}
+		for _, s := range c.MACs {
+			mac, err := net.ParseMAC(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dns client group %s mac %s", c.Name, s)
+			}
+			if _, ok := groups.devices[mac.String()]; !ok {
+				groups.devices[mac.String()] = g
+			}
+		}
+		if len(c.Rejects) > 0 {
+			g.rejects = make(map[string]bool, len(c.Rejects))
+			for _, name := range c.Rejects {
+				if name != internal.ClientRejectNone {
+					g.rejects[name] = true
+				}
+			}
+		}
+		groups.groups = append(groups.groups, g)
+		log.Info("dns client group %s: %v, devices: %v, direct: %v", c.Name, c.Subnets, c.MACs, c.Direct)
+	}
+	return groups, nil
+}
+
+func (h *handler) getClients() *clientGroups {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.clients
+}
+
+// lookup return the group of the device leased the client ip, or the first group contains the
+// client ip, nil for the default profile
+func (groups *clientGroups) lookup(ip net.IP) *clientGroup {
+	if groups == nil || ip == nil {
+		return nil
+	}
+
+	if mac := groups.leases.mac(ip, time.Now()); mac != "" {
+		if g, ok := groups.devices[mac]; ok {
+			return g
+		}
+	}
+
+	for _, g := range groups.groups {
+		for _, subnet := range g.subnets {
+			if subnet.Contains(ip) {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// isDirect return true if the clients are resolved direct only, false of the default profile
+func (g *clientGroup) isDirect() bool {
+	return g != nil && g.direct
+}
+
+// rejectApplied return true if the reject list is applied to the clients, all the lists are
+// applied of the default profile
+func (g *clientGroup) rejectApplied(name string) bool {
+	return g == nil || g.rejects == nil || g.rejects[name]
+}
+
+// logged return true if the queries of the clients are written to the query log and the events,
+// true of the default profile
+func (g *clientGroup) logged() bool {
+	return g == nil || !g.nolog
+}
+
+// String return the group name, default of the default profile
+func (g *clientGroup) String() string {
+	if g == nil {
+		return "default"
+	}
+	return g.name
+}
+
+// resolveDirect resolve the query of the direct clients by the upstream nameservers, the AAAA
+// answer is synthesized if dns64 enabled, not shared with the other clients answered the fake ip
+func (h *handler) resolveDirect(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
+	resp, err := h.resolveUpstream(r, info)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	if d := h.dns64For(r); d != nil && resp.Rcode == dns.RcodeSuccess && !hasAAAA(resp) {
+		return h.resolveDNS64(r, info, d, h.resolveUpstream)
+	}
+	return resp, nil
+}
@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestClientGroups(t *testing.T) {
+	config := &internal.Config{DNS: internal.DNS{Clients: []internal.DNSClientGroup{
+		{Name: "iot", Subnets: []string{"192.168.20.0/24"}, Direct: true, Rejects: []string{internal.ClientRejectNone}},
+		{Name: "guest", Subnets: []string{"192.168.30.0/24", "fd00:30::/64"}, Rejects: []string{"ads"}},
+		{Name: "all", Subnets: []string{"192.168.0.0/16"}},
+	}}}
+
+	groups, err := newClientGroups(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"192.168.20.8": "iot",
+		"192.168.30.8": "guest",
+		"fd00:30::8":   "guest",
+		"192.168.1.8":  "all",
+		"10.0.0.8":     "default",
+	}
+	for ip, expect := range cases {
+		if g := groups.lookup(net.ParseIP(ip)); g.String() != expect {
+			t.Fatalf("client %s should be in group %s, got %s", ip, expect, g)
+		}
+	}
+
+	iot, guest, all := groups.groups[0], groups.groups[1], groups.groups[2]
+	if !iot.isDirect() || guest.isDirect() || (*clientGroup)(nil).isDirect() {
+		t.Fatal("only iot should be direct")
+	}
+	if iot.rejectApplied("ads") || !guest.rejectApplied("ads") || guest.rejectApplied("trackers") {
+		t.Fatal("unexpected reject lists of iot and guest")
+	}
+	if !all.rejectApplied("trackers") || !(*clientGroup)(nil).rejectApplied("trackers") {
+		t.Fatal("all the reject lists should be applied by default")
+	}
+	if !guest.logged() || !(*clientGroup)(nil).logged() {
+		t.Fatal("the queries should be logged by default")
+	}
+
+	if groups, _ = newClientGroups(&internal.Config{}); groups.lookup(net.ParseIP("192.168.20.8")) != nil {
+		t.Fatal("no group should be matched if not configured")
+	}
+}
+
+func TestRejectListOfClientGroup(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	store.SAdd(internal.GetRedisRejectKey("ads"), "ads.example.com")
+	store.SAdd(internal.GetRedisRejectKey("trackers"), "tracker.example.net")
+
+	server := &Server{Store: store}
+	lists, err := server.loadRejects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.rejects = lists
+
+	guest := &clientGroup{name: "guest", rejects: map[string]bool{"ads": true}}
+	if server.matchReject("ads.example.com.", guest) == nil {
+		t.Fatal("ads should be rejected of guest")
+	}
+	if server.matchReject("tracker.example.net.", guest) != nil {
+		t.Fatal("trackers should not be rejected of guest")
+	}
+
+	iot := &clientGroup{name: "iot", rejects: map[string]bool{}}
+	if server.matchReject("ads.example.com.", iot) != nil {
+		t.Fatal("nothing should be rejected of iot")
+	}
+}
+
+func TestClientGroupsOfDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kungfu-leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dhcp.leases")
+	leases := "0 aa:bb:cc:dd:ee:ff 192.168.1.10 tv *\n"
+	if err = ioutil.WriteFile(file, []byte(leases), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &internal.Config{DNS: internal.DNS{DHCPLeases: file, Clients: []internal.DNSClientGroup{
+		{Name: "tv", MACs: []string{"AA-BB-CC-DD-EE-FF"}, Direct: true, NoLog: true},
+		{Name: "lan", Subnets: []string{"192.168.1.0/24"}},
+	}}}
+
+	groups, err := newClientGroups(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g := groups.lookup(net.ParseIP("192.168.1.10")); g.String() != "tv" || g.logged() {
+		t.Fatalf("the device should be in group tv, got %s", g)
+	}
+	if g := groups.lookup(net.ParseIP("192.168.1.11")); g.String() != "lan" {
+		t.Fatalf("the other clients should be in group lan, got %s", g)
+	}
+}
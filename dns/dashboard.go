@@ -0,0 +1,136 @@
+package dns
+
+import (
+	_ "embed"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// topDomainsLimit is the top domains shown on the dashboard
+const topDomainsLimit = 20
+
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+// poolStats is the fake ip pool utilization
+type poolStats struct {
+	Used uint64 `json:"used"`
+	Size uint64 `json:"size"`
+}
+
+// dashboard serve the single page dashboard on /
+func (a *adminHandler) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+func (a *adminHandler) stats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	stats := a.server.stats
+	if stats == nil {
+		stats = newQueryStats()
+	}
+	total, outcomes := stats.snapshot()
+
+	pools := make(map[string]poolStats)
+	for family, pool := range map[string]*allocator{"ipv4": a.server.pool, "ipv6": a.server.pool6} {
+		if pool != nil && pool.configured() {
+			used, size := pool.utilization()
+			pools[family] = poolStats{Used: used, Size: size}
+		}
+	}
+
+	a.server.rulesLock.RLock()
+	rejects := len(a.server.rejects)
+	a.server.rulesLock.RUnlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"qps":        stats.qps(time.Now()),
+		"queries":    total,
+		"outcomes":   outcomes,
+		"topDomains": stats.top(topDomainsLimit),
+		"pools":      pools,
+		"rules":      a.server.getRules().Len(),
+		"rejects":    rejects,
+	})
+}
+
+// ruleTest match the domain with the rules, reject, proxy or direct
+func (a *adminHandler) ruleTest(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	if domain == "" {
+		writeError(w, http.StatusBadRequest, "empty domain")
+		return
+	}
+
+	qname := dns.Fqdn(domain)
+	result := map[string]string{"domain": strings.TrimSuffix(qname, ".")}
+	switch list := a.server.matchReject(qname, nil); {
+	case list != nil:
+		result["result"] = "reject"
+		result["list"] = list.name
+	case a.server.getRules().Match(qname):
+		result["result"] = "proxy"
+	default:
+		result["result"] = "direct"
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// gateway proxy /api/gateway/* to the admin api of the gateway, for the active connections on
+// the dashboard
+func (a *adminHandler) gateway(w http.ResponseWriter, r *http.Request) {
+	var admin internal.Admin
+	if a.server.Config != nil {
+		admin = a.server.Config.Admin
+	}
+	if admin.Gateway == "" {
+		writeError(w, http.StatusNotFound, "gateway admin api is not configured")
+		return
+	}
+
+	target, err := url.Parse(admin.URL(admin.Gateway))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	transport, err := admin.Transport()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the gateway shares the auth of the admin http apis
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		admin.Authorize(r)
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		writeError(w, http.StatusBadGateway, err.Error())
+	}
+	http.StripPrefix("/api/gateway", proxy).ServeHTTP(w, r)
+}
@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// dns64 synthesize the AAAA answers from the A answers (RFC 6147), the ipv4 is embedded in the
+// NAT64 prefix (RFC 6052)
+type dns64 struct {
+	prefix *net.IPNet
+}
+
+// newDNS64 create the dns64 if the prefix configured, nil if disabled
+func newDNS64(config *internal.Config) (*dns64, error) {
+	if config == nil || config.DNS.DNS64.Prefix == "" {
+		return nil, nil
+	}
+
+	ip, prefix, err := net.ParseCIDR(config.DNS.DNS64.Prefix)
+	if err != nil || ip.To4() != nil {
+		return nil, fmt.Errorf("invalid dns64 prefix %s", config.DNS.DNS64.Prefix)
+	}
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("invalid dns64 prefix length %d", ones)
+	}
+
+	log.Info("dns64 prefix: %s", prefix)
+	return &dns64{prefix: prefix}, nil
+}
+
+func (h *handler) getDNS64() *dns64 {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.dns64
+}
+
+// dns64For return the dns64 if the request is AAAA query and dns64 enabled, nil otherwise
+func (h *handler) dns64For(r *dns.Msg) *dns64 {
+	if !isIPV6TypeAAAAQuery(&r.Question[0]) {
+		return nil
+	}
+	return h.getDNS64()
+}
+
+// embed the ipv4 in the prefix, the bits 64 to 71 are skipped
+func (d *dns64) embed(ip4 net.IP) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, d.prefix.IP)
+
+	ones, _ := d.prefix.Mask.Size()
+	pos := ones / 8
+	for _, b := range ip4.To4() {
+		if pos == 8 {
+			pos++
+		}
+		ip[pos] = b
+		pos++
+	}
+	return ip
+}
+
+// synthesize the AAAA answer of the request from the A answer, the cnames are kept
+func (d *dns64) synthesize(r *dns.Msg, a *dns.Msg) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Rcode = a.Rcode
+
+	for _, rr := range a.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			msg.Answer = append(msg.Answer, newAAAARecord(rr.Hdr.Name, d.embed(rr.A), rr.Hdr.Ttl))
+		case *dns.CNAME:
+			msg.Answer = append(msg.Answer, dns.Copy(rr))
+		}
+	}
+
+	if len(msg.Answer) == 0 {
+		msg.Ns = a.Ns
+	}
+	return msg
+}
+
+// resolveDNS64 answer the AAAA query by synthesizing the A answer resolved by resolve
+func (h *handler) resolveDNS64(r *dns.Msg, info *queryInfo, d *dns64, resolve func(*dns.Msg, *queryInfo) (*dns.Msg, error)) (*dns.Msg, error) {
+	req := r.Copy()
+	req.Question[0].Qtype = dns.TypeA
+
+	resp, err := resolve(req, info)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	log.Debug("dns64 resolve %s, synthesize %d answers", r.Question[0].Name, len(resp.Answer))
+	return d.synthesize(r, resp), nil
+}
+
+// hasAAAA return true if the response has AAAA answers
+func hasAAAA(msg *dns.Msg) bool {
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == dns.TypeAAAA {
+			return true
+		}
+	}
+	return false
+}
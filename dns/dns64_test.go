@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestDNS64Embed(t *testing.T) {
+	cases := map[string]string{
+		"64:ff9b::/96":      "64:ff9b::c000:221",
+		"2001:db8::/32":     "2001:db8:c000:221::",
+		"2001:db8:100::/40": "2001:db8:1c0:2:21::",
+		"2001:db8::/64":     "2001:db8::c0:2:2100:0",
+	}
+
+	for prefix, expect := range cases {
+		d, err := newDNS64(&internal.Config{DNS: internal.DNS{DNS64: internal.DNS64{Prefix: prefix}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := d.embed(net.ParseIP("192.0.2.33")); !ip.Equal(net.ParseIP(expect)) {
+			t.Fatalf("embed in %s should be %s, got %s", prefix, expect, ip)
+		}
+	}
+
+	if d, _ := newDNS64(&internal.Config{}); d != nil {
+		t.Fatal("dns64 should be disabled without prefix")
+	}
+}
+
+func TestDNS64Internal(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	store.SAdd(internal.GetRedisProxyDomainSetKey(), "google.com")
+	server := &Server{
+		Store: store,
+		pool:  newAllocator(store, "current-ip"),
+		pool6: newAllocator(store, "current-ip6"),
+	}
+	server.pool.reset(net.ParseIP("10.85.0.2").To4(), 10)
+	server.loadRules()
+
+	h := &handler{server: server, cache: newDomainCache(domainCacheSize, domainCacheTTL, 0)}
+	h.ttl = ttlPolicy{mapping: time.Hour}
+	h.dns64, _ = newDNS64(&internal.Config{DNS: internal.DNS{DNS64: internal.DNS64{Prefix: "64:ff9b::/96"}}})
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.google.com.", dns.TypeAAAA)
+	msg, err := h.resolveInternal(r, new(queryInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answer) != 1 || msg.Question[0].Qtype != dns.TypeAAAA {
+		t.Fatalf("unexpected answer %v", msg)
+	}
+
+	// the fake ipv4 of the domain embedded
+	ip, err := store.Get(testDomainKey("www.google.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !answerIP(msg.Answer[0]).Equal(h.dns64.embed(net.ParseIP(ip))) {
+		t.Fatalf("the fake ip %s should be embedded, %v", ip, msg.Answer[0])
+	}
+}
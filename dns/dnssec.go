@@ -0,0 +1,756 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// dnssecCacheTTL is the max time to cache the validated zone keys and delegations
+	dnssecCacheTTL = time.Duration(time.Hour)
+	// dnssecMaxDepth limit the length of the chain of trust
+	dnssecMaxDepth = 16
+	// dnssecUDPSize is the edns0 udp size of the queries with DO set
+	dnssecUDPSize = 4096
+)
+
+// rootAnchors is the DS of the root zone KSK (KSK-2017 and KSK-2024)
+var rootAnchors = []string{
+	". 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 172800 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// errInsecure is the data is provably unsigned, or signed by the zone out of the chain of trust
+var errInsecure = errors.New("dnssec insecure")
+
+// the delegation status of the name proven by the DS query
+const (
+	// cutUnsigned the DS answer is unsigned, the status is decided by the ancestors
+	cutUnsigned = iota
+	// cutInsecure the name is a delegation without DS
+	cutInsecure
+	// cutSecure the name is in a signed zone
+	cutSecure
+)
+
+// validator is the validating stub of the upstream answers, the chain of trust is built from
+// the trust anchors by querying the DNSKEY and DS of the signer zones on the upstreams
+type validator struct {
+	anchors map[string][]*dns.DS
+	query   func(name string, qtype uint16) (*dns.Msg, error)
+
+	lock  sync.Mutex
+	zones map[string]*zoneKeys
+	cuts  map[string]*zoneCut
+}
+
+// zoneKeys is the validated DNSKEY of the zone, nil if the zone is insecure
+type zoneKeys struct {
+	keys   []*dns.DNSKEY
+	expire time.Time
+}
+
+type zoneCut struct {
+	status int
+	expire time.Time
+}
+
+// rrset is the records of the same name and type with the covering signatures
+type rrset struct {
+	rrs  []dns.RR
+	sigs []*dns.RRSIG
+}
+
+// newValidator create the validator if dnssec enabled, the trust anchors are loaded from the
+// file if configured, the root KSK by default
+func newValidator(config *internal.Config, query func(name string, qtype uint16) (*dns.Msg, error)) (*validator, error) {
+	if config == nil || !config.DNS.DNSSEC.Enable {
+		return nil, nil
+	}
+
+	lines := rootAnchors
+	if file := config.DNS.DNSSEC.TrustAnchor; file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		lines = strings.Split(string(data), "\n")
+	}
+
+	anchors, err := parseTrustAnchors(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("no trust anchor")
+	}
+
+	return &validator{
+		anchors: anchors,
+		query:   query,
+		zones:   make(map[string]*zoneKeys),
+		cuts:    make(map[string]*zoneCut),
+	}, nil
+}
+
+// parseTrustAnchors parse the DS or DNSKEY records in zone file format, the DNSKEY is
+// converted to DS (SHA256)
+func parseTrustAnchors(lines []string) (map[string][]*dns.DS, error) {
+	anchors := make(map[string][]*dns.DS)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor %s, %v", line, err)
+		}
+
+		var ds *dns.DS
+		switch rr := rr.(type) {
+		case *dns.DS:
+			ds = rr
+		case *dns.DNSKEY:
+			ds = rr.ToDS(dns.SHA256)
+		}
+
+		if ds == nil {
+			return nil, fmt.Errorf("invalid trust anchor %s, not DS or DNSKEY", line)
+		}
+
+		zone := canonicalName(ds.Hdr.Name)
+		anchors[zone] = append(anchors[zone], ds)
+	}
+	return anchors, nil
+}
+
+func (h *handler) getValidator() *validator {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.dnssec
+}
+
+// queryDNSSEC query the records for validating on the upstreams, with DO and CD set
+func (h *handler) queryDNSSEC(name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(dnssecUDPSize, true)
+	msg.CheckingDisabled = true
+
+	nameserver, _ := h.upstreams()
+	err := fmt.Errorf("no upstream nameserver")
+	for _, ns := range orderByHealth(nameserver) {
+		var resp *dns.Msg
+		resp, _, err = ns.exchange(msg)
+		if err != nil {
+			continue
+		}
+
+		if resp.Rcode == dns.RcodeServerFailure {
+			err = fmt.Errorf("query %s %s on %s fail code %d", name, dns.Type(qtype), ns, resp.Rcode)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, err
+}
+
+// prepare copy the query with DO and CD set, the upstream return the signatures and leave
+// the validation to us
+func (v *validator) prepare(r *dns.Msg) *dns.Msg {
+	req := r.Copy()
+	req.CheckingDisabled = true
+	if opt := req.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		req.SetEdns0(dnssecUDPSize, true)
+	}
+	return req
+}
+
+// finish validate the upstream answer of the query, set AD if secure, answer SERVFAIL if bogus,
+// the dnssec records are stripped if the client did not ask for them (DO)
+func (v *validator) finish(r *dns.Msg, resp *dns.Msg) *dns.Msg {
+	if !r.CheckingDisabled {
+		secure, err := v.validate(resp, time.Now())
+		if err != nil {
+			log.Warning("dnssec validate %s qtype: %d bogus, %v", r.Question[0].Name, r.Question[0].Qtype, err)
+			msg := new(dns.Msg)
+			msg.SetRcode(r, dns.RcodeServerFailure)
+			return msg
+		}
+		resp.AuthenticatedData = secure
+	}
+	resp.CheckingDisabled = r.CheckingDisabled
+
+	opt := r.IsEdns0()
+	if opt == nil || !opt.Do() {
+		qtype := r.Question[0].Qtype
+		resp.Answer = stripDNSSEC(resp.Answer, qtype)
+		resp.Ns = stripDNSSEC(resp.Ns, qtype)
+		resp.Extra = stripDNSSEC(resp.Extra, qtype)
+	}
+
+	if opt == nil {
+		extra := resp.Extra[:0]
+		for _, rr := range resp.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		resp.Extra = extra
+	}
+	return resp
+}
+
+// validate the answer, the positive answer is secure if all the rrsets are validated and the
+// wildcard expansions are proven, the negative answer is secure if the NSEC or NSEC3 prove it
+func (v *validator) validate(msg *dns.Msg, now time.Time) (bool, error) {
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		return false, nil
+	}
+
+	if len(msg.Answer) == 0 {
+		return v.validateDenial(msg, now)
+	}
+
+	unsigned, err := v.verifySection(msg.Answer, now, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if err := v.checkUnsigned(unsigned, now); err != nil {
+		return false, err
+	}
+
+	if len(unsigned) > 0 {
+		return false, nil
+	}
+
+	secure := msg.Rcode == dns.RcodeSuccess
+	for _, set := range groupRRsets(msg.Answer) {
+		owner := set.rrs[0].Header().Name
+		if len(set.sigs) == 0 || int(set.sigs[0].Labels) >= dns.CountLabel(owner) {
+			continue
+		}
+
+		// the denial of the name expanded from the wildcard is in the authority section
+		unsigned, err := v.verifySection(msg.Ns, now, 0)
+		if err != nil {
+			return false, err
+		}
+		if len(unsigned) > 0 {
+			return false, fmt.Errorf("unsigned denial of %s expanded from the wildcard", owner)
+		}
+
+		proven, err := wildcardProof(owner, set.sigs[0].Labels, msg.Ns)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && proven
+	}
+	return secure, nil
+}
+
+// validateDenial validate the negative answer, the denial of the signed zone must be proven by
+// the NSEC or NSEC3 of the authority section
+func (v *validator) validateDenial(msg *dns.Msg, now time.Time) (bool, error) {
+	unsigned, err := v.verifySection(msg.Ns, now, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if len(msg.Ns) == 0 && len(msg.Question) > 0 {
+		unsigned = append(unsigned, msg.Question[0].Name)
+	}
+
+	if err := v.checkUnsigned(unsigned, now); err != nil {
+		return false, err
+	}
+
+	if len(unsigned) > 0 || len(msg.Question) == 0 {
+		return false, nil
+	}
+	return denial(msg.Question[0], msg.Rcode, msg.Ns)
+}
+
+// checkUnsigned check the unsigned names are in the insecure zones
+func (v *validator) checkUnsigned(unsigned []string, now time.Time) error {
+	for _, name := range unsigned {
+		insecure, err := v.insecure(name, now, 0)
+		if err != nil {
+			return err
+		}
+
+		if !insecure {
+			return fmt.Errorf("unsigned %s in signed zone", name)
+		}
+	}
+	return nil
+}
+
+// verifySection verify the signed rrsets of the section, return the owner names of the rrsets
+// unsigned or signed by the insecure zone, the synthesized cname of DNAME is ignored
+func (v *validator) verifySection(section []dns.RR, now time.Time, depth int) ([]string, error) {
+	sets := groupRRsets(section)
+
+	dname := false
+	for _, set := range sets {
+		if set.rrs[0].Header().Rrtype == dns.TypeDNAME {
+			dname = true
+		}
+	}
+
+	var unsigned []string
+	for _, set := range sets {
+		hdr := set.rrs[0].Header()
+		if len(set.sigs) == 0 {
+			if !(dname && hdr.Rrtype == dns.TypeCNAME) {
+				unsigned = append(unsigned, hdr.Name)
+			}
+			continue
+		}
+
+		err := v.verify(set, now, depth)
+		if err == errInsecure {
+			unsigned = append(unsigned, hdr.Name)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return unsigned, nil
+}
+
+// verify the rrset with any of the signatures by the validated keys of the signer zone
+func (v *validator) verify(set *rrset, now time.Time, depth int) error {
+	hdr := set.rrs[0].Header()
+	owner := canonicalName(hdr.Name)
+	err := fmt.Errorf("no valid signature of %s %s", owner, dns.Type(hdr.Rrtype))
+
+	supported := false
+	for _, sig := range set.sigs {
+		if !dnssecAlgorithmSupported(sig.Algorithm) {
+			continue
+		}
+		supported = true
+
+		signer := canonicalName(sig.SignerName)
+		if !dns.IsSubDomain(signer, owner) {
+			err = fmt.Errorf("signer %s of %s out of zone", signer, owner)
+			continue
+		}
+
+		if !sig.ValidityPeriod(now) {
+			err = fmt.Errorf("signature of %s %s expired or not yet valid", owner, dns.Type(hdr.Rrtype))
+			continue
+		}
+
+		keys, kerr := v.zoneKeys(signer, now, depth)
+		if kerr == errInsecure {
+			return errInsecure
+		}
+
+		if kerr != nil {
+			err = kerr
+			continue
+		}
+
+		for _, key := range keys {
+			if sig.KeyTag == key.KeyTag() && verifySignature(sig, key, set.rrs) == nil {
+				return nil
+			}
+		}
+	}
+
+	// the algorithms not supported are treated as unsigned (RFC 4035 5.2)
+	if !supported {
+		return errInsecure
+	}
+	return err
+}
+
+// zoneKeys return the DNSKEY of the zone validated by the DS of the parent or trust anchor
+func (v *validator) zoneKeys(zone string, now time.Time, depth int) ([]*dns.DNSKEY, error) {
+	if depth > dnssecMaxDepth {
+		return nil, fmt.Errorf("chain of trust of %s too long", zone)
+	}
+
+	v.lock.Lock()
+	cached := v.zones[zone]
+	v.lock.Unlock()
+
+	if cached != nil && now.Before(cached.expire) {
+		if cached.keys == nil {
+			return nil, errInsecure
+		}
+		return cached.keys, nil
+	}
+
+	ds, err := v.delegation(zone, now, depth)
+	if err == errInsecure {
+		v.cacheKeys(zone, nil, dnssecCacheTTL, now)
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := v.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+
+	var set *rrset
+	for _, s := range groupRRsets(msg.Answer) {
+		hdr := s.rrs[0].Header()
+		if hdr.Rrtype == dns.TypeDNSKEY && canonicalName(hdr.Name) == zone {
+			set = s
+		}
+	}
+
+	if set == nil {
+		return nil, fmt.Errorf("no DNSKEY of %s", zone)
+	}
+
+	keys := make([]*dns.DNSKEY, 0, len(set.rrs))
+	for _, rr := range set.rrs {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+
+	for _, sig := range set.sigs {
+		if !sig.ValidityPeriod(now) {
+			continue
+		}
+
+		for _, key := range keys {
+			if sig.KeyTag != key.KeyTag() || !matchDS(key, ds) {
+				continue
+			}
+
+			if verifySignature(sig, key, set.rrs) == nil {
+				ttl := time.Duration(set.rrs[0].Header().Ttl) * time.Second
+				v.cacheKeys(zone, keys, ttl, now)
+				return keys, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no DNSKEY of %s matched the DS", zone)
+}
+
+func (v *validator) cacheKeys(zone string, keys []*dns.DNSKEY, ttl time.Duration, now time.Time) {
+	if ttl > dnssecCacheTTL {
+		ttl = dnssecCacheTTL
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.zones[zone] = &zoneKeys{keys: keys, expire: now.Add(ttl)}
+}
+
+// delegation return the DS of the zone from the trust anchors or the validated parent, errInsecure
+// if the zone is provably insecure
+func (v *validator) delegation(zone string, now time.Time, depth int) ([]*dns.DS, error) {
+	if ds := v.anchors[zone]; ds != nil {
+		return ds, nil
+	}
+
+	msg, err := v.query(zone, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+
+	var set *rrset
+	for _, s := range groupRRsets(msg.Answer) {
+		hdr := s.rrs[0].Header()
+		if hdr.Rrtype == dns.TypeDS && canonicalName(hdr.Name) == zone {
+			set = s
+		}
+	}
+
+	if set == nil || len(set.sigs) == 0 {
+		insecure, err := v.insecure(zone, now, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		if insecure {
+			return nil, errInsecure
+		}
+		return nil, fmt.Errorf("no DS of %s in signed zone", zone)
+	}
+
+	if err = v.verify(set, now, depth+1); err != nil {
+		return nil, err
+	}
+
+	ds := supportedDS(set.rrs)
+	if len(ds) == 0 {
+		return nil, errInsecure
+	}
+	return ds, nil
+}
+
+// insecure walk up from the name to prove the unsigned data is under an insecure delegation
+func (v *validator) insecure(name string, now time.Time, depth int) (bool, error) {
+	for name = canonicalName(name); ; name = parentName(name) {
+		status, err := v.cut(name, now, depth)
+		if err != nil {
+			return false, err
+		}
+
+		switch status {
+		case cutInsecure:
+			return true, nil
+		case cutSecure:
+			return false, nil
+		}
+
+		if name == "." {
+			return false, nil
+		}
+	}
+}
+
+// cut return the delegation status of the name by the DS query
+func (v *validator) cut(name string, now time.Time, depth int) (int, error) {
+	if depth > dnssecMaxDepth {
+		return cutUnsigned, fmt.Errorf("chain of trust of %s too long", name)
+	}
+
+	if v.anchors[name] != nil {
+		return cutSecure, nil
+	}
+
+	v.lock.Lock()
+	cached := v.cuts[name]
+	v.lock.Unlock()
+
+	if cached != nil && now.Before(cached.expire) {
+		return cached.status, nil
+	}
+
+	msg, err := v.query(name, dns.TypeDS)
+	if err != nil {
+		return cutUnsigned, err
+	}
+
+	status, err := v.proveCut(name, msg, now, depth)
+	if err != nil {
+		return status, err
+	}
+
+	v.lock.Lock()
+	v.cuts[name] = &zoneCut{status: status, expire: now.Add(dnssecCacheTTL)}
+	v.lock.Unlock()
+	return status, nil
+}
+
+// proveCut check the answer of the DS query of the name
+func (v *validator) proveCut(name string, msg *dns.Msg, now time.Time, depth int) (int, error) {
+	for _, set := range groupRRsets(msg.Answer) {
+		hdr := set.rrs[0].Header()
+		if hdr.Rrtype != dns.TypeDS || canonicalName(hdr.Name) != name {
+			continue
+		}
+
+		if len(set.sigs) == 0 {
+			return cutUnsigned, nil
+		}
+
+		err := v.verify(set, now, depth+1)
+		if err == errInsecure {
+			return cutUnsigned, nil
+		}
+
+		if err != nil {
+			return cutUnsigned, err
+		}
+
+		if len(supportedDS(set.rrs)) == 0 {
+			return cutInsecure, nil
+		}
+		return cutSecure, nil
+	}
+
+	signed := false
+	for _, rr := range msg.Ns {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			signed = true
+		}
+	}
+
+	if !signed {
+		return cutUnsigned, nil
+	}
+
+	unsigned, err := v.verifySection(msg.Ns, now, depth+1)
+	if err != nil {
+		return cutUnsigned, err
+	}
+
+	if len(unsigned) > 0 {
+		return cutUnsigned, nil
+	}
+
+	if deniesDS(name, msg.Ns) {
+		return cutInsecure, nil
+	}
+	return cutSecure, nil
+}
+
+// deniesDS check the NSEC or NSEC3 proves the name is a delegation without DS, the opt-out
+// NSEC3 covering the name is accepted as well
+func deniesDS(name string, ns []dns.RR) bool {
+	for _, rr := range ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if canonicalName(rr.Hdr.Name) == name && isDelegation(rr.TypeBitMap) {
+				return true
+			}
+		case *dns.NSEC3:
+			if rr.Match(name) && isDelegation(rr.TypeBitMap) {
+				return true
+			}
+
+			if rr.Flags&1 == 1 && rr.Cover(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDelegation return true if the type bitmap has NS but neither DS nor SOA
+func isDelegation(bitmap []uint16) bool {
+	ns := false
+	for _, t := range bitmap {
+		switch t {
+		case dns.TypeNS:
+			ns = true
+		case dns.TypeDS, dns.TypeSOA:
+			return false
+		}
+	}
+	return ns
+}
+
+// matchDS return true if the key is the one of the DS
+func matchDS(key *dns.DNSKEY, ds []*dns.DS) bool {
+	for _, d := range ds {
+		if d.KeyTag != key.KeyTag() || d.Algorithm != key.Algorithm {
+			continue
+		}
+
+		if digest := key.ToDS(d.DigestType); digest != nil && strings.EqualFold(digest.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedDS return the DS of the algorithms and digest types supported
+func supportedDS(rrs []dns.RR) []*dns.DS {
+	var ds []*dns.DS
+	for _, rr := range rrs {
+		d, ok := rr.(*dns.DS)
+		if !ok || !dnssecAlgorithmSupported(d.Algorithm) {
+			continue
+		}
+
+		switch d.DigestType {
+		case dns.SHA1, dns.SHA256, dns.SHA384:
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// dnssecAlgorithmSupported return true if the signature algorithm can be verified
+func dnssecAlgorithmSupported(algorithm uint8) bool {
+	switch algorithm {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512,
+		dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dnssecED25519:
+		return true
+	}
+	return false
+}
+
+// groupRRsets group the records by name and type in order, with the covering signatures
+func groupRRsets(section []dns.RR) []*rrset {
+	var sets []*rrset
+	index := make(map[string]*rrset)
+
+	get := func(name string, rrtype uint16) *rrset {
+		key := fmt.Sprintf("%s/%d", canonicalName(name), rrtype)
+		set := index[key]
+		if set == nil {
+			set = &rrset{}
+			index[key] = set
+			sets = append(sets, set)
+		}
+		return set
+	}
+
+	for _, rr := range section {
+		hdr := rr.Header()
+		switch rr := rr.(type) {
+		case *dns.OPT:
+		case *dns.RRSIG:
+			set := get(hdr.Name, rr.TypeCovered)
+			set.sigs = append(set.sigs, rr)
+		default:
+			set := get(hdr.Name, hdr.Rrtype)
+			set.rrs = append(set.rrs, rr)
+		}
+	}
+
+	// the signatures without the covered records
+	r := sets[:0]
+	for _, set := range sets {
+		if len(set.rrs) > 0 {
+			r = append(r, set)
+		}
+	}
+	return r
+}
+
+// stripDNSSEC remove the RRSIG, NSEC and NSEC3 records, except the type queried
+func stripDNSSEC(section []dns.RR, qtype uint16) []dns.RR {
+	r := section[:0]
+	for _, rr := range section {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			if t != qtype {
+				continue
+			}
+		}
+		r = append(r, rr)
+	}
+	return r
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+func parentName(name string) string {
+	labels := dns.Split(name)
+	if len(labels) < 2 {
+		return "."
+	}
+	return name[labels[1]:]
+}
@@ -0,0 +1,198 @@
+package dns
+
+import (
+	"crypto"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type testZone struct {
+	key  *dns.DNSKEY
+	priv crypto.PrivateKey
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testZone{key: key, priv: priv}
+}
+
+func (z *testZone) sign(t *testing.T, rrs ...dns.RR) []dns.RR {
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrs[0].Header().Ttl},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.key.Hdr.Name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+	}
+
+	if err := sig.Sign(z.priv.(crypto.Signer), rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func testRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func newTestValidator(t *testing.T) (*validator, *testZone) {
+	root := newTestZone(t, ".")
+	example := newTestZone(t, "example.")
+
+	ds := example.key.ToDS(dns.SHA256)
+	ds.Hdr.Ttl = 3600
+
+	answers := map[string]*dns.Msg{
+		"./DNSKEY":               {Answer: root.sign(t, root.key)},
+		"example./DNSKEY":        {Answer: example.sign(t, example.key)},
+		"example./DS":            {Answer: root.sign(t, ds)},
+		"www.example./DS":        {Ns: example.sign(t, testRR(t, "www.example. 3600 IN NSEC z.example. A RRSIG NSEC"))},
+		"a.insecure.example./DS": {},
+		"insecure.example./DS": {
+			Ns: example.sign(t, testRR(t, "insecure.example. 3600 IN NSEC www.example. NS RRSIG NSEC")),
+		},
+	}
+
+	anchors, err := parseTrustAnchors([]string{root.key.ToDS(dns.SHA256).String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &validator{
+		anchors: anchors,
+		zones:   make(map[string]*zoneKeys),
+		cuts:    make(map[string]*zoneCut),
+		query: func(name string, qtype uint16) (*dns.Msg, error) {
+			msg := answers[fmt.Sprintf("%s/%s", name, dns.Type(qtype))]
+			if msg == nil {
+				return nil, fmt.Errorf("no answer of %s %s", name, dns.Type(qtype))
+			}
+			return msg, nil
+		},
+	}
+	return v, example
+}
+
+func TestValidatorSecure(t *testing.T) {
+	v, example := newTestValidator(t)
+
+	msg := &dns.Msg{Answer: example.sign(t, testRR(t, "www.example. 300 IN A 192.0.2.1"))}
+	secure, err := v.validate(msg, time.Now())
+	if err != nil || !secure {
+		t.Fatal("signed answer should be secure", err)
+	}
+
+	msg.Answer[0].(*dns.A).A[3] = 2
+	if _, err = v.validate(msg, time.Now()); err == nil {
+		t.Fatal("tampered answer should be bogus")
+	}
+}
+
+func TestValidatorUnsigned(t *testing.T) {
+	v, _ := newTestValidator(t)
+
+	msg := &dns.Msg{Answer: []dns.RR{testRR(t, "www.example. 300 IN A 192.0.2.1")}}
+	if _, err := v.validate(msg, time.Now()); err == nil {
+		t.Fatal("unsigned answer in signed zone should be bogus")
+	}
+
+	msg = &dns.Msg{Answer: []dns.RR{testRR(t, "a.insecure.example. 300 IN A 192.0.2.1")}}
+	secure, err := v.validate(msg, time.Now())
+	if err != nil || secure {
+		t.Fatal("unsigned answer under insecure delegation should be insecure", err)
+	}
+}
+
+func TestValidatorFinish(t *testing.T) {
+	v, example := newTestValidator(t)
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.", dns.TypeA)
+
+	req := v.prepare(r)
+	if opt := req.IsEdns0(); opt == nil || !opt.Do() || !req.CheckingDisabled {
+		t.Fatal("upstream query should set DO and CD")
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = example.sign(t, testRR(t, "www.example. 300 IN A 192.0.2.1"))
+
+	resp = v.finish(r, resp)
+	if !resp.AuthenticatedData || resp.Rcode != dns.RcodeSuccess {
+		t.Fatal("secure answer should set AD")
+	}
+
+	if len(resp.Answer) != 1 || resp.IsEdns0() != nil {
+		t.Fatal("dnssec records should be stripped without DO", resp)
+	}
+
+	resp = new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = []dns.RR{testRR(t, "www.example. 300 IN A 192.0.2.1")}
+
+	resp = v.finish(r, resp)
+	if resp.Rcode != dns.RcodeServerFailure {
+		t.Fatal("bogus answer should fail closed")
+	}
+}
+
+func TestParseTrustAnchors(t *testing.T) {
+	anchors, err := parseTrustAnchors(append([]string{"; comment", ""}, rootAnchors...))
+	if err != nil || len(anchors["."]) != 2 {
+		t.Fatal("parse root anchors error", err)
+	}
+
+	if _, err = parseTrustAnchors([]string{"example. 300 IN A 192.0.2.1"}); err == nil {
+		t.Fatal("non DS or DNSKEY anchor should be invalid")
+	}
+}
+
+func TestValidatorCheckingDisabledCache(t *testing.T) {
+	v, _ := newTestValidator(t)
+
+	// the upstream answer the unsigned record of the signed zone
+	up, stop := startTestUpstream(t, "", 0, "192.0.2.1")
+	defer stop()
+
+	h := &handler{
+		nameserver: []*upstream{up},
+		negative:   newNegativeCache(0),
+		answers:    newAnswerCache(0),
+		dnssec:     v,
+	}
+
+	cd := new(dns.Msg)
+	cd.SetQuestion("www.example.", dns.TypeA)
+	cd.CheckingDisabled = true
+	resp, err := h.resolveShared(cd, &queryInfo{}, h.resolveUpstream)
+	if err != nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatal("the answer should be returned without validation for CD", resp, err)
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.", dns.TypeA)
+	resp, err = h.resolveShared(r, &queryInfo{}, h.resolveUpstream)
+	if err != nil || resp.Rcode != dns.RcodeServerFailure {
+		t.Fatal("the bogus answer cached for CD should not be served without CD", resp, err)
+	}
+}
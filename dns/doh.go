@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dohPath      = "/dns-query"
+	dohMediaType = "application/dns-message"
+	// dohMaxSize is the max size of the dns message in request
+	dohMaxSize = 65535
+)
+
+// dohHandler serve dns over https (RFC 8484), GET and POST with wire format
+type dohHandler struct {
+	handler dns.Handler
+}
+
+func (d *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		data, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+		data, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, dohMaxSize))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil || len(data) == 0 {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err = req.Unpack(data); err != nil || len(req.Question) == 0 {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+
+	rw := &dohResponseWriter{remote: remoteAddr(r.RemoteAddr)}
+	d.handler.ServeDNS(rw, req)
+	if rw.msg == nil {
+		http.Error(w, "resolve fail", http.StatusBadGateway)
+		return
+	}
+
+	buf := msgBuffers.Get()
+	defer msgBuffers.Put(buf)
+	resp, err := rw.msg.PackBuffer(*buf)
+	if err != nil {
+		log.Error("pack doh response of %s error, %v", req.Question[0].Name, err)
+		http.Error(w, "resolve fail", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minTTL(rw.msg)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	w.Write(resp)
+}
+
+// minTTL return the min ttl of the answers, 0 if no answer
+func minTTL(msg *dns.Msg) uint32 {
+	var ttl uint32
+	for i, rr := range msg.Answer {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}
+
+func remoteAddr(addr string) net.Addr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+}
+
+// dohResponseWriter collect the reply message of the dns handler
+type dohResponseWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (w *dohResponseWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+func (w *dohResponseWriter) WriteMsg(msg *dns.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func (w *dohResponseWriter) Write(data []byte) (int, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(data); err != nil {
+		return 0, err
+	}
+	w.msg = msg
+	return len(data), nil
+}
+
+func (w *dohResponseWriter) Close() error {
+	return nil
+}
+
+func (w *dohResponseWriter) TsigStatus() error {
+	return nil
+}
+
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+
+func (w *dohResponseWriter) Hijack() {}
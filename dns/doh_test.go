@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDoHHandler(t *testing.T) {
+	d := &dohHandler{handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, newARecord(r.Question[0].Name, net.ParseIP("10.85.0.1"), 30))
+		w.WriteMsg(msg)
+	})}
+
+	req := new(dns.Msg)
+	req.SetQuestion("google.com.", dns.TypeA)
+	data, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := httptest.NewRequest(http.MethodGet, dohPath+"?dns="+base64.RawURLEncoding.EncodeToString(data), nil)
+	post := httptest.NewRequest(http.MethodPost, dohPath, bytes.NewReader(data))
+	post.Header.Set("Content-Type", dohMediaType)
+
+	for _, r := range []*http.Request{get, post} {
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status %d, %s", r.Method, w.Code, w.Body.String())
+		}
+
+		if w.Header().Get("Cache-Control") != "max-age=30" {
+			t.Fatalf("%s cache control %s", r.Method, w.Header().Get("Cache-Control"))
+		}
+
+		resp := new(dns.Msg)
+		if err := resp.Unpack(w.Body.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Answer) != 1 || resp.Id != req.Id {
+			t.Fatalf("%s unexpected response %v", r.Method, resp)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest(http.MethodGet, dohPath+"?dns=xx", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid request status %d", w.Code)
+	}
+}
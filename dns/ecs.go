@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	ecsClientMode = "client"
+	ecsMaskIpv4   = 24
+	ecsMaskIpv6   = 56
+	ednsUDPSize   = 4096
+)
+
+// ecsConfig attach edns0 client subnet to upstream query,
+// use the real client subnet or the configured static subnet
+type ecsConfig struct {
+	client bool
+	subnet *net.IPNet
+}
+
+// parseECS parse the ecs config, "client" or cidr like 1.2.3.0/24
+func parseECS(value string) (*ecsConfig, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	if value == ecsClientMode {
+		return &ecsConfig{client: true}, nil
+	}
+
+	_, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream ecs %s, %v", value, err)
+	}
+
+	return &ecsConfig{subnet: subnet}, nil
+}
+
+// ecsState records what apply added to the request
+type ecsState struct {
+	ecsAdded bool
+	optAdded bool
+}
+
+// apply attach ecs to the request if the client does not send one
+func (c *ecsConfig) apply(r *dns.Msg, remote net.Addr) ecsState {
+	var state ecsState
+	if c == nil {
+		return state
+	}
+
+	opt := r.IsEdns0()
+	if opt != nil {
+		for _, o := range opt.Option {
+			if o.Option() == dns.EDNS0SUBNET {
+				// passthrough the client's own ecs
+				return state
+			}
+		}
+	}
+
+	subnet := c.subnet
+	if c.client {
+		subnet = clientSubnet(remote)
+	}
+
+	if subnet == nil {
+		return state
+	}
+
+	if opt == nil {
+		r.SetEdns0(ednsUDPSize, false)
+		opt = r.IsEdns0()
+		state.optAdded = true
+	}
+
+	opt.Option = append(opt.Option, newECSOption(subnet))
+	state.ecsAdded = true
+	return state
+}
+
+// strip remove the ecs and opt added by apply from the response
+func (s ecsState) strip(msg *dns.Msg) {
+	if msg == nil || !s.ecsAdded {
+		return
+	}
+
+	if s.optAdded {
+		removeOPT(msg)
+		return
+	}
+
+	if opt := msg.IsEdns0(); opt != nil {
+		options := opt.Option[:0]
+		for _, o := range opt.Option {
+			if o.Option() != dns.EDNS0SUBNET {
+				options = append(options, o)
+			}
+		}
+		opt.Option = options
+	}
+}
+
+func removeOPT(msg *dns.Msg) {
+	extra := msg.Extra[:0]
+	for _, rr := range msg.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	msg.Extra = extra
+}
+
+// clientSubnet the subnet of public client address, nil for private address
+func clientSubnet(remote net.Addr) *net.IPNet {
+	var ip net.IP
+	switch addr := remote.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		return nil
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() {
+		return nil
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(ecsMaskIpv4, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+
+	mask := net.CIDRMask(ecsMaskIpv6, 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+func newECSOption(subnet *net.IPNet) *dns.EDNS0_SUBNET {
+	ones, _ := subnet.Mask.Size()
+	e := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+	}
+
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		e.Family = 1
+		e.Address = ip4
+	} else {
+		e.Family = 2
+		e.Address = subnet.IP
+	}
+	return e
+}
@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseECS(t *testing.T) {
+	if c, err := parseECS(""); c != nil || err != nil {
+		t.Fatal("empty ecs should be disabled", err)
+	}
+	if c, err := parseECS("client"); err != nil || !c.client {
+		t.Fatal("unexpected client ecs", err)
+	}
+	if c, err := parseECS("1.2.3.4/24"); err != nil || c.subnet.String() != "1.2.3.0/24" {
+		t.Fatal("unexpected static ecs", err)
+	}
+	if _, err := parseECS("1.2.3.4"); err == nil {
+		t.Fatal("ecs should be invalid")
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	tests := map[net.Addr]string{
+		&net.UDPAddr{IP: net.ParseIP("8.8.4.4")}:           "8.8.4.0/24",
+		&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:3::1")}: "2001:db8:1::/56",
+		&net.UDPAddr{IP: net.ParseIP("192.168.1.2")}:       "<nil>",
+		&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}:         "<nil>",
+		&net.UDPAddr{IP: net.ParseIP("fe80::1")}:           "<nil>",
+	}
+	for addr, expect := range tests {
+		if subnet := clientSubnet(addr); subnet.String() != expect {
+			t.Fatal("unexpected client subnet of", addr, subnet)
+		}
+	}
+}
+
+func TestECSApply(t *testing.T) {
+	remote := &net.UDPAddr{IP: net.ParseIP("8.8.4.4")}
+	c, _ := parseECS("client")
+
+	// the opt is added and stripped
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	state := c.apply(r, remote)
+	if !state.ecsAdded || !state.optAdded || ecsSubnet(r) != "8.8.4.0/24" {
+		t.Fatal("the ecs should be added", state)
+	}
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Extra = append(msg.Extra, r.IsEdns0())
+	state.strip(msg)
+	if msg.IsEdns0() != nil {
+		t.Fatal("the opt added should be removed")
+	}
+
+	// the ecs is added to the opt of the client and stripped
+	r = new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	r.SetEdns0(1232, true)
+	state = c.apply(r, remote)
+	if !state.ecsAdded || state.optAdded || ecsSubnet(r) != "8.8.4.0/24" {
+		t.Fatal("the ecs should be added to the opt", state)
+	}
+	msg = new(dns.Msg)
+	msg.SetReply(r)
+	msg.Extra = append(msg.Extra, r.Copy().IsEdns0())
+	state.strip(msg)
+	if opt := msg.IsEdns0(); opt == nil || len(opt.Option) != 0 {
+		t.Fatal("only the ecs should be removed")
+	}
+
+	// the client's own ecs is passed through
+	static, _ := parseECS("1.2.3.0/24")
+	r.IsEdns0().Option[0] = newECSOption(&net.IPNet{IP: net.IPv4(5, 6, 7, 0), Mask: net.CIDRMask(24, 32)})
+	if state = static.apply(r, remote); state.ecsAdded || ecsSubnet(r) != "5.6.7.0/24" {
+		t.Fatal("the client ecs should be kept", state)
+	}
+
+	// no ecs of the private client
+	r = new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	if state = c.apply(r, &net.UDPAddr{IP: net.ParseIP("10.0.0.2")}); state.ecsAdded || r.IsEdns0() != nil {
+		t.Fatal("the ecs should not be added for the private client", state)
+	}
+}
+
+// ecsSubnet return the subnet of the ecs of the request
+func ecsSubnet(r *dns.Msg) string {
+	if opt := r.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+				return (&net.IPNet{IP: e.Address, Mask: net.CIDRMask(int(e.SourceNetmask), len(e.Address)*8)}).String()
+			}
+		}
+	}
+	return ""
+}
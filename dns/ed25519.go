@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnssecED25519 is the algorithm of RFC 8080, not known by the vendored dns library, the
+// signature is verified with the signed data rebuilt here
+const dnssecED25519 = 15
+
+// verifySignature verify the rrset with the signature by the key
+func verifySignature(sig *dns.RRSIG, key *dns.DNSKEY, rrs []dns.RR) error {
+	if sig.Algorithm != dnssecED25519 {
+		return sig.Verify(key, rrs)
+	}
+
+	if sig.KeyTag != key.KeyTag() || sig.Algorithm != key.Algorithm || key.Protocol != 3 ||
+		canonicalName(sig.SignerName) != canonicalName(key.Hdr.Name) {
+		return dns.ErrKey
+	}
+
+	if !dns.IsRRset(rrs) || rrs[0].Header().Rrtype != sig.TypeCovered {
+		return dns.ErrRRset
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return dns.ErrKey
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return dns.ErrSig
+	}
+
+	data, err := signedData(sig, rrs)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(pub), data, signature) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+// signedData return the data signed by the RRSIG, the RRSIG RDATA without the signature and the
+// rrset in the canonical form and order (RFC 4034 3.1.8.1 and 6)
+func signedData(sig *dns.RRSIG, rrs []dns.RR) ([]byte, error) {
+	rdata := *sig
+	rdata.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeRRSIG, Class: sig.Hdr.Class}
+	rdata.SignerName = strings.ToLower(sig.SignerName)
+	rdata.Signature = ""
+
+	wire := make([]byte, dns.Len(&rdata)+1)
+	off, err := dns.PackRR(&rdata, wire, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	// the root owner name, type, class, ttl and rdlength
+	data := append([]byte(nil), wire[11:off]...)
+
+	wires := make([][]byte, 0, len(rrs))
+	for _, rr := range rrs {
+		w, err := canonicalWire(rr, sig)
+		if err != nil {
+			return nil, err
+		}
+		wires = append(wires, w)
+	}
+
+	// the owner name, type, class and ttl are the same, sorted by the rdata
+	sort.Slice(wires, func(i, j int) bool { return bytes.Compare(wires[i], wires[j]) < 0 })
+	for i, w := range wires {
+		if i > 0 && bytes.Equal(w, wires[i-1]) {
+			continue
+		}
+		data = append(data, w...)
+	}
+	return data, nil
+}
+
+// canonicalWire return the record in the canonical form, the owner of the wildcard expansion is
+// restored, the names are lowercase and the ttl is the original ttl of the signature
+func canonicalWire(rr dns.RR, sig *dns.RRSIG) ([]byte, error) {
+	rr = dns.Copy(rr)
+	hdr := rr.Header()
+	hdr.Ttl = sig.OrigTtl
+
+	if labels := dns.SplitDomainName(hdr.Name); len(labels) > int(sig.Labels) {
+		hdr.Name = "*." + strings.Join(labels[len(labels)-int(sig.Labels):], ".") + "."
+	}
+	hdr.Name = strings.ToLower(hdr.Name)
+
+	// the types of RFC 4034 6.2 with the domain names in rdata, without HINFO (RFC 6840 5.1)
+	switch x := rr.(type) {
+	case *dns.NS:
+		x.Ns = strings.ToLower(x.Ns)
+	case *dns.CNAME:
+		x.Target = strings.ToLower(x.Target)
+	case *dns.SOA:
+		x.Ns = strings.ToLower(x.Ns)
+		x.Mbox = strings.ToLower(x.Mbox)
+	case *dns.MB:
+		x.Mb = strings.ToLower(x.Mb)
+	case *dns.MG:
+		x.Mg = strings.ToLower(x.Mg)
+	case *dns.MR:
+		x.Mr = strings.ToLower(x.Mr)
+	case *dns.PTR:
+		x.Ptr = strings.ToLower(x.Ptr)
+	case *dns.MINFO:
+		x.Rmail = strings.ToLower(x.Rmail)
+		x.Email = strings.ToLower(x.Email)
+	case *dns.MX:
+		x.Mx = strings.ToLower(x.Mx)
+	case *dns.NAPTR:
+		x.Replacement = strings.ToLower(x.Replacement)
+	case *dns.KX:
+		x.Exchanger = strings.ToLower(x.Exchanger)
+	case *dns.SRV:
+		x.Target = strings.ToLower(x.Target)
+	case *dns.DNAME:
+		x.Target = strings.ToLower(x.Target)
+	}
+
+	wire := make([]byte, dns.Len(rr)+1)
+	off, err := dns.PackRR(rr, wire, 0, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("pack the signed record error %v", err)
+	}
+	return wire[:off], nil
+}
@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSignedData(t *testing.T) {
+	zone := newTestZone(t, "example.")
+	rrs := zone.sign(t,
+		testRR(t, "WWW.Example. 300 IN MX 10 Mail.Example."),
+		testRR(t, "www.example. 300 IN MX 5 mx.example."),
+	)
+	sig := rrs[2].(*dns.RRSIG)
+
+	// the signature of the dns library is verified with the data rebuilt
+	data, err := signedData(sig, rrs[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(sig.Signature)
+	r := new(big.Int).SetBytes(raw[:32])
+	s := new(big.Int).SetBytes(raw[32:])
+	hash := sha256.Sum256(data)
+	if !ecdsa.Verify(&zone.priv.(*ecdsa.PrivateKey).PublicKey, hash[:], r, s) {
+		t.Fatal("signed data should match the dns library")
+	}
+}
+
+func signED25519(t *testing.T, key *dns.DNSKEY, priv ed25519.PrivateKey, rrs ...dns.RR) []dns.RR {
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrs[0].Header().Ttl},
+		TypeCovered: rrs[0].Header().Rrtype,
+		Algorithm:   dnssecED25519,
+		Labels:      uint8(dns.CountLabel(rrs[0].Header().Name)),
+		OrigTtl:     rrs[0].Header().Ttl,
+		KeyTag:      key.KeyTag(),
+		SignerName:  key.Hdr.Name,
+		Inception:   uint32(now.Add(-time.Hour).Unix()),
+		Expiration:  uint32(now.Add(time.Hour).Unix()),
+	}
+
+	data, err := signedData(sig, rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+	return append(rrs, sig)
+}
+
+func TestValidatorED25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dnssecED25519,
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+	}
+
+	anchors, err := parseTrustAnchors([]string{key.ToDS(dns.SHA256).String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := &dns.Msg{Answer: signED25519(t, key, priv, key)}
+	v := &validator{
+		anchors: anchors,
+		zones:   make(map[string]*zoneKeys),
+		cuts:    make(map[string]*zoneCut),
+		query: func(name string, qtype uint16) (*dns.Msg, error) {
+			return keys, nil
+		},
+	}
+
+	msg := &dns.Msg{Answer: signED25519(t, key, priv, testRR(t, "www.example. 300 IN A 192.0.2.1"))}
+	secure, err := v.validate(msg, time.Now())
+	if err != nil || !secure {
+		t.Fatal("answer signed by ED25519 should be secure", err)
+	}
+
+	msg.Answer[0].(*dns.A).A[3] = 2
+	if _, err = v.validate(msg, time.Now()); err == nil {
+		t.Fatal("tampered answer signed by ED25519 should be bogus")
+	}
+}
@@ -0,0 +1,217 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ednsServerUDPSize is the udp payload size of the server, as the dns flag day 2020
+	ednsServerUDPSize = 1232
+	// plainUDPSize is the max udp response size of the request without edns0
+	plainUDPSize = 512
+	// clientCookieLen is the length of the client cookie, the server cookie is 8 to 32 bytes
+	clientCookieLen = 8
+	serverCookieLen = 8
+)
+
+// cookieSecret sign the server cookies, changed on restart
+var cookieSecret = newCookieSecret()
+
+func newCookieSecret() []byte {
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	return secret
+}
+
+// ednsState is the edns0 of the client request, saved before the ecs is added for the upstream
+type ednsState struct {
+	present bool
+	udpSize uint16
+	do      bool
+	// cookie is the client cookie in hex, empty if not sent
+	cookie string
+	remote net.IP
+	udp    bool
+}
+
+func newEDNSState(r *dns.Msg, remote net.Addr) ednsState {
+	var s ednsState
+	switch addr := remote.(type) {
+	case *net.UDPAddr:
+		s.remote, s.udp = addr.IP, true
+	case *net.TCPAddr:
+		s.remote = addr.IP
+	}
+
+	opt := r.IsEdns0()
+	if opt == nil {
+		return s
+	}
+
+	s.present = true
+	s.udpSize = opt.UDPSize()
+	s.do = opt.Do()
+	for _, o := range opt.Option {
+		if c, ok := o.(*dns.EDNS0_COOKIE); ok && len(c.Cookie) >= clientCookieLen*2 {
+			s.cookie = c.Cookie[:clientCookieLen*2]
+		}
+	}
+	return s
+}
+
+// checkRequest return the error response and the outcome of the request not supported, NOTIMP
+// for the opcodes other than QUERY, FORMERR for the count of questions other than 1 or the
+// malformed cookie, BADVERS for the edns0 version other than 0, REFUSED for the classes other
+// than INET, nil if the request is supported
+func checkRequest(r *dns.Msg) (*dns.Msg, string) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+
+	if r.Opcode != dns.OpcodeQuery {
+		msg.Rcode = dns.RcodeNotImplemented
+		return msg, "notimp"
+	}
+
+	if len(r.Question) != 1 {
+		msg.Rcode = dns.RcodeFormatError
+		return msg, "formerr"
+	}
+
+	if opt := r.IsEdns0(); opt != nil {
+		if opt.Version() != 0 {
+			// the extended rcode is set on the OPT, the 4 bits of BADVERS in the header are 0
+			reply := newOPT(ednsServerUDPSize, false)
+			reply.Hdr.Ttl |= uint32(dns.RcodeBadVers>>4) << 24
+			msg.Extra = append(msg.Extra, reply)
+			return msg, "badvers"
+		}
+
+		for _, o := range opt.Option {
+			c, ok := o.(*dns.EDNS0_COOKIE)
+			if !ok {
+				continue
+			}
+			if n := len(c.Cookie) / 2; n != clientCookieLen && (n < clientCookieLen+8 || n > clientCookieLen+32) {
+				msg.Rcode = dns.RcodeFormatError
+				return msg, "formerr"
+			}
+		}
+	}
+
+	if r.Question[0].Qclass != dns.ClassINET {
+		msg.Rcode = dns.RcodeRefused
+		return msg, "refused"
+	}
+	return nil, ""
+}
+
+// reply set the OPT of the response by the request, no OPT if the request has none, otherwise
+// the OPT of the server with the DO bit and the cookie echoed, the options of the upstream are
+// kept except the cookie, the response is compressed and truncated to the udp size of the client
+// over udp
+func (s ednsState) reply(msg *dns.Msg) {
+	var upstream *dns.OPT
+	extra := make([]dns.RR, 0, len(msg.Extra)+1)
+	for _, rr := range msg.Extra {
+		if opt, ok := rr.(*dns.OPT); ok {
+			upstream = opt
+			continue
+		}
+		extra = append(extra, rr)
+	}
+
+	var opt *dns.OPT
+	if s.present {
+		opt = newOPT(ednsServerUDPSize, s.do)
+		if upstream != nil {
+			// keep the extended rcode
+			opt.Hdr.Ttl |= upstream.Hdr.Ttl & 0xFF000000
+			for _, o := range upstream.Option {
+				if o.Option() != dns.EDNS0COOKIE {
+					opt.Option = append(opt.Option, o)
+				}
+			}
+		}
+		if s.cookie != "" {
+			opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: s.cookie + s.serverCookie()})
+		}
+		extra = append(extra, opt)
+	}
+	msg.Extra = extra
+
+	// the large answer sets of the upstream fit in less packets
+	msg.Compress = true
+	if !s.udp {
+		return
+	}
+
+	size := plainUDPSize
+	if s.present && int(s.udpSize) > size {
+		size = int(s.udpSize)
+		if size > ednsServerUDPSize {
+			size = ednsServerUDPSize
+		}
+	}
+
+	truncate(msg, size)
+}
+
+// truncate fit the compressed response in the size, the additional records are dropped first,
+// then the answer and the authority records not fit with TC set, the client retry over tcp
+func truncate(msg *dns.Msg, size int) {
+	msg.Compress = true
+	if msg.Len() <= size {
+		return
+	}
+
+	answer, ns, opt := msg.Answer, msg.Ns, msg.IsEdns0()
+	msg.Answer, msg.Ns, msg.Extra = nil, nil, nil
+	if opt != nil {
+		msg.Extra = []dns.RR{opt}
+	}
+
+	if fit(msg, &msg.Answer, answer, size) < len(answer) {
+		msg.Truncated = true
+		return
+	}
+	if fit(msg, &msg.Ns, ns, size) < len(ns) {
+		msg.Truncated = true
+	}
+}
+
+// fit append the records to the section of the response until exceed the size, return the
+// count of the records appended
+func fit(msg *dns.Msg, section *[]dns.RR, rrs []dns.RR, size int) int {
+	for i, rr := range rrs {
+		*section = append(*section, rr)
+		if msg.Len() > size {
+			*section = (*section)[:i]
+			return i
+		}
+	}
+	return len(rrs)
+}
+
+// serverCookie return the server cookie in hex of the client cookie and the client address
+func (s ednsState) serverCookie() string {
+	mac := hmac.New(sha256.New, cookieSecret)
+	client, _ := hex.DecodeString(s.cookie)
+	mac.Write(client)
+	mac.Write(s.remote)
+	return hex.EncodeToString(mac.Sum(nil)[:serverCookieLen])
+}
+
+func newOPT(udpSize uint16, do bool) *dns.OPT {
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(udpSize)
+	if do {
+		opt.SetDo()
+	}
+	return opt
+}
@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckRequest(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("google.com.", dns.TypeA)
+	if msg, _ := checkRequest(r); msg != nil {
+		t.Fatal("the query should be supported")
+	}
+
+	cases := map[string]func(r *dns.Msg) int{
+		"notimp": func(r *dns.Msg) int {
+			r.Opcode = dns.OpcodeNotify
+			return dns.RcodeNotImplemented
+		},
+		"no question": func(r *dns.Msg) int {
+			r.Question = nil
+			return dns.RcodeFormatError
+		},
+		"questions": func(r *dns.Msg) int {
+			r.Question = append(r.Question, r.Question[0])
+			return dns.RcodeFormatError
+		},
+		"class": func(r *dns.Msg) int {
+			r.Question[0].Qclass = dns.ClassCHAOS
+			return dns.RcodeRefused
+		},
+		"cookie": func(r *dns.Msg) int {
+			r.SetEdns0(4096, false)
+			r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102"})
+			return dns.RcodeFormatError
+		},
+	}
+
+	for name, mutate := range cases {
+		r := new(dns.Msg)
+		r.SetQuestion("google.com.", dns.TypeA)
+		rcode := mutate(r)
+
+		msg, _ := checkRequest(r)
+		if msg == nil || msg.Rcode != rcode || msg.Id != r.Id {
+			t.Fatalf("%s: unexpected response %v", name, msg)
+		}
+		if _, err := msg.Pack(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	// the extended rcode of BADVERS survive the pack
+	r.SetEdns0(4096, false)
+	r.IsEdns0().SetVersion(1)
+	msg, outcome := checkRequest(r)
+	if outcome != "badvers" {
+		t.Fatal("unexpected outcome", outcome)
+	}
+	data, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(data); err != nil {
+		t.Fatal(err)
+	}
+	if opt := resp.IsEdns0(); opt == nil || opt.ExtendedRcode() != dns.RcodeBadVers {
+		t.Fatalf("unexpected BADVERS response %v", resp)
+	}
+}
+
+func TestEDNSReply(t *testing.T) {
+	remote := &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353}
+	upstream := func() *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion("google.com.", dns.TypeTXT)
+		msg.SetEdns0(4096, true)
+		msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbb"})
+		return msg
+	}
+
+	// no OPT for the request without edns0
+	r := new(dns.Msg)
+	r.SetQuestion("google.com.", dns.TypeTXT)
+	msg := upstream()
+	newEDNSState(r, remote).reply(msg)
+	if msg.IsEdns0() != nil {
+		t.Fatal("the response should not have OPT")
+	}
+
+	// the server OPT with the cookie
+	r.SetEdns0(1400, true)
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"})
+	msg = upstream()
+	newEDNSState(r, remote).reply(msg)
+	opt := msg.IsEdns0()
+	if opt == nil || opt.UDPSize() != ednsServerUDPSize || !opt.Do() || len(opt.Option) != 1 {
+		t.Fatalf("unexpected OPT %v", opt)
+	}
+	cookie := opt.Option[0].(*dns.EDNS0_COOKIE).Cookie
+	if !strings.HasPrefix(cookie, "0102030405060708") || len(cookie) != (clientCookieLen+serverCookieLen)*2 {
+		t.Fatalf("the client cookie should be echoed with the server cookie, %s", cookie)
+	}
+
+	// truncated to the client udp size
+	r = new(dns.Msg)
+	r.SetQuestion("google.com.", dns.TypeTXT)
+	msg = upstream()
+	for i := 0; i < 10; i++ {
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: "google.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{strings.Repeat("x", 100)},
+		})
+	}
+	tcp := msg.Copy()
+	newEDNSState(r, remote).reply(msg)
+	if !msg.Truncated || len(msg.Answer) == 0 || len(msg.Answer) == 10 || msg.Len() > plainUDPSize {
+		t.Fatal("the large response over udp should be truncated")
+	}
+	if _, err := msg.Pack(); err != nil {
+		t.Fatal(err)
+	}
+
+	newEDNSState(r, &net.TCPAddr{IP: remote.IP}).reply(tcp)
+	if tcp.Truncated || len(tcp.Answer) != 10 {
+		t.Fatal("the response over tcp should not be truncated")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.SetEdns0(ednsServerUDPSize, false)
+	for i := 0; i < 25; i++ {
+		msg.Answer = append(msg.Answer, newARecord("www.example.com.", net.IPv4(10, 0, 0, byte(i)), 60))
+	}
+
+	// fit only with the names compressed
+	msg.Compress = false
+	if msg.Len() <= plainUDPSize {
+		t.Fatal("the uncompressed response should exceed", msg.Len())
+	}
+	truncate(msg, plainUDPSize)
+	if msg.Truncated || len(msg.Answer) != 25 {
+		t.Fatal("the compressed response should fit")
+	}
+
+	// the additional records are dropped without TC
+	msg.Extra = append(msg.Extra, &dns.TXT{
+		Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+		Txt: []string{strings.Repeat("x", 200)},
+	})
+	truncate(msg, plainUDPSize)
+	if msg.Truncated || len(msg.Answer) != 25 || len(msg.Extra) != 1 || msg.IsEdns0() == nil {
+		t.Fatalf("only the additional records should be dropped, %v", msg)
+	}
+
+	truncate(msg, 100)
+	if !msg.Truncated || len(msg.Answer) >= 25 || msg.Len() > 100 || msg.IsEdns0() == nil {
+		t.Fatalf("the answers should be truncated, %v", msg)
+	}
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ruleEvent is the event of the query matched the proxy or reject rules
+type ruleEvent struct {
+	Client string `json:"client"`
+	Domain string `json:"domain"`
+	// Action is proxy or reject
+	Action string `json:"action"`
+	// Rule is the rule matched, gfwlist, cname:<domain>, geoip:<ip> or reject:<list>
+	Rule string `json:"rule"`
+}
+
+// publishEvents publish the query event and the rule event of the proxied or rejected query
+func (h *handler) publishEvents(remote net.Addr, r *dns.Msg, msg *dns.Msg, info *queryInfo, outcome string, elapsed time.Duration) {
+	events := h.server.events
+	if events.Active("query") {
+		events.Publish("query", newQueryLogEntry(remote, r, msg, info, outcome, elapsed))
+	}
+
+	if !events.Active("rule") {
+		return
+	}
+
+	e := &ruleEvent{Domain: strings.TrimSuffix(r.Question[0].Name, ".")}
+	switch {
+	case outcome == "reject":
+		e.Action, e.Rule = "reject", "reject:"+info.reject
+	case outcome != "internal":
+		return
+	case info.cname != "":
+		e.Action, e.Rule = "proxy", "cname:"+strings.TrimSuffix(info.cname, ".")
+	case info.geoip != "":
+		e.Action, e.Rule = "proxy", "geoip:"+info.geoip
+	default:
+		e.Action, e.Rule = "proxy", "gfwlist"
+	}
+
+	if host, _, err := net.SplitHostPort(remote.String()); err == nil {
+		e.Client = host
+	}
+	events.Publish("rule", e)
+}
+
+// events stream the query and rule events over websocket
+func (a *adminHandler) events(w http.ResponseWriter, r *http.Request) {
+	if a.server.events == nil {
+		writeError(w, http.StatusNotFound, "events not enabled")
+		return
+	}
+	a.server.events.Handler().ServeHTTP(w, r)
+}
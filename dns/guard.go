@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// guardMaxClients prune the idle clients if the tracked clients grows beyond
+const guardMaxClients = 65536
+
+// the verdict of the client guard
+const (
+	guardAllow = iota
+	// guardRefuse answer REFUSED, the client is denied by acl
+	guardRefuse
+	// guardDrop drop the query without answer, the client exceed the rate limit
+	guardDrop
+)
+
+// clientGuard check the client acl and limit the query rate of each client ip, so the
+// server exposed on LAN or VPS can't be abused as open resolver or amplification vector
+type clientGuard struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	qps   float64
+	burst float64
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket is the rate limit state of a client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newClientGuard create the guard of the config, nil if neither acl nor rate limit configured
+func newClientGuard(config *internal.Config) (*clientGuard, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	allow, err := internal.ParseSubnets(config.DNS.ACL.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := internal.ParseSubnets(config.DNS.ACL.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := config.DNS.RateLimit
+	if len(allow) == 0 && len(deny) == 0 && limit.QPS <= 0 {
+		return nil, nil
+	}
+
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = limit.QPS
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &clientGuard{
+		allow:   allow,
+		deny:    deny,
+		qps:     limit.QPS,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}, nil
+}
+
+// check the client of the query
+func (g *clientGuard) check(addr net.Addr, now time.Time) int {
+	if g == nil {
+		return guardAllow
+	}
+
+	ip := addrIP(addr)
+	if ip == nil {
+		return guardAllow
+	}
+
+	if !g.allowed(ip) {
+		return guardRefuse
+	}
+
+	if g.qps > 0 && !g.take(ip.String(), now) {
+		return guardDrop
+	}
+	return guardAllow
+}
+
+// allowed check the acl, the deny list is checked first
+func (g *clientGuard) allowed(ip net.IP) bool {
+	for _, subnet := range g.deny {
+		if subnet.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(g.allow) == 0 {
+		return true
+	}
+
+	for _, subnet := range g.allow {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// take a token of the client, false if the bucket is empty
+func (g *clientGuard) take(client string, now time.Time) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	b := g.buckets[client]
+	if b == nil {
+		if len(g.buckets) >= guardMaxClients {
+			g.prune(now)
+		}
+
+		b = &tokenBucket{tokens: g.burst, last: now}
+		g.buckets[client] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * g.qps
+	if b.tokens > g.burst {
+		b.tokens = g.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// prune the clients whose bucket is refilled, they are the same as new clients
+func (g *clientGuard) prune(now time.Time) {
+	full := time.Duration(g.burst / g.qps * float64(time.Second))
+	for client, b := range g.buckets {
+		if now.Sub(b.last) >= full {
+			delete(g.buckets, client)
+		}
+	}
+}
+
+// addrIP return the ip of the udp or tcp address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestClientGuard(t *testing.T) {
+	config := &internal.Config{}
+	if g, err := newClientGuard(config); err != nil || g != nil {
+		t.Fatal("guard should be disabled by default")
+	}
+
+	config.DNS.ACL.Allow = []string{"192.168.0.0/16", "127.0.0.1"}
+	config.DNS.ACL.Deny = []string{"192.168.1.0/24"}
+	config.DNS.RateLimit = internal.DNSRateLimit{QPS: 1, Burst: 2}
+
+	g, err := newClientGuard(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	addr := func(ip string) net.Addr {
+		return &net.UDPAddr{IP: net.ParseIP(ip), Port: 53}
+	}
+
+	tests := []struct {
+		ip      string
+		verdict int
+	}{
+		{"127.0.0.1", guardAllow},
+		{"192.168.0.2", guardAllow},
+		{"192.168.1.2", guardRefuse},
+		{"10.0.0.1", guardRefuse},
+	}
+
+	for _, test := range tests {
+		if v := g.check(addr(test.ip), now); v != test.verdict {
+			t.Fatalf("%s expect verdict %d, got %d", test.ip, test.verdict, v)
+		}
+	}
+
+	// burst 2, the first is taken above
+	if v := g.check(addr("192.168.0.2"), now); v != guardAllow {
+		t.Fatalf("expect allow within burst, got %d", v)
+	}
+
+	if v := g.check(addr("192.168.0.2"), now); v != guardDrop {
+		t.Fatalf("expect drop exceed burst, got %d", v)
+	}
+
+	// refilled 1 token per second
+	if v := g.check(addr("192.168.0.2"), now.Add(time.Second)); v != guardAllow {
+		t.Fatalf("expect allow after refill, got %d", v)
+	}
+
+	config.DNS.ACL.Deny = []string{"bad"}
+	if _, err := newClientGuard(config); err == nil {
+		t.Fatal("invalid subnet should be rejected")
+	}
+}
@@ -1,9 +1,10 @@
 package dns
 
 import (
-	"fmt"
 	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/geoip"
 	"github.com/yinheli/kungfu/internal"
+	"github.com/yinheli/kungfu/tracing"
 	"net"
 	"runtime/debug"
 	"strings"
@@ -11,21 +12,45 @@ import (
 	"time"
 )
 
-// DEFAULT_TTL default dns ttl (internal resolve result response)
-const DEFAULT_TTL = time.Duration(time.Hour * 3)
+// DEFAULT_TTL default ttl of the domain mapping (internal resolve result response)
+const DEFAULT_TTL = internal.DefaultMappingTTL
 
 // DNS_SERVER_NAME the dns server name for response PTR
 const DNS_SERVER_NAME = "kungfu-dns-server-helps-you-automatic-climb-the-wall."
 
 type handler struct {
 	server     *Server
-	client     *dns.Client
-	nameserver []string
+	nameserver []*upstream
+	cache      *domainCache
+	flight     singleflight
+	ecs        *ecsConfig
+	negative   *negativeCache
+	answers    *answerCache
+	hosts      *hosts
+	// race is the count of upstreams to query concurrently, 0 for sequential
+	race     int
+	poison   *poisonGuard
+	querylog *queryLogger
+	ttl      ttlPolicy
+	proxied  *proxiedQuery
+	guard    *clientGuard
+	dnssec   *validator
+	dns64    *dns64
+	local    *localZones
+	clients  *clientGroups
 
 	lock sync.Mutex
+	// configLock guard the nameserver, race, hosts, ttl, proxied, guard, dnssec, dns64, local and
+	// clients, swapped on reload
+	configLock sync.RWMutex
+	// inflight is the queries in process, waited on shutdown
+	inflight sync.WaitGroup
 }
 
 func (h *handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	h.inflight.Add(1)
+	defer h.inflight.Done()
+
 	defer func() {
 		if x := recover(); x != nil {
 			log.Error("ServeDNS error", x)
@@ -41,20 +66,85 @@ func (h *handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		return
 	}
 
+	if msg, outcome := checkRequest(r); msg != nil {
+		qtype := "none"
+		if len(r.Question) > 0 {
+			qtype = dns.Type(r.Question[0].Qtype).String()
+		}
+		queriesTotal.Inc(qtype, outcome)
+		writeMsg(w, msg)
+		return
+	}
+
 	question := r.Question[0]
 
+	switch h.getGuard().check(w.RemoteAddr(), time.Now()) {
+	case guardRefuse:
+		queriesTotal.Inc(dns.Type(question.Qtype).String(), "refused")
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeRefused)
+		writeMsg(w, msg)
+		return
+	case guardDrop:
+		queriesTotal.Inc(dns.Type(question.Qtype).String(), "ratelimited")
+		return
+	}
+
+	// before the ecs added to the request
+	edns := newEDNSState(r, w.RemoteAddr())
+	ecs := h.ecs.apply(r, w.RemoteAddr())
+
 	var msg *dns.Msg
 	var err error
-
-	if question.Qtype == dns.TypePTR {
-		msg, err = h.resolveInternalPTR(r)
-	} else {
-		isIPV4A := isIPV4TypeAQuery(&question)
-		if isIPV4A {
-			msg, err = h.resolveInternal(r)
-		} else {
-			msg, err = h.resolveUpstream(r)
+	var outcome string
+	info := new(queryInfo)
+	start := time.Now()
+	group := h.getClients().lookup(addrIP(w.RemoteAddr()))
+
+	info.span = h.server.tracer.StartSpan("dns query", tracing.KindServer)
+	defer info.span.End()
+	info.span.SetAttr("dns.qname", question.Name)
+	info.span.SetAttr("dns.qtype", dns.Type(question.Qtype).String())
+	info.span.SetAttr("client.address", addrIP(w.RemoteAddr()).String())
+	info.span.SetAttr("client.group", group.String())
+
+	if msg = h.getHosts().resolve(r); msg != nil {
+		outcome = "hosts"
+		log.Debug("resolve %s qtype: %s by static hosts", question.Name, dns.Type(question.Qtype).String())
+	} else if h.getLocal().match(question.Name) {
+		outcome = "local"
+		msg, err = h.resolveLocal(r, info)
+	} else if list := h.server.matchReject(question.Name, group); list != nil {
+		outcome = "reject"
+		info.reject = list.name
+		msg, err = h.resolveReject(r, list)
+	} else if question.Qtype == dns.TypePTR {
+		outcome = "ptr"
+		msg, err = h.resolveInternalPTR(r, info)
+	} else if group.isDirect() {
+		outcome = "upstream"
+		msg, err = h.resolveDirect(r, info)
+	} else if isIPV4TypeAQuery(&question) || isIPV6TypeAAAAQuery(&question) {
+		outcome = "upstream"
+		if h.isDomainInGfwlist(question.Name) {
+			outcome = "internal"
+		}
+		msg, err = h.resolveShared(r, info, h.resolveInternal)
+		if info.cname != "" || info.geoip != "" {
+			outcome = "internal"
 		}
+	} else if isServiceBindingQuery(&question) {
+		outcome = "upstream"
+		msg, err = h.resolveShared(r, info, h.resolveServiceBinding)
+		if info.cname != "" || h.isDomainInGfwlist(question.Name) {
+			outcome = "internal"
+		}
+	} else if h.isDomainInGfwlist(question.Name) {
+		outcome = "internal"
+		msg, err = h.resolveShared(r, info, h.resolveProxied)
+	} else {
+		outcome = "upstream"
+		msg, err = h.resolveShared(r, info, h.resolveUpstream)
 	}
 
 	if err != nil {
@@ -68,114 +158,243 @@ func (h *handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}
 
 	if err != nil || msg == nil {
-		dns.HandleFailed(w, r)
+		outcome = "fail"
+	}
+	info.span.SetError(err)
+	info.span.SetAttr("dns.outcome", outcome)
+	if msg != nil {
+		info.span.SetAttr("dns.rcode", dns.RcodeToString[msg.Rcode])
+	}
+	queriesTotal.Inc(dns.Type(question.Qtype).String(), outcome)
+	h.server.stats.record(question.Name, outcome, start)
+	h.server.recordHourly(question.Name, outcome, start)
+	h.server.prefetch.record(question, outcome)
+	if group.logged() {
+		h.querylog.log(w.RemoteAddr(), r, msg, info, outcome, time.Since(start))
+		h.publishEvents(w.RemoteAddr(), r, msg, info, outcome, time.Since(start))
+	}
+
+	if err != nil || msg == nil {
+		msg = new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeServerFailure)
 	} else {
-		w.WriteMsg(msg)
+		ecs.strip(msg)
+	}
+	edns.reply(msg)
+	writeMsg(w, msg)
+}
+
+// upstreams return the upstream nameservers and the count to race
+func (h *handler) upstreams() ([]*upstream, int) {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.nameserver, h.race
+}
+
+func (h *handler) getHosts() *hosts {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.hosts
+}
+
+func (h *handler) getGuard() *clientGuard {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.guard
+}
+
+func (h *handler) getTTL() ttlPolicy {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.ttl
+}
+
+// resolveShared resolve the same question only once for concurrent requests
+func (h *handler) resolveShared(r *dns.Msg, info *queryInfo, resolve func(*dns.Msg, *queryInfo) (*dns.Msg, error)) (*dns.Msg, error) {
+	msg, err, shared := h.flight.do(msgCacheKey(r), info, func(info *queryInfo) (*dns.Msg, error) {
+		return resolve(r, info)
+	})
+
+	if err != nil || msg == nil || !shared {
+		return msg, err
 	}
 
+	msg = msg.Copy()
+	msg.Id = r.Id
+	// keep the question case of this request, the answer names are not touched
+	msg.Question = append([]dns.Question(nil), r.Question...)
+	return msg, nil
 }
 
 func isIPV4TypeAQuery(q *dns.Question) bool {
 	return q.Qclass == dns.ClassINET && q.Qtype == dns.TypeA
 }
 
+func isIPV6TypeAAAAQuery(q *dns.Question) bool {
+	return q.Qclass == dns.ClassINET && q.Qtype == dns.TypeAAAA
+}
+
+func getDomainKey(qtype uint16, qname string) string {
+	if qtype == dns.TypeAAAA {
+		return internal.GetRedisDomain6Key(qname)
+	}
+	return internal.GetRedisDomainKey(qname)
+}
+
 func (h *handler) queryDomainCache(r *dns.Msg) *dns.Msg {
 	qname := r.Question[0].Name
-	redis := h.server.RedisClient
-	qnameKey := internal.GetRedisDomainKey(qname)
+	qtype := r.Question[0].Qtype
+	store := h.server.Store
+	qnameKey := getDomainKey(qtype, qname)
+
+	ip, ttl := h.cache.get(qnameKey)
+	observeCache("domain", ip != nil)
+	if ip != nil {
+		h.server.toucher.Touch(ip)
+		msg := newInternalReply(r, ip, h.getTTL().answerTTL(ttl))
+		log.Debug("internal resolve %s result: %s, ttl: %d (memory)", qname, ip, msg.Answer[0].Header().Ttl)
+		return msg
+	}
 
-	ttl, err := redis.TTL(qnameKey).Result()
+	ttl, err := store.TTL(qnameKey)
 	if err != nil {
-		log.Error("redis check %s error %v", qname, err)
-		return nil
+		if err != internal.ErrStoreUnavailable {
+			log.Error("redis check %s error %v", qname, err)
+		}
+		return h.staleInternalReply(r, qnameKey)
 	}
 
 	if ttl > 1 {
-		ip, err := redis.Get(qnameKey).Result()
+		ip, err := store.Get(qnameKey)
 		if err != nil {
-			log.Error("redis get %s error %v", qname, err)
-			return nil
+			if err != internal.ErrStoreUnavailable {
+				log.Error("redis get %s error %v", qname, err)
+			}
+			return h.staleInternalReply(r, qnameKey)
 		}
 
-		msg := new(dns.Msg)
-		msg.SetReply(r)
-		a := newARecord(qname, net.ParseIP(ip), uint32(ttl.Seconds()))
-		msg.Answer = append(msg.Answer, a)
-		log.Debug("internal resolve %s result: %s, ttl: %d", qname, ip, a.Hdr.Ttl)
+		h.cache.set(qnameKey, net.ParseIP(ip), ttl)
+		h.server.toucher.Touch(net.ParseIP(ip))
+
+		msg := newInternalReply(r, net.ParseIP(ip), h.getTTL().answerTTL(ttl))
+		log.Debug("internal resolve %s result: %s, ttl: %d", qname, ip, msg.Answer[0].Header().Ttl)
 		return msg
 	}
 
 	return nil
 }
 
-func (h *handler) resolveInternal(r *dns.Msg) (*dns.Msg, error) {
-	msg := h.queryDomainCache(r)
-	if msg != nil {
-		return msg, nil
+// staleInternalReply answer the stale mapping in memory if redis is unavailable, nil if none
+func (h *handler) staleInternalReply(r *dns.Msg, key string) *dns.Msg {
+	ip := h.cache.getStale(key)
+	if ip == nil {
+		return nil
 	}
 
-	h.lock.Lock()
-	defer h.lock.Unlock()
-
-	qname := r.Question[0].Name
-	redis := h.server.RedisClient
+	log.Warning("internal resolve %s result: %s, serve stale mapping", r.Question[0].Name, ip)
+	return newInternalReply(r, ip, time.Duration(staleTTL)*time.Second)
+}
 
-	if !h.isDomainInGfwlist(qname) {
-		return h.resolveUpstream(r)
+func (h *handler) resolveInternal(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
+	if syncer := h.server.getIPSet(); syncer != nil {
+		return h.resolveSynced(r, info, syncer)
 	}
 
-	// recheck
-	msg = h.queryDomainCache(r)
+	lookup := info.span.Child("store lookup", tracing.KindClient)
+	msg := h.queryDomainCache(r)
+	lookup.SetAttr("mapping.hit", msg != nil)
+	lookup.End()
 	if msg != nil {
 		return msg, nil
 	}
 
-	qnameKey := internal.GetRedisDomainKey(qname)
+	qname := r.Question[0].Name
 
-	currentIpKey := internal.GetRedisKey("current-ip")
+	if !h.isDomainInGfwlist(qname) {
+		resp, err := h.resolveUpstream(r, info)
+		if err != nil || resp == nil {
+			return resp, err
+		}
 
-	ipInt, err := redis.Incr(currentIpKey).Result()
-	if err != nil {
-		return nil, err
+		// reached a proxied domain via cname, answer the fake ip of qname with the chain flattened
+		if info.cname = h.matchCNAME(resp); info.cname != "" {
+			log.Debug("internal resolve %s, proxied by cname %s", qname, info.cname)
+		} else if info.geoip = h.matchGeoIP(resp); info.geoip != "" {
+			log.Debug("internal resolve %s, proxied by geoip of %s", qname, info.geoip)
+		} else if d := h.dns64For(r); d != nil && resp.Rcode == dns.RcodeSuccess && !hasAAAA(resp) {
+			return h.resolveDNS64(r, info, d, h.resolveUpstream)
+		} else {
+			return resp, nil
+		}
 	}
 
-	ipValue := (h.server.minIp + uint32(ipInt)) % h.server.maxIp
-
-	ip := internal.IntToIpv4(ipValue)
-
-	ipStr := ip.String()
+	var err error
+	allocate := info.span.Child("store allocate", tracing.KindClient)
+	if d := h.dns64For(r); d != nil && !h.server.getPool(dns.TypeAAAA).configured() {
+		// ipv6 network not configured, answer the fake ipv4 embedded in the NAT64 prefix
+		msg, err = h.resolveDNS64(r, info, d, func(req *dns.Msg, _ *queryInfo) (*dns.Msg, error) {
+			return h.allocateInternal(req)
+		})
+	} else {
+		msg, err = h.allocateInternal(r)
+	}
+	allocate.SetError(err)
+	allocate.End()
+	if err == internal.ErrStoreUnavailable {
+		// degrade to the real address, better than failing all the proxied domains
+		log.Debug("internal resolve %s, store unavailable, bypass fake ip", qname)
+		return h.resolveUpstream(r, info)
+	}
+	return msg, err
+}
 
-	qnameIpKey := internal.GetRedisIpKey(ipStr)
+// allocateInternal answer the fake ip of the query, allocate if not mapped
+func (h *handler) allocateInternal(r *dns.Msg) (*dns.Msg, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
 
-	success, err := redis.SetNX(qnameIpKey, strings.TrimSuffix(qname, "."), DEFAULT_TTL).Result()
-	if err != nil {
-		return nil, err
+	// recheck
+	msg := h.queryDomainCache(r)
+	if msg != nil {
+		return msg, nil
 	}
 
-	if !success {
-		return nil, fmt.Errorf("update ip cache fail: duplicate key: %s, %s", qnameIpKey, qname)
+	qname := r.Question[0].Name
+	qtype := r.Question[0].Qtype
+	qnameKey := getDomainKey(qtype, qname)
+
+	pool := h.server.getPool(qtype)
+	if !pool.configured() {
+		// ipv6 network not configured, answer empty to avoid leak the real address
+		msg = newEmptyReply(r)
+		log.Debug("internal resolve %s qtype: %s, empty answer", qname, dns.Type(qtype).String())
+		return msg, nil
 	}
 
-	success, err = redis.SetNX(qnameKey, ipStr, DEFAULT_TTL).Result()
+	policy := h.getTTL()
+	ttl := policy.mappingTTL()
+	ip, err := pool.allocate(qnameKey, strings.TrimSuffix(qname, "."), ttl)
 	if err != nil {
-		redis.Del(qnameIpKey)
 		return nil, err
 	}
 
-	if !success {
-		redis.Del(qnameIpKey)
-		return nil, fmt.Errorf("update domain cache fail: duplicate key: %s, %s", qnameKey, ipStr)
-	}
+	h.cache.set(qnameKey, ip, ttl)
+	h.server.toucher.Touch(ip)
 
-	msg = new(dns.Msg)
-	msg.SetReply(r)
-	a := newARecord(qname, ip, uint32(DEFAULT_TTL.Seconds()))
-	msg.Answer = append(msg.Answer, a)
-	log.Debug("internal *new resolve %s result: %s, ttl: %d", qname, ip, a.Hdr.Ttl)
+	msg = newInternalReply(r, ip, policy.answerTTL(ttl))
+	log.Debug("internal *new resolve %s result: %s, ttl: %d", qname, ip, msg.Answer[0].Header().Ttl)
 	return msg, nil
 }
 
-func (h *handler) resolveInternalPTR(r *dns.Msg) (*dns.Msg, error) {
+func newInternalReply(r *dns.Msg, ip net.IP, ttl time.Duration) *dns.Msg {
+	q := r.Question[0]
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Answer = append(msg.Answer, newIPRecord(q.Qtype, q.Name, ip, uint32(ttl.Seconds())))
+	return msg
+}
+
+func (h *handler) resolveInternalPTR(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
 	qname := r.Question[0].Name
 
 	if h.server.arpaContains(&qname) {
@@ -193,70 +412,166 @@ func (h *handler) resolveInternalPTR(r *dns.Msg) (*dns.Msg, error) {
 		return msg, nil
 	}
 
-	return h.resolveUpstream(r)
+	if msg, err := h.resolveFakePTR(r); msg != nil || err != nil {
+		return msg, err
+	}
+
+	return h.resolveUpstream(r, info)
 }
 
-func (h *handler) resolveUpstream(r *dns.Msg) (*dns.Msg, error) {
+func (h *handler) resolveUpstream(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
 	qname := r.Question[0].Name
 	qtype := dns.Type(r.Question[0].Qtype).String()
 
-	var err error
-	for _, ns := range h.nameserver {
-		r, _, err = h.client.Exchange(r, ns)
-		if err != nil {
-			log.Error("resolve upstream %s on %s qtype: %s error %v", qname, ns, qtype, err)
-			continue
+	cached, remain, refresh := h.answers.get(r)
+	observeCache("answer", cached != nil && remain > 0)
+	if cached != nil && remain > 0 {
+		info.upstream = "cache"
+		log.Debug("resolve upstream %s qtype: %s, code: %d (cache)", qname, qtype, cached.Rcode)
+		if refresh {
+			go h.refreshAnswer(r.Copy())
 		}
+		return cached, nil
+	}
 
-		if r.Rcode == dns.RcodeServerFailure {
-			log.Error("resolve upstream %s on %s qtype: %s fail code %d", qname, ns, qtype, r.Rcode)
-			continue
-		}
+	// the stale answer is preferred to the cached SERVFAIL
+	msg := h.negative.get(r)
+	observeCache("negative", msg != nil)
+	if msg != nil && !(cached != nil && msg.Rcode == dns.RcodeServerFailure) {
+		info.upstream = "negative-cache"
+		log.Debug("resolve upstream %s qtype: %s, code: %d (negative cache)", qname, qtype, msg.Rcode)
+		return msg, nil
+	}
 
-		log.Debug("resolve upstream %s on %s qtype: %s, code: %d", qname, ns, qtype, r.Rcode)
-		break
+	var resp *dns.Msg
+	var err error
+	if msg == nil {
+		resp, err = h.queryUpstream(r, info)
 	}
 
-	return r, err
+	if cached != nil && (resp == nil || resp.Rcode == dns.RcodeServerFailure) {
+		info.upstream = "stale-cache"
+		log.Warning("resolve upstream %s qtype: %s failed, serve stale answer", qname, qtype)
+		return cached, nil
+	}
+	return resp, err
 }
 
-func (h *handler) isDomainInGfwlist(domain string) bool {
-	if domain == "." {
-		return false
+// refreshAnswer resolve the upstream in background to refresh the cached answer before expire
+func (h *handler) refreshAnswer(r *dns.Msg) {
+	defer h.answers.refreshed(r)
+
+	if _, err := h.queryUpstream(r, &queryInfo{}); err != nil {
+		log.Debug("refresh answer %s error, %v", r.Question[0].Name, err)
 	}
+}
 
-	domain = strings.TrimSuffix(domain, ".")
+// queryUpstream query the upstream nameservers, the answer is validated if dnssec enabled
+// and cached
+func (h *handler) queryUpstream(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
+	qname := r.Question[0].Name
+	qtype := dns.Type(r.Question[0].Qtype).String()
 
-	if h.isSingleDomainInGfwList(domain) {
-		return true
+	req := r
+	v := h.getValidator()
+	if v != nil {
+		req = v.prepare(r)
 	}
 
-	ds := strings.Split(domain, ".")
+	var resp *dns.Msg
+	var err error
+	nameserver, race := h.upstreams()
+	if race > 0 {
+		resp, err = h.raceUpstream(req, nameserver, race, info)
+	} else {
+		for _, ns := range orderByHealth(nameserver) {
+			resp, err = h.exchange(ns, req, info.span)
+			if err != nil {
+				log.Error("resolve upstream %s on %s qtype: %s error %v", qname, ns, qtype, err)
+				continue
+			}
+
+			if resp.Rcode == dns.RcodeServerFailure {
+				log.Error("resolve upstream %s on %s qtype: %s fail code %d", qname, ns, qtype, resp.Rcode)
+				continue
+			}
+
+			log.Debug("resolve upstream %s on %s qtype: %s, code: %d", qname, ns, qtype, resp.Rcode)
+			info.upstream = ns.String()
+			break
+		}
+	}
 
-	n := len(ds)
-	if n == 1 {
-		return false
+	if resp != nil {
+		if v != nil {
+			resp = v.finish(r, resp)
+		}
+		h.getTTL().clamp(resp)
+		h.negative.set(r, resp)
+		h.answers.set(r, resp)
 	}
 
-	for i, j := 1, n-1; i < j; i += 1 {
-		s := strings.Join(ds[i:], ".")
-		if h.isSingleDomainInGfwList(s) {
-			return true
+	return resp, err
+}
+
+// matchCNAME return the first name in the cname chain of the answer matched the proxy rules
+func (h *handler) matchCNAME(msg *dns.Msg) string {
+	for _, rr := range msg.Answer {
+		cname, ok := rr.(*dns.CNAME)
+		if !ok {
+			continue
+		}
+
+		for _, name := range []string{cname.Hdr.Name, cname.Target} {
+			if h.isDomainInGfwlist(name) {
+				return name
+			}
 		}
 	}
+	return ""
+}
 
-	return false
+// matchGeoIP return the first ip of the answer proxied by the geoip rules
+func (h *handler) matchGeoIP(msg *dns.Msg) string {
+	for _, rr := range msg.Answer {
+		ip := answerIP(rr)
+		if ip == nil {
+			continue
+		}
+
+		if h.server.geoipAction(ip) == geoip.ActionProxy {
+			return ip.String()
+		}
+		return ""
+	}
+	return ""
 }
 
-func (h *handler) isSingleDomainInGfwList(domain string) bool {
-	key := internal.GetRedisProxyDomainSetKey()
-	v, err := h.server.RedisClient.SIsMember(key, domain).Result()
-	if err != nil {
-		log.Warning("check single domain in proxy set error, domain %s, %v", domain, err)
+func (h *handler) isDomainInGfwlist(domain string) bool {
+	if domain == "." {
 		return false
 	}
 
-	return v
+	return h.server.getRules().Match(domain)
+}
+
+func newIPRecord(qtype uint16, qname string, ip net.IP, ttl uint32) dns.RR {
+	if qtype == dns.TypeAAAA {
+		return newAAAARecord(qname, ip, ttl)
+	}
+	return newARecord(qname, ip, ttl)
+}
+
+func newAAAARecord(qname string, ip net.IP, ttl uint32) *dns.AAAA {
+	a := new(dns.AAAA)
+	a.Hdr = dns.RR_Header{
+		Name:   dns.Fqdn(qname),
+		Rrtype: dns.TypeAAAA,
+		Class:  dns.ClassINET,
+		Ttl:    ttl,
+	}
+	a.AAAA = ip
+	return a
 }
 
 func newARecord(qname string, ip net.IP, ttl uint32) *dns.A {
@@ -270,3 +585,24 @@ func newARecord(qname string, ip net.IP, ttl uint32) *dns.A {
 	a.A = ip
 	return a
 }
+
+// msgBuffers is the buffers of packing the responses, the larger message is allocated
+var msgBuffers = internal.NewBufferPool("dns", 4096)
+
+// writeMsg pack the udp response in the pooled buffer and write it, the responses of tcp, dot
+// and doh and the responses signed by tsig are written by the writer
+func writeMsg(w dns.ResponseWriter, msg *dns.Msg) error {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); !ok || msg.IsTsig() != nil {
+		return w.WriteMsg(msg)
+	}
+
+	buf := msgBuffers.Get()
+	defer msgBuffers.Put(buf)
+
+	data, err := msg.PackBuffer(*buf)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestMatchCNAME(t *testing.T) {
+	matcher, err := gfwlist.NewMatcher([]string{"google.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{rules: matcher}
+	h := &handler{server: server}
+
+	cname := func(name, target string) dns.RR {
+		rr, _ := dns.NewRR(name + " 300 IN CNAME " + target)
+		return rr
+	}
+
+	tests := []struct {
+		answer []dns.RR
+		match  string
+	}{
+		{[]dns.RR{cname("www.example.com.", "www.google.com.")}, "www.google.com."},
+		{[]dns.RR{cname("a.example.com.", "b.google.com."), cname("b.google.com.", "cdn.example.net.")}, "b.google.com."},
+		{[]dns.RR{cname("a.example.com.", "b.example.net.")}, ""},
+		{[]dns.RR{newARecord("www.example.com.", []byte{1, 1, 1, 1}, 300)}, ""},
+	}
+
+	for _, test := range tests {
+		msg := new(dns.Msg)
+		msg.Answer = test.answer
+		if match := h.matchCNAME(msg); match != test.match {
+			t.Fatalf("expect %q, got %q for %v", test.match, match, test.answer)
+		}
+	}
+}
+
+func TestResolveServiceBinding(t *testing.T) {
+	matcher, err := gfwlist.NewMatcher([]string{"google.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &handler{server: &Server{rules: matcher}}
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.google.com.", typeHTTPS)
+	if !isServiceBindingQuery(&r.Question[0]) {
+		t.Fatal("https query should be service binding query")
+	}
+
+	msg, err := h.resolveServiceBinding(r, new(queryInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 0 {
+		t.Fatalf("https query of proxied domain should be suppressed, got %v", msg)
+	}
+}
+
+// unavailableStore fail the mapping lookups as redis is down
+type unavailableStore struct {
+	internal.Store
+}
+
+func (s *unavailableStore) TTL(key string) (time.Duration, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestQueryDomainCacheStale(t *testing.T) {
+	server := &Server{Store: &unavailableStore{}}
+	h := &handler{server: server, cache: newDomainCache(domainCacheSize, time.Millisecond, time.Minute)}
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.google.com.", dns.TypeA)
+	key := getDomainKey(dns.TypeA, r.Question[0].Name)
+
+	if msg := h.queryDomainCache(r); msg != nil {
+		t.Fatal("no mapping should be answered without stale cache")
+	}
+
+	h.cache.set(key, net.ParseIP("10.85.0.2"), time.Hour)
+	time.Sleep(time.Millisecond * 5)
+
+	msg := h.queryDomainCache(r)
+	if msg == nil || len(msg.Answer) != 1 || msg.Answer[0].Header().Ttl != staleTTL {
+		t.Fatal("stale mapping should be answered if redis is unavailable", msg)
+	}
+}
+
+// recordWriter record whether the message is written packed
+type recordWriter struct {
+	dns.ResponseWriter
+	remote net.Addr
+	msg    *dns.Msg
+	packed bool
+}
+
+func (w *recordWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+func (w *recordWriter) WriteMsg(msg *dns.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func (w *recordWriter) Write(data []byte) (int, error) {
+	w.msg, w.packed = new(dns.Msg), true
+	return len(data), w.msg.Unpack(data)
+}
+
+func TestWriteMsg(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	signed := msg.Copy().SetTsig("key.", dns.HmacSHA256, 300, time.Now().Unix())
+
+	tests := []struct {
+		remote net.Addr
+		msg    *dns.Msg
+		packed bool
+	}{
+		{&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, msg, true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, msg, false},
+		// the tsig is signed by the writer
+		{&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, signed, false},
+	}
+
+	for _, test := range tests {
+		w := &recordWriter{remote: test.remote}
+		if err := writeMsg(w, test.msg); err != nil || w.packed != test.packed ||
+			w.msg == nil || w.msg.Question[0].Name != "example.com." {
+			t.Fatal("unexpected write of", test.remote, w.packed, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// healthCheckInterval is the interval of probing upstreams
+	healthCheckInterval = time.Duration(time.Second * 30)
+	// healthMaxFailures mark the upstream down after continuous failures
+	healthMaxFailures = 3
+	// healthDownTime how long the down upstream is skipped, until the probe success
+	healthDownTime = time.Duration(time.Minute)
+)
+
+// recordHealth update the health state with the exchange result
+func (u *upstream) recordHealth(err error) {
+	if err == nil {
+		if atomic.SwapInt32(&u.continuousFailures, 0) >= healthMaxFailures {
+			log.Info("upstream %s is up", u)
+		}
+		atomic.StoreInt64(&u.downUntil, 0)
+		return
+	}
+
+	if atomic.AddInt32(&u.continuousFailures, 1) >= healthMaxFailures {
+		if atomic.SwapInt64(&u.downUntil, time.Now().Add(healthDownTime).UnixNano()) == 0 {
+			log.Warning("upstream %s is down, %v", u, err)
+		}
+	}
+}
+
+func (u *upstream) healthy() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&u.downUntil)
+}
+
+// orderByHealth keep the order of upstreams, move the unhealthy ones to the end
+func orderByHealth(upstreams []*upstream) []*upstream {
+	ordered := make([]*upstream, 0, len(upstreams))
+	var down []*upstream
+	for _, ns := range upstreams {
+		if ns.healthy() {
+			ordered = append(ordered, ns)
+		} else {
+			down = append(down, ns)
+		}
+	}
+	return append(ordered, down...)
+}
+
+// checkHealth probe all upstreams periodically
+func (h *handler) checkHealth() {
+	for range time.Tick(healthCheckInterval) {
+		nameserver, _ := h.upstreams()
+		for _, ns := range nameserver {
+			go func(ns *upstream) {
+				m := new(dns.Msg)
+				m.SetQuestion(".", dns.TypeNS)
+
+				_, rtt, err := ns.exchange(m)
+				if err != nil {
+					log.Debug("health check upstream %s error %v", ns, err)
+					return
+				}
+				log.Debug("health check upstream %s rtt: %v, srtt: %v", ns, rtt, ns.rtt())
+			}(ns)
+		}
+	}
+}
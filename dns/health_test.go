@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestUpstreamHealth(t *testing.T) {
+	addr := deadUpstreamAddr(t)
+	down, err := parseUpstream(addr, time.Millisecond*100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, stop := startTestUpstream(t, "", 0, "192.0.2.1")
+	defer stop()
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	for i := 0; i < healthMaxFailures; i++ {
+		if !down.healthy() {
+			t.Fatal("the upstream should be down after the continuous failures only", i)
+		}
+		if _, _, err := down.exchange(r); err == nil {
+			t.Fatal("the exchange should fail")
+		}
+	}
+	if down.healthy() {
+		t.Fatal("the upstream should be down")
+	}
+
+	// the upstream down is demoted
+	if ordered := orderByHealth([]*upstream{down, up}); ordered[0] != up || ordered[1] != down {
+		t.Fatal("the upstream down should be moved to the end", ordered)
+	}
+	if sorted := sortByRTT([]*upstream{down, up}); sorted[0] != up {
+		t.Fatal("the upstream down should be raced last", sorted)
+	}
+
+	// the upstream is up after the success
+	_, stop = startTestUpstream(t, addr, 0, "192.0.2.2")
+	defer stop()
+	if _, _, err := down.exchange(r); err != nil || !down.healthy() {
+		t.Fatal("the upstream should recover", err)
+	}
+
+	// the down time passed
+	for i := 0; i < healthMaxFailures; i++ {
+		up.recordHealth(errors.New("timeout"))
+	}
+	if up.healthy() {
+		t.Fatal("the upstream should be down")
+	}
+	up.downUntil = time.Now().Add(-time.Second).UnixNano()
+	if !up.healthy() {
+		t.Fatal("the upstream should be tried after the down time")
+	}
+}
+
+func TestQueryUpstreamAllDown(t *testing.T) {
+	dead, err := parseUpstream(deadUpstreamAddr(t), time.Millisecond*100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, stop := startTestUpstream(t, "", 0, "192.0.2.1")
+	defer stop()
+
+	for i := 0; i < healthMaxFailures; i++ {
+		dead.recordHealth(errors.New("timeout"))
+		up.recordHealth(errors.New("timeout"))
+	}
+
+	// all the upstreams down are still tried in order
+	h := &handler{nameserver: []*upstream{dead, up}, negative: newNegativeCache(0)}
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	info := new(queryInfo)
+	resp, err := h.queryUpstream(r, info)
+	if err != nil || len(resp.Answer) != 1 || info.upstream != up.String() {
+		t.Fatal("should fall back to the upstreams down", resp, err)
+	}
+	if !up.healthy() {
+		t.Fatal("the upstream answered should be up")
+	}
+}
@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// hostsTTL is the ttl of static records
+const hostsTTL = 60
+
+// hosts is the static records, answered before any other resolve
+//
+// line format (hosts file style):
+//
+//	192.168.1.1 router.lan
+//	fd00::2 nas.lan nas
+//	router.lan www.lan          # CNAME, www.lan -> router.lan
+type hosts struct {
+	records map[string]*hostRecord
+	// ptr is the reverse addr -> name
+	ptr map[string]string
+}
+
+type hostRecord struct {
+	a     []net.IP
+	aaaa  []net.IP
+	cname string
+}
+
+func newHosts() *hosts {
+	return &hosts{
+		records: make(map[string]*hostRecord),
+		ptr:     make(map[string]string),
+	}
+}
+
+func (h *hosts) loadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return h.load(f)
+}
+
+func (h *hosts) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := h.addLine(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (h *hosts) addLine(line string) error {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid hosts line: %s", line)
+	}
+
+	ip := net.ParseIP(fields[0])
+	for _, name := range fields[1:] {
+		name = strings.ToLower(dns.Fqdn(name))
+		record := h.records[name]
+		if record == nil {
+			record = new(hostRecord)
+			h.records[name] = record
+		}
+
+		switch {
+		case ip == nil:
+			record.cname = strings.ToLower(dns.Fqdn(fields[0]))
+		case ip.To4() != nil:
+			record.a = append(record.a, ip.To4())
+		default:
+			record.aaaa = append(record.aaaa, ip)
+		}
+
+		if ip != nil {
+			if arpa, err := dns.ReverseAddr(ip.String()); err == nil {
+				if _, ok := h.ptr[arpa]; !ok {
+					h.ptr[arpa] = name
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (h *hosts) len() int {
+	return len(h.records)
+}
+
+// resolve answer the request with static records, nil if the name is not found
+func (h *hosts) resolve(r *dns.Msg) *dns.Msg {
+	if h == nil {
+		return nil
+	}
+
+	q := r.Question[0]
+	if q.Qclass != dns.ClassINET {
+		return nil
+	}
+
+	name := strings.ToLower(q.Name)
+
+	if q.Qtype == dns.TypePTR {
+		target, ok := h.ptr[name]
+		if !ok {
+			return nil
+		}
+
+		msg := newHostsReply(r)
+		ptr := new(dns.PTR)
+		ptr.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: hostsTTL}
+		ptr.Ptr = target
+		msg.Answer = append(msg.Answer, ptr)
+		return msg
+	}
+
+	record, ok := h.records[name]
+	if !ok {
+		return nil
+	}
+
+	msg := newHostsReply(r)
+	owner := q.Name
+	// follow the cname chain inside hosts, limit the depth avoid loop
+	for i := 0; i < 8 && record != nil; i++ {
+		if record.cname != "" {
+			cname := new(dns.CNAME)
+			cname.Hdr = dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: hostsTTL}
+			cname.Target = record.cname
+			msg.Answer = append(msg.Answer, cname)
+
+			if q.Qtype == dns.TypeCNAME {
+				break
+			}
+
+			owner = record.cname
+			record = h.records[record.cname]
+			continue
+		}
+
+		switch q.Qtype {
+		case dns.TypeA:
+			for _, ip := range record.a {
+				msg.Answer = append(msg.Answer, newARecord(owner, ip, hostsTTL))
+			}
+		case dns.TypeAAAA:
+			for _, ip := range record.aaaa {
+				msg.Answer = append(msg.Answer, newAAAARecord(owner, ip, hostsTTL))
+			}
+		}
+		break
+	}
+
+	return msg
+}
+
+func newHostsReply(r *dns.Msg) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+	return msg
+}
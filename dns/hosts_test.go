@@ -0,0 +1,53 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHosts(t *testing.T) {
+	h := newHosts()
+	err := h.load(strings.NewReader(`
+# comment
+192.168.1.1 router.lan
+fd00::1 router.lan
+router.lan www.lan
+0.0.0.0 ads.example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.lan.", dns.TypeA)
+	msg := h.resolve(r)
+	if msg == nil || len(msg.Answer) != 2 {
+		t.Fatalf("www.lan should answer cname and a, got %v", msg)
+	}
+
+	if a, ok := msg.Answer[1].(*dns.A); !ok || a.A.String() != "192.168.1.1" {
+		t.Fatalf("unexpected answer %v", msg.Answer[1])
+	}
+
+	r.SetQuestion("router.lan.", dns.TypeAAAA)
+	if msg = h.resolve(r); msg == nil || len(msg.Answer) != 1 {
+		t.Fatalf("router.lan should answer aaaa, got %v", msg)
+	}
+
+	r.SetQuestion("ads.example.com.", dns.TypeAAAA)
+	if msg = h.resolve(r); msg == nil || len(msg.Answer) != 0 {
+		t.Fatalf("ads.example.com aaaa should answer empty, got %v", msg)
+	}
+
+	r.SetQuestion("1.1.168.192.in-addr.arpa.", dns.TypePTR)
+	if msg = h.resolve(r); msg == nil || msg.Answer[0].(*dns.PTR).Ptr != "router.lan." {
+		t.Fatalf("ptr should answer router.lan, got %v", msg)
+	}
+
+	r.SetQuestion("google.com.", dns.TypeA)
+	if msg = h.resolve(r); msg != nil {
+		t.Fatal("google.com should not found")
+	}
+}
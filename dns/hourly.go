@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// hourlyFlushInterval is the interval of adding the hourly stats to the store
+	hourlyFlushInterval = time.Duration(time.Minute)
+	// statsTopLimit is the default count of the top domains of the reports
+	statsTopLimit = 20
+)
+
+// recordHourly count the query in the hourly stats, the domains of the blocked and the proxied
+// queries are counted too
+func (server *Server) recordHourly(qname string, outcome string, now time.Time) {
+	hourly := server.hourly
+	if hourly == nil {
+		return
+	}
+
+	hourly.Add(internal.StatsQueries, outcome, 1, now)
+
+	domain := strings.TrimSuffix(strings.ToLower(qname), ".")
+	switch outcome {
+	case "reject":
+		hourly.Add(internal.StatsBlocked, domain, 1, now)
+	case "internal":
+		hourly.Add(internal.StatsProxied, domain, 1, now)
+	}
+}
+
+// runHourlyStats add the hourly stats to the store periodically, and on shutdown
+func (server *Server) runHourlyStats() {
+	ticker := time.NewTicker(hourlyFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.done:
+			server.flushHourly()
+			return
+		case <-ticker.C:
+			server.flushHourly()
+		}
+	}
+}
+
+func (server *Server) flushHourly() {
+	if err := server.hourly.Flush(server.Store); err != nil {
+		log.Warning("save hourly stats error, %v", err)
+	}
+}
+
+// statsRange return the hours of the range, today (default) since the midnight or the duration
+// until now
+func statsRange(value string, now time.Time) ([]string, bool) {
+	if value == "" || value == "today" {
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return internal.StatsHours(midnight, now), true
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return nil, false
+	}
+	return internal.StatsHours(now.Add(-d), now), true
+}
+
+// statsTop report the top domains of the kind in the range
+func (a *adminHandler) statsTop(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	switch kind {
+	case "":
+		kind = internal.StatsProxied
+	case internal.StatsProxied, internal.StatsBlocked, internal.StatsBytes:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid kind "+kind+", should be proxied, blocked or bytes")
+		return
+	}
+
+	hours, ok := statsRange(query.Get("range"), time.Now())
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid range "+query.Get("range"))
+		return
+	}
+
+	n := statsTopLimit
+	if value := query.Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid n "+value)
+			return
+		}
+	}
+
+	top, err := internal.TopStats(a.server.Store, kind, hours, n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, top)
+}
+
+// hourlyQueries is the queries by outcome of the hour
+type hourlyQueries struct {
+	Hour     string           `json:"hour"`
+	Queries  int64            `json:"queries"`
+	Outcomes map[string]int64 `json:"outcomes"`
+}
+
+// statsHourly report the queries by outcome per hour in the range
+func (a *adminHandler) statsHourly(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	hours, ok := statsRange(r.URL.Query().Get("range"), time.Now())
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid range "+r.URL.Query().Get("range"))
+		return
+	}
+
+	list := make([]hourlyQueries, 0, len(hours))
+	for _, hour := range hours {
+		outcomes, err := internal.TopStats(a.server.Store, internal.StatsQueries, []string{hour}, 0)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		q := hourlyQueries{Hour: hour, Outcomes: make(map[string]int64, len(outcomes))}
+		for _, o := range outcomes {
+			q.Outcomes[o.Name] = o.Count
+			q.Queries += o.Count
+		}
+		list = append(list, q)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
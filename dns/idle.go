@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// idleSweepInterval is the interval to release the idle mappings
+	idleSweepInterval = time.Duration(time.Minute)
+	// idleSweepBatch is the count of the reverse mapping keys scanned at a time
+	idleSweepBatch = 1000
+)
+
+// runIdleSweep release the mappings without access for the idle period if configured,
+// only the leader run it if multiple instances share the store
+func (server *Server) runIdleSweep() {
+	elector := internal.NewElector(server.Store, "idle-sweep")
+	elector.Start()
+	defer elector.Stop()
+
+	ticker := time.NewTicker(idleSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.done:
+			return
+		case <-ticker.C:
+		}
+
+		idle := server.toucher.Idle()
+		if idle <= 0 || !elector.IsLeader() {
+			continue
+		}
+
+		released, err := server.sweepIdle(idle, time.Now())
+		if err != nil {
+			log.Error("sweep idle mappings error, %v", err)
+		}
+
+		if released > 0 {
+			log.Info("release idle mappings: %d, idle: %v", released, idle)
+		}
+	}
+}
+
+// sweepIdle release the mappings not accessed since now - idle, the mappings without the
+// access time (created before idle sweeping enabled) are given the idle period from now. The
+// reverse mapping keys are scanned in batches, never listed at once
+func (server *Server) sweepIdle(idle time.Duration, now time.Time) (int, error) {
+	released := 0
+	var cursor uint64
+	for {
+		ipKeys, next, err := server.Store.Scan(cursor, internal.GetRedisIpKey("*"), idleSweepBatch)
+		if err != nil {
+			return released, err
+		}
+
+		n, err := server.sweepIdleKeys(ipKeys, idle, now)
+		released += n
+		if err != nil || next == 0 {
+			return released, err
+		}
+		cursor = next
+	}
+}
+
+// sweepIdleKeys release the idle mappings of the reverse mapping keys
+func (server *Server) sweepIdleKeys(ipKeys []string, idle time.Duration, now time.Time) (int, error) {
+	store := server.Store
+	released := 0
+
+	realPrefix := internal.GetRedisRealIpKey("")
+	ipPrefix := internal.GetRedisIpKey("")
+	for _, ipKey := range ipKeys {
+		if strings.HasPrefix(ipKey, realPrefix) {
+			continue
+		}
+
+		ip := net.ParseIP(strings.TrimPrefix(ipKey, ipPrefix))
+		if ip == nil {
+			continue
+		}
+
+		accessKey := internal.GetRedisAccessKey(ip.String())
+		value, err := store.Get(accessKey)
+		if err == internal.ErrNil {
+			ttl, _ := store.TTL(ipKey)
+			if ttl <= 0 {
+				ttl = server.handler.getTTL().mappingTTL()
+			}
+			store.SetNX(accessKey, strconv.FormatInt(now.Unix(), 10), ttl)
+			continue
+		}
+
+		if err != nil {
+			return released, err
+		}
+
+		access, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && now.Sub(time.Unix(access, 0)) < idle {
+			continue
+		}
+
+		domain, err := store.Get(ipKey)
+		if err == internal.ErrNil {
+			continue
+		}
+
+		if err != nil {
+			return released, err
+		}
+
+		qtype := dns.TypeAAAA
+		if ip.To4() != nil {
+			qtype = dns.TypeA
+		}
+
+		// the orphaned reverse mapping is left to reconcile
+		key := getDomainKey(qtype, dns.Fqdn(domain))
+		if mapped, err := store.Get(key); err != nil || mapped != ip.String() {
+			continue
+		}
+
+		log.Debug("release idle mapping %s -> %s", domain, ip)
+		if err = server.flushMapping(key); err != nil {
+			return released, err
+		}
+		store.Del(accessKey)
+		released++
+	}
+
+	return released, nil
+}
@@ -0,0 +1,56 @@
+package dns
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestSweepIdle(t *testing.T) {
+	server := newSnapshotTestServer()
+	store := server.Store
+	now := time.Now()
+
+	for _, domain := range []string{"active.com", "idle.com", "legacy.com"} {
+		if _, err := server.pool.allocate(testDomainKey(domain), domain, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ipOf := func(domain string) string {
+		ip, _ := store.Get(testDomainKey(domain))
+		return ip
+	}
+
+	active, idle, legacy := ipOf("active.com"), ipOf("idle.com"), ipOf("legacy.com")
+	store.Set(internal.GetRedisAccessKey(active), strconv.FormatInt(now.Add(-time.Minute).Unix(), 10), time.Hour)
+	store.Set(internal.GetRedisAccessKey(idle), strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), time.Hour)
+
+	released, err := server.sweepIdle(time.Minute*10, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if released != 1 {
+		t.Fatalf("expect 1 released, got %d", released)
+	}
+
+	if _, err := store.Get(testDomainKey("idle.com")); err != internal.ErrNil {
+		t.Fatal("idle mapping should be released")
+	}
+
+	if _, err := store.Get(internal.GetRedisIpKey(idle)); err != internal.ErrNil {
+		t.Fatal("idle ip should be released")
+	}
+
+	if ipOf("active.com") != active || ipOf("legacy.com") != legacy {
+		t.Fatal("active and legacy mappings should be kept")
+	}
+
+	// the legacy mapping is given the idle period from now
+	if _, err := store.Get(internal.GetRedisAccessKey(legacy)); err != nil {
+		t.Fatalf("access time of legacy mapping should be recorded, %v", err)
+	}
+}
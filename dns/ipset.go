@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/ipsync"
+)
+
+// resolveSynced answer the real ips of the proxied domains and add them to the set, the
+// domains proxied by cname or geoip too, other domains are answered as is
+func (h *handler) resolveSynced(r *dns.Msg, info *queryInfo, syncer *ipsync.Syncer) (*dns.Msg, error) {
+	qname := r.Question[0].Name
+
+	resp, err := h.resolveUpstream(r, info)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	if !h.isDomainInGfwlist(qname) {
+		if info.cname = h.matchCNAME(resp); info.cname != "" {
+			log.Debug("sync the ips of %s, proxied by cname %s", qname, info.cname)
+		} else if info.geoip = h.matchGeoIP(resp); info.geoip != "" {
+			log.Debug("sync the ips of %s, proxied by geoip of %s", qname, info.geoip)
+		} else {
+			return resp, nil
+		}
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for _, rr := range resp.Answer {
+		if ip := answerIP(rr); ip != nil {
+			ips = append(ips, ip)
+			if ttl == 0 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+
+	if len(ips) > 0 {
+		syncer.Add(ips, time.Duration(ttl)*time.Second)
+	}
+	return resp, nil
+}
@@ -0,0 +1,160 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leaseCheckInterval is the interval to check the lease file changed
+const leaseCheckInterval = time.Duration(30 * time.Second)
+
+// dhcpLeases is the mac of the leased ips, the lease file is reloaded on lookup if changed
+//
+// dnsmasq format:
+//
+//	1700000000 aa:bb:cc:dd:ee:ff 192.168.1.10 tv *
+//
+// isc dhcpd format:
+//
+//	lease 192.168.1.10 {
+//	  binding state active;
+//	  hardware ethernet aa:bb:cc:dd:ee:ff;
+//	}
+type dhcpLeases struct {
+	file string
+
+	lock    sync.Mutex
+	checked time.Time
+	modTime time.Time
+	// macs is the ip to the mac of the lease, expire is 0 if never expire
+	macs map[string]dhcpLease
+}
+
+type dhcpLease struct {
+	mac    string
+	expire int64
+}
+
+// newDHCPLeases create the leases of the file, nil if the file is empty
+func newDHCPLeases(file string) *dhcpLeases {
+	if file == "" {
+		return nil
+	}
+	return &dhcpLeases{file: file, macs: make(map[string]dhcpLease)}
+}
+
+// mac return the mac leased the ip, empty if not leased or expired, nil leases is no-op
+func (l *dhcpLeases) mac(ip net.IP, now time.Time) string {
+	if l == nil {
+		return ""
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.checked) >= leaseCheckInterval {
+		l.checked = now
+		if err := l.reload(); err != nil {
+			log.Warning("load dhcp leases %s error, %v", l.file, err)
+		}
+	}
+
+	lease, ok := l.macs[ip.String()]
+	if !ok || (lease.expire != 0 && lease.expire < now.Unix()) {
+		return ""
+	}
+	return lease.mac
+}
+
+// reload the lease file if modified
+func (l *dhcpLeases) reload() error {
+	info, err := os.Stat(l.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(l.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	macs, err := parseLeases(f)
+	if err != nil {
+		return err
+	}
+
+	l.macs, l.modTime = macs, info.ModTime()
+	log.Debug("load dhcp leases %s, %d leases", l.file, len(macs))
+	return nil
+}
+
+// parseLeases parse the leases of dnsmasq or isc dhcpd, the later lease of the ip wins
+func parseLeases(r io.Reader) (map[string]dhcpLease, error) {
+	macs := make(map[string]dhcpLease)
+
+	// the ip of the isc dhcpd lease block in parsing
+	var block string
+	var lease dhcpLease
+	active := true
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+
+		if block != "" {
+			switch {
+			case line == "}":
+				if active && lease.mac != "" {
+					macs[block] = lease
+				} else {
+					delete(macs, block)
+				}
+				block = ""
+			case len(fields) == 3 && fields[0] == "hardware":
+				if mac, err := net.ParseMAC(fields[2]); err == nil {
+					lease.mac = mac.String()
+				}
+			case len(fields) == 3 && fields[0] == "binding" && fields[1] == "state":
+				active = fields[2] == "active"
+			}
+			continue
+		}
+
+		if len(fields) >= 2 && fields[0] == "lease" {
+			if ip := net.ParseIP(fields[1]); ip != nil {
+				block, lease, active = ip.String(), dhcpLease{}, true
+			}
+			continue
+		}
+
+		// dnsmasq, the ipv6 leases have the iaid instead of the mac and are skipped
+		if len(fields) < 3 {
+			continue
+		}
+		expire, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[1])
+		ip := net.ParseIP(fields[2])
+		if err != nil || ip == nil {
+			continue
+		}
+		macs[ip.String()] = dhcpLease{mac: mac.String(), expire: expire}
+	}
+	return macs, scanner.Err()
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLeases(t *testing.T) {
+	dnsmasq := `1700000000 aa:bb:cc:dd:ee:01 192.168.1.10 tv *
+0 AA:BB:CC:DD:EE:02 192.168.1.11 * 01:aa:bb:cc:dd:ee:02
+duid 00:01:00:01:2a:2b:2c:2d:aa:bb:cc:dd:ee:ff
+1700000000 12345678 fd00::10 phone 00:01:00:01
+`
+	macs, err := parseLeases(strings.NewReader(dnsmasq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(macs) != 2 || macs["192.168.1.10"].expire != 1700000000 || macs["192.168.1.11"].mac != "aa:bb:cc:dd:ee:02" {
+		t.Fatal("unexpected dnsmasq leases", macs)
+	}
+
+	dhcpd := `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.20 {
+  starts 4 2026/10/15 10:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:20;
+}
+lease 192.168.1.21 {
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:21;
+}
+lease 192.168.1.21 {
+  binding state free;
+  hardware ethernet aa:bb:cc:dd:ee:21;
+}
+`
+	if macs, err = parseLeases(strings.NewReader(dhcpd)); err != nil {
+		t.Fatal(err)
+	}
+	if len(macs) != 1 || macs["192.168.1.20"].mac != "aa:bb:cc:dd:ee:20" {
+		t.Fatal("unexpected dhcpd leases", macs)
+	}
+}
+
+func TestDHCPLeasesExpire(t *testing.T) {
+	now := time.Now()
+	l := newDHCPLeases("/nonexistent/dhcp.leases")
+	l.checked = now
+	l.macs["192.168.1.10"] = dhcpLease{mac: "aa:bb:cc:dd:ee:01", expire: now.Unix() - 1}
+	l.macs["192.168.1.11"] = dhcpLease{mac: "aa:bb:cc:dd:ee:02"}
+
+	if l.mac(net.ParseIP("192.168.1.10"), now) != "" {
+		t.Fatal("expired lease should have no mac")
+	}
+	if l.mac(net.ParseIP("192.168.1.11"), now) != "aa:bb:cc:dd:ee:02" {
+		t.Fatal("lease never expire should have the mac")
+	}
+
+	var empty *dhcpLeases
+	if empty.mac(net.ParseIP("192.168.1.11"), now) != "" {
+		t.Fatal("nil leases should have no mac")
+	}
+}
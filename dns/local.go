@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// localZones is the local domains, never answered the fake ip or sent to the upstream
+// nameservers, to avoid leaking the internal names
+type localZones struct {
+	matcher *gfwlist.Matcher
+	// nameserver is the local nameserver, nil to answer NXDOMAIN
+	nameserver *upstream
+}
+
+// newLocalZones create the local zones of the config, the default local domains if not
+// configured
+func newLocalZones(config *internal.Config, timeout time.Duration) (*localZones, error) {
+	domains := internal.DefaultLocalDomains
+	nameserver := ""
+	if config != nil {
+		if len(config.DNS.Local.Domains) > 0 {
+			domains = config.DNS.Local.Domains
+		}
+		nameserver = config.DNS.Local.Nameserver
+	}
+
+	matcher, err := gfwlist.NewMatcher(domains)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local domains, %v", err)
+	}
+
+	l := &localZones{matcher: matcher}
+	if nameserver != "" {
+		if l.nameserver, err = parseUpstream(nameserver, timeout); err != nil {
+			return nil, fmt.Errorf("invalid local nameserver, %v", err)
+		}
+	}
+	return l, nil
+}
+
+func (h *handler) getLocal() *localZones {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.local
+}
+
+// match return true if the domain is local, nil zones match nothing
+func (l *localZones) match(qname string) bool {
+	return l != nil && qname != "." && l.matcher.Match(qname)
+}
+
+// resolveLocal forward the query of the local domain to the local nameserver, or answer NXDOMAIN
+func (h *handler) resolveLocal(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
+	l := h.getLocal()
+	if l == nil || l.nameserver == nil {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeNameError)
+		return msg, nil
+	}
+
+	resp, _, err := l.nameserver.exchange(r)
+	if err != nil {
+		return nil, fmt.Errorf("resolve local %s on %s error, %v", r.Question[0].Name, l.nameserver, err)
+	}
+	info.upstream = l.nameserver.String()
+	return resp, nil
+}
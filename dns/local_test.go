@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestLocalZones(t *testing.T) {
+	l, err := newLocalZones(&internal.Config{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"printer.local.", "router.lan.", "x.home.arpa."} {
+		if !l.match(name) {
+			t.Fatalf("%s should be local", name)
+		}
+	}
+	for _, name := range []string{"google.com.", "localhost.com.", "."} {
+		if l.match(name) {
+			t.Fatalf("%s should not be local", name)
+		}
+	}
+
+	var zones *localZones
+	if zones.match("printer.local.") {
+		t.Fatal("nil zones should match nothing")
+	}
+
+	config := &internal.Config{DNS: internal.DNS{Local: internal.DNSLocal{Domains: []string{"corp.example"}}}}
+	if l, err = newLocalZones(config, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !l.match("git.corp.example.") || l.match("printer.local.") {
+		t.Fatal("configured domains should replace the default")
+	}
+
+	config = &internal.Config{DNS: internal.DNS{Local: internal.DNSLocal{Nameserver: "bad://192.168.1.1"}}}
+	if _, err = newLocalZones(config, time.Second); err == nil {
+		t.Fatal("invalid nameserver should fail")
+	}
+}
+
+func TestResolveLocal(t *testing.T) {
+	l, err := newLocalZones(&internal.Config{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{local: l}
+
+	r := new(dns.Msg)
+	r.SetQuestion("printer.local.", dns.TypeA)
+	msg, err := h.resolveLocal(r, new(queryInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Rcode != dns.RcodeNameError || len(msg.Answer) != 0 {
+		t.Fatalf("local domain without nameserver should be NXDOMAIN, got %s", dns.RcodeToString[msg.Rcode])
+	}
+}
@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"github.com/yinheli/kungfu/metrics"
+)
+
+var (
+	queriesTotal = metrics.NewCounter("kungfu_dns_queries_total",
+		"dns queries by qtype and outcome", "qtype", "outcome")
+	cacheRequestsTotal = metrics.NewCounter("kungfu_dns_cache_requests_total",
+		"dns cache lookups by cache and result", "cache", "result")
+	poolUtilization = metrics.NewGauge("kungfu_dns_pool_utilization",
+		"fake ip pool utilization ratio", "family")
+	poolUsed = metrics.NewGauge("kungfu_dns_pool_used",
+		"fake ip pool used addresses", "family")
+	upstreamRTT = metrics.NewHistogram("kungfu_dns_upstream_rtt_seconds",
+		"upstream nameserver round trip time", metrics.DefaultBuckets, "upstream")
+	upstreamErrorsTotal = metrics.NewCounter("kungfu_dns_upstream_errors_total",
+		"upstream nameserver exchange errors", "upstream")
+	prefetchTotal = metrics.NewCounter("kungfu_dns_prefetch_total",
+		"popular domains refreshed before expire by kind", "kind")
+)
+
+// observeCache record the cache lookup result
+func observeCache(cache string, hit bool) {
+	if hit {
+		cacheRequestsTotal.Inc(cache, "hit")
+	} else {
+		cacheRequestsTotal.Inc(cache, "miss")
+	}
+}
+
+// collectPools update the pool utilization gauges before collect
+func (server *Server) collectPools() {
+	pools := map[string]*allocator{"ipv4": server.pool, "ipv6": server.pool6}
+	for family, pool := range pools {
+		if pool == nil || !pool.configured() {
+			continue
+		}
+
+		used, size := pool.utilization()
+		poolUsed.Set(float64(used), family)
+		poolUtilization.Set(float64(used)/float64(size), family)
+	}
+}
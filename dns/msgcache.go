@@ -0,0 +1,191 @@
+package dns
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// staleTTL is the ttl of the stale answer (RFC 8767)
+	staleTTL = 30
+	// defaultStaleTTL is the default period to serve the expired answers if redis or upstreams fail
+	defaultStaleTTL = time.Duration(time.Hour)
+)
+
+// msgCache is lru cache of upstream response message
+type msgCache struct {
+	size int
+
+	lock    sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type msgCacheEntry struct {
+	key    string
+	msg    *dns.Msg
+	ttl    time.Duration
+	stored time.Time
+	expire time.Time
+}
+
+func newMsgCache(size int) *msgCache {
+	return &msgCache{
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// msgCacheKey is the key of the question, the CD and DO bits and the client subnet of the
+// request, the responses vary on them, the answer not validated for CD is never shared
+func msgCacheKey(r *dns.Msg) string {
+	q := r.Question[0]
+	key := fmt.Sprintf("%s:%d:%d", strings.ToLower(q.Name), q.Qtype, q.Qclass)
+	if r.CheckingDisabled {
+		key += ":cd"
+	}
+
+	opt := r.IsEdns0()
+	if opt == nil {
+		return key
+	}
+
+	if opt.Do() {
+		key += ":do"
+	}
+
+	for _, o := range opt.Option {
+		if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+			key += fmt.Sprintf(":%s/%d", subnet.Address, subnet.SourceNetmask)
+		}
+	}
+	return key
+}
+
+// get return the copy of cached response for the request, ttl is decreased by the cached time
+func (c *msgCache) get(r *dns.Msg) *dns.Msg {
+	msg, _, _ := c.lookup(msgCacheKey(r), r, 0)
+	return msg
+}
+
+// lookup return the copy of cached response of the key for the request, with the remaining
+// and the original ttl, the expired response is returned within the stale period, with the
+// remaining ttl <= 0 and the record ttl of staleTTL
+func (c *msgCache) lookup(key string, r *dns.Msg, stale time.Duration) (*dns.Msg, time.Duration, time.Duration) {
+	c.lock.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.lock.Unlock()
+		return nil, 0, 0
+	}
+
+	entry := e.Value.(*msgCacheEntry)
+	now := time.Now()
+	if now.After(entry.expire.Add(stale)) {
+		c.removeElement(e)
+		c.lock.Unlock()
+		return nil, 0, 0
+	}
+
+	c.ll.MoveToFront(e)
+	msg := entry.msg.Copy()
+	stored := entry.stored
+	c.lock.Unlock()
+
+	msg.Id = r.Id
+	msg.Question = append([]dns.Question(nil), r.Question...)
+
+	remain := entry.expire.Sub(now)
+	if remain > 0 {
+		decreaseTTL(msg, uint32(now.Sub(stored).Seconds()))
+	} else {
+		setTTL(msg, staleTTL)
+	}
+	return msg, remain, entry.ttl
+}
+
+// set the response of the request
+func (c *msgCache) set(r *dns.Msg, msg *dns.Msg, ttl time.Duration) {
+	if len(r.Question) == 0 {
+		return
+	}
+	c.store(msgCacheKey(r), msg, ttl)
+}
+
+// store the copy of the response with the key
+func (c *msgCache) store(key string, msg *dns.Msg, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	entry := &msgCacheEntry{
+		key:    key,
+		msg:    msg.Copy(),
+		ttl:    ttl,
+		stored: now,
+		expire: now.Add(ttl),
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.ll.PushFront(entry)
+
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// flush remove all the entries
+func (c *msgCache) flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+}
+
+func (c *msgCache) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.entries, e.Value.(*msgCacheEntry).key)
+}
+
+func decreaseTTL(msg *dns.Msg, elapsed uint32) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT {
+				continue
+			}
+
+			if h.Ttl > elapsed {
+				h.Ttl -= elapsed
+			} else {
+				h.Ttl = 0
+			}
+		}
+	}
+}
+
+func setTTL(msg *dns.Msg, ttl uint32) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+				h.Ttl = ttl
+			}
+		}
+	}
+}
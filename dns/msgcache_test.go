@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMsgCacheTTL(t *testing.T) {
+	c := newMsgCache(2)
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	c.store(msgCacheKey(r), newTestAnswer(t, r, 300), time.Millisecond*50)
+
+	r.Id = 1234
+	msg := c.get(r)
+	if msg == nil || msg.Id != 1234 || msg.Answer[0].Header().Ttl != 300 {
+		t.Fatal("response should be cached", msg)
+	}
+
+	// the ttl is decreased by the cached time
+	c.store(msgCacheKey(r), newTestAnswer(t, r, 300), time.Minute)
+	c.entries[msgCacheKey(r)].Value.(*msgCacheEntry).stored = time.Now().Add(-time.Second * 10)
+	if msg = c.get(r); msg == nil || msg.Answer[0].Header().Ttl != 290 {
+		t.Fatal("ttl should be decreased", msg)
+	}
+
+	c.store(msgCacheKey(r), newTestAnswer(t, r, 300), time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	if msg = c.get(r); msg != nil || len(c.entries) != 0 {
+		t.Fatal("expired response should be removed")
+	}
+
+	// the least recently used is evicted
+	for _, name := range []string{"a.com.", "b.com.", "c.com."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		c.set(q, newTestAnswer(t, q, 300), time.Minute)
+	}
+	if len(c.entries) != 2 || c.entries["a.com.:1:1"] != nil {
+		t.Fatal("lru entry should be evicted", len(c.entries))
+	}
+}
+
+func TestMsgCacheKey(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("Example.COM.", dns.TypeA)
+	if key := msgCacheKey(r); key != "example.com.:1:1" {
+		t.Fatal("unexpected key", key)
+	}
+
+	do := r.Copy()
+	do.SetEdns0(ednsUDPSize, true)
+
+	ecs := r.Copy()
+	ecs.SetEdns0(ednsUDPSize, false)
+	ecs.IsEdns0().Option = append(ecs.IsEdns0().Option,
+		newECSOption(&net.IPNet{IP: net.IPv4(1, 2, 3, 0), Mask: net.CIDRMask(24, 32)}))
+
+	other := ecs.Copy()
+	other.IsEdns0().Option[0] = newECSOption(&net.IPNet{IP: net.IPv4(5, 6, 7, 0), Mask: net.CIDRMask(24, 32)})
+
+	cd := r.Copy()
+	cd.CheckingDisabled = true
+
+	// the opt without the DO bit and the ecs is the same
+	plain := r.Copy()
+	plain.SetEdns0(ednsUDPSize, false)
+
+	keys := make(map[string]bool)
+	for _, m := range []*dns.Msg{r, do, ecs, other, cd} {
+		keys[msgCacheKey(m)] = true
+	}
+	if len(keys) != 5 || !keys[msgCacheKey(plain)] {
+		t.Fatal("keys should vary on the CD and DO bits and the client subnet", keys)
+	}
+
+	c := newMsgCache(10)
+	c.set(do, newTestAnswer(t, do, 300), time.Minute)
+	if c.get(r) != nil || c.get(ecs) != nil || c.get(do) == nil {
+		t.Fatal("response should be cached by the key of the request")
+	}
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	negativeCacheSize = 1024
+	// defaultNegativeMaxTTL is the default cap of negative cache ttl
+	defaultNegativeMaxTTL = time.Duration(time.Minute * 5)
+	// serverFailureTTL is the negative cache ttl of SERVFAIL, it has no SOA
+	serverFailureTTL = time.Duration(time.Second * 30)
+)
+
+// negativeCache cache NXDOMAIN, NODATA and SERVFAIL response of upstream (RFC 2308)
+type negativeCache struct {
+	cache  *msgCache
+	maxTTL time.Duration
+}
+
+func newNegativeCache(maxTTL time.Duration) *negativeCache {
+	return &negativeCache{
+		cache:  newMsgCache(negativeCacheSize),
+		maxTTL: maxTTL,
+	}
+}
+
+func (c *negativeCache) get(r *dns.Msg) *dns.Msg {
+	if c.maxTTL <= 0 {
+		return nil
+	}
+	return c.cache.get(r)
+}
+
+func (c *negativeCache) flush() {
+	c.cache.flush()
+}
+
+// set the negative response of the request
+func (c *negativeCache) set(r *dns.Msg, msg *dns.Msg) {
+	if c.maxTTL <= 0 || msg == nil {
+		return
+	}
+
+	ttl, ok := negativeTTL(msg)
+	if !ok {
+		return
+	}
+
+	if ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+
+	c.cache.set(r, msg, ttl)
+}
+
+// negativeTTL get the ttl of negative response, ok is false if msg is not negative
+func negativeTTL(msg *dns.Msg) (time.Duration, bool) {
+	switch msg.Rcode {
+	case dns.RcodeServerFailure:
+		return serverFailureTTL, true
+	case dns.RcodeNameError:
+	case dns.RcodeSuccess:
+		if len(msg.Answer) > 0 {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Minttl
+			if soa.Hdr.Ttl < ttl {
+				ttl = soa.Hdr.Ttl
+			}
+			return time.Duration(ttl) * time.Second, true
+		}
+	}
+
+	// without SOA, negative response should not be cached
+	return 0, false
+}
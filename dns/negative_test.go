@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newTestNegative(t *testing.T, r *dns.Msg, rcode int, soa string) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetRcode(r, rcode)
+	if soa != "" {
+		rr, err := dns.NewRR(soa)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Ns = append(msg.Ns, rr)
+	}
+	return msg
+}
+
+func TestNegativeTTL(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("nx.example.com.", dns.TypeA)
+
+	tests := []struct {
+		msg *dns.Msg
+		ttl time.Duration
+		ok  bool
+	}{
+		// the min of the soa ttl and the minimum
+		{newTestNegative(t, r, dns.RcodeNameError, "example.com. 300 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 60"), time.Minute, true},
+		{newTestNegative(t, r, dns.RcodeSuccess, "example.com. 30 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 600"), time.Second * 30, true},
+		{newTestNegative(t, r, dns.RcodeServerFailure, ""), serverFailureTTL, true},
+		// no soa is not cached
+		{newTestNegative(t, r, dns.RcodeNameError, ""), 0, false},
+		{newTestNegative(t, r, dns.RcodeRefused, ""), 0, false},
+		{newTestAnswer(t, r, 300), 0, false},
+	}
+
+	for i, test := range tests {
+		if ttl, ok := negativeTTL(test.msg); ttl != test.ttl || ok != test.ok {
+			t.Fatal("unexpected negative ttl of", i, ttl, ok)
+		}
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	c := newNegativeCache(time.Second * 10)
+
+	r := new(dns.Msg)
+	r.SetQuestion("nx.example.com.", dns.TypeA)
+	c.set(r, newTestNegative(t, r, dns.RcodeNameError, "example.com. 300 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 600"))
+
+	msg := c.get(r)
+	if msg == nil || msg.Rcode != dns.RcodeNameError {
+		t.Fatal("negative response should be cached")
+	}
+	// the ttl is capped
+	if e := c.cache.entries[msgCacheKey(r)].Value.(*msgCacheEntry); e.ttl != time.Second*10 {
+		t.Fatal("negative ttl should be capped", e.ttl)
+	}
+
+	do := r.Copy()
+	do.SetEdns0(ednsUDPSize, true)
+	if c.get(do) != nil {
+		t.Fatal("negative response should vary on DO")
+	}
+
+	c.flush()
+	if c.get(r) != nil {
+		t.Fatal("negative cache should be flushed")
+	}
+
+	// disabled
+	c = newNegativeCache(0)
+	c.set(r, newTestNegative(t, r, dns.RcodeServerFailure, ""))
+	if c.get(r) != nil {
+		t.Fatal("negative cache should be disabled")
+	}
+}
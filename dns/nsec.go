@@ -0,0 +1,261 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// denial check the NSEC or NSEC3 of the validated authority section prove the negative answer
+// of the question (RFC 4035 5.4 and RFC 5155 8), the proof relying on an opt-out NSEC3 is not
+// secure
+func denial(q dns.Question, rcode int, ns []dns.RR) (bool, error) {
+	name := canonicalName(q.Name)
+	nsecs, nsec3s := denialRecords(ns)
+	switch {
+	case len(nsecs) > 0:
+		if err := denialNSEC(name, q.Qtype, rcode, nsecs); err != nil {
+			return false, err
+		}
+		return true, nil
+	case len(nsec3s) > 0:
+		return denialNSEC3(name, q.Qtype, rcode, nsec3s)
+	}
+	return false, fmt.Errorf("no NSEC or NSEC3 of %s", name)
+}
+
+// denialNSEC check the name does not exist and no wildcard could match, or the name or the
+// matching wildcard does not have the type
+func denialNSEC(name string, qtype uint16, rcode int, nsecs []*dns.NSEC) error {
+	if rcode == dns.RcodeSuccess {
+		for _, nsec := range nsecs {
+			if canonicalName(nsec.Hdr.Name) == name {
+				if hasType(nsec.TypeBitMap, qtype) || hasType(nsec.TypeBitMap, dns.TypeCNAME) {
+					return fmt.Errorf("NSEC of %s has the type %s", name, dns.Type(qtype))
+				}
+				return nil
+			}
+		}
+	}
+
+	cover := coveringNSEC(nsecs, name)
+	if cover == nil {
+		return fmt.Errorf("no NSEC covers %s", name)
+	}
+
+	wildcard := "*." + nsecClosestEncloser(cover, name)
+	if wildcard == "*.." {
+		wildcard = "*."
+	}
+
+	if rcode == dns.RcodeSuccess {
+		// the wildcard nodata, the wildcard exists without the type
+		for _, nsec := range nsecs {
+			if canonicalName(nsec.Hdr.Name) == wildcard && !hasType(nsec.TypeBitMap, qtype) &&
+				!hasType(nsec.TypeBitMap, dns.TypeCNAME) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no NSEC proves %s has no %s", name, dns.Type(qtype))
+	}
+
+	if coveringNSEC(nsecs, wildcard) == nil {
+		return fmt.Errorf("no NSEC covers the wildcard %s", wildcard)
+	}
+	return nil
+}
+
+// denialNSEC3 check the closest encloser proof of the name and the wildcard of the closest
+// encloser, or the name or the matching wildcard does not have the type
+func denialNSEC3(name string, qtype uint16, rcode int, nsec3s []*dns.NSEC3) (bool, error) {
+	if rcode == dns.RcodeSuccess {
+		if match := matchingNSEC3(nsec3s, name); match != nil {
+			if hasType(match.TypeBitMap, qtype) || hasType(match.TypeBitMap, dns.TypeCNAME) {
+				return false, fmt.Errorf("NSEC3 of %s has the type %s", name, dns.Type(qtype))
+			}
+			return true, nil
+		}
+	}
+
+	encloser, next, err := nsec3ClosestEncloser(nsec3s, name)
+	if err != nil {
+		return false, err
+	}
+
+	cover := coveringNSEC3(nsec3s, next)
+	secure := cover.Flags&1 == 0
+	wildcard := "*." + encloser
+	if wildcard == "*.." {
+		wildcard = "*."
+	}
+
+	if rcode == dns.RcodeSuccess {
+		// the DS of the unsigned delegation in the opt-out span (RFC 5155 8.6)
+		if qtype == dns.TypeDS && !secure {
+			return false, nil
+		}
+
+		if match := matchingNSEC3(nsec3s, wildcard); match != nil && !hasType(match.TypeBitMap, qtype) &&
+			!hasType(match.TypeBitMap, dns.TypeCNAME) {
+			return secure, nil
+		}
+		return false, fmt.Errorf("no NSEC3 proves %s has no %s", name, dns.Type(qtype))
+	}
+
+	if coveringNSEC3(nsec3s, wildcard) == nil {
+		return false, fmt.Errorf("no NSEC3 covers the wildcard %s", wildcard)
+	}
+	return secure, nil
+}
+
+// wildcardProof check the name of the wildcard expansion does not exist, the next closer name
+// of the wildcard with the labels of the signature is denied (RFC 4035 5.3.4, RFC 5155 8.8)
+func wildcardProof(name string, labels uint8, ns []dns.RR) (bool, error) {
+	name = canonicalName(name)
+	indexes := dns.Split(name)
+	if int(labels) >= len(indexes) {
+		return true, nil
+	}
+	next := name[indexes[len(indexes)-int(labels)-1]:]
+
+	nsecs, nsec3s := denialRecords(ns)
+	if coveringNSEC(nsecs, name) != nil {
+		return true, nil
+	}
+
+	if cover := coveringNSEC3(nsec3s, next); cover != nil {
+		return cover.Flags&1 == 0, nil
+	}
+	return false, fmt.Errorf("no denial of %s expanded from the wildcard", name)
+}
+
+// denialRecords return the NSEC and NSEC3 of the section, the NSEC3 of the unknown hash
+// algorithm can not be checked and is ignored (RFC 5155 8.1)
+func denialRecords(ns []dns.RR) ([]*dns.NSEC, []*dns.NSEC3) {
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, rr := range ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, rr)
+		case *dns.NSEC3:
+			if rr.Hash == dns.SHA1 {
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+	return nsecs, nsec3s
+}
+
+// coveringNSEC return the NSEC proving the name does not exist, the NSEC of the delegation or
+// DNAME above the name is not from the zone of the name and is ignored (RFC 6840 4.1)
+func coveringNSEC(nsecs []*dns.NSEC, name string) *dns.NSEC {
+	for _, nsec := range nsecs {
+		owner, next := canonicalName(nsec.Hdr.Name), canonicalName(nsec.NextDomain)
+		if compareNames(owner, name) >= 0 {
+			continue
+		}
+
+		if dns.IsSubDomain(owner, name) && (isDelegation(nsec.TypeBitMap) || hasType(nsec.TypeBitMap, dns.TypeDNAME)) {
+			continue
+		}
+
+		// the last NSEC of the zone points back to the apex
+		if compareNames(owner, next) >= 0 {
+			if dns.IsSubDomain(next, name) {
+				return nsec
+			}
+			continue
+		}
+
+		if compareNames(name, next) < 0 {
+			return nsec
+		}
+	}
+	return nil
+}
+
+// nsecClosestEncloser return the longest ancestor of the name shared with the owner or the
+// next name of the covering NSEC
+func nsecClosestEncloser(nsec *dns.NSEC, name string) string {
+	n := dns.CompareDomainName(name, nsec.Hdr.Name)
+	if m := dns.CompareDomainName(name, nsec.NextDomain); m > n {
+		n = m
+	}
+
+	indexes := dns.Split(name)
+	if n == 0 || len(indexes) == 0 {
+		return "."
+	}
+	return name[indexes[len(indexes)-n]:]
+}
+
+// nsec3ClosestEncloser return the closest encloser of the name and the next closer name, the
+// encloser is matched and the next closer is covered (RFC 5155 8.3)
+func nsec3ClosestEncloser(nsec3s []*dns.NSEC3, name string) (string, string, error) {
+	indexes := dns.Split(name)
+	for i := 1; i <= len(indexes); i++ {
+		encloser := "."
+		if i < len(indexes) {
+			encloser = name[indexes[i]:]
+		}
+		match := matchingNSEC3(nsec3s, encloser)
+		if match == nil {
+			continue
+		}
+
+		if isDelegation(match.TypeBitMap) || hasType(match.TypeBitMap, dns.TypeDNAME) {
+			return "", "", fmt.Errorf("closest encloser %s of %s is a delegation or DNAME", encloser, name)
+		}
+
+		next := name[indexes[i-1]:]
+		if coveringNSEC3(nsec3s, next) == nil {
+			return "", "", fmt.Errorf("no NSEC3 covers the next closer %s", next)
+		}
+		return encloser, next, nil
+	}
+	return "", "", fmt.Errorf("no closest encloser of %s", name)
+}
+
+func matchingNSEC3(nsec3s []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+// coveringNSEC3 return the NSEC3 covering the hash of the name, the hash of the owner is
+// matched by Cover too and excluded
+func coveringNSEC3(nsec3s []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Cover(name) && !nsec3.Match(name) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// compareNames compare the names in the canonical order, the labels from the right, each as
+// the lowercase bytes (RFC 4034 6.1)
+func compareNames(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
@@ -0,0 +1,155 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func negativeMsg(t *testing.T, name string, qtype uint16, rcode int, ns ...[]dns.RR) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.Rcode = rcode
+	for _, rrs := range ns {
+		msg.Ns = append(msg.Ns, rrs...)
+	}
+	return msg
+}
+
+func TestValidatorDenialNSEC(t *testing.T) {
+	v, example := newTestValidator(t)
+	soa := example.sign(t, testRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 3600"))
+	apex := example.sign(t, testRR(t, "example. 3600 IN NSEC b.example. NS SOA RRSIG NSEC DNSKEY"))
+	b := example.sign(t, testRR(t, "b.example. 3600 IN NSEC d.example. A RRSIG NSEC"))
+	www := example.sign(t, testRR(t, "www.example. 3600 IN NSEC z.example. A RRSIG NSEC"))
+	wildcard := example.sign(t, testRR(t, "*.example. 3600 IN NSEC b.example. TXT RRSIG NSEC"))
+
+	tests := []struct {
+		name   string
+		msg    *dns.Msg
+		secure bool
+		bogus  bool
+	}{
+		{"nxdomain", negativeMsg(t, "a.example.", dns.TypeA, dns.RcodeNameError, soa, apex), true, false},
+		{"nxdomain without NSEC", negativeMsg(t, "a.example.", dns.TypeA, dns.RcodeNameError, soa), false, true},
+		{"nxdomain not covered", negativeMsg(t, "a.example.", dns.TypeA, dns.RcodeNameError, soa, b), false, true},
+		{"nxdomain wildcard not covered", negativeMsg(t, "c.example.", dns.TypeA, dns.RcodeNameError, soa, b), false, true},
+		{"nxdomain wildcard covered", negativeMsg(t, "c.example.", dns.TypeA, dns.RcodeNameError, soa, b, apex), true, false},
+		{"nodata", negativeMsg(t, "www.example.", dns.TypeAAAA, dns.RcodeSuccess, soa, www), true, false},
+		{"nodata type exists", negativeMsg(t, "www.example.", dns.TypeA, dns.RcodeSuccess, soa, www), false, true},
+		{"wildcard nodata", negativeMsg(t, "c.example.", dns.TypeA, dns.RcodeSuccess, soa, b, wildcard), true, false},
+		{"wildcard nodata type exists", negativeMsg(t, "c.example.", dns.TypeTXT, dns.RcodeSuccess, soa, b, wildcard), false, true},
+		{"unsigned NSEC", negativeMsg(t, "a.example.", dns.TypeA, dns.RcodeNameError, soa, apex[:1]), false, true},
+	}
+
+	for _, test := range tests {
+		secure, err := v.validate(test.msg, time.Now())
+		if (err != nil) != test.bogus || secure != test.secure {
+			t.Errorf("%s: expect secure %v bogus %v, got %v, %v", test.name, test.secure, test.bogus, secure, err)
+		}
+	}
+}
+
+// nsec3Chain return the NSEC3 of the names, the owners are the hashes linked in order
+func nsec3Chain(t *testing.T, z *testZone, optOut bool, names ...string) [][]dns.RR {
+	var sorted []string
+	for _, name := range names {
+		sorted = append(sorted, dns.HashName(name, dns.SHA1, 1, "AABBCCDD"))
+	}
+
+	sort.Strings(sorted)
+
+	flags := 0
+	if optOut {
+		flags = 1
+	}
+
+	chain := make([][]dns.RR, 0, len(sorted))
+	for i, hash := range sorted {
+		next := sorted[(i+1)%len(sorted)]
+		chain = append(chain, z.sign(t, testRR(t, fmt.Sprintf("%s.example. 3600 IN NSEC3 1 %d 1 AABBCCDD %s A RRSIG", hash, flags, next))))
+	}
+	return chain
+}
+
+func TestValidatorDenialNSEC3(t *testing.T) {
+	v, example := newTestValidator(t)
+	soa := example.sign(t, testRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 3600"))
+
+	chain := nsec3Chain(t, example, false, "example.", "www.example.")
+	all := append([][]dns.RR{soa}, chain...)
+
+	msg := negativeMsg(t, "a.example.", dns.TypeA, dns.RcodeNameError, all...)
+	if secure, err := v.validate(msg, time.Now()); err != nil || !secure {
+		t.Fatal("nxdomain proven by NSEC3 should be secure", err)
+	}
+
+	msg = negativeMsg(t, "www.example.", dns.TypeAAAA, dns.RcodeSuccess, all...)
+	if secure, err := v.validate(msg, time.Now()); err != nil || !secure {
+		t.Fatal("nodata proven by NSEC3 should be secure", err)
+	}
+
+	msg = negativeMsg(t, "www.example.", dns.TypeA, dns.RcodeSuccess, all...)
+	if _, err := v.validate(msg, time.Now()); err == nil {
+		t.Fatal("nodata of the type existing should be bogus")
+	}
+
+	// without the NSEC3 covering the next closer
+	var partial [][]dns.RR
+	for _, rrs := range chain {
+		if !rrs[0].(*dns.NSEC3).Cover("a.example.") {
+			partial = append(partial, rrs)
+		}
+	}
+	msg = negativeMsg(t, "a.example.", dns.TypeA, dns.RcodeNameError, append([][]dns.RR{soa}, partial...)...)
+	if _, err := v.validate(msg, time.Now()); err == nil {
+		t.Fatal("nxdomain without the covering NSEC3 should be bogus")
+	}
+
+	optOut := nsec3Chain(t, example, true, "example.", "www.example.")
+	msg = negativeMsg(t, "a.example.", dns.TypeA, dns.RcodeNameError, append([][]dns.RR{soa}, optOut...)...)
+	if secure, err := v.validate(msg, time.Now()); err != nil || secure {
+		t.Fatal("nxdomain proven by opt-out NSEC3 should be insecure", err)
+	}
+}
+
+func TestValidatorWildcard(t *testing.T) {
+	v, example := newTestValidator(t)
+
+	answer := example.sign(t, testRR(t, "*.example. 300 IN A 192.0.2.1"))
+	answer[0].Header().Name = "a.example."
+	answer[1].Header().Name = "a.example."
+
+	msg := &dns.Msg{Answer: answer}
+	if _, err := v.validate(msg, time.Now()); err == nil {
+		t.Fatal("wildcard expansion without the denial should be bogus")
+	}
+
+	msg.Ns = example.sign(t, testRR(t, "example. 3600 IN NSEC b.example. NS SOA RRSIG NSEC DNSKEY"))
+	if secure, err := v.validate(msg, time.Now()); err != nil || !secure {
+		t.Fatal("wildcard expansion with the denial should be secure", err)
+	}
+
+	msg.Ns = example.sign(t, testRR(t, "b.example. 3600 IN NSEC d.example. A RRSIG NSEC"))
+	if _, err := v.validate(msg, time.Now()); err == nil {
+		t.Fatal("wildcard expansion with the NSEC not covering should be bogus")
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	// the canonical order of RFC 4034 6.1
+	names := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.",
+		"zABC.a.EXAMPLE.", "z.example.", "*.z.example."}
+	for i := 1; i < len(names); i++ {
+		if compareNames(names[i-1], names[i]) >= 0 || compareNames(names[i], names[i-1]) <= 0 {
+			t.Errorf("expect %s before %s", names[i-1], names[i])
+		}
+	}
+
+	if compareNames("Z.a.example.", "z.A.example.") != 0 {
+		t.Error("expect the names equal ignoring the case")
+	}
+}
@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+	"github.com/yinheli/kungfu/tracing"
+)
+
+const (
+	// poisonMinRTT the early answer check only applies to upstream slower than this
+	poisonMinRTT = time.Duration(time.Millisecond * 50)
+	// poisonEarlyRatio answer arrives faster than srtt * ratio is suspicious
+	poisonEarlyRatio = 0.2
+)
+
+// poisonGuard detect the injected bogus answers of udp upstream,
+// and re-resolve via the trusted upstream
+type poisonGuard struct {
+	store   internal.Store
+	trusted *upstream
+	// autoProxy add the poisoned domain to the proxy domain set
+	autoProxy bool
+
+	lock  sync.RWMutex
+	bogus map[string]bool
+}
+
+// loadBogus load the known bogus ip set
+func (g *poisonGuard) loadBogus() {
+	if g == nil {
+		return
+	}
+
+	ips, err := g.store.SMembers(internal.GetRedisBogusIpSetKey())
+	if err != nil {
+		log.Error("get bogus ip set error, %v", err)
+		return
+	}
+
+	bogus := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		bogus[ip] = true
+	}
+
+	g.lock.Lock()
+	g.bogus = bogus
+	g.lock.Unlock()
+}
+
+// poisoned check the response, expected is the srtt of the upstream before the exchange
+func (g *poisonGuard) poisoned(resp *dns.Msg, rtt time.Duration, expected time.Duration) (bool, string) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	for _, rr := range resp.Answer {
+		var ip string
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A.String()
+		case *dns.AAAA:
+			ip = v.AAAA.String()
+		default:
+			continue
+		}
+
+		if g.bogus[ip] {
+			return true, "bogus ip " + ip
+		}
+	}
+
+	if len(resp.Answer) > 0 && expected >= poisonMinRTT &&
+		float64(rtt) < float64(expected)*poisonEarlyRatio {
+		return true, "answer too early, rtt: " + rtt.String() + ", srtt: " + expected.String()
+	}
+
+	return false, ""
+}
+
+// exchange with the upstream, re-resolve via the trusted upstream if the answer is poisoned,
+// traced as the child of the span of the query
+func (h *handler) exchange(ns *upstream, r *dns.Msg, parent *tracing.Span) (resp *dns.Msg, err error) {
+	span := parent.Child("upstream exchange", tracing.KindClient)
+	span.SetAttr("dns.upstream", ns.String())
+	defer func() {
+		span.SetError(err)
+		if resp != nil {
+			span.SetAttr("dns.rcode", dns.RcodeToString[resp.Rcode])
+		}
+		span.End()
+	}()
+
+	expected := ns.rtt()
+	resp, rtt, err := ns.exchange(r)
+	if err != nil || h.poison == nil || ns.net != "udp" || ns == h.poison.trusted {
+		return resp, err
+	}
+
+	poisoned, reason := h.poison.poisoned(resp, rtt, expected)
+	if !poisoned {
+		return resp, err
+	}
+
+	qname := r.Question[0].Name
+	log.Warning("poisoned answer of %s from %s, %s, re-resolve on %s", qname, ns, reason, h.poison.trusted)
+	span.SetAttr("dns.poisoned", reason)
+
+	if h.poison.autoProxy {
+		h.addProxyDomain(qname)
+	}
+
+	resp, _, err = h.poison.trusted.exchange(r)
+	return resp, err
+}
+
+// addProxyDomain add the domain to proxy domain set and notify reload
+func (h *handler) addProxyDomain(qname string) {
+	domain := strings.ToLower(strings.TrimSuffix(qname, "."))
+	store := h.server.Store
+	if err := store.SAdd(internal.GetRedisProxyDomainSetKey(), domain); err != nil {
+		log.Error("add proxy domain %s error, %v", domain, err)
+		return
+	}
+
+	log.Info("add poisoned domain %s to proxy domain set", domain)
+	store.Publish(internal.GetRedisProxyDomainChannelKey(), domain)
+}
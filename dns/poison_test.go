@@ -0,0 +1,36 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPoisoned(t *testing.T) {
+	g := &poisonGuard{bogus: map[string]bool{"243.185.187.39": true}}
+
+	resp := new(dns.Msg)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("243.185.187.39"),
+	})
+
+	if ok, _ := g.poisoned(resp, time.Millisecond*100, time.Millisecond*100); !ok {
+		t.Fatal("bogus ip should be poisoned")
+	}
+
+	resp.Answer[0].(*dns.A).A = net.ParseIP("93.184.216.34")
+	if ok, reason := g.poisoned(resp, time.Millisecond*100, time.Millisecond*100); ok {
+		t.Fatalf("normal answer should not be poisoned, %s", reason)
+	}
+
+	if ok, _ := g.poisoned(resp, time.Millisecond*5, time.Millisecond*200); !ok {
+		t.Fatal("early answer should be poisoned")
+	}
+
+	if ok, _ := g.poisoned(resp, time.Millisecond*1, time.Millisecond*20); ok {
+		t.Fatal("fast upstream should not be checked for early answer")
+	}
+}
@@ -0,0 +1,199 @@
+package dns
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// prefetchInterval is the interval to refresh the popular domains
+	prefetchInterval = time.Duration(time.Minute)
+	// prefetchAhead refresh the cached answers expire in it, before the next round
+	prefetchAhead = prefetchInterval * 2
+	// prefetchTrackSize is the max questions counted, the counts are halved if exceeded
+	prefetchTrackSize = 10000
+)
+
+// prefetchQuestion is the question counted, internal is true if answered by the fake ip
+type prefetchQuestion struct {
+	name     string
+	qtype    uint16
+	internal bool
+}
+
+// prefetcher count the queries of the questions in each round, the top questions are refreshed
+// before expire, the fake ip mappings are touched and the upstream answers are re-resolved
+type prefetcher struct {
+	lock   sync.Mutex
+	size   int
+	counts map[prefetchQuestion]uint64
+}
+
+func newPrefetcher() *prefetcher {
+	return &prefetcher{counts: make(map[prefetchQuestion]uint64)}
+}
+
+// setSize set the count of the top questions refreshed, 0 to disable, nil prefetcher is no-op
+func (p *prefetcher) setSize(size int) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.size = size
+	if size == 0 {
+		p.counts = make(map[prefetchQuestion]uint64)
+	}
+}
+
+// record the query answered by the fake ip or the upstream, nil prefetcher is no-op
+func (p *prefetcher) record(q dns.Question, outcome string) {
+	if p == nil || (outcome != "internal" && outcome != "upstream") {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.size == 0 {
+		return
+	}
+
+	key := prefetchQuestion{name: strings.ToLower(q.Name), qtype: q.Qtype, internal: outcome == "internal"}
+	if _, ok := p.counts[key]; !ok && len(p.counts) >= prefetchTrackSize {
+		p.decay()
+	}
+	p.counts[key]++
+}
+
+// top return the most queried questions of the round, the counts are halved for the next round,
+// the questions not queried recently are dropped
+func (p *prefetcher) top() []prefetchQuestion {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	questions := make([]prefetchQuestion, 0, len(p.counts))
+	for q := range p.counts {
+		questions = append(questions, q)
+	}
+	sort.Slice(questions, func(i, j int) bool {
+		ci, cj := p.counts[questions[i]], p.counts[questions[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return questions[i].name < questions[j].name
+	})
+
+	if len(questions) > p.size {
+		questions = questions[:p.size]
+	}
+	p.decay()
+	return questions
+}
+
+func (p *prefetcher) decay() {
+	for q, n := range p.counts {
+		if n /= 2; n == 0 {
+			delete(p.counts, q)
+		} else {
+			p.counts[q] = n
+		}
+	}
+}
+
+// loadPrefetch load the count of the popular domains to prefetch, 0 if disabled
+func (server *Server) loadPrefetch() int {
+	value, err := server.Store.Get(internal.GetRedisPrefetchKey())
+	if err != nil {
+		if err != internal.ErrNil {
+			log.Error("get prefetch config error, %v", err)
+		}
+		return 0
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		log.Error("invalid prefetch config: %s", value)
+		return 0
+	}
+
+	log.Info("prefetch popular domains: %d", n)
+	return n
+}
+
+// runPrefetch refresh the popular questions periodically
+func (server *Server) runPrefetch() {
+	ticker := time.NewTicker(prefetchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.done:
+			return
+		case <-ticker.C:
+		}
+
+		mappings, answers := server.handler.prefetch(server.prefetch.top())
+		if mappings > 0 || answers > 0 {
+			log.Debug("prefetch mappings: %d, answers: %d", mappings, answers)
+		}
+	}
+}
+
+// prefetch refresh the questions, extend the mappings expire in half the mapping ttl, and
+// re-resolve the answers expire before the next round
+func (h *handler) prefetch(questions []prefetchQuestion) (mappings int, answers int) {
+	for _, q := range questions {
+		if q.internal && h.server.getIPSet() == nil && (q.qtype == dns.TypeA || q.qtype == dns.TypeAAAA) {
+			if h.prefetchMapping(q) {
+				prefetchTotal.Inc("mapping")
+				mappings++
+			}
+			continue
+		}
+
+		r := new(dns.Msg)
+		r.SetQuestion(q.name, q.qtype)
+		cached, remain, refresh := h.answers.get(r)
+		if cached != nil && remain > prefetchAhead && !refresh {
+			continue
+		}
+
+		h.refreshAnswer(r)
+		prefetchTotal.Inc("answer")
+		answers++
+	}
+	return
+}
+
+// prefetchMapping touch the mapping of the question if it expires in half the mapping ttl
+func (h *handler) prefetchMapping(q prefetchQuestion) bool {
+	store := h.server.Store
+	key := getDomainKey(q.qtype, q.name)
+
+	ttl, err := store.TTL(key)
+	if err != nil || ttl <= 0 || ttl > h.server.toucher.TTL()/2 {
+		return false
+	}
+
+	ip, err := store.Get(key)
+	if err != nil {
+		return false
+	}
+
+	err = internal.TouchMapping(store, net.ParseIP(ip), h.server.toucher.TTL(), false)
+	if err != nil {
+		if err != internal.ErrNil && err != internal.ErrStoreUnavailable {
+			log.Warning("prefetch mapping of %s error, %v", q.name, err)
+		}
+		return false
+	}
+	return true
+}
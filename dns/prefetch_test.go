@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestPrefetcherTop(t *testing.T) {
+	p := newPrefetcher()
+	q := func(name string) dns.Question {
+		return dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	}
+
+	p.record(q("google.com."), "internal")
+	if len(p.top()) != 0 {
+		t.Fatal("disabled prefetcher should not count")
+	}
+
+	p.setSize(1)
+	for i := 0; i < 3; i++ {
+		p.record(q("Google.com."), "internal")
+	}
+	p.record(q("baidu.com."), "upstream")
+	p.record(q("ads.example.com."), "reject")
+
+	top := p.top()
+	if len(top) != 1 || top[0].name != "google.com." || !top[0].internal {
+		t.Fatalf("unexpected top %+v", top)
+	}
+
+	// halved, the domain queried once is dropped
+	if len(p.counts) != 1 || p.counts[top[0]] != 1 {
+		t.Fatalf("counts should decay, %+v", p.counts)
+	}
+}
+
+func TestPrefetchMapping(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	server := &Server{Store: store, pool: newAllocator(store, "current-ip")}
+	server.pool.reset(net.ParseIP("10.85.0.2").To4(), 10)
+	h := &handler{server: server}
+
+	ip, err := server.pool.allocate(testDomainKey("google.com"), "google.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := prefetchQuestion{name: "google.com.", qtype: dns.TypeA, internal: true}
+	if mappings, _ := h.prefetch([]prefetchQuestion{q}); mappings != 1 {
+		t.Fatal("the mapping expire soon should be prefetched")
+	}
+
+	key := getDomainKey(dns.TypeA, "google.com.")
+	if ttl, _ := store.TTL(key); ttl <= time.Minute {
+		t.Fatalf("the mapping ttl should be extended, %v", ttl)
+	}
+	if ttl, _ := store.TTL(internal.GetRedisIpKey(ip.String())); ttl <= time.Minute {
+		t.Fatalf("the ip ttl should be extended, %v", ttl)
+	}
+
+	if mappings, _ := h.prefetch([]prefetchQuestion{q}); mappings != 0 {
+		t.Fatal("the fresh mapping should not be prefetched")
+	}
+}
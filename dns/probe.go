@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"net/http"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// probeOK is the result of the passed readiness check
+const probeOK = "ok"
+
+// healthz report the process is alive, for the liveness probe
+func (a *adminHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": probeOK})
+}
+
+// readyz report whether the server can answer the queries, the store is reachable, at least one
+// upstream is healthy and the rules are loaded, 503 with the failed checks if not ready
+func (a *adminHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	checks, ready := a.server.readiness()
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+// readiness check the store, the upstreams and the rules, the result of each check is ok or
+// the reason of failure
+func (server *Server) readiness() (map[string]string, bool) {
+	checks := map[string]string{"store": probeOK, "upstream": probeOK, "rules": probeOK}
+
+	if server.shuttingDown() {
+		checks["server"] = "shutting down"
+	}
+
+	if _, err := server.Store.Get(internal.GetRedisNetworkKey()); err != nil && err != internal.ErrNil {
+		checks["store"] = err.Error()
+	}
+
+	if server.handler == nil {
+		checks["upstream"] = "not started"
+	} else {
+		nameserver, _ := server.handler.upstreams()
+		checks["upstream"] = "no healthy upstream"
+		for _, ns := range nameserver {
+			if ns.healthy() {
+				checks["upstream"] = probeOK
+				break
+			}
+		}
+	}
+
+	if server.getRules() == nil {
+		checks["rules"] = "not loaded"
+	}
+
+	for _, result := range checks {
+		if result != probeOK {
+			return checks, false
+		}
+	}
+	return checks, true
+}
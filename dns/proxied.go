@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+	"golang.org/x/net/proxy"
+)
+
+// the policy of the other query types (ANY, TXT, MX ...) of the proxied domains
+const (
+	// proxiedQueryUpstream forward to the upstream nameservers as the direct domains
+	proxiedQueryUpstream = "upstream"
+	// proxiedQueryRefuse answer REFUSED
+	proxiedQueryRefuse = "refuse"
+	// proxiedQueryEmpty answer empty (NODATA)
+	proxiedQueryEmpty = "empty"
+	// proxiedQueryProxy forward to the nameserver over tcp through the proxy
+	proxiedQueryProxy = "proxy"
+)
+
+// defaultProxyNameserver is the nameserver queried through the proxy
+const defaultProxyNameserver = "8.8.8.8:53"
+
+// proxiedQuery is the policy of the other query types of the proxied domains
+type proxiedQuery struct {
+	policy     string
+	dialer     proxy.Dialer
+	nameserver string
+	timeout    time.Duration
+}
+
+// loadProxiedQuery load the policy from store, fallback to upstream if invalid
+func (server *Server) loadProxiedQuery() *proxiedQuery {
+	p := &proxiedQuery{policy: proxiedQueryUpstream, timeout: upstreamTimeout}
+
+	policy, err := server.Store.Get(internal.GetRedisProxiedQueryKey())
+	if err != nil {
+		if err != internal.ErrNil {
+			log.Error("get proxied query policy error, %v", err)
+		}
+		return p
+	}
+
+	switch policy {
+	case proxiedQueryUpstream, proxiedQueryRefuse, proxiedQueryEmpty:
+	case proxiedQueryProxy:
+		if p.dialer, err = server.loadProxyDialer(); err != nil {
+			log.Error("proxied query policy %s unavailable, use %s, %v", policy, proxiedQueryUpstream, err)
+			return p
+		}
+
+		p.nameserver, err = server.Store.Get(internal.GetRedisProxyNameserverKey())
+		if err != nil || p.nameserver == "" {
+			p.nameserver = defaultProxyNameserver
+		}
+	default:
+		log.Error("invalid proxied query policy %s, use %s", policy, proxiedQueryUpstream)
+		return p
+	}
+
+	p.policy = policy
+	log.Info("proxied query policy: %s", policy)
+	return p
+}
+
+// loadProxyDialer create the dialer of the proxy config, the same proxy as the gateway
+func (server *Server) loadProxyDialer() (proxy.Dialer, error) {
+	value, err := server.Store.Get(internal.GetRedisProxyKey())
+	if err != nil {
+		return nil, fmt.Errorf("get proxy config error, %v", err)
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.FromURL(u, proxy.Direct)
+}
+
+func (h *handler) getProxiedQuery() *proxiedQuery {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.proxied
+}
+
+// resolveProxied resolve the other query types of the proxied domains by the policy
+func (h *handler) resolveProxied(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
+	p := h.getProxiedQuery()
+	if p == nil {
+		return h.resolveUpstream(r, info)
+	}
+
+	switch p.policy {
+	case proxiedQueryRefuse:
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeRefused)
+		return msg, nil
+	case proxiedQueryEmpty:
+		return newEmptyReply(r), nil
+	case proxiedQueryProxy:
+		info.upstream = "proxy://" + p.nameserver
+		return p.exchange(r)
+	}
+	return h.resolveUpstream(r, info)
+}
+
+// exchange the query with the nameserver over tcp through the proxy
+func (p *proxiedQuery) exchange(r *dns.Msg) (*dns.Msg, error) {
+	conn, err := p.dialer.Dial("tcp", p.nameserver)
+	if err != nil {
+		return nil, err
+	}
+
+	co := &dns.Conn{Conn: conn}
+	defer co.Close()
+
+	co.SetDeadline(time.Now().Add(p.timeout))
+	if err = co.WriteMsg(r); err != nil {
+		return nil, err
+	}
+	return co.ReadMsg()
+}
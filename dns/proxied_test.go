@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+	"golang.org/x/net/proxy"
+)
+
+func TestProxiedQuery(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	server := &Server{Store: store}
+
+	r := new(dns.Msg)
+	r.SetQuestion("google.com.", dns.TypeTXT)
+
+	tests := []struct {
+		policy string
+		rcode  int
+	}{
+		{proxiedQueryRefuse, dns.RcodeRefused},
+		{proxiedQueryEmpty, dns.RcodeSuccess},
+	}
+
+	for _, test := range tests {
+		store.Set(internal.GetRedisProxiedQueryKey(), test.policy, 0)
+		h := &handler{server: server, proxied: server.loadProxiedQuery()}
+
+		msg, err := h.resolveProxied(r, new(queryInfo))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Rcode != test.rcode || len(msg.Answer) != 0 {
+			t.Fatalf("policy %s expect rcode %d, got %v", test.policy, test.rcode, msg)
+		}
+	}
+
+	// proxy policy is unavailable without the proxy config
+	store.Set(internal.GetRedisProxiedQueryKey(), proxiedQueryProxy, 0)
+	if p := server.loadProxiedQuery(); p.policy != proxiedQueryUpstream {
+		t.Fatalf("expect fallback to upstream, got %s", p.policy)
+	}
+}
+
+func TestProxiedQueryExchange(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &dns.Server{Listener: l, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN TXT \"hello\"")
+		msg.Answer = append(msg.Answer, rr)
+		w.WriteMsg(msg)
+	})}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	p := &proxiedQuery{policy: proxiedQueryProxy, dialer: proxy.Direct, nameserver: l.Addr().String(), timeout: upstreamTimeout}
+
+	r := new(dns.Msg)
+	r.SetQuestion("google.com.", dns.TypeTXT)
+	msg, err := p.exchange(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msg.Answer) != 1 {
+		t.Fatalf("expect the answer through proxy, got %v", msg)
+	}
+}
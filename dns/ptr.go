@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// parseReverseAddr return the ip of the in-addr.arpa or ip6.arpa name, nil if invalid
+func parseReverseAddr(qname string) net.IP {
+	name := strings.ToLower(strings.TrimSuffix(qname, "."))
+
+	if s := strings.TrimSuffix(name, ".in-addr.arpa"); s != name {
+		labels := strings.Split(s, ".")
+		if len(labels) != net.IPv4len {
+			return nil
+		}
+		ip := make(net.IP, net.IPv4len)
+		for i, label := range labels {
+			n, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return nil
+			}
+			ip[net.IPv4len-1-i] = byte(n)
+		}
+		return ip
+	}
+
+	if s := strings.TrimSuffix(name, ".ip6.arpa"); s != name {
+		nibbles := strings.Split(s, ".")
+		if len(nibbles) != net.IPv6len*2 {
+			return nil
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, nibble := range nibbles {
+			n, err := strconv.ParseUint(nibble, 16, 4)
+			if err != nil || len(nibble) != 1 {
+				return nil
+			}
+			// the nibbles are from the lowest
+			j := len(nibbles) - 1 - i
+			ip[j/2] |= byte(n) << uint(4*(1-j%2))
+		}
+		return ip
+	}
+	return nil
+}
+
+// resolveFakePTR answer the PTR of the fake ip with the mapped domain, NXDOMAIN if the ip is
+// not allocated, nil if the ip is not in the pools
+func (h *handler) resolveFakePTR(r *dns.Msg) (*dns.Msg, error) {
+	qname := r.Question[0].Name
+	ip := parseReverseAddr(qname)
+	if ip == nil || !(h.server.pool.owns(ip) || h.server.pool6.owns(ip)) {
+		return nil, nil
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+
+	domain, err := h.server.Store.Get(internal.GetRedisIpKey(ip.String()))
+	if err == internal.ErrNil {
+		log.Debug("resolve ptr %s, fake ip %s is not allocated", qname, ip)
+		msg.Rcode = dns.RcodeNameError
+		return msg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ptr := new(dns.PTR)
+	ptr.Hdr = dns.RR_Header{
+		Name:   dns.Fqdn(qname),
+		Rrtype: dns.TypePTR,
+		Class:  dns.ClassINET,
+		Ttl:    0,
+	}
+	ptr.Ptr = dns.Fqdn(domain)
+	msg.Answer = append(msg.Answer, ptr)
+	return msg, nil
+}
@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestParseReverseAddr(t *testing.T) {
+	for _, s := range []string{"10.85.0.2", "fd00:6b75:6e67:6675::1:2"} {
+		arpa, _ := dns.ReverseAddr(s)
+		if ip := parseReverseAddr(arpa); !ip.Equal(net.ParseIP(s)) {
+			t.Fatalf("unexpected ip %s of %s", ip, arpa)
+		}
+	}
+
+	for _, arpa := range []string{"0.85.10.in-addr.arpa.", "2.0.85.300.in-addr.arpa.", "google.com."} {
+		if ip := parseReverseAddr(arpa); ip != nil {
+			t.Fatalf("invalid %s should be nil, %s", arpa, ip)
+		}
+	}
+}
+
+func TestResolveFakePTR(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	server := &Server{Store: store, pool: newAllocator(store, "current-ip")}
+	server.pool.reset(net.ParseIP("10.85.0.2").To4(), 10)
+	h := &handler{server: server}
+
+	ip, err := server.pool.allocate(testDomainKey("google.com"), "google.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(ip string) *dns.Msg {
+		arpa, _ := dns.ReverseAddr(ip)
+		r := new(dns.Msg)
+		r.SetQuestion(arpa, dns.TypePTR)
+		msg, err := h.resolveFakePTR(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	msg := query(ip.String())
+	if msg == nil || len(msg.Answer) != 1 || msg.Answer[0].(*dns.PTR).Ptr != "google.com." {
+		t.Fatal("unexpected answer", msg)
+	}
+
+	if msg = query("10.85.0.10"); msg == nil || msg.Rcode != dns.RcodeNameError {
+		t.Fatal("not allocated fake ip should be NXDOMAIN", msg)
+	}
+
+	if msg = query("192.168.1.1"); msg != nil {
+		t.Fatal("ip out of the pool should be resolved by upstream", msg)
+	}
+}
@@ -0,0 +1,272 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// queryLogBufferSize is the pending entries, new entries are dropped if full
+	queryLogBufferSize = 4096
+
+	defaultQueryLogMaxSize    = 100
+	defaultQueryLogMaxBackups = 3
+	defaultQueryLogMaxLen     = 100000
+)
+
+// queryLogEntry is the record of one query
+type queryLogEntry struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Qname    string    `json:"qname"`
+	Qtype    string    `json:"qtype"`
+	Rcode    string    `json:"rcode"`
+	Answer   []string  `json:"answer,omitempty"`
+	Upstream string    `json:"upstream,omitempty"`
+	// Decision is how the query resolved, hosts, reject, ptr, internal, upstream or fail
+	Decision string  `json:"decision"`
+	Elapsed  float64 `json:"elapsed_ms"`
+}
+
+// queryLogSink write the entries, called in the single log goroutine
+type queryLogSink interface {
+	write(entry *queryLogEntry) error
+}
+
+// queryLogger record the queries asynchronously, nil logger logs nothing
+type queryLogger struct {
+	dropped uint64
+
+	sink    queryLogSink
+	sample  float64
+	entries chan *queryLogEntry
+}
+
+func newQueryLogger(config *internal.QueryLog, store internal.Store) (*queryLogger, error) {
+	var sink queryLogSink
+	var err error
+
+	switch config.Sink {
+	case "":
+		return nil, nil
+	case "file":
+		sink, err = newFileSink(config.File, config.MaxSize, config.MaxBackups)
+	case "syslog":
+		sink, err = newSyslogSink()
+	case "redis":
+		sink = newStreamSink(store, config.Stream, config.MaxLen)
+	default:
+		err = fmt.Errorf("unsupported query log sink %s", config.Sink)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &queryLogger{
+		sink:    sink,
+		sample:  config.Sample,
+		entries: make(chan *queryLogEntry, queryLogBufferSize),
+	}
+	go l.run()
+
+	log.Info("query log enabled, sink: %s, sample: %v", config.Sink, config.Sample)
+	return l, nil
+}
+
+// log the query, skipped by sampling or the buffer is full
+func (l *queryLogger) log(remote net.Addr, r *dns.Msg, msg *dns.Msg, info *queryInfo, decision string, elapsed time.Duration) {
+	if l == nil {
+		return
+	}
+
+	if l.sample > 0 && l.sample < 1 && rand.Float64() >= l.sample {
+		return
+	}
+
+	entry := newQueryLogEntry(remote, r, msg, info, decision, elapsed)
+	select {
+	case l.entries <- entry:
+	default:
+		if atomic.AddUint64(&l.dropped, 1)%1000 == 1 {
+			log.Warning("query log buffer is full, dropped %d entries", atomic.LoadUint64(&l.dropped))
+		}
+	}
+}
+
+// newQueryLogEntry create the record of the query
+func newQueryLogEntry(remote net.Addr, r *dns.Msg, msg *dns.Msg, info *queryInfo, decision string, elapsed time.Duration) *queryLogEntry {
+	q := r.Question[0]
+	entry := &queryLogEntry{
+		Time:     time.Now(),
+		Qname:    q.Name,
+		Qtype:    dns.Type(q.Qtype).String(),
+		Rcode:    dns.RcodeToString[dns.RcodeServerFailure],
+		Upstream: info.upstream,
+		Decision: decision,
+		Elapsed:  float64(elapsed) / float64(time.Millisecond),
+	}
+
+	if host, _, err := net.SplitHostPort(remote.String()); err == nil {
+		entry.Client = host
+	}
+
+	if msg != nil {
+		entry.Rcode = dns.RcodeToString[msg.Rcode]
+		for _, rr := range msg.Answer {
+			entry.Answer = append(entry.Answer, answerValue(rr))
+		}
+	}
+	return entry
+}
+
+func (l *queryLogger) run() {
+	for entry := range l.entries {
+		if err := l.sink.write(entry); err != nil {
+			log.Error("write query log error, %v", err)
+		}
+	}
+}
+
+// answerValue return the rdata of the record
+func answerValue(rr dns.RR) string {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A.String()
+	case *dns.AAAA:
+		return v.AAAA.String()
+	case *dns.CNAME:
+		return v.Target
+	case *dns.PTR:
+		return v.Ptr
+	}
+	return rr.String()
+}
+
+// fileSink write json lines to the file, rotated by size
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func newFileSink(path string, maxSize int, maxBackups int) (*fileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("query log file is not configured")
+	}
+
+	if maxSize <= 0 {
+		maxSize = defaultQueryLogMaxSize
+	}
+
+	if maxBackups <= 0 {
+		maxBackups = defaultQueryLogMaxBackups
+	}
+
+	s := &fileSink{
+		path:       path,
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open query log file error, %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat query log file error, %v", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate shift the backups, path -> path.1 -> path.2 ..., the oldest is removed
+func (s *fileSink) rotate() error {
+	s.file.Close()
+
+	for i := s.maxBackups - 1; i > 0; i-- {
+		os.Rename(s.path+"."+strconv.Itoa(i), s.path+"."+strconv.Itoa(i+1))
+	}
+
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		log.Error("rotate query log file error, %v", err)
+	}
+
+	return s.open()
+}
+
+func (s *fileSink) write(entry *queryLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if s.size+int64(len(data)) > s.maxSize {
+		if err = s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// streamSink add the entries to the redis stream
+type streamSink struct {
+	store  internal.Store
+	stream string
+	maxLen int64
+}
+
+func newStreamSink(store internal.Store, stream string, maxLen int64) *streamSink {
+	if stream == "" {
+		stream = internal.GetRedisQueryLogKey()
+	}
+
+	if maxLen <= 0 {
+		maxLen = defaultQueryLogMaxLen
+	}
+
+	return &streamSink{store: store, stream: stream, maxLen: maxLen}
+}
+
+func (s *streamSink) write(entry *queryLogEntry) error {
+	answer, _ := json.Marshal(entry.Answer)
+	return s.store.XAdd(s.stream, s.maxLen, map[string]string{
+		"time":       entry.Time.Format(time.RFC3339Nano),
+		"client":     entry.Client,
+		"qname":      entry.Qname,
+		"qtype":      entry.Qtype,
+		"rcode":      entry.Rcode,
+		"answer":     string(answer),
+		"upstream":   entry.Upstream,
+		"decision":   entry.Decision,
+		"elapsed_ms": strconv.FormatFloat(entry.Elapsed, 'f', 3, 64),
+	})
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package dns
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogSink write json entries to the local syslog
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink() (queryLogSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "kungfu-dns")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) write(entry *queryLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(data))
+}
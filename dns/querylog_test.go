@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSinkRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kungfu-querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "query.log")
+	s, err := newFileSink(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotate every few entries
+	s.maxSize = 512
+
+	entry := &queryLogEntry{Time: time.Now(), Client: "192.168.1.2", Qname: "google.com.", Qtype: "A", Decision: "internal"}
+	for i := 0; i < 20; i++ {
+		if err := s.write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > s.maxSize {
+			t.Fatalf("%s size %d exceeds %d", name, info.Size(), s.maxSize)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("backups more than max backups")
+	}
+}
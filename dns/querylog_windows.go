@@ -0,0 +1,9 @@
+package dns
+
+import (
+	"fmt"
+)
+
+func newSyslogSink() (queryLogSink, error) {
+	return nil, fmt.Errorf("syslog query log sink is not supported on windows")
+}
@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+type raceResult struct {
+	ns   *upstream
+	resp *dns.Msg
+	err  error
+}
+
+// raceUpstream send the request to the fastest n upstreams concurrently,
+// return the first valid response
+func (h *handler) raceUpstream(r *dns.Msg, nameserver []*upstream, n int, info *queryInfo) (*dns.Msg, error) {
+	qname := r.Question[0].Name
+	qtype := dns.Type(r.Question[0].Qtype).String()
+
+	candidates := sortByRTT(nameserver)
+	if n < len(candidates) {
+		candidates = candidates[:n]
+	}
+
+	results := make(chan *raceResult, len(candidates))
+	for _, ns := range candidates {
+		go func(ns *upstream, req *dns.Msg) {
+			resp, err := h.exchange(ns, req, info.span)
+			results <- &raceResult{ns: ns, resp: resp, err: err}
+		}(ns, r.Copy())
+	}
+
+	var last *raceResult
+	for range candidates {
+		result := <-results
+		if result.err != nil {
+			log.Error("race upstream %s on %s qtype: %s error %v", qname, result.ns, qtype, result.err)
+			last = result
+			continue
+		}
+
+		if result.resp.Rcode == dns.RcodeServerFailure {
+			log.Error("race upstream %s on %s qtype: %s fail code %d", qname, result.ns, qtype, result.resp.Rcode)
+			last = result
+			continue
+		}
+
+		log.Debug("race upstream %s on %s qtype: %s, code: %d, srtt: %v",
+			qname, result.ns, qtype, result.resp.Rcode, result.ns.rtt())
+		result.resp.Id = r.Id
+		info.upstream = result.ns.String()
+		return result.resp, nil
+	}
+
+	if last == nil {
+		return nil, fmt.Errorf("no upstream nameserver")
+	}
+
+	return last.resp, last.err
+}
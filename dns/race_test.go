@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startTestUpstream serve the A answer of the ip after the delay, or the rcode if the ip is
+// empty, on the udp address, the random address if empty
+func startTestUpstream(t *testing.T, addr string, delay time.Duration, ip string) (*upstream, func()) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			time.Sleep(delay)
+			msg := new(dns.Msg)
+			if ip == "" {
+				msg.SetRcode(r, dns.RcodeServerFailure)
+			} else {
+				msg.SetReply(r)
+				rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A " + ip)
+				msg.Answer = append(msg.Answer, rr)
+			}
+			w.WriteMsg(msg)
+		})}
+	go srv.ActivateAndServe()
+	<-started
+
+	ns, err := parseUpstream(pc.LocalAddr().String(), time.Millisecond*500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ns, func() { srv.Shutdown() }
+}
+
+// deadUpstreamAddr return the udp address nothing listening
+func deadUpstreamAddr(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+func TestRaceUpstream(t *testing.T) {
+	slow, stop := startTestUpstream(t, "", time.Millisecond*200, "192.0.2.1")
+	defer stop()
+	fast, stop := startTestUpstream(t, "", 0, "192.0.2.2")
+	defer stop()
+	failed, stop := startTestUpstream(t, "", 0, "")
+	defer stop()
+
+	h := &handler{}
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+
+	// the fastest valid answer wins, the SERVFAIL is skipped
+	info := new(queryInfo)
+	resp, err := h.raceUpstream(r, []*upstream{slow, failed, fast}, 3, info)
+	if err != nil || resp.Answer[0].(*dns.A).A.String() != "192.0.2.2" || info.upstream != fast.String() ||
+		resp.Id != r.Id {
+		t.Fatal("the fastest answer should win", resp, err)
+	}
+
+	// the slowest is not raced once the rtt known
+	time.Sleep(time.Millisecond * 250)
+	if sorted := sortByRTT([]*upstream{slow, fast}); sorted[0] != fast {
+		t.Fatal("the upstreams should be sorted by rtt", sorted)
+	}
+	info = new(queryInfo)
+	if _, err = h.raceUpstream(r, []*upstream{slow, fast}, 1, info); err != nil || info.upstream != fast.String() {
+		t.Fatal("the fastest upstream should be raced", info.upstream, err)
+	}
+
+	// all failed, the last failure returned
+	resp, err = h.raceUpstream(r, []*upstream{failed}, 2, new(queryInfo))
+	if err != nil || resp.Rcode != dns.RcodeServerFailure {
+		t.Fatal("the failure should be returned", resp, err)
+	}
+	if _, err = h.raceUpstream(r, nil, 2, new(queryInfo)); err == nil {
+		t.Fatal("no upstream should fail")
+	}
+}
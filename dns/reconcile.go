@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// reconcileInterval is the interval to repair the orphaned mapping keys
+const reconcileInterval = time.Duration(time.Minute * 10)
+
+// runReconcile repair the mapping keys periodically, only the leader run it if
+// multiple instances share the store
+func (server *Server) runReconcile() {
+	elector := internal.NewElector(server.Store, "reconcile")
+	elector.Start()
+	defer elector.Stop()
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !elector.IsLeader() {
+			continue
+		}
+
+		repaired, err := server.reconcile()
+		if err != nil {
+			log.Error("reconcile mappings error, %v", err)
+		}
+
+		if repaired > 0 {
+			log.Info("reconcile mappings, repaired: %d", repaired)
+		}
+	}
+}
+
+// reconcile repair the mapping keys left inconsistent, e.g. written by old version or
+// modified manually: the reverse ip key without domain key is released, the missing
+// reverse ip key is restored, and the domain key of ip owned by other domain is removed
+func (server *Server) reconcile() (int, error) {
+	store := server.Store
+	repaired := 0
+
+	ipKeys, err := store.Keys(internal.GetRedisIpKey("*"))
+	if err != nil {
+		return repaired, err
+	}
+
+	realPrefix := internal.GetRedisRealIpKey("")
+	ipPrefix := internal.GetRedisIpKey("")
+	for _, ipKey := range ipKeys {
+		if strings.HasPrefix(ipKey, realPrefix) {
+			continue
+		}
+
+		ip := net.ParseIP(strings.TrimPrefix(ipKey, ipPrefix))
+		if ip == nil {
+			continue
+		}
+
+		domain, err := store.Get(ipKey)
+		if err == internal.ErrNil {
+			continue
+		}
+
+		if err != nil {
+			return repaired, err
+		}
+
+		qtype := dns.TypeAAAA
+		if ip.To4() != nil {
+			qtype = dns.TypeA
+		}
+
+		mapped, err := store.Get(getDomainKey(qtype, dns.Fqdn(domain)))
+		if err != nil && err != internal.ErrNil {
+			return repaired, err
+		}
+
+		if mapped != ip.String() {
+			log.Debug("reconcile release orphaned ip %s of %s", ip, domain)
+			server.getPool(qtype).release(ip.String())
+			repaired++
+		}
+	}
+
+	domainKeys, err := server.domainKeys()
+	if err != nil {
+		return repaired, err
+	}
+
+	for _, key := range domainKeys {
+		ip, err := store.Get(key)
+		if err == internal.ErrNil {
+			continue
+		}
+
+		if err != nil {
+			return repaired, err
+		}
+
+		domain := domainOfKey(key)
+		ipKey := internal.GetRedisIpKey(ip)
+		owner, err := store.Get(ipKey)
+		if err != nil && err != internal.ErrNil {
+			return repaired, err
+		}
+
+		if owner == domain {
+			continue
+		}
+
+		if err == internal.ErrNil {
+			ttl, _ := store.TTL(key)
+			if ttl <= 0 {
+				ttl = server.handler.getTTL().mappingTTL()
+			}
+
+			log.Debug("reconcile restore reverse mapping %s -> %s", ip, domain)
+			if _, err = store.SetNX(ipKey, domain, ttl); err != nil {
+				return repaired, err
+			}
+		} else {
+			log.Debug("reconcile remove %s, ip %s is owned by %s", key, ip, owner)
+			if err = store.Del(key); err != nil {
+				return repaired, err
+			}
+			server.handler.cache.remove(key)
+			store.Publish(internal.GetRedisCacheChannelKey(), key)
+		}
+		repaired++
+	}
+
+	return repaired, nil
+}
@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestReconcile(t *testing.T) {
+	server := newSnapshotTestServer()
+	store := server.Store
+
+	// consistent
+	if _, err := server.pool.allocate(testDomainKey("google.com"), "google.com", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// reverse mapping without domain key
+	store.Set(internal.GetRedisIpKey("10.85.0.8"), "orphan.com", time.Hour)
+	// domain key without reverse mapping
+	store.Set(testDomainKey("lost.com"), "10.85.0.9", time.Hour)
+	// domain key of ip owned by other domain
+	store.Set(internal.GetRedisIpKey("10.85.0.10"), "owner.com", time.Hour)
+	store.Set(testDomainKey("owner.com"), "10.85.0.10", time.Hour)
+	store.Set(testDomainKey("conflict.com"), "10.85.0.10", time.Hour)
+	// real ip keys are not mappings
+	store.Set(internal.GetRedisRealIpKey("1.1.1.1"), "x", time.Hour)
+
+	repaired, err := server.reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repaired != 3 {
+		t.Fatalf("expect 3 repaired, got %d", repaired)
+	}
+
+	if _, err := store.Get(internal.GetRedisIpKey("10.85.0.8")); err != internal.ErrNil {
+		t.Fatal("orphaned reverse mapping should be released")
+	}
+
+	if domain, _ := store.Get(internal.GetRedisIpKey("10.85.0.9")); domain != "lost.com" {
+		t.Fatalf("reverse mapping should be restored, got %s", domain)
+	}
+
+	if _, err := store.Get(testDomainKey("conflict.com")); err != internal.ErrNil {
+		t.Fatal("conflict domain key should be removed")
+	}
+
+	if repaired, _ = server.reconcile(); repaired != 0 {
+		t.Fatalf("expect nothing to repair, got %d", repaired)
+	}
+}
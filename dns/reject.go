@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// rejectTTL is the ttl of the zero answers of the blocked domains
+const rejectTTL = 300
+
+// rejectList is the blocked domains of the reject list
+type rejectList struct {
+	name    string
+	mode    string
+	matcher *gfwlist.Matcher
+	// schedule is the time windows the list is active, nil for always
+	schedule *internal.Schedule
+}
+
+// loadRejects load the reject lists in kungfu:reject:<name>, in the order of name
+func (server *Server) loadRejects() ([]*rejectList, error) {
+	keys, err := server.Store.Keys(internal.GetRedisRejectKey("*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	prefix := internal.GetRedisRejectKey("")
+	lists := make([]*rejectList, 0, len(keys))
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		if strings.Contains(name, ":") {
+			// the temporary set of the loader
+			continue
+		}
+
+		rules, err := server.Store.SMembers(key)
+		if err != nil {
+			return nil, err
+		}
+
+		matcher, err := gfwlist.NewMatcher(rules)
+		if err != nil {
+			log.Error("compile reject list %s error, %v", name, err)
+			continue
+		}
+
+		mode, err := server.Store.Get(internal.GetRedisRejectModeKey(name))
+		if err != nil && err != internal.ErrNil {
+			return nil, err
+		}
+		switch mode {
+		case "":
+			mode = internal.RejectModeZero
+		case internal.RejectModeZero, internal.RejectModeReset:
+		default:
+			log.Error("unsupported mode %s of reject list %s", mode, name)
+			continue
+		}
+
+		value, err := server.Store.Get(internal.GetRedisRejectScheduleKey(name))
+		if err != nil && err != internal.ErrNil {
+			return nil, err
+		}
+		schedule, err := internal.ParseSchedule(value)
+		if err != nil {
+			log.Error("invalid schedule of reject list %s, %v", name, err)
+			continue
+		}
+
+		lists = append(lists, &rejectList{name: name, mode: mode, matcher: matcher, schedule: schedule})
+	}
+	return lists, nil
+}
+
+// matchReject return the first reject list applied to the client group and active now the domain
+// matched, nil if none matched
+func (server *Server) matchReject(domain string, group *clientGroup) *rejectList {
+	if domain == "." {
+		return nil
+	}
+
+	server.rulesLock.RLock()
+	lists := server.rejects
+	server.rulesLock.RUnlock()
+
+	now := time.Now()
+	for _, list := range lists {
+		if group.rejectApplied(list.name) && list.schedule.Active(now) && list.matcher.Match(domain) {
+			return list
+		}
+	}
+	return nil
+}
+
+// resolveReject answer the blocked domain, 0.0.0.0 and :: of the zero mode, or the fake ip of the
+// reset mode marked for the gateway to reset the connections, empty answer for other types
+func (h *handler) resolveReject(r *dns.Msg, list *rejectList) (*dns.Msg, error) {
+	q := r.Question[0]
+	if !isIPV4TypeAQuery(&q) && !isIPV6TypeAAAAQuery(&q) {
+		return newEmptyReply(r), nil
+	}
+
+	if list.mode == internal.RejectModeReset {
+		msg, err := h.allocateInternal(r)
+		if err == nil {
+			err = h.markRejected(msg, list.name)
+		}
+		if err == nil {
+			log.Debug("reject %s by list %s, answer fake ip", q.Name, list.name)
+			return msg, nil
+		}
+		// the zero answer is better than leaking the blocked domain
+		log.Warning("reject %s by list %s fail, answer zero, %v", q.Name, list.name, err)
+	}
+
+	ip := net.IPv4zero
+	if q.Qtype == dns.TypeAAAA {
+		ip = net.IPv6zero
+	}
+	log.Debug("reject %s by list %s", q.Name, list.name)
+	return newInternalReply(r, ip, time.Duration(rejectTTL)*time.Second), nil
+}
+
+// markRejected mark the fake ips of the answer for the gateway to reset the connections, the
+// mark expire with the mapping
+func (h *handler) markRejected(msg *dns.Msg, name string) error {
+	ttl := h.getTTL().mappingTTL()
+	for _, rr := range msg.Answer {
+		if ip := answerIP(rr); ip != nil {
+			if err := h.server.Store.Set(internal.GetRedisRejectedKey(ip.String()), name, ttl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// answerIP return the ip of the A or AAAA record, nil for other records
+func answerIP(rr dns.RR) net.IP {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A
+	case *dns.AAAA:
+		return v.AAAA
+	}
+	return nil
+}
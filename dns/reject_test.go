@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestRejectList(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	store.SAdd(internal.GetRedisRejectKey("ads"), "ads.example.com", "@@good.ads.example.com")
+	store.SAdd(internal.GetRedisRejectKey("ads:tmp"), "example.com")
+	store.SAdd(internal.GetRedisRejectKey("trackers"), "tracker.example.net")
+	store.Set(internal.GetRedisRejectModeKey("trackers"), internal.RejectModeReset, 0)
+
+	server := &Server{Store: store, toucher: internal.NewToucher(store)}
+	server.pool = newAllocator(store, "current-ip")
+	server.pool.reset(net.ParseIP("10.85.0.2").To4(), 8)
+
+	lists, err := server.loadRejects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.rejects = lists
+	if len(lists) != 2 || lists[1].mode != internal.RejectModeReset {
+		t.Fatal("unexpected reject lists", lists)
+	}
+
+	if server.matchReject("good.ads.example.com.", nil) != nil || server.matchReject("www.example.com.", nil) != nil {
+		t.Fatal("exception and other domains should not be rejected")
+	}
+
+	h := &handler{server: server, cache: newDomainCache(domainCacheSize, domainCacheTTL, time.Minute)}
+	h.ttl = ttlPolicy{mapping: time.Hour}
+
+	// zero mode
+	r := new(dns.Msg)
+	r.SetQuestion("x.ads.example.com.", dns.TypeAAAA)
+	msg, err := h.resolveReject(r, server.matchReject(r.Question[0].Name, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answer) != 1 || !answerIP(msg.Answer[0]).Equal(net.IPv6zero) {
+		t.Fatal("unexpected zero answer", msg)
+	}
+
+	// reset mode
+	r.SetQuestion("tracker.example.net.", dns.TypeA)
+	msg, err = h.resolveReject(r, server.matchReject(r.Question[0].Name, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answer) != 1 {
+		t.Fatal("unexpected fake ip answer", msg)
+	}
+	name, err := store.Get(internal.GetRedisRejectedKey(answerIP(msg.Answer[0]).String()))
+	if err != nil || name != "trackers" {
+		t.Fatal("fake ip should be marked rejected", name, err)
+	}
+
+	// other types
+	r.SetQuestion("tracker.example.net.", dns.TypeMX)
+	if msg, _ = h.resolveReject(r, server.matchReject(r.Question[0].Name, nil)); len(msg.Answer) != 0 {
+		t.Fatal("other types should be answered empty", msg)
+	}
+}
+
+func TestRejectListSchedule(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	store.SAdd(internal.GetRedisRejectKey("streaming"), "video.example.com")
+	store.SAdd(internal.GetRedisRejectKey("broken"), "other.example.com")
+	store.Set(internal.GetRedisRejectScheduleKey("broken"), "someday 09:00-18:00", 0)
+
+	server := &Server{Store: store}
+	lists, err := server.loadRejects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists) != 1 || lists[0].name != "streaming" {
+		t.Fatal("reject list of invalid schedule should be skipped", lists)
+	}
+	server.rejects = lists
+
+	if server.matchReject("video.example.com.", nil) == nil {
+		t.Fatal("reject list without schedule should be always active")
+	}
+
+	// never active now, the window is the other minute of the day
+	now := time.Now().Add(time.Minute)
+	lists[0].schedule, _ = internal.ParseSchedule(now.Format("15:04") + "-" + now.Add(time.Minute).Format("15:04"))
+	if server.matchReject("video.example.com.", nil) != nil {
+		t.Fatal("reject list out of the schedule should not be matched")
+	}
+}
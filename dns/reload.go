@@ -0,0 +1,137 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// Reload apply the config file and the runtime config in store, the upstream nameservers,
+// network, static hosts, client acl, log level and rules, all of them are validated before swap,
+// the running config is kept if any is invalid
+func (server *Server) Reload(config *internal.Config) error {
+	if server.handler == nil {
+		return fmt.Errorf("dns server is not started")
+	}
+
+	value, err := server.Store.Get(internal.GetRedisUpstreamNameserverKey())
+	if err != nil {
+		return fmt.Errorf("get upstream name server error, %v", err)
+	}
+
+	nameserver := parseUpstreams(value, upstreamTimeout)
+	if len(nameserver) == 0 {
+		return fmt.Errorf("no valid upstream nameserver in %s", value)
+	}
+	server.loadForceTCP(nameserver)
+
+	network, err := server.Store.Get(internal.GetRedisNetworkKey())
+	if err != nil {
+		return fmt.Errorf("get network config error, %v", err)
+	}
+
+	n, err := internal.ParseNetwork(network)
+	if err != nil {
+		return fmt.Errorf("invalid network %s, %v", network, err)
+	}
+
+	if network != server.network {
+		if err = internal.CheckNetwork(n); err != nil {
+			return err
+		}
+	}
+
+	network6, err := server.Store.Get(internal.GetRedisNetwork6Key())
+	if err != nil && err != internal.ErrNil {
+		return fmt.Errorf("get ipv6 network config error, %v", err)
+	}
+
+	if network6 != "" {
+		if _, _, _, err = internal.ParseNetwork6(network6); err != nil {
+			return fmt.Errorf("invalid ipv6 network %s, %v", network6, err)
+		}
+	}
+
+	hosts, err := server.loadHosts(config)
+	if err != nil {
+		return fmt.Errorf("load hosts error, %v", err)
+	}
+
+	guard, err := newClientGuard(config)
+	if err != nil {
+		return fmt.Errorf("invalid client acl, %v", err)
+	}
+
+	validator, err := newValidator(config, server.handler.queryDNSSEC)
+	if err != nil {
+		return fmt.Errorf("invalid dnssec config, %v", err)
+	}
+
+	dns64, err := newDNS64(config)
+	if err != nil {
+		return err
+	}
+
+	local, err := newLocalZones(config, upstreamTimeout)
+	if err != nil {
+		return err
+	}
+
+	clients, err := newClientGroups(config)
+	if err != nil {
+		return err
+	}
+
+	// all validated, swap
+	server.Config = config
+	internal.ApplyLog(config)
+
+	h := server.handler
+	h.configLock.Lock()
+	h.nameserver = nameserver
+	h.race = server.loadRace(len(nameserver))
+	h.hosts = hosts
+	h.ttl = server.loadTTLPolicy()
+	h.proxied = server.loadProxiedQuery()
+	h.guard = guard
+	h.dnssec = validator
+	h.dns64 = dns64
+	h.local = local
+	h.clients = clients
+	h.configLock.Unlock()
+
+	server.loadSticky()
+	server.prefetch.setSize(server.loadPrefetch())
+	server.toucher.Load()
+	if network != server.network {
+		server.updateNetwork(network)
+		server.addLocalArpa(network)
+	}
+
+	if network6 != "" && network6 != server.network6 {
+		server.updateNetwork6(network6)
+	}
+
+	server.loadRules()
+
+	log.Info("reload config, upstream nameservers: %s", value)
+	return nil
+}
+
+// addLocalArpa answer the PTR of the relay ip of the network
+func (server *Server) addLocalArpa(network string) {
+	n, err := internal.ParseNetwork(network)
+	if err != nil {
+		return
+	}
+
+	arpa, err := dns.ReverseAddr(n.RelayIp.String())
+	if err != nil {
+		return
+	}
+
+	server.localArpaLock.Lock()
+	server.localArpa[arpa] = true
+	server.localArpaLock.Unlock()
+}
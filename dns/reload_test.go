@@ -0,0 +1,40 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestReload(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{Keys: map[string]string{
+		"network":             "10.85.0.1/16",
+		"upstream-nameserver": "119.29.29.29",
+	}})
+
+	server := &Server{
+		Store:     store,
+		pool:      newAllocator(store, "current-ip"),
+		pool6:     newAllocator(store, "current-ip6"),
+		localArpa: make(map[string]bool),
+	}
+	server.handler = &handler{server: server}
+
+	if err := server.Reload(&internal.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if nameserver, _ := server.handler.upstreams(); len(nameserver) != 1 || server.network != "10.85.0.1/16" {
+		t.Fatalf("unexpected nameserver %v, network %s", nameserver, server.network)
+	}
+
+	store.Set(internal.GetRedisUpstreamNameserverKey(), "223.5.5.5", 0)
+	store.Set(internal.GetRedisNetworkKey(), "invalid", 0)
+	if err := server.Reload(&internal.Config{}); err == nil {
+		t.Fatal("invalid network should fail")
+	}
+
+	if nameserver, _ := server.handler.upstreams(); nameserver[0].addr != "119.29.29.29:53" {
+		t.Fatalf("nameserver should be kept on invalid config, got %v", nameserver)
+	}
+}
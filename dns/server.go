@@ -1,126 +1,837 @@
 package dns
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis"
 	"github.com/miekg/dns"
 	"github.com/yinheli/kungfu"
+	"github.com/yinheli/kungfu/geoip"
+	"github.com/yinheli/kungfu/gfwlist"
 	"github.com/yinheli/kungfu/internal"
+	"github.com/yinheli/kungfu/ipsync"
+	"github.com/yinheli/kungfu/metrics"
+	"github.com/yinheli/kungfu/tracing"
+)
+
+const (
+	// rulesRefreshInterval is the interval to reload the proxy domains and rules
+	rulesRefreshInterval = time.Duration(time.Minute)
+	// upstreamTimeout is the timeout of upstream exchange and the dns server read/write
+	upstreamTimeout = time.Duration(time.Second * 10)
+	// defaultListen is the listen address of the plain dns
+	defaultListen = "0.0.0.0:53"
 )
 
 var (
-	log = kungfu.GetLog()
+	log = kungfu.GetModuleLog("dns")
 )
 
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
 // Server is the dns server
 type Server struct {
-	RedisClient *redis.Client
-
-	minIp         uint32
-	maxIp         uint32
+	Store  internal.Store
+	Config *internal.Config
+
+	pool  *allocator
+	pool6 *allocator
+	// toucher refresh the mapping on access
+	toucher *internal.Toucher
+	// network and network6 is the applied network config
+	network       string
+	network6      string
 	localArpaLock sync.RWMutex
 	localArpa     map[string]bool
+	rulesLock     sync.RWMutex
+	rules         *gfwlist.Matcher
 	handler       *handler
+
+	// geoip and geoipRules proxy the passthrough answers by the country, guarded by rulesLock
+	geoip      *geoip.Updater
+	geoipRules *geoip.Rules
+	// rejects is the reject lists, guarded by rulesLock
+	rejects []*rejectList
+	// stats count the queries for the dashboard
+	stats *queryStats
+	// prefetch refresh the popular domains before expire
+	prefetch *prefetcher
+	// hourly aggregate the queries per hour into the store for the reports
+	hourly *internal.HourlyStats
+	// pusher push the metrics to InfluxDB or VictoriaMetrics, nil if disabled
+	pusher *metrics.Pusher
+	// tracer export the spans of the queries, nil if disabled
+	tracer *tracing.Tracer
+	// events is the query and rule events streamed by the admin api
+	events *internal.EventHub
+	// ipset sync the real ips of the proxied domains instead of the fake ips, guarded by rulesLock
+	ipset *ipsync.Syncer
+
+	// lifecycleLock guard the listening servers
+	lifecycleLock sync.Mutex
+	dnsServers    []*dns.Server
+	httpServers   []*http.Server
+	sub           internal.Subscription
+	// done is closed on shutdown, closed after shutdown finished
+	done   chan struct{}
+	closed chan struct{}
 }
 
 // Start the dns server
 func (server *Server) Start() {
+	server.done = make(chan struct{})
+	server.closed = make(chan struct{})
 
-	network, err := server.RedisClient.Get(internal.GetRedisNetworkKey()).Result()
+	network, err := server.Store.Get(internal.GetRedisNetworkKey())
 	if err != nil {
 		log.Error("get network config error, %v", err)
 		return
 	}
 
-	minIp, maxIp, err := internal.ParseNetwork(network)
-	if err != nil {
+	server.pool = newAllocator(server.Store, "current-ip")
+	server.pool6 = newAllocator(server.Store, "current-ip6")
+	server.loadSticky()
+	server.toucher = internal.NewToucher(server.Store)
+	server.toucher.Load()
+
+	if err = server.updateNetwork(network); err != nil {
 		log.Error("parse network error %v", err)
 		return
 	}
 
-	server.minIp = minIp
-	server.maxIp = maxIp
-
-	log.Info("network config: %s, ip pool min: %s, max: %s, pool size: %d",
-		network,
-		internal.IntToIpv4(minIp+1),
-		internal.IntToIpv4(maxIp-1),
-		maxIp-minIp-1)
-
-	upstreamNameserver, err := server.RedisClient.Get(internal.GetRedisUpstreamNameserverKey()).Result()
+	upstreamNameserver, err := server.Store.Get(internal.GetRedisUpstreamNameserverKey())
 	if err != nil {
 		log.Error("get upstream name server error, %v", err)
 		return
 	}
 
-	var nameserver []string
-	for _, n := range strings.Split(upstreamNameserver, ",") {
-		n = strings.TrimSpace(n)
-		if len(n) > 0 {
-			n = fmt.Sprintf("%s:%d", n, 53)
-			nameserver = append(nameserver, n)
-		}
-	}
+	timeout := upstreamTimeout
+
+	nameserver := parseUpstreams(upstreamNameserver, timeout)
+	server.loadForceTCP(nameserver)
 
 	log.Info("upstream nameservers: %s", upstreamNameserver)
 	log.Debug("parsed upstream nameservers: %v", nameserver)
 
+	ecs, err := server.loadECS()
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+
+	hosts, err := server.loadHosts(server.Config)
+	if err != nil {
+		log.Error("load hosts error, %v", err)
+		return
+	}
+
 	server.initLocalArpa()
+	server.addLocalArpa(network)
+	server.loadNetwork6()
 
-	timeout := time.Duration(time.Second * 10)
+	guard, err := newClientGuard(server.Config)
+	if err != nil {
+		log.Error("init client acl error, %v", err)
+		return
+	}
 
-	client := &dns.Client{
-		Net:     "udp",
-		Timeout: timeout,
+	var querylog *queryLogger
+	if server.Config != nil {
+		querylog, err = newQueryLogger(&server.Config.QueryLog, server.Store)
+		if err != nil {
+			log.Error("init query log error, %v", err)
+			return
+		}
 	}
 
+	server.stats = newQueryStats()
+	var retention time.Duration
+	if server.Config != nil {
+		retention = server.Config.Stats.Retention
+	}
+	server.hourly = internal.NewHourlyStats(retention)
+	server.prefetch = newPrefetcher()
+	server.prefetch.setSize(server.loadPrefetch())
+	server.events = internal.NewEventHub()
+	stale := server.loadDuration(internal.GetRedisStaleTTLKey(), defaultStaleTTL)
 	server.handler = &handler{
 		server:     server,
-		client:     client,
 		nameserver: nameserver,
+		cache:      newDomainCache(domainCacheSize, domainCacheTTL, stale),
+		ecs:        ecs,
+		hosts:      hosts,
+		race:       server.loadRace(len(nameserver)),
+		poison:     server.loadPoisonGuard(timeout),
+		querylog:   querylog,
+		ttl:        server.loadTTLPolicy(),
+		proxied:    server.loadProxiedQuery(),
+		guard:      guard,
+		negative:   newNegativeCache(server.loadDuration(internal.GetRedisNegativeCacheTTLKey(), defaultNegativeMaxTTL)),
+		answers:    newAnswerCache(stale),
+	}
+
+	server.handler.dnssec, err = newValidator(server.Config, server.handler.queryDNSSEC)
+	if err != nil {
+		log.Error("init dnssec validator error, %v", err)
+		return
+	}
+
+	server.handler.dns64, err = newDNS64(server.Config)
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+
+	server.handler.local, err = newLocalZones(server.Config, timeout)
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+
+	server.handler.clients, err = newClientGroups(server.Config)
+	if err != nil {
+		log.Error("%v", err)
+		return
 	}
 
+	server.loadRules()
+	go server.refreshRules()
+
+	go server.handler.checkHealth()
+	go server.runReconcile()
+	go server.runIdleSweep()
+	go server.runPrefetch()
+	go server.runHourlyStats()
+
+	metrics.OnCollect(server.collectPools)
+	if server.Config != nil {
+		admin := server.Config.Admin
+		metrics.ServeProtected(server.Config.Metrics.DNS, admin.Protect, admin.Cert, admin.Key)
+		if server.pusher = server.Config.Metrics.NewPusher("dns"); server.pusher != nil {
+			server.pusher.Start()
+		}
+		if server.tracer = server.Config.Tracing.NewTracer("dns"); server.tracer != nil {
+			server.tracer.Start()
+		}
+	}
+
+	// notify systemd after the plain dns listeners started
+	started := &sync.WaitGroup{}
+	started.Add(2)
+	udpServer := &dns.Server{
+		Net:               "udp4",
+		Addr:              server.listenAddr(),
+		Handler:           server.handler,
+		ReadTimeout:       timeout,
+		WriteTimeout:      timeout,
+		NotifyStartedFunc: started.Done,
+	}
+	tcpServer := &dns.Server{
+		Net:               "tcp4",
+		Addr:              server.listenAddr(),
+		Handler:           server.handler,
+		ReadTimeout:       timeout,
+		WriteTimeout:      timeout,
+		NotifyStartedFunc: started.Done,
+	}
+	server.activate(udpServer, tcpServer)
+
+	go server.serve(udpServer)
+	go server.serve(tcpServer)
 	go func() {
-		udpServer := &dns.Server{
-			Net:          "udp4",
-			Addr:         "0.0.0.0:53",
-			Handler:      server.handler,
-			ReadTimeout:  timeout,
-			WriteTimeout: timeout,
+		started.Wait()
+		internal.SdReady("serving dns on "+server.listenAddr(), server.alive)
+	}()
+
+	tlsServer, err := server.newTLSServer(timeout)
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+
+	if tlsServer != nil {
+		go server.serve(tlsServer)
+	}
+
+	go server.serveHTTPS()
+	go server.serveAdmin()
+
+	server.subscribe()
+
+	// wait the shutdown finished
+	<-server.closed
+}
+
+// Shutdown stop accepting new queries, drain the in flight queries until ctx done,
+// then close the store
+func (server *Server) Shutdown(ctx context.Context) error {
+	if server.done == nil {
+		return nil
+	}
+
+	server.lifecycleLock.Lock()
+	select {
+	case <-server.done:
+		server.lifecycleLock.Unlock()
+		return nil
+	default:
+	}
+	close(server.done)
+	internal.SdNotify("STOPPING=1")
+	dnsServers := server.dnsServers
+	httpServers := server.httpServers
+	server.lifecycleLock.Unlock()
+
+	defer close(server.closed)
+
+	log.Info("shutdown dns server, drain in flight queries")
+	server.pusher.Stop()
+
+	for _, srv := range dnsServers {
+		// it closes the listener and waits the queries of its own, the handler waits all below
+		go srv.Shutdown()
+	}
+
+	var err error
+	for _, srv := range httpServers {
+		if e := srv.Shutdown(ctx); e != nil {
+			err = e
 		}
+	}
 
-		log.Debug("start dns udp server")
-		err = udpServer.ListenAndServe()
-		if err != nil {
-			log.Error("start dns udp server fail, %v", err)
-			os.Exit(1)
+	if server.handler != nil {
+		drained := make(chan struct{})
+		go func() {
+			server.handler.inflight.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+			log.Warning("shutdown timeout, drop the in flight queries")
 		}
-	}()
+	}
 
-	go func() {
-		tcpServer := &dns.Server{
-			Net:          "tcp4",
-			Addr:         "0.0.0.0:53",
-			Handler:      server.handler,
-			ReadTimeout:  timeout,
-			WriteTimeout: timeout,
+	server.lifecycleLock.Lock()
+	if server.sub != nil {
+		server.sub.Close()
+	}
+	server.lifecycleLock.Unlock()
+
+	if e := server.Store.Close(); e != nil {
+		log.Error("close store error, %v", e)
+	}
+	server.tracer.Stop()
+
+	log.Info("dns server shutdown")
+	return err
+}
+
+// shuttingDown return true if shutdown is started
+func (server *Server) shuttingDown() bool {
+	select {
+	case <-server.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// listenAddr return the ipv4 listen address of the plain dns, default 0.0.0.0:53
+func (server *Server) listenAddr() string {
+	if server.Config == nil || server.Config.DNS.Listen == "" {
+		return defaultListen
+	}
+	return server.Config.DNS.Listen
+}
+
+// reusePort return whether listen with SO_REUSEPORT
+func (server *Server) reusePort() bool {
+	return server.Config != nil && server.Config.DNS.ReusePort
+}
+
+// serve start the dns server, exit if fail
+func (server *Server) serve(srv *dns.Server) {
+	log.Debug("start dns %s server on %s", srv.Net, srv.Addr)
+	err := server.listen(srv)
+	if err == nil {
+		server.lifecycleLock.Lock()
+		if !server.shuttingDown() {
+			server.dnsServers = append(server.dnsServers, srv)
 		}
+		server.lifecycleLock.Unlock()
+
+		err = srv.ActivateAndServe()
+	}
 
-		log.Debug("start dns tcp server")
-		err = tcpServer.ListenAndServe()
+	if err != nil && !server.shuttingDown() {
+		log.Error("start dns %s server fail, %v", srv.Net, err)
+		os.Exit(1)
+	}
+}
+
+// activate use the sockets of the systemd socket activation as the plain dns listeners, the
+// first datagram socket for udp and the first stream socket for tcp
+func (server *Server) activate(udpServer *dns.Server, tcpServer *dns.Server) {
+	listeners, conns := internal.ActivationSockets()
+	if len(conns) > 0 {
+		udpServer.PacketConn = conns[0]
+		udpServer.Addr = conns[0].LocalAddr().String()
+	}
+	if len(listeners) > 0 {
+		tcpServer.Listener = listeners[0]
+		tcpServer.Addr = listeners[0].Addr().String()
+	}
+
+	if len(conns) > 1 || len(listeners) > 1 {
+		log.Warning("only one udp and one tcp activation socket are used, the others are closed")
+		for _, c := range conns[1:] {
+			c.Close()
+		}
+		for _, l := range listeners[1:] {
+			l.Close()
+		}
+	}
+}
+
+// alive report whether the server is not hung, for the systemd watchdog
+func (server *Server) alive() bool {
+	server.rulesLock.RLock()
+	server.rulesLock.RUnlock()
+
+	server.lifecycleLock.Lock()
+	server.lifecycleLock.Unlock()
+	return !server.shuttingDown()
+}
+
+// listen create the listener of the dns server, the activation sockets are used as is
+func (server *Server) listen(srv *dns.Server) error {
+	if srv.PacketConn != nil || srv.Listener != nil {
+		return nil
+	}
+
+	switch srv.Net {
+	case "udp", "udp4", "udp6":
+		conn, err := internal.ListenPacket(srv.Net, srv.Addr, server.reusePort())
 		if err != nil {
-			log.Error("start dns tcp server fail, %v", err)
-			os.Exit(1)
+			return err
 		}
-	}()
+		srv.PacketConn = conn
+	case "tcp-tls":
+		l, err := internal.Listen("tcp", srv.Addr, server.reusePort())
+		if err != nil {
+			return err
+		}
+		srv.Listener = tls.NewListener(l, srv.TLSConfig)
+	default:
+		l, err := internal.Listen(srv.Net, srv.Addr, server.reusePort())
+		if err != nil {
+			return err
+		}
+		srv.Listener = l
+	}
+	return nil
+}
 
-	server.subscribe()
+// serveHTTP start the http server, with tls if cert and key configured, exit if fail
+func (server *Server) serveHTTP(name string, srv *http.Server, cert, key string) {
+	l, err := internal.Listen("tcp", srv.Addr, server.reusePort())
+	if err == nil {
+		server.lifecycleLock.Lock()
+		if !server.shuttingDown() {
+			server.httpServers = append(server.httpServers, srv)
+		}
+		server.lifecycleLock.Unlock()
+
+		log.Info("%s server listen on %s", name, srv.Addr)
+		if cert != "" && key != "" {
+			err = srv.ServeTLS(l, cert, key)
+		} else {
+			err = srv.Serve(l)
+		}
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		log.Error("start %s server fail, %v", name, err)
+		os.Exit(1)
+	}
+}
+
+// newTLSServer create the dns over tls server, nil if not configured
+func (server *Server) newTLSServer(timeout time.Duration) (*dns.Server, error) {
+	if server.Config == nil {
+		return nil, nil
+	}
+
+	conf := server.Config.DNS.TLS
+	if conf.Cert == "" || conf.Key == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+	if err != nil {
+		return nil, fmt.Errorf("load dns over tls certificate error, %v", err)
+	}
+
+	addr := conf.Listen
+	if addr == "" {
+		addr = net.JoinHostPort("0.0.0.0", defaultDoTPort)
+	}
+
+	return &dns.Server{
+		Net:          "tcp-tls",
+		Addr:         addr,
+		Handler:      server.handler,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	}, nil
+}
+
+// serveHTTPS start the dns over https server if configured, exit if fail
+func (server *Server) serveHTTPS() {
+	if server.Config == nil || server.Config.DNS.HTTPS.Listen == "" {
+		return
+	}
+
+	conf := server.Config.DNS.HTTPS
+	mux := http.NewServeMux()
+	mux.Handle(dohPath, &dohHandler{handler: server.handler})
+	httpServer := &http.Server{
+		Addr:    conf.Listen,
+		Handler: mux,
+	}
+
+	server.serveHTTP("dns over https", httpServer, conf.Cert, conf.Key)
+}
+
+// loadDuration load duration config in seconds, return def if not configured or invalid
+func (server *Server) loadDuration(key string, def time.Duration) time.Duration {
+	value, err := server.Store.Get(key)
+	if err != nil {
+		if err != internal.ErrNil {
+			log.Error("get config %s error, %v", key, err)
+		}
+		return def
+	}
+
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		log.Error("invalid config %s: %s, use default %v", key, value, def)
+		return def
+	}
+
+	log.Info("config %s: %ds", key, seconds)
+	return time.Duration(seconds) * time.Second
+}
+
+func (server *Server) loadHosts(conf *internal.Config) (*hosts, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	config := &conf.Hosts
+	if config.File == "" && len(config.Records) == 0 {
+		return nil, nil
+	}
+
+	h := newHosts()
+	if config.File != "" {
+		if err := h.loadFile(config.File); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, line := range config.Records {
+		if err := h.addLine(line); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("load static hosts records: %d", h.len())
+	return h, nil
+}
+
+// loadRace load the count of upstreams to race, "all" for all upstreams
+func (server *Server) loadRace(total int) int {
+	value, err := server.Store.Get(internal.GetRedisUpstreamRaceKey())
+	if err != nil {
+		if err != internal.ErrNil {
+			log.Error("get upstream race config error, %v", err)
+		}
+		return 0
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "all" {
+		return total
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Error("invalid upstream race config: %s", value)
+		return 0
+	}
+
+	log.Info("upstream race: %d", n)
+	return n
+}
+
+// loadForceTCP query the plain upstreams over tcp only if configured, for networks interfere udp dns
+func (server *Server) loadForceTCP(nameserver []*upstream) {
+	value, err := server.Store.Get(internal.GetRedisUpstreamTCPKey())
+	if err != nil {
+		if err != internal.ErrNil {
+			log.Error("get upstream tcp config error, %v", err)
+		}
+		return
+	}
+
+	if value != "true" {
+		return
+	}
+
+	for _, ns := range nameserver {
+		ns.forceTCP()
+	}
+	log.Info("query upstream nameservers over tcp only")
+}
+
+// loadSticky derive the fake ip from the hash of domain if configured
+func (server *Server) loadSticky() {
+	value, err := server.Store.Get(internal.GetRedisStickyIpKey())
+	if err != nil && err != internal.ErrNil {
+		log.Error("get sticky ip config error, %v", err)
+		return
+	}
+
+	sticky := value == "true"
+	server.pool.setSticky(sticky)
+	server.pool6.setSticky(sticky)
+	if sticky {
+		log.Info("derive fake ip from the hash of domain")
+	}
+}
+
+// loadPoisonGuard enable the poisoning detection if the trusted upstream is configured
+func (server *Server) loadPoisonGuard(timeout time.Duration) *poisonGuard {
+	value, err := server.Store.Get(internal.GetRedisUpstreamTrustedKey())
+	if err != nil {
+		if err != internal.ErrNil {
+			log.Error("get trusted upstream config error, %v", err)
+		}
+		return nil
+	}
+
+	trusted, err := parseUpstream(strings.TrimSpace(value), timeout)
+	if err != nil {
+		log.Error("%v", err)
+		return nil
+	}
+
+	autoProxy, _ := server.Store.Get(internal.GetRedisPoisonAutoProxyKey())
+
+	log.Info("poisoning detection enabled, trusted upstream: %s, auto proxy: %s", trusted, autoProxy)
+	return &poisonGuard{
+		store:     server.Store,
+		trusted:   trusted,
+		autoProxy: autoProxy == "true",
+	}
+}
+
+func (server *Server) loadECS() (*ecsConfig, error) {
+	value, err := server.Store.Get(internal.GetRedisUpstreamEcsKey())
+	if err == internal.ErrNil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get upstream ecs config error, %v", err)
+	}
+
+	log.Info("upstream ecs: %s", value)
+	return parseECS(value)
+}
+
+func (server *Server) loadNetwork6() {
+	network6, err := server.Store.Get(internal.GetRedisNetwork6Key())
+	if err == internal.ErrNil {
+		log.Info("ipv6 network not configured, AAAA query of proxy domain will answer empty")
+		return
+	}
+
+	if err != nil {
+		log.Error("get ipv6 network config error, %v", err)
+		return
+	}
+
+	server.updateNetwork6(network6)
+}
+
+func (server *Server) updateNetwork6(network6 string) {
+	relayIp6, minIp6, size, err := internal.ParseNetwork6(network6)
+	if err != nil {
+		log.Error("parse ipv6 network error %v", err)
+		return
+	}
+
+	// the relay ip is not always the first ip of the subnet
+	server.pool6.reset(minIp6, size, relayIp6)
+	server.network6 = network6
+
+	log.Info("ipv6 network config: %s, relay ip: %s, ip pool min: %s, max: %s, pool size: %d",
+		network6,
+		relayIp6,
+		minIp6,
+		internal.AddToIp(minIp6, size-1),
+		size)
+
+	arpa, err := dns.ReverseAddr(relayIp6.String())
+	if err != nil {
+		return
+	}
+
+	server.localArpaLock.Lock()
+	server.localArpa[arpa] = true
+	server.localArpaLock.Unlock()
+}
+
+func (server *Server) updateNetwork(network string) error {
+	n, err := internal.ParseNetwork(network)
+	if err != nil {
+		return err
+	}
+
+	if err = internal.CheckNetwork(n); err != nil {
+		return err
+	}
+
+	server.pool.reset(n.First, n.Size, n.RelayIp)
+	server.network = network
+
+	log.Info("network config: %s, relay ip: %s, ip pool min: %s, max: %s, pool size: %d",
+		network,
+		n.RelayIp,
+		n.First,
+		internal.AddToIp(n.First, n.Size-1),
+		n.Size-1)
+
+	return nil
+}
+
+// getPool get the ip pool of the query type
+func (server *Server) getPool(qtype uint16) *allocator {
+	if qtype == dns.TypeAAAA {
+		return server.pool6
+	}
+	return server.pool
+}
+
+// loadRules load the proxy domains and pattern rules (wildcard, keyword, regex) into matcher
+func (server *Server) loadRules() {
+	domains, err := server.Store.SMembers(internal.GetRedisProxyDomainSetKey())
+	if err != nil {
+		log.Error("get proxy domains error, %v", err)
+		return
+	}
+
+	rules, err := server.Store.SMembers(internal.GetRedisProxyRuleSetKey())
+	if err != nil {
+		log.Error("get proxy rules error, %v", err)
+		return
+	}
+
+	matcher, err := gfwlist.NewMatcher(append(domains, rules...))
+	if err != nil {
+		log.Error("compile proxy rules error, %v", err)
+		return
+	}
+
+	server.rulesLock.Lock()
+	server.rules = matcher
+	server.rulesLock.Unlock()
+
+	log.Debug("load proxy domains: %d, pattern rules: %d", len(domains), len(rules))
+
+	if rejects, err := server.loadRejects(); err != nil {
+		log.Error("load reject lists error, %v", err)
+	} else {
+		server.rulesLock.Lock()
+		server.rejects = rejects
+		server.rulesLock.Unlock()
+	}
+
+	// the current geoip rules are kept if invalid
+	if geoipRules, err := server.Store.SMembers(internal.GetRedisGeoIPRuleKey()); err != nil {
+		log.Error("get geoip rules error, %v", err)
+	} else if parsed, err := geoip.ParseRules(geoipRules); err != nil {
+		log.Error("invalid geoip rules, %v", err)
+	} else {
+		server.rulesLock.Lock()
+		server.geoipRules = parsed
+		server.rulesLock.Unlock()
+	}
+
+	if server.handler != nil {
+		server.handler.poison.loadBogus()
+	}
+}
+
+// refreshRules reload the rules periodically, the domains may be added manually without notify
+func (server *Server) refreshRules() {
+	for range time.Tick(rulesRefreshInterval) {
+		server.loadRules()
+	}
+}
+
+func (server *Server) getRules() *gfwlist.Matcher {
+	server.rulesLock.RLock()
+	defer server.rulesLock.RUnlock()
+	return server.rules
+}
+
+// SetGeoIP replace the country database of the geoip rules, nil to disable
+func (server *Server) SetGeoIP(u *geoip.Updater) {
+	server.rulesLock.Lock()
+	defer server.rulesLock.Unlock()
+	server.geoip = u
+}
+
+// SetIPSet answer the real ips of the proxied domains and add them to the set of the syncer,
+// nil to answer the fake ips
+func (server *Server) SetIPSet(syncer *ipsync.Syncer) {
+	server.rulesLock.Lock()
+	defer server.rulesLock.Unlock()
+	server.ipset = syncer
+}
+
+func (server *Server) getIPSet() *ipsync.Syncer {
+	server.rulesLock.RLock()
+	defer server.rulesLock.RUnlock()
+	return server.ipset
+}
+
+// geoipAction return the action of the ip by the geoip rules, empty if no rule matched
+func (server *Server) geoipAction(ip net.IP) string {
+	server.rulesLock.RLock()
+	u, rules := server.geoip, server.geoipRules
+	server.rulesLock.RUnlock()
+	return rules.Match(u.DB(), ip)
 }
 
 func (server *Server) initLocalArpa() {
@@ -160,39 +871,54 @@ func (server *Server) arpaContains(qname *string) bool {
 
 func (server *Server) subscribe() {
 	networkChannelKey := internal.GetRedisNetworkChannelKey()
-	log.Debug("subscribe network-channel, %s", networkChannelKey)
-	sub := server.RedisClient.Subscribe(networkChannelKey)
+	network6ChannelKey := internal.GetRedisNetwork6ChannelKey()
+	proxyDomainChannelKey := internal.GetRedisProxyDomainChannelKey()
+	cacheChannelKey := internal.GetRedisCacheChannelKey()
+	log.Debug("subscribe channels, %s, %s, %s, %s", networkChannelKey, network6ChannelKey, proxyDomainChannelKey, cacheChannelKey)
+	sub := server.Store.Subscribe(networkChannelKey, network6ChannelKey, proxyDomainChannelKey, cacheChannelKey)
+
+	server.lifecycleLock.Lock()
+	server.sub = sub
+	server.lifecycleLock.Unlock()
+
 	for {
 		message, err := sub.ReceiveMessage()
+		if server.shuttingDown() {
+			return
+		}
+
 		if err != nil {
 			log.Error("receive message error %v", err)
 			continue
 		}
 
-		network := message.Payload
+		if message.Channel == network6ChannelKey {
+			log.Info("receive network6 channel message payload: %s", message.Payload)
+			server.updateNetwork6(message.Payload)
+			continue
+		}
 
-		log.Info("receive network channel message payload: %s", network)
+		if message.Channel == proxyDomainChannelKey {
+			log.Info("receive gfwlist channel message payload: %s", message.Payload)
+			server.loadRules()
+			continue
+		}
 
-		minIp, maxIp, err := internal.ParseNetwork(network)
-		if err != nil {
-			log.Error("parse network error %v", err)
+		if message.Channel == cacheChannelKey {
+			log.Debug("receive cache channel message payload: %s", message.Payload)
+			server.invalidateCache(message.Payload)
 			continue
 		}
 
-		server.minIp = minIp
-		server.maxIp = maxIp
+		network := message.Payload
 
-		log.Info("update network config: %s, ip pool min: %s, max: %s, pool size: %d",
-			network,
-			internal.IntToIpv4(minIp+1),
-			internal.IntToIpv4(maxIp-1),
-			maxIp-minIp-1)
+		log.Info("receive network channel message payload: %s", network)
 
-		ip, _, _ := net.ParseCIDR(network)
-		arpa, _ := dns.ReverseAddr(ip.String())
+		if err := server.updateNetwork(network); err != nil {
+			log.Error("parse network error %v", err)
+			continue
+		}
 
-		server.localArpaLock.Lock()
-		server.localArpa[arpa] = true
-		server.localArpaLock.Unlock()
+		server.addLocalArpa(network)
 	}
 }
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/yinheli/kungfu"
 	"github.com/yinheli/kungfu/dns"
+	"github.com/yinheli/kungfu/gateway"
+	"github.com/yinheli/kungfu/geoip"
+	"github.com/yinheli/kungfu/gfwlist"
 	"github.com/yinheli/kungfu/internal"
+	"github.com/yinheli/kungfu/ipsync"
 	"os"
+	"os/signal"
+	"reflect"
 	"runtime"
+	"syscall"
+	"time"
 )
 
 var (
@@ -17,8 +26,13 @@ var (
 	c       = flag.String("c", "config.yml", "config file")
 	d       = flag.Bool("d", false, "debug log level")
 	version = flag.Bool("version", false, "show server version")
+
+	standalone = flag.Bool("standalone", false, "run the gateway in the same process with the memory store, without redis and the iptables rules, the config file is optional")
 )
 
+// shutdownTimeout is the max time to drain the in flight requests on shutdown
+const shutdownTimeout = time.Duration(time.Second * 30)
+
 func main() {
 	ver := getVersion()
 
@@ -29,6 +43,7 @@ func main() {
 		u()
 	}
 
+	flag.Var(&internal.ConfigOverrides, "set", "override the config value, key=value, e.g. redis.addr=127.0.0.1:6379, repeatable, over the KUNGFU_* env vars")
 	flag.Parse()
 
 	if !flag.Parsed() {
@@ -50,16 +65,221 @@ func main() {
 	log.Info("kungfu dns server version: %s", ver)
 	log.Info(kungfu.DECLARATION)
 
-	config := internal.ParseConfig(*c)
-	client := internal.NewRedisClient(&config.Redis)
+	var config *internal.Config
+	var err error
+	if *standalone {
+		config, err = parseStandaloneConfig(*c)
+	} else {
+		config, err = internal.ParseConfig(*c)
+	}
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	internal.ApplyLog(config)
+	if *d {
+		kungfu.SetLogLevelDebug()
+	}
+
+	store := internal.NewStore(config)
+	if err = config.Seed(store); err != nil {
+		log.Error("write the pool, upstream and outbounds of config to store error, %v", err)
+		os.Exit(1)
+	}
+
+	// only the leader refresh the gfwlist if multiple instances share the store
+	elector := internal.NewElector(store, "gfwlist")
+	elector.Start()
+	loader := startLoader(store, elector, config)
+	rejectLoaders := startRejectLoaders(store, elector, config)
+	manager := startSubscriptions(store, elector, config)
 
 	server := &dns.Server{
-		RedisClient: client,
+		Store:  store,
+		Config: config,
 	}
+	updater := startGeoIP(config)
+	server.SetGeoIP(updater)
+	syncer := startIPSet(config)
+	server.SetIPSet(syncer)
+
+	var gw *gateway.Gateway
+	if *standalone {
+		gw = startGateway(store, config)
+	}
+
+	go internal.WatchConfig(*c, func(next *internal.Config) {
+		if *standalone {
+			applyStandalone(next)
+		}
+		if err := next.Seed(store); err != nil {
+			log.Error("write the pool, upstream and outbounds of config to store error, %v", err)
+		}
+
+		if err := server.Reload(next); err != nil {
+			log.Error("reload config error, keep the running config, %v", err)
+			return
+		}
+
+		if next.Gfwlist != config.Gfwlist {
+			if loader != nil {
+				loader.Stop()
+			}
+			loader = startLoader(store, elector, next)
+		}
+
+		if !reflect.DeepEqual(next.Reject, config.Reject) {
+			for _, l := range rejectLoaders {
+				l.Stop()
+			}
+			rejectLoaders = startRejectLoaders(store, elector, next)
+		}
+
+		if !reflect.DeepEqual(next.Subscription, config.Subscription) {
+			if manager != nil {
+				manager.Stop()
+			}
+			manager = startSubscriptions(store, elector, next)
+		}
+
+		if next.GeoIP != config.GeoIP {
+			if updater != nil {
+				updater.Stop()
+			}
+			updater = startGeoIP(next)
+			server.SetGeoIP(updater)
+		}
+
+		if next.DNS.IPSet != config.DNS.IPSet {
+			server.SetIPSet(nil)
+			if syncer != nil {
+				syncer.Stop()
+			}
+			syncer = startIPSet(next)
+			server.SetIPSet(syncer)
+		}
+		config = next
+	})
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		log.Info("receive signal %v, shutdown", <-sig)
+
+		elector.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if gw != nil {
+			stopGateway(ctx, gw)
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warning("shutdown error, %v", err)
+		}
+
+		if syncer != nil {
+			syncer.Stop()
+		}
+	}()
 
 	server.Start()
 }
 
+// startLoader load the gfwlist source and watch for changes, nil if source not configured
+func startLoader(store internal.Store, elector *internal.Elector, config *internal.Config) *gfwlist.Loader {
+	if config.Gfwlist.Source == "" {
+		return nil
+	}
+
+	loader := &gfwlist.Loader{
+		Store:    store,
+		Source:   config.Gfwlist.Source,
+		Interval: config.Gfwlist.Interval,
+		Elector:  elector,
+	}
+	loader.Start()
+	return loader
+}
+
+// startRejectLoaders load the reject list sources and watch for changes
+func startRejectLoaders(store internal.Store, elector *internal.Elector, config *internal.Config) []*gfwlist.Loader {
+	loaders := make([]*gfwlist.Loader, 0, len(config.Reject))
+	for _, list := range config.Reject {
+		loader := &gfwlist.Loader{
+			Store:    store,
+			Source:   list.Source,
+			Interval: list.Interval,
+			Elector:  elector,
+			Reject:   list.Name,
+			Mode:     list.Mode,
+			Schedule: list.Schedule,
+		}
+		loader.Start()
+		loaders = append(loaders, loader)
+	}
+	return loaders
+}
+
+// startSubscriptions fetch the rule subscriptions and update them periodically, nil if no source
+// configured
+func startSubscriptions(store internal.Store, elector *internal.Elector, config *internal.Config) *gfwlist.Manager {
+	if len(config.Subscription.Sources) == 0 {
+		return nil
+	}
+
+	manager := &gfwlist.Manager{
+		Store:   store,
+		Sources: config.Subscription.Sources,
+		Mode:    config.Subscription.Mode,
+		Elector: elector,
+	}
+	manager.Start()
+	return manager
+}
+
+// startIPSet create the sets and sync the real ips of the proxied domains, nil if backend not
+// configured or fail, the fake ips are answered then
+func startIPSet(config *internal.Config) *ipsync.Syncer {
+	if config.DNS.IPSet.Backend == "" {
+		return nil
+	}
+
+	table := config.DNS.IPSet.Table
+	if table == "" {
+		table = "inet kungfu"
+	}
+
+	syncer := &ipsync.Syncer{
+		Backend:    config.DNS.IPSet.Backend,
+		Name:       config.DNS.IPSet.Name,
+		Name6:      config.DNS.IPSet.Name6,
+		Table:      table,
+		Interval:   config.DNS.IPSet.Interval,
+		MinTimeout: config.DNS.IPSet.MinTimeout,
+	}
+	if err := syncer.Start(); err != nil {
+		log.Error("start ipset sync error, answer the fake ips, %v", err)
+		return nil
+	}
+	return syncer
+}
+
+// startGeoIP load the geoip database and watch for changes, nil if database not configured
+func startGeoIP(config *internal.Config) *geoip.Updater {
+	if config.GeoIP.Database == "" {
+		return nil
+	}
+
+	updater := &geoip.Updater{
+		File:     config.GeoIP.Database,
+		URL:      config.GeoIP.URL,
+		Interval: config.GeoIP.Interval,
+	}
+	updater.Start()
+	return updater
+}
+
 func getVersion() string {
 	if build == "" {
 		return fmt.Sprintf("%s", kungfu.Version)
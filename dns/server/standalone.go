@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/yinheli/kungfu/gateway"
+	"github.com/yinheli/kungfu/geoip"
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// standaloneNetwork is the fake ip network of the standalone mode if not configured
+	standaloneNetwork = "198.18.0.1/15"
+	// standaloneNameservers is the upstream nameservers of the standalone mode if not configured
+	standaloneNameservers = "119.29.29.29,223.5.5.5"
+)
+
+// parseStandaloneConfig parse the config file, the default config if the file not exists, the
+// config values can be set by the env vars and the -set flags
+func parseStandaloneConfig(file string) (*internal.Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	config, err := internal.LoadConfig(data, file)
+	if err != nil {
+		return nil, err
+	}
+	applyStandalone(config)
+
+	if config.Memory.Keys["proxy"] == "" && !hasDefaultOutbound(config) {
+		return nil, fmt.Errorf("the proxy is required in standalone mode, e.g. KUNGFU_OUTBOUNDS_0_NAME=%s and KUNGFU_OUTBOUNDS_0_PROXIES=socks5://127.0.0.1:1080",
+			internal.OutboundDefault)
+	}
+	return config, nil
+}
+
+// applyStandalone use the memory store, the tun mode of the gateway without the iptables
+// rules, and the default network and nameservers
+func applyStandalone(config *internal.Config) {
+	if config.Store != "memory" {
+		log.Info("standalone mode, use the memory store instead of %s", config.Store)
+		config.Store = "memory"
+	}
+
+	if config.Gateway.Mode == internal.GatewayModeTProxy {
+		log.Warning("standalone mode, use the tun mode instead of tproxy")
+	}
+	config.Gateway.Mode = internal.GatewayModeTun
+
+	if config.Pool.Network == "" && config.Memory.Keys["network"] == "" {
+		config.Pool.Network = standaloneNetwork
+	}
+
+	if len(config.Upstream.Nameservers) == 0 && config.Memory.Keys["upstream-nameserver"] == "" {
+		config.Set("upstream.nameservers", standaloneNameservers)
+	}
+}
+
+func hasDefaultOutbound(config *internal.Config) bool {
+	for _, outbound := range config.Outbounds {
+		if outbound.Name == internal.OutboundDefault {
+			return true
+		}
+	}
+	return false
+}
+
+// startGateway start the gateway in the process, sharing the memory store with the dns server
+func startGateway(store internal.Store, config *internal.Config) *gateway.Gateway {
+	g := &gateway.Gateway{
+		Store:  store,
+		Config: config,
+	}
+
+	if config.ChnRoutes.Source != "" {
+		g.ChnRoutes = &geoip.RoutesLoader{
+			Source:   config.ChnRoutes.Source,
+			Interval: config.ChnRoutes.Interval,
+		}
+		g.ChnRoutes.Start()
+	}
+
+	if config.GeoIP.Database != "" {
+		// the database is downloaded by the updater of the dns server
+		g.GeoIP = &geoip.Updater{File: config.GeoIP.Database}
+		g.GeoIP.Start()
+	}
+
+	go g.Serve()
+	return g
+}
+
+// stopGateway drain the relay connections of the gateway
+func stopGateway(ctx context.Context, g *gateway.Gateway) {
+	if err := g.Shutdown(ctx); err != nil {
+		log.Warning("shutdown gateway error, %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestParseStandaloneConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kungfu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the default config without the proxy
+	if _, err := parseStandaloneConfig(filepath.Join(dir, "missing.yml")); err == nil ||
+		!strings.Contains(err.Error(), "the proxy is required in standalone mode") {
+		t.Fatal("the default outbound should be required", err)
+	}
+
+	// the default config with the proxy by the env vars
+	os.Setenv("KUNGFU_OUTBOUNDS_0_NAME", internal.OutboundDefault)
+	os.Setenv("KUNGFU_OUTBOUNDS_0_PROXIES", "socks5://127.0.0.1:1080")
+	config, err := parseStandaloneConfig(filepath.Join(dir, "missing.yml"))
+	os.Unsetenv("KUNGFU_OUTBOUNDS_0_NAME")
+	os.Unsetenv("KUNGFU_OUTBOUNDS_0_PROXIES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Store != "memory" || config.Gateway.Mode != internal.GatewayModeTun ||
+		config.Pool.Network != standaloneNetwork ||
+		strings.Join(config.Upstream.Nameservers, ",") != standaloneNameservers {
+		t.Fatal("unexpected standalone defaults", config.Store, config.Gateway.Mode, config.Pool.Network,
+			config.Upstream.Nameservers)
+	}
+
+	// the store and the mode are forced, the network and the nameservers are kept
+	file := filepath.Join(dir, "config.yml")
+	ioutil.WriteFile(file, []byte(`
+store: redis
+gateway:
+  mode: tproxy
+pool:
+  network: 10.85.0.1/16
+upstream:
+  nameservers:
+    - 8.8.8.8
+memory:
+  keys:
+    proxy: socks5://127.0.0.1:1080
+`), 0644)
+	if config, err = parseStandaloneConfig(file); err != nil {
+		t.Fatal(err)
+	}
+	if config.Store != "memory" || config.Gateway.Mode != internal.GatewayModeTun ||
+		config.Pool.Network != "10.85.0.1/16" || strings.Join(config.Upstream.Nameservers, ",") != "8.8.8.8" {
+		t.Fatal("unexpected standalone overrides", config.Store, config.Gateway.Mode, config.Pool.Network,
+			config.Upstream.Nameservers)
+	}
+
+	// the network and the nameservers of the memory keys are not overridden
+	config = &internal.Config{Memory: internal.Memory{Keys: map[string]string{
+		"network": "10.86.0.1/16", "upstream-nameserver": "1.1.1.1"}}}
+	applyStandalone(config)
+	if config.Pool.Network != "" || len(config.Upstream.Nameservers) != 0 {
+		t.Fatal("the memory keys should be kept", config.Pool.Network, config.Upstream.Nameservers)
+	}
+
+	// the invalid config
+	ioutil.WriteFile(file, []byte("store: [\n"), 0644)
+	if _, err = parseStandaloneConfig(file); err == nil {
+		t.Fatal("the invalid config should fail")
+	}
+}
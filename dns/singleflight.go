@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/tracing"
+)
+
+// singleflight suppress duplicate resolve of the same question in flight
+type singleflight struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg   sync.WaitGroup
+	msg  *dns.Msg
+	info queryInfo
+	err  error
+	dups int
+}
+
+// queryInfo is how the query resolved, for the query log
+type queryInfo struct {
+	// upstream is the upstream nameserver answered the query
+	upstream string
+	// cname is the name in the cname chain matched the proxy rules
+	cname string
+	// geoip is the answer ip proxied by the geoip rules
+	geoip string
+	// reject is the reject list matched
+	reject string
+	// span is the trace span of the query, nil if not traced
+	span *tracing.Span
+}
+
+// do execute fn once for concurrent calls with the same key, shared is true if
+// the result is shared with other callers, then the caller must not modify msg,
+// info is filled by fn for the first caller and copied to the others
+func (g *singleflight) do(key string, info *queryInfo, fn func(*queryInfo) (*dns.Msg, error)) (msg *dns.Msg, err error, shared bool) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.lock.Unlock()
+		c.wg.Wait()
+		// the span is of this query
+		span := info.span
+		*info = c.info
+		info.span = span
+		return c.msg, c.err, true
+	}
+
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.lock.Unlock()
+
+	c.msg, c.err = fn(info)
+	c.info = *info
+
+	g.lock.Lock()
+	delete(g.calls, key)
+	dups := c.dups
+	g.lock.Unlock()
+
+	c.wg.Done()
+
+	return c.msg, c.err, dups > 0
+}
@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSingleflight(t *testing.T) {
+	var g singleflight
+	var calls int32
+	release := make(chan struct{})
+
+	fn := func(info *queryInfo) (*dns.Msg, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		info.upstream = "1.1.1.1:53"
+		return new(dns.Msg), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*dns.Msg, 5)
+	infos := make([]*queryInfo, 5)
+	shares := make([]bool, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			infos[i] = new(queryInfo)
+			results[i], _, shares[i] = g.do("example.com.:1:1", infos[i], fn)
+		}(i)
+	}
+
+	// wait for the calls joined
+	for {
+		g.lock.Lock()
+		c := g.calls["example.com.:1:1"]
+		joined := c != nil && c.dups == len(results)-1
+		g.lock.Unlock()
+		if joined {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatal("the concurrent calls should be resolved once", calls)
+	}
+	for i := range results {
+		if results[i] != results[0] || !shares[i] || infos[i].upstream != "1.1.1.1:53" {
+			t.Fatal("the result should be shared", i)
+		}
+	}
+
+	// the keys are separated and the calls not in flight are not shared
+	errFailed := errors.New("failed")
+	for _, key := range []string{"example.com.:1:1", "example.com.:1:1:do"} {
+		msg, err, shared := g.do(key, new(queryInfo), func(*queryInfo) (*dns.Msg, error) {
+			return nil, errFailed
+		})
+		if msg != nil || err != errFailed || shared {
+			t.Fatal("unexpected result of", key, err, shared)
+		}
+	}
+	if len(g.calls) != 0 {
+		t.Fatal("the calls done should be removed")
+	}
+}
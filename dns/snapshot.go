@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	snapshotJSON = "json"
+	snapshotCSV  = "csv"
+)
+
+var snapshotHeader = []string{"domain", "ip", "ttl"}
+
+// listMappings return the domain -> fake ip mappings sorted by domain
+func (server *Server) listMappings() ([]mapping, error) {
+	store := server.Store
+	keys, err := server.domainKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make([]mapping, 0, len(keys))
+	for _, key := range keys {
+		ip, err := store.Get(key)
+		if err != nil {
+			continue
+		}
+
+		ttl, _ := store.TTL(key)
+		mappings = append(mappings, mapping{Domain: domainOfKey(key), IP: ip, TTL: int64(ttl / time.Second)})
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Domain < mappings[j].Domain
+	})
+	return mappings, nil
+}
+
+// restoreMapping restore the mapping from snapshot, the mapping is skipped
+// if the domain or the ip is already mapped to others
+func (server *Server) restoreMapping(m mapping) (bool, error) {
+	domain := strings.TrimSuffix(strings.ToLower(m.Domain), ".")
+	if _, ok := dns.IsDomainName(domain); !ok || domain == "" {
+		return false, fmt.Errorf("invalid domain %q", m.Domain)
+	}
+
+	ip := net.ParseIP(m.IP)
+	if ip == nil {
+		return false, fmt.Errorf("invalid ip %q", m.IP)
+	}
+
+	qtype := dns.TypeAAAA
+	if ip.To4() != nil {
+		qtype = dns.TypeA
+		ip = ip.To4()
+	}
+
+	ttl := time.Duration(m.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = server.handler.getTTL().mappingTTL()
+	}
+
+	key := getDomainKey(qtype, dns.Fqdn(domain))
+	ok, err := server.getPool(qtype).adopt(ip, key, domain, ttl)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	server.handler.cache.remove(key)
+	return true, nil
+}
+
+// encodeMappings write the mappings as json or csv
+func encodeMappings(w io.Writer, format string, mappings []mapping) error {
+	switch format {
+	case "", snapshotJSON:
+		return json.NewEncoder(w).Encode(mappings)
+	case snapshotCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(snapshotHeader)
+		for _, m := range mappings {
+			cw.Write([]string{m.Domain, m.IP, strconv.FormatInt(m.TTL, 10)})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unsupported format %s", format)
+}
+
+// decodeMappings read the mappings from json or csv, the csv header line is optional
+func decodeMappings(r io.Reader, format string) ([]mapping, error) {
+	switch format {
+	case "", snapshotJSON:
+		var mappings []mapping
+		err := json.NewDecoder(r).Decode(&mappings)
+		return mappings, err
+	case snapshotCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		mappings := make([]mapping, 0, len(records))
+		for i, record := range records {
+			if i == 0 && len(record) > 0 && record[0] == snapshotHeader[0] {
+				continue
+			}
+
+			if len(record) < 2 {
+				return nil, fmt.Errorf("line %d: expect domain,ip[,ttl]", i+1)
+			}
+
+			m := mapping{Domain: record[0], IP: record[1]}
+			if len(record) > 2 && record[2] != "" {
+				if m.TTL, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+					return nil, fmt.Errorf("line %d: invalid ttl %s", i+1, record[2])
+				}
+			}
+			mappings = append(mappings, m)
+		}
+		return mappings, nil
+	}
+	return nil, fmt.Errorf("unsupported format %s", format)
+}
+
+// snapshotFormat return the format by query parameter or content type
+func snapshotFormat(format string, contentType string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+
+	if strings.Contains(contentType, "csv") {
+		return snapshotCSV
+	}
+	return snapshotJSON
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+func newSnapshotTestServer() *Server {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	server := &Server{
+		Store: store,
+		pool:  newAllocator(store, "current-ip"),
+		pool6: newAllocator(store, "current-ip6"),
+	}
+	server.pool.reset(net.ParseIP("10.85.0.2").To4(), 10, net.ParseIP("10.85.0.1").To4())
+	server.handler = &handler{
+		server:   server,
+		cache:    newDomainCache(domainCacheSize, domainCacheTTL, 0),
+		negative: newNegativeCache(0),
+	}
+	return server
+}
+
+func TestMappingsCodec(t *testing.T) {
+	mappings := []mapping{
+		{Domain: "google.com", IP: "10.85.0.2", TTL: 3600},
+		{Domain: "twitter.com", IP: "10.85.0.3", TTL: 60},
+	}
+
+	for _, format := range []string{snapshotJSON, snapshotCSV} {
+		var buf bytes.Buffer
+		if err := encodeMappings(&buf, format, mappings); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := decodeMappings(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		if len(decoded) != len(mappings) || decoded[1] != mappings[1] {
+			t.Fatalf("%s: unexpected mappings %v", format, decoded)
+		}
+	}
+
+	if _, err := decodeMappings(strings.NewReader("google.com\n"), snapshotCSV); err == nil {
+		t.Fatal("csv line without ip should be rejected")
+	}
+}
+
+func TestRestoreMapping(t *testing.T) {
+	server := newSnapshotTestServer()
+
+	tests := []struct {
+		m  mapping
+		ok bool
+	}{
+		{mapping{Domain: "google.com", IP: "10.85.0.5", TTL: 3600}, true},
+		// restore again is fine
+		{mapping{Domain: "google.com", IP: "10.85.0.5"}, true},
+		// ip is used by other domain
+		{mapping{Domain: "twitter.com", IP: "10.85.0.5"}, false},
+		// domain is mapped to other ip
+		{mapping{Domain: "google.com", IP: "10.85.0.6"}, false},
+		// out of the pool
+		{mapping{Domain: "facebook.com", IP: "10.86.0.5"}, false},
+		// reserved relay ip
+		{mapping{Domain: "facebook.com", IP: "10.85.0.1"}, false},
+		{mapping{Domain: "facebook.com", IP: "bad"}, false},
+	}
+
+	for _, test := range tests {
+		ok, _ := server.restoreMapping(test.m)
+		if ok != test.ok {
+			t.Fatalf("restore %v expect %v, got %v", test.m, test.ok, ok)
+		}
+	}
+
+	ip, err := server.Store.Get(getDomainKey(dns.TypeA, "google.com."))
+	if err != nil || ip != "10.85.0.5" {
+		t.Fatalf("unexpected domain mapping %s, %v", ip, err)
+	}
+
+	// the released ip of the conflict domain is reusable
+	if _, err := server.Store.Get(internal.GetRedisIpKey("10.85.0.6")); err != internal.ErrNil {
+		t.Fatal("conflict ip should be released")
+	}
+
+	// the restored ip is never allocated to others
+	for i := 0; i < 8; i++ {
+		domain := fmt.Sprintf("other%d.com", i)
+		ip, err := server.pool.allocate(testDomainKey(domain), domain, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ip.String() == "10.85.0.5" {
+			t.Fatal("restored ip allocated again")
+		}
+	}
+}
+
+func TestAdminImportMappings(t *testing.T) {
+	server := newSnapshotTestServer()
+	admin := newAdminHandler(server)
+
+	body := "domain,ip,ttl\ngoogle.com,10.85.0.3,3600\ntwitter.com,10.86.0.3,3600\n"
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/mappings?format=csv", strings.NewReader(body)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"imported":1`) {
+		t.Fatalf("import status %d, %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/mappings?format=csv", nil))
+	if !strings.Contains(w.Body.String(), "google.com,10.85.0.3,") {
+		t.Fatalf("unexpected export %s", w.Body.String())
+	}
+}
@@ -0,0 +1,125 @@
+package dns
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// qpsWindow is the seconds the qps averaged over
+	qpsWindow = 10
+	// topDomainsSize is the max domains counted, the counts are halved if exceeded
+	topDomainsSize = 10000
+)
+
+// domainCount is the queries of the domain
+type domainCount struct {
+	Domain string `json:"domain"`
+	Count  uint64 `json:"count"`
+}
+
+// queryStats count the queries for the dashboard, the qps, the outcomes and the top domains
+type queryStats struct {
+	lock  sync.Mutex
+	total uint64
+	// buckets is the queries of the last seconds, indexed by the unix second
+	buckets  [qpsWindow + 1]uint64
+	seconds  [qpsWindow + 1]int64
+	outcomes map[string]uint64
+	domains  map[string]uint64
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{
+		outcomes: make(map[string]uint64),
+		domains:  make(map[string]uint64),
+	}
+}
+
+// record the query of the domain, nil stats is no-op
+func (s *queryStats) record(qname string, outcome string, now time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.total++
+	s.outcomes[outcome]++
+
+	sec := now.Unix()
+	i := sec % int64(len(s.buckets))
+	if s.seconds[i] != sec {
+		s.seconds[i] = sec
+		s.buckets[i] = 0
+	}
+	s.buckets[i]++
+
+	domain := strings.TrimSuffix(strings.ToLower(qname), ".")
+	if domain == "" {
+		return
+	}
+	if _, ok := s.domains[domain]; !ok && len(s.domains) >= topDomainsSize {
+		// decay the counts, the domains queried once are dropped
+		for d, n := range s.domains {
+			if n /= 2; n == 0 {
+				delete(s.domains, d)
+			} else {
+				s.domains[d] = n
+			}
+		}
+	}
+	s.domains[domain]++
+}
+
+// qps return the queries per second of the last complete seconds
+func (s *queryStats) qps(now time.Time) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sec := now.Unix()
+	var n uint64
+	for i, t := range s.seconds {
+		if t < sec && t >= sec-qpsWindow {
+			n += s.buckets[i]
+		}
+	}
+	return float64(n) / qpsWindow
+}
+
+// top return the most queried domains
+func (s *queryStats) top(n int) []domainCount {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := make([]domainCount, 0, len(s.domains))
+	for d, c := range s.domains {
+		result = append(result, domainCount{Domain: d, Count: c})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Domain < result[j].Domain
+	})
+
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// snapshot return the total queries and the queries by outcome
+func (s *queryStats) snapshot() (uint64, map[string]uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	outcomes := make(map[string]uint64, len(s.outcomes))
+	for k, v := range s.outcomes {
+		outcomes[k] = v
+	}
+	return s.total, outcomes
+}
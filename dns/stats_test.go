@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/gfwlist"
+)
+
+func TestQueryStats(t *testing.T) {
+	s := newQueryStats()
+	now := time.Unix(1000, 0)
+	for i := 0; i < 20; i++ {
+		s.record("www.google.com.", "internal", now.Add(-time.Second))
+	}
+	s.record("Example.com.", "upstream", now.Add(-time.Second))
+	// the current second is not complete
+	s.record("example.com.", "upstream", now)
+
+	if qps := s.qps(now); qps != 2.1 {
+		t.Fatal("unexpected qps", qps)
+	}
+
+	top := s.top(1)
+	if len(top) != 1 || top[0].Domain != "www.google.com" || top[0].Count != 20 {
+		t.Fatal("unexpected top domains", top)
+	}
+
+	total, outcomes := s.snapshot()
+	if total != 22 || outcomes["upstream"] != 2 {
+		t.Fatal("unexpected outcomes", total, outcomes)
+	}
+
+	if qps := s.qps(now.Add(time.Minute)); qps != 0 {
+		t.Fatal("expired seconds should not count", qps)
+	}
+}
+
+func TestAdminDashboard(t *testing.T) {
+	server := newSnapshotTestServer()
+	server.stats = newQueryStats()
+	server.rules, _ = gfwlist.NewMatcher([]string{"google.com"})
+	admin := newAdminHandler(server)
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := do("/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "kungfu dashboard") {
+		t.Fatalf("dashboard status %d", w.Code)
+	}
+
+	var stats struct {
+		Rules int
+		Pools map[string]poolStats
+	}
+	if err := json.Unmarshal(do("/api/stats").Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rules != 1 {
+		t.Fatal("unexpected stats", stats)
+	}
+
+	var result map[string]string
+	if err := json.Unmarshal(do("/api/rules/test?domain=www.google.com").Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["result"] != "proxy" {
+		t.Fatal("unexpected rule test result", result)
+	}
+
+	if w := do("/api/gateway/api/conns"); w.Code != http.StatusNotFound {
+		t.Fatalf("gateway admin not configured status %d", w.Code)
+	}
+}
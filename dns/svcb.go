@@ -0,0 +1,44 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// the service binding types (RFC 9460), not defined in the vendored dns package
+const (
+	typeSVCB  uint16 = 64
+	typeHTTPS uint16 = 65
+)
+
+func isServiceBindingQuery(q *dns.Question) bool {
+	return q.Qclass == dns.ClassINET && (q.Qtype == typeSVCB || q.Qtype == typeHTTPS)
+}
+
+// resolveServiceBinding answer empty (NODATA) for the proxied domains, the ip hints and the
+// alias target in the upstream answer would let clients bypass the fake ip, clients fallback to
+// the A/AAAA queries
+func (h *handler) resolveServiceBinding(r *dns.Msg, info *queryInfo) (*dns.Msg, error) {
+	qname := r.Question[0].Name
+	if h.isDomainInGfwlist(qname) {
+		log.Debug("resolve %s qtype: %d, suppressed for proxied domain", qname, r.Question[0].Qtype)
+		return newEmptyReply(r), nil
+	}
+
+	resp, err := h.resolveUpstream(r, info)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	if info.cname = h.matchCNAME(resp); info.cname != "" {
+		log.Debug("resolve %s qtype: %d, suppressed for cname %s", qname, r.Question[0].Qtype, info.cname)
+		return newEmptyReply(r), nil
+	}
+	return resp, nil
+}
+
+// newEmptyReply return success reply without answer
+func newEmptyReply(r *dns.Msg) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	return msg
+}
@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	tlsPoolMaxIdle  = 8
+	tlsPoolIdleLife = time.Duration(time.Second * 30)
+)
+
+// tlsPool keep idle dns over tls connections for reuse, handshake is expensive
+type tlsPool struct {
+	addr    string
+	config  *tls.Config
+	timeout time.Duration
+
+	lock sync.Mutex
+	idle []*tlsConn
+}
+
+type tlsConn struct {
+	*dns.Conn
+	touch time.Time
+}
+
+func newTLSPool(addr string, serverName string, pins [][]byte, timeout time.Duration) *tlsPool {
+	config := &tls.Config{
+		ServerName:         serverName,
+		ClientSessionCache: tls.NewLRUClientSessionCache(tlsPoolMaxIdle),
+	}
+
+	if len(pins) > 0 {
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPins(rawCerts, pins)
+		}
+	}
+
+	return &tlsPool{
+		addr:    addr,
+		config:  config,
+		timeout: timeout,
+	}
+}
+
+func (p *tlsPool) get() (*tlsConn, error) {
+	p.lock.Lock()
+	for len(p.idle) > 0 {
+		n := len(p.idle) - 1
+		c := p.idle[n]
+		p.idle = p.idle[:n]
+
+		if time.Since(c.touch) < tlsPoolIdleLife {
+			p.lock.Unlock()
+			return c, nil
+		}
+		c.Close()
+	}
+	p.lock.Unlock()
+
+	dialer := &net.Dialer{Timeout: p.timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", p.addr, p.config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsConn{Conn: &dns.Conn{Conn: conn}}, nil
+}
+
+func (p *tlsPool) put(c *tlsConn) {
+	c.touch = time.Now()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.idle) >= tlsPoolMaxIdle {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *tlsPool) exchange(r *dns.Msg) (*dns.Msg, time.Duration, error) {
+	var err error
+	// the idle connection may be closed by server, retry once with new connection
+	for i := 0; i < 2; i++ {
+		var c *tlsConn
+		c, err = p.get()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		start := time.Now()
+		c.SetDeadline(start.Add(p.timeout))
+
+		if err = c.WriteMsg(r); err == nil {
+			var resp *dns.Msg
+			resp, err = c.ReadMsg()
+			if err == nil && resp.Id != r.Id {
+				err = dns.ErrId
+			}
+
+			if err == nil {
+				p.put(c)
+				return resp, time.Since(start), nil
+			}
+		}
+
+		c.Close()
+	}
+
+	return nil, 0, err
+}
+
+// parsePins decode base64 encoded sha256 of the certificate SubjectPublicKeyInfo
+func parsePins(values []string) ([][]byte, error) {
+	var pins [][]byte
+	for _, v := range values {
+		pin, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid spki pin %s", v)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+func verifyPins(rawCerts [][]byte, pins [][]byte) error {
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(sum[:], pin) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("spki pin verify fail, none of the certificates match")
+}
@@ -0,0 +1,266 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// traceStep is a decision of the query, stage is hosts, client, local, reject, rule, cache,
+// upstream, cname, geoip, ip or outbound
+type traceStep struct {
+	Stage  string `json:"stage"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// queryTrace is the decision path of the query, the decision is the outcome the query would get,
+// hosts, local, reject, internal (the fake ip or the proxied query) or upstream
+type queryTrace struct {
+	Domain   string      `json:"domain"`
+	Type     string      `json:"type"`
+	Decision string      `json:"decision"`
+	Steps    []traceStep `json:"steps"`
+}
+
+func (t *queryTrace) add(stage string, result string, format string, args ...interface{}) {
+	t.Steps = append(t.Steps, traceStep{Stage: stage, Result: result, Detail: fmt.Sprintf(format, args...)})
+}
+
+// trace simulate the query and return the decision path, the fake ip is not allocated and the
+// query is not counted, only the direct domains are resolved by the upstream for the cname and
+// geoip rules
+func (a *adminHandler) trace(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	if domain == "" || domain == "." {
+		writeError(w, http.StatusBadRequest, "empty domain")
+		return
+	}
+
+	qtype := dns.TypeA
+	if t := strings.ToUpper(r.URL.Query().Get("type")); t != "" {
+		var ok bool
+		if qtype, ok = dns.StringToType[t]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid type "+t)
+			return
+		}
+	}
+
+	var client net.IP
+	if c := r.URL.Query().Get("client"); c != "" {
+		if client = net.ParseIP(c); client == nil {
+			writeError(w, http.StatusBadRequest, "invalid client "+c)
+			return
+		}
+	}
+
+	if a.server.handler == nil {
+		writeError(w, http.StatusServiceUnavailable, "server not started")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.handler.trace(dns.Fqdn(domain), qtype, client))
+}
+
+// trace follow the decisions of ServeDNS for the question of the client
+func (h *handler) trace(qname string, qtype uint16, client net.IP) *queryTrace {
+	r := new(dns.Msg)
+	r.SetQuestion(qname, qtype)
+	t := &queryTrace{Domain: strings.TrimSuffix(qname, "."), Type: dns.Type(qtype).String()}
+
+	if msg := h.getHosts().resolve(r); msg != nil {
+		t.Decision = "hosts"
+		t.add("hosts", "matched", "%s", answerString(msg))
+		return t
+	}
+	t.add("hosts", "miss", "")
+
+	group := h.getClients().lookup(client)
+	if group != nil {
+		t.add("client", group.name, "direct %v", group.direct)
+	}
+
+	if l := h.getLocal(); l.match(qname) {
+		t.Decision = "local"
+		if l.nameserver == nil {
+			t.add("local", "matched", "answered NXDOMAIN")
+		} else {
+			t.add("local", "matched", "forwarded to %s", l.nameserver)
+		}
+		return t
+	}
+
+	if list := h.server.matchReject(qname, group); list != nil {
+		rule, _ := list.matcher.Explain(qname)
+		t.Decision = "reject"
+		t.add("reject", "matched", "list %s, rule %s, mode %s", list.name, rule, list.mode)
+		return t
+	}
+	t.add("reject", "miss", "")
+
+	if group.isDirect() {
+		t.Decision = "upstream"
+		t.add("rule", "direct", "client group %s is direct", group.name)
+		h.traceUpstream(t, r)
+		return t
+	}
+
+	rule, proxied := h.server.getRules().Explain(qname)
+	switch {
+	case proxied:
+		t.add("rule", "proxy", "rule %s", rule)
+	case rule != "":
+		t.add("rule", "direct", "excepted by rule %s", rule)
+	default:
+		t.add("rule", "direct", "no rule matched")
+	}
+
+	internalQuery := isIPV4TypeAQuery(&r.Question[0]) || isIPV6TypeAAAAQuery(&r.Question[0])
+	if !internalQuery {
+		if proxied {
+			t.Decision = "internal"
+			policy := proxiedQueryUpstream
+			if p := h.getProxiedQuery(); p != nil {
+				policy = p.policy
+			}
+			t.add("upstream", policy, "%s query of the proxied domain", t.Type)
+			h.traceOutbound(t, qname)
+		} else {
+			t.Decision = "upstream"
+			h.traceUpstream(t, r)
+		}
+		return t
+	}
+
+	if !proxied {
+		t.Decision = "upstream"
+		resp := h.traceUpstream(t, r)
+		if resp == nil {
+			return t
+		}
+
+		if cname := h.matchCNAME(resp); cname != "" {
+			t.add("cname", "proxy", "cname %s matched the rules", strings.TrimSuffix(cname, "."))
+		} else if ip := h.matchGeoIP(resp); ip != "" {
+			t.add("geoip", "proxy", "answer %s matched the geoip rules", ip)
+		} else {
+			return t
+		}
+	}
+
+	t.Decision = "internal"
+	if h.server.getIPSet() != nil {
+		t.add("ip", "ipset", "the real ips are answered and synced to the ipset")
+	} else {
+		h.traceMapping(t, qname, qtype)
+	}
+	h.traceOutbound(t, qname)
+	return t
+}
+
+// traceUpstream resolve the query by the upstream with the caches, nil if failed
+func (h *handler) traceUpstream(t *queryTrace, r *dns.Msg) *dns.Msg {
+	info := new(queryInfo)
+	resp, err := h.resolveUpstream(r, info)
+	if err != nil || resp == nil {
+		t.add("upstream", "fail", "%v", err)
+		return nil
+	}
+
+	result := "upstream"
+	switch info.upstream {
+	case "cache", "negative-cache", "stale-cache":
+		result = info.upstream
+	}
+	t.add("upstream", result, "%s, rcode %s, %s", info.upstream, dns.RcodeToString[resp.Rcode], answerString(resp))
+	return resp
+}
+
+// traceMapping report the fake ip mapped to the domain, in the memory cache or the store
+func (h *handler) traceMapping(t *queryTrace, qname string, qtype uint16) {
+	key := getDomainKey(qtype, qname)
+	if ip, ttl := h.cache.get(key); ip != nil {
+		t.add("cache", "memory", "")
+		t.add("ip", ip.String(), "ttl %v", ttl)
+		return
+	}
+
+	ttl, err := h.server.Store.TTL(key)
+	if err != nil {
+		t.add("cache", "fail", "%v", err)
+		return
+	}
+	if ttl > 1 {
+		if ip, err := h.server.Store.Get(key); err == nil {
+			t.add("cache", "store", "")
+			t.add("ip", ip, "ttl %v", ttl)
+			return
+		}
+	}
+
+	t.add("cache", "miss", "")
+	pool := h.server.getPool(qtype)
+	if pool == nil || !pool.configured() {
+		t.add("ip", "none", "the pool is not configured, the upstream answer is returned")
+		return
+	}
+	t.add("ip", "allocate", "a fake ip is allocated from the pool on the query")
+}
+
+// traceOutbound report the outbound the gateway relay the domain by, the first outbound in the
+// order of name whose rules matched, or the default
+func (h *handler) traceOutbound(t *queryTrace, qname string) {
+	store := h.server.Store
+	keys, err := store.Keys(internal.GetRedisOutboundKey("*"))
+	if err != nil {
+		t.add("outbound", "fail", "%v", err)
+		return
+	}
+	sort.Strings(keys)
+
+	prefix := internal.GetRedisOutboundKey("")
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		rules, err := store.SMembers(internal.GetRedisOutboundRuleKey(name))
+		if err != nil {
+			t.add("outbound", "fail", "get outbound %s rules error, %v", name, err)
+			return
+		}
+
+		matcher, err := gfwlist.NewMatcher(rules)
+		if err != nil {
+			continue
+		}
+		if rule, ok := matcher.Explain(qname); ok {
+			t.add("outbound", name, "rule %s", rule)
+			return
+		}
+	}
+
+	if _, err := store.Get(internal.GetRedisProxyKey()); err != nil {
+		t.add("outbound", internal.OutboundDefault, "%s is not configured", internal.GetRedisProxyKey())
+		return
+	}
+	t.add("outbound", internal.OutboundDefault, "")
+}
+
+// answerString return the answer records in short
+func answerString(msg *dns.Msg) string {
+	answers := make([]string, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		answers = append(answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	if len(answers) == 0 {
+		return "no answer"
+	}
+	return strings.Join(answers, ", ")
+}
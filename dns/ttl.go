@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// ttlPolicy is the ttl of the fake ip answers, the mappings and the upstream answers
+type ttlPolicy struct {
+	// answer is the max ttl of the fake ip answers returned to clients, 0 for the remaining
+	// ttl of the mapping
+	answer time.Duration
+	// mapping is the ttl of the domain <-> fake ip mapping in store
+	mapping time.Duration
+	// upstreamMin and upstreamMax clamp the ttl of upstream answers, 0 to keep as is
+	upstreamMin time.Duration
+	upstreamMax time.Duration
+}
+
+// loadTTLPolicy load the ttl policy from store
+func (server *Server) loadTTLPolicy() ttlPolicy {
+	return ttlPolicy{
+		answer:      server.loadDuration(internal.GetRedisAnswerTTLKey(), 0),
+		mapping:     server.loadDuration(internal.GetRedisMappingTTLKey(), DEFAULT_TTL),
+		upstreamMin: server.loadDuration(internal.GetRedisUpstreamMinTTLKey(), 0),
+		upstreamMax: server.loadDuration(internal.GetRedisUpstreamMaxTTLKey(), 0),
+	}
+}
+
+// mappingTTL return the ttl of new mapping
+func (p ttlPolicy) mappingTTL() time.Duration {
+	if p.mapping <= 0 {
+		return DEFAULT_TTL
+	}
+	return p.mapping
+}
+
+// answerTTL return the ttl of the fake ip answer by the remaining ttl of the mapping,
+// the answer never outlive the mapping
+func (p ttlPolicy) answerTTL(remain time.Duration) time.Duration {
+	if p.answer > 0 && p.answer < remain {
+		return p.answer
+	}
+	return remain
+}
+
+// clamp the ttl of the upstream answer and authority records in place
+func (p ttlPolicy) clamp(msg *dns.Msg) {
+	if p.upstreamMin <= 0 && p.upstreamMax <= 0 {
+		return
+	}
+
+	min := uint32(p.upstreamMin / time.Second)
+	max := uint32(p.upstreamMax / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns} {
+		for _, rr := range section {
+			h := rr.Header()
+			if min > 0 && h.Ttl < min {
+				h.Ttl = min
+			}
+
+			if max > 0 && h.Ttl > max {
+				h.Ttl = max
+			}
+		}
+	}
+}
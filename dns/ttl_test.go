@@ -0,0 +1,42 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTTLPolicy(t *testing.T) {
+	var p ttlPolicy
+	if p.mappingTTL() != DEFAULT_TTL {
+		t.Fatal("default mapping ttl should be DEFAULT_TTL")
+	}
+
+	if ttl := p.answerTTL(time.Hour); ttl != time.Hour {
+		t.Fatalf("default answer ttl should be the remaining, got %v", ttl)
+	}
+
+	p = ttlPolicy{answer: time.Second * 30, upstreamMin: time.Second * 60, upstreamMax: time.Hour}
+	if ttl := p.answerTTL(time.Hour); ttl != time.Second*30 {
+		t.Fatalf("answer ttl should be 30s, got %v", ttl)
+	}
+
+	if ttl := p.answerTTL(time.Second * 10); ttl != time.Second*10 {
+		t.Fatalf("answer ttl should not outlive the mapping, got %v", ttl)
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{
+		newARecord("a.com.", []byte{1, 1, 1, 1}, 5),
+		newARecord("a.com.", []byte{1, 1, 1, 2}, 600),
+		newARecord("a.com.", []byte{1, 1, 1, 3}, 86400),
+	}
+	p.clamp(msg)
+
+	for i, expect := range []uint32{60, 600, 3600} {
+		if ttl := msg.Answer[i].Header().Ttl; ttl != expect {
+			t.Fatalf("answer %d ttl should be %d, got %d", i, expect, ttl)
+		}
+	}
+}
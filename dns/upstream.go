@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDNSPort = "53"
+	defaultDoTPort = "853"
+)
+
+// upstream is the upstream nameserver, plain udp/tcp or dns over tls
+//
+// supported formats:
+//
+//	8.8.8.8
+//	udp://8.8.8.8:53
+//	tcp://8.8.8.8:53
+//	tls://1.1.1.1:853?name=cloudflare-dns.com&pin=base64(sha256(spki))
+type upstream struct {
+	// 64 bit atomic fields first, keep them aligned on 32 bit platform
+
+	// srtt is the smoothed round trip time in nanoseconds
+	srtt     int64
+	requests uint64
+	failures uint64
+	// downUntil is the unix nano time until which the upstream is considered down
+	downUntil          int64
+	continuousFailures int32
+
+	raw    string
+	net    string
+	addr   string
+	client *dns.Client
+	// tcpClient retry the truncated udp response over tcp
+	tcpClient *dns.Client
+	pool      *tlsPool
+	timeout   time.Duration
+}
+
+func parseUpstream(raw string, timeout time.Duration) (*upstream, error) {
+	s := raw
+	if !strings.Contains(s, "://") {
+		s = "udp://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream nameserver %s, %v", raw, err)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid upstream nameserver %s, empty host", raw)
+	}
+
+	ns := &upstream{raw: raw, timeout: timeout}
+
+	port := u.Port()
+	switch u.Scheme {
+	case "udp", "tcp":
+		if port == "" {
+			port = defaultDNSPort
+		}
+		ns.net = u.Scheme
+	case "tls":
+		if port == "" {
+			port = defaultDoTPort
+		}
+		ns.net = "tcp-tls"
+	default:
+		return nil, fmt.Errorf("invalid upstream nameserver %s, unsupported scheme %s", raw, u.Scheme)
+	}
+
+	ns.addr = net.JoinHostPort(u.Hostname(), port)
+
+	if ns.net == "tcp-tls" {
+		serverName := u.Query().Get("name")
+		if serverName == "" {
+			serverName = u.Hostname()
+		}
+
+		pins, err := parsePins(u.Query()["pin"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream nameserver %s, %v", raw, err)
+		}
+
+		ns.pool = newTLSPool(ns.addr, serverName, pins, timeout)
+		return ns, nil
+	}
+
+	ns.client = &dns.Client{
+		Net:     ns.net,
+		Timeout: timeout,
+	}
+
+	if ns.net == "udp" {
+		ns.tcpClient = &dns.Client{
+			Net:     "tcp",
+			Timeout: timeout,
+		}
+	}
+
+	return ns, nil
+}
+
+// forceTCP query the plain udp upstream over tcp only
+func (u *upstream) forceTCP() {
+	if u.net != "udp" {
+		return
+	}
+
+	u.net = "tcp"
+	u.client = u.tcpClient
+	u.tcpClient = nil
+}
+
+func parseUpstreams(nameservers string, timeout time.Duration) []*upstream {
+	var upstreams []*upstream
+	for _, n := range strings.Split(nameservers, ",") {
+		n = strings.TrimSpace(n)
+		if len(n) == 0 {
+			continue
+		}
+
+		ns, err := parseUpstream(n, timeout)
+		if err != nil {
+			log.Error("%v", err)
+			continue
+		}
+
+		upstreams = append(upstreams, ns)
+	}
+	return upstreams
+}
+
+func (u *upstream) exchange(r *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
+	if u.pool != nil {
+		resp, rtt, err = u.pool.exchange(r)
+	} else {
+		resp, rtt, err = u.client.Exchange(r, u.addr)
+		if err == nil && resp.Truncated && u.tcpClient != nil {
+			log.Debug("truncated response of %s from %s, retry over tcp", r.Question[0].Name, u)
+			var tcpRTT time.Duration
+			resp, tcpRTT, err = u.tcpClient.Exchange(r, u.addr)
+			rtt += tcpRTT
+		}
+	}
+
+	atomic.AddUint64(&u.requests, 1)
+	u.recordHealth(err)
+	if err != nil {
+		atomic.AddUint64(&u.failures, 1)
+		upstreamErrorsTotal.Inc(u.String())
+		// penalize the failure as timeout
+		rtt = u.timeout
+	} else {
+		upstreamRTT.Observe(rtt.Seconds(), u.String())
+	}
+	u.updateRTT(rtt)
+
+	return
+}
+
+// updateRTT update the smoothed rtt, srtt = 0.7 * srtt + 0.3 * rtt
+func (u *upstream) updateRTT(rtt time.Duration) {
+	for {
+		old := atomic.LoadInt64(&u.srtt)
+		next := int64(rtt)
+		if old > 0 {
+			next = (old*7 + int64(rtt)*3) / 10
+		}
+
+		if atomic.CompareAndSwapInt64(&u.srtt, old, next) {
+			return
+		}
+	}
+}
+
+func (u *upstream) rtt() time.Duration {
+	return time.Duration(atomic.LoadInt64(&u.srtt))
+}
+
+// sortByRTT return the upstreams ordered by health and srtt, upstream not tried yet goes first
+func sortByRTT(upstreams []*upstream) []*upstream {
+	sorted := append([]*upstream(nil), upstreams...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		hi, hj := sorted[i].healthy(), sorted[j].healthy()
+		if hi != hj {
+			return hi
+		}
+		return sorted[i].rtt() < sorted[j].rtt()
+	})
+	return sorted
+}
+
+func (u *upstream) String() string {
+	if u.net == "udp" {
+		return u.addr
+	}
+	return fmt.Sprintf("%s://%s", strings.TrimPrefix(u.net, "tcp-"), u.addr)
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUpstream(t *testing.T) {
+	cases := []struct {
+		raw  string
+		net  string
+		addr string
+	}{
+		{"8.8.8.8", "udp", "8.8.8.8:53"},
+		{"udp://8.8.8.8:5353", "udp", "8.8.8.8:5353"},
+		{"tcp://8.8.8.8", "tcp", "8.8.8.8:53"},
+		{"tls://1.1.1.1", "tcp-tls", "1.1.1.1:853"},
+		{"tls://1.1.1.1:853?name=cloudflare-dns.com", "tcp-tls", "1.1.1.1:853"},
+	}
+
+	for _, c := range cases {
+		ns, err := parseUpstream(c.raw, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ns.net != c.net || ns.addr != c.addr {
+			t.Fatalf("parse %s, got %s %s", c.raw, ns.net, ns.addr)
+		}
+	}
+
+	invalid := []string{
+		"http://8.8.8.8",
+		"tls://1.1.1.1?pin=invalid",
+	}
+
+	for _, raw := range invalid {
+		if _, err := parseUpstream(raw, time.Second); err == nil {
+			t.Fatalf("parse %s should fail", raw)
+		}
+	}
+}
+
+func TestUpstreamForceTCP(t *testing.T) {
+	ns, err := parseUpstream("8.8.8.8", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ns.tcpClient == nil {
+		t.Fatal("udp upstream should have tcp client for truncated response")
+	}
+
+	ns.forceTCP()
+	if ns.net != "tcp" || ns.client.Net != "tcp" || ns.tcpClient != nil {
+		t.Fatalf("upstream should be tcp only, got %s", ns)
+	}
+}
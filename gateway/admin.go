@@ -0,0 +1,241 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// adminHandler is the http api of the gateway
+//
+//	GET    /api/conns        list the active relayed connections
+//	DELETE /api/conns/<id>   kill the connection
+//	GET    /api/traffic?by=  get the bytes relayed per domain (default) or client ip
+//	GET    /api/limits       get the bandwidth limits of the client subnets and outbounds
+//	PUT    /api/limits?client=<subnet>|outbound=<name>&rate=
+//	                         set the bandwidth limit in bytes per second, K, M or G suffix
+//	DELETE /api/limits?client=<subnet>|outbound=<name>
+//	                         remove the bandwidth limit
+//	GET    /events?types=    websocket streaming the events as json, conn.open and conn.close
+//	                         (conn for both), all types if empty
+//	GET    /healthz          the process is alive, for the liveness probe
+//	GET    /readyz           the store is reachable and a proxy of the default outbound is
+//	                         alive, 503 if not, for the readiness probe
+//	GET    /debug/<path>     the pprof, expvar and dump endpoints guarded by the debug token,
+//	                         the dump includes the relayed connections
+type adminHandler struct {
+	gateway *Gateway
+}
+
+func newAdminHandler(g *Gateway) http.Handler {
+	a := &adminHandler{gateway: g}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/conns", a.conns)
+	mux.HandleFunc("/api/conns/", a.conn)
+	mux.HandleFunc("/api/traffic", a.traffic)
+	mux.HandleFunc("/api/limits", a.limits)
+	mux.HandleFunc("/events", a.events)
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.HandleFunc("/readyz", a.readyz)
+	if g.Config != nil {
+		mux.Handle("/debug/", internal.DebugHandler(g.Config.Admin.DebugToken, g.debugDump))
+	}
+	return mux
+}
+
+// serveAdmin start the admin http server if configured
+func (g *Gateway) serveAdmin() {
+	if g.Config == nil || g.Config.Admin.Gateway == "" {
+		return
+	}
+
+	admin := g.Config.Admin
+	g.admin = &http.Server{
+		Addr:    admin.Gateway,
+		Handler: admin.Protect(newAdminHandler(g)),
+	}
+
+	go func() {
+		log.Info("admin server listen on %s", g.admin.Addr)
+		var err error
+		if admin.Cert != "" && admin.Key != "" {
+			err = g.admin.ListenAndServeTLS(admin.Cert, admin.Key)
+		} else {
+			err = g.admin.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Error("start admin server fail, %v", err)
+		}
+	}()
+}
+
+// debugDump return the relayed connections and the udp tunnels for the debug dump
+func (g *Gateway) debugDump() interface{} {
+	g.udpTunnelLock.Lock()
+	tunnels := len(g.udpTunnels)
+	g.udpTunnelLock.Unlock()
+
+	conns := g.conns.list()
+	return map[string]interface{}{
+		"conns":      len(conns),
+		"udpTunnels": tunnels,
+		"relayed":    conns,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func (a *adminHandler) events(w http.ResponseWriter, r *http.Request) {
+	if a.gateway.conns == nil || a.gateway.conns.events == nil {
+		writeError(w, http.StatusNotFound, "events not enabled")
+		return
+	}
+	a.gateway.conns.events.Handler().ServeHTTP(w, r)
+}
+
+func (a *adminHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (a *adminHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	checks, ready := a.gateway.readiness()
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+func (a *adminHandler) conns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.gateway.conns.list())
+}
+
+func (a *adminHandler) conn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/conns/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid connection id")
+		return
+	}
+
+	if !a.gateway.conns.kill(id) {
+		writeError(w, http.StatusNotFound, "connection not found")
+		return
+	}
+
+	log.Info("kill connection %d by admin api", id)
+	writeJSON(w, http.StatusOK, map[string]uint64{"killed": id})
+}
+
+func (a *adminHandler) traffic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	kind := r.URL.Query().Get("by")
+	switch kind {
+	case "":
+		kind = trafficDomain
+	case trafficDomain, trafficClient:
+	default:
+		writeError(w, http.StatusBadRequest, "by should be domain or client")
+		return
+	}
+
+	// include the bytes not persisted yet
+	a.gateway.flushTraffic()
+
+	list, err := loadTraffic(a.gateway.Store, kind)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *adminHandler) limits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodDelete:
+		if err := a.setLimit(r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.gateway.bandwidth.limits())
+}
+
+// setLimit save the limit of the client subnet or outbound to the store and reload the limits
+func (a *adminHandler) setLimit(r *http.Request) error {
+	query := r.URL.Query()
+	kind, name := limitClient, query.Get("client")
+	if name == "" {
+		kind, name = limitOutbound, query.Get("outbound")
+	}
+	if name == "" {
+		return errors.New("client or outbound is required")
+	}
+
+	if kind == limitClient {
+		subnets, err := internal.ParseSubnets([]string{name})
+		if err != nil {
+			return err
+		}
+		name = subnets[0].String()
+	}
+
+	key := internal.GetRedisBandwidthKey(kind)
+	if r.Method == http.MethodDelete {
+		if err := a.gateway.Store.HDel(key, name); err != nil {
+			return err
+		}
+		log.Info("remove bandwidth limit of %s %s by admin api", kind, name)
+	} else {
+		rate, err := parseRate(query.Get("rate"))
+		if err != nil {
+			return err
+		}
+		if err := a.gateway.Store.HSet(key, name, strconv.FormatInt(rate, 10)); err != nil {
+			return err
+		}
+		log.Info("set bandwidth limit of %s %s to %d bytes/s by admin api", kind, name, rate)
+	}
+
+	return a.gateway.bandwidth.load(a.gateway.Store)
+}
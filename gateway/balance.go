@@ -0,0 +1,244 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+	"golang.org/x/net/proxy"
+)
+
+// the strategies of selecting the upstream proxy of an outbound
+const (
+	// strategyFailover use the first healthy proxy in order
+	strategyFailover = "failover"
+	// strategyRoundRobin rotate the healthy proxies
+	strategyRoundRobin = "round-robin"
+	// strategyLowestLatency use the healthy proxy with the lowest check latency
+	strategyLowestLatency = "lowest-latency"
+)
+
+const (
+	// healthCheckTCP check the proxy server is connectable
+	healthCheckTCP = "tcp"
+
+	defaultHealthCheckInterval = time.Duration(time.Second * 30)
+	healthCheckTimeout         = time.Duration(time.Second * 5)
+)
+
+var errNoUDPUpstream = errors.New("no upstream proxy support udp")
+
+// checker is the proxy can't be checked by connecting the server, checked by itself for the
+// tcp health check
+type checker interface {
+	check() error
+}
+
+// upstream is a proxy of the outbound with the health
+type upstream struct {
+	proxy  *url.URL
+	dialer proxy.Dialer
+	// udp relay udp through the proxy, nil if the proxy doesn't support udp
+	udp udpDialer
+	// forward connect the proxy server, the outbound relayed via, nil if directly
+	forward proxy.Dialer
+
+	lock    sync.RWMutex
+	alive   bool
+	latency time.Duration
+}
+
+func newUpstream(proxyURL string, forward proxy.Dialer) (*upstream, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := newDialer(u, forward)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &upstream{proxy: u, dialer: dialer, forward: forward, alive: true}
+	// the udp is not relayed through the chain, the packets are sent to the server directly
+	if forward == nil {
+		p.udp, _ = dialer.(udpDialer)
+	}
+	return p, nil
+}
+
+func (p *upstream) health() (alive bool, latency time.Duration) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.alive, p.latency
+}
+
+func (p *upstream) setHealth(alive bool, latency time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.alive = alive
+	p.latency = latency
+}
+
+// markDown mark the proxy unhealthy until the next check succeed
+func (p *upstream) markDown() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.alive = false
+}
+
+// check the proxy by connecting the proxy server, or requesting the url through the proxy
+func (p *upstream) check(target string) error {
+	start := time.Now()
+
+	var err error
+	if c, ok := p.dialer.(checker); ok && target == healthCheckTCP {
+		err = c.check()
+	} else if target == healthCheckTCP {
+		var conn net.Conn
+		conn, err = dialServer(p.forward, p.proxy.Host, healthCheckTimeout)
+		if err == nil {
+			conn.Close()
+		}
+	} else {
+		client := &http.Client{
+			Transport: &http.Transport{Dial: p.dialer.Dial, DisableKeepAlives: true},
+			Timeout:   healthCheckTimeout,
+		}
+		var resp *http.Response
+		resp, err = client.Get(target)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("unexpected status %s", resp.Status)
+			}
+		}
+	}
+
+	p.setHealth(err == nil, time.Since(start))
+	return err
+}
+
+// pick the upstream by the strategy from the healthy upstreams, all the upstreams are
+// candidates if none is healthy
+func (o *outbound) pick(udp bool) *upstream {
+	var candidates []*upstream
+	var latencies []time.Duration
+	for _, p := range o.upstreams {
+		if udp && p.udp == nil {
+			continue
+		}
+		if alive, latency := p.health(); alive {
+			candidates = append(candidates, p)
+			latencies = append(latencies, latency)
+		}
+	}
+
+	if len(candidates) == 0 {
+		for _, p := range o.upstreams {
+			if !udp || p.udp != nil {
+				return p
+			}
+		}
+		return nil
+	}
+
+	switch o.strategy {
+	case strategyRoundRobin:
+		return candidates[int(atomic.AddUint32(&o.next, 1)-1)%len(candidates)]
+	case strategyLowestLatency:
+		best := 0
+		for i, latency := range latencies {
+			if latency < latencies[best] {
+				best = i
+			}
+		}
+		return candidates[best]
+	}
+	return candidates[0]
+}
+
+// Dial connect the addr through the picked upstream, the upstream is marked down if the proxy
+// server is unreachable
+func (o *outbound) Dial(network, addr string) (net.Conn, error) {
+	p := o.pick(false)
+	conn, err := p.dialer.Dial(network, addr)
+	if _, ok := err.(*net.OpError); ok && len(o.upstreams) > 1 {
+		log.Warning("outbound %s upstream %s fail, mark down, %v", o.name, p.proxy.Host, err)
+		p.markDown()
+	}
+	return conn, err
+}
+
+// DialUDP relay udp through the picked upstream support udp
+func (o *outbound) DialUDP(addr string) (net.Conn, error) {
+	p := o.pick(true)
+	if p == nil {
+		return nil, errNoUDPUpstream
+	}
+	return p.udp.DialUDP(addr)
+}
+
+// supportUDP return true if any upstream can relay udp
+func (o *outbound) supportUDP() bool {
+	for _, p := range o.upstreams {
+		if p.udp != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// healthCheck check the upstreams of the outbounds with multiple upstreams periodically
+func (g *Gateway) healthCheck() {
+	for {
+		target, interval := g.loadHealthCheck()
+
+		select {
+		case <-g.done:
+			return
+		case <-time.After(interval):
+		}
+
+		for _, o := range append([]*outbound{g.outbound}, g.outbounds...) {
+			if o == nil || len(o.upstreams) < 2 {
+				continue
+			}
+
+			for _, p := range o.upstreams {
+				wasAlive, _ := p.health()
+				err := p.check(target)
+				if err != nil && wasAlive {
+					log.Warning("outbound %s upstream %s is down, %v", o.name, p.proxy.Host, err)
+				} else if err == nil && !wasAlive {
+					log.Info("outbound %s upstream %s is up", o.name, p.proxy.Host)
+				}
+			}
+		}
+	}
+}
+
+// loadHealthCheck load the check target and interval, the target is tcp or the url
+func (g *Gateway) loadHealthCheck() (string, time.Duration) {
+	target, err := g.Store.Get(internal.GetRedisProxyCheckKey())
+	if err != nil || (target != healthCheckTCP && !strings.HasPrefix(target, "http")) {
+		target = healthCheckTCP
+	}
+
+	interval := defaultHealthCheckInterval
+	if v, err := g.Store.Get(internal.GetRedisProxyCheckIntervalKey()); err == nil {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return target, interval
+}
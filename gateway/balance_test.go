@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOutboundPick(t *testing.T) {
+	o, err := newOutbound("test", "socks5://127.0.0.1:1, http://127.0.0.1:2, socks5://127.0.0.1:3", strategyFailover, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := o.upstreams[0], o.upstreams[1], o.upstreams[2]
+
+	if o.pick(false) != a {
+		t.Fatal("failover should pick the first proxy")
+	}
+
+	a.markDown()
+	if o.pick(false) != b || o.pick(true) != c {
+		t.Fatal("failover should pick the next healthy proxy")
+	}
+
+	o.strategy = strategyRoundRobin
+	if p1, p2 := o.pick(false), o.pick(false); p1 == p2 || p1 == a || p2 == a {
+		t.Fatal("round-robin should rotate the healthy proxies")
+	}
+
+	o.strategy = strategyLowestLatency
+	b.setHealth(true, time.Millisecond*20)
+	c.setHealth(true, time.Millisecond*10)
+	if o.pick(false) != c {
+		t.Fatal("should pick the lowest latency proxy")
+	}
+
+	b.markDown()
+	c.markDown()
+	if o.pick(false) != a {
+		t.Fatal("should pick from all proxies if none is healthy")
+	}
+
+	if _, err := newOutbound("test", "socks5://127.0.0.1:1", "random", nil); err == nil {
+		t.Fatal("unknown strategy should be rejected")
+	}
+}
+
+func TestUpstreamCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newUpstream("socks5://"+ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.check(healthCheckTCP); err != nil {
+		t.Fatal(err)
+	}
+
+	ln.Close()
+	p.check(healthCheckTCP)
+	if alive, _ := p.health(); alive {
+		t.Fatal("closed proxy should be down")
+	}
+}
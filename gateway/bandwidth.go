@@ -0,0 +1,278 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// the kinds of the bandwidth limits
+const (
+	limitClient   = "client"
+	limitOutbound = "outbound"
+)
+
+// the directions of the relayed bytes, limited separately
+const (
+	directionUpload   = "upload"
+	directionDownload = "download"
+)
+
+// bandwidthSweepInterval is the interval of evicting the idle buckets
+const bandwidthSweepInterval = time.Duration(time.Minute)
+
+// clientLimit is the bandwidth of each client ip in the subnet
+type clientLimit struct {
+	subnet *net.IPNet
+	rate   int64
+}
+
+// byteBucket is the token bucket of bytes, the burst is the bytes of a second
+type byteBucket struct {
+	tokens float64
+	last   time.Time
+	// rate is the rate of the last take
+	rate int64
+}
+
+// take the bytes from the bucket, the tokens can be negative, return the delay until the debt
+// is paid
+func (b *byteBucket) take(n int, rate int64, now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+	b.last = now
+	b.rate = rate
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// full return whether the bucket is refilled, the same as a new bucket
+func (b *byteBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*float64(b.rate) >= float64(b.rate)
+}
+
+// bandwidth limit the bytes relayed per second of each client ip in the subnets and of each
+// outbound, the upload and download are limited separately, the limits can be changed while
+// the connections relaying
+type bandwidth struct {
+	lock sync.Mutex
+	// clients is in the order of prefix length desc, the most specific subnet matched first
+	clients   []clientLimit
+	outbounds map[string]int64
+	// buckets is keyed by kind, client ip or outbound name and direction, the idle buckets
+	// refilled are evicted every bandwidthSweepInterval
+	buckets map[string]*byteBucket
+	swept   time.Time
+}
+
+func newBandwidth() *bandwidth {
+	return &bandwidth{
+		outbounds: make(map[string]int64),
+		buckets:   make(map[string]*byteBucket),
+	}
+}
+
+// load the limits from kungfu:bandwidth:client and kungfu:bandwidth:outbound
+func (b *bandwidth) load(store internal.Store) error {
+	clientRates, err := store.HGetAll(internal.GetRedisBandwidthKey(limitClient))
+	if err != nil {
+		return err
+	}
+
+	outboundRates, err := store.HGetAll(internal.GetRedisBandwidthKey(limitOutbound))
+	if err != nil {
+		return err
+	}
+
+	var clients []clientLimit
+	for subnet, value := range clientRates {
+		subnets, err := internal.ParseSubnets([]string{subnet})
+		if err != nil {
+			return fmt.Errorf("invalid client subnet %s, %v", subnet, err)
+		}
+		rate, err := parseRate(value)
+		if err != nil {
+			return fmt.Errorf("invalid bandwidth of client %s, %v", subnet, err)
+		}
+		clients = append(clients, clientLimit{subnet: subnets[0], rate: rate})
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		oi, _ := clients[i].subnet.Mask.Size()
+		oj, _ := clients[j].subnet.Mask.Size()
+		return oi > oj
+	})
+
+	outbounds := make(map[string]int64, len(outboundRates))
+	for name, value := range outboundRates {
+		rate, err := parseRate(value)
+		if err != nil {
+			return fmt.Errorf("invalid bandwidth of outbound %s, %v", name, err)
+		}
+		outbounds[name] = rate
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.clients = clients
+	b.outbounds = outbounds
+	b.buckets = make(map[string]*byteBucket)
+
+	log.Info("bandwidth limits loaded, clients: %d, outbounds: %d", len(clients), len(outbounds))
+	return nil
+}
+
+// wait until the bytes of the client and outbound are allowed
+func (b *bandwidth) wait(client net.IP, outbound string, direction string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	now := time.Now()
+	var delay time.Duration
+
+	b.lock.Lock()
+	if now.Sub(b.swept) >= bandwidthSweepInterval {
+		b.sweep(now)
+	}
+	for _, c := range b.clients {
+		if c.subnet.Contains(client) {
+			if d := b.take(limitClient+"|"+client.String()+"|"+direction, n, c.rate, now); d > delay {
+				delay = d
+			}
+			break
+		}
+	}
+	if rate, ok := b.outbounds[outbound]; ok {
+		if d := b.take(limitOutbound+"|"+outbound+"|"+direction, n, rate, now); d > delay {
+			delay = d
+		}
+	}
+	b.lock.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// take the bytes from the bucket of the key, must be called with the lock
+func (b *bandwidth) take(key string, n int, rate int64, now time.Time) time.Duration {
+	bucket := b.buckets[key]
+	if bucket == nil {
+		bucket = &byteBucket{tokens: float64(rate), last: now}
+		b.buckets[key] = bucket
+	}
+	return bucket.take(n, rate, now)
+}
+
+// sweep evict the buckets refilled, the clients gone are not kept, must be called with the lock
+func (b *bandwidth) sweep(now time.Time) {
+	for key, bucket := range b.buckets {
+		if bucket.full(now) {
+			delete(b.buckets, key)
+		}
+	}
+	b.swept = now
+}
+
+// limits return the limits of the clients and outbounds in bytes per second
+func (b *bandwidth) limits() map[string]map[string]int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	clients := make(map[string]int64, len(b.clients))
+	for _, c := range b.clients {
+		clients[c.subnet.String()] = c.rate
+	}
+
+	outbounds := make(map[string]int64, len(b.outbounds))
+	for name, rate := range b.outbounds {
+		outbounds[name] = rate
+	}
+
+	return map[string]map[string]int64{limitClient: clients, limitOutbound: outbounds}
+}
+
+// parseRate parse the bytes per second, with the optional suffix K, M or G of 1024
+func parseRate(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, should be positive bytes per second", value)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid rate %q, too large", value)
+	}
+	return n * unit, nil
+}
+
+// limitedConn limit the bandwidth of the tunnel, written is upload and read is download
+type limitedConn struct {
+	net.Conn
+	bandwidth *bandwidth
+	client    net.IP
+	outbound  string
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bandwidth.wait(c.client, c.outbound, directionDownload, n)
+	return n, err
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	c.bandwidth.wait(c.client, c.outbound, directionUpload, len(b))
+	return c.Conn.Write(b)
+}
+
+func (c *limitedConn) unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *limitedConn) account(n int, read bool) {
+	direction := directionUpload
+	if read {
+		direction = directionDownload
+	}
+	c.bandwidth.wait(c.client, c.outbound, direction, n)
+}
+
+func (c *limitedConn) CloseRead() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return nil
+}
+
+func (c *limitedConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
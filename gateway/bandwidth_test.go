@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, expect := range map[string]int64{"1024": 1024, "512k": 512 << 10, "2M": 2 << 20, "1G": 1 << 30} {
+		if rate, err := parseRate(s); err != nil || rate != expect {
+			t.Fatal("unexpected rate of", s, rate, err)
+		}
+	}
+
+	for _, s := range []string{"", "0", "-1k", "1T", "9223372036854775807K", "8589934592G"} {
+		if _, err := parseRate(s); err == nil {
+			t.Fatal("rate should be invalid", s)
+		}
+	}
+}
+
+func TestByteBucket(t *testing.T) {
+	now := time.Now()
+	b := &byteBucket{tokens: 1000, last: now}
+
+	if d := b.take(1000, 1000, now); d != 0 {
+		t.Fatal("burst should be allowed", d)
+	}
+	if d := b.take(500, 1000, now); d != 500*time.Millisecond {
+		t.Fatal("unexpected delay", d)
+	}
+	// the debt is paid after a second
+	if d := b.take(100, 1000, now.Add(time.Second)); d != 0 {
+		t.Fatal("unexpected delay", d)
+	}
+}
+
+func TestBandwidthSweep(t *testing.T) {
+	now := time.Now()
+	b := newBandwidth()
+	b.take("client|10.0.0.2|upload", 100, 1000, now)
+	// the debt of 1 second
+	b.take("client|10.0.0.3|upload", 2000, 1000, now)
+
+	b.sweep(now.Add(time.Second))
+	if len(b.buckets) != 1 || b.buckets["client|10.0.0.3|upload"] == nil {
+		t.Fatal("the bucket refilled should be evicted", b.buckets)
+	}
+	b.sweep(now.Add(2 * time.Second))
+	if len(b.buckets) != 0 {
+		t.Fatal("the bucket paid the debt should be evicted", b.buckets)
+	}
+}
+
+func TestBandwidthLimits(t *testing.T) {
+	g := &Gateway{Store: internal.NewMemoryStore(&internal.Memory{}), bandwidth: newBandwidth()}
+	handler := newAdminHandler(g)
+
+	for _, url := range []string{
+		"/api/limits?client=10.0.0.0/8&rate=1M",
+		"/api/limits?client=10.0.0.2&rate=10k",
+		"/api/limits?outbound=default&rate=100m",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatal("unexpected status", url, w.Code, w.Body.String())
+		}
+	}
+
+	limits := g.bandwidth.limits()
+	if limits[limitClient]["10.0.0.2/32"] != 10<<10 || limits[limitOutbound]["default"] != 100<<20 {
+		t.Fatal("unexpected limits", limits)
+	}
+
+	// the most specific subnet is matched
+	g.bandwidth.wait(net.ParseIP("10.0.0.2"), "default", directionUpload, 1)
+	g.bandwidth.wait(net.ParseIP("192.168.1.2"), "stream", directionUpload, 1)
+	if len(g.bandwidth.buckets) != 2 || g.bandwidth.buckets["client|10.0.0.2|upload"] == nil {
+		t.Fatal("unexpected buckets", g.bandwidth.buckets)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/limits?client=10.0.0.2", nil))
+	if w.Code != http.StatusOK || len(g.bandwidth.limits()[limitClient]) != 1 {
+		t.Fatal("limit should be removed", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/limits?outbound=default&rate=fast", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatal("invalid rate should be rejected", w.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// trackedConn is an active relayed connection in the connection table
+type trackedConn struct {
+	id       uint64
+	network  string
+	client   string
+	fakeIp   string
+	domain   string
+	target   string
+	outbound string
+	start    time.Time
+	// upload and download is the bytes relayed, updated while relaying
+	upload   int64
+	download int64
+	// reportedUpload and reportedDownload is the bytes added to the traffic stats, guarded by
+	// the lock of the table
+	reportedUpload   int64
+	reportedDownload int64
+	// kill close the connection
+	kill func()
+}
+
+// connInfo is the json of the tracked connection
+type connInfo struct {
+	ID       uint64    `json:"id"`
+	Network  string    `json:"network"`
+	Client   string    `json:"client"`
+	FakeIP   string    `json:"fakeIp"`
+	Domain   string    `json:"domain"`
+	Target   string    `json:"target"`
+	Outbound string    `json:"outbound"`
+	Upload   int64     `json:"upload"`
+	Download int64     `json:"download"`
+	Start    time.Time `json:"start"`
+	Duration string    `json:"duration"`
+}
+
+// connTable is the table of the active relayed connections, the bytes relayed are added to the
+// traffic stats on collect and remove
+type connTable struct {
+	lock    sync.RWMutex
+	next    uint64
+	conns   map[uint64]*trackedConn
+	traffic *trafficStats
+	// events publish the conn.open and conn.close events, nil to disable
+	events *internal.EventHub
+}
+
+func newConnTable(traffic *trafficStats) *connTable {
+	return &connTable{conns: make(map[uint64]*trackedConn), traffic: traffic}
+}
+
+// add assign the id of the connection and add it to the table
+func (t *connTable) add(c *trackedConn) *trackedConn {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.next++
+	c.id = t.next
+	c.start = time.Now()
+	t.conns[c.id] = c
+	if t.events.Active("conn.open") {
+		t.events.Publish("conn.open", c.info(c.start))
+	}
+	return c
+}
+
+func (t *connTable) remove(c *trackedConn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.conns, c.id)
+	t.report(c)
+	if t.events.Active("conn.close") {
+		t.events.Publish("conn.close", c.info(time.Now()))
+	}
+}
+
+// collect add the bytes relayed since the last collect of the active connections to the
+// traffic stats
+func (t *connTable) collect() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, c := range t.conns {
+		t.report(c)
+	}
+}
+
+// report add the bytes not reported of the connection, must be called with the lock
+func (t *connTable) report(c *trackedConn) {
+	upload, download := atomic.LoadInt64(&c.upload), atomic.LoadInt64(&c.download)
+	t.traffic.add(c.domain, c.client, upload-c.reportedUpload, download-c.reportedDownload)
+	c.reportedUpload, c.reportedDownload = upload, download
+}
+
+// list return the connections in the order of id
+func (t *connTable) list() []connInfo {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	now := time.Now()
+	list := make([]connInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		list = append(list, c.info(now))
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// info return the json of the connection at now
+func (c *trackedConn) info(now time.Time) connInfo {
+	return connInfo{
+		ID:       c.id,
+		Network:  c.network,
+		Client:   c.client,
+		FakeIP:   c.fakeIp,
+		Domain:   c.domain,
+		Target:   c.target,
+		Outbound: c.outbound,
+		Upload:   atomic.LoadInt64(&c.upload),
+		Download: atomic.LoadInt64(&c.download),
+		Start:    c.start,
+		Duration: now.Sub(c.start).Round(time.Second).String(),
+	}
+}
+
+// kill close the connection of the id, return false if not found, the connection is removed
+// by the relay after closed
+func (t *connTable) kill(id uint64) bool {
+	t.lock.RLock()
+	c := t.conns[id]
+	t.lock.RUnlock()
+
+	if c == nil {
+		return false
+	}
+	c.kill()
+	return true
+}
+
+// countingConn count the bytes of the tunnel, written is upload and read is download
+type countingConn struct {
+	net.Conn
+	c *trackedConn
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.c.download, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.c.upload, int64(n))
+	return n, err
+}
+
+func (c *countingConn) unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *countingConn) account(n int, read bool) {
+	if read {
+		atomic.AddInt64(&c.c.download, int64(n))
+	} else {
+		atomic.AddInt64(&c.c.upload, int64(n))
+	}
+}
+
+func (c *countingConn) CloseRead() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return nil
+}
+
+func (c *countingConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConnTable(t *testing.T) {
+	table := newConnTable(newTrafficStats())
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	killed := false
+	c := table.add(&trackedConn{network: "tcp", domain: "google.com", outbound: defaultOutbound,
+		kill: func() {
+			killed = true
+			client.Close()
+		}})
+
+	conn := &countingConn{Conn: client, c: c}
+	go server.Read(make([]byte, 4))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	list := table.list()
+	if len(list) != 1 || list[0].ID != c.id || list[0].Domain != "google.com" || list[0].Upload != 4 {
+		t.Fatal("unexpected connections", list)
+	}
+
+	if table.kill(c.id + 1) {
+		t.Fatal("kill unknown connection should fail")
+	}
+	if !table.kill(c.id) || !killed {
+		t.Fatal("connection should be killed")
+	}
+
+	table.remove(c)
+	if len(table.list()) != 0 {
+		t.Fatal("connection should be removed")
+	}
+}
+
+func TestAdminConns(t *testing.T) {
+	g := &Gateway{conns: newConnTable(newTrafficStats())}
+	c := g.conns.add(&trackedConn{network: "udp", kill: func() {}})
+	handler := newAdminHandler(g)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/conns", nil))
+
+	var list []connInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Network != "udp" {
+		t.Fatal("unexpected response", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/conns/100", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatal("unexpected status", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/conns/1", nil))
+	if w.Code != http.StatusOK || c.id != 1 {
+		t.Fatal("unexpected status", w.Code)
+	}
+}
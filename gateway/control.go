@@ -0,0 +1,360 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yinheli/kungfu/api"
+	"github.com/yinheli/kungfu/internal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// controlStatsInterval is the default interval of the stats streamed
+	controlStatsInterval = time.Duration(time.Second)
+	// controlStatsMinInterval is the min interval of the stats streamed, the stats read the store
+	controlStatsMinInterval = time.Duration(time.Millisecond * 100)
+	// controlTopDomains is the count of the top domains of the stats
+	controlTopDomains = 10
+)
+
+// controlServer is the gRPC control api of the gateway, see api/kungfu.proto
+type controlServer struct {
+	api.UnimplementedControlServer
+	gateway *Gateway
+	admin   internal.Admin
+}
+
+// newControlServer return the gRPC server of the control api, the token or the basic auth of
+// the admin config is required if configured
+func newControlServer(g *Gateway, opts ...grpc.ServerOption) *grpc.Server {
+	s := &controlServer{gateway: g}
+	if g.Config != nil {
+		s.admin = g.Config.Admin
+	}
+
+	opts = append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+
+	server := grpc.NewServer(opts...)
+	api.RegisterControlServer(server, s)
+	return server
+}
+
+// serveControl start the control api server if configured
+func (g *Gateway) serveControl() {
+	if g.Config == nil || g.Config.Admin.GRPC == "" {
+		return
+	}
+
+	admin := g.Config.Admin
+	var opts []grpc.ServerOption
+	if admin.Cert != "" && admin.Key != "" {
+		creds, err := credentials.NewServerTLSFromFile(admin.Cert, admin.Key)
+		if err != nil {
+			log.Error("start control server fail, %v", err)
+			return
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	ln, err := net.Listen("tcp", admin.GRPC)
+	if err != nil {
+		log.Error("start control server fail, %v", err)
+		return
+	}
+
+	g.control = newControlServer(g, opts...)
+	go func() {
+		log.Info("control server listen on %s", admin.GRPC)
+		if err := g.control.Serve(ln); err != nil {
+			log.Error("control server error, %v", err)
+		}
+	}()
+}
+
+// authorize check the authorization metadata, the same as the Authorization header of the
+// admin http apis
+func (s *controlServer) authorize(ctx context.Context) error {
+	if !s.admin.AuthEnabled() {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if s.admin.Authorized(auth) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+func (s *controlServer) GetStats(ctx context.Context, req *api.StatsRequest) (*api.Stats, error) {
+	return s.stats(time.Now())
+}
+
+func (s *controlServer) WatchStats(req *api.WatchStatsRequest, stream api.Control_WatchStatsServer) error {
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if interval == 0 {
+		interval = controlStatsInterval
+	} else if interval < controlStatsMinInterval {
+		interval = controlStatsMinInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := s.stats(time.Now())
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(stats); err != nil {
+			return err
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.gateway.done:
+			return status.Error(codes.Unavailable, "gateway shutdown")
+		case <-ticker.C:
+		}
+	}
+}
+
+// stats return the connections, the outbounds and the stats of today at now
+func (s *controlServer) stats(now time.Time) (*api.Stats, error) {
+	g := s.gateway
+	stats := &api.Stats{Time: now.UnixNano() / int64(time.Millisecond)}
+
+	for _, c := range g.conns.list() {
+		if c.Network == "udp" {
+			stats.UdpConns++
+		} else {
+			stats.TcpConns++
+		}
+		stats.Upload += c.Upload
+		stats.Download += c.Download
+	}
+
+	g.udpTunnelLock.Lock()
+	stats.UdpTunnels = uint32(len(g.udpTunnels))
+	g.udpTunnelLock.Unlock()
+
+	for _, o := range append([]*outbound{g.outbound}, g.outbounds...) {
+		if o == nil {
+			continue
+		}
+
+		outbound := &api.Outbound{Name: o.name, Strategy: o.strategy}
+		for _, p := range o.upstreams {
+			alive, latency := p.health()
+			outbound.Upstreams = append(outbound.Upstreams, &api.Upstream{
+				Proxy:     (&url.URL{Scheme: p.proxy.Scheme, Host: p.proxy.Host}).String(),
+				Alive:     alive,
+				LatencyMs: int64(latency / time.Millisecond),
+				Udp:       p.udp != nil,
+			})
+		}
+		stats.Outbounds = append(stats.Outbounds, outbound)
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	hours := internal.StatsHours(midnight, now)
+
+	queries, err := internal.TopStats(g.Store, internal.StatsQueries, hours, 0)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	stats.Queries = make(map[string]int64, len(queries))
+	for _, q := range queries {
+		stats.Queries[q.Name] = q.Count
+	}
+
+	top, err := internal.TopStats(g.Store, internal.StatsBytes, hours, controlTopDomains)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	for _, t := range top {
+		stats.TopDomains = append(stats.TopDomains, &api.Count{Name: t.Name, Count: t.Count})
+	}
+	return stats, nil
+}
+
+// ruleKey return the set of the rules and the channel notified on change, the proxy domain set
+// reloaded by the dns servers if the outbound is empty, else the rules of the outbound reloaded
+// by the gateways
+func (s *controlServer) ruleKey(outbound string) (string, string, error) {
+	if outbound == "" {
+		return internal.GetRedisProxyDomainSetKey(), internal.GetRedisProxyDomainChannelKey(), nil
+	}
+
+	if _, err := s.gateway.Store.Get(internal.GetRedisOutboundKey(outbound)); err == internal.ErrNil {
+		return "", "", status.Errorf(codes.NotFound, "outbound %s not found", outbound)
+	} else if err != nil {
+		return "", "", status.Error(codes.Unavailable, err.Error())
+	}
+	return internal.GetRedisOutboundRuleKey(outbound), internal.GetRedisProxyChannelKey(), nil
+}
+
+func (s *controlServer) ListRules(ctx context.Context, req *api.ListRulesRequest) (*api.Rules, error) {
+	key, _, err := s.ruleKey(req.Outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	domains, err := s.gateway.Store.SMembers(key)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	sort.Strings(domains)
+	return &api.Rules{Domains: domains}, nil
+}
+
+func (s *controlServer) AddRules(ctx context.Context, req *api.RulesRequest) (*api.Rules, error) {
+	return s.updateRules(req, "add")
+}
+
+func (s *controlServer) RemoveRules(ctx context.Context, req *api.RulesRequest) (*api.Rules, error) {
+	return s.updateRules(req, "remove")
+}
+
+// updateRules add or remove the domains, then notify the dns servers or the gateways reload
+func (s *controlServer) updateRules(req *api.RulesRequest, cmd string) (*api.Rules, error) {
+	var domains []string
+	for _, domain := range req.Domains {
+		domain = strings.TrimSpace(domain)
+		// the outbound rules keep the prefix and the case of the regex rules
+		if req.Outbound == "" {
+			domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		}
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty domain")
+	}
+
+	key, channel, err := s.ruleKey(req.Outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	store := s.gateway.Store
+	if cmd == "add" {
+		err = store.SAdd(key, domains...)
+	} else {
+		err = store.SRem(key, domains...)
+	}
+	if err == nil {
+		err = store.Publish(channel, cmd)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	if req.Outbound == "" {
+		log.Info("%s proxy domains %s by control api", cmd, strings.Join(domains, ", "))
+	} else {
+		log.Info("%s rules %s of outbound %s by control api", cmd, strings.Join(domains, ", "), req.Outbound)
+	}
+	return &api.Rules{Domains: domains}, nil
+}
+
+func (s *controlServer) ListConns(ctx context.Context, req *api.ListConnsRequest) (*api.Conns, error) {
+	now := time.Now()
+	list := s.gateway.conns.list()
+	conns := &api.Conns{Conns: make([]*api.Conn, 0, len(list))}
+	for _, c := range list {
+		conns.Conns = append(conns.Conns, controlConn(c, now))
+	}
+	return conns, nil
+}
+
+func (s *controlServer) WatchConns(req *api.WatchConnsRequest, stream api.Control_WatchConnsServer) error {
+	events, cancel := s.gateway.conns.events.Subscribe("conn")
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.gateway.done:
+			return status.Error(codes.Unavailable, "gateway shutdown")
+		case event := <-events:
+			c, ok := event.Data.(connInfo)
+			if !ok {
+				continue
+			}
+
+			typ := strings.TrimPrefix(event.Type, "conn.")
+			if err := stream.Send(&api.ConnEvent{Type: typ, Conn: controlConn(c, event.Time)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *controlServer) KillConn(ctx context.Context, req *api.KillConnRequest) (*api.KillConnReply, error) {
+	if !s.gateway.conns.kill(req.Id) {
+		return nil, status.Errorf(codes.NotFound, "connection %d not found", req.Id)
+	}
+
+	log.Info("kill connection %d by control api", req.Id)
+	return &api.KillConnReply{Id: req.Id}, nil
+}
+
+// ReloadConfig reload config.yml if the gateway started with it, then notify the gateways reload
+// the config of the store, so the outbounds are reloaded even if config.yml not changed
+func (s *controlServer) ReloadConfig(ctx context.Context, req *api.ReloadConfigRequest) (*api.ReloadConfigReply, error) {
+	if s.gateway.ReloadConfig != nil {
+		if err := s.gateway.ReloadConfig(); err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "reload config error, %v", err)
+		}
+	}
+
+	if err := s.gateway.Store.Publish(internal.GetRedisProxyChannelKey(), "reload"); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	log.Info("reload config by control api")
+	return &api.ReloadConfigReply{}, nil
+}
+
+// controlConn return the connection message of the tracked connection at now
+func controlConn(c connInfo, now time.Time) *api.Conn {
+	return &api.Conn{
+		Id:         c.ID,
+		Network:    c.Network,
+		Client:     c.Client,
+		FakeIp:     c.FakeIP,
+		Domain:     c.Domain,
+		Target:     c.Target,
+		Outbound:   c.Outbound,
+		Upload:     c.Upload,
+		Download:   c.Download,
+		Start:      c.Start.UnixNano() / int64(time.Millisecond),
+		DurationMs: int64(now.Sub(c.Start) / time.Millisecond),
+	}
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"net"
+	"time"
+
+	"github.com/yinheli/kungfu/geoip"
+)
+
+// directTimeout is the dial timeout of the real ip relayed directly
+const directTimeout = time.Duration(time.Second * 5)
+
+// directIp return the real ip if the connections of the fake ip should be relayed directly, the
+// real ip in the china ip list or matched the DIRECT ip rules, empty otherwise
+func (g *Gateway) directIp(dstIp net.IP) string {
+	chnRoutes := g.ChnRoutes.Ranges()
+	db := g.GeoIP.DB()
+	geoipDirect := g.geoipRules.Has(db, geoip.ActionDirect)
+
+	// the real ip is resolved for ipv4 only
+	if dstIp.To4() == nil || (chnRoutes.Len() == 0 && !geoipDirect) {
+		return ""
+	}
+
+	realIp, err := g.getRealIp(dstIp.String())
+	if err != nil {
+		log.Warning("get real ip of %v error, %v", dstIp, err)
+		return ""
+	}
+
+	ip := net.ParseIP(realIp)
+	if chnRoutes.Contains(ip) {
+		log.Debug("real ip %s of %v is in china ip list, relay directly", realIp, dstIp)
+		return realIp
+	}
+
+	if geoipDirect && g.geoipRules.Match(db, ip) == geoip.ActionDirect {
+		return realIp
+	}
+	return ""
+}
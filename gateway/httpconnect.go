@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// httpConnectClient tunnel tcp through the http proxy by CONNECT, with the basic auth of the
+// username and password of the proxy url, the proxy is connected by tls if the scheme is https
+type httpConnectClient struct {
+	server  string
+	auth    string
+	tls     bool
+	timeout time.Duration
+	// forward connect the server, directly if nil
+	forward proxy.Dialer
+}
+
+func newHTTPConnectClient(u *url.URL) *httpConnectClient {
+	c := &httpConnectClient{server: u.Host, tls: u.Scheme == "https", timeout: socks5Timeout}
+	if u.Port() == "" {
+		port := "80"
+		if c.tls {
+			port = "443"
+		}
+		c.server = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	if u.User != nil {
+		password, _ := u.User.Password()
+		c.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+	}
+	return c
+}
+
+// Dial connect the addr through the http proxy, implement proxy.Dialer
+func (c *httpConnectClient) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("http connect: unsupported network %s", network)
+	}
+
+	conn, err := dialServer(c.forward, c.server, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.server)
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	r, err := c.connect(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	// the data of the target sent with the response
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+func (c *httpConnectClient) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if c.auth != "" {
+		req.Header.Set("Proxy-Authorization", c.auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http connect: %s", resp.Status)
+	}
+	return r, nil
+}
+
+// bufferedConn read the buffered data first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *bufferedConn) CloseRead() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return nil
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// fakeHTTPProxy accept one CONNECT request with the basic auth, then echo
+func fakeHTTPProxy(t *testing.T, username, password string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+
+		resp := &http.Response{StatusCode: http.StatusOK, ProtoMajor: 1, ProtoMinor: 1}
+		auth := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}})
+		user, pass, ok := auth.BasicAuth()
+		if req.Method != http.MethodConnect || req.Host != "example.com:443" {
+			resp.StatusCode = http.StatusBadRequest
+		} else if !ok || user != username || pass != password {
+			resp.StatusCode = http.StatusProxyAuthRequired
+		}
+		resp.Write(conn)
+
+		if resp.StatusCode == http.StatusOK {
+			io.Copy(conn, r)
+		}
+	}()
+
+	return ln
+}
+
+func TestHTTPConnectClientDial(t *testing.T) {
+	ln := fakeHTTPProxy(t, "user", "pass")
+	defer ln.Close()
+
+	u, _ := url.Parse("http://user:pass@" + ln.Addr().String())
+	conn, err := newHTTPConnectClient(u).Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatal("should tunnel through the proxy", err)
+	}
+}
+
+func TestHTTPConnectClientAuthFail(t *testing.T) {
+	ln := fakeHTTPProxy(t, "user", "pass")
+	defer ln.Close()
+
+	u, _ := url.Parse("http://" + ln.Addr().String())
+	if _, err := newHTTPConnectClient(u).Dial("tcp", "example.com:443"); err == nil {
+		t.Fatal("should fail without auth")
+	}
+}
@@ -71,3 +71,15 @@ func (p *ipv4Packet) pseudoSum() uint32 {
 	sum += uint32(p.dataLen())
 	return sum
 }
+
+// ipPacket is the common part of ipv4 and ipv6 packet
+type ipPacket interface {
+	payload() []byte
+	protocol() byte
+	sourceIP() net.IP
+	setSourceIP(ip net.IP)
+	destinationIP() net.IP
+	setDestinationIP(ip net.IP)
+	resetChecksum()
+	pseudoSum() uint32
+}
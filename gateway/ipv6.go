@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const ipv6HeaderLen = 40
+
+type ipv6Packet []byte
+
+func (p *ipv6Packet) payloadLen() uint16 {
+	return binary.BigEndian.Uint16((*p)[4:6])
+}
+
+// valid return whether the packet holds the payload length, the malformed packets are dropped
+func (p *ipv6Packet) valid() bool {
+	return len(*p) >= ipv6HeaderLen && len(*p) >= ipv6HeaderLen+int(p.payloadLen())
+}
+
+func (p *ipv6Packet) payload() []byte {
+	return (*p)[ipv6HeaderLen : ipv6HeaderLen+int(p.payloadLen())]
+}
+
+// protocol is the next header, extension headers are not supported
+func (p *ipv6Packet) protocol() byte {
+	return (*p)[6]
+}
+
+func (p *ipv6Packet) sourceIP() net.IP {
+	return net.IP(append([]byte(nil), (*p)[8:24]...))
+}
+
+func (p *ipv6Packet) setSourceIP(ip net.IP) {
+	copy((*p)[8:24], []byte(ip.To16()))
+}
+
+func (p *ipv6Packet) destinationIP() net.IP {
+	return net.IP(append([]byte(nil), (*p)[24:40]...))
+}
+
+func (p *ipv6Packet) setDestinationIP(ip net.IP) {
+	copy((*p)[24:40], []byte(ip.To16()))
+}
+
+// resetChecksum ipv6 header has no checksum
+func (p *ipv6Packet) resetChecksum() {
+}
+
+func (p *ipv6Packet) pseudoSum() uint32 {
+	sum := sum((*p)[8:40])
+	sum += uint32(p.payloadLen())
+	sum += uint32(p.protocol())
+	return sum
+}
+
+func isIPv6Packet(packet *[]byte) bool {
+	return len(*packet) >= ipv6HeaderLen && ((*packet)[0]>>4) == 6
+}
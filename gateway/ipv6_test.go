@@ -0,0 +1,28 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestIPv6PacketValid(t *testing.T) {
+	packet := make([]byte, ipv6HeaderLen+8)
+	packet[0] = 6 << 4
+	binary.BigEndian.PutUint16(packet[4:], 8)
+
+	p := ipv6Packet(packet)
+	if !isIPv6Packet((*[]byte)(&p)) || !p.valid() || len(p.payload()) != 8 {
+		t.Fatal("the packet should be valid", len(p.payload()))
+	}
+
+	// the payload length larger than the packet read
+	binary.BigEndian.PutUint16(packet[4:], 1400)
+	if p.valid() {
+		t.Fatal("the truncated packet should be invalid")
+	}
+
+	p = ipv6Packet(packet[:20])
+	if p.valid() {
+		t.Fatal("the packet shorter than the header should be invalid")
+	}
+}
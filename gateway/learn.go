@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	defaultLearnThreshold = 3
+	// learnWindow is the period the failures counted in
+	learnWindow = time.Duration(time.Minute * 10)
+	// defaultLearnTTL is the period the learned domain is proxied
+	defaultLearnTTL = time.Duration(time.Hour * 24 * 7)
+	// learnLogMaxLen is the approximate max length of the learned events stream
+	learnLogMaxLen = 10000
+)
+
+// failure is the direct route failures of a domain in the window
+type failure struct {
+	count int
+	first time.Time
+}
+
+// learner learn the domains whose direct route repeatedly times out or is reset while racing,
+// the learned domains are proxied without racing until the ttl expire
+type learner struct {
+	store internal.Store
+
+	lock      sync.Mutex
+	enabled   bool
+	threshold int
+	ttl       time.Duration
+	failures  map[string]*failure
+}
+
+func newLearner(store internal.Store) *learner {
+	return &learner{
+		store:     store,
+		threshold: defaultLearnThreshold,
+		ttl:       defaultLearnTTL,
+		failures:  make(map[string]*failure),
+	}
+}
+
+// load the config, kungfu:adaptive enable learning, kungfu:adaptive-threshold is the failures
+// to learn and kungfu:adaptive-ttl is the seconds the learned domain proxied
+func (l *learner) load() {
+	enabled, _ := l.store.Get(internal.GetRedisAdaptiveKey())
+
+	threshold := defaultLearnThreshold
+	if v, err := l.store.Get(internal.GetRedisAdaptiveThresholdKey()); err == nil {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			threshold = n
+		}
+	}
+
+	ttl := defaultLearnTTL
+	if v, err := l.store.Get(internal.GetRedisAdaptiveTTLKey()); err == nil {
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds > 0 {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.enabled = enabled == "true"
+	l.threshold = threshold
+	l.ttl = ttl
+}
+
+// learned return true if the domain is learned
+func (l *learner) learned(domain string) bool {
+	_, err := l.store.Get(internal.GetRedisLearnedKey(domain))
+	return err == nil
+}
+
+// observe the direct route result of the domain, the domain is learned if the direct route
+// times out or is reset threshold times in the window
+func (l *learner) observe(domain string, err error) {
+	now := time.Now()
+
+	l.lock.Lock()
+	if !l.enabled {
+		l.lock.Unlock()
+		return
+	}
+
+	if err == nil {
+		delete(l.failures, domain)
+		l.lock.Unlock()
+		return
+	}
+
+	reason := blockedReason(err)
+	if reason == "" {
+		l.lock.Unlock()
+		return
+	}
+
+	f := l.failures[domain]
+	if f == nil || now.Sub(f.first) > learnWindow {
+		f = &failure{first: now}
+		l.failures[domain] = f
+	}
+	f.count++
+
+	if f.count < l.threshold {
+		l.lock.Unlock()
+		return
+	}
+
+	count, ttl := f.count, l.ttl
+	delete(l.failures, domain)
+	l.lock.Unlock()
+
+	l.learn(domain, reason, count, ttl)
+}
+
+// learn proxy the domain for ttl and record the event
+func (l *learner) learn(domain string, reason string, count int, ttl time.Duration) {
+	if err := l.store.Set(internal.GetRedisLearnedKey(domain), reason, ttl); err != nil {
+		log.Error("learn domain %s error, %v", domain, err)
+		return
+	}
+
+	log.Info("learn domain %s, direct route %s %d times, proxied for %v", domain, reason, count, ttl)
+
+	l.store.XAdd(internal.GetRedisLearnLogKey(), learnLogMaxLen, map[string]string{
+		"time":     time.Now().Format(time.RFC3339),
+		"domain":   domain,
+		"reason":   reason,
+		"failures": strconv.Itoa(count),
+		"ttl":      strconv.FormatInt(int64(ttl/time.Second), 10),
+	})
+}
+
+// blockedReason return timeout or reset if the error is the sign of blocking, empty otherwise
+func blockedReason(err error) string {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return "timeout"
+	}
+
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	if err == syscall.ECONNRESET {
+		return "reset"
+	}
+	return ""
+}
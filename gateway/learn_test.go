@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestBlockedReason(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	cases := map[error]string{
+		timeoutError{}:        "timeout",
+		reset:                 "reset",
+		errors.New("refused"): "",
+		syscall.ECONNREFUSED:  "",
+	}
+	for err, expected := range cases {
+		if reason := blockedReason(err); reason != expected {
+			t.Fatal("unexpected reason of", err, reason)
+		}
+	}
+}
+
+func TestLearner(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	store.Set(internal.GetRedisAdaptiveKey(), "true", 0)
+	store.Set(internal.GetRedisAdaptiveThresholdKey(), "2", 0)
+	store.Set(internal.GetRedisAdaptiveTTLKey(), "60", 0)
+
+	l := newLearner(store)
+	l.load()
+	if l.threshold != 2 || l.ttl != time.Minute {
+		t.Fatal("unexpected config", l.threshold, l.ttl)
+	}
+
+	// the success reset the failures
+	l.observe("example.com", timeoutError{})
+	l.observe("example.com", nil)
+	l.observe("example.com", timeoutError{})
+	if l.learned("example.com") {
+		t.Fatal("domain should not be learned after success")
+	}
+
+	// the other errors are not counted
+	l.observe("example.com", errors.New("refused"))
+	if l.learned("example.com") {
+		t.Fatal("domain should not be learned by other errors")
+	}
+
+	l.observe("example.com", timeoutError{})
+	if !l.learned("example.com") {
+		t.Fatal("domain should be learned")
+	}
+
+	g := &Gateway{Store: store, learner: l}
+	g.raceRules, _ = gfwlist.NewMatcher([]string{"example.com"})
+	if g.raceDirect("example.com", net.ParseIP("10.85.0.2")) {
+		t.Fatal("learned domain should not race")
+	}
+}
+
+func TestLearnerDisabled(t *testing.T) {
+	l := newLearner(internal.NewMemoryStore(&internal.Memory{}))
+	l.load()
+	for i := 0; i < defaultLearnThreshold; i++ {
+		l.observe("example.com", timeoutError{})
+	}
+	if l.learned("example.com") {
+		t.Fatal("domain should not be learned if disabled")
+	}
+}
@@ -0,0 +1,18 @@
+package gateway
+
+import (
+	"github.com/yinheli/kungfu/metrics"
+)
+
+var (
+	connectionsTotal = metrics.NewCounter("kungfu_gateway_connections_total",
+		"proxied connections by network", "network")
+	activeConnections = metrics.NewGauge("kungfu_gateway_active_connections",
+		"active proxied connections by network", "network")
+	dialErrorsTotal = metrics.NewCounter("kungfu_gateway_dial_errors_total",
+		"dial errors by network", "network")
+	relayBytesTotal = metrics.NewCounter("kungfu_gateway_relay_bytes_total",
+		"relayed bytes of tcp connections by direction", "direction")
+	rejectedTotal = metrics.NewCounter("kungfu_gateway_rejected_total",
+		"connections reset by reject list", "list")
+)
@@ -0,0 +1,571 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// muxDestination is the address of the tunnel connection the server serve the mux on
+	muxDestination = "sp.mux.sing-box.arpa:444"
+	// muxProtocolSmux is the protocol of the session request of sing-mux, version 0
+	muxProtocolSmux = 1
+
+	smuxVersion = 1
+	smuxCmdSYN  = 0
+	smuxCmdFIN  = 1
+	smuxCmdPSH  = 2
+	smuxCmdNOP  = 3
+	smuxHeader  = 8
+	// smuxMaxFrame is the max payload of a frame, the default of the servers
+	smuxMaxFrame = 32768
+	// smuxMaxBuffer is the max bytes buffered of the session, the tunnel is not read until the
+	// streams read the buffer
+	smuxMaxBuffer = 4 * 1024 * 1024
+
+	// muxKeepAlive is the interval of the keepalive frames, the servers close the session not
+	// received anything in 30 seconds
+	muxKeepAlive = time.Duration(time.Second * 10)
+	// muxIdleTimeout is how long the session without the streams is kept
+	muxIdleTimeout = time.Duration(time.Minute)
+
+	muxStatusSuccess = 0
+	// muxMaxMessage is the max length of the error message of the stream rejected
+	muxMaxMessage = 1024
+)
+
+var errMuxClosed = errors.New("mux: session closed")
+
+// muxClient multiplex the tcp connections in the streams of the smux sessions over a few long
+// lived tunnel connections of the proxy, the sing-mux protocol of sing-box, enabled by the
+// mux=<max streams of a tunnel> option of the proxy url of shadowsocks, trojan, vmess and vless,
+// a new tunnel is connected when all the tunnels are full, the idle tunnels are closed. Only the
+// servers of sing-box (and xray with sing-mux) serve it, the plain smux of the other servers
+// (e.g. v2ray mux.cool) is not supported
+type muxClient struct {
+	dialer     proxy.Dialer
+	maxStreams int
+
+	lock     sync.Mutex
+	sessions []*muxSession
+}
+
+// muxUDPClient is the mux client of the proxy can relay udp, the udp is not multiplexed
+type muxUDPClient struct {
+	*muxClient
+	udpDialer
+}
+
+// withMux return the mux client of the dialer if the mux option set, the dialer if not
+func withMux(u *url.URL, dialer proxy.Dialer) (proxy.Dialer, error) {
+	option := u.Query().Get("mux")
+	if option == "" {
+		return dialer, nil
+	}
+
+	maxStreams, err := strconv.Atoi(option)
+	if err != nil || maxStreams < 1 {
+		return nil, fmt.Errorf("mux: invalid max streams %s", option)
+	}
+
+	c := &muxClient{dialer: dialer, maxStreams: maxStreams}
+	if udp, ok := dialer.(udpDialer); ok {
+		return &muxUDPClient{muxClient: c, udpDialer: udp}, nil
+	}
+	return c, nil
+}
+
+// Dial open the stream to the addr in the session not full, implement proxy.Dialer
+func (c *muxClient) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("mux: unsupported network %s", network)
+	}
+
+	dst, err := socks5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := session.open()
+	if err != nil {
+		return nil, err
+	}
+
+	// the stream request, the flags and the address
+	if _, err := stream.Write(append([]byte{0, 0}, dst...)); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return &muxConn{muxStream: stream}, nil
+}
+
+// session return the session not full, a new session is connected if none, the tunnel is dialed
+// without the lock so a slow server does not block the other dials
+func (c *muxClient) session() (*muxSession, error) {
+	if s := c.pick(); s != nil {
+		return s, nil
+	}
+
+	conn, err := c.dialer.Dial("tcp", muxDestination)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{0, muxProtocolSmux}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := newMuxSession(conn)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// the session installed by the concurrent dial is preferred, the extra one is closed
+	if picked := c.pickLocked(); picked != nil {
+		s.close()
+		return picked, nil
+	}
+	c.sessions = append(c.sessions, s)
+	return s, nil
+}
+
+// pick return the session not full, nil if none
+func (c *muxClient) pick() *muxSession {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.pickLocked()
+}
+
+// pickLocked remove the closed sessions and return the session not full, c.lock is held
+func (c *muxClient) pickLocked() *muxSession {
+	alive := c.sessions[:0]
+	var picked *muxSession
+	for _, s := range c.sessions {
+		if s.isClosed() {
+			continue
+		}
+		alive = append(alive, s)
+		if picked == nil && s.numStreams() < c.maxStreams {
+			picked = s
+		}
+	}
+	c.sessions = alive
+	return picked
+}
+
+// muxSession is the client of the smux (version 1) session over the tunnel, each frame is the
+// version, the command, the length and the stream id in little endian, and the payload
+type muxSession struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	streams  map[uint32]*muxStream
+	nextID   uint32
+	idleFrom time.Time
+	// buffered is the bytes received not read by the streams
+	buffered int
+	bucket   chan struct{}
+
+	die     chan struct{}
+	dieOnce sync.Once
+}
+
+func newMuxSession(conn net.Conn) *muxSession {
+	s := &muxSession{
+		conn:     conn,
+		streams:  make(map[uint32]*muxStream),
+		nextID:   1,
+		idleFrom: time.Now(),
+		bucket:   make(chan struct{}, 1),
+		die:      make(chan struct{}),
+	}
+	go s.recvLoop()
+	go s.keepalive()
+	return s
+}
+
+func (s *muxSession) isClosed() bool {
+	select {
+	case <-s.die:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *muxSession) numStreams() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.streams)
+}
+
+func (s *muxSession) close() {
+	s.dieOnce.Do(func() {
+		close(s.die)
+		s.conn.Close()
+	})
+}
+
+// open the stream by the SYN frame, the client streams are odd
+func (s *muxSession) open() (*muxStream, error) {
+	s.lock.Lock()
+	if s.isClosed() {
+		s.lock.Unlock()
+		return nil, errMuxClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := &muxStream{id: id, session: s, notify: make(chan struct{}, 1), die: make(chan struct{})}
+	s.streams[id] = stream
+	s.lock.Unlock()
+
+	if err := s.writeFrame(smuxCmdSYN, id, nil); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *muxSession) remove(id uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if stream, ok := s.streams[id]; ok {
+		s.release(stream.drop())
+		delete(s.streams, id)
+		if len(s.streams) == 0 {
+			s.idleFrom = time.Now()
+		}
+	}
+}
+
+// release return the bytes read by the streams to the bucket, the lock should be held
+func (s *muxSession) release(n int) {
+	s.buffered -= n
+	if s.buffered < smuxMaxBuffer {
+		select {
+		case s.bucket <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *muxSession) writeFrame(cmd byte, id uint32, payload []byte) error {
+	frame := make([]byte, smuxHeader, smuxHeader+len(payload))
+	frame[0], frame[1] = smuxVersion, cmd
+	binary.LittleEndian.PutUint16(frame[2:], uint16(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:], id)
+	frame = append(frame, payload...)
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.isClosed() {
+		return errMuxClosed
+	}
+	if _, err := s.conn.Write(frame); err != nil {
+		s.close()
+		return err
+	}
+	return nil
+}
+
+func (s *muxSession) recvLoop() {
+	defer func() {
+		s.close()
+		s.lock.Lock()
+		for _, stream := range s.streams {
+			stream.finish()
+		}
+		s.lock.Unlock()
+	}()
+
+	header := make([]byte, smuxHeader)
+	for {
+		for {
+			s.lock.Lock()
+			full := s.buffered >= smuxMaxBuffer
+			s.lock.Unlock()
+			if !full {
+				break
+			}
+			select {
+			case <-s.bucket:
+			case <-s.die:
+				return
+			}
+		}
+
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			return
+		}
+		if header[0] != smuxVersion {
+			log.Warning("mux: unsupported version %d", header[0])
+			return
+		}
+
+		id := binary.LittleEndian.Uint32(header[4:])
+		payload := make([]byte, binary.LittleEndian.Uint16(header[2:]))
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			return
+		}
+
+		s.lock.Lock()
+		stream := s.streams[id]
+		switch header[1] {
+		case smuxCmdPSH:
+			if stream != nil && len(payload) > 0 {
+				s.buffered += len(payload)
+				stream.push(payload)
+			}
+		case smuxCmdFIN:
+			if stream != nil {
+				stream.finish()
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+// keepalive send the NOP frames, close the session idle too long
+func (s *muxSession) keepalive() {
+	ticker := time.NewTicker(muxKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.die:
+			return
+		case <-ticker.C:
+		}
+
+		s.lock.Lock()
+		idle := len(s.streams) == 0 && time.Since(s.idleFrom) > muxIdleTimeout
+		s.lock.Unlock()
+		if idle {
+			s.close()
+			return
+		}
+		s.writeFrame(smuxCmdNOP, 0, nil)
+	}
+}
+
+// muxStream is the stream of the smux session, the data of the PSH frames are buffered until
+// read, the FIN frame is the end of the stream
+type muxStream struct {
+	id      uint32
+	session *muxSession
+
+	lock         sync.Mutex
+	buf          [][]byte
+	fin          bool
+	finSent      bool
+	readDeadline time.Time
+	notify       chan struct{}
+
+	die     chan struct{}
+	dieOnce sync.Once
+}
+
+// push the data received, the session lock is held
+func (m *muxStream) push(b []byte) {
+	m.lock.Lock()
+	m.buf = append(m.buf, b)
+	m.lock.Unlock()
+	m.wake()
+}
+
+// finish the stream received the FIN, the data buffered can still be read
+func (m *muxStream) finish() {
+	m.lock.Lock()
+	m.fin = true
+	m.lock.Unlock()
+	m.wake()
+}
+
+// drop the data buffered, return the bytes dropped, the session lock is held
+func (m *muxStream) drop() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	n := 0
+	for _, b := range m.buf {
+		n += len(b)
+	}
+	m.buf = nil
+	return n
+}
+
+func (m *muxStream) wake() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *muxStream) Read(b []byte) (int, error) {
+	for {
+		m.lock.Lock()
+		if len(m.buf) > 0 {
+			n := copy(b, m.buf[0])
+			if m.buf[0] = m.buf[0][n:]; len(m.buf[0]) == 0 {
+				m.buf = m.buf[1:]
+			}
+			m.lock.Unlock()
+
+			m.session.lock.Lock()
+			m.session.release(n)
+			m.session.lock.Unlock()
+			return n, nil
+		}
+		fin, deadline := m.fin, m.readDeadline
+		m.lock.Unlock()
+
+		if fin {
+			return 0, io.EOF
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, &net.OpError{Op: "read", Net: "mux", Err: errMuxTimeout{}}
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case <-m.notify:
+		case <-timeout:
+		case <-m.die:
+			return 0, io.ErrClosedPipe
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (m *muxStream) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		select {
+		case <-m.die:
+			return n, io.ErrClosedPipe
+		default:
+		}
+
+		size := len(b) - n
+		if size > smuxMaxFrame {
+			size = smuxMaxFrame
+		}
+		if err := m.session.writeFrame(smuxCmdPSH, m.id, b[n:n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// CloseWrite send the FIN, the data of the server can still be read
+func (m *muxStream) CloseWrite() error {
+	m.lock.Lock()
+	sent := m.finSent
+	m.finSent = true
+	m.lock.Unlock()
+	if sent {
+		return nil
+	}
+	return m.session.writeFrame(smuxCmdFIN, m.id, nil)
+}
+
+func (m *muxStream) CloseRead() error {
+	return nil
+}
+
+func (m *muxStream) Close() error {
+	err := m.CloseWrite()
+	m.dieOnce.Do(func() { close(m.die) })
+	m.session.remove(m.id)
+	return err
+}
+
+func (m *muxStream) LocalAddr() net.Addr  { return m.session.conn.LocalAddr() }
+func (m *muxStream) RemoteAddr() net.Addr { return m.session.conn.RemoteAddr() }
+
+func (m *muxStream) SetDeadline(t time.Time) error {
+	return m.SetReadDeadline(t)
+}
+
+func (m *muxStream) SetReadDeadline(t time.Time) error {
+	m.lock.Lock()
+	m.readDeadline = t
+	m.lock.Unlock()
+	m.wake()
+	return nil
+}
+
+// SetWriteDeadline is ignored, the tunnel is shared by the streams
+func (m *muxStream) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// errMuxTimeout is the timeout of the read deadline, implement net.Error
+type errMuxTimeout struct{}
+
+func (errMuxTimeout) Error() string   { return "mux: i/o timeout" }
+func (errMuxTimeout) Timeout() bool   { return true }
+func (errMuxTimeout) Temporary() bool { return true }
+
+// muxConn read the status of the stream response before the data of the target
+type muxConn struct {
+	*muxStream
+	received bool
+}
+
+func (c *muxConn) Read(b []byte) (int, error) {
+	if !c.received {
+		status := make([]byte, 1)
+		if _, err := io.ReadFull(c.muxStream, status); err != nil {
+			return 0, err
+		}
+		if status[0] != muxStatusSuccess {
+			// the error message in the uvarint length and the string
+			length, err := binary.ReadUvarint(byteReader{c.muxStream})
+			if err != nil || length > muxMaxMessage {
+				return 0, fmt.Errorf("mux: stream rejected")
+			}
+			message := make([]byte, length)
+			if _, err := io.ReadFull(c.muxStream, message); err != nil {
+				return 0, fmt.Errorf("mux: stream rejected")
+			}
+			return 0, fmt.Errorf("mux: stream rejected, %s", message)
+		}
+		c.received = true
+	}
+	return c.muxStream.Read(b)
+}
+
+// byteReader read the byte of the reader, implement io.ByteReader
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	b := make([]byte, 1)
+	_, err := io.ReadFull(r.Reader, b)
+	return b[0], err
+}
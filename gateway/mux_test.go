@@ -0,0 +1,297 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tunnelDialer connect the server whatever the addr, count the tunnels
+type tunnelDialer struct {
+	server  string
+	lock    sync.Mutex
+	tunnels int
+}
+
+func (d *tunnelDialer) Dial(network, addr string) (net.Conn, error) {
+	d.lock.Lock()
+	d.tunnels++
+	d.lock.Unlock()
+	return net.Dial(network, d.server)
+}
+
+// serveMux serve the sing-mux session of smux, accept the streams to example.com:80 and echo,
+// the streams are rejected with the response and closed if not nil
+func serveMux(t *testing.T, conn net.Conn, reject []byte) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	request := make([]byte, 2)
+	if _, err := io.ReadFull(r, request); err != nil || request[1] != muxProtocolSmux {
+		t.Error("unexpected session request", request, err)
+		return
+	}
+
+	var writeLock sync.Mutex
+	write := func(cmd byte, id uint32, payload []byte) {
+		frame := make([]byte, smuxHeader)
+		frame[0], frame[1] = smuxVersion, cmd
+		binary.LittleEndian.PutUint16(frame[2:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(frame[4:], id)
+		writeLock.Lock()
+		conn.Write(append(frame, payload...))
+		writeLock.Unlock()
+	}
+
+	accepted := make(map[uint32]bool)
+	header := make([]byte, smuxHeader)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		id := binary.LittleEndian.Uint32(header[4:])
+		payload := make([]byte, binary.LittleEndian.Uint16(header[2:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+
+		switch header[1] {
+		case smuxCmdPSH:
+			if !accepted[id] {
+				// the stream request in one frame, the flags and the address
+				dst, err := readSocks5Addr(bytes.NewReader(payload[2:]))
+				if err != nil || dst != "example.com:80" {
+					t.Error("unexpected stream request", dst, err)
+					return
+				}
+				if reject != nil {
+					write(smuxCmdPSH, id, reject)
+					write(smuxCmdFIN, id, nil)
+					continue
+				}
+				accepted[id] = true
+				write(smuxCmdPSH, id, []byte{muxStatusSuccess})
+				continue
+			}
+			write(smuxCmdPSH, id, payload)
+		case smuxCmdFIN:
+			write(smuxCmdFIN, id, nil)
+		}
+	}
+}
+
+func TestMuxClientDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveMux(t, conn, nil)
+		}
+	}()
+
+	u, _ := url.Parse("ss://aes-128-gcm:pass@127.0.0.1:8388?mux=2")
+	forward := &tunnelDialer{server: ln.Addr().String()}
+	dialer, err := withMux(u, forward)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := dialer.Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	if forward.tunnels != 2 {
+		t.Fatal("the streams should share the tunnels, got tunnels", forward.tunnels)
+	}
+
+	for i, conn := range conns {
+		message := []byte{'p', 'i', 'n', 'g', byte('0' + i)}
+		conn.Write(message)
+		buf := make([]byte, len(message))
+		if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, message) {
+			t.Fatal("should relay through the stream", string(buf), err)
+		}
+	}
+
+	// the server close the stream after the FIN
+	conns[0].(halfCloser).CloseWrite()
+	if _, err := conns[0].Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("the stream should be closed by the server", err)
+	}
+
+	conns[1].Close()
+	if conn, err := dialer.Dial("tcp", "example.com:80"); err != nil || forward.tunnels != 2 {
+		t.Fatal("the stream closed should be reused", forward.tunnels, err)
+	} else {
+		conn.Close()
+	}
+}
+
+func TestMuxConnRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		response []byte
+		err      string
+	}{
+		{"message", append([]byte{1, 5}, "oops!"...), "mux: stream rejected, oops!"},
+		// the length of 1<<62 should not be allocated
+		{"oversized", []byte{1, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40}, "mux: stream rejected"},
+		{"truncated", append([]byte{1, 100}, "oops"...), "mux: stream rejected"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go serveMux(t, server, test.response)
+
+			u, _ := url.Parse("ss://aes-128-gcm:pass@127.0.0.1:8388?mux=2")
+			dialer, err := withMux(u, &pipeDialer{conn: client})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := dialer.Dial("tcp", "example.com:80")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.Read(make([]byte, 1)); err == nil || err.Error() != test.err {
+				t.Fatal("unexpected error", err)
+			}
+		})
+	}
+}
+
+// pipeDialer return the conn once
+type pipeDialer struct {
+	conn net.Conn
+}
+
+func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+// slowDialer block the first dial until released, the others connect the mux server by pipes
+type slowDialer struct {
+	t       *testing.T
+	lock    sync.Mutex
+	dials   int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *slowDialer) Dial(network, addr string) (net.Conn, error) {
+	d.lock.Lock()
+	d.dials++
+	first := d.dials == 1
+	d.lock.Unlock()
+
+	if first {
+		close(d.started)
+		<-d.release
+	}
+
+	client, server := net.Pipe()
+	go serveMux(d.t, server, nil)
+	return client, nil
+}
+
+func TestMuxClientSlowDial(t *testing.T) {
+	u, _ := url.Parse("ss://aes-128-gcm:pass@127.0.0.1:8388?mux=2")
+	forward := &slowDialer{t: t, started: make(chan struct{}), release: make(chan struct{})}
+	dialer, err := withMux(u, forward)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := make(chan net.Conn)
+	go func() {
+		conn, err := dialer.Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Error(err)
+		}
+		slow <- conn
+	}()
+	<-forward.started
+
+	// the slow tunnel should not block the other dials
+	done := make(chan net.Conn)
+	go func() {
+		conn, err := dialer.Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- conn
+	}()
+
+	var conns []net.Conn
+	select {
+	case conn := <-done:
+		conns = append(conns, conn)
+	case <-time.After(time.Second):
+		t.Fatal("the dial should not wait for the slow tunnel")
+	}
+
+	// the session of the slow tunnel is closed, the stream joins the installed session
+	close(forward.release)
+	conns = append(conns, <-slow)
+	for _, conn := range conns {
+		if conn != nil {
+			defer conn.Close()
+		}
+	}
+
+	c := dialer.(*muxClient)
+	c.lock.Lock()
+	sessions := len(c.sessions)
+	c.lock.Unlock()
+	if sessions != 1 || c.sessions[0].numStreams() != 2 {
+		t.Fatal("the extra session should be closed, got sessions", sessions)
+	}
+}
+
+func TestWithMux(t *testing.T) {
+	u, _ := url.Parse("trojan://password@127.0.0.1:443")
+	dialer, err := newDialer(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dialer.(*trojanClient); !ok {
+		t.Fatal("mux should be disabled without the option")
+	}
+
+	u, _ = url.Parse("trojan://password@127.0.0.1:443?mux=8")
+	if dialer, err = newDialer(u, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dialer.(udpDialer); !ok {
+		t.Fatal("the udp should be relayed without mux")
+	}
+
+	for _, proxyURL := range []string{"trojan://password@127.0.0.1:443?mux=0", "socks5://127.0.0.1:1080?mux=8"} {
+		u, _ = url.Parse(proxyURL)
+		if _, err := newDialer(u, nil); err == nil {
+			t.Fatal("the mux option should be invalid", proxyURL)
+		}
+	}
+}
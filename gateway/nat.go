@@ -3,6 +3,7 @@ package gateway
 import (
 	"fmt"
 	"github.com/yinheli/kungfu/internal"
+	"hash/fnv"
 	"net"
 	"sync"
 	"time"
@@ -136,5 +137,10 @@ func (s *natSession) String() string {
 }
 
 func addrToInt(ip net.IP, port uint16) uint64 {
+	if ip.To4() == nil {
+		h := fnv.New64a()
+		h.Write(ip.To16())
+		return h.Sum64() + uint64(port)
+	}
 	return uint64(internal.Ipv4ToInt(ip)) + uint64(port)
 }
@@ -59,7 +59,9 @@ func BenchmarkNat(b *testing.B) {
 
 	n := newNat()
 
-	minIp, maxIp, _ := internal.ParseNetwork("10.0.0.1/16")
+	network, _ := internal.ParseNetwork("10.0.0.1/16")
+	minIp := internal.Ipv4ToInt(network.First)
+	maxIp := minIp + uint32(network.Size)
 
 	var ipIdx uint32
 
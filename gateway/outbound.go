@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// udpDialer is the proxy can relay udp, the packets written to the conn are sent to the addr
+type udpDialer interface {
+	DialUDP(addr string) (net.Conn, error)
+}
+
+// newDialer return the dialer of the proxy url, the proxy server is connected through the
+// forward dialer, directly if nil
+func newDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	switch u.Scheme {
+	case "ss":
+		c, err := newShadowsocksClient(u)
+		if err != nil {
+			return nil, err
+		}
+		c.forward = forward
+		return withMux(u, c)
+	case "trojan":
+		c, err := newTrojanClient(u)
+		if err != nil {
+			return nil, err
+		}
+		c.forward = forward
+		return withMux(u, c)
+	case "vmess":
+		c, err := newVmessClient(u)
+		if err != nil {
+			return nil, err
+		}
+		c.forward = forward
+		return withMux(u, c)
+	case "vless":
+		c, err := newVlessClient(u)
+		if err != nil {
+			return nil, err
+		}
+		c.forward = forward
+		return withMux(u, c)
+	}
+
+	if u.Query().Get("mux") != "" {
+		return nil, fmt.Errorf("mux: unsupported proxy %s", u.Scheme)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		c := newSocks5Client(u)
+		c.forward = forward
+		return c, nil
+	case "http", "https":
+		c := newHTTPConnectClient(u)
+		c.forward = forward
+		return c, nil
+	case "wireguard", "wg":
+		// the endpoint is udp, can't be connected through the proxy of the outbound relayed via
+		if forward != nil {
+			return nil, errors.New("wireguard: can't be relayed via other outbound")
+		}
+		return newWireguardClient(u)
+	}
+
+	if forward == nil {
+		forward = proxy.Direct
+	}
+	return proxy.FromURL(u, forward)
+}
+
+// dialServer connect the proxy server through the forward dialer, the proxy of the outbound
+// relayed via, directly with the timeout and the socket options if nil
+func dialServer(forward proxy.Dialer, server string, timeout time.Duration) (net.Conn, error) {
+	if forward == nil {
+		return dialTCP(server, timeout)
+	}
+	return forward.Dial("tcp", server)
+}
+
+// proxyTarget return the host:port of the domain mapped to the fake ip sent to the proxy, the
+// proxy server resolve the domain itself, the fake ip or any ip is never sent as the target
+func proxyTarget(host string, port uint16) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return "", fmt.Errorf("invalid domain %q mapped to the fake ip", host)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
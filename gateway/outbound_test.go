@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"testing"
+)
+
+// recordDialer dial directly and record the addresses, the jump host of the tests
+type recordDialer struct {
+	addrs []string
+}
+
+func (d *recordDialer) Dial(network, addr string) (net.Conn, error) {
+	d.addrs = append(d.addrs, addr)
+	return net.Dial(network, addr)
+}
+
+func TestProxyTarget(t *testing.T) {
+	target, err := proxyTarget("www.google.com.", 443)
+	if err != nil || target != "www.google.com:443" {
+		t.Fatal("the domain should be the target", target, err)
+	}
+
+	for _, host := range []string{"", "198.18.0.10", "fd00::10"} {
+		if _, err := proxyTarget(host, 443); err == nil {
+			t.Fatal("the ip should not be the target", host)
+		}
+	}
+}
+
+func TestNewDialerForward(t *testing.T) {
+	ln := fakeHTTPProxy(t, "user", "pass")
+	defer ln.Close()
+
+	forward := &recordDialer{}
+	u, _ := url.Parse("http://user:pass@" + ln.Addr().String())
+	dialer, err := newDialer(u, forward)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := dialer.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if len(forward.addrs) != 1 || forward.addrs[0] != ln.Addr().String() {
+		t.Fatal("the proxy server should be connected through the forward dialer", forward.addrs)
+	}
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatal("should tunnel through the chain", err)
+	}
+}
@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// raceTimeout is the max time waiting the first response of the routes
+const raceTimeout = time.Duration(time.Second * 5)
+
+var errRaceFail = errors.New("both the direct route and the proxy fail")
+
+type raceResult struct {
+	conn     net.Conn
+	outbound string
+	err      error
+}
+
+// raceDirect return true if the connections of the host race the direct route and the proxy,
+// the learned hosts are proxied without racing
+func (g *Gateway) raceDirect(host string, dstIp net.IP) bool {
+	// the real ip is resolved for ipv4 only
+	if g.raceRules == nil || dstIp.To4() == nil || !g.raceRules.Match(host) {
+		return false
+	}
+	return g.learner == nil || !g.learner.learned(host)
+}
+
+// dialRace send the first bytes of the client through both the direct route and the outbound,
+// the route responds first (the server hello of tls) is kept and the other is closed, fallback
+// to the outbound if the client doesn't send first
+func (g *Gateway) dialRace(client net.Conn, ob *outbound, host string, dstIp net.IP, port uint16, target string) (net.Conn, string, error) {
+	first := make([]byte, mtu)
+	client.SetReadDeadline(time.Now().Add(sniffTimeout))
+	n, err := client.Read(first)
+	client.SetReadDeadline(time.Time{})
+	if n == 0 {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			tunnel, err := ob.Dial("tcp", target)
+			return tunnel, ob.name, err
+		}
+		return nil, "", err
+	}
+	first = first[:n]
+
+	results := make(chan raceResult, 2)
+	go func() {
+		realIp, err := g.getRealIp(dstIp.String())
+		if err != nil {
+			results <- raceResult{outbound: outboundDirect, err: err}
+			return
+		}
+		conn, err := dialTCP(net.JoinHostPort(realIp, strconv.Itoa(int(port))), raceTimeout)
+		r := raceFirst(conn, err, first, outboundDirect)
+		if g.learner != nil {
+			g.learner.observe(host, r.err)
+		}
+		results <- r
+	}()
+	go func() {
+		conn, err := ob.Dial("tcp", target)
+		results <- raceFirst(conn, err, first, ob.name)
+	}()
+
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err != nil {
+			log.Debug("race %s by %s fail, %v", target, r.outbound, r.err)
+			continue
+		}
+
+		if i == 0 {
+			// close the loser in background
+			go func() {
+				if r := <-results; r.err == nil {
+					r.conn.Close()
+				}
+			}()
+		}
+		log.Debug("race %s won by %s", target, r.outbound)
+		return r.conn, r.outbound, nil
+	}
+
+	return nil, "", errRaceFail
+}
+
+// raceFirst send the first bytes of the client and wait the first response, the response is
+// relayed first by the returned conn
+func raceFirst(conn net.Conn, err error, first []byte, outbound string) raceResult {
+	if err != nil {
+		return raceResult{outbound: outbound, err: err}
+	}
+
+	conn.SetDeadline(time.Now().Add(raceTimeout))
+	if _, err = conn.Write(first); err == nil {
+		resp := make([]byte, mtu)
+		var n int
+		if n, err = conn.Read(resp); n > 0 {
+			conn.SetDeadline(time.Time{})
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(resp[:n]), conn))
+			return raceResult{conn: &bufferedConn{Conn: conn, r: r}, outbound: outbound}
+		}
+	}
+
+	conn.Close()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return raceResult{outbound: outbound, err: err}
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestDialRace(t *testing.T) {
+	// the direct server reply the hello
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		conn.Write([]byte("world"))
+	}()
+
+	g := &Gateway{Store: internal.NewMemoryStore(&internal.Memory{})}
+	g.Store.Set(internal.GetRedisRealIpKey("10.85.0.2"), "127.0.0.1", time.Minute)
+
+	// the proxy is unreachable
+	ob, err := newOutbound(defaultOutbound, "socks5://127.0.0.1:1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, peer := net.Pipe()
+	defer client.Close()
+	go peer.Write([]byte("hello"))
+
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	tunnel, outbound, err := g.dialRace(client, ob, "example.com", net.ParseIP("10.85.0.2"), port, "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	if outbound != outboundDirect {
+		t.Fatal("direct route should win, got", outbound)
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(tunnel, buf); err != nil || string(buf) != "world" {
+		t.Fatal("the first response should be relayed", string(buf), err)
+	}
+}
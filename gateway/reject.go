@@ -0,0 +1,18 @@
+package gateway
+
+import (
+	"net"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// rejected return true if the fake ip is answered to the domain of the reset mode reject list
+func (g *Gateway) rejected(ip net.IP) bool {
+	name, err := g.Store.Get(internal.GetRedisRejectedKey(ip.String()))
+	if err != nil {
+		return false
+	}
+
+	rejectedTotal.Inc(name)
+	return true
+}
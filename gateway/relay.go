@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"io"
+	"net"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// relayBufferSize is the buffer of copying the conns not spliced
+const relayBufferSize = 32 * 1024
+
+var (
+	relayBuffers = internal.NewBufferPool("relay", relayBufferSize)
+	// packetBuffers is the buffers of the udp packets read from the tunnels
+	packetBuffers = internal.NewBufferPool("packet", mtu)
+)
+
+// relayWrapper is the conn wrapping the relayed conn, e.g. countingConn and limitedConn, the
+// bytes spliced without calling Read and Write are accounted by it
+type relayWrapper interface {
+	unwrap() net.Conn
+	// account the bytes read from the conn if read, written to it if not
+	account(n int, read bool)
+}
+
+// relay copy src to dst, spliced in the kernel if both are the tcp conns on linux, otherwise
+// copied with the pooled buffer
+func relay(dst net.Conn, src net.Conn) (int64, error) {
+	if n, spliced, err := spliceRelay(dst, src); spliced {
+		return n, err
+	}
+
+	buf := relayBuffers.Get()
+	defer relayBuffers.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// unwrapTCP return the tcp conn of the conn and the wrappers, nil if not a tcp conn
+func unwrapTCP(c net.Conn) (*net.TCPConn, []relayWrapper) {
+	var wrappers []relayWrapper
+	for {
+		switch conn := c.(type) {
+		case *net.TCPConn:
+			return conn, wrappers
+		case relayWrapper:
+			wrappers = append(wrappers, conn)
+			c = conn.unwrap()
+		default:
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"io"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceChunk is the max bytes of a splice, the default capacity of the pipe
+const spliceChunk = 64 * 1024
+
+// spliceRelay splice src to dst through the pipe without copying to the user space, the
+// wrappers account each chunk, not spliced if any is not the tcp conn or the pipe fail
+func spliceRelay(dst net.Conn, src net.Conn) (int64, bool, error) {
+	srcTCP, srcWrappers := unwrapTCP(src)
+	dstTCP, dstWrappers := unwrapTCP(dst)
+	if srcTCP == nil || dstTCP == nil {
+		return 0, false, nil
+	}
+	if len(srcWrappers) == 0 && len(dstWrappers) == 0 {
+		// the tcp conns are spliced by go
+		n, err := dstTCP.ReadFrom(srcTCP)
+		return n, true, err
+	}
+
+	rc, err := srcTCP.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	wc, err := dstTCP.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+
+	p := make([]int, 2)
+	if err := unix.Pipe2(p, unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return 0, false, nil
+	}
+	defer unix.Close(p[0])
+	defer unix.Close(p[1])
+
+	var written int64
+	for {
+		var n int
+		var serr error
+		if err := rc.Read(func(fd uintptr) bool {
+			// the result is int or int64 by the arch
+			m, e := unix.Splice(int(fd), nil, p[1], nil, spliceChunk, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+			n, serr = int(m), e
+			return serr != unix.EAGAIN
+		}); err != nil {
+			return written, true, err
+		}
+		if serr != nil {
+			return written, true, serr
+		}
+		if n == 0 {
+			return written, true, nil
+		}
+		for _, w := range srcWrappers {
+			w.account(n, true)
+		}
+
+		for pending := n; pending > 0; {
+			var m int
+			var werr error
+			if err := wc.Write(func(fd uintptr) bool {
+				k, e := unix.Splice(p[0], nil, int(fd), nil, pending, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+				m, werr = int(k), e
+				return werr != unix.EAGAIN
+			}); err != nil {
+				return written, true, err
+			}
+			if werr != nil {
+				return written, true, werr
+			}
+			if m == 0 {
+				return written, true, io.ErrShortWrite
+			}
+			pending -= m
+			written += int64(m)
+			for _, w := range dstWrappers {
+				w.account(m, false)
+			}
+		}
+	}
+}
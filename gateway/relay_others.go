@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package gateway
+
+import "net"
+
+// spliceRelay is only supported on linux
+func spliceRelay(dst net.Conn, src net.Conn) (int64, bool, error) {
+	return 0, false, nil
+}
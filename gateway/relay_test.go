@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// tcpPair return the connected tcp conns
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialed.(*net.TCPConn), accepted.(*net.TCPConn)
+}
+
+func TestRelay(t *testing.T) {
+	payload := make([]byte, 512*1024)
+	rand.Read(payload)
+
+	client, clientPeer := tcpPair(t)
+	defer client.Close()
+	defer clientPeer.Close()
+	tunnel, tunnelPeer := tcpPair(t)
+	defer tunnel.Close()
+	defer tunnelPeer.Close()
+
+	go func() {
+		client.Write(payload)
+		client.CloseWrite()
+	}()
+
+	received := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(tunnelPeer)
+		received <- b
+	}()
+
+	tc := &trackedConn{}
+	n, err := relay(&countingConn{Conn: tunnel, c: tc}, clientPeer)
+	tunnel.CloseWrite()
+	if err != nil || n != int64(len(payload)) || tc.upload != n {
+		t.Fatal("unexpected relayed bytes", n, tc.upload, err)
+	}
+	if !bytes.Equal(<-received, payload) {
+		t.Fatal("the payload should be relayed")
+	}
+
+	// the conns not tcp are copied
+	src, srcPeer := net.Pipe()
+	dst, dstPeer := net.Pipe()
+	go func() {
+		srcPeer.Write(payload[:1024])
+		srcPeer.Close()
+	}()
+	go func() {
+		b, _ := ioutil.ReadAll(dstPeer)
+		received <- b
+	}()
+
+	if n, err := relay(dst, src); err != nil || n != 1024 {
+		t.Fatal("unexpected copied bytes", n, err)
+	}
+	dst.Close()
+	if !bytes.Equal(<-received, payload[:1024]) {
+		t.Fatal("the payload should be copied")
+	}
+}
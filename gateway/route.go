@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yinheli/kungfu/gfwlist"
+	"github.com/yinheli/kungfu/internal"
+	"golang.org/x/net/proxy"
+)
+
+// defaultOutbound is the name of the outbound of kungfu:proxy
+const defaultOutbound = "default"
+
+// outboundDirect is the outbound name of the udp relayed to the real ip directly
+const outboundDirect = "direct"
+
+// outbound is the proxy the connections relayed through, one of the upstream proxies is
+// picked by the strategy for each connection
+type outbound struct {
+	name      string
+	strategy  string
+	upstreams []*upstream
+	// next is the index of round-robin
+	next uint32
+	// rules is the domains relayed through the outbound, nil for the default outbound
+	rules *gfwlist.Matcher
+	// via is the name of the outbound the proxy servers are connected through, empty if directly
+	via string
+}
+
+// newOutbound create the outbound of the proxy urls separated by comma, the proxy servers are
+// connected through the via dialer, directly if nil
+func newOutbound(name string, proxyURLs string, strategy string, via proxy.Dialer) (*outbound, error) {
+	switch strategy {
+	case "":
+		strategy = strategyFailover
+	case strategyFailover, strategyRoundRobin, strategyLowestLatency:
+	default:
+		return nil, fmt.Errorf("unsupported strategy %s", strategy)
+	}
+
+	o := &outbound{name: name, strategy: strategy}
+	for _, proxyURL := range strings.Split(proxyURLs, ",") {
+		if proxyURL = strings.TrimSpace(proxyURL); proxyURL == "" {
+			continue
+		}
+
+		p, err := newUpstream(proxyURL, via)
+		if err != nil {
+			return nil, err
+		}
+		o.upstreams = append(o.upstreams, p)
+	}
+
+	if len(o.upstreams) == 0 {
+		return nil, errors.New("proxy not configured")
+	}
+	return o, nil
+}
+
+// loadOutbounds load the named outbounds, the domains matched the rules in
+// kungfu:outbound-rule:<name> are relayed through the proxy of kungfu:outbound:<name>,
+// the outbounds are matched in the order of name, the proxy servers of the outbound are
+// connected through the outbound of kungfu:outbound-via:<name> if set
+func (g *Gateway) loadOutbounds() ([]*outbound, error) {
+	keys, err := g.Store.Keys(internal.GetRedisOutboundKey("*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	prefix := internal.GetRedisOutboundKey("")
+	vias := make(map[string]string, len(keys))
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		via, err := g.Store.Get(internal.GetRedisOutboundViaKey(name))
+		if err != nil && err != internal.ErrNil {
+			return nil, fmt.Errorf("get outbound %s via error, %v", name, err)
+		}
+		vias[name] = via
+	}
+
+	loaded := make(map[string]*outbound, len(keys))
+	outbounds := make([]*outbound, 0, len(keys))
+	for _, key := range keys {
+		o, err := g.loadOutbound(strings.TrimPrefix(key, prefix), vias, loaded)
+		if err != nil {
+			return nil, err
+		}
+		outbounds = append(outbounds, o)
+	}
+
+	return outbounds, nil
+}
+
+// loadOutbound load the named outbound, the outbound relayed via is loaded first, the loaded
+// outbounds are kept by the name
+func (g *Gateway) loadOutbound(name string, vias map[string]string, loaded map[string]*outbound) (*outbound, error) {
+	if o, ok := loaded[name]; ok {
+		return o, nil
+	}
+
+	chain, ok := internal.ViaChain(name, vias)
+	if !ok {
+		return nil, fmt.Errorf("outbound %s via cycle %s", name, strings.Join(chain, " -> "))
+	}
+
+	var forward proxy.Dialer
+	switch via := vias[name]; via {
+	case "":
+	case defaultOutbound:
+		if g.outbound == nil {
+			return nil, fmt.Errorf("outbound %s via %s, proxy not configured", name, via)
+		}
+		forward = g.outbound
+	default:
+		if _, ok := vias[via]; !ok {
+			return nil, fmt.Errorf("outbound %s via unknown outbound %s", name, via)
+		}
+		o, err := g.loadOutbound(via, vias, loaded)
+		if err != nil {
+			return nil, err
+		}
+		forward = o
+	}
+
+	proxyURL, err := g.Store.Get(internal.GetRedisOutboundKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("get outbound %s error, %v", name, err)
+	}
+
+	strategy, err := g.Store.Get(internal.GetRedisOutboundStrategyKey(name))
+	if err != nil && err != internal.ErrNil {
+		return nil, fmt.Errorf("get outbound %s strategy error, %v", name, err)
+	}
+
+	o, err := newOutbound(name, proxyURL, strategy, forward)
+	if err != nil {
+		return nil, fmt.Errorf("outbound %s, %v", name, err)
+	}
+	o.via = vias[name]
+
+	rules, err := g.Store.SMembers(internal.GetRedisOutboundRuleKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("get outbound %s rules error, %v", name, err)
+	}
+
+	o.rules, err = gfwlist.NewMatcher(rules)
+	if err != nil {
+		return nil, fmt.Errorf("outbound %s rules, %v", name, err)
+	}
+
+	log.Info("outbound %s: %d proxies, strategy: %s, rules: %d, via: %s",
+		name, len(o.upstreams), o.strategy, o.rules.Len(), viaName(o.via))
+	loaded[name] = o
+	return o, nil
+}
+
+// viaName return the name of the outbound relayed via for the logs, direct if empty
+func viaName(via string) string {
+	if via == "" {
+		return outboundDirect
+	}
+	return via
+}
+
+// route return the first outbound the host matched, the default outbound if none matched
+func (g *Gateway) route(host string) *outbound {
+	for _, o := range g.outbounds {
+		if o.rules.Match(host) {
+			return o
+		}
+	}
+	return g.outbound
+}
@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestRoute(t *testing.T) {
+	g := &Gateway{Store: internal.NewMemoryStore(&internal.Memory{
+		Keys: map[string]string{
+			"outbound:a-stream": "socks5://127.0.0.1:1080",
+			"outbound:b-work":   "http://127.0.0.1:3128",
+		},
+		Sets: map[string][]string{
+			"outbound-rule:a-stream": {"netflix.com", "keyword:nflx"},
+			"outbound-rule:b-work":   {"example.com", "netflix.com"},
+		},
+	})}
+
+	var err error
+	g.outbound, err = newOutbound(defaultOutbound, "socks5://127.0.0.1:1988", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.outbounds, err = g.loadOutbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for host, name := range map[string]string{
+		"www.netflix.com":  "a-stream",
+		"nflxvideo.net":    "a-stream",
+		"mail.example.com": "b-work",
+		"www.google.com":   defaultOutbound,
+		"example.com.cn":   defaultOutbound,
+		"netflix.com":      "a-stream",
+		"www.example.com.": "b-work",
+		"notnetflix.com":   defaultOutbound,
+		"www.nflx.example": "a-stream",
+	} {
+		if o := g.route(host); o.name != name {
+			t.Fatal("route", host, "should be", name, "got", o.name)
+		}
+	}
+
+	if !g.outbounds[0].supportUDP() || g.outbounds[1].supportUDP() {
+		t.Fatal("only socks5 outbound should relay udp")
+	}
+}
+
+func TestLoadOutboundsVia(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{
+		Keys: map[string]string{
+			"outbound:jump":      "socks5://127.0.0.1:1080",
+			"outbound:video":     "ss://aes-128-gcm:pass@127.0.0.1:8388",
+			"outbound-via:video": "jump",
+			"outbound:work":      "socks5://127.0.0.1:1081",
+			"outbound-via:work":  defaultOutbound,
+		},
+	})
+	g := &Gateway{Store: store}
+
+	var err error
+	g.outbound, err = newOutbound(defaultOutbound, "socks5://127.0.0.1:1988", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.outbounds, err = g.loadOutbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jump, video, work := g.outbounds[0], g.outbounds[1], g.outbounds[2]
+	if jump.upstreams[0].forward != nil || video.upstreams[0].forward != jump || work.upstreams[0].forward != g.outbound {
+		t.Fatal("the proxy servers should be connected through the outbound of via")
+	}
+	if !jump.supportUDP() || video.supportUDP() || work.supportUDP() {
+		t.Fatal("udp should not be relayed through the chain")
+	}
+
+	store.Set(internal.GetRedisOutboundViaKey("jump"), "video", 0)
+	if _, err := g.loadOutbounds(); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatal("outbound via cycle should fail", err)
+	}
+
+	store.Set(internal.GetRedisOutboundViaKey("jump"), "unknown", 0)
+	if _, err := g.loadOutbounds(); err == nil {
+		t.Fatal("outbound via unknown outbound should fail")
+	}
+}
@@ -1,19 +1,21 @@
 package gateway
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"github.com/go-redis/redis"
 	"github.com/miekg/dns"
-	"github.com/op/go-logging"
-	"github.com/songgao/water"
 	"github.com/yinheli/kungfu"
+	"github.com/yinheli/kungfu/geoip"
+	"github.com/yinheli/kungfu/gfwlist"
 	"github.com/yinheli/kungfu/internal"
-	"golang.org/x/net/proxy"
-	"io"
+	"github.com/yinheli/kungfu/metrics"
+	"github.com/yinheli/kungfu/netfilter"
+	"github.com/yinheli/kungfu/tracing"
+	"google.golang.org/grpc"
 	"net"
-	"net/url"
+	"net/http"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,31 +23,90 @@ import (
 )
 
 const (
-	tunName = "tun-kungfu-01"
-	mtu     = 1500
+	mtu = 1500
 )
 
 var (
-	log = kungfu.GetLog()
+	log = kungfu.GetModuleLog("gateway")
 
 	realIpQueryLock sync.Mutex
 )
 
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
 // Gateway is the gateway server
 type Gateway struct {
-	RedisClient *redis.Client
-
-	network        string
-	proxy          *url.URL
-	dialer         proxy.Dialer
-	relayIp        net.IP
-	relayPort      uint16
-	nat            *nat
-	ifce           *water.Interface
-	relayTCPServer *net.TCPListener
-	relayUDPServer *net.UDPConn
-	udpTunnelLock  sync.Mutex
-	udpTunnels     map[string]*net.UDPConn
+	Store  internal.Store
+	Config *internal.Config
+	// Firewall install the iptables rules of the fake ip network, removed on shutdown
+	Firewall bool
+	// GeoIP is the country database of the geoip rules, nil if not configured
+	GeoIP *geoip.Updater
+	// ChnRoutes is the china ip list relayed directly, nil if not configured
+	ChnRoutes *geoip.RoutesLoader
+	// ReloadConfig reload config.yml for the control api, nil if started without the file
+	ReloadConfig func() error
+
+	network  string
+	network6 string
+	// tproxy intercept by TPROXY instead of the tun, the relay servers listen on all the
+	// addresses and the original destination is kept by the sockets
+	tproxy bool
+	// outbound is the default proxy
+	outbound *outbound
+	// outbounds is the named proxies selected by the domain rules
+	outbounds []*outbound
+	// udpProxy relay udp through the proxy if the proxy support udp
+	udpProxy        bool
+	relayIp         net.IP
+	relayIp6        net.IP
+	relayPort       uint16
+	nat             *nat
+	ifce            tunDevice
+	relayTCPServer  *net.TCPListener
+	relayTCP6Server *net.TCPListener
+	relayUDPServer  *net.UDPConn
+	relayUDP6Server *net.UDPConn
+	// tunRoutes is the commands removing the routes added to the tun, run on reconfig and shutdown
+	tunRoutes [][]string
+	// restoreDNS restore the system dns on shutdown, nil if not set
+	restoreDNS    func()
+	udpTunnelLock sync.Mutex
+	udpTunnels    map[string]net.Conn
+	// conns is the active relayed connections, listed and killed by the admin api
+	conns *connTable
+	// traffic is the bytes relayed per domain and client, persisted periodically
+	traffic *trafficStats
+	// hourly aggregate the bytes relayed per domain per hour for the reports
+	hourly *internal.HourlyStats
+	// pusher push the metrics to InfluxDB or VictoriaMetrics, nil if disabled
+	pusher *metrics.Pusher
+	// tracer export the spans of the relayed connections, nil if disabled
+	tracer *tracing.Tracer
+	// bandwidth limit the relayed bytes of the clients and outbounds
+	bandwidth *bandwidth
+	// raceRules is the domains racing the direct route and the proxy
+	raceRules *gfwlist.Matcher
+	// geoipRules relay the connections of the countries directly
+	geoipRules *geoip.Rules
+	// learner proxy the racing domains whose direct route repeatedly fails
+	learner *learner
+	// admin is the admin http server, nil if not configured
+	admin *http.Server
+	// control is the gRPC control api server, nil if not configured
+	control *grpc.Server
+	// toucher refresh the mapping of the fake ip in use
+	toucher *internal.Toucher
+
+	// relays is the tcp relay connections, waited on shutdown
+	relays sync.WaitGroup
+	sub    internal.Subscription
+	// done is closed on shutdown, closed after shutdown finished
+	done   chan struct{}
+	closed chan struct{}
 }
 
 // Serve the gateway
@@ -57,48 +118,148 @@ func (g *Gateway) Serve() {
 	}
 
 	g.nat = newNat()
-	g.udpTunnels = make(map[string]*net.UDPConn)
-
-	g.tunUp()
+	g.udpTunnels = make(map[string]net.Conn)
+	g.traffic = newTrafficStats()
+	var retention time.Duration
+	if g.Config != nil {
+		retention = g.Config.Stats.Retention
+	}
+	g.hourly = internal.NewHourlyStats(retention)
+	g.conns = newConnTable(g.traffic)
+	g.conns.events = internal.NewEventHub()
+	g.done = make(chan struct{})
+	g.closed = make(chan struct{})
+
+	if !g.tproxy {
+		g.tunUp()
+	}
+	g.setupFirewall()
+	g.setSystemDNS()
 	go g.relayTCPServe()
+	go g.relayTCP6Serve()
 	go g.relayUDPServe()
-	go g.handleRequest()
+	go g.relayUDP6Serve()
+	if !g.tproxy {
+		go g.handleRequest()
+	}
+	go g.healthCheck()
+	go g.accountTraffic()
+	g.serveAdmin()
+	g.serveControl()
+
+	if g.Config != nil {
+		admin := g.Config.Admin
+		metrics.ServeProtected(g.Config.Metrics.Gateway, admin.Protect, admin.Cert, admin.Key)
+		if g.pusher = g.Config.Metrics.NewPusher("gateway"); g.pusher != nil {
+			g.pusher.Start()
+		}
+		if g.tracer = g.Config.Tracing.NewTracer("gateway"); g.tracer != nil {
+			g.tracer.Start()
+		}
+	}
 
+	internal.SdReady("relaying "+g.network, g.alive)
 	g.subscribe()
+
+	// wait the shutdown finished
+	<-g.closed
 }
 
 func (g *Gateway) loadConfig() (err error) {
-	network, err := g.RedisClient.Get(internal.GetRedisNetworkKey()).Result()
+	network, err := g.Store.Get(internal.GetRedisNetworkKey())
 	if err != nil {
 		log.Error("get network config error, %v", err)
 		return
 	}
 
-	_, _, err = internal.ParseNetwork(network)
+	n, err := internal.ParseNetwork(network)
 	if err != nil {
 		log.Error("parse network error %v", err)
 		return
 	}
 
-	proxyStr, err := g.RedisClient.Get(internal.GetRedisProxyKey()).Result()
+	proxyStr, err := g.Store.Get(internal.GetRedisProxyKey())
 	if err != nil {
 		log.Error("get proxy config error, %v", err)
 		return
 	}
 
-	g.proxy, err = url.Parse(proxyStr)
-	if err != nil {
-		log.Error("parse proxy config error, %v", err)
+	strategy, err := g.Store.Get(internal.GetRedisProxyStrategyKey())
+	if err != nil && err != internal.ErrNil {
+		log.Error("get proxy-strategy config error, %v", err)
 		return
 	}
 
-	g.dialer, err = proxy.FromURL(g.proxy, proxy.Direct)
+	if g.Config != nil {
+		setSocketOptions(g.Config.Gateway.Socket)
+	}
+
+	g.outbound, err = newOutbound(defaultOutbound, proxyStr, strategy, nil)
 	if err != nil {
 		log.Error("get proxy dialer error, %v", err)
 		return
 	}
 
-	relayPortStr, err := g.RedisClient.Get(internal.GetRedisRelayPortKey()).Result()
+	g.outbounds, err = g.loadOutbounds()
+	if err != nil {
+		log.Error("load outbounds error, %v", err)
+		return
+	}
+	// the wireguard tunnels of the proxies removed are closed
+	releaseWireguard(append([]*outbound{g.outbound}, g.outbounds...))
+
+	raceRules, err := g.Store.SMembers(internal.GetRedisDirectRaceKey())
+	if err != nil {
+		log.Error("get direct-race config error, %v", err)
+		return
+	}
+
+	g.raceRules, err = gfwlist.NewMatcher(raceRules)
+	if err != nil {
+		log.Error("invalid direct-race rules, %v", err)
+		return
+	}
+
+	geoipRules, err := g.Store.SMembers(internal.GetRedisGeoIPRuleKey())
+	if err != nil {
+		log.Error("get geoip-rule config error, %v", err)
+		return
+	}
+
+	g.geoipRules, err = geoip.ParseRules(geoipRules)
+	if err != nil {
+		log.Error("invalid geoip rules, %v", err)
+		return
+	}
+
+	if g.learner == nil {
+		g.learner = newLearner(g.Store)
+	}
+	g.learner.load()
+
+	if g.bandwidth == nil {
+		g.bandwidth = newBandwidth()
+	}
+	// the current limits are kept if invalid
+	if e := g.bandwidth.load(g.Store); e != nil {
+		log.Error("load bandwidth limits error, %v", e)
+	}
+
+	udpProxy, err := g.Store.Get(internal.GetRedisProxyUDPKey())
+	if err != nil && err != internal.ErrNil {
+		log.Error("get proxy-udp config error, %v", err)
+		return
+	}
+	g.udpProxy = udpProxy == "true"
+	if g.udpProxy {
+		for _, o := range append([]*outbound{g.outbound}, g.outbounds...) {
+			if !o.supportUDP() {
+				log.Warning("outbound %s doesn't support udp, relay udp directly", o.name)
+			}
+		}
+	}
+
+	relayPortStr, err := g.Store.Get(internal.GetRedisRelayPortKey())
 	if err != nil {
 		log.Error("get relay-port config error, %v", err)
 		return
@@ -110,25 +271,44 @@ func (g *Gateway) loadConfig() (err error) {
 		return
 	}
 
-	relayIp, _, _ := net.ParseCIDR(network)
+	relayIp := n.RelayIp
+
+	network6, err := g.Store.Get(internal.GetRedisNetwork6Key())
+	if err != nil && err != internal.ErrNil {
+		log.Error("get ipv6 network config error, %v", err)
+		return
+	}
 
-	g.network = network
+	var relayIp6 net.IP
+	if network6 != "" {
+		relayIp6, _, _, err = internal.ParseNetwork6(network6)
+		if err != nil {
+			log.Error("parse ipv6 network error %v", err)
+			return
+		}
+	}
+
+	// the relay ip with prefix, assigned to the tun
+	g.network = n.RelayCIDR()
+	g.network6 = network6
 	g.relayIp = relayIp
+	g.relayIp6 = relayIp6
 	g.relayPort = uint16(relayPort)
+	g.tproxy = g.Config != nil && g.Config.Gateway.Mode == internal.GatewayModeTProxy
+
+	if g.toucher == nil {
+		g.toucher = internal.NewToucher(g.Store)
+	}
+	g.toucher.Load()
 
-	log.Debug("network: %s, relayIp: %s, relayPort: %d",
-		network, relayIp.String(), relayPort)
+	log.Debug("network: %s, relayIp: %s, network6: %s, relayIp6: %v, relayPort: %d",
+		network, relayIp.String(), network6, relayIp6, relayPort)
 
-	return
+	return nil
 }
 
 func (g *Gateway) tunUp() {
-	config := water.Config{
-		DeviceType: water.TUN,
-	}
-	config.Name = tunName
-
-	ifce, err := water.New(config)
+	ifce, err := newTun(g.network)
 	if err != nil {
 		log.Error("create tun fail %v", err)
 		os.Exit(1)
@@ -139,76 +319,162 @@ func (g *Gateway) tunUp() {
 	g.configTun()
 }
 
+// setupFirewall install the iptables rules of the fake ip network if enabled
+func (g *Gateway) setupFirewall() {
+	if !g.Firewall {
+		return
+	}
+
+	if err := netfilter.Setup(g.firewallRules()); err != nil {
+		log.Error("setup firewall error, %v", err)
+	}
+}
+
+// firewallRules return the rules of the fake ip network and the config
+func (g *Gateway) firewallRules() *netfilter.Rules {
+	rules := &netfilter.Rules{Network: g.network, Network6: g.network6}
+	if g.tproxy {
+		rules.TProxyPort = int(g.relayPort)
+	}
+	if g.Config != nil {
+		rules.DNS = g.Config.Gateway.Firewall.DNS
+		rules.Interface = g.Config.Gateway.Firewall.Interface
+	}
+	return rules
+}
+
+// FirewallScript return the shell script installing the iptables rules and the tproxy routes,
+// the same as -setup-firewall, for installing by hand
+func (g *Gateway) FirewallScript() (string, error) {
+	if err := g.loadConfig(); err != nil {
+		return "", err
+	}
+	return netfilter.Script(g.firewallRules())
+}
+
+// setSystemDNS set the system dns to the kungfu dns server if configured
+func (g *Gateway) setSystemDNS() {
+	if g.Config == nil || g.Config.Gateway.SystemDNS == "" {
+		return
+	}
+
+	restore, err := setSystemDNS(g.Config.Gateway.SystemDNS)
+	if err != nil {
+		log.Error("set system dns error, %v", err)
+		return
+	}
+	g.restoreDNS = restore
+}
+
 func (g *Gateway) relayTCPServe() {
+	g.serveTCPRelay("tcp4", g.relayIp, &g.relayTCPServer)
+}
+
+func (g *Gateway) relayTCP6Serve() {
+	if g.relayIp6 == nil {
+		return
+	}
+	g.serveTCPRelay("tcp6", g.relayIp6, &g.relayTCP6Server)
+}
+
+func (g *Gateway) serveTCPRelay(network string, ip net.IP, server **net.TCPListener) {
 
-	addr := &net.TCPAddr{IP: g.relayIp, Port: int(g.relayPort)}
-	ln, err := net.ListenTCP("tcp4", addr)
+	var ln *net.TCPListener
+	var err error
+	if g.tproxy {
+		ln, err = listenTransparentTCP(network, int(g.relayPort))
+	} else {
+		ln, err = listenTCP(network, (&net.TCPAddr{IP: ip, Port: int(g.relayPort)}).String(), nil)
+	}
 	if err != nil {
-		log.Error("start tcp relay server on port %d fail, %v", g.relayPort, err)
+		log.Error("start %s relay server on port %d fail, %v", network, g.relayPort, err)
 		return
 	}
 
-	log.Info("relay server listen on %d", g.relayPort)
+	log.Info("%s relay server listen on %d", network, g.relayPort)
 
-	g.relayTCPServer = ln
+	*server = ln
 
 	for {
-		if g.relayTCPServer == nil {
+		if *server == nil {
 			break
 		}
 
-		conn, err := g.relayTCPServer.AcceptTCP()
+		conn, err := (*server).AcceptTCP()
 		if err != nil {
+			if g.shuttingDown() {
+				return
+			}
 			log.Error("relay server accept request error, %v", err)
 			time.Sleep(time.Second * 3)
 			continue
 		}
 
-		go g.handleTCPRelayConn(conn)
+		g.relays.Add(1)
+		go func() {
+			defer g.relays.Done()
+			g.handleTCPRelayConn(conn)
+		}()
 	}
 }
 
 func (g *Gateway) relayUDPServe() {
-	addr := &net.UDPAddr{IP: g.relayIp, Port: int(g.relayPort)}
-	ln, err := net.ListenUDP("udp4", addr)
+	g.serveUDPRelay("udp4", g.relayIp, &g.relayUDPServer)
+}
+
+func (g *Gateway) relayUDP6Serve() {
+	if g.relayIp6 == nil {
+		return
+	}
+	g.serveUDPRelay("udp6", g.relayIp6, &g.relayUDP6Server)
+}
+
+func (g *Gateway) serveUDPRelay(network string, ip net.IP, server **net.UDPConn) {
+	var ln *net.UDPConn
+	var err error
+	if g.tproxy {
+		ln, err = listenTransparentUDP(network, int(g.relayPort))
+	} else {
+		ln, err = net.ListenUDP(network, &net.UDPAddr{IP: ip, Port: int(g.relayPort)})
+	}
 	if err != nil {
-		log.Error("start udp relay server on port %d fail, %v", g.relayPort, err)
+		log.Error("start %s relay server on port %d fail, %v", network, g.relayPort, err)
 		return
 	}
 
-	log.Info("relay server listen on %d", g.relayPort)
+	log.Info("%s relay server listen on %d", network, g.relayPort)
 
-	g.relayUDPServer = ln
+	*server = ln
 
 	for {
-		if g.relayUDPServer == nil {
+		if *server == nil {
 			break
 		}
 
-		buf := make([]byte, mtu)
+		// the packet is written to the tunnel before handleUDPRelay return
+		buf := packetBuffers.Get()
 
-		n, clientAddr, err := g.relayUDPServer.ReadFromUDP(buf)
+		var n int
+		var clientAddr, origDst *net.UDPAddr
+		if g.tproxy {
+			n, clientAddr, origDst, err = readTransparentUDP(ln, *buf)
+		} else {
+			n, clientAddr, err = ln.ReadFromUDP(*buf)
+		}
 		if err != nil {
+			packetBuffers.Put(buf)
+			if g.shuttingDown() {
+				return
+			}
 			log.Error("relay udp server receive data error, %v", err)
 			time.Sleep(time.Second * 3)
 			continue
 		}
 
-		go g.handleUDPRelay(clientAddr, buf[:n])
-	}
-}
-
-func (g *Gateway) configTun() {
-	execCommand("ip", fmt.Sprintf("addr flush dev %s", g.ifce.Name()))
-
-	err := execCommand("ip", fmt.Sprintf("addr add %s dev %s", g.network, g.ifce.Name()))
-	if err != nil {
-		log.Warning("set up tun addr error %v", err)
-	}
-
-	err = execCommand("ip", fmt.Sprintf("link set dev %s up mtu %d qlen 1000", g.ifce.Name(), mtu))
-	if err != nil {
-		log.Warning("up tun error %v", err)
+		go func() {
+			g.handleUDPRelay(ln, clientAddr, origDst, (*buf)[:n])
+			packetBuffers.Put(buf)
+		}()
 	}
 }
 
@@ -217,12 +483,27 @@ func (g *Gateway) handleRequest() {
 	for {
 		n, err := g.ifce.Read(buffer)
 		if err != nil {
-			log.Error("read tun ifce data error", err)
+			if !g.shuttingDown() {
+				log.Error("read tun ifce data error", err)
+			}
 			break
 		}
 
 		packet := buffer[:n]
 
+		if isIPv6Packet(&packet) {
+			p := ipv6Packet(packet)
+			if !p.valid() {
+				continue
+			}
+			if p.protocol() == tcp {
+				g.handleTCP(&p)
+			} else if p.protocol() == udp {
+				g.handleUDP(&p)
+			}
+			continue
+		}
+
 		if !isIPv4Packet(&packet) {
 			continue
 		}
@@ -240,7 +521,7 @@ func (g *Gateway) handleRequest() {
 	}
 }
 
-func (g *Gateway) handleTCP(p *ipv4Packet) {
+func (g *Gateway) handleTCP(p ipPacket) {
 	tp := tcpPacket(p.payload())
 
 	srcIp := p.sourceIP()
@@ -249,7 +530,16 @@ func (g *Gateway) handleTCP(p *ipv4Packet) {
 	srcPort := tp.sourcePort()
 	dstPort := tp.destinationPort()
 
-	if srcPort == g.relayPort && g.relayIp.Equal(srcIp) {
+	relayIp := g.relayIp
+	if _, ok := p.(*ipv6Packet); ok {
+		relayIp = g.relayIp6
+	}
+
+	if relayIp == nil {
+		return
+	}
+
+	if srcPort == g.relayPort && relayIp.Equal(srcIp) {
 		session := g.nat.getSession(dstPort)
 		if session == nil {
 			log.Warning("nat session not found, %v:%d -> %v:%d", srcIp, srcPort, dstIp, dstPort)
@@ -269,13 +559,13 @@ func (g *Gateway) handleTCP(p *ipv4Packet) {
 		}
 
 		if isNew {
-			if log.IsEnabledFor(logging.DEBUG) {
+			if log.DebugEnabled() {
 				log.Debug("tcp %v:%d -> %v:%d, relay: %d", srcIp, srcPort, dstIp, dstPort, port)
 			}
 		}
 
 		p.setSourceIP(dstIp)
-		p.setDestinationIP(g.relayIp)
+		p.setDestinationIP(relayIp)
 		tp.setSourcePort(port)
 		tp.setDestinationPort(g.relayPort)
 	}
@@ -283,7 +573,16 @@ func (g *Gateway) handleTCP(p *ipv4Packet) {
 	tp.resetChecksum(p.pseudoSum())
 	p.resetChecksum()
 
-	g.ifce.Write(*p)
+	g.writePacket(p)
+}
+
+func (g *Gateway) writePacket(p ipPacket) {
+	switch v := p.(type) {
+	case *ipv4Packet:
+		g.ifce.Write(*v)
+	case *ipv6Packet:
+		g.ifce.Write(*v)
+	}
 }
 
 func (g *Gateway) handleICMP(p *ipv4Packet) {
@@ -306,7 +605,7 @@ func (g *Gateway) handleICMP(p *ipv4Packet) {
 	}
 }
 
-func (g *Gateway) handleUDP(p *ipv4Packet) {
+func (g *Gateway) handleUDP(p ipPacket) {
 	up := udpPacket(p.payload())
 
 	srcIp := p.sourceIP()
@@ -315,7 +614,16 @@ func (g *Gateway) handleUDP(p *ipv4Packet) {
 	srcPort := up.sourcePort()
 	dstPort := up.destinationPort()
 
-	if srcPort == g.relayPort && g.relayIp.Equal(srcIp) {
+	relayIp := g.relayIp
+	if _, ok := p.(*ipv6Packet); ok {
+		relayIp = g.relayIp6
+	}
+
+	if relayIp == nil {
+		return
+	}
+
+	if srcPort == g.relayPort && relayIp.Equal(srcIp) {
 		session := g.nat.getSession(dstPort)
 		if session == nil {
 			log.Warning("nat session not found, %v:%d -> %v:%d", srcIp, srcPort, dstIp, dstPort)
@@ -335,13 +643,13 @@ func (g *Gateway) handleUDP(p *ipv4Packet) {
 		}
 
 		if isNew {
-			if log.IsEnabledFor(logging.DEBUG) {
+			if log.DebugEnabled() {
 				log.Debug("udp %v:%d -> %v:%d, relay: %d", srcIp, srcPort, dstIp, dstPort, port)
 			}
 		}
 
 		p.setSourceIP(dstIp)
-		p.setDestinationIP(g.relayIp)
+		p.setDestinationIP(relayIp)
 		up.setSourcePort(port)
 		up.setDestinationPort(g.relayPort)
 	}
@@ -349,7 +657,7 @@ func (g *Gateway) handleUDP(p *ipv4Packet) {
 	up.resetChecksum(p.pseudoSum())
 	p.resetChecksum()
 
-	g.ifce.Write(*p)
+	g.writePacket(p)
 }
 
 func (g *Gateway) handleTCPRelayConn(conn *net.TCPConn) {
@@ -363,9 +671,8 @@ func (g *Gateway) handleTCPRelayConn(conn *net.TCPConn) {
 	conn.SetNoDelay(true)
 
 	remoteAddr := conn.RemoteAddr().(*net.TCPAddr)
-	remotePort := uint16(remoteAddr.Port)
 
-	session := g.nat.getSession(remotePort)
+	session := g.relaySession(remoteAddr.IP, remoteAddr.Port, conn.LocalAddr().(*net.TCPAddr))
 	// log.Debug("remoteAddr: %v, session: %v", remoteAddr, session)
 
 	if session == nil {
@@ -373,49 +680,170 @@ func (g *Gateway) handleTCPRelayConn(conn *net.TCPConn) {
 		return
 	}
 
-	if g.proxy == nil {
+	if g.outbound == nil {
 		log.Warning("gateway proxy dialer is nil")
 		return
 	}
 
+	span := g.tracer.StartSpan("relay tcp", tracing.KindServer)
+	defer span.End()
+	span.SetAttr("client.address", session.srcIp.String())
+	span.SetAttr("relay.fake_ip", session.dstIp.String())
+
+	var client net.Conn = conn
 	key := internal.GetRedisIpKey(session.dstIp.String())
-	host, err := g.RedisClient.Get(key).Result()
+	lookup := span.Child("store lookup", tracing.KindClient)
+	host, err := g.Store.Get(key)
+	lookup.End()
+	if err == internal.ErrNil {
+		// the mapping expired, recover the host from the first bytes of the client
+		r := bufio.NewReaderSize(conn, sniffBufferSize)
+		if host, err = sniffHost(conn, r); err == nil {
+			client = &bufferedConn{Conn: conn, r: r}
+			g.remap(session.dstIp, host)
+		}
+	}
 	if err != nil {
+		span.SetError(err)
 		log.Warning("get redis domain fail %s, error: %v", key, err)
 		return
 	}
+	span.SetAttr("relay.domain", host)
+
+	if g.rejected(session.dstIp) {
+		// reset instead of the normal close, the client fail fast
+		span.SetAttr("relay.rejected", true)
+		conn.SetLinger(0)
+		log.Debug("reject %s:%d request %s", session.srcIp, session.srcPort, host)
+		return
+	}
 
-	target := fmt.Sprintf("%s:%d", host, session.dstPort)
-	tunnel, err := g.dialer.Dial("tcp", target)
+	done := make(chan struct{})
+	defer close(done)
+	go g.keepMapping(session.dstIp, done)
+
+	target, err := proxyTarget(host, session.dstPort)
+	if err != nil {
+		span.SetError(err)
+		log.Warning("fake ip %s, %v", session.dstIp, err)
+		return
+	}
+	ob := g.route(host)
+	var tunnel net.Conn
+	outboundName := ob.name
+	dial := span.Child("proxy dial", tracing.KindClient)
+	dial.SetAttr("relay.target", target)
+	if g.raceDirect(host, session.dstIp) {
+		tunnel, outboundName, err = g.dialRace(client, ob, host, session.dstIp, session.dstPort, target)
+	} else if realIp := g.directIp(session.dstIp); realIp != "" {
+		outboundName = outboundDirect
+		tunnel, err = dialTCP(net.JoinHostPort(realIp, strconv.Itoa(int(session.dstPort))), directTimeout)
+	} else {
+		tunnel, err = ob.Dial("tcp", target)
+	}
+	dial.SetAttr("relay.outbound", outboundName)
+	dial.SetError(err)
+	dial.End()
+	span.SetAttr("relay.target", target)
+	span.SetAttr("relay.outbound", outboundName)
 	if err != nil {
-		log.Warning("dial %s by proxy %s error %v", target, g.proxy.String(), err)
+		span.SetError(err)
+		dialErrorsTotal.Inc("tcp")
+		log.Warning("dial %s by outbound %s error %v", target, ob.name, err)
 		return
 	}
 
 	defer tunnel.Close()
 
+	tc := g.conns.add(&trackedConn{
+		network:  "tcp",
+		client:   net.JoinHostPort(session.srcIp.String(), strconv.Itoa(int(session.srcPort))),
+		fakeIp:   session.dstIp.String(),
+		domain:   host,
+		target:   target,
+		outbound: outboundName,
+		kill: func() {
+			conn.Close()
+			tunnel.Close()
+		},
+	})
+	defer g.conns.remove(tc)
+	tunnel = &countingConn{Conn: tunnel, c: tc}
+	tunnel = &limitedConn{Conn: tunnel, bandwidth: g.bandwidth, client: session.srcIp, outbound: outboundName}
+
+	connectionsTotal.Inc("tcp")
+	activeConnections.Add(1, "tcp")
+	defer activeConnections.Add(-1, "tcp")
+
 	uploadChan := make(chan int64)
 	downloadchan := make(chan int64)
 
-	go forward(conn, tunnel.(*net.TCPConn), uploadChan)
-	go forward(tunnel.(*net.TCPConn), conn, downloadchan)
+	go forward(client, tunnel, uploadChan)
+	go forward(tunnel, client, downloadchan)
 
 	uploadBytes := <-uploadChan
 	downloadBytes := <-downloadchan
 
+	relayBytesTotal.Add(uint64(uploadBytes), "upload")
+	relayBytesTotal.Add(uint64(downloadBytes), "download")
+	span.SetAttr("relay.upload_bytes", uploadBytes)
+	span.SetAttr("relay.download_bytes", downloadBytes)
+
 	log.Debug("relay %s:%d request %s, upload: %d, download: %d",
 		session.srcIp.String(), session.srcPort, target,
 		uploadBytes, downloadBytes)
 }
 
-func (g *Gateway) handleUDPRelay(clientAddr *net.UDPAddr, packet []byte) {
+// keepMapping refresh the mapping of the fake ip until done, the mapping of long-lived
+// connection never expire
+func (g *Gateway) keepMapping(ip net.IP, done <-chan struct{}) {
+	g.toucher.Touch(ip)
+
+	ticker := time.NewTicker(internal.TouchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			g.toucher.Touch(ip)
+		}
+	}
+}
+
+// relaySession return the session of the client, the original destination is the local address
+// in tproxy mode, otherwise from the nat session of the client port
+func (g *Gateway) relaySession(srcIp net.IP, srcPort int, local net.Addr) *natSession {
+	if !g.tproxy {
+		return g.nat.getSession(uint16(srcPort))
+	}
+
+	var dstIp net.IP
+	var dstPort int
+	switch addr := local.(type) {
+	case *net.TCPAddr:
+		dstIp, dstPort = addr.IP, addr.Port
+	case *net.UDPAddr:
+		dstIp, dstPort = addr.IP, addr.Port
+	default:
+		return nil
+	}
+
+	if ip4 := dstIp.To4(); ip4 != nil {
+		dstIp = ip4
+	}
+	return &natSession{srcIp: srcIp, srcPort: uint16(srcPort), dstIp: dstIp, dstPort: uint16(dstPort)}
+}
+
+func (g *Gateway) handleUDPRelay(server *net.UDPConn, clientAddr, origDst *net.UDPAddr, packet []byte) {
 	defer func() {
 		if x := recover(); x != nil {
 			log.Error("handle udp relay exception, %v", x)
 		}
 	}()
 
-	tunnel := g.getUDPTunnel(clientAddr)
+	tunnel := g.getUDPTunnel(server, clientAddr, origDst)
 	if tunnel == nil {
 		return
 	}
@@ -424,58 +852,144 @@ func (g *Gateway) handleUDPRelay(clientAddr *net.UDPAddr, packet []byte) {
 	tunnel.Write(packet)
 }
 
-func (g *Gateway) getUDPTunnel(clientAddr *net.UDPAddr) *net.UDPConn {
+// getUDPTunnel return the tunnel of the client, the replies are sent back by the relay server,
+// or by the socket bound the original destination in tproxy mode
+func (g *Gateway) getUDPTunnel(server *net.UDPConn, clientAddr, origDst *net.UDPAddr) net.Conn {
 	g.udpTunnelLock.Lock()
 	defer g.udpTunnelLock.Unlock()
 
-	port := uint16(clientAddr.Port)
-	session := g.nat.getSession(port)
+	var local net.Addr
+	if origDst != nil {
+		local = origDst
+	}
+	session := g.relaySession(clientAddr.IP, clientAddr.Port, local)
 	if session == nil {
 		return nil
 	}
 
-	tunnel := g.udpTunnels[clientAddr.String()]
+	g.toucher.Touch(session.dstIp)
+
+	// the client of tproxy mode may send to multiple destinations by the same port
+	key := clientAddr.String()
+	if g.tproxy {
+		key += "->" + origDst.String()
+	}
+
+	tunnel := g.udpTunnels[key]
 	if tunnel != nil {
 		return tunnel
 	}
 
-	realIp, err := g.getRealIp(session.dstIp.String())
-
-	if err != nil {
-		log.Warning("get real ip fail %v:%d, error: %s", session.dstIp, session.dstPort, err)
+	if g.rejected(session.dstIp) {
 		return nil
 	}
 
-	log.Debug("get real ip: %s %s", session.dstIp.String(), realIp)
+	var target, host string
+	var ob *outbound
+	var err error
+	if g.udpProxy {
+		host, err = g.Store.Get(internal.GetRedisIpKey(session.dstIp.String()))
+		if err != nil {
+			log.Warning("get redis domain fail %v, error: %v", session.dstIp, err)
+			return nil
+		}
 
-	target := &net.UDPAddr{
-		IP:   net.ParseIP(realIp),
-		Port: int(session.dstPort),
+		// the domain is resolved by the proxy server
+		if target, err = proxyTarget(host, session.dstPort); err != nil {
+			log.Warning("fake ip %s, %v", session.dstIp, err)
+			return nil
+		}
+		ob = g.route(host)
 	}
-	tunnel, err = net.DialUDP("udp", nil, target)
-	if err != nil {
-		log.Warning("dial %s error %v", target, err)
-		return nil
+
+	if ob != nil && ob.supportUDP() {
+		tunnel, err = ob.DialUDP(target)
+		if err != nil {
+			dialErrorsTotal.Inc("udp")
+			log.Warning("dial udp %s by outbound %s error %v", target, ob.name, err)
+			return nil
+		}
+	} else {
+		realIp, err := g.getRealIp(session.dstIp.String())
+
+		if err != nil {
+			log.Warning("get real ip fail %v:%d, error: %s", session.dstIp, session.dstPort, err)
+			return nil
+		}
+
+		log.Debug("get real ip: %s %s", session.dstIp.String(), realIp)
+
+		if host == "" {
+			host, _ = g.Store.Get(internal.GetRedisIpKey(session.dstIp.String()))
+		}
+
+		target = net.JoinHostPort(realIp, strconv.Itoa(int(session.dstPort)))
+		tunnel, err = net.Dial("udp", target)
+		if err != nil {
+			dialErrorsTotal.Inc("udp")
+			log.Warning("dial %s error %v", target, err)
+			return nil
+		}
 	}
-	log.Debug("udp create tunnel %s:%d -> %s:%d",
-		clientAddr.IP.String(), clientAddr.Port, target.IP.String(), target.Port)
-	g.udpTunnels[clientAddr.String()] = tunnel
+
+	reply := server
+	if g.tproxy {
+		network := "udp4"
+		if session.dstIp.To4() == nil {
+			network = "udp6"
+		}
+		reply, err = dialTransparentUDP(network, origDst)
+		if err != nil {
+			log.Warning("bind original destination %v error %v", origDst, err)
+			tunnel.Close()
+			return nil
+		}
+	}
+
+	tc := &trackedConn{
+		network:  "udp",
+		client:   clientAddr.String(),
+		fakeIp:   session.dstIp.String(),
+		domain:   host,
+		target:   target,
+		outbound: outboundDirect,
+	}
+	if ob != nil && ob.supportUDP() {
+		tc.outbound = ob.name
+	}
+	rawTunnel := tunnel
+	tc.kill = func() { rawTunnel.Close() }
+	g.conns.add(tc)
+	tunnel = &countingConn{Conn: tunnel, c: tc}
+	tunnel = &limitedConn{Conn: tunnel, bandwidth: g.bandwidth, client: clientAddr.IP, outbound: tc.outbound}
+
+	connectionsTotal.Inc("udp")
+	activeConnections.Add(1, "udp")
+	log.Debug("udp create tunnel %s:%d -> %s",
+		clientAddr.IP.String(), clientAddr.Port, target)
+	g.udpTunnels[key] = tunnel
 
 	go func() {
 		defer func() {
 			g.udpTunnelLock.Lock()
 			defer g.udpTunnelLock.Unlock()
 
-			log.Debug("udp destroy tunnel %s:%d -> %s:%d",
-				clientAddr.IP.String(), clientAddr.Port, target.IP.String(), target.Port)
+			log.Debug("udp destroy tunnel %s:%d -> %s",
+				clientAddr.IP.String(), clientAddr.Port, target)
 
-			delete(g.udpTunnels, clientAddr.String())
+			delete(g.udpTunnels, key)
 			tunnel.Close()
+			g.conns.remove(tc)
+			if reply != server {
+				reply.Close()
+			}
+			activeConnections.Add(-1, "udp")
 
 		}()
-		buf := make([]byte, mtu)
+		buf := packetBuffers.Get()
+		defer packetBuffers.Put(buf)
 		for {
-			n, err := tunnel.Read(buf)
+			n, err := tunnel.Read(*buf)
 			if err != nil {
 
 				if e, ok := err.(*net.OpError); ok && e.Timeout() {
@@ -486,7 +1000,7 @@ func (g *Gateway) getUDPTunnel(clientAddr *net.UDPAddr) *net.UDPConn {
 				break
 			}
 
-			_, err = g.relayUDPServer.WriteToUDP(buf[:n], clientAddr)
+			_, err = reply.WriteToUDP((*buf)[:n], clientAddr)
 			if err != nil {
 				log.Error("response to client error, %v", err)
 				break
@@ -499,8 +1013,8 @@ func (g *Gateway) getUDPTunnel(clientAddr *net.UDPAddr) *net.UDPConn {
 
 func (g *Gateway) getRealIp(dstIp string) (string, error) {
 	realIpKey := internal.GetRedisRealIpKey(dstIp)
-	realIp, err := g.RedisClient.Get(realIpKey).Result()
-	if err != nil && realIp != "" {
+	realIp, err := g.Store.Get(realIpKey)
+	if err == nil && realIp != "" {
 		return realIp, nil
 	}
 
@@ -508,18 +1022,18 @@ func (g *Gateway) getRealIp(dstIp string) (string, error) {
 	defer realIpQueryLock.Unlock()
 
 	// retry
-	realIp, err = g.RedisClient.Get(realIpKey).Result()
-	if err != nil && realIp != "" {
+	realIp, err = g.Store.Get(realIpKey)
+	if err == nil && realIp != "" {
 		return realIp, nil
 	}
 
 	ipKey := internal.GetRedisIpKey(dstIp)
-	host, err := g.RedisClient.Get(ipKey).Result()
+	host, err := g.Store.Get(ipKey)
 	if err != nil {
 		return "", err
 	}
 
-	conn, err := g.dialer.Dial("tcp", "8.8.8.8:53")
+	conn, err := g.outbound.Dial("tcp", "8.8.8.8:53")
 	if err != nil {
 		return "", err
 	}
@@ -557,19 +1071,25 @@ func (g *Gateway) getRealIp(dstIp string) (string, error) {
 	log.Debug("cache real ip query result, cache key: %s, mapping ip: %s, host: %s, realIp: %s",
 		realIpKey, dstIp, host, realIp)
 
-	g.RedisClient.SetNX(realIpKey, realIp, time.Duration(ttl)*time.Second)
+	g.Store.SetNX(realIpKey, realIp, time.Duration(ttl)*time.Second)
 	return realIp, nil
 }
 
 func (g *Gateway) subscribe() {
 	channels := []string{
 		internal.GetRedisNetworkChannelKey(),
+		internal.GetRedisNetwork6ChannelKey(),
 		internal.GetRedisProxyChannelKey(),
 	}
 	log.Debug("subscribe channels: %s", strings.Join(channels, ", "))
-	sub := g.RedisClient.Subscribe(channels...)
+	sub := g.Store.Subscribe(channels...)
+	g.sub = sub
 	for {
 		message, err := sub.ReceiveMessage()
+		if g.shuttingDown() {
+			return
+		}
+
 		if err != nil {
 			log.Error("receive message error %v", err)
 			continue
@@ -586,30 +1106,174 @@ func (g *Gateway) subscribe() {
 			g.relayTCPServer = nil
 			g.relayUDPServer.Close()
 			g.relayUDPServer = nil
+			if g.relayUDP6Server != nil {
+				g.relayUDP6Server.Close()
+				g.relayUDP6Server = nil
+			}
+			if g.relayTCP6Server != nil {
+				g.relayTCP6Server.Close()
+				g.relayTCP6Server = nil
+			}
 			time.Sleep(time.Second * 5)
 
-			log.Debug("re-config tun ifce")
-			g.configTun()
+			if !g.tproxy {
+				log.Debug("re-config tun ifce")
+				g.configTun()
+			}
+			g.setupFirewall()
 
 			log.Debug("start relay server")
 			go g.relayTCPServe()
+			go g.relayTCP6Serve()
 			go g.relayUDPServe()
+			go g.relayUDP6Serve()
 		}
 	}
 }
 
-func execCommand(name string, args string) error {
-	log.Debug("execute cmd %s %s", name, args)
-	return exec.Command(name, strings.Split(args, " ")...).Run()
+// readiness check the store and the proxies of the default outbound, the result of each check
+// is ok or the reason of failure
+func (g *Gateway) readiness() (map[string]string, bool) {
+	checks := map[string]string{"store": "ok", "proxy": "ok"}
+
+	if g.shuttingDown() {
+		checks["gateway"] = "shutting down"
+	}
+
+	if _, err := g.Store.Get(internal.GetRedisNetworkKey()); err != nil && err != internal.ErrNil {
+		checks["store"] = err.Error()
+	}
+
+	if g.outbound == nil {
+		checks["proxy"] = "not configured"
+	} else {
+		checks["proxy"] = "no alive proxy"
+		for _, p := range g.outbound.upstreams {
+			if alive, _ := p.health(); alive {
+				checks["proxy"] = "ok"
+				break
+			}
+		}
+	}
+
+	for _, result := range checks {
+		if result != "ok" {
+			return checks, false
+		}
+	}
+	return checks, true
+}
+
+// alive report whether the gateway is not hung, for the systemd watchdog
+func (g *Gateway) alive() bool {
+	g.conns.lock.RLock()
+	g.conns.lock.RUnlock()
+	return !g.shuttingDown()
+}
+
+// Shutdown stop accepting new connections, wait the relay connections until ctx done,
+// then close the store
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	if g.done == nil || g.shuttingDown() {
+		return nil
+	}
+	close(g.done)
+	defer close(g.closed)
+	internal.SdNotify("STOPPING=1")
+
+	log.Info("shutdown gateway, drain relay connections")
+	g.pusher.Stop()
+	defer g.tracer.Stop()
+
+	if g.relayTCPServer != nil {
+		g.relayTCPServer.Close()
+	}
+	if g.relayTCP6Server != nil {
+		g.relayTCP6Server.Close()
+	}
+	if g.relayUDPServer != nil {
+		g.relayUDPServer.Close()
+	}
+	if g.relayUDP6Server != nil {
+		g.relayUDP6Server.Close()
+	}
+	if g.admin != nil {
+		g.admin.Close()
+	}
+	// the streams never end, stopped without waiting
+	if g.control != nil {
+		g.control.Stop()
+	}
+
+	var err error
+	drained := make(chan struct{})
+	go func() {
+		g.relays.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Warning("shutdown timeout, drop the relay connections")
+	}
+
+	if g.sub != nil {
+		g.sub.Close()
+	}
+
+	g.flushTraffic()
+	releaseWireguard(nil)
+
+	if g.restoreDNS != nil {
+		g.restoreDNS()
+	}
+
+	g.removeTunRoutes()
+	if g.ifce != nil {
+		g.ifce.Close()
+	}
+
+	if g.Firewall {
+		netfilter.Cleanup()
+	}
+
+	if e := g.Store.Close(); e != nil {
+		log.Error("close store error, %v", e)
+	}
+
+	log.Info("gateway shutdown")
+	return err
+}
+
+// shuttingDown return true if shutdown is started
+func (g *Gateway) shuttingDown() bool {
+	select {
+	case <-g.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func isIPv4Packet(packet *[]byte) bool {
 	return ((*packet)[0] >> 4) == 4
 }
 
-func forward(src *net.TCPConn, dst *net.TCPConn, ch chan<- int64) {
-	n, _ := io.Copy(dst, src)
-	dst.CloseWrite()
-	src.CloseRead()
+// halfCloser is the conn can be closed in one direction, e.g. *net.TCPConn
+type halfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
+func forward(src net.Conn, dst net.Conn, ch chan<- int64) {
+	n, _ := relay(dst, src)
+	if c, ok := dst.(halfCloser); ok {
+		c.CloseWrite()
+	}
+	if c, ok := src.(halfCloser); ok {
+		c.CloseRead()
+	}
 	ch <- n
 }
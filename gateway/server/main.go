@@ -1,24 +1,35 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/yinheli/kungfu"
 	"github.com/yinheli/kungfu/gateway"
+	"github.com/yinheli/kungfu/geoip"
 	"github.com/yinheli/kungfu/internal"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 )
 
 var (
 	log   = kungfu.GetLog()
 	build string
 
-	c       = flag.String("c", "config.yml", "config file")
-	d       = flag.Bool("d", false, "debug log level")
-	version = flag.Bool("version", false, "show server version")
+	c              = flag.String("c", "config.yml", "config file")
+	d              = flag.Bool("d", false, "debug log level")
+	setupFirewall  = flag.Bool("setup-firewall", false, "install the iptables rules of the fake ip network (linux), removed on shutdown")
+	printFirewall  = flag.Bool("print-firewall", false, "print the shell script of the iptables rules and routes (linux), then exit")
+	printChnRoutes = flag.Bool("print-chnroutes", false, "print the cidrs of the china ip list (chnroutes.source) for the route table, then exit")
+	version        = flag.Bool("version", false, "show server version")
 )
 
+// shutdownTimeout is the max time to drain the in flight requests on shutdown
+const shutdownTimeout = time.Duration(time.Second * 30)
+
 func main() {
 	ver := getVersion()
 
@@ -29,6 +40,7 @@ func main() {
 		u()
 	}
 
+	flag.Var(&internal.ConfigOverrides, "set", "override the config value, key=value, e.g. redis.addr=127.0.0.1:6379, repeatable, over the KUNGFU_* env vars")
 	flag.Parse()
 
 	if !flag.Parsed() {
@@ -50,13 +62,106 @@ func main() {
 	log.Info("kungfu gateway server version: %s", ver)
 	log.Info(kungfu.DECLARATION)
 
-	config := internal.ParseConfig(*c)
-	client := internal.NewRedisClient(&config.Redis)
+	config, err := internal.ParseConfig(*c)
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	internal.ApplyLog(config)
+	if *d {
+		kungfu.SetLogLevelDebug()
+	}
+
+	store := internal.NewStore(config)
+	if err = config.Seed(store); err != nil {
+		log.Error("write the pool, upstream and outbounds of config to store error, %v", err)
+		os.Exit(1)
+	}
+
+	// the gateway config is in store and applied via channels, the pool and outbounds of config
+	// file are written to store and notified via the channels too
+	apply := func(next *internal.Config) error {
+		internal.ApplyLog(next)
+		return next.Seed(store)
+	}
+	go internal.WatchConfig(*c, func(next *internal.Config) {
+		if err := apply(next); err != nil {
+			log.Error("write the pool, upstream and outbounds of config to store error, %v", err)
+		}
+	})
 
 	server := &gateway.Gateway{
-		RedisClient: client,
+		Store:    store,
+		Config:   config,
+		Firewall: *setupFirewall,
+		// the control api reload config.yml the same as SIGHUP
+		ReloadConfig: func() error {
+			next, err := internal.ParseConfig(*c)
+			if err != nil {
+				return err
+			}
+			return apply(next)
+		},
+	}
+
+	if *printFirewall {
+		script, err := server.FirewallScript()
+		if err != nil {
+			log.Error("generate firewall script error, %v", err)
+			os.Exit(1)
+		}
+		fmt.Print(script)
+		os.Exit(0)
+	}
+
+	if config.ChnRoutes.Source != "" {
+		server.ChnRoutes = &geoip.RoutesLoader{
+			Source:   config.ChnRoutes.Source,
+			Interval: config.ChnRoutes.Interval,
+		}
+	}
+
+	if *printChnRoutes {
+		if server.ChnRoutes == nil {
+			log.Error("chnroutes source not configured")
+			os.Exit(1)
+		}
+		if err := server.ChnRoutes.Load(); err != nil {
+			log.Error("load china ip list error, %v", err)
+			os.Exit(1)
+		}
+		for _, cidr := range server.ChnRoutes.Ranges().CIDRs() {
+			fmt.Println(cidr)
+		}
+		os.Exit(0)
 	}
 
+	if server.ChnRoutes != nil {
+		server.ChnRoutes.Start()
+	}
+
+	if config.GeoIP.Database != "" {
+		server.GeoIP = &geoip.Updater{
+			File:     config.GeoIP.Database,
+			URL:      config.GeoIP.URL,
+			Interval: config.GeoIP.Interval,
+		}
+		server.GeoIP.Start()
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		log.Info("receive signal %v, shutdown", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warning("shutdown error, %v", err)
+		}
+	}()
+
 	server.Serve()
 }
 
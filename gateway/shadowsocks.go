@@ -0,0 +1,353 @@
+package gateway
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// ssMaxPayload is the max payload size of a chunk of the aead stream
+	ssMaxPayload = 0x3fff
+	ssSubkeyInfo = "ss-subkey"
+)
+
+var errShadowsocksPacket = errors.New("shadowsocks: invalid packet")
+
+// ssAEADs is the aead of the subkey by the cipher name, all the internal.ShadowsocksCiphers
+var ssAEADs = map[string]func(subkey []byte) (cipher.AEAD, error){
+	"aes-128-gcm":            newGCM,
+	"aes-192-gcm":            newGCM,
+	"aes-256-gcm":            newGCM,
+	"chacha20-ietf-poly1305": chacha20poly1305.New,
+}
+
+// shadowsocksClient is the shadowsocks client with the aead ciphers, the proxy url is
+// ss://method:password@host:port or the SIP002 format with the base64 encoded userinfo
+type shadowsocksClient struct {
+	server  string
+	cipher  *ssCipher
+	timeout time.Duration
+	// forward connect the server, directly if nil
+	forward proxy.Dialer
+}
+
+func newShadowsocksClient(u *url.URL) (*shadowsocksClient, error) {
+	method, password, err := internal.ParseShadowsocksUser(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shadowsocksClient{
+		server:  u.Host,
+		cipher:  &ssCipher{key: evpBytesToKey(password, internal.ShadowsocksCiphers[method]), aead: ssAEADs[method]},
+		timeout: socks5Timeout,
+	}, nil
+}
+
+// Dial connect the addr through the shadowsocks server, implement proxy.Dialer
+func (c *shadowsocksClient) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("shadowsocks: unsupported network %s", network)
+	}
+
+	dst, err := socks5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialServer(c.forward, c.server, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := newSSConn(conn, c.cipher)
+	if _, err := sc.Write(dst); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// DialUDP relay udp to the addr through the shadowsocks server
+func (c *shadowsocksClient) DialUDP(addr string) (net.Conn, error) {
+	dst, err := socks5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := net.ResolveUDPAddr("udp", c.server)
+	if err != nil {
+		return nil, err
+	}
+
+	udp, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssUDPConn{
+		UDPConn: udp,
+		cipher:  c.cipher,
+		header:  dst,
+		buf:     make([]byte, mtu+len(c.cipher.key)+len(dst)+16),
+	}, nil
+}
+
+// ssConn is the aead stream of shadowsocks, each side send the salt first, then the chunks of
+// the encrypted payload length and the encrypted payload
+type ssConn struct {
+	net.Conn
+	cipher *ssCipher
+
+	enc      cipher.AEAD
+	encNonce []byte
+	dec      cipher.AEAD
+	decNonce []byte
+	// pending is the decrypted payload not read yet
+	pending []byte
+}
+
+func newSSConn(conn net.Conn, c *ssCipher) *ssConn {
+	return &ssConn{Conn: conn, cipher: c}
+}
+
+func (c *ssConn) Write(b []byte) (int, error) {
+	var out []byte
+	if c.enc == nil {
+		salt := make([]byte, len(c.cipher.key))
+		if _, err := rand.Read(salt); err != nil {
+			return 0, err
+		}
+
+		aead, err := c.cipher.newAEAD(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.enc = aead
+		c.encNonce = make([]byte, aead.NonceSize())
+		out = salt
+	}
+
+	for n := 0; n < len(b); {
+		size := len(b) - n
+		if size > ssMaxPayload {
+			size = ssMaxPayload
+		}
+
+		out = c.enc.Seal(out, c.encNonce, []byte{byte(size >> 8), byte(size)}, nil)
+		increaseNonce(c.encNonce)
+		out = c.enc.Seal(out, c.encNonce, b[n:n+size], nil)
+		increaseNonce(c.encNonce)
+		n += size
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *ssConn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	if c.dec == nil {
+		salt := make([]byte, len(c.cipher.key))
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return 0, err
+		}
+
+		aead, err := c.cipher.newAEAD(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.dec = aead
+		c.decNonce = make([]byte, aead.NonceSize())
+	}
+
+	buf := make([]byte, 2+c.dec.Overhead())
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return 0, err
+	}
+	length, err := c.dec.Open(buf[:0], c.decNonce, buf, nil)
+	if err != nil {
+		return 0, err
+	}
+	increaseNonce(c.decNonce)
+
+	size := int(binary.BigEndian.Uint16(length)) & ssMaxPayload
+	buf = make([]byte, size+c.dec.Overhead())
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return 0, err
+	}
+	payload, err := c.dec.Open(buf[:0], c.decNonce, buf, nil)
+	if err != nil {
+		return 0, err
+	}
+	increaseNonce(c.decNonce)
+
+	n := copy(b, payload)
+	c.pending = payload[n:]
+	return n, nil
+}
+
+func (c *ssConn) CloseRead() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return nil
+}
+
+func (c *ssConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+// ssUDPConn relay the packets to the addr of header, each packet is the salt and the
+// encrypted address and payload
+type ssUDPConn struct {
+	*net.UDPConn
+	cipher *ssCipher
+	header []byte
+	buf    []byte
+}
+
+func (c *ssUDPConn) Write(b []byte) (int, error) {
+	salt := make([]byte, len(c.cipher.key))
+	if _, err := rand.Read(salt); err != nil {
+		return 0, err
+	}
+
+	aead, err := c.cipher.newAEAD(salt)
+	if err != nil {
+		return 0, err
+	}
+
+	plain := append(c.header[:len(c.header):len(c.header)], b...)
+	packet := aead.Seal(salt, make([]byte, aead.NonceSize()), plain, nil)
+	if _, err := c.UDPConn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read the packets from the server, the invalid packets are dropped
+func (c *ssUDPConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.UDPConn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+
+		payload, err := openSSPacket(c.cipher, c.buf[:n])
+		if err != nil {
+			continue
+		}
+		return copy(b, payload), nil
+	}
+}
+
+// openSSPacket decrypt the udp packet, return the payload without the address
+func openSSPacket(c *ssCipher, packet []byte) ([]byte, error) {
+	size := len(c.key)
+	if len(packet) < size {
+		return nil, errShadowsocksPacket
+	}
+
+	aead, err := c.newAEAD(packet[:size])
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := aead.Open(nil, make([]byte, aead.NonceSize()), packet[size:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := socks5AddrLen(plain)
+	if err != nil {
+		return nil, err
+	}
+	return plain[n:], nil
+}
+
+// ssCipher is the aead cipher and the key derived from the password, the salt is as long as
+// the key
+type ssCipher struct {
+	key  []byte
+	aead func(subkey []byte) (cipher.AEAD, error)
+}
+
+// newAEAD return the aead of the subkey derived from the key and salt
+func (c *ssCipher) newAEAD(salt []byte) (cipher.AEAD, error) {
+	return c.aead(hkdfSHA1(c.key, salt, []byte(ssSubkeyInfo), len(c.key)))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// increaseNonce increase the nonce by 1 in little endian
+func increaseNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// evpBytesToKey derive the key of the password, same as EVP_BytesToKey of openssl with md5
+func evpBytesToKey(password string, size int) []byte {
+	var key, prev []byte
+	for len(key) < size {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:size]
+}
+
+// hkdfSHA1 is the HKDF (RFC 5869) with sha1
+func hkdfSHA1(secret, salt, info []byte, size int) []byte {
+	mac := hmac.New(sha1.New, salt)
+	mac.Write(secret)
+	prk := mac.Sum(nil)
+
+	var out, prev []byte
+	for i := byte(1); len(out) < size; i++ {
+		mac = hmac.New(sha1.New, prk)
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:size]
+}
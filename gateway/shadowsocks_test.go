@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestHKDFSHA1(t *testing.T) {
+	// RFC 5869 test case 4
+	secret, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+
+	okm := hex.EncodeToString(hkdfSHA1(secret, salt, info, 42))
+	if okm != "085a01ea1b10f36933068b56efa5ad81a4f14b822f5b091568a9cdd4f155fda2c22e422478d305f3f896" {
+		t.Fatal("unexpected okm", okm)
+	}
+}
+
+func TestNewShadowsocksClient(t *testing.T) {
+	for _, s := range []string{
+		"ss://aes-256-gcm:secret@127.0.0.1:8388",
+		// SIP002, base64 of aes-256-gcm:secret
+		"ss://YWVzLTI1Ni1nY206c2VjcmV0@127.0.0.1:8388",
+	} {
+		u, _ := url.Parse(s)
+		c, err := newShadowsocksClient(u)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		if !bytes.Equal(c.cipher.key, evpBytesToKey("secret", 32)) {
+			t.Fatal("unexpected key of", s)
+		}
+	}
+
+	u, _ := url.Parse("ss://rc4-md5:secret@127.0.0.1:8388")
+	if _, err := newShadowsocksClient(u); err == nil {
+		t.Fatal("stream cipher should be unsupported")
+	}
+}
+
+func TestShadowsocksAEADs(t *testing.T) {
+	for method, size := range internal.ShadowsocksCiphers {
+		newAEAD, ok := ssAEADs[method]
+		if !ok {
+			t.Fatal("no aead of", method)
+		}
+		if _, err := newAEAD(make([]byte, size)); err != nil {
+			t.Fatal(method, err)
+		}
+	}
+}
+
+func TestShadowsocksClientDial(t *testing.T) {
+	for _, method := range []string{"aes-128-gcm", "chacha20-ietf-poly1305"} {
+		testShadowsocksClientDial(t, method)
+	}
+}
+
+func testShadowsocksClientDial(t *testing.T, method string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	u, _ := url.Parse("ss://" + method + ":secret@" + ln.Addr().String())
+	client, err := newShadowsocksClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server read the target address, then echo
+	target := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		sc := newSSConn(conn, client.cipher)
+		addr, err := readSocks5Addr(sc)
+		if err != nil {
+			return
+		}
+		target <- addr
+		io.Copy(sc, sc)
+	}()
+
+	conn, err := client.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// larger than a chunk
+	data := make([]byte, ssMaxPayload*2+10)
+	rand.Read(data)
+	go conn.Write(data)
+
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("should relay through the server")
+	}
+	if addr := <-target; addr != "example.com:443" {
+		t.Fatal("unexpected target", addr)
+	}
+}
+
+func TestShadowsocksClientDialUDP(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	u, _ := url.Parse("ss://aes-256-gcm:secret@" + server.LocalAddr().String())
+	client, err := newShadowsocksClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server echo the packet from the target
+	go func() {
+		buf := make([]byte, mtu)
+		n, addr, err := server.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		payload, err := openSSPacket(client.cipher, buf[:n])
+		if err != nil {
+			return
+		}
+		salt := make([]byte, len(client.cipher.key))
+		rand.Read(salt)
+		aead, _ := client.cipher.newAEAD(salt)
+		plain := append([]byte{socks5AtypIPv4, 8, 8, 8, 8, 0, 53}, payload...)
+		server.WriteToUDP(aead.Seal(salt, make([]byte, aead.NonceSize()), plain, nil), addr)
+	}()
+
+	conn, err := client.DialUDP("example.com:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, mtu)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatal("unexpected payload", buf[:n])
+	}
+}
@@ -0,0 +1,190 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	// sniffTimeout is the max time waiting the first bytes of the client
+	sniffTimeout = time.Duration(time.Second * 3)
+	// sniffBufferSize is enough for a tls record, the http headers larger are not sniffed
+	sniffBufferSize = 16<<10 + 5
+
+	tlsRecordHandshake      = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsExtensionServerName  = 0x0000
+)
+
+var errSniffFail = errors.New("host not found in the first bytes")
+
+// sniffHost recover the host of the connection from the sni of the tls client hello or the
+// host header of the http request, the bytes peeked are kept in r and relayed later
+func sniffHost(conn net.Conn, r *bufio.Reader) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	b, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	var host string
+	if b[0] == tlsRecordHandshake {
+		host, err = sniffTLS(r)
+	} else {
+		host, err = sniffHTTP(r)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return "", errSniffFail
+	}
+	return host, nil
+}
+
+func sniffTLS(r *bufio.Reader) (string, error) {
+	header, err := r.Peek(5)
+	if err != nil {
+		return "", err
+	}
+
+	record, err := r.Peek(5 + (int(header[3])<<8 | int(header[4])))
+	if err != nil {
+		return "", err
+	}
+	return parseSNI(record[5:])
+}
+
+// parseSNI parse the server name of the client hello handshake message
+func parseSNI(b []byte) (string, error) {
+	if len(b) < 4 || b[0] != tlsHandshakeClientHello {
+		return "", errSniffFail
+	}
+
+	// skip the message header, the version and the random
+	b = b[4:]
+	if len(b) < 34 {
+		return "", errSniffFail
+	}
+	b = b[34:]
+
+	// skip the session id, the cipher suites and the compression methods
+	for _, lenSize := range []int{1, 2, 1} {
+		var ok bool
+		if b, ok = skipVector(b, lenSize); !ok {
+			return "", errSniffFail
+		}
+	}
+
+	if len(b) < 2 {
+		return "", errSniffFail
+	}
+	extensions := b[2:]
+	if n := int(b[0])<<8 | int(b[1]); n < len(extensions) {
+		extensions = extensions[:n]
+	}
+
+	for len(extensions) >= 4 {
+		typ := int(extensions[0])<<8 | int(extensions[1])
+		n := int(extensions[2])<<8 | int(extensions[3])
+		if len(extensions) < 4+n {
+			break
+		}
+		data := extensions[4 : 4+n]
+		extensions = extensions[4+n:]
+
+		if typ != tlsExtensionServerName {
+			continue
+		}
+
+		// the server name list, the first host name is used
+		if len(data) < 5 || data[2] != 0 {
+			break
+		}
+		n = int(data[3])<<8 | int(data[4])
+		if len(data) < 5+n {
+			break
+		}
+		return string(data[5 : 5+n]), nil
+	}
+
+	return "", errSniffFail
+}
+
+// skipVector skip the vector with the length of lenSize bytes
+func skipVector(b []byte, lenSize int) ([]byte, bool) {
+	if len(b) < lenSize {
+		return nil, false
+	}
+
+	n := 0
+	for _, c := range b[:lenSize] {
+		n = n<<8 | int(c)
+	}
+
+	if len(b) < lenSize+n {
+		return nil, false
+	}
+	return b[lenSize+n:], true
+}
+
+func sniffHTTP(r *bufio.Reader) (string, error) {
+	// peek until the end of the headers
+	for {
+		b, err := r.Peek(r.Buffered())
+		if err != nil {
+			return "", err
+		}
+
+		if bytes.Contains(b, []byte("\r\n\r\n")) {
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(b)))
+			if err != nil {
+				return "", err
+			}
+			if host, _, err := net.SplitHostPort(req.Host); err == nil {
+				return host, nil
+			}
+			return req.Host, nil
+		}
+
+		if r.Buffered() >= sniffBufferSize {
+			return "", errSniffFail
+		}
+
+		if _, err := r.Peek(r.Buffered() + 1); err != nil {
+			return "", err
+		}
+	}
+}
+
+// remap restore the expired mapping of the fake ip to the sniffed host, the mapping is not
+// restored if the host is mapped to other fake ip
+func (g *Gateway) remap(ip net.IP, host string) {
+	domainKey := internal.GetRedisDomainKey(host + ".")
+	if ip.To4() == nil {
+		domainKey = internal.GetRedisDomain6Key(host + ".")
+	}
+
+	mapped, err := g.Store.MapDomain(domainKey, internal.GetRedisIpKey(ip.String()), host, ip.String(), g.toucher.TTL())
+	if err != nil {
+		log.Warning("restore mapping %s -> %v error, %v", host, ip, err)
+		return
+	}
+
+	if mapped != ip.String() {
+		log.Debug("sniffed host %s of %v is mapped to %s", host, ip, mapped)
+		return
+	}
+	log.Info("restore mapping %s -> %v by sniffing", host, ip)
+}
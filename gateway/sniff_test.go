@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestSniffHostTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// the client hello of crypto/tls
+	go tls.Client(client, &tls.Config{ServerName: "www.Google.com"}).Handshake()
+
+	r := bufio.NewReaderSize(server, sniffBufferSize)
+	host, err := sniffHost(server, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "www.google.com" {
+		t.Fatal("unexpected host", host)
+	}
+
+	// the peeked bytes are kept
+	if b, _ := r.Peek(1); b[0] != tlsRecordHandshake {
+		t.Fatal("the client hello should be kept")
+	}
+	client.Close()
+}
+
+func TestSniffHostHTTP(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	request := "GET / HTTP/1.1\r\nHost: example.com:8080\r\nUser-Agent: test\r\n\r\n"
+	go io.WriteString(client, request)
+
+	r := bufio.NewReaderSize(server, sniffBufferSize)
+	host, err := sniffHost(server, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "example.com" {
+		t.Fatal("unexpected host", host)
+	}
+
+	b := make([]byte, len(request))
+	if _, err := io.ReadFull(r, b); err != nil || string(b) != request {
+		t.Fatal("the request should be kept", string(b), err)
+	}
+	client.Close()
+}
+
+func TestParseSNIMalformed(t *testing.T) {
+	for _, b := range [][]byte{nil, {0x01, 0, 0, 40}, append([]byte{0x01, 0, 0, 40}, make([]byte, 36)...)} {
+		if _, err := parseSNI(b); err == nil {
+			t.Fatal("malformed client hello should fail", b)
+		}
+	}
+}
+
+func TestRemap(t *testing.T) {
+	g := &Gateway{Store: internal.NewMemoryStore(&internal.Memory{})}
+
+	g.remap(net.ParseIP("10.85.0.2"), "google.com")
+	if domain, _ := g.Store.Get(internal.GetRedisIpKey("10.85.0.2")); domain != "google.com" {
+		t.Fatal("mapping should be restored, got", domain)
+	}
+	if ip, _ := g.Store.Get(internal.GetRedisDomainKey("google.com.")); ip != "10.85.0.2" {
+		t.Fatal("domain mapping should be restored, got", ip)
+	}
+
+	// the domain is mapped to other ip
+	g.remap(net.ParseIP("10.85.0.3"), "google.com")
+	if _, err := g.Store.Get(internal.GetRedisIpKey("10.85.0.3")); err != internal.ErrNil {
+		t.Fatal("mapping should not be restored")
+	}
+}
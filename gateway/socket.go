@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// socketOptions is the internal.GatewaySocket of the tcp sockets, set on loading the config
+var socketOptions atomic.Value
+
+// socketControl set the options of the socket before bind or connect
+type socketControl func(network, address string, c syscall.RawConn) error
+
+func setSocketOptions(opts internal.GatewaySocket) {
+	socketOptions.Store(opts)
+}
+
+func loadSocketOptions() internal.GatewaySocket {
+	opts, _ := socketOptions.Load().(internal.GatewaySocket)
+	return opts
+}
+
+// dialTCP connect the address with the socket options, the proxy servers and the direct relays
+func dialTCP(address string, timeout time.Duration) (net.Conn, error) {
+	control, keepAlive := newSocketControl(loadSocketOptions(), false)
+	d := &net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
+	if control != nil {
+		d.Control = control
+	}
+	return d.Dial("tcp", address)
+}
+
+// listenTCP listen the address with the socket options, extra is the control of the listener
+// set before the options, e.g. IP_TRANSPARENT, nil to skip
+func listenTCP(network, address string, extra socketControl) (*net.TCPListener, error) {
+	control, keepAlive := newSocketControl(loadSocketOptions(), true)
+	lc := &net.ListenConfig{KeepAlive: keepAlive}
+	switch {
+	case extra == nil && control != nil:
+		lc.Control = control
+	case extra != nil && control == nil:
+		lc.Control = extra
+	case extra != nil && control != nil:
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			if err := extra(network, address, c); err != nil {
+				return err
+			}
+			return control(network, address, c)
+		}
+	}
+
+	ln, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	return ln.(*net.TCPListener), nil
+}
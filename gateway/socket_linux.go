@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// tcpFastOpenConnect is TCP_FASTOPEN_CONNECT not defined in unix, the data of the first
+	// write is sent in the SYN
+	tcpFastOpenConnect = 0x1e
+	// tcpFastOpenQueue is the queue length of the pending fast open requests of the listener
+	tcpFastOpenQueue = 256
+
+	// defaultKeepAlive is the keepalive idle of go, used if the interval or the count set
+	defaultKeepAlive = time.Duration(time.Second * 15)
+)
+
+// newSocketControl return the control setting the options, nil if none set, and the keepalive
+// of the dialer or the listener, the keepalive is set by the control and disabled of go if the
+// interval or the count set, go set the interval the same as the idle time
+func newSocketControl(opts internal.GatewaySocket, listen bool) (socketControl, time.Duration) {
+	type option struct{ level, name, value int }
+
+	var options []option
+	if opts.FastOpen {
+		if listen {
+			options = append(options, option{unix.IPPROTO_TCP, unix.TCP_FASTOPEN, tcpFastOpenQueue})
+		} else {
+			options = append(options, option{unix.IPPROTO_TCP, tcpFastOpenConnect, 1})
+		}
+	}
+
+	keepAlive := opts.KeepAlive
+	if keepAlive >= 0 && (opts.KeepAliveInterval > 0 || opts.KeepAliveCount > 0) {
+		idle := keepAlive
+		if idle == 0 {
+			idle = defaultKeepAlive
+		}
+		options = append(options,
+			option{unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1},
+			option{unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, int(idle / time.Second)})
+		if opts.KeepAliveInterval > 0 {
+			options = append(options, option{unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, int(opts.KeepAliveInterval / time.Second)})
+		}
+		if opts.KeepAliveCount > 0 {
+			options = append(options, option{unix.IPPROTO_TCP, unix.TCP_KEEPCNT, opts.KeepAliveCount})
+		}
+		keepAlive = -1
+	}
+
+	if opts.UserTimeout > 0 {
+		options = append(options, option{unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(opts.UserTimeout / time.Millisecond)})
+	}
+	if opts.ReadBuffer > 0 {
+		options = append(options, option{unix.SOL_SOCKET, unix.SO_RCVBUF, opts.ReadBuffer})
+	}
+	if opts.WriteBuffer > 0 {
+		options = append(options, option{unix.SOL_SOCKET, unix.SO_SNDBUF, opts.WriteBuffer})
+	}
+
+	if len(options) == 0 {
+		return nil, keepAlive
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			for _, o := range options {
+				if err = unix.SetsockoptInt(int(fd), o.level, o.name, o.value); err != nil {
+					return
+				}
+			}
+		}); e != nil {
+			return e
+		}
+		return err
+	}, keepAlive
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+	"golang.org/x/sys/unix"
+)
+
+func getsockopt(t *testing.T, conn syscall.Conn, level, name int) int {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value int
+	raw.Control(func(fd uintptr) {
+		value, err = unix.GetsockoptInt(int(fd), level, name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestSocketOptions(t *testing.T) {
+	setSocketOptions(internal.GatewaySocket{
+		KeepAlive:         time.Duration(time.Second * 30),
+		KeepAliveInterval: time.Duration(time.Second * 5),
+		KeepAliveCount:    3,
+		UserTimeout:       time.Duration(time.Second * 20),
+		ReadBuffer:        64 * 1024,
+	})
+	defer setSocketOptions(internal.GatewaySocket{})
+
+	ln, err := listenTCP("tcp4", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := dialTCP(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	accepted, err := ln.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+
+	for _, c := range []syscall.Conn{conn.(*net.TCPConn), accepted} {
+		if getsockopt(t, c, unix.SOL_SOCKET, unix.SO_KEEPALIVE) == 0 ||
+			getsockopt(t, c, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE) != 30 ||
+			getsockopt(t, c, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL) != 5 ||
+			getsockopt(t, c, unix.IPPROTO_TCP, unix.TCP_KEEPCNT) != 3 {
+			t.Fatal("the keepalive options should be set")
+		}
+		if getsockopt(t, c, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT) != 20000 {
+			t.Fatal("the user timeout should be set")
+		}
+		// the kernel double the buffer for the bookkeeping
+		if getsockopt(t, c, unix.SOL_SOCKET, unix.SO_RCVBUF) < 64*1024 {
+			t.Fatal("the read buffer should be set")
+		}
+	}
+
+	if control, keepAlive := newSocketControl(internal.GatewaySocket{KeepAlive: -1}, false); control != nil || keepAlive != -1 {
+		t.Fatal("the keepalive should be disabled without the control")
+	}
+}
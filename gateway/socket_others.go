@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package gateway
+
+import (
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// newSocketControl return the keepalive of the options, the other options are only supported
+// on linux
+func newSocketControl(opts internal.GatewaySocket, listen bool) (socketControl, time.Duration) {
+	return nil, opts.KeepAlive
+}
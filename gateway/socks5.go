@@ -0,0 +1,352 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	socks5Version = 5
+
+	socks5AuthNone     = 0
+	socks5AuthPassword = 2
+	socks5AuthNoAccept = 0xff
+
+	socks5CmdConnect      = 1
+	socks5CmdUDPAssociate = 3
+
+	socks5AtypIPv4   = 1
+	socks5AtypDomain = 3
+	socks5AtypIPv6   = 4
+
+	// socks5Timeout is the timeout of dialing the socks5 server and the handshake
+	socks5Timeout = time.Duration(time.Second * 10)
+)
+
+var errSocks5Addr = errors.New("socks5: invalid address header")
+
+// socks5Client is the socks5 (RFC 1928) client of the proxy, authenticate with the username
+// and password (RFC 1929) of the proxy url, relay tcp by CONNECT and udp by UDP ASSOCIATE
+type socks5Client struct {
+	server   string
+	username string
+	password string
+	timeout  time.Duration
+	// forward connect the server, directly if nil
+	forward proxy.Dialer
+}
+
+func newSocks5Client(u *url.URL) *socks5Client {
+	c := &socks5Client{server: u.Host, timeout: socks5Timeout}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	return c
+}
+
+// Dial connect the addr through the socks5 server, implement proxy.Dialer
+func (c *socks5Client) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("socks5: unsupported network %s", network)
+	}
+
+	conn, _, err := c.request(socks5CmdConnect, addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// DialUDP associate the udp relay of the socks5 server, the packets written to the returned
+// conn are sent to the addr, the association is kept until the conn is closed
+func (c *socks5Client) DialUDP(addr string) (net.Conn, error) {
+	header, err := socks5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl, relay, err := c.request(socks5CmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+
+	relayAddr, err := net.ResolveUDPAddr("udp", relay)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	// the relay is bound on all the addresses of the server
+	if relayAddr.IP.IsUnspecified() {
+		relayAddr.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	udp, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	// the association terminate when the control connection is closed
+	go func() {
+		io.Copy(ioutil.Discard, ctrl)
+		udp.Close()
+	}()
+
+	return &socks5UDPConn{
+		UDPConn: udp,
+		ctrl:    ctrl,
+		header:  append([]byte{0, 0, 0}, header...),
+		buf:     make([]byte, mtu+len(header)+3),
+	}, nil
+}
+
+// request connect the socks5 server, authenticate and send the command, return the conn and
+// the bound address of the reply
+func (c *socks5Client) request(cmd byte, addr string) (net.Conn, string, error) {
+	dst, err := socks5Addr(addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	conn, err := dialServer(c.forward, c.server, c.timeout)
+	if err != nil {
+		return nil, "", err
+	}
+
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	bound, err := c.handshake(conn, cmd, dst)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, bound, nil
+}
+
+func (c *socks5Client) handshake(conn net.Conn, cmd byte, dst []byte) (string, error) {
+	methods := []byte{socks5Version, 1, socks5AuthNone}
+	if c.username != "" {
+		methods = []byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", err
+	}
+	if buf[0] != socks5Version {
+		return "", fmt.Errorf("socks5: unexpected version %d", buf[0])
+	}
+
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if c.username == "" {
+			return "", errors.New("socks5: server require authentication")
+		}
+		if err := c.authenticate(conn); err != nil {
+			return "", err
+		}
+	case socks5AuthNoAccept:
+		return "", errors.New("socks5: no acceptable authentication method")
+	default:
+		return "", fmt.Errorf("socks5: unsupported authentication method %d", buf[1])
+	}
+
+	req := append([]byte{socks5Version, cmd, 0}, dst...)
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+
+	buf = make([]byte, 3)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", err
+	}
+	if buf[1] != 0 {
+		return "", fmt.Errorf("socks5: request fail, reply %d", buf[1])
+	}
+
+	return readSocks5Addr(conn)
+}
+
+// authenticate by username and password, RFC 1929
+func (c *socks5Client) authenticate(conn net.Conn) error {
+	if len(c.username) > 255 || len(c.password) > 255 {
+		return errors.New("socks5: username or password too long")
+	}
+
+	req := []byte{1, byte(len(c.username))}
+	req = append(req, c.username...)
+	req = append(req, byte(len(c.password)))
+	req = append(req, c.password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[1] != 0 {
+		return errors.New("socks5: authentication fail")
+	}
+	return nil
+}
+
+// socks5Addr encode the host:port in ATYP, ADDR, PORT
+func socks5Addr(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid port %s", portStr)
+	}
+
+	var b []byte
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks5: host too long %s", host)
+		}
+		b = append([]byte{socks5AtypDomain, byte(len(host))}, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{socks5AtypIPv4}, ip4...)
+	} else {
+		b = append([]byte{socks5AtypIPv6}, ip...)
+	}
+
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// readSocks5Addr read the ATYP, ADDR, PORT and return in host:port
+func readSocks5Addr(r io.Reader) (string, error) {
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch buf[0] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if buf[0] == socks5AtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		domain := make([]byte, buf[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("socks5: unsupported address type %d", buf[0])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socks5UDPConn relay the packets of a udp association, the packets are prefixed with the
+// socks5 udp header
+type socks5UDPConn struct {
+	*net.UDPConn
+	ctrl   net.Conn
+	header []byte
+	buf    []byte
+}
+
+func (c *socks5UDPConn) Write(b []byte) (int, error) {
+	packet := append(c.header[:len(c.header):len(c.header)], b...)
+	if _, err := c.UDPConn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read the packets from the relay, the fragments are dropped
+func (c *socks5UDPConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.UDPConn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+
+		offset, err := socks5UDPHeaderLen(c.buf[:n])
+		if err != nil || c.buf[2] != 0 {
+			continue
+		}
+		return copy(b, c.buf[offset:n]), nil
+	}
+}
+
+func (c *socks5UDPConn) Close() error {
+	c.ctrl.Close()
+	return c.UDPConn.Close()
+}
+
+// socks5UDPHeaderLen return the length of RSV, FRAG, ATYP, ADDR, PORT of the packet
+func socks5UDPHeaderLen(packet []byte) (int, error) {
+	if len(packet) < 3 {
+		return 0, errSocks5Addr
+	}
+
+	n, err := socks5AddrLen(packet[3:])
+	if err != nil {
+		return 0, err
+	}
+	return 3 + n, nil
+}
+
+// socks5AddrLen return the length of ATYP, ADDR, PORT at the beginning of b
+func socks5AddrLen(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, errSocks5Addr
+	}
+
+	var n int
+	switch b[0] {
+	case socks5AtypIPv4:
+		n = 1 + net.IPv4len + 2
+	case socks5AtypIPv6:
+		n = 1 + net.IPv6len + 2
+	case socks5AtypDomain:
+		if len(b) < 2 {
+			return 0, errSocks5Addr
+		}
+		n = 2 + int(b[1]) + 2
+	default:
+		return 0, errSocks5Addr
+	}
+
+	if len(b) < n {
+		return 0, errSocks5Addr
+	}
+	return n, nil
+}
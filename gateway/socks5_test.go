@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+)
+
+// fakeSocks5Server accept one request with the username and password auth, reply the CONNECT
+// with echo, and the UDP ASSOCIATE with an echo relay
+func fakeSocks5Server(t *testing.T, username, password string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 512)
+		io.ReadFull(conn, buf[:2])
+		io.ReadFull(conn, buf[:buf[1]])
+		conn.Write([]byte{socks5Version, socks5AuthPassword})
+
+		io.ReadFull(conn, buf[:2])
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != username || string(pass) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+
+		io.ReadFull(conn, buf[:3])
+		cmd := buf[1]
+		dst, err := readSocks5Addr(conn)
+		if err != nil {
+			return
+		}
+
+		switch cmd {
+		case socks5CmdConnect:
+			if dst != "example.com:80" {
+				return
+			}
+			conn.Write([]byte{socks5Version, 0, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+			io.Copy(conn, conn)
+		case socks5CmdUDPAssociate:
+			relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero})
+			if err != nil {
+				return
+			}
+			defer relay.Close()
+
+			bound, _ := socks5Addr(relay.LocalAddr().String())
+			conn.Write(append([]byte{socks5Version, 0, 0}, bound...))
+
+			go func() {
+				for {
+					n, addr, err := relay.ReadFromUDP(buf)
+					if err != nil {
+						return
+					}
+					relay.WriteToUDP(buf[:n], addr)
+				}
+			}()
+			io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	return ln
+}
+
+func TestSocks5ClientDial(t *testing.T) {
+	ln := fakeSocks5Server(t, "user", "pass")
+	defer ln.Close()
+
+	u, _ := url.Parse("socks5://user:pass@" + ln.Addr().String())
+	client := newSocks5Client(u)
+
+	conn, err := client.Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*net.TCPConn); !ok {
+		t.Fatal("conn should be tcp conn to forward")
+	}
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatal("should relay through the proxy", err)
+	}
+}
+
+func TestSocks5ClientAuthFail(t *testing.T) {
+	ln := fakeSocks5Server(t, "user", "pass")
+	defer ln.Close()
+
+	u, _ := url.Parse("socks5://user:wrong@" + ln.Addr().String())
+	if _, err := newSocks5Client(u).Dial("tcp", "example.com:80"); err == nil {
+		t.Fatal("wrong password should fail")
+	}
+}
+
+func TestSocks5ClientDialUDP(t *testing.T) {
+	ln := fakeSocks5Server(t, "user", "pass")
+	defer ln.Close()
+
+	u, _ := url.Parse("socks5://user:pass@" + ln.Addr().String())
+	conn, err := newSocks5Client(u).DialUDP("example.com:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the echo relay send back the packet with the header of the target
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, mtu)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte("ping")) {
+		t.Fatal("udp header should be stripped, got", buf[:n])
+	}
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// systemDNSStateFile keep the previous dns servers of the network services while the system
+// dns is set, a crashed process leave it and the servers are restored at the next start
+var systemDNSStateFile = "/var/db/kungfu-systemdns.json"
+
+// parseNetworkServices parse the output of networksetup -listallnetworkservices, the first
+// line is a note and the disabled services prefixed with * are skipped
+func parseNetworkServices(out string) []string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return nil
+	}
+
+	var services []string
+	for _, service := range lines[1:] {
+		service = strings.TrimSpace(service)
+		if service == "" || strings.HasPrefix(service, "*") {
+			continue
+		}
+		services = append(services, service)
+	}
+	return services
+}
+
+// parseDNSServers parse the output of networksetup -getdnsservers, "Empty" is returned if not
+// set as networksetup -setdnsservers take it to clear the servers
+func parseDNSServers(out string) []string {
+	// "There aren't any DNS Servers set on ..." if not set
+	if fields := strings.Fields(out); len(fields) > 0 && !strings.HasPrefix(out, "There aren't") {
+		return fields
+	}
+	return []string{"Empty"}
+}
+
+// loadSystemDNSState return the previous dns servers of the state file, nil if not exists
+func loadSystemDNSState(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var previous map[string][]string
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// saveSystemDNSState write the previous dns servers to the state file
+func saveSystemDNSState(path string, previous map[string][]string) error {
+	data, err := json.Marshal(previous)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
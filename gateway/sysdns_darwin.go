@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// setSystemDNS set the dns server of the enabled network services by networksetup, return
+// the func restore the previous servers. The previous servers are kept in the state file, the
+// servers left by a crashed process are restored first
+func setSystemDNS(server string) (func(), error) {
+	left, err := loadSystemDNSState(systemDNSStateFile)
+	if err != nil {
+		log.Warning("load system dns state error, %v", err)
+	}
+	if left != nil {
+		log.Info("restore the system dns left by the previous process")
+		restoreSystemDNS(left)
+		os.Remove(systemDNSStateFile)
+	}
+
+	out, err := exec.Command("networksetup", "-listallnetworkservices").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make(map[string][]string)
+	for _, service := range parseNetworkServices(string(out)) {
+		out, err := exec.Command("networksetup", "-getdnsservers", service).Output()
+		if err != nil {
+			log.Warning("get dns servers of %s error, %v", service, err)
+			continue
+		}
+		previous[service] = parseDNSServers(string(out))
+	}
+
+	// saved before changing, restoring a service not changed is harmless
+	if err := saveSystemDNSState(systemDNSStateFile, previous); err != nil {
+		log.Warning("save system dns state error, %v", err)
+	}
+
+	for service, servers := range previous {
+		if err := exec.Command("networksetup", "-setdnsservers", service, server).Run(); err != nil {
+			log.Warning("set dns servers of %s error, %v", service, err)
+			continue
+		}
+		log.Info("set system dns of %s to %s, previous: %s", service, server, strings.Join(servers, " "))
+	}
+
+	return func() {
+		restoreSystemDNS(previous)
+		os.Remove(systemDNSStateFile)
+	}, nil
+}
+
+// restoreSystemDNS set the dns servers of the services back
+func restoreSystemDNS(previous map[string][]string) {
+	for service, servers := range previous {
+		args := append([]string{"-setdnsservers", service}, servers...)
+		if err := exec.Command("networksetup", args...).Run(); err != nil {
+			log.Warning("restore dns servers of %s error, %v", service, err)
+			continue
+		}
+		log.Info("restore system dns of %s to %s", service, strings.Join(servers, " "))
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package gateway
+
+import (
+	"errors"
+	"runtime"
+)
+
+func setSystemDNS(server string) (func(), error) {
+	return nil, errors.New("set system dns is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNetworkServices(t *testing.T) {
+	out := `An asterisk (*) denotes that a network service is disabled.
+Wi-Fi
+*Bluetooth PAN
+Thunderbolt Bridge
+USB 10/100/1000 LAN
+`
+	expect := []string{"Wi-Fi", "Thunderbolt Bridge", "USB 10/100/1000 LAN"}
+	if services := parseNetworkServices(out); !reflect.DeepEqual(services, expect) {
+		t.Errorf("expect %q, got %q", expect, services)
+	}
+
+	if services := parseNetworkServices("An asterisk (*) denotes that a network service is disabled.\n"); services != nil {
+		t.Errorf("expect no services, got %q", services)
+	}
+}
+
+func TestParseDNSServers(t *testing.T) {
+	tests := []struct {
+		out    string
+		expect []string
+	}{
+		{"192.168.1.1\n8.8.8.8\n", []string{"192.168.1.1", "8.8.8.8"}},
+		{"There aren't any DNS Servers set on Wi-Fi.\n", []string{"Empty"}},
+		{"", []string{"Empty"}},
+	}
+
+	for _, test := range tests {
+		if servers := parseDNSServers(test.out); !reflect.DeepEqual(servers, test.expect) {
+			t.Errorf("%q: expect %q, got %q", test.out, test.expect, servers)
+		}
+	}
+}
+
+func TestSystemDNSState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kungfu-sysdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "systemdns.json")
+	previous, err := loadSystemDNSState(path)
+	if err != nil || previous != nil {
+		t.Fatalf("expect no state, got %v, %v", previous, err)
+	}
+
+	expect := map[string][]string{
+		"Wi-Fi":              {"192.168.1.1", "8.8.8.8"},
+		"Thunderbolt Bridge": {"Empty"},
+	}
+	if err := saveSystemDNSState(path, expect); err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err = loadSystemDNSState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(previous, expect) {
+		t.Errorf("expect %v, got %v", expect, previous)
+	}
+
+	ioutil.WriteFile(path, []byte("{"), 0644)
+	if _, err := loadSystemDNSState(path); err == nil {
+		t.Error("expect error of the broken state")
+	}
+}
@@ -0,0 +1,40 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+)
+
+// setSystemDNS set the dns server of the wintun adapter by netsh and block the dns queries of
+// the other interfaces by WFP, so the queries are not leaked outside the tunnel, return the
+// func removing the filters. The filters are dynamic, removed by the system if the process
+// crashed, the dns server of the adapter is removed with it
+func setSystemDNS(server string) (func(), error) {
+	ip := net.ParseIP(server)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid dns server %s", server)
+	}
+
+	family := "ipv4"
+	if ip.To4() == nil {
+		family = "ipv6"
+	}
+
+	cmd := []string{"netsh", "interface", family, "set", "dnsservers", "name=" + wintunName,
+		"source=static", "address=" + server, "register=none", "validate=no"}
+	if err := runCommand(cmd); err != nil {
+		return nil, err
+	}
+
+	filter, err := blockDNSLeak(wintunName, ip)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("set system dns of %s to %s, the dns of the other interfaces is blocked", wintunName, server)
+
+	return func() {
+		if err := filter.Close(); err != nil {
+			log.Warning("remove the dns filter error, %v", err)
+		}
+	}, nil
+}
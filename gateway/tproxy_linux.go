@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// the ipv6 options not defined in syscall
+const (
+	ipv6RecvOrigDstAddr = 0x4a
+	ipv6Transparent     = 0x4b
+)
+
+var errNoOrigDst = errors.New("original destination not found")
+
+// transparent return the control setting IP_TRANSPARENT, the socket can accept the connections
+// to the non-local address and bind the non-local address, the udp socket receive the original
+// destination if recvOrigDst
+func transparent(recvOrigDst bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		level, opts := syscall.SOL_IP, []int{syscall.IP_TRANSPARENT}
+		if recvOrigDst {
+			opts = append(opts, syscall.IP_RECVORIGDSTADDR)
+		}
+		if network[len(network)-1] == '6' {
+			level, opts = syscall.SOL_IPV6, []int{ipv6Transparent}
+			if recvOrigDst {
+				opts = append(opts, ipv6RecvOrigDstAddr)
+			}
+		}
+
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			// multiple reply sockets bind the same original destination
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			for _, opt := range opts {
+				if err != nil {
+					return
+				}
+				err = syscall.SetsockoptInt(int(fd), level, opt, 1)
+			}
+		}); e != nil {
+			return e
+		}
+		return err
+	}
+}
+
+// listenTransparentTCP listen the port of all the addresses for the connections redirected by
+// TPROXY, the local address of the accepted connection is the original destination
+func listenTransparentTCP(network string, port int) (*net.TCPListener, error) {
+	return listenTCP(network, tproxyAddr(network, port), transparent(false))
+}
+
+// listenTransparentUDP listen the port of all the addresses for the packets redirected by
+// TPROXY, the original destination is read by readTransparentUDP
+func listenTransparentUDP(network string, port int) (*net.UDPConn, error) {
+	lc := &net.ListenConfig{Control: transparent(true)}
+	conn, err := lc.ListenPacket(context.Background(), network, tproxyAddr(network, port))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// dialTransparentUDP bind the original destination, the replies sent by it come from the
+// original destination
+func dialTransparentUDP(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	lc := &net.ListenConfig{Control: transparent(false)}
+	conn, err := lc.ListenPacket(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// readTransparentUDP read the packet and the original destination from the control message
+func readTransparentUDP(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	oob := make([]byte, 64)
+	n, oobn, _, src, err := conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	for _, msg := range msgs {
+		// the data is sockaddr_in or sockaddr_in6, the port is in network byte order
+		if msg.Header.Level == syscall.SOL_IP && msg.Header.Type == syscall.IP_RECVORIGDSTADDR && len(msg.Data) >= 8 {
+			port := binary.BigEndian.Uint16(msg.Data[2:4])
+			return n, src, &net.UDPAddr{IP: net.IP(append([]byte(nil), msg.Data[4:8]...)), Port: int(port)}, nil
+		}
+		if msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == ipv6RecvOrigDstAddr && len(msg.Data) >= 24 {
+			port := binary.BigEndian.Uint16(msg.Data[2:4])
+			return n, src, &net.UDPAddr{IP: net.IP(append([]byte(nil), msg.Data[8:24]...)), Port: int(port)}, nil
+		}
+	}
+
+	return 0, nil, nil, errNoOrigDst
+}
+
+// tproxyAddr is the unspecified address of the network with the port
+func tproxyAddr(network string, port int) string {
+	ip := net.IPv4zero
+	if network[len(network)-1] == '6' {
+		ip = net.IPv6unspecified
+	}
+	return (&net.TCPAddr{IP: ip, Port: port}).String()
+}
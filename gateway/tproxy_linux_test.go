@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReadTransparentUDP(t *testing.T) {
+	server, err := listenTransparentUDP("udp4", 0)
+	if err != nil {
+		t.Skip("transparent socket need CAP_NET_ADMIN,", err)
+	}
+	defer server.Close()
+
+	port := server.LocalAddr().(*net.UDPAddr).Port
+	client, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the destination of the packet not redirected is the local address
+	buf := make([]byte, mtu)
+	n, src, dst, err := readTransparentUDP(server, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" || src.Port != client.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatal("unexpected packet", buf[:n], src)
+	}
+	if !dst.IP.Equal(net.IPv4(127, 0, 0, 1)) || dst.Port != port {
+		t.Fatal("unexpected original destination", dst)
+	}
+
+	g := &Gateway{tproxy: true}
+	session := g.relaySession(src.IP, src.Port, dst)
+	if session.dstPort != uint16(port) || len(session.dstIp) != net.IPv4len {
+		t.Fatal("unexpected session", session)
+	}
+}
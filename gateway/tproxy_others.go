@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package gateway
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+var errTProxyUnsupported = errors.New("tproxy is not supported on " + runtime.GOOS)
+
+func listenTransparentTCP(network string, port int) (*net.TCPListener, error) {
+	return nil, errTProxyUnsupported
+}
+
+func listenTransparentUDP(network string, port int) (*net.UDPConn, error) {
+	return nil, errTProxyUnsupported
+}
+
+func dialTransparentUDP(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, errTProxyUnsupported
+}
+
+func readTransparentUDP(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	return 0, nil, nil, errTProxyUnsupported
+}
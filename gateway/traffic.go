@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+// trafficFlushInterval is the interval of persisting the traffic to the store
+const trafficFlushInterval = time.Duration(time.Minute)
+
+// the kinds of the traffic accounting
+const (
+	trafficDomain = "domain"
+	trafficClient = "client"
+)
+
+type traffic struct {
+	upload   int64
+	download int64
+}
+
+// trafficStats accumulate the bytes relayed per domain and per client ip, the accumulated
+// bytes are added to the store on flush
+type trafficStats struct {
+	lock    sync.Mutex
+	domains map[string]*traffic
+	clients map[string]*traffic
+}
+
+func newTrafficStats() *trafficStats {
+	return &trafficStats{
+		domains: make(map[string]*traffic),
+		clients: make(map[string]*traffic),
+	}
+}
+
+// add the bytes of the connection, the client is the ip:port of the connection
+func (s *trafficStats) add(domain string, client string, upload int64, download int64) {
+	if upload == 0 && download == 0 {
+		return
+	}
+
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	if domain == "" {
+		domain = "unknown"
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, t := range []*traffic{trafficOf(s.domains, domain), trafficOf(s.clients, client)} {
+		t.upload += upload
+		t.download += download
+	}
+}
+
+func trafficOf(m map[string]*traffic, name string) *traffic {
+	t := m[name]
+	if t == nil {
+		t = &traffic{}
+		m[name] = t
+	}
+	return t
+}
+
+// flush add the accumulated bytes to the store and the bytes of the domains to the hourly stats,
+// the bytes failed to add are dropped
+func (s *trafficStats) flush(store internal.Store, hourly *internal.HourlyStats) {
+	s.lock.Lock()
+	domains, clients := s.domains, s.clients
+	s.domains = make(map[string]*traffic)
+	s.clients = make(map[string]*traffic)
+	s.lock.Unlock()
+
+	now := time.Now()
+	for domain, t := range domains {
+		hourly.Add(internal.StatsBytes, domain, t.upload+t.download, now)
+	}
+	if err := hourly.Flush(store); err != nil {
+		log.Warning("save hourly stats error, %v", err)
+	}
+
+	for kind, m := range map[string]map[string]*traffic{trafficDomain: domains, trafficClient: clients} {
+		for name, t := range m {
+			err := store.HIncrBy(internal.GetRedisTrafficKey(kind, "upload"), name, t.upload)
+			if err == nil {
+				err = store.HIncrBy(internal.GetRedisTrafficKey(kind, "download"), name, t.download)
+			}
+			if err != nil {
+				log.Warning("save traffic of %s %s error, %v", kind, name, err)
+			}
+		}
+	}
+}
+
+// trafficInfo is the json of the traffic of a domain or client
+type trafficInfo struct {
+	Name     string `json:"name"`
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+}
+
+// loadTraffic load the traffic of the kind from the store, in the order of total bytes desc
+func loadTraffic(store internal.Store, kind string) ([]trafficInfo, error) {
+	uploads, err := store.HGetAll(internal.GetRedisTrafficKey(kind, "upload"))
+	if err != nil {
+		return nil, err
+	}
+
+	downloads, err := store.HGetAll(internal.GetRedisTrafficKey(kind, "download"))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]trafficInfo, 0, len(uploads))
+	for name, upload := range uploads {
+		t := trafficInfo{Name: name}
+		t.Upload, _ = strconv.ParseInt(upload, 10, 64)
+		t.Download, _ = strconv.ParseInt(downloads[name], 10, 64)
+		list = append(list, t)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		ti, tj := list[i].Upload+list[i].Download, list[j].Upload+list[j].Download
+		if ti != tj {
+			return ti > tj
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// accountTraffic flush the traffic of the relayed connections periodically until shutdown
+func (g *Gateway) accountTraffic() {
+	ticker := time.NewTicker(trafficFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			g.flushTraffic()
+		}
+	}
+}
+
+// flushTraffic collect the bytes of the active connections and persist the traffic
+func (g *Gateway) flushTraffic() {
+	g.conns.collect()
+	g.traffic.flush(g.Store, g.hourly)
+}
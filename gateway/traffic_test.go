@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestTrafficAccounting(t *testing.T) {
+	store := internal.NewMemoryStore(&internal.Memory{})
+	stats := newTrafficStats()
+	table := newConnTable(stats)
+	hourly := internal.NewHourlyStats(0)
+
+	c1 := table.add(&trackedConn{domain: "google.com", client: "10.0.0.2:50001"})
+	c2 := table.add(&trackedConn{domain: "youtube.com", client: "10.0.0.2:50002"})
+	c3 := table.add(&trackedConn{domain: "google.com", client: "10.0.0.3:50001"})
+
+	atomic.AddInt64(&c1.upload, 100)
+	atomic.AddInt64(&c1.download, 1000)
+	atomic.AddInt64(&c2.download, 10)
+	table.collect()
+	stats.flush(store, hourly)
+
+	// the bytes after the collect are reported on remove
+	atomic.AddInt64(&c1.download, 1000)
+	atomic.AddInt64(&c3.upload, 5)
+	table.remove(c1)
+	table.remove(c3)
+	table.collect()
+	stats.flush(store, hourly)
+
+	domains, err := loadTraffic(store, trafficDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 || domains[0] != (trafficInfo{Name: "google.com", Upload: 105, Download: 2000}) ||
+		domains[1] != (trafficInfo{Name: "youtube.com", Download: 10}) {
+		t.Fatal("unexpected domain traffic", domains)
+	}
+
+	top, err := internal.TopStats(store, internal.StatsBytes, internal.StatsHours(time.Now().Add(-time.Hour), time.Now()), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0] != (internal.StatsCount{Name: "google.com", Count: 2105}) {
+		t.Fatal("unexpected hourly bytes", top)
+	}
+
+	clients, err := loadTraffic(store, trafficClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients[0] != (trafficInfo{Name: "10.0.0.2", Upload: 100, Download: 2010}) ||
+		clients[1] != (trafficInfo{Name: "10.0.0.3", Upload: 5}) {
+		t.Fatal("unexpected client traffic", clients)
+	}
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/net/websocket"
+)
+
+// streamTransport connect the proxy server of trojan, vmess and vless, by tcp, tls, and the
+// websocket in the tcp or tls, the options of the proxy url are
+//
+//	security       tls or none, tls for trojan
+//	sni (or peer)  the server name of tls, the host of the url if empty
+//	allowInsecure  1 or true to skip the verification of the certificate
+//	type           tcp (default) or ws, host and path for the host header and the path
+type streamTransport struct {
+	server string
+	// tls is the tls config, nil if not tls
+	tls *tls.Config
+	// ws is the location of the websocket, nil if not websocket
+	ws      *url.URL
+	timeout time.Duration
+	// forward connect the server, directly if nil
+	forward proxy.Dialer
+}
+
+func newStreamTransport(u *url.URL, security string) (*streamTransport, error) {
+	query := u.Query()
+	if s := query.Get("security"); s != "" {
+		security = s
+	}
+
+	t := &streamTransport{server: u.Host, timeout: socks5Timeout}
+	host := u.Hostname()
+	switch security {
+	case "tls":
+		sni := query.Get("sni")
+		if sni == "" {
+			sni = query.Get("peer")
+		}
+		if sni == "" {
+			sni = host
+		}
+		insecure := query.Get("allowInsecure")
+		t.tls = &tls.Config{ServerName: sni, InsecureSkipVerify: insecure == "1" || insecure == "true"}
+		host = sni
+	case "none":
+	default:
+		return nil, fmt.Errorf("unsupported security %s", security)
+	}
+
+	if u.Port() == "" {
+		port := "80"
+		if t.tls != nil {
+			port = "443"
+		}
+		t.server = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	switch query.Get("type") {
+	case "", "tcp":
+	case "ws":
+		scheme, path := "ws", query.Get("path")
+		if t.tls != nil {
+			scheme = "wss"
+		}
+		if h := query.Get("host"); h != "" {
+			host = h
+		}
+		if path == "" {
+			path = "/"
+		}
+		t.ws = &url.URL{Scheme: scheme, Host: host, Path: path}
+	default:
+		return nil, fmt.Errorf("unsupported transport %s", query.Get("type"))
+	}
+	return t, nil
+}
+
+// dial connect the server, the deadline of the handshake is set, cleared by the caller after
+// the request sent
+func (t *streamTransport) dial() (net.Conn, error) {
+	conn, err := dialServer(t.forward, t.server, t.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(t.timeout))
+
+	if t.tls != nil {
+		tc := tls.Client(conn, t.tls.Clone())
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake, %v", err)
+		}
+		conn = tc
+	}
+	if t.ws == nil {
+		return conn, nil
+	}
+
+	origin := "http://" + t.ws.Host
+	if t.tls != nil {
+		origin = "https://" + t.ws.Host
+	}
+	config, err := websocket.NewConfig(t.ws.String(), origin)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake, %v", err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	trojanCmdConnect      = 1
+	trojanCmdUDPAssociate = 3
+)
+
+var trojanCRLF = []byte{'\r', '\n'}
+
+// trojanClient is the trojan client, the proxy url is trojan://password@host:port, always
+// by tls, with the options of the stream transport
+type trojanClient struct {
+	*streamTransport
+	// key is the hex of the sha224 of the password
+	key []byte
+}
+
+func newTrojanClient(u *url.URL) (*trojanClient, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("trojan: password required")
+	}
+
+	// the password may contain the colon
+	password := u.User.Username()
+	if p, ok := u.User.Password(); ok {
+		password += ":" + p
+	}
+	sum := sha256.Sum224([]byte(password))
+
+	t, err := newStreamTransport(u, "tls")
+	if err != nil {
+		return nil, fmt.Errorf("trojan: %v", err)
+	}
+	if t.tls == nil {
+		return nil, errors.New("trojan: security should be tls")
+	}
+	return &trojanClient{streamTransport: t, key: []byte(hex.EncodeToString(sum[:]))}, nil
+}
+
+// Dial connect the addr through the trojan server, implement proxy.Dialer
+func (c *trojanClient) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("trojan: unsupported network %s", network)
+	}
+	return c.request(trojanCmdConnect, addr)
+}
+
+// DialUDP relay udp to the addr through the trojan server, the packets are in the tls stream
+func (c *trojanClient) DialUDP(addr string) (net.Conn, error) {
+	dst, err := socks5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.request(trojanCmdUDPAssociate, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &trojanUDPConn{Conn: conn, header: dst, r: bufio.NewReader(conn)}, nil
+}
+
+// request connect the trojan server and send the request of the command, the password key,
+// the command and the address
+func (c *trojanClient) request(cmd byte, addr string) (net.Conn, error) {
+	dst, err := socks5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("trojan: %v", err)
+	}
+
+	req := make([]byte, 0, len(c.key)+len(dst)+5)
+	req = append(append(req, c.key...), trojanCRLF...)
+	req = append(append(append(req, cmd), dst...), trojanCRLF...)
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// trojanUDPConn relay the packets in the stream, each packet is the address, the length, CRLF
+// and the payload
+type trojanUDPConn struct {
+	net.Conn
+	header []byte
+	r      *bufio.Reader
+}
+
+func (c *trojanUDPConn) Write(b []byte) (int, error) {
+	packet := make([]byte, 0, len(c.header)+4+len(b))
+	packet = append(append(packet, c.header...), byte(len(b)>>8), byte(len(b)))
+	packet = append(append(packet, trojanCRLF...), b...)
+	if _, err := c.Conn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read the packet, the payload exceeding b is dropped
+func (c *trojanUDPConn) Read(b []byte) (int, error) {
+	if _, err := readSocks5Addr(c.r); err != nil {
+		return 0, err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		return 0, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(head))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, err
+	}
+	return copy(b, payload), nil
+}
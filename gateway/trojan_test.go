@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// serveTrojan check the trojan request of the password, then echo the stream or the packets
+func serveTrojan(t *testing.T, conn io.ReadWriter, password string) {
+	r := bufio.NewReader(conn)
+	head := make([]byte, 56+2+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return
+	}
+
+	sum := sha256.Sum224([]byte(password))
+	if string(head[:56]) != hex.EncodeToString(sum[:]) {
+		t.Error("unexpected trojan password key")
+		return
+	}
+	if addr, err := readSocks5Addr(r); err != nil || addr != "example.com:443" {
+		t.Error("unexpected trojan address", addr, err)
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, 2)); err != nil {
+		return
+	}
+
+	// the udp packets are echoed as is, the address is the same
+	io.Copy(conn, r)
+}
+
+func TestTrojanClientDial(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serveTrojan(t, conn, "pass:word")
+			}()
+		}
+	}()
+
+	u, _ := url.Parse("trojan://pass:word@" + ln.Addr().String() + "?sni=example.com&allowInsecure=1")
+	c, err := newTrojanClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := c.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatal("should tunnel through the trojan server", err)
+	}
+
+	udp, err := c.DialUDP("example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	udp.Write([]byte("hello"))
+	buf = make([]byte, 16)
+	if n, err := udp.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatal("should relay the udp packet", string(buf[:n]), err)
+	}
+
+	// the certificate is self-signed
+	u, _ = url.Parse("trojan://pass:word@" + ln.Addr().String())
+	c, _ = newTrojanClient(u)
+	if _, err := c.Dial("tcp", "example.com:443"); err == nil {
+		t.Fatal("should fail to verify the self-signed certificate")
+	}
+}
+
+func TestTrojanClientWebsocket(t *testing.T) {
+	var path string
+	srv := httptest.NewTLSServer(websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			path = r.URL.Path
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			serveTrojan(t, ws, "secret")
+		},
+	})
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	u, _ := url.Parse("trojan://secret@" + net.JoinHostPort(host, port) + "?type=ws&path=/trojan&allowInsecure=1")
+	c, err := newTrojanClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := c.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatal("should tunnel through the websocket", err)
+	}
+	if path != "/trojan" {
+		t.Fatal("unexpected websocket path", path)
+	}
+}
+
+func TestNewTrojanClient(t *testing.T) {
+	u, _ := url.Parse("trojan://1.2.3.4")
+	if _, err := newTrojanClient(u); err == nil {
+		t.Fatal("password should be required")
+	}
+
+	u, _ = url.Parse("trojan://secret@example.com?peer=cdn.example.com&type=grpc")
+	if _, err := newTrojanClient(u); err == nil {
+		t.Fatal("grpc transport should be unsupported")
+	}
+
+	u, _ = url.Parse("trojan://secret@example.com?peer=cdn.example.com")
+	c, err := newTrojanClient(u)
+	if err != nil || c.server != "example.com:443" || c.tls.ServerName != "cdn.example.com" || c.tls.InsecureSkipVerify {
+		t.Fatalf("unexpected client %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// tunDevice is the tun of the platform, the water interface or the wintun adapter on windows
+type tunDevice interface {
+	io.ReadWriteCloser
+	Name() string
+}
+
+// tunCommands is the commands configuring the tun of a platform, up is run in order, down
+// remove the routes added by up and is run on reconfig and shutdown
+type tunCommands struct {
+	up   [][]string
+	down [][]string
+}
+
+// linuxTunCommands return the commands of the tun on linux, the route of the network is
+// replaced explicitly so that it survive the address flush
+func linuxTunCommands(name, network, network6 string) (*tunCommands, error) {
+	ip, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("parse tun network error %v", err)
+	}
+
+	c := &tunCommands{}
+	c.up = append(c.up,
+		[]string{"ip", "addr", "flush", "dev", name},
+		[]string{"ip", "addr", "add", network, "dev", name},
+	)
+	if network6 != "" {
+		if _, _, err := net.ParseCIDR(network6); err != nil {
+			return nil, fmt.Errorf("parse tun ipv6 network error %v", err)
+		}
+		c.up = append(c.up, []string{"ip", "-6", "addr", "add", network6, "dev", name})
+	}
+	c.up = append(c.up,
+		[]string{"ip", "link", "set", "dev", name, "up", "mtu", fmt.Sprint(mtu), "qlen", "1000"},
+		[]string{"ip", "route", "replace", ipnet.String(), "dev", name, "src", ip.String()},
+	)
+	c.down = append(c.down, []string{"ip", "route", "del", ipnet.String(), "dev", name})
+
+	if network6 != "" {
+		_, ipnet6, _ := net.ParseCIDR(network6)
+		c.up = append(c.up, []string{"ip", "-6", "route", "replace", ipnet6.String(), "dev", name})
+		c.down = append(c.down, []string{"ip", "-6", "route", "del", ipnet6.String(), "dev", name})
+	}
+	return c, nil
+}
+
+// darwinTunCommands return the commands of the utun on macOS, the utun is point to point so
+// the network is routed to it
+func darwinTunCommands(name, network, network6 string) (*tunCommands, error) {
+	ip, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("parse tun network error %v", err)
+	}
+
+	c := &tunCommands{}
+	c.up = append(c.up,
+		[]string{"ifconfig", name, "inet", ip.String(), ip.String(), "netmask", net.IP(ipnet.Mask).String(),
+			"mtu", fmt.Sprint(mtu), "up"},
+		[]string{"route", "-n", "add", "-net", ipnet.String(), "-interface", name},
+	)
+	c.down = append(c.down, []string{"route", "-n", "delete", "-net", ipnet.String(), "-interface", name})
+
+	if network6 != "" {
+		ip6, ipnet6, err := net.ParseCIDR(network6)
+		if err != nil {
+			return nil, fmt.Errorf("parse tun ipv6 network error %v", err)
+		}
+		prefix, _ := ipnet6.Mask.Size()
+		c.up = append(c.up,
+			[]string{"ifconfig", name, "inet6", ip6.String(), "prefixlen", fmt.Sprint(prefix)},
+			[]string{"route", "-n", "add", "-inet6", ipnet6.String(), "-interface", name},
+		)
+		c.down = append(c.down, []string{"route", "-n", "delete", "-inet6", ipnet6.String(), "-interface", name})
+	}
+	return c, nil
+}
+
+// windowsTunCommands return the netsh commands of the wintun adapter, the args are not split
+// as the adapter name may contain spaces
+func windowsTunCommands(name, network, network6 string) (*tunCommands, error) {
+	ip, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("parse tun network error %v", err)
+	}
+
+	c := &tunCommands{}
+	c.up = append(c.up,
+		[]string{"netsh", "interface", "ipv4", "set", "address", "name=" + name, "static",
+			ip.String(), net.IP(ipnet.Mask).String()},
+		[]string{"netsh", "interface", "ipv4", "set", "subinterface", name, fmt.Sprintf("mtu=%d", mtu), "store=active"},
+		[]string{"netsh", "interface", "ipv4", "add", "route", "prefix=" + ipnet.String(), "interface=" + name,
+			"store=active"},
+	)
+	c.down = append(c.down, []string{"netsh", "interface", "ipv4", "delete", "route", "prefix=" + ipnet.String(),
+		"interface=" + name, "store=active"})
+
+	if network6 != "" {
+		_, ipnet6, err := net.ParseCIDR(network6)
+		if err != nil {
+			return nil, fmt.Errorf("parse tun ipv6 network error %v", err)
+		}
+		c.up = append(c.up,
+			[]string{"netsh", "interface", "ipv6", "add", "address", name, network6, "store=active"},
+			[]string{"netsh", "interface", "ipv6", "add", "route", "prefix=" + ipnet6.String(), "interface=" + name,
+				"store=active"},
+		)
+		c.down = append(c.down, []string{"netsh", "interface", "ipv6", "delete", "route", "prefix=" + ipnet6.String(),
+			"interface=" + name, "store=active"})
+	}
+	return c, nil
+}
+
+// runCommand run the command, the output is included in the error
+var runCommand = func(cmd []string) error {
+	log.Debug("execute cmd %s", strings.Join(cmd, " "))
+	out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v, %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// applyTun remove the routes of the previous config and run the commands of the new one
+func (g *Gateway) applyTun(c *tunCommands, err error) {
+	g.removeTunRoutes()
+	if err != nil {
+		log.Warning("config tun error %v", err)
+		return
+	}
+
+	for _, cmd := range c.up {
+		if err := runCommand(cmd); err != nil {
+			log.Warning("config tun, %s error %v", strings.Join(cmd, " "), err)
+		}
+	}
+	g.tunRoutes = c.down
+}
+
+// removeTunRoutes remove the routes added to the tun, it's fine if the route is gone already
+func (g *Gateway) removeTunRoutes() {
+	for _, cmd := range g.tunRoutes {
+		if err := runCommand(cmd); err != nil {
+			log.Debug("remove tun route, %s error %v", strings.Join(cmd, " "), err)
+		}
+	}
+	g.tunRoutes = nil
+}
@@ -0,0 +1,16 @@
+package gateway
+
+import (
+	"github.com/songgao/water"
+)
+
+// newTun create the utun, the name is assigned by the system
+func newTun(network string) (tunDevice, error) {
+	return water.New(water.Config{DeviceType: water.TUN})
+}
+
+// configTun assign the relay ip to the utun and route the network to it, the routes of the
+// previous config are removed
+func (g *Gateway) configTun() {
+	g.applyTun(darwinTunCommands(g.ifce.Name(), g.network, g.network6))
+}
@@ -0,0 +1,20 @@
+package gateway
+
+import (
+	"github.com/songgao/water"
+)
+
+const tunName = "tun-kungfu-01"
+
+func newTun(network string) (tunDevice, error) {
+	config := water.Config{
+		DeviceType: water.TUN,
+	}
+	config.Name = tunName
+	return water.New(config)
+}
+
+// configTun assign the relay ip to the tun and route the network to it
+func (g *Gateway) configTun() {
+	g.applyTun(linuxTunCommands(g.ifce.Name(), g.network, g.network6))
+}
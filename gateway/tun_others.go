@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package gateway
+
+import (
+	"errors"
+	"runtime"
+)
+
+func newTun(network string) (tunDevice, error) {
+	return nil, errors.New("tun is not supported on " + runtime.GOOS)
+}
+
+func (g *Gateway) configTun() {
+}
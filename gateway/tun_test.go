@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func joinCommands(cmds [][]string) []string {
+	lines := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		lines = append(lines, strings.Join(cmd, " "))
+	}
+	return lines
+}
+
+func TestTunCommands(t *testing.T) {
+	tests := []struct {
+		goos     string
+		build    func(name, network, network6 string) (*tunCommands, error)
+		network6 string
+		up       []string
+		down     []string
+	}{
+		{
+			goos:  "linux",
+			build: linuxTunCommands,
+			up: []string{
+				"ip addr flush dev tun0",
+				"ip addr add 10.85.0.1/16 dev tun0",
+				"ip link set dev tun0 up mtu 1500 qlen 1000",
+				"ip route replace 10.85.0.0/16 dev tun0 src 10.85.0.1",
+			},
+			down: []string{"ip route del 10.85.0.0/16 dev tun0"},
+		},
+		{
+			goos:     "linux",
+			build:    linuxTunCommands,
+			network6: "fd85::1/112",
+			up: []string{
+				"ip addr flush dev tun0",
+				"ip addr add 10.85.0.1/16 dev tun0",
+				"ip -6 addr add fd85::1/112 dev tun0",
+				"ip link set dev tun0 up mtu 1500 qlen 1000",
+				"ip route replace 10.85.0.0/16 dev tun0 src 10.85.0.1",
+				"ip -6 route replace fd85::/112 dev tun0",
+			},
+			down: []string{
+				"ip route del 10.85.0.0/16 dev tun0",
+				"ip -6 route del fd85::/112 dev tun0",
+			},
+		},
+		{
+			goos:     "darwin",
+			build:    darwinTunCommands,
+			network6: "fd85::1/112",
+			up: []string{
+				"ifconfig tun0 inet 10.85.0.1 10.85.0.1 netmask 255.255.0.0 mtu 1500 up",
+				"route -n add -net 10.85.0.0/16 -interface tun0",
+				"ifconfig tun0 inet6 fd85::1 prefixlen 112",
+				"route -n add -inet6 fd85::/112 -interface tun0",
+			},
+			down: []string{
+				"route -n delete -net 10.85.0.0/16 -interface tun0",
+				"route -n delete -inet6 fd85::/112 -interface tun0",
+			},
+		},
+		{
+			goos:     "windows",
+			build:    windowsTunCommands,
+			network6: "fd85::1/112",
+			up: []string{
+				"netsh interface ipv4 set address name=tun0 static 10.85.0.1 255.255.0.0",
+				"netsh interface ipv4 set subinterface tun0 mtu=1500 store=active",
+				"netsh interface ipv4 add route prefix=10.85.0.0/16 interface=tun0 store=active",
+				"netsh interface ipv6 add address tun0 fd85::1/112 store=active",
+				"netsh interface ipv6 add route prefix=fd85::/112 interface=tun0 store=active",
+			},
+			down: []string{
+				"netsh interface ipv4 delete route prefix=10.85.0.0/16 interface=tun0 store=active",
+				"netsh interface ipv6 delete route prefix=fd85::/112 interface=tun0 store=active",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		c, err := test.build("tun0", "10.85.0.1/16", test.network6)
+		if err != nil {
+			t.Fatalf("%s: %v", test.goos, err)
+		}
+		if up := joinCommands(c.up); !reflect.DeepEqual(up, test.up) {
+			t.Errorf("%s: expect up %q, got %q", test.goos, test.up, up)
+		}
+		if down := joinCommands(c.down); !reflect.DeepEqual(down, test.down) {
+			t.Errorf("%s: expect down %q, got %q", test.goos, test.down, down)
+		}
+
+		if _, err := test.build("tun0", "10.85.0.1", ""); err == nil {
+			t.Errorf("%s: expect error of the invalid network", test.goos)
+		}
+	}
+}
+
+func TestApplyTun(t *testing.T) {
+	var ran []string
+	defer func(f func([]string) error) { runCommand = f }(runCommand)
+	runCommand = func(cmd []string) error {
+		ran = append(ran, strings.Join(cmd, " "))
+		return nil
+	}
+
+	g := &Gateway{}
+	g.applyTun(linuxTunCommands("tun0", "10.85.0.1/16", ""))
+	g.applyTun(linuxTunCommands("tun0", "10.86.0.1/16", ""))
+	g.removeTunRoutes()
+
+	expect := []string{
+		"ip addr flush dev tun0",
+		"ip addr add 10.85.0.1/16 dev tun0",
+		"ip link set dev tun0 up mtu 1500 qlen 1000",
+		"ip route replace 10.85.0.0/16 dev tun0 src 10.85.0.1",
+		"ip route del 10.85.0.0/16 dev tun0",
+		"ip addr flush dev tun0",
+		"ip addr add 10.86.0.1/16 dev tun0",
+		"ip link set dev tun0 up mtu 1500 qlen 1000",
+		"ip route replace 10.86.0.0/16 dev tun0 src 10.86.0.1",
+		"ip route del 10.86.0.0/16 dev tun0",
+	}
+	if !reflect.DeepEqual(ran, expect) {
+		t.Errorf("expect commands %q, got %q", expect, ran)
+	}
+	if g.tunRoutes != nil {
+		t.Errorf("expect the routes removed, got %q", g.tunRoutes)
+	}
+}
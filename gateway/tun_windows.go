@@ -0,0 +1,12 @@
+package gateway
+
+// newTun create the wintun adapter, wintun.dll is required next to the executable
+func newTun(network string) (tunDevice, error) {
+	return newWintun(wintunName)
+}
+
+// configTun assign the relay ip to the adapter by netsh and route the network to it, the
+// routes of the previous config are removed
+func (g *Gateway) configTun() {
+	g.applyTun(windowsTunCommands(g.ifce.Name(), g.network, g.network6))
+}
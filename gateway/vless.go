@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	v2rayCmdTCP = 1
+
+	v2rayAtypIPv4   = 1
+	v2rayAtypDomain = 2
+	v2rayAtypIPv6   = 3
+)
+
+// vlessClient is the vless client of v2ray and xray, the proxy url is
+// vless://uuid@host:port?security=tls&type=ws&path=/ws, with the options of the stream
+// transport, none security by default, the flows (xtls) are not supported
+type vlessClient struct {
+	*streamTransport
+	id []byte
+}
+
+func newVlessClient(u *url.URL) (*vlessClient, error) {
+	if u.User == nil {
+		return nil, errors.New("vless: uuid required")
+	}
+	id, err := parseUUID(u.User.Username())
+	if err != nil {
+		return nil, fmt.Errorf("vless: %v", err)
+	}
+	if flow := u.Query().Get("flow"); flow != "" {
+		return nil, fmt.Errorf("vless: unsupported flow %s", flow)
+	}
+
+	t, err := newStreamTransport(u, "none")
+	if err != nil {
+		return nil, fmt.Errorf("vless: %v", err)
+	}
+	return &vlessClient{streamTransport: t, id: id}, nil
+}
+
+// Dial connect the addr through the vless server, implement proxy.Dialer
+func (c *vlessClient) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("vless: unsupported network %s", network)
+	}
+
+	dst, err := v2rayAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("vless: %v", err)
+	}
+
+	// version, uuid, no addons, command, port, address
+	req := make([]byte, 0, 19+len(dst))
+	req = append(append(append(req, 0), c.id...), 0, v2rayCmdTCP)
+	if _, err := conn.Write(append(req, dst...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &vlessConn{Conn: conn}, nil
+}
+
+// vlessConn skip the response header, the version and the addons, before the data of the
+// target, the request is sent without waiting the response
+type vlessConn struct {
+	net.Conn
+	received bool
+}
+
+func (c *vlessConn) Read(b []byte) (int, error) {
+	if !c.received {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(c.Conn, head); err != nil {
+			return 0, err
+		}
+		if head[0] != 0 {
+			return 0, fmt.Errorf("vless: unexpected response version %d", head[0])
+		}
+		if _, err := io.CopyN(ioutil.Discard, c.Conn, int64(head[1])); err != nil {
+			return 0, err
+		}
+		c.received = true
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *vlessConn) CloseRead() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return nil
+}
+
+func (c *vlessConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+// parseUUID parse the uuid of the user, 32 hex digits with or without the hyphens
+func parseUUID(s string) ([]byte, error) {
+	id, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(id) != 16 {
+		return nil, fmt.Errorf("invalid uuid %q", s)
+	}
+	return id, nil
+}
+
+// v2rayAddr encode the host:port in PORT, ATYP, ADDR of vless and vmess
+func v2rayAddr(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", portStr)
+	}
+
+	b := []byte{byte(port >> 8), byte(port)}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host too long %s", host)
+		}
+		b = append(append(b, v2rayAtypDomain, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(append(b, v2rayAtypIPv4), ip4...)
+	} else {
+		b = append(append(b, v2rayAtypIPv6), ip...)
+	}
+	return b, nil
+}
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// serveVless check the vless request of the uuid, then respond and echo
+func serveVless(t *testing.T, conn io.ReadWriter) {
+	id, _ := parseUUID(testUUID)
+	head := make([]byte, 19)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	if head[0] != 0 || !bytes.Equal(head[1:17], id) || head[17] != 0 || head[18] != v2rayCmdTCP {
+		t.Error("unexpected vless request", head)
+		return
+	}
+
+	dst, _ := v2rayAddr("example.com:443")
+	addr := make([]byte, len(dst))
+	if _, err := io.ReadFull(conn, addr); err != nil || !bytes.Equal(addr, dst) {
+		t.Error("unexpected vless address", addr)
+		return
+	}
+
+	// the response with an addon byte
+	conn.Write([]byte{0, 1, 0})
+	io.Copy(conn, conn)
+}
+
+func TestVlessClientDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveVless(t, conn)
+	}()
+
+	u, _ := url.Parse("vless://" + testUUID + "@" + ln.Addr().String())
+	c, err := newVlessClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := c.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatal("should tunnel through the vless server", err)
+	}
+}
+
+func TestVlessClientWebsocketTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(websocket.Handler(func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		serveVless(t, ws)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse("vless://" + testUUID + "@" + srv.Listener.Addr().String() + "?security=tls&allowInsecure=1&type=ws&path=/ws")
+	c, err := newVlessClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := c.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatal("should tunnel through the websocket", err)
+	}
+}
+
+func TestNewVlessClient(t *testing.T) {
+	for _, proxy := range []string{
+		"vless://1.2.3.4:443",
+		"vless://not-uuid@1.2.3.4:443",
+		"vless://" + testUUID + "@1.2.3.4:443?flow=xtls-rprx-vision",
+		"vless://" + testUUID + "@1.2.3.4:443?security=reality",
+	} {
+		u, _ := url.Parse(proxy)
+		if _, err := newVlessClient(u); err == nil {
+			t.Fatal("should be invalid", proxy)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package gateway
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	vmessVersion = 1
+	// vmessOptionChunkStream is the option of the body in the chunks, the length not masked
+	vmessOptionChunkStream = 1
+	vmessSecurityAES128GCM = 3
+	// vmessMaxChunk is the max payload of a chunk of the body
+	vmessMaxChunk = 8192 - 16 - 2
+)
+
+// the kdf salts of the aead header of vmess
+const (
+	vmessKDFSalt           = "VMess AEAD KDF"
+	vmessAuthIDKey         = "AES Auth ID Encryption"
+	vmessHeaderKey         = "VMess Header AEAD Key"
+	vmessHeaderNonce       = "VMess Header AEAD Nonce"
+	vmessHeaderLengthKey   = "VMess Header AEAD Key_Length"
+	vmessHeaderLengthNonce = "VMess Header AEAD Nonce_Length"
+	vmessRespLengthKey     = "AEAD Resp Header Len Key"
+	vmessRespLengthIV      = "AEAD Resp Header Len IV"
+	vmessRespKey           = "AEAD Resp Header Key"
+	vmessRespIV            = "AEAD Resp Header IV"
+)
+
+var vmessCmdKeySalt = []byte("c48619fe-8f02-49e0-b9e9-edf763e17e21")
+
+// vmessClient is the vmess client of v2ray and xray, the proxy url is
+// vmess://uuid@host:port?security=tls&type=ws&path=/ws, with the options of the stream
+// transport, none security by default, only the aead header (alterId 0) and the aes-128-gcm
+// body are supported
+type vmessClient struct {
+	*streamTransport
+	cmdKey []byte
+}
+
+func newVmessClient(u *url.URL) (*vmessClient, error) {
+	if u.User == nil {
+		return nil, errors.New("vmess: uuid required")
+	}
+	id, err := parseUUID(u.User.Username())
+	if err != nil {
+		return nil, fmt.Errorf("vmess: %v", err)
+	}
+
+	query := u.Query()
+	if aid := query.Get("alterId"); aid != "" && aid != "0" {
+		return nil, fmt.Errorf("vmess: unsupported alterId %s, only the aead header (alterId 0)", aid)
+	}
+	switch encryption := query.Get("encryption"); encryption {
+	case "", "auto", "aes-128-gcm":
+	default:
+		return nil, fmt.Errorf("vmess: unsupported encryption %s, only aes-128-gcm", encryption)
+	}
+
+	t, err := newStreamTransport(u, "none")
+	if err != nil {
+		return nil, fmt.Errorf("vmess: %v", err)
+	}
+
+	cmdKey := md5.Sum(append(id, vmessCmdKeySalt...))
+	return &vmessClient{streamTransport: t, cmdKey: cmdKey[:]}, nil
+}
+
+// Dial connect the addr through the vmess server, implement proxy.Dialer
+func (c *vmessClient) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("vmess: unsupported network %s", network)
+	}
+
+	dst, err := v2rayAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the body key and iv, and the response check byte
+	secret := make([]byte, 33)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	iv, key, check := secret[:16], secret[16:32], secret[32]
+
+	header := make([]byte, 0, 45+len(dst))
+	header = append(append(append(header, vmessVersion), iv...), key...)
+	header = append(header, check, vmessOptionChunkStream, vmessSecurityAES128GCM, 0, v2rayCmdTCP)
+	header = append(header, dst...)
+	sum := fnv.New32a()
+	sum.Write(header)
+	header = sum.Sum(header)
+
+	req, err := sealVmessHeader(c.cmdKey, header, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("vmess: %v", err)
+	}
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return newVmessConn(conn, key, iv, check)
+}
+
+// vmessKDF is the nested hmac-sha256 of the salt and the path
+func vmessKDF(key []byte, path ...string) []byte {
+	create := func() hash.Hash { return hmac.New(sha256.New, []byte(vmessKDFSalt)) }
+	for _, p := range path {
+		parent, value := create, []byte(p)
+		create = func() hash.Hash { return hmac.New(parent, value) }
+	}
+
+	h := create()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealVmessHeader encrypt the request header, the auth id, the encrypted length, the
+// connection nonce and the encrypted header
+func sealVmessHeader(cmdKey []byte, header []byte, now time.Time) ([]byte, error) {
+	authID := make([]byte, 16)
+	binary.BigEndian.PutUint64(authID, uint64(now.Unix()))
+	if _, err := rand.Read(authID[8:12]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(authID[12:], crc32.ChecksumIEEE(authID[:12]))
+	block, err := aes.NewCipher(vmessKDF(cmdKey, vmessAuthIDKey)[:16])
+	if err != nil {
+		return nil, err
+	}
+	block.Encrypt(authID, authID)
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	length := []byte{byte(len(header) >> 8), byte(len(header))}
+	lengthAEAD, err := newAESGCM(vmessKDF(cmdKey, vmessHeaderLengthKey, string(authID), string(nonce))[:16])
+	if err != nil {
+		return nil, err
+	}
+	headerAEAD, err := newAESGCM(vmessKDF(cmdKey, vmessHeaderKey, string(authID), string(nonce))[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(nil), authID...)
+	out = lengthAEAD.Seal(out, vmessKDF(cmdKey, vmessHeaderLengthNonce, string(authID), string(nonce))[:12], length, authID)
+	out = append(out, nonce...)
+	out = headerAEAD.Seal(out, vmessKDF(cmdKey, vmessHeaderNonce, string(authID), string(nonce))[:12], header, authID)
+	return out, nil
+}
+
+// vmessConn is the body of vmess in the aes-128-gcm chunks, each chunk is the length of the
+// encrypted payload and the encrypted payload, the empty chunk is the end of the stream
+type vmessConn struct {
+	net.Conn
+
+	enc      cipher.AEAD
+	encIV    []byte
+	encCount uint16
+	dec      cipher.AEAD
+	decIV    []byte
+	decCount uint16
+
+	// respKey, respIV and check is for the response header, read before the body
+	respKey  []byte
+	respIV   []byte
+	check    byte
+	received bool
+	// pending is the decrypted payload not read yet
+	pending []byte
+}
+
+func newVmessConn(conn net.Conn, key []byte, iv []byte, check byte) (*vmessConn, error) {
+	enc, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	respKey, respIV := sha256.Sum256(key), sha256.Sum256(iv)
+	dec, err := newAESGCM(respKey[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	return &vmessConn{
+		Conn:    conn,
+		enc:     enc,
+		encIV:   iv,
+		dec:     dec,
+		decIV:   respIV[:16],
+		respKey: respKey[:16],
+		respIV:  respIV[:16],
+		check:   check,
+	}, nil
+}
+
+// chunkNonce return the nonce of the chunk, the count and the iv
+func chunkNonce(iv []byte, count uint16) []byte {
+	nonce := append([]byte(nil), iv[:12]...)
+	binary.BigEndian.PutUint16(nonce, count)
+	return nonce
+}
+
+func (c *vmessConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		// the empty chunk is the end of the stream
+		return 0, nil
+	}
+	n := 0
+	for {
+		size := len(b) - n
+		if size > vmessMaxChunk {
+			size = vmessMaxChunk
+		}
+		if err := c.writeChunk(b[n : n+size]); err != nil {
+			return n, err
+		}
+		if n += size; n >= len(b) {
+			return n, nil
+		}
+	}
+}
+
+func (c *vmessConn) writeChunk(payload []byte) error {
+	chunk := make([]byte, 2, 2+len(payload)+c.enc.Overhead())
+	chunk = c.enc.Seal(chunk, chunkNonce(c.encIV, c.encCount), payload, nil)
+	binary.BigEndian.PutUint16(chunk, uint16(len(chunk)-2))
+	c.encCount++
+
+	_, err := c.Conn.Write(chunk)
+	return err
+}
+
+func (c *vmessConn) Read(b []byte) (int, error) {
+	if !c.received {
+		if err := c.readResponseHeader(); err != nil {
+			return 0, err
+		}
+		c.received = true
+	}
+
+	if len(c.pending) == 0 {
+		size := make([]byte, 2)
+		if _, err := io.ReadFull(c.Conn, size); err != nil {
+			return 0, err
+		}
+
+		chunk := make([]byte, binary.BigEndian.Uint16(size))
+		if _, err := io.ReadFull(c.Conn, chunk); err != nil {
+			return 0, err
+		}
+
+		payload, err := c.dec.Open(chunk[:0], chunkNonce(c.decIV, c.decCount), chunk, nil)
+		if err != nil {
+			return 0, errors.New("vmess: invalid chunk")
+		}
+		c.decCount++
+		if len(payload) == 0 {
+			return 0, io.EOF
+		}
+		c.pending = payload
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readResponseHeader read the encrypted length and the encrypted response header, check the
+// response check byte
+func (c *vmessConn) readResponseHeader() error {
+	lengthAEAD, err := newAESGCM(vmessKDF(c.respKey, vmessRespLengthKey)[:16])
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 2+lengthAEAD.Overhead())
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return err
+	}
+	length, err := lengthAEAD.Open(nil, vmessKDF(c.respIV, vmessRespLengthIV)[:12], buf, nil)
+	if err != nil {
+		return errors.New("vmess: invalid response header")
+	}
+
+	headerAEAD, err := newAESGCM(vmessKDF(c.respKey, vmessRespKey)[:16])
+	if err != nil {
+		return err
+	}
+	buf = make([]byte, int(binary.BigEndian.Uint16(length))+headerAEAD.Overhead())
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return err
+	}
+	header, err := headerAEAD.Open(nil, vmessKDF(c.respIV, vmessRespIV)[:12], buf, nil)
+	if err != nil || len(header) < 4 || header[0] != c.check {
+		return errors.New("vmess: invalid response header")
+	}
+	return nil
+}
+
+func (c *vmessConn) CloseRead() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return nil
+}
+
+// CloseWrite send the empty chunk, the end of the stream
+func (c *vmessConn) CloseWrite() error {
+	if err := c.writeChunk(nil); err != nil {
+		return err
+	}
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
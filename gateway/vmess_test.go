@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// serveVmess open the vmess request header of the uuid, then respond and echo the body
+func serveVmess(t *testing.T, conn net.Conn) {
+	id, _ := parseUUID(testUUID)
+	c, _ := newVmessClient(&url.URL{User: url.User(testUUID), Host: "127.0.0.1"})
+	cmdKey := c.cmdKey
+
+	authID := make([]byte, 16)
+	if _, err := io.ReadFull(conn, authID); err != nil {
+		return
+	}
+	block, _ := aes.NewCipher(vmessKDF(cmdKey, vmessAuthIDKey)[:16])
+	plain := make([]byte, 16)
+	block.Decrypt(plain, authID)
+	if crc32.ChecksumIEEE(plain[:12]) != binary.BigEndian.Uint32(plain[12:]) ||
+		time.Since(time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)) > time.Minute {
+		t.Error("unexpected vmess auth id", id)
+		return
+	}
+
+	buf := make([]byte, 18+8)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	nonce := buf[18:]
+	lengthAEAD, _ := newAESGCM(vmessKDF(cmdKey, vmessHeaderLengthKey, string(authID), string(nonce))[:16])
+	length, err := lengthAEAD.Open(nil, vmessKDF(cmdKey, vmessHeaderLengthNonce, string(authID), string(nonce))[:12], buf[:18], authID)
+	if err != nil {
+		t.Error("open vmess header length", err)
+		return
+	}
+
+	buf = make([]byte, int(binary.BigEndian.Uint16(length))+16)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	headerAEAD, _ := newAESGCM(vmessKDF(cmdKey, vmessHeaderKey, string(authID), string(nonce))[:16])
+	header, err := headerAEAD.Open(nil, vmessKDF(cmdKey, vmessHeaderNonce, string(authID), string(nonce))[:12], buf, authID)
+	if err != nil {
+		t.Error("open vmess header", err)
+		return
+	}
+
+	sum := fnv.New32a()
+	sum.Write(header[:len(header)-4])
+	dst, _ := v2rayAddr("example.com:443")
+	if header[0] != vmessVersion || header[34] != vmessOptionChunkStream || header[35] != vmessSecurityAES128GCM ||
+		header[37] != v2rayCmdTCP || !bytes.Equal(header[38:len(header)-4], dst) ||
+		!bytes.Equal(sum.Sum(nil), header[len(header)-4:]) {
+		t.Error("unexpected vmess header", header)
+		return
+	}
+
+	// the response header, then the body echoed by the response key
+	iv, key, check := header[1:17], header[17:33], header[33]
+	respKey, respIV := sha256.Sum256(key), sha256.Sum256(iv)
+	lengthAEAD, _ = newAESGCM(vmessKDF(respKey[:16], vmessRespLengthKey)[:16])
+	headerAEAD, _ = newAESGCM(vmessKDF(respKey[:16], vmessRespKey)[:16])
+	resp := lengthAEAD.Seal(nil, vmessKDF(respIV[:16], vmessRespLengthIV)[:12], []byte{0, 4}, nil)
+	resp = headerAEAD.Seal(resp, vmessKDF(respIV[:16], vmessRespIV)[:12], []byte{check, 0, 0, 0}, nil)
+	conn.Write(resp)
+
+	enc, _ := newAESGCM(respKey[:16])
+	dec, _ := newAESGCM(key)
+	server := &vmessConn{Conn: conn, enc: enc, encIV: respIV[:16], dec: dec, decIV: iv, received: true}
+	io.Copy(server, server)
+	server.CloseWrite()
+}
+
+func TestVmessClientDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveVmess(t, conn)
+	}()
+
+	u, _ := url.Parse("vmess://" + testUUID + "@" + ln.Addr().String())
+	c, err := newVmessClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := c.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the empty write should not end the stream
+	if n, err := conn.Write(nil); n != 0 || err != nil {
+		t.Fatal("unexpected empty write", n, err)
+	}
+
+	// larger than a chunk
+	data := bytes.Repeat([]byte("ping"), vmessMaxChunk)
+	go conn.Write(data)
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatal("should tunnel through the vmess server", err)
+	}
+
+	conn.(halfCloser).CloseWrite()
+	if n, err := conn.Read(buf); n != 0 || err != io.EOF {
+		t.Fatal("the empty chunk should be the end of the stream", n, err)
+	}
+}
+
+func TestNewVmessClient(t *testing.T) {
+	for _, proxy := range []string{
+		"vmess://1.2.3.4:443",
+		"vmess://" + testUUID + "@1.2.3.4:443?alterId=64",
+		"vmess://" + testUUID + "@1.2.3.4:443?encryption=chacha20-poly1305",
+		"vmess://" + testUUID + "@1.2.3.4:443?type=grpc",
+	} {
+		u, _ := url.Parse(proxy)
+		if _, err := newVmessClient(u); err == nil {
+			t.Fatal("should be invalid", proxy)
+		}
+	}
+}
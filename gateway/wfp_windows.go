@@ -0,0 +1,271 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// the WFP (windows filtering platform) constants of fwpmu.h and fwptypes.h
+const (
+	rpcAuthnWinNT            = 10
+	fwpmSessionFlagDynamic   = 0x1
+	fwpActionBlock           = 0x1001
+	fwpActionPermit          = 0x1002
+	fwpMatchEqual            = 0
+	fwpMatchFlagsAllSet      = 6
+	fwpUint8Type             = 1
+	fwpUint16Type            = 2
+	fwpUint32Type            = 3
+	fwpUint64Type            = 4
+	fwpByteArray16Type       = 11
+	fwpByteBlobType          = 12
+	fwpConditionFlagLoopback = 0x1
+
+	// dnsFilterPermitWeight and dnsFilterBlockWeight order the filters of the sublayer, the
+	// permits are matched before the block
+	dnsFilterPermitWeight = 15
+	dnsFilterBlockWeight  = 0
+)
+
+var (
+	fwpmLayerALEAuthConnectV4    = windows.GUID{Data1: 0xc38d57d1, Data2: 0x05a7, Data3: 0x4c33, Data4: [8]byte{0x90, 0x4f, 0x7f, 0xbc, 0xee, 0xe6, 0x0e, 0x82}}
+	fwpmLayerALEAuthConnectV6    = windows.GUID{Data1: 0x4a72393b, Data2: 0x319f, Data3: 0x44bc, Data4: [8]byte{0x84, 0xc3, 0xba, 0x54, 0xdc, 0xb3, 0xb6, 0xb4}}
+	fwpmConditionIPRemotePort    = windows.GUID{Data1: 0xc35a604d, Data2: 0xd22b, Data3: 0x4e1a, Data4: [8]byte{0x91, 0xb4, 0x68, 0xf6, 0x74, 0xee, 0x67, 0x4b}}
+	fwpmConditionIPRemoteAddress = windows.GUID{Data1: 0xb235ae9a, Data2: 0x1d64, Data3: 0x49b8, Data4: [8]byte{0xa4, 0x4c, 0x5f, 0xf3, 0xd9, 0x09, 0x50, 0x45}}
+	fwpmConditionIPLocalIfce     = windows.GUID{Data1: 0x4cd62a49, Data2: 0x59c3, Data3: 0x4969, Data4: [8]byte{0xb7, 0xf3, 0xbd, 0xa5, 0xd3, 0x28, 0x90, 0xa4}}
+	fwpmConditionALEAppID        = windows.GUID{Data1: 0xd78e1e87, Data2: 0x8644, Data3: 0x4ea5, Data4: [8]byte{0x94, 0x37, 0xd8, 0x09, 0xec, 0xef, 0xc9, 0x71}}
+	fwpmConditionFlags           = windows.GUID{Data1: 0x632ce23b, Data2: 0x5167, Data3: 0x435c, Data4: [8]byte{0x86, 0xd7, 0xe9, 0x03, 0x68, 0x4a, 0xa8, 0x0c}}
+
+	// dnsSublayerKey is the sublayer of the dns filters of kungfu
+	dnsSublayerKey = windows.GUID{Data1: 0x8c0a6e5b, Data2: 0x2a4d, Data3: 0x4f3e, Data4: [8]byte{0x9b, 0x1c, 0x6d, 0x7e, 0x52, 0x0f, 0x3a, 0x91}}
+)
+
+var (
+	fwpuclnt                      = windows.NewLazySystemDLL("fwpuclnt.dll")
+	procFwpmEngineOpen0           = fwpuclnt.NewProc("FwpmEngineOpen0")
+	procFwpmEngineClose0          = fwpuclnt.NewProc("FwpmEngineClose0")
+	procFwpmSubLayerAdd0          = fwpuclnt.NewProc("FwpmSubLayerAdd0")
+	procFwpmFilterAdd0            = fwpuclnt.NewProc("FwpmFilterAdd0")
+	procFwpmGetAppIdFromFileName0 = fwpuclnt.NewProc("FwpmGetAppIdFromFileName0")
+	procFwpmFreeMemory0           = fwpuclnt.NewProc("FwpmFreeMemory0")
+
+	iphlpapi                        = windows.NewLazySystemDLL("iphlpapi.dll")
+	procConvertInterfaceAliasToLuid = iphlpapi.NewProc("ConvertInterfaceAliasToLuid")
+)
+
+// the structs of fwpmtypes.h, the layout is of the 64 bit windows
+type fwpmDisplayData0 struct {
+	name        *uint16
+	description *uint16
+}
+
+type fwpByteBlob struct {
+	size uint32
+	data *byte
+}
+
+type fwpValue0 struct {
+	typ   uint32
+	value uintptr
+}
+
+type fwpmSession0 struct {
+	sessionKey           windows.GUID
+	displayData          fwpmDisplayData0
+	flags                uint32
+	txnWaitTimeoutInMSec uint32
+	processID            uint32
+	sid                  *windows.SID
+	username             *uint16
+	kernelMode           int32
+}
+
+type fwpmSublayer0 struct {
+	subLayerKey  windows.GUID
+	displayData  fwpmDisplayData0
+	flags        uint32
+	providerKey  *windows.GUID
+	providerData fwpByteBlob
+	weight       uint16
+}
+
+type fwpmFilterCondition0 struct {
+	fieldKey       windows.GUID
+	matchType      uint32
+	conditionValue fwpValue0
+}
+
+type fwpmAction0 struct {
+	typ        uint32
+	filterType windows.GUID
+}
+
+type fwpmFilter0 struct {
+	filterKey           windows.GUID
+	displayData         fwpmDisplayData0
+	flags               uint32
+	providerKey         *windows.GUID
+	providerData        fwpByteBlob
+	layerKey            windows.GUID
+	subLayerKey         windows.GUID
+	weight              fwpValue0
+	numFilterConditions uint32
+	filterCondition     *fwpmFilterCondition0
+	action              fwpmAction0
+	// rawContext is the union of the raw context and the provider context key
+	rawContext      uint64
+	_               uint64
+	reserved        *windows.GUID
+	filterID        uint64
+	effectiveWeight fwpValue0
+}
+
+// dnsFilter is the dynamic WFP session of the dns filters, the filters are removed by the
+// system when the session is closed, even the process crashed
+type dnsFilter struct {
+	engine windows.Handle
+	name   *uint16
+}
+
+// blockDNSLeak block the dns queries (port 53) of the interfaces other than the tunnel, the
+// queries to the servers, of the loopback and of kungfu itself (the upstream queries of the
+// dns server) are permitted
+func blockDNSLeak(tunnel string, servers ...net.IP) (*dnsFilter, error) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		return nil, errors.New("the dns filter is not supported on windows/" + runtime.GOARCH)
+	}
+
+	luid, err := interfaceLUID(tunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	exe16, err := windows.UTF16PtrFromString(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	var appID *fwpByteBlob
+	if r, _, _ := procFwpmGetAppIdFromFileName0.Call(uintptr(unsafe.Pointer(exe16)), uintptr(unsafe.Pointer(&appID))); r != 0 {
+		return nil, fmt.Errorf("get the app id of %s error %v", exe, syscall.Errno(r))
+	}
+	defer procFwpmFreeMemory0.Call(uintptr(unsafe.Pointer(&appID)))
+
+	f := &dnsFilter{name: windows.StringToUTF16Ptr("kungfu dns")}
+	session := fwpmSession0{
+		displayData: fwpmDisplayData0{name: f.name},
+		flags:       fwpmSessionFlagDynamic,
+	}
+	if r, _, _ := procFwpmEngineOpen0.Call(0, rpcAuthnWinNT, 0, uintptr(unsafe.Pointer(&session)),
+		uintptr(unsafe.Pointer(&f.engine))); r != 0 {
+		return nil, fmt.Errorf("open the WFP engine error %v", syscall.Errno(r))
+	}
+
+	if err := f.install(luid, appID, servers); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *dnsFilter) install(luid uint64, appID *fwpByteBlob, servers []net.IP) error {
+	sublayer := fwpmSublayer0{
+		subLayerKey: dnsSublayerKey,
+		displayData: fwpmDisplayData0{name: f.name},
+		weight:      0xffff,
+	}
+	if r, _, _ := procFwpmSubLayerAdd0.Call(uintptr(f.engine), uintptr(unsafe.Pointer(&sublayer)), 0); r != 0 {
+		return fmt.Errorf("add the WFP sublayer error %v", syscall.Errno(r))
+	}
+
+	port := fwpmFilterCondition0{
+		fieldKey:       fwpmConditionIPRemotePort,
+		conditionValue: fwpValue0{typ: fwpUint16Type, value: 53},
+	}
+
+	for _, layer := range []windows.GUID{fwpmLayerALEAuthConnectV4, fwpmLayerALEAuthConnectV6} {
+		permits := [][]fwpmFilterCondition0{
+			{{fieldKey: fwpmConditionIPLocalIfce, conditionValue: fwpValue0{typ: fwpUint64Type, value: uintptr(unsafe.Pointer(&luid))}}},
+			{{fieldKey: fwpmConditionFlags, matchType: fwpMatchFlagsAllSet, conditionValue: fwpValue0{typ: fwpUint32Type, value: fwpConditionFlagLoopback}}},
+			{{fieldKey: fwpmConditionALEAppID, conditionValue: fwpValue0{typ: fwpByteBlobType, value: uintptr(unsafe.Pointer(appID))}}},
+		}
+
+		for _, server := range servers {
+			ip4 := server.To4()
+			switch {
+			case layer == fwpmLayerALEAuthConnectV4 && ip4 != nil:
+				v := uintptr(ip4[0])<<24 | uintptr(ip4[1])<<16 | uintptr(ip4[2])<<8 | uintptr(ip4[3])
+				permits = append(permits, []fwpmFilterCondition0{port,
+					{fieldKey: fwpmConditionIPRemoteAddress, conditionValue: fwpValue0{typ: fwpUint32Type, value: v}}})
+			case layer == fwpmLayerALEAuthConnectV6 && ip4 == nil:
+				ip6 := append(net.IP(nil), server.To16()...)
+				permits = append(permits, []fwpmFilterCondition0{port,
+					{fieldKey: fwpmConditionIPRemoteAddress, conditionValue: fwpValue0{typ: fwpByteArray16Type, value: uintptr(unsafe.Pointer(&ip6[0]))}}})
+			}
+		}
+
+		for _, conditions := range permits {
+			if err := f.addFilter(layer, fwpActionPermit, dnsFilterPermitWeight, conditions); err != nil {
+				return err
+			}
+		}
+
+		if err := f.addFilter(layer, fwpActionBlock, dnsFilterBlockWeight, []fwpmFilterCondition0{port}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *dnsFilter) addFilter(layer windows.GUID, action uint32, weight uint8, conditions []fwpmFilterCondition0) error {
+	filter := fwpmFilter0{
+		displayData:         fwpmDisplayData0{name: f.name},
+		layerKey:            layer,
+		subLayerKey:         dnsSublayerKey,
+		weight:              fwpValue0{typ: fwpUint8Type, value: uintptr(weight)},
+		numFilterConditions: uint32(len(conditions)),
+		filterCondition:     &conditions[0],
+		action:              fwpmAction0{typ: action},
+	}
+
+	var id uint64
+	r, _, _ := procFwpmFilterAdd0.Call(uintptr(f.engine), uintptr(unsafe.Pointer(&filter)), 0, uintptr(unsafe.Pointer(&id)))
+	runtime.KeepAlive(conditions)
+	if r != 0 {
+		return fmt.Errorf("add the WFP filter error %v", syscall.Errno(r))
+	}
+	return nil
+}
+
+// Close close the session, the filters are removed with it
+func (f *dnsFilter) Close() error {
+	if r, _, _ := procFwpmEngineClose0.Call(uintptr(f.engine)); r != 0 {
+		return fmt.Errorf("close the WFP engine error %v", syscall.Errno(r))
+	}
+	return nil
+}
+
+// interfaceLUID return the LUID of the interface by the name (alias)
+func interfaceLUID(name string) (uint64, error) {
+	name16, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+
+	var luid uint64
+	if r, _, _ := procConvertInterfaceAliasToLuid.Call(uintptr(unsafe.Pointer(name16)), uintptr(unsafe.Pointer(&luid))); r != 0 {
+		return 0, fmt.Errorf("get the LUID of %s error %v", name, syscall.Errno(r))
+	}
+	return luid, nil
+}
@@ -0,0 +1,29 @@
+package gateway
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestWFPStructSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("the layout is of the 64 bit windows")
+	}
+
+	// the sizes of the structs of fwpmtypes.h on the 64 bit windows
+	sizes := []struct {
+		name   string
+		size   uintptr
+		expect uintptr
+	}{
+		{"FWPM_SESSION0", unsafe.Sizeof(fwpmSession0{}), 72},
+		{"FWPM_SUBLAYER0", unsafe.Sizeof(fwpmSublayer0{}), 72},
+		{"FWPM_FILTER_CONDITION0", unsafe.Sizeof(fwpmFilterCondition0{}), 40},
+		{"FWPM_FILTER0", unsafe.Sizeof(fwpmFilter0{}), 200},
+	}
+	for _, s := range sizes {
+		if s.size != s.expect {
+			t.Errorf("expect size of %s %d, got %d", s.name, s.expect, s.size)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	wintunName = "kungfu"
+	wintunType = "Kungfu"
+	// wintunRingCapacity is the ring of the session, power of 2 between 128KiB and 64MiB
+	wintunRingCapacity = 0x400000
+	// wintunWaitTimeout is the wait of the read event in milliseconds, the closed flag is
+	// checked between the waits
+	wintunWaitTimeout = 250
+
+	errorNoMoreItems syscall.Errno = 259
+)
+
+// wintunDLL is the wintun.dll (https://www.wintun.net) next to the executable, it's loaded
+// from the executable dir only to avoid the dll search path
+type wintunDLL struct {
+	createAdapter        *windows.Proc
+	closeAdapter         *windows.Proc
+	startSession         *windows.Proc
+	endSession           *windows.Proc
+	getReadWaitEvent     *windows.Proc
+	receivePacket        *windows.Proc
+	releaseReceivePacket *windows.Proc
+	allocateSendPacket   *windows.Proc
+	sendPacket           *windows.Proc
+}
+
+func loadWintun() (*wintunDLL, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(filepath.Dir(exe), "wintun.dll")
+	dll, err := windows.LoadDLL(path)
+	if err != nil {
+		return nil, fmt.Errorf("load %s error %v, download it from https://www.wintun.net", path, err)
+	}
+
+	w := &wintunDLL{}
+	procs := []struct {
+		proc **windows.Proc
+		name string
+	}{
+		{&w.createAdapter, "WintunCreateAdapter"},
+		{&w.closeAdapter, "WintunCloseAdapter"},
+		{&w.startSession, "WintunStartSession"},
+		{&w.endSession, "WintunEndSession"},
+		{&w.getReadWaitEvent, "WintunGetReadWaitEvent"},
+		{&w.receivePacket, "WintunReceivePacket"},
+		{&w.releaseReceivePacket, "WintunReleaseReceivePacket"},
+		{&w.allocateSendPacket, "WintunAllocateSendPacket"},
+		{&w.sendPacket, "WintunSendPacket"},
+	}
+	for _, p := range procs {
+		if *p.proc, err = dll.FindProc(p.name); err != nil {
+			dll.Release()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// wintunDevice is the wintun adapter and its session, the adapter is removed on close
+type wintunDevice struct {
+	dll     *wintunDLL
+	name    string
+	adapter uintptr
+	session uintptr
+	event   windows.Handle
+	closed  int32
+	// lock keep the session from ending while a packet is read or written
+	lock sync.RWMutex
+}
+
+// newWintun create the adapter and start the session
+func newWintun(name string) (*wintunDevice, error) {
+	dll, err := loadWintun()
+	if err != nil {
+		return nil, err
+	}
+
+	name16, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	type16, err := windows.UTF16PtrFromString(wintunType)
+	if err != nil {
+		return nil, err
+	}
+
+	adapter, _, err := dll.createAdapter.Call(uintptr(unsafe.Pointer(name16)), uintptr(unsafe.Pointer(type16)), 0)
+	if adapter == 0 {
+		return nil, fmt.Errorf("create wintun adapter error %v", err)
+	}
+
+	session, _, err := dll.startSession.Call(adapter, wintunRingCapacity)
+	if session == 0 {
+		dll.closeAdapter.Call(adapter)
+		return nil, fmt.Errorf("start wintun session error %v", err)
+	}
+
+	event, _, _ := dll.getReadWaitEvent.Call(session)
+	return &wintunDevice{
+		dll:     dll,
+		name:    name,
+		adapter: adapter,
+		session: session,
+		event:   windows.Handle(event),
+	}, nil
+}
+
+func (d *wintunDevice) Name() string {
+	return d.name
+}
+
+// Read copy a packet of the session to b, it wait on the read event if the ring is empty
+func (d *wintunDevice) Read(b []byte) (int, error) {
+	for {
+		d.lock.RLock()
+		if atomic.LoadInt32(&d.closed) == 1 {
+			d.lock.RUnlock()
+			return 0, os.ErrClosed
+		}
+
+		var size uint32
+		packet, _, err := d.dll.receivePacket.Call(d.session, uintptr(unsafe.Pointer(&size)))
+		if packet != 0 {
+			n := copy(b, unsafe.Slice(*(**byte)(unsafe.Pointer(&packet)), size))
+			d.dll.releaseReceivePacket.Call(d.session, packet)
+			d.lock.RUnlock()
+			return n, nil
+		}
+		d.lock.RUnlock()
+
+		switch err {
+		case errorNoMoreItems:
+			windows.WaitForSingleObject(d.event, wintunWaitTimeout)
+		case windows.ERROR_HANDLE_EOF:
+			return 0, os.ErrClosed
+		default:
+			return 0, fmt.Errorf("wintun receive packet error %v", err)
+		}
+	}
+}
+
+// Write send b as a packet, the packet is dropped if the ring is full like a nic does
+func (d *wintunDevice) Write(b []byte) (int, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if atomic.LoadInt32(&d.closed) == 1 {
+		return 0, os.ErrClosed
+	}
+
+	packet, _, err := d.dll.allocateSendPacket.Call(d.session, uintptr(len(b)))
+	if packet == 0 {
+		if err == windows.ERROR_BUFFER_OVERFLOW {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("wintun allocate packet error %v", err)
+	}
+	copy(unsafe.Slice(*(**byte)(unsafe.Pointer(&packet)), len(b)), b)
+	d.dll.sendPacket.Call(d.session, packet)
+	return len(b), nil
+}
+
+// Close end the session and remove the adapter
+func (d *wintunDevice) Close() error {
+	if !atomic.CompareAndSwapInt32(&d.closed, 0, 1) {
+		return errors.New("wintun adapter closed")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.dll.endSession.Call(d.session)
+	d.dll.closeAdapter.Call(d.adapter)
+	return nil
+}
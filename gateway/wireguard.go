@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu/internal"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// wireguardHandshakeTimeout is the age of the last handshake the peer is considered down, the
+// session keys are rejected after 180s without a new handshake
+const wireguardHandshakeTimeout = time.Duration(time.Second * 180)
+
+var (
+	// wireguardClients is the clients by the proxy url, the tunnel is kept across the config
+	// reloads and closed once the url is not configured
+	wireguardClients     = make(map[string]*wireguardClient)
+	wireguardClientsLock sync.Mutex
+)
+
+// wireguardClient relay the connections through the userspace wireguard tunnel on the tcp/ip
+// stack of gvisor, no kernel interface or route is configured. The targets are resolved by the
+// dns servers through the tunnel, the tunnel is started at the first dial
+type wireguardClient struct {
+	config  *internal.Wireguard
+	timeout time.Duration
+
+	lock sync.Mutex
+	dev  *device.Device
+	tnet *netstack.Net
+}
+
+// newWireguardClient return the client of the proxy url, the client of the same url is reused
+func newWireguardClient(u *url.URL) (*wireguardClient, error) {
+	config, err := internal.ParseWireguard(u)
+	if err != nil {
+		return nil, err
+	}
+
+	wireguardClientsLock.Lock()
+	defer wireguardClientsLock.Unlock()
+
+	name := u.String()
+	if c, ok := wireguardClients[name]; ok {
+		return c, nil
+	}
+
+	c := &wireguardClient{config: config, timeout: socks5Timeout}
+	wireguardClients[name] = c
+	return c, nil
+}
+
+// releaseWireguard close the tunnels of the urls not used by the outbounds, all if nil
+func releaseWireguard(outbounds []*outbound) {
+	used := make(map[string]bool)
+	for _, o := range outbounds {
+		for _, p := range o.upstreams {
+			used[p.proxy.String()] = true
+		}
+	}
+
+	wireguardClientsLock.Lock()
+	defer wireguardClientsLock.Unlock()
+
+	for name, c := range wireguardClients {
+		if !used[name] {
+			c.close()
+			delete(wireguardClients, name)
+		}
+	}
+}
+
+// Dial connect the addr through the tunnel, implement proxy.Dialer
+func (c *wireguardClient) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("wireguard: unsupported network %s", network)
+	}
+	return c.dial(network, addr)
+}
+
+// DialUDP relay udp to the addr through the tunnel
+func (c *wireguardClient) DialUDP(addr string) (net.Conn, error) {
+	return c.dial("udp", addr)
+}
+
+func (c *wireguardClient) dial(network, addr string) (net.Conn, error) {
+	tnet, err := c.start()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return tnet.DialContext(ctx, network, addr)
+}
+
+// check report the peer down if no handshake recently, the handshake is kept by the keepalive,
+// the endpoint is udp and can not be checked by connecting
+func (c *wireguardClient) check() error {
+	if _, err := c.start(); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	dev := c.dev
+	c.lock.Unlock()
+	if dev == nil {
+		return errors.New("wireguard: tunnel closed")
+	}
+
+	status, err := dev.IpcGet()
+	if err != nil {
+		return err
+	}
+
+	last := lastHandshake(status)
+	if last.IsZero() || time.Since(last) > wireguardHandshakeTimeout {
+		return fmt.Errorf("wireguard: no handshake with %s since %v", c.config.Endpoint, last)
+	}
+	return nil
+}
+
+// start the tunnel if not started, the endpoint is resolved each time starting
+func (c *wireguardClient) start() (*netstack.Net, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.tnet != nil {
+		return c.tnet, nil
+	}
+
+	endpoint, err := net.ResolveUDPAddr("udp", c.config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("wireguard: resolve endpoint %s error, %v", c.config.Endpoint, err)
+	}
+
+	tun, tnet, err := netstack.CreateNetTUN(c.config.Addresses, c.config.DNS, c.config.MTU)
+	if err != nil {
+		return nil, fmt.Errorf("wireguard: create tun error, %v", err)
+	}
+
+	logger := &device.Logger{
+		Verbosef: func(format string, args ...interface{}) { log.Debug("wireguard: "+format, args...) },
+		Errorf:   func(format string, args ...interface{}) { log.Warning("wireguard: "+format, args...) },
+	}
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	if err := dev.IpcSet(wireguardIpc(c.config, endpoint)); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("wireguard: config device error, %v", err)
+	}
+
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("wireguard: up device error, %v", err)
+	}
+
+	log.Info("wireguard tunnel to %s up, address: %v", c.config.Endpoint, c.config.Addresses)
+	c.dev = dev
+	c.tnet = tnet
+	return tnet, nil
+}
+
+// close the tunnel, it's started again at the next dial
+func (c *wireguardClient) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.dev != nil {
+		c.dev.Close()
+		log.Info("wireguard tunnel to %s closed", c.config.Endpoint)
+	}
+	c.dev = nil
+	c.tnet = nil
+}
+
+// wireguardIpc return the config of the device in the cross-platform userspace configuration
+// protocol, all the traffic is routed to the peer
+func wireguardIpc(config *internal.Wireguard, endpoint *net.UDPAddr) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "private_key=%s\n", hex.EncodeToString(config.PrivateKey))
+	fmt.Fprintf(&b, "public_key=%s\n", hex.EncodeToString(config.PublicKey))
+	if config.PresharedKey != nil {
+		fmt.Fprintf(&b, "preshared_key=%s\n", hex.EncodeToString(config.PresharedKey))
+	}
+	fmt.Fprintf(&b, "endpoint=%s\n", endpoint)
+	if config.Keepalive > 0 {
+		fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", config.Keepalive)
+	}
+	b.WriteString("allowed_ip=0.0.0.0/0\nallowed_ip=::/0\n")
+	return b.String()
+}
+
+// lastHandshake return the last handshake time of the peer in the device status
+func lastHandshake(status string) time.Time {
+	var sec, nsec int64
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "last_handshake_time_sec":
+			sec, _ = strconv.ParseInt(parts[1], 10, 64)
+		case "last_handshake_time_nsec":
+			nsec, _ = strconv.ParseInt(parts[1], 10, 64)
+		}
+	}
+
+	if sec == 0 && nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, nsec)
+}
@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+func wireguardKeyPair(t *testing.T) ([]byte, []byte) {
+	private := make([]byte, 32)
+	rand.Read(private)
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, public
+}
+
+// wireguardPeer start the peer on the userspace stack with the address 10.0.0.1, the tcp
+// echo server listen on port 80
+func wireguardPeer(t *testing.T, private, client []byte) (*device.Device, int) {
+	tun, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil, 1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	config := fmt.Sprintf("private_key=%s\nlisten_port=0\npublic_key=%s\nallowed_ip=10.0.0.2/32\n",
+		hex.EncodeToString(private), hex.EncodeToString(client))
+	if err := dev.IpcSet(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tnet.ListenTCP(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	status, _ := dev.IpcGet()
+	var port int
+	for _, line := range strings.Split(status, "\n") {
+		if n, _ := fmt.Sscanf(line, "listen_port=%d", &port); n == 1 {
+			break
+		}
+	}
+	return dev, port
+}
+
+func TestWireguardClient(t *testing.T) {
+	serverPrivate, serverPublic := wireguardKeyPair(t)
+	clientPrivate, clientPublic := wireguardKeyPair(t)
+
+	peer, port := wireguardPeer(t, serverPrivate, clientPublic)
+	defer peer.Close()
+
+	u, _ := url.Parse(fmt.Sprintf("wg://%s@127.0.0.1:%d?publickey=%s&address=10.0.0.2",
+		url.PathEscape(base64.StdEncoding.EncodeToString(clientPrivate)), port,
+		url.QueryEscape(base64.StdEncoding.EncodeToString(serverPublic))))
+
+	if _, err := newDialer(u, &recordDialer{}); err == nil {
+		t.Fatal("wireguard relayed via other outbound should fail")
+	}
+
+	p, err := newUpstream(u.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseWireguard(nil)
+	if p.udp == nil {
+		t.Fatal("wireguard should relay udp")
+	}
+
+	c, err := p.dialer.Dial("tcp", "10.0.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatal("the data should be relayed through the tunnel", string(buf), err)
+	}
+
+	// the handshake is done, the peer is healthy
+	if err := p.check(healthCheckTCP); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client of the same url is reused, released if not used
+	again, _ := newUpstream(u.String(), nil)
+	if again.dialer != p.dialer {
+		t.Fatal("the client should be reused")
+	}
+	releaseWireguard([]*outbound{{upstreams: []*upstream{p}}})
+	if len(wireguardClients) != 1 {
+		t.Fatal("the used client should be kept")
+	}
+	releaseWireguard(nil)
+	if len(wireguardClients) != 0 {
+		t.Fatal("the unused client should be released")
+	}
+}
+
+func TestLastHandshake(t *testing.T) {
+	if !lastHandshake("public_key=00\nlast_handshake_time_sec=0\nlast_handshake_time_nsec=0\n").IsZero() {
+		t.Fatal("no handshake should be zero")
+	}
+	if last := lastHandshake("last_handshake_time_sec=100\nlast_handshake_time_nsec=5\n"); !last.Equal(time.Unix(100, 5)) {
+		t.Fatal("unexpected handshake time", last)
+	}
+}
@@ -0,0 +1,342 @@
+package geoip
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APNICURL is the url of the APNIC delegated stats, the china ip list is the CN records
+const APNICURL = "https://ftp.apnic.net/apnic/stats/apnic/delegated-apnic-latest"
+
+// ipRange is the ips from start to end inclusive, ipv4 in the 16 bytes form
+type ipRange struct {
+	start net.IP
+	end   net.IP
+}
+
+// Ranges is the ip ranges in the order of start, the overlapped and adjacent ranges merged,
+// looked up by binary search
+type Ranges struct {
+	ranges []ipRange
+}
+
+// ParseRanges parse the APNIC delegated stats (the CN records) or the list of cidr per line,
+// the lines start with # are comments
+func ParseRanges(r io.Reader) (*Ranges, error) {
+	var ranges []ipRange
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rg ipRange
+		var ok bool
+		var err error
+		if strings.Contains(line, "|") {
+			rg, ok, err = parseDelegated(line)
+		} else {
+			rg, err = parseCIDR(line)
+			ok = err == nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if ok {
+			ranges = append(ranges, rg)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return newRanges(ranges), nil
+}
+
+// parseDelegated parse the record of the delegated stats, e.g. apnic|CN|ipv4|1.0.1.0|256|...
+// and apnic|CN|ipv6|2001:250::|35|..., false if not the CN ip record
+func parseDelegated(line string) (ipRange, bool, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 5 || fields[1] != "CN" || (fields[2] != "ipv4" && fields[2] != "ipv6") {
+		return ipRange{}, false, nil
+	}
+
+	start := net.ParseIP(fields[3])
+	value, err := strconv.ParseUint(fields[4], 10, 64)
+	if start == nil || err != nil {
+		return ipRange{}, false, fmt.Errorf("invalid record %s", line)
+	}
+
+	if fields[2] == "ipv6" {
+		rg, err := parseCIDR(fmt.Sprintf("%s/%d", fields[3], value))
+		return rg, err == nil, err
+	}
+
+	// the value of ipv4 is the count of the ips, maybe not the power of 2
+	if start.To4() == nil || value == 0 {
+		return ipRange{}, false, fmt.Errorf("invalid record %s", line)
+	}
+	end := new(big.Int).Add(new(big.Int).SetBytes(start.To16()), new(big.Int).SetUint64(value-1))
+	return ipRange{start: start.To16(), end: toIP(end)}, true, nil
+}
+
+func parseCIDR(s string) (ipRange, error) {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return ipRange{}, err
+	}
+
+	start := n.IP.To16()
+	end := make(net.IP, net.IPv6len)
+	mask := n.Mask
+	if len(mask) == net.IPv4len {
+		// align the mask with the 16 bytes form of ipv4
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+	for i := range start {
+		end[i] = start[i] | ^mask[i]
+	}
+	return ipRange{start: start, end: end}, nil
+}
+
+// newRanges sort and merge the ranges
+func newRanges(ranges []ipRange) *Ranges {
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start, ranges[j].start) < 0
+	})
+
+	var merged []ipRange
+	for _, rg := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			// the next ip of the last range
+			next := toIP(new(big.Int).Add(new(big.Int).SetBytes(last.end), big.NewInt(1)))
+			if bytes.Compare(rg.start, next) <= 0 {
+				if bytes.Compare(rg.end, last.end) > 0 {
+					last.end = rg.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, rg)
+	}
+	return &Ranges{ranges: merged}
+}
+
+// Len return the count of the merged ranges
+func (r *Ranges) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.ranges)
+}
+
+// Contains return true if the ip is in the ranges
+func (r *Ranges) Contains(ip net.IP) bool {
+	ip = ip.To16()
+	if r == nil || ip == nil {
+		return false
+	}
+
+	// the first range ends after the ip
+	i := sort.Search(len(r.ranges), func(i int) bool {
+		return bytes.Compare(r.ranges[i].end, ip) >= 0
+	})
+	return i < len(r.ranges) && bytes.Compare(r.ranges[i].start, ip) <= 0
+}
+
+// CIDRs return the minimal cidrs of the ranges, ipv4 first, for generating the route table
+func (r *Ranges) CIDRs() []string {
+	if r == nil {
+		return nil
+	}
+
+	var cidrs []string
+	for _, rg := range r.ranges {
+		start := new(big.Int).SetBytes(rg.start)
+		end := new(big.Int).SetBytes(rg.end)
+		bits := 128
+		if rg.start.To4() != nil {
+			bits = 32
+		}
+
+		for start.Cmp(end) <= 0 {
+			// the largest block aligned to start and not exceeding end
+			size := 0
+			for size < bits && start.Bit(size) == 0 {
+				last := new(big.Int).Add(start, new(big.Int).Lsh(big.NewInt(1), uint(size+1)))
+				if last.Sub(last, big.NewInt(1)).Cmp(end) > 0 {
+					break
+				}
+				size++
+			}
+
+			ip := toIP(start)
+			if bits == 32 {
+				ip = ip.To4()
+			}
+			cidrs = append(cidrs, fmt.Sprintf("%s/%d", ip, bits-size))
+			start.Add(start, new(big.Int).Lsh(big.NewInt(1), uint(size)))
+		}
+	}
+	return cidrs
+}
+
+// toIP convert the integer to the 16 bytes ip
+func toIP(n *big.Int) net.IP {
+	b := n.Bytes()
+	if len(b) > net.IPv6len {
+		b = b[len(b)-net.IPv6len:]
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[net.IPv6len-len(b):], b)
+	return ip
+}
+
+// RoutesLoader load the china ip list, reload on the file changed, update the url source
+// periodically
+type RoutesLoader struct {
+	// Source is the file path or http(s) url of the list
+	Source string
+	// Interval is the update interval of url source, 0 to disable
+	Interval time.Duration
+
+	lock    sync.RWMutex
+	ranges  *Ranges
+	modTime time.Time
+	stop    chan struct{}
+}
+
+// Start load the list and watch for changes
+func (l *RoutesLoader) Start() {
+	l.stop = make(chan struct{})
+
+	if err := l.Load(); err != nil {
+		log.Error("load china ip list %s error, %v", l.Source, err)
+	}
+
+	if !isURL(l.Source) {
+		go l.watchFile()
+	} else if l.Interval > 0 {
+		go l.schedule()
+	}
+}
+
+// Stop watching for changes
+func (l *RoutesLoader) Stop() {
+	if l.stop != nil {
+		close(l.stop)
+	}
+}
+
+// Ranges return the loaded ranges, nil if not loaded
+func (l *RoutesLoader) Ranges() *Ranges {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.ranges
+}
+
+// Load parse the source, the current list is kept if invalid
+func (l *RoutesLoader) Load() error {
+	var buf []byte
+	var modTime time.Time
+	var err error
+	if isURL(l.Source) {
+		buf, err = fetch(l.Source)
+	} else {
+		var info os.FileInfo
+		if info, err = os.Stat(l.Source); err == nil {
+			modTime = info.ModTime()
+			buf, err = ioutil.ReadFile(l.Source)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	ranges, err := ParseRanges(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+
+	if ranges.Len() == 0 {
+		return fmt.Errorf("no china ip found in %s", l.Source)
+	}
+
+	l.lock.Lock()
+	l.ranges = ranges
+	l.modTime = modTime
+	l.lock.Unlock()
+
+	log.Info("load china ip list %s, ranges: %d", l.Source, ranges.Len())
+	return nil
+}
+
+func (l *RoutesLoader) schedule() {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		log.Debug("update china ip list %s", l.Source)
+		if err := l.Load(); err != nil {
+			log.Error("update china ip list %s error, %v", l.Source, err)
+		}
+	}
+}
+
+func (l *RoutesLoader) watchFile() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(l.Source)
+		if err != nil {
+			continue
+		}
+
+		l.lock.RLock()
+		changed := !info.ModTime().Equal(l.modTime)
+		l.lock.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		log.Info("china ip list %s changed, reload", l.Source)
+		if err := l.Load(); err != nil {
+			log.Error("reload china ip list %s error, %v", l.Source, err)
+		}
+	}
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
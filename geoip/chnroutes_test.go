@@ -0,0 +1,67 @@
+package geoip
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const delegated = `2|apnic|20200101|1|19830613|20200101|+1000
+apnic|*|ipv4|*|1|summary
+apnic|CN|ipv4|1.0.1.0|256|20110414|allocated
+apnic|CN|ipv4|1.0.2.0|512|20110414|allocated
+apnic|CN|ipv4|1.0.8.0|768|20110412|allocated
+apnic|JP|ipv4|1.0.16.0|4096|20110412|allocated
+apnic|CN|ipv6|2001:250::|35|20000426|allocated
+apnic|CN|asn|4134|1|20000101|allocated
+`
+
+func TestParseRanges(t *testing.T) {
+	ranges, err := ParseRanges(strings.NewReader(delegated))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1.0.1.0 - 1.0.3.255 merged
+	if ranges.Len() != 3 {
+		t.Fatal("unexpected ranges", ranges.Len())
+	}
+
+	tests := map[string]bool{
+		"1.0.0.255":       false,
+		"1.0.1.0":         true,
+		"1.0.3.255":       true,
+		"1.0.4.0":         false,
+		"1.0.10.255":      true,
+		"1.0.11.0":        false,
+		"1.0.16.1":        false,
+		"2001:250::1":     true,
+		"2001:250:2000::": false,
+	}
+	for ip, expected := range tests {
+		if ranges.Contains(net.ParseIP(ip)) != expected {
+			t.Fatalf("expect %v of %s", expected, ip)
+		}
+	}
+
+	expected := []string{"1.0.1.0/24", "1.0.2.0/23", "1.0.8.0/23", "1.0.10.0/24", "2001:250::/35"}
+	if cidrs := ranges.CIDRs(); !reflect.DeepEqual(cidrs, expected) {
+		t.Fatal("unexpected cidrs", cidrs)
+	}
+}
+
+func TestParseRangesCIDR(t *testing.T) {
+	ranges, err := ParseRanges(strings.NewReader("# china ip\n10.0.0.0/8\n10.1.0.0/16\n\n192.168.0.0/24\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(ranges.CIDRs(), []string{"10.0.0.0/8", "192.168.0.0/24"}) {
+		t.Fatal("unexpected cidrs", ranges.CIDRs())
+	}
+
+	if _, err := ParseRanges(strings.NewReader("10.0.0.0/33")); err == nil {
+		t.Fatal("invalid cidr should fail")
+	}
+}
@@ -0,0 +1,358 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+)
+
+// metadataMarker is the start of the metadata section at the end of the database
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the 16 zero bytes between the search tree and the data section
+const dataSectionSeparator = 16
+
+// the data types of the MaxMind DB format
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errInvalidDatabase = errors.New("invalid MaxMind DB")
+
+// DB is the MaxMind DB (mmdb) of the country, e.g. GeoLite2-Country, only the iso code of the
+// country is decoded
+type DB struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node of ::/96, the ipv4 subtree of the ipv6 database
+	ipv4Start uint
+	data      []byte
+
+	lock sync.RWMutex
+	// countries is the iso code of the decoded records, keyed by the data offset
+	countries map[uint]string
+}
+
+// NewDB parse the database in the buffer
+func NewDB(buf []byte) (*DB, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%v, metadata not found", errInvalidDatabase)
+	}
+
+	d := &decoder{buf: buf[i+len(metadataMarker):]}
+	v, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%v, metadata %v", errInvalidDatabase, err)
+	}
+
+	metadata, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v, metadata is not a map", errInvalidDatabase)
+	}
+
+	db := &DB{
+		buf:        buf,
+		nodeCount:  uintValue(metadata["node_count"]),
+		recordSize: uintValue(metadata["record_size"]),
+		ipVersion:  uintValue(metadata["ip_version"]),
+		countries:  make(map[uint]string),
+	}
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%v, unsupported record size %d", errInvalidDatabase, db.recordSize)
+	}
+
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%v, unsupported ip version %d", errInvalidDatabase, db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(i) {
+		return nil, fmt.Errorf("%v, search tree exceeds the database", errInvalidDatabase)
+	}
+	db.data = buf[treeSize+dataSectionSeparator : i]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// Country return the iso code of the country of the ip, e.g. CN, empty if not found
+func (db *DB) Country(ip net.IP) (string, error) {
+	node := uint(0)
+	bits := ip.To4()
+	if bits != nil {
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else {
+		if db.ipVersion == 4 {
+			return "", nil
+		}
+		bits = ip.To16()
+		if bits == nil {
+			return "", fmt.Errorf("invalid ip %v", ip)
+		}
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+
+	if node == db.nodeCount {
+		return "", nil
+	}
+	if node < db.nodeCount {
+		return "", fmt.Errorf("%v, search tree too deep", errInvalidDatabase)
+	}
+
+	return db.country(node - db.nodeCount - dataSectionSeparator)
+}
+
+// record return the left (0) or right (1) record of the node
+func (db *DB) record(node uint, bit uint) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// country decode the iso code of the record at the offset of the data section, the country
+// is preferred to the registered country
+func (db *DB) country(offset uint) (string, error) {
+	db.lock.RLock()
+	country, ok := db.countries[offset]
+	db.lock.RUnlock()
+	if ok {
+		return country, nil
+	}
+
+	d := &decoder{buf: db.data}
+	v, _, err := d.decode(offset)
+	if err != nil {
+		return "", err
+	}
+
+	record, _ := v.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		c, _ := record[key].(map[string]interface{})
+		if code, ok := c["iso_code"].(string); ok {
+			country = strings.ToUpper(code)
+			break
+		}
+	}
+
+	db.lock.Lock()
+	db.countries[offset] = country
+	db.lock.Unlock()
+	return country, nil
+}
+
+// decoder decode the data section of the MaxMind DB format
+type decoder struct {
+	buf []byte
+}
+
+// decode the value at the offset, return the value and the offset after it
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(pointer)
+		return v, next, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			if value, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			if value, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, fmt.Errorf("value of type %d exceeds the data", typ)
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, next, nil
+	case typeInt32:
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported type %d", typ)
+}
+
+// control decode the control byte, return the type, the size (the payload of pointer) and the
+// offset of the value
+func (d *decoder) control(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("offset exceeds the data")
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		return typ, uint(ctrl & 0x1f), offset, nil
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("offset exceeds the data")
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("size exceeds the data")
+		}
+		var extra uint
+		for _, c := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(c)
+		}
+		offset += n
+
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	return typ, size, offset, nil
+}
+
+// pointer decode the pointer with the payload of the control byte, return the target offset
+// and the offset after the pointer
+func (d *decoder) pointer(payload uint, offset uint) (uint, uint, error) {
+	n := payload>>3&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("pointer exceeds the data")
+	}
+
+	var p uint
+	if n < 4 {
+		p = payload & 0x7
+	}
+	for _, c := range d.buf[offset : offset+n] {
+		p = p<<8 | uint(c)
+	}
+
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	return p, offset + n, nil
+}
+
+func uintValue(v interface{}) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
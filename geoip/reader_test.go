@@ -0,0 +1,194 @@
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net"
+	"sort"
+	"testing"
+)
+
+// encodeString encode the string shorter than 29 bytes
+func encodeString(s string) []byte {
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encodeUint(typ int, n uint32) []byte {
+	return []byte{byte(typ<<5 | 4), byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+// encodeCountry encode the record {"country": {"iso_code": code}}
+func encodeCountry(code string) []byte {
+	b := []byte{byte(typeMap<<5 | 1)}
+	b = append(b, encodeString("country")...)
+	b = append(b, byte(typeMap<<5|1))
+	b = append(b, encodeString("iso_code")...)
+	return append(b, encodeString(code)...)
+}
+
+// trieNode is the node of the search tree, the record is the child node or the data
+type trieNode struct {
+	children [2]*trieNode
+	data     []int
+}
+
+// buildDB build the ipv4 database of 24 bits record with the networks of the countries
+func buildDB(networks map[string]string) []byte {
+	// the less specific networks inserted first
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Slice(cidrs, func(i, j int) bool {
+		_, ni, _ := net.ParseCIDR(cidrs[i])
+		_, nj, _ := net.ParseCIDR(cidrs[j])
+		oi, _ := ni.Mask.Size()
+		oj, _ := nj.Mask.Size()
+		return oi < oj
+	})
+
+	root := &trieNode{data: []int{-1, -1}}
+	var data []byte
+	for _, cidr := range cidrs {
+		code := networks[cidr]
+		_, n, _ := net.ParseCIDR(cidr)
+		ones, _ := n.Mask.Size()
+		offset := len(data)
+		data = append(data, encodeCountry(code)...)
+
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := int(n.IP.To4()[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				node.data[bit] = offset
+				break
+			}
+			if node.children[bit] == nil {
+				// the more specific network split the network of the record
+				inherit := node.data[bit]
+				node.children[bit] = &trieNode{data: []int{inherit, inherit}}
+				node.data[bit] = -1
+			}
+			node = node.children[bit]
+		}
+	}
+
+	// number the nodes in breadth first order
+	nodes := []*trieNode{root}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].children {
+			if c != nil {
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	index := make(map[*trieNode]int, len(nodes))
+	for i, n := range nodes {
+		index[n] = i
+	}
+
+	var buf []byte
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := len(nodes)
+			if c := n.children[bit]; c != nil {
+				record = index[c]
+			} else if n.data[bit] >= 0 {
+				record = len(nodes) + dataSectionSeparator + n.data[bit]
+			}
+			buf = append(buf, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, byte(typeMap<<5|3))
+	buf = append(buf, encodeString("node_count")...)
+	buf = append(buf, encodeUint(typeUint32, uint32(len(nodes)))...)
+	buf = append(buf, encodeString("record_size")...)
+	buf = append(buf, encodeUint(typeUint16, 24)...)
+	buf = append(buf, encodeString("ip_version")...)
+	return append(buf, encodeUint(typeUint16, 4)...)
+}
+
+func TestCountry(t *testing.T) {
+	db, err := NewDB(buildDB(map[string]string{
+		"1.0.0.0/8":   "cn",
+		"1.2.3.0/24":  "JP",
+		"128.0.0.0/1": "US",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"1.1.1.1":     "CN",
+		"1.2.3.4":     "JP",
+		"1.2.4.4":     "CN",
+		"8.8.8.8":     "",
+		"200.1.1.1":   "US",
+		"2001:db8::1": "",
+	}
+	for ip, expected := range tests {
+		country, err := db.Country(net.ParseIP(ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if country != expected {
+			t.Fatalf("expect %q of %s, got %q", expected, ip, country)
+		}
+	}
+}
+
+func TestNewDBInvalid(t *testing.T) {
+	if _, err := NewDB([]byte("not a database")); err == nil {
+		t.Fatal("database without metadata should be invalid")
+	}
+}
+
+func TestDecodePointer(t *testing.T) {
+	// the map value points to the string at offset 0
+	buf := encodeString("CN")
+	offset := uint(len(buf))
+	buf = append(buf, byte(typeMap<<5|1))
+	buf = append(buf, encodeString("iso_code")...)
+	buf = append(buf, byte(typePointer<<5), 0)
+
+	d := &decoder{buf: buf}
+	v, _, err := d.decode(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := v.(map[string]interface{}); m["iso_code"] != "CN" {
+		t.Fatal("unexpected value", v)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	mmdb := buildDB(map[string]string{"1.0.0.0/8": "CN"})
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-Country_20200101/COPYRIGHT.txt", Mode: 0644, Size: 4})
+	tw.Write([]byte("test"))
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-Country_20200101/GeoLite2-Country.mmdb", Mode: 0644, Size: int64(len(mmdb))})
+	tw.Write(mmdb)
+	tw.Close()
+
+	var gzBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	gw.Write(tarBuf.Bytes())
+	gw.Close()
+
+	for _, buf := range [][]byte{mmdb, tarBuf.Bytes(), gzBuf.Bytes()} {
+		extracted, err := extract(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(extracted, mmdb) {
+			t.Fatal("unexpected extracted database")
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// the actions of the rules
+const (
+	ActionDirect = "DIRECT"
+	ActionProxy  = "PROXY"
+)
+
+// the types of the rules
+const (
+	ruleGeoIP = "GEOIP"
+	ruleCIDR  = "IP-CIDR"
+	ruleCIDR6 = "IP-CIDR6"
+	noResolve = "NO-RESOLVE"
+	// ruleMatch is the final rule, applied if no country matched
+	ruleMatch = "MATCH"
+)
+
+// cidrRule is the action of the ips in the network
+type cidrRule struct {
+	network *net.IPNet
+	action  string
+}
+
+// Rules is the action of the ip by the network or the country, e.g. IP-CIDR,1.0.0.0/8,DIRECT,
+// GEOIP,CN,DIRECT and MATCH,PROXY
+type Rules struct {
+	cidrs     []cidrRule
+	countries map[string]string
+	final     string
+}
+
+// ParseRules parse the rules, IP-CIDR,<cidr>,<action>, IP-CIDR6,<cidr>,<action>,
+// GEOIP,<country iso code>,<action> or MATCH,<action>, the action is DIRECT or PROXY, the
+// no-resolve option of the clash rules is ignored
+func ParseRules(rules []string) (*Rules, error) {
+	r := &Rules{countries: make(map[string]string)}
+	for _, rule := range rules {
+		fields := strings.Split(strings.ToUpper(strings.TrimSpace(rule)), ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if len(fields) > 1 && fields[len(fields)-1] == noResolve {
+			fields = fields[:len(fields)-1]
+		}
+
+		action := fields[len(fields)-1]
+		switch action {
+		case ActionDirect, ActionProxy:
+		default:
+			return nil, fmt.Errorf("invalid geoip rule %s, unsupported action %s", rule, action)
+		}
+
+		switch {
+		case (fields[0] == ruleCIDR || fields[0] == ruleCIDR6) && len(fields) == 3:
+			_, network, err := net.ParseCIDR(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid geoip rule %s, %v", rule, err)
+			}
+			r.cidrs = append(r.cidrs, cidrRule{network: network, action: action})
+		case fields[0] == ruleGeoIP && len(fields) == 3 && fields[1] != "":
+			if a, ok := r.countries[fields[1]]; ok && a != action {
+				return nil, fmt.Errorf("invalid geoip rule %s, conflict with %s", rule, a)
+			}
+			r.countries[fields[1]] = action
+		case fields[0] == ruleMatch && len(fields) == 2:
+			if r.final != "" && r.final != action {
+				return nil, fmt.Errorf("invalid geoip rule %s, conflict with %s", rule, r.final)
+			}
+			r.final = action
+		default:
+			return nil, fmt.Errorf("invalid geoip rule %s", rule)
+		}
+	}
+
+	// the most specific network first
+	sort.SliceStable(r.cidrs, func(i, j int) bool {
+		a, _ := r.cidrs[i].network.Mask.Size()
+		b, _ := r.cidrs[j].network.Mask.Size()
+		return a > b
+	})
+	return r, nil
+}
+
+// Len return the count of the rules
+func (r *Rules) Len() int {
+	if r == nil {
+		return 0
+	}
+
+	n := len(r.cidrs) + len(r.countries)
+	if r.final != "" {
+		n++
+	}
+	return n
+}
+
+// Has return true if any rule applicable is the action, the country rules apply with the
+// database only
+func (r *Rules) Has(db *DB, action string) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, c := range r.cidrs {
+		if c.action == action {
+			return true
+		}
+	}
+	if db == nil {
+		return false
+	}
+
+	if r.final == action {
+		return true
+	}
+	for _, a := range r.countries {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Action return the action of the country, the final action if no country matched
+func (r *Rules) Action(country string) string {
+	if r == nil {
+		return ""
+	}
+
+	if action, ok := r.countries[country]; ok {
+		return action
+	}
+	return r.final
+}
+
+// Match return the action of the ip, the network rules first, empty if no rule matched, the
+// country rules are skipped if the database not loaded
+func (r *Rules) Match(db *DB, ip net.IP) string {
+	if r.Len() == 0 {
+		return ""
+	}
+
+	for _, c := range r.cidrs {
+		if c.network.Contains(ip) {
+			return c.action
+		}
+	}
+
+	if db == nil {
+		return ""
+	}
+
+	country, err := db.Country(ip)
+	if err != nil {
+		log.Warning("lookup country of %v error, %v", ip, err)
+		return ""
+	}
+	return r.Action(country)
+}
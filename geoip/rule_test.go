@@ -0,0 +1,88 @@
+package geoip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]string{"GEOIP,CN,DIRECT", "geoip, jp, proxy", "MATCH,PROXY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rules.Len() != 3 || !rules.Has(&DB{}, ActionDirect) || !rules.Has(&DB{}, ActionProxy) {
+		t.Fatal("unexpected rules", rules)
+	}
+
+	tests := map[string]string{"CN": ActionDirect, "JP": ActionProxy, "US": ActionProxy, "": ActionProxy}
+	for country, expected := range tests {
+		if action := rules.Action(country); action != expected {
+			t.Fatalf("expect %s of %q, got %s", expected, country, action)
+		}
+	}
+
+	invalid := [][]string{
+		{"GEOIP,CN"},
+		{"GEOIP,CN,REJECT"},
+		{"DOMAIN,example.com,PROXY"},
+		{"GEOIP,CN,DIRECT", "GEOIP,CN,PROXY"},
+		{"MATCH,DIRECT", "MATCH,PROXY"},
+	}
+	for _, r := range invalid {
+		if _, err := ParseRules(r); err == nil {
+			t.Fatal("rules should be invalid", r)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	db, err := NewDB(buildDB(map[string]string{"1.0.0.0/8": "CN"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ParseRules([]string{"GEOIP,CN,DIRECT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if action := rules.Match(db, net.ParseIP("1.1.1.1")); action != ActionDirect {
+		t.Fatal("unexpected action", action)
+	}
+
+	if action := rules.Match(db, net.ParseIP("8.8.8.8")); action != "" {
+		t.Fatal("no rule should match", action)
+	}
+
+	if action := rules.Match(nil, net.ParseIP("1.1.1.1")); action != "" {
+		t.Fatal("no rule should match without database", action)
+	}
+}
+
+func TestMatchCIDR(t *testing.T) {
+	rules, err := ParseRules([]string{
+		"IP-CIDR,1.0.0.0/8,DIRECT",
+		"IP-CIDR,1.1.1.0/24,PROXY,no-resolve",
+		"IP-CIDR6,2001:db8::/32,DIRECT",
+		"GEOIP,JP,PROXY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !rules.Has(nil, ActionDirect) || !rules.Has(nil, ActionProxy) {
+		t.Fatal("cidr rules should apply without database")
+	}
+
+	tests := map[string]string{"1.2.3.4": ActionDirect, "1.1.1.1": ActionProxy, "2001:db8::1": ActionDirect, "8.8.8.8": ""}
+	for ip, expected := range tests {
+		if action := rules.Match(nil, net.ParseIP(ip)); action != expected {
+			t.Fatalf("expect %q of %s, got %q", expected, ip, action)
+		}
+	}
+
+	if _, err := ParseRules([]string{"IP-CIDR,1.0.0.0,DIRECT"}); err == nil {
+		t.Fatal("invalid cidr should be invalid")
+	}
+}
@@ -0,0 +1,250 @@
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu"
+)
+
+const (
+	watchInterval = time.Duration(time.Second * 5)
+	fetchTimeout  = time.Duration(time.Minute * 5)
+)
+
+var (
+	log = kungfu.GetModuleLog("geoip")
+)
+
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
+// Updater load the database file, reload on the file changed, download the database from url
+// periodically if configured
+type Updater struct {
+	// File is the mmdb file path
+	File string
+	// URL is the http(s) url of the mmdb, gzip or tar.gz (the MaxMind download) is extracted,
+	// empty to disable update
+	URL string
+	// Interval is the update interval of the url, 0 to download only if the file not exists
+	Interval time.Duration
+
+	lock    sync.RWMutex
+	db      *DB
+	modTime time.Time
+	stop    chan struct{}
+}
+
+// Start load the database and watch for changes
+func (u *Updater) Start() {
+	u.stop = make(chan struct{})
+
+	if err := u.Load(); err != nil {
+		if os.IsNotExist(err) && u.URL != "" {
+			log.Info("geoip database %s not found, download from %s", u.File, u.URL)
+		} else {
+			log.Error("load geoip database %s error, %v", u.File, err)
+		}
+	}
+
+	if u.URL != "" && (u.DB() == nil || u.expired()) {
+		if err := u.Update(); err != nil {
+			log.Error("update geoip database %s error, %v", u.URL, err)
+		}
+	}
+
+	go u.watchFile()
+	if u.URL != "" && u.Interval > 0 {
+		go u.schedule()
+	}
+}
+
+// Stop watching for changes
+func (u *Updater) Stop() {
+	if u.stop != nil {
+		close(u.stop)
+	}
+}
+
+// DB return the loaded database, nil if not loaded
+func (u *Updater) DB() *DB {
+	if u == nil {
+		return nil
+	}
+
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return u.db
+}
+
+// Load parse the database file
+func (u *Updater) Load() error {
+	info, err := os.Stat(u.File)
+	if err != nil {
+		return err
+	}
+
+	buf, err := ioutil.ReadFile(u.File)
+	if err != nil {
+		return err
+	}
+
+	db, err := NewDB(buf)
+	if err != nil {
+		return err
+	}
+
+	u.lock.Lock()
+	u.db = db
+	u.modTime = info.ModTime()
+	u.lock.Unlock()
+
+	log.Info("load geoip database %s, ip version: %d, nodes: %d", u.File, db.ipVersion, db.nodeCount)
+	return nil
+}
+
+// Update download the database and replace the file, the file is kept if the download invalid
+func (u *Updater) Update() error {
+	buf, err := fetch(u.URL)
+	if err != nil {
+		return err
+	}
+
+	if _, err = NewDB(buf); err != nil {
+		return err
+	}
+
+	tmp := u.File + ".tmp"
+	if err = ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, u.File); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	log.Info("update geoip database %s from %s", u.File, u.URL)
+	return u.Load()
+}
+
+// expired return true if the file is older than the update interval
+func (u *Updater) expired() bool {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return u.Interval > 0 && time.Since(u.modTime) > u.Interval
+}
+
+func (u *Updater) schedule() {
+	ticker := time.NewTicker(u.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		}
+
+		log.Debug("update geoip database %s", u.URL)
+		if err := u.Update(); err != nil {
+			log.Error("update geoip database %s error, %v", u.URL, err)
+		}
+	}
+}
+
+func (u *Updater) watchFile() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(u.File)
+		if err != nil {
+			continue
+		}
+
+		u.lock.RLock()
+		changed := !info.ModTime().Equal(u.modTime)
+		u.lock.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		log.Info("geoip database %s changed, reload", u.File)
+		if err := u.Load(); err != nil {
+			log.Error("reload geoip database %s error, %v", u.File, err)
+		}
+	}
+}
+
+// fetch download the url, the gzip is decompressed and the mmdb in the tar is extracted
+func fetch(url string) ([]byte, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s fail, status: %s", url, resp.Status)
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return extract(buf)
+}
+
+// extract the mmdb of the gzip or tar, the plain mmdb is returned as is
+func extract(buf []byte) ([]byte, error) {
+	if len(buf) > 2 && buf[0] == 0x1f && buf[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		if buf, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	// the ustar magic of the tar header
+	if len(buf) < 262 || string(buf[257:262]) != "ustar" {
+		return buf, nil
+	}
+
+	tr := tar.NewReader(bytes.NewReader(buf))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("mmdb not found in the tar")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasSuffix(filepath.Base(header.Name), ".mmdb") {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
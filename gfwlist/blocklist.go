@@ -0,0 +1,99 @@
+package gfwlist
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+)
+
+// hostsNames is the names of the hosts file not blocked
+var hostsNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// ParseBlocklist parse the adblock list get the blocked domains and the exception rules, the
+// AdBlock Plus domain rules (||example.com^), the hosts file (0.0.0.0 example.com) and the
+// domain per line are supported, the rules of url path and element hiding are ignored
+func ParseBlocklist(r io.Reader) (domains []string, rules []string, err error) {
+	domainSet := make(map[string]bool)
+	ruleSet := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "", strings.HasPrefix(line, "!"), strings.HasPrefix(line, "["), strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, RuleException):
+			if domain := blockedDomain(strings.TrimPrefix(line, RuleException)); domain != "" {
+				ruleSet[RuleException+domain] = true
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			for _, name := range fields[1:] {
+				if strings.HasPrefix(name, "#") {
+					break
+				}
+				name = strings.ToLower(name)
+				if !hostsNames[name] && domainPattern.MatchString(name) {
+					domainSet[name] = true
+				}
+			}
+			continue
+		}
+
+		if domain := blockedDomain(line); domain != "" {
+			domainSet[domain] = true
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return sortedKeys(domainSet), sortedKeys(ruleSet), nil
+}
+
+// blockedDomain get the domain of the AdBlock Plus domain rule or the plain domain, empty if
+// the rule blocks the url path or hides the element
+func blockedDomain(rule string) string {
+	if strings.Contains(rule, "##") || strings.Contains(rule, "#@#") {
+		return ""
+	}
+
+	// the rules with the options apply to the requests of some type, not the whole domain
+	if i := strings.Index(rule, "$"); i >= 0 {
+		if rule[i+1:] != "important" {
+			return ""
+		}
+		rule = rule[:i]
+	}
+
+	rule = strings.TrimPrefix(rule, "||")
+	rule = strings.TrimSuffix(rule, "^")
+	if strings.ContainsAny(rule, "/*^|") {
+		return ""
+	}
+
+	rule = strings.ToLower(strings.Trim(rule, "."))
+	if !domainPattern.MatchString(rule) {
+		return ""
+	}
+	return rule
+}
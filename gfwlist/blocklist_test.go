@@ -0,0 +1,44 @@
+package gfwlist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	list := `[Adblock Plus 2.0]
+! Title: test list
+||ads.example.com^
+||tracker.example.net^$important
+||cdn.example.org^$third-party
+||example.com/banner.png
+example.com##.ad
+@@||good.example.com^
+# hosts file
+0.0.0.0 localhost
+0.0.0.0 Pixel.Example.com metrics.example.com # inline comment
+127.0.0.1 stats.example.com
+doubleclick.net
+`
+	domains, rules, err := ParseBlocklist(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"ads.example.com",
+		"doubleclick.net",
+		"metrics.example.com",
+		"pixel.example.com",
+		"stats.example.com",
+		"tracker.example.net",
+	}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Fatal("unexpected domains", domains)
+	}
+
+	if !reflect.DeepEqual(rules, []string{"@@good.example.com"}) {
+		t.Fatal("unexpected rules", rules)
+	}
+}
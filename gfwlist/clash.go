@@ -0,0 +1,164 @@
+package gfwlist
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the policies of the clash rules, the other policies (proxy names and groups) are proxied
+const (
+	PolicyDirect = "DIRECT"
+	PolicyProxy  = "PROXY"
+	PolicyReject = "REJECT"
+)
+
+var clashPattern = regexp.MustCompile(`(?m)^(payload|rules):`)
+
+// ClashRules is the rules of the clash rule file classified by the policy
+type ClashRules struct {
+	// Proxy, Direct and Reject is the domain rules in the format of the matcher
+	Proxy  []string
+	Direct []string
+	Reject []string
+	// IP is the ip rules in the format of the geoip rules, e.g. IP-CIDR,1.0.0.0/8,DIRECT
+	IP []string
+	// Skipped is the count of the unsupported rules, e.g. PROCESS-NAME, DST-PORT
+	Skipped int
+}
+
+// IsClash report whether the data is the clash config or rule provider
+func IsClash(data []byte) bool {
+	return clashPattern.Match(data)
+}
+
+// ParseClash parse the rules of the clash config (rules) or rule provider (payload), the payload
+// of classical (DOMAIN-SUFFIX,google.com), domain (+.google.com) and ipcidr (1.0.0.0/8) behavior
+// supported, the rules without policy (the payload) are of the policy
+func ParseClash(data []byte, policy string) (*ClashRules, error) {
+	var file struct {
+		Payload []string
+		Rules   []string
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse clash rules error, %v", err)
+	}
+
+	c := new(ClashRules)
+	for _, rule := range append(file.Payload, file.Rules...) {
+		if err := c.add(strings.TrimSpace(rule), policy); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *ClashRules) add(rule string, policy string) error {
+	if rule == "" || strings.HasPrefix(rule, "#") {
+		return nil
+	}
+
+	if !strings.Contains(rule, ",") {
+		if _, _, err := net.ParseCIDR(rule); err == nil {
+			return c.add("IP-CIDR,"+rule, policy)
+		}
+		c.addDomain(domainBehavior(rule), policy)
+		return nil
+	}
+
+	fields := strings.Split(rule, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	typ := strings.ToUpper(fields[0])
+	if typ == "MATCH" || typ == "FINAL" {
+		if len(fields) > 1 {
+			policy = fields[1]
+		}
+		c.addIP("MATCH", policy)
+		return nil
+	}
+
+	if len(fields) < 2 || fields[1] == "" {
+		return fmt.Errorf("invalid clash rule %s", rule)
+	}
+	value := fields[1]
+	if len(fields) > 2 {
+		policy = fields[2]
+	}
+
+	switch typ {
+	case "DOMAIN":
+		c.addDomain(RuleWildcard+strings.ToLower(value), policy)
+	case "DOMAIN-SUFFIX":
+		c.addDomain(strings.ToLower(strings.TrimPrefix(value, ".")), policy)
+	case "DOMAIN-KEYWORD":
+		c.addDomain(RuleKeyword+strings.ToLower(value), policy)
+	case "DOMAIN-REGEX":
+		if _, err := regexp.Compile(value); err != nil {
+			return fmt.Errorf("invalid clash rule %s, %v", rule, err)
+		}
+		c.addDomain(RuleRegex+value, policy)
+	case "IP-CIDR", "IP-CIDR6":
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return fmt.Errorf("invalid clash rule %s, %v", rule, err)
+		}
+		c.addIP(typ+","+value, policy)
+	case "GEOIP":
+		c.addIP("GEOIP,"+strings.ToUpper(value), policy)
+	default:
+		c.Skipped++
+	}
+	return nil
+}
+
+// domainBehavior convert the domain of the domain behavior payload to the matcher rule,
+// +.google.com is the domain and the subdomains, .google.com and *.google.com the subdomains
+func domainBehavior(domain string) string {
+	domain = strings.ToLower(domain)
+	switch {
+	case strings.HasPrefix(domain, "+."):
+		return domain[2:]
+	case strings.HasPrefix(domain, "."):
+		return RuleWildcard + "*" + domain
+	}
+	// the exact domain or *.google.com
+	return RuleWildcard + domain
+}
+
+func (c *ClashRules) addDomain(rule string, policy string) {
+	switch normalizePolicy(policy) {
+	case PolicyDirect:
+		c.Direct = append(c.Direct, rule)
+	case PolicyReject:
+		c.Reject = append(c.Reject, rule)
+	default:
+		c.Proxy = append(c.Proxy, rule)
+	}
+}
+
+// addIP add the ip rule, the ips are proxied or relayed directly, not rejected
+func (c *ClashRules) addIP(rule string, policy string) {
+	switch normalizePolicy(policy) {
+	case PolicyDirect:
+		c.IP = append(c.IP, rule+","+PolicyDirect)
+	case PolicyReject:
+		c.Skipped++
+	default:
+		c.IP = append(c.IP, rule+","+PolicyProxy)
+	}
+}
+
+func normalizePolicy(policy string) string {
+	switch strings.ToUpper(policy) {
+	case PolicyDirect:
+		return PolicyDirect
+	case PolicyReject, "REJECT-DROP":
+		return PolicyReject
+	}
+	return PolicyProxy
+}
@@ -0,0 +1,78 @@
+package gfwlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClash(t *testing.T) {
+	config := []byte(`port: 7890
+rules:
+  - DOMAIN-SUFFIX,Google.com,Proxy
+  - DOMAIN,www.example.com,DIRECT
+  - DOMAIN-KEYWORD,facebook,Proxy
+  - DOMAIN-SUFFIX,ads.example.com,REJECT
+  - IP-CIDR,192.168.0.0/16,DIRECT,no-resolve
+  - GEOIP,cn,DIRECT
+  - PROCESS-NAME,curl,DIRECT
+  - MATCH,Proxy
+`)
+	if !IsClash(config) {
+		t.Fatal("should be clash rules")
+	}
+
+	c, err := ParseClash(config, PolicyProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c.Proxy, []string{"google.com", "keyword:facebook"}) {
+		t.Fatal("unexpected proxy rules", c.Proxy)
+	}
+	if !reflect.DeepEqual(c.Direct, []string{"wildcard:www.example.com"}) {
+		t.Fatal("unexpected direct rules", c.Direct)
+	}
+	if !reflect.DeepEqual(c.Reject, []string{"ads.example.com"}) {
+		t.Fatal("unexpected reject rules", c.Reject)
+	}
+
+	ip := []string{"IP-CIDR,192.168.0.0/16,DIRECT", "GEOIP,CN,DIRECT", "MATCH,PROXY"}
+	if !reflect.DeepEqual(c.IP, ip) {
+		t.Fatal("unexpected ip rules", c.IP)
+	}
+	if c.Skipped != 1 {
+		t.Fatal("unexpected skipped", c.Skipped)
+	}
+}
+
+func TestParseClashProvider(t *testing.T) {
+	provider := []byte(`payload:
+  - '+.google.com'
+  - '.youtube.com'
+  - 'www.example.com'
+  - '1.0.0.0/8'
+  - 'DOMAIN-SUFFIX,twitter.com'
+`)
+	c, err := ParseClash(provider, PolicyReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"google.com", "wildcard:*.youtube.com", "wildcard:www.example.com", "twitter.com"}
+	if !reflect.DeepEqual(c.Reject, expected) {
+		t.Fatal("unexpected reject rules", c.Reject)
+	}
+
+	// the ips are not rejected
+	if len(c.IP) != 0 || c.Skipped != 1 {
+		t.Fatal("unexpected ip rules", c.IP, c.Skipped)
+	}
+
+	if IsClash([]byte("||google.com\n")) {
+		t.Fatal("gfwlist should not be clash rules")
+	}
+
+	if _, err := ParseClash([]byte("rules:\n  - IP-CIDR,1.0.0.0,DIRECT\n"), PolicyProxy); err == nil {
+		t.Fatal("invalid cidr should be invalid")
+	}
+}
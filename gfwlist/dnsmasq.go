@@ -0,0 +1,75 @@
+package gfwlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Dnsmasq write the dnsmasq config of the proxy domains and rules, server=/<domain>/<server> to
+// resolve the domains by the server, and ipset=/<domain>/<ipset> to add the answers to the ipset
+// if ipset not empty, the exceptions are resolved by the default upstream (server=/<domain>/#).
+// dnsmasq matches the domain and the subdomains, so *.example.com and the exact wildcard rules
+// are converted to the domain, the keyword, regex and other wildcard rules are skipped
+func Dnsmasq(w io.Writer, domains []string, rules []string, server string, ipset string) (skipped int, err error) {
+	host := server
+	if i := strings.Index(server, "#"); i >= 0 {
+		host = server[:i]
+	}
+	if net.ParseIP(host) == nil {
+		return 0, fmt.Errorf("invalid dns server %s, should be ip or ip#port", server)
+	}
+
+	proxied := make(map[string]bool, len(domains))
+	excepted := make(map[string]bool)
+	for _, domain := range domains {
+		proxied[strings.ToLower(domain)] = true
+	}
+
+	for _, rule := range rules {
+		set := proxied
+		if strings.HasPrefix(rule, RuleException) {
+			set = excepted
+			rule = strings.TrimPrefix(rule, RuleException)
+		}
+
+		if domain := dnsmasqDomain(rule); domain != "" {
+			set[domain] = true
+		} else {
+			skipped++
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, domain := range sortedKeys(proxied) {
+		if excepted[domain] {
+			continue
+		}
+		fmt.Fprintf(bw, "server=/%s/%s\n", domain, server)
+		if ipset != "" {
+			fmt.Fprintf(bw, "ipset=/%s/%s\n", domain, ipset)
+		}
+	}
+	for _, domain := range sortedKeys(excepted) {
+		fmt.Fprintf(bw, "server=/%s/#\n", domain)
+	}
+	return skipped, bw.Flush()
+}
+
+// dnsmasqDomain convert the rule to the domain of dnsmasq, empty if can't
+func dnsmasqDomain(rule string) string {
+	rule = strings.ToLower(rule)
+	switch {
+	case strings.HasPrefix(rule, RuleKeyword), strings.HasPrefix(rule, RuleRegex):
+		return ""
+	case strings.HasPrefix(rule, RuleWildcard):
+		rule = strings.TrimPrefix(strings.TrimPrefix(rule, RuleWildcard), "*.")
+	}
+
+	if !domainPattern.MatchString(rule) {
+		return ""
+	}
+	return rule
+}
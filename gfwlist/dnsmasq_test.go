@@ -0,0 +1,38 @@
+package gfwlist
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDnsmasq(t *testing.T) {
+	domains := []string{"google.com", "Twitter.com", "example.com"}
+	rules := []string{"wildcard:*.youtube.com", "keyword:facebook", "wildcard:*.google.*", "@@example.com", "@@google.cn"}
+
+	var buf bytes.Buffer
+	skipped, err := Dnsmasq(&buf, domains, rules, "127.0.0.1#5353", "gfwlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `server=/google.com/127.0.0.1#5353
+ipset=/google.com/gfwlist
+server=/twitter.com/127.0.0.1#5353
+ipset=/twitter.com/gfwlist
+server=/youtube.com/127.0.0.1#5353
+ipset=/youtube.com/gfwlist
+server=/example.com/#
+server=/google.cn/#
+`
+	if buf.String() != expected {
+		t.Fatal("unexpected config", buf.String())
+	}
+
+	if skipped != 2 {
+		t.Fatal("unexpected skipped", skipped)
+	}
+
+	if _, err := Dnsmasq(&buf, domains, rules, "localhost", ""); err == nil {
+		t.Fatal("dns server should be ip")
+	}
+}
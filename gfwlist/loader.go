@@ -0,0 +1,387 @@
+package gfwlist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yinheli/kungfu"
+	"github.com/yinheli/kungfu/geoip"
+	"github.com/yinheli/kungfu/internal"
+)
+
+const (
+	watchInterval = time.Duration(time.Second * 5)
+	fetchTimeout  = time.Duration(time.Second * 30)
+)
+
+var (
+	log = kungfu.GetModuleLog("gfwlist")
+)
+
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
+// OfficialURL is the url of the official gfwlist
+const OfficialURL = "https://raw.githubusercontent.com/gfwlist/gfwlist/master/gfwlist.txt"
+
+// Loader load the gfwlist into the proxy domain set,
+// reload on SIGHUP or the source file changed, update url source periodically
+type Loader struct {
+	Store internal.Store
+	// Source is the gfwlist file path or http(s) url
+	Source string
+	// Interval is the update interval of url source, 0 to disable
+	Interval time.Duration
+	// Elector elect the instance to load the source if multiple instances share the store,
+	// nil to always load
+	Elector *internal.Elector
+	// Reject is the name of the reject list the adblock source loaded into, empty to load the
+	// gfwlist into the proxy domain set
+	Reject string
+	// Mode is the mode of the reject list
+	Mode string
+	// Schedule is the time windows the reject list is active, empty for always
+	Schedule string
+
+	lock       sync.Mutex
+	modTime    time.Time
+	lastUpdate time.Time
+	stop       chan struct{}
+}
+
+// Start load the gfwlist and watch for changes
+func (l *Loader) Start() {
+	l.stop = make(chan struct{})
+
+	if !l.isLeader() {
+		log.Info("not the leader, skip loading gfwlist %s", l.Source)
+	} else if err := l.Load(); err != nil {
+		log.Error("load gfwlist %s error, %v", l.Source, err)
+	}
+
+	go l.watchSignal()
+
+	if !isURL(l.Source) {
+		go l.watchFile()
+	} else if l.Interval > 0 {
+		go l.schedule()
+	}
+}
+
+// Stop watching for changes, e.g. the source is changed
+func (l *Loader) Stop() {
+	if l.stop != nil {
+		close(l.stop)
+	}
+}
+
+// isLeader report whether this instance should load the source
+func (l *Loader) isLeader() bool {
+	return l.Elector == nil || l.Elector.IsLeader()
+}
+
+// LastUpdate is the time of the last successful update
+func (l *Loader) LastUpdate() time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lastUpdate
+}
+
+// Load parse the source and update the proxy domain set
+func (l *Loader) Load() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	rc, err := l.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+
+	if l.Reject != "" {
+		return l.loadReject(data)
+	}
+
+	if IsClash(data) {
+		return l.loadClash(data)
+	}
+
+	domains, rules, err := Parse(bytes.NewReader(Decode(data)))
+	if err != nil {
+		return err
+	}
+
+	if len(domains) == 0 {
+		return fmt.Errorf("no domain found in %s", l.Source)
+	}
+
+	if _, err = NewMatcher(rules); err != nil {
+		return err
+	}
+
+	added, removed, err := l.Store.UpdateSet(internal.GetRedisProxyDomainSetKey(),
+		internal.GetRedisProxyDomainLoadedSetKey(), domains)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = l.Store.UpdateSet(internal.GetRedisProxyRuleSetKey(),
+		internal.GetRedisProxyRuleLoadedSetKey(), rules); err != nil {
+		return err
+	}
+
+	l.Store.Publish(internal.GetRedisProxyDomainChannelKey(), l.Source)
+
+	l.lastUpdate = time.Now()
+	l.Store.Set(internal.GetRedisProxyDomainUpdatedKey(), strconv.FormatInt(l.lastUpdate.Unix(), 10), 0)
+
+	log.Info("load gfwlist %s, domains: %d, added: %d, removed: %d, pattern rules: %d",
+		l.Source, len(domains), added, removed, len(rules))
+	return nil
+}
+
+// loadClash parse the clash rules and update the proxy domain set and the geoip rules, the
+// DIRECT domains are the exception rules, must be called with the lock
+func (l *Loader) loadClash(data []byte) error {
+	c, err := ParseClash(data, PolicyProxy)
+	if err != nil {
+		return err
+	}
+
+	domains, rules := splitRules(c.Proxy)
+	for _, rule := range c.Direct {
+		rules = append(rules, RuleException+rule)
+	}
+
+	if len(domains) == 0 && len(rules) == 0 && len(c.IP) == 0 {
+		return fmt.Errorf("no rule found in %s", l.Source)
+	}
+
+	if _, err = NewMatcher(rules); err != nil {
+		return err
+	}
+
+	if _, err = geoip.ParseRules(c.IP); err != nil {
+		return err
+	}
+
+	added, removed, err := l.Store.UpdateSet(internal.GetRedisProxyDomainSetKey(),
+		internal.GetRedisProxyDomainLoadedSetKey(), domains)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = l.Store.UpdateSet(internal.GetRedisProxyRuleSetKey(),
+		internal.GetRedisProxyRuleLoadedSetKey(), rules); err != nil {
+		return err
+	}
+
+	if _, _, err = l.Store.UpdateSet(internal.GetRedisGeoIPRuleKey(),
+		internal.GetRedisGeoIPRuleLoadedKey(), c.IP); err != nil {
+		return err
+	}
+
+	l.Store.Publish(internal.GetRedisProxyDomainChannelKey(), l.Source)
+
+	l.lastUpdate = time.Now()
+	l.Store.Set(internal.GetRedisProxyDomainUpdatedKey(), strconv.FormatInt(l.lastUpdate.Unix(), 10), 0)
+
+	log.Info("load clash rules %s, domains: %d, added: %d, removed: %d, pattern rules: %d, ip rules: %d, skipped: %d",
+		l.Source, len(domains), added, removed, len(rules), len(c.IP), c.Skipped+len(c.Reject))
+	return nil
+}
+
+// splitRules split the plain domains from the pattern rules
+func splitRules(rules []string) (domains []string, patterns []string) {
+	for _, rule := range rules {
+		if strings.Contains(rule, ":") {
+			patterns = append(patterns, rule)
+		} else {
+			domains = append(domains, rule)
+		}
+	}
+	return
+}
+
+// loadReject parse the adblock list or the clash rules and update the reject list, must be
+// called with the lock
+func (l *Loader) loadReject(data []byte) error {
+	var domains, rules []string
+	if IsClash(data) {
+		c, err := ParseClash(data, PolicyReject)
+		if err != nil {
+			return err
+		}
+		domains, rules = splitRules(c.Reject)
+		for _, rule := range c.Direct {
+			rules = append(rules, RuleException+rule)
+		}
+	} else {
+		var err error
+		if domains, rules, err = ParseBlocklist(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	if len(domains) == 0 && len(rules) == 0 {
+		return fmt.Errorf("no domain found in %s", l.Source)
+	}
+
+	if _, err := NewMatcher(rules); err != nil {
+		return err
+	}
+
+	mode := l.Mode
+	if mode == "" {
+		mode = internal.RejectModeZero
+	}
+	if err := l.Store.Set(internal.GetRedisRejectModeKey(l.Reject), mode, 0); err != nil {
+		return err
+	}
+
+	var err error
+	if l.Schedule == "" {
+		err = l.Store.Del(internal.GetRedisRejectScheduleKey(l.Reject))
+	} else {
+		err = l.Store.Set(internal.GetRedisRejectScheduleKey(l.Reject), l.Schedule, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	added, removed, err := l.Store.UpdateSet(internal.GetRedisRejectKey(l.Reject),
+		internal.GetRedisRejectLoadedKey(l.Reject), append(domains, rules...))
+	if err != nil {
+		return err
+	}
+
+	l.Store.Publish(internal.GetRedisProxyDomainChannelKey(), l.Source)
+	l.lastUpdate = time.Now()
+
+	log.Info("load reject list %s from %s, domains: %d, added: %d, removed: %d, rules: %d",
+		l.Reject, l.Source, len(domains), added, removed, len(rules))
+	return nil
+}
+
+func (l *Loader) open() (io.ReadCloser, error) {
+	if isURL(l.Source) {
+		client := &http.Client{Timeout: fetchTimeout}
+		resp, err := client.Get(l.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("fetch %s fail, status: %s", l.Source, resp.Status)
+		}
+		return resp.Body, nil
+	}
+
+	f, err := os.Open(l.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := f.Stat(); err == nil {
+		l.modTime = info.ModTime()
+	}
+	return f, nil
+}
+
+func (l *Loader) schedule() {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !l.isLeader() {
+			continue
+		}
+
+		log.Debug("update gfwlist %s", l.Source)
+		if err := l.Load(); err != nil {
+			log.Error("update gfwlist %s error, %v", l.Source, err)
+		}
+	}
+}
+
+func (l *Loader) watchSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ch:
+		}
+
+		log.Info("receive SIGHUP, reload gfwlist %s", l.Source)
+		if err := l.Load(); err != nil {
+			log.Error("reload gfwlist %s error, %v", l.Source, err)
+		}
+	}
+}
+
+func (l *Loader) watchFile() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !l.isLeader() {
+			continue
+		}
+
+		info, err := os.Stat(l.Source)
+		if err != nil {
+			continue
+		}
+
+		l.lock.Lock()
+		changed := !info.ModTime().Equal(l.modTime)
+		l.lock.Unlock()
+
+		if !changed {
+			continue
+		}
+
+		log.Info("gfwlist %s changed, reload", l.Source)
+		if err := l.Load(); err != nil {
+			log.Error("reload gfwlist %s error, %v", l.Source, err)
+		}
+	}
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
@@ -0,0 +1,230 @@
+package gfwlist
+
+import (
+	"regexp"
+	"strings"
+)
+
+// rule prefixes of the pattern rules
+const (
+	RuleWildcard = "wildcard:"
+	RuleKeyword  = "keyword:"
+	RuleRegex    = "regex:"
+	// RuleException is the prefix of exception rule, e.g. @@google.cn, @@wildcard:*.example.com
+	RuleException = "@@"
+)
+
+// Matcher match domain against the suffix, wildcard, keyword and regex rules
+type Matcher struct {
+	suffix *suffixTrie
+	// pattern is the combined regexp of the wildcard, keyword and regex rules
+	pattern *regexp.Regexp
+	// patternRules is the rules of the pattern in order, for explaining the matched rule
+	patternRules []string
+	// exception force the matched domains not proxy
+	exception *Matcher
+	count     int
+}
+
+// NewMatcher compile the rules, rule without prefix is domain suffix rule, the regex rules
+// invalid are skipped and logged
+func NewMatcher(rules []string) (*Matcher, error) {
+	m := &Matcher{suffix: newSuffixTrie()}
+
+	var patterns []string
+	var exceptions []string
+	for _, rule := range rules {
+		switch {
+		case strings.HasPrefix(rule, RuleException):
+			exceptions = append(exceptions, strings.TrimPrefix(rule, RuleException))
+		case strings.HasPrefix(rule, RuleWildcard):
+			// the query names are lowercase
+			glob := strings.ToLower(strings.TrimPrefix(rule, RuleWildcard))
+			if suffix := strings.TrimPrefix(glob, "*."); !strings.Contains(suffix, "*") && suffix != glob {
+				// *.example.com matches the subdomains only
+				m.suffix.add(suffix, true)
+				break
+			}
+			patterns = append(patterns, globToRegexp(glob))
+			m.patternRules = append(m.patternRules, rule)
+		case strings.HasPrefix(rule, RuleKeyword):
+			patterns = append(patterns, regexp.QuoteMeta(strings.ToLower(strings.TrimPrefix(rule, RuleKeyword))))
+			m.patternRules = append(m.patternRules, rule)
+		case strings.HasPrefix(rule, RuleRegex):
+			re := strings.TrimPrefix(rule, RuleRegex)
+			if _, err := regexp.Compile(re); err != nil {
+				// a rule RE2 can't compile, e.g. the lookahead, should not fail the whole list
+				log.Warning("skip the invalid regex rule %s, %v", re, err)
+				continue
+			}
+			patterns = append(patterns, re)
+			m.patternRules = append(m.patternRules, rule)
+		default:
+			m.suffix.add(strings.ToLower(rule), false)
+		}
+		m.count++
+	}
+
+	if len(patterns) > 0 {
+		pattern, err := regexp.Compile("(?:" + strings.Join(patterns, ")|(?:") + ")")
+		if err != nil {
+			return nil, err
+		}
+		m.pattern = pattern
+	}
+
+	if len(exceptions) > 0 {
+		exception, err := NewMatcher(exceptions)
+		if err != nil {
+			return nil, err
+		}
+		m.exception = exception
+	}
+
+	return m, nil
+}
+
+// Match check if the domain (with or without the trailing dot) matches any rule
+func (m *Matcher) Match(domain string) bool {
+	if m == nil {
+		return false
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return false
+	}
+
+	if m.exception.Match(domain) {
+		return false
+	}
+
+	if m.suffix.match(domain) {
+		return true
+	}
+
+	if m.pattern == nil {
+		return false
+	}
+
+	// AutoProxy regex rules are written for url
+	return m.pattern.MatchString(domain) || m.pattern.MatchString("http://"+domain+"/")
+}
+
+// Explain return the rule matched the domain, ok is false if no rule matched or the domain is
+// excepted, then the rule is the exception rule with the @@ prefix
+func (m *Matcher) Explain(domain string) (rule string, ok bool) {
+	if m == nil {
+		return "", false
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return "", false
+	}
+
+	if rule, ok := m.exception.Explain(domain); ok {
+		return RuleException + rule, false
+	}
+
+	if suffix, sub, ok := m.suffix.lookup(domain); ok {
+		if sub {
+			return RuleWildcard + "*." + suffix, true
+		}
+		return suffix, true
+	}
+
+	if m.pattern == nil || !m.Match(domain) {
+		return "", false
+	}
+
+	// the combined regexp doesn't tell which rule, match the rules one by one
+	for _, rule := range m.patternRules {
+		if single, err := NewMatcher([]string{rule}); err == nil && single.Match(domain) {
+			return rule, true
+		}
+	}
+	return "", false
+}
+
+// Len is the count of rules
+func (m *Matcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.count
+}
+
+func globToRegexp(glob string) string {
+	parts := strings.Split(glob, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
+
+// suffixTrie is the trie of domain labels in reverse order
+type suffixTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	// end the domain itself and it's subdomains match
+	end bool
+	// sub only the subdomains match
+	sub bool
+}
+
+func newSuffixTrie() *suffixTrie {
+	return &suffixTrie{root: &trieNode{}}
+}
+
+func (t *suffixTrie) add(domain string, subOnly bool) {
+	labels := strings.Split(domain, ".")
+	node := t.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+
+		child, ok := node.children[labels[i]]
+		if !ok {
+			child = &trieNode{}
+			node.children[labels[i]] = child
+		}
+		node = child
+	}
+
+	if subOnly {
+		node.sub = true
+	} else {
+		node.end = true
+	}
+}
+
+func (t *suffixTrie) match(domain string) bool {
+	_, _, ok := t.lookup(domain)
+	return ok
+}
+
+// lookup return the suffix matched the domain, sub is true if the suffix matches the
+// subdomains only
+func (t *suffixTrie) lookup(domain string) (suffix string, sub bool, ok bool) {
+	labels := strings.Split(domain, ".")
+	node := t.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = node.children[labels[i]]
+		if node == nil {
+			return "", false, false
+		}
+
+		if node.end {
+			return strings.Join(labels[i:], "."), false, true
+		}
+		if node.sub && i > 0 {
+			return strings.Join(labels[i:], "."), true, true
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,86 @@
+package gfwlist
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	m, err := NewMatcher([]string{
+		"google.com",
+		RuleWildcard + "*.example.com",
+		RuleWildcard + "*.blogspot.*",
+		RuleKeyword + "falun",
+		RuleWildcard + "*.Mixed.Example.org",
+		RuleWildcard + "*.Shop.*",
+		RuleKeyword + "Tibet",
+		RuleRegex + `^https?:\/\/[^\/]+wikipedia\.org`,
+		RuleException + "direct.google.com",
+		RuleException + RuleWildcard + "*.cn.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"google.com.":           true,
+		"www.google.com":        true,
+		"agoogle.com":           false,
+		"example.com":           false,
+		"www.example.com":       true,
+		"abc.blogspot.co.uk":    true,
+		"www.falundafa.org":     true,
+		"zh.wikipedia.org":      true,
+		"www.mixed.example.org": true,
+		"WWW.Mixed.Example.ORG": true,
+		"mixed.example.org":     false,
+		"a.shop.example.net":    true,
+		"freetibet.org":         true,
+		"baidu.com":             false,
+		"direct.google.com":     false,
+		"a.direct.google.com":   false,
+		"a.cn.example.com":      false,
+		".":                     false,
+	}
+
+	for domain, expect := range cases {
+		if m.Match(domain) != expect {
+			t.Fatalf("match %s should be %v", domain, expect)
+		}
+	}
+
+	// the invalid regex is skipped
+	m, err = NewMatcher([]string{RuleRegex + "(", RuleRegex + "^(?!www)", "google.com"})
+	if err != nil || m.Len() != 1 || !m.Match("google.com") {
+		t.Fatal("invalid regex should be skipped", err)
+	}
+}
+
+func TestMatcherExplain(t *testing.T) {
+	m, err := NewMatcher([]string{
+		"google.com",
+		RuleWildcard + "*.example.com",
+		RuleKeyword + "falun",
+		RuleRegex + `wikipedia\.org`,
+		RuleException + "direct.google.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		domain string
+		rule   string
+		ok     bool
+	}{
+		{"www.google.com.", "google.com", true},
+		{"www.example.com", RuleWildcard + "*.example.com", true},
+		{"www.falundafa.org", RuleKeyword + "falun", true},
+		{"zh.wikipedia.org", RuleRegex + `wikipedia\.org`, true},
+		{"a.direct.google.com", RuleException + "direct.google.com", false},
+		{"baidu.com", "", false},
+	}
+
+	for _, c := range cases {
+		if rule, ok := m.Explain(c.domain); rule != c.rule || ok != c.ok {
+			t.Fatalf("explain %s should be %s %v, got %s %v", c.domain, c.rule, c.ok, rule, ok)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package gfwlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	domainPattern  = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+	globPattern    = regexp.MustCompile(`^[a-z0-9.*-]+$`)
+	keywordPattern = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+)
+
+// Parse parse the gfwlist (AutoProxy format) get the proxy domains and the pattern rules
+func Parse(r io.Reader) (domains []string, rules []string, err error) {
+	domainSet := make(map[string]bool)
+	ruleSet := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		domain, rule := parseRule(scanner.Text())
+		if domain != "" {
+			domainSet[domain] = true
+		}
+		if rule != "" {
+			ruleSet[rule] = true
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return sortedKeys(domainSet), sortedKeys(ruleSet), nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for k := range set {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Decode decode the base64 encoded gfwlist, plain text list is returned as is
+func Decode(data []byte) []byte {
+	if bytes.Contains(data, []byte("[AutoProxy")) {
+		return data
+	}
+
+	compact := bytes.Join(bytes.Fields(data), nil)
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(compact)))
+	n, err := base64.StdEncoding.Decode(decoded, compact)
+	if err != nil {
+		return data
+	}
+	return decoded[:n]
+}
+
+// parseRule get the domain or the pattern rule of the line, empty if not supported
+func parseRule(line string) (domain string, rule string) {
+	line = strings.TrimSpace(line)
+
+	switch {
+	case line == "":
+		return
+	case strings.HasPrefix(line, "!"), strings.HasPrefix(line, "["):
+		// comment and header
+		return
+	case strings.HasPrefix(line, RuleException):
+		domain, rule = parseRule(strings.TrimPrefix(line, RuleException))
+		if domain != "" {
+			return "", RuleException + domain
+		}
+		if rule != "" {
+			return "", RuleException + rule
+		}
+		return
+	case len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/"):
+		return "", RuleRegex + line[1:len(line)-1]
+	}
+
+	switch {
+	case strings.HasPrefix(line, "||"):
+		line = line[2:]
+	case strings.HasPrefix(line, "|"):
+		line = line[1:]
+	case strings.HasPrefix(line, "."):
+		line = line[1:]
+	}
+
+	if strings.Contains(line, "*") {
+		if glob := globHostOf(line); glob != "" {
+			return "", RuleWildcard + glob
+		}
+		return
+	}
+
+	if keywordPattern.MatchString(line) {
+		return "", RuleKeyword + strings.ToLower(line)
+	}
+
+	return hostOf(line), ""
+}
+
+// globHostOf get the host part of wildcard rule
+func globHostOf(s string) string {
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+
+	if i := strings.IndexAny(s, "/:?"); i >= 0 {
+		s = s[:i]
+	}
+
+	s = strings.ToLower(strings.Trim(s, "."))
+	if s == "" || s == "*" || !globPattern.MatchString(s) {
+		return ""
+	}
+	return s
+}
+
+func hostOf(s string) string {
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return ""
+		}
+		s = u.Hostname()
+	} else if i := strings.IndexAny(s, "/:?"); i >= 0 {
+		s = s[:i]
+	}
+
+	s = strings.ToLower(strings.Trim(s, "."))
+	if !domainPattern.MatchString(s) {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,54 @@
+package gfwlist
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	list := `[AutoProxy 0.2.9]
+! comment
+||google.com
+|https://www.example.com/path
+.twitter.com
+facebook.com/some/path
+@@||exception.com
+/^https?:\/\/[^\/]+regex\.com/
+*.wildcard.com
+||Google.com
+keyword
+`
+
+	domains, rules, err := Parse(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"facebook.com", "google.com", "twitter.com", "www.example.com"}
+	if strings.Join(domains, ",") != strings.Join(expect, ",") {
+		t.Fatalf("expect %v, got %v", expect, domains)
+	}
+
+	expectRules := []string{
+		RuleException + "exception.com",
+		RuleKeyword + "keyword",
+		RuleRegex + `^https?:\/\/[^\/]+regex\.com`,
+		RuleWildcard + "*.wildcard.com",
+	}
+	if strings.Join(rules, ",") != strings.Join(expectRules, ",") {
+		t.Fatalf("expect %v, got %v", expectRules, rules)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	encoded := "WyBBdXRvUHJveHkgMC4yLjkgXQohIGNvbW1lbnQKfHxn\nb29nbGUuY29tCg=="
+
+	domains, _, err := Parse(strings.NewReader(string(Decode([]byte(encoded)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(domains) != 1 || domains[0] != "google.com" {
+		t.Fatalf("expect [google.com], got %v", domains)
+	}
+}
@@ -0,0 +1,406 @@
+package gfwlist
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu/geoip"
+	"github.com/yinheli/kungfu/internal"
+)
+
+// policyAllow is the exception of the reject rules, e.g. @@||example.com^ of the adblock list
+const policyAllow = "ALLOW"
+
+// subscription is the source and the rules last loaded
+type subscription struct {
+	source internal.RuleSource
+	etag   string
+	// rules is the policy of the matcher rules
+	rules map[string]string
+	// ips is the action of the geoip rules without action, e.g. IP-CIDR,1.0.0.0/8
+	ips    map[string]string
+	loaded bool
+}
+
+// Manager fetch the rule subscriptions, merge the rules by the priority and load them into the
+// proxy domain set, the proxy rule set, the geoip rules and the subscription reject list
+type Manager struct {
+	Store internal.Store
+	// Sources is the rule lists
+	Sources []internal.RuleSource
+	// Mode is the mode of the subscription reject list
+	Mode string
+	// Elector elect the instance to fetch the sources if multiple instances share the store,
+	// nil to always fetch
+	Elector *internal.Elector
+
+	lock sync.Mutex
+	subs []*subscription
+	stop chan struct{}
+}
+
+// Start load the cached lists, fetch the sources and update them periodically
+func (m *Manager) Start() {
+	m.stop = make(chan struct{})
+
+	m.lock.Lock()
+	m.subs = make([]*subscription, 0, len(m.Sources))
+	for _, source := range m.Sources {
+		sub := &subscription{source: source}
+		if err := sub.loadCache(); err != nil {
+			log.Warning("load cache of subscription %s error, %v", source.Name, err)
+		}
+		m.subs = append(m.subs, sub)
+	}
+	m.lock.Unlock()
+
+	if m.isLeader() {
+		m.Refresh()
+	} else {
+		log.Info("not the leader, skip fetching the subscriptions")
+	}
+
+	for _, sub := range m.subs {
+		if sub.source.Interval > 0 {
+			go m.schedule(sub)
+		}
+	}
+}
+
+// Stop updating the sources
+func (m *Manager) Stop() {
+	if m.stop != nil {
+		close(m.stop)
+	}
+}
+
+func (m *Manager) isLeader() bool {
+	return m.Elector == nil || m.Elector.IsLeader()
+}
+
+// Refresh fetch all the sources and apply the rules, the cached lists are applied even if the
+// sources are not modified or unreachable
+func (m *Manager) Refresh() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, sub := range m.subs {
+		if _, err := sub.fetch(); err != nil {
+			log.Error("fetch subscription %s error, %v", sub.source.Name, err)
+		}
+	}
+
+	if err := m.apply(); err != nil {
+		log.Error("apply subscriptions error, %v", err)
+	}
+}
+
+func (m *Manager) schedule(sub *subscription) {
+	ticker := time.NewTicker(sub.source.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !m.isLeader() {
+			continue
+		}
+
+		m.lock.Lock()
+		modified, err := sub.fetch()
+		if err != nil {
+			log.Error("update subscription %s error, %v", sub.source.Name, err)
+		} else if modified {
+			if err = m.apply(); err != nil {
+				log.Error("apply subscriptions error, %v", err)
+			}
+		} else {
+			log.Debug("subscription %s not modified", sub.source.Name)
+		}
+		m.lock.Unlock()
+	}
+}
+
+// apply merge the rules of the loaded subscriptions and update the sets, must be called with
+// the lock
+func (m *Manager) apply() error {
+	var subs []*subscription
+	for _, sub := range m.subs {
+		if sub.loaded {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	rules, ips := merge(subs)
+
+	var domains, proxyRules, rejectRules, ipRules []string
+	for rule, policy := range rules {
+		switch policy {
+		case PolicyProxy:
+			if strings.Contains(rule, ":") {
+				proxyRules = append(proxyRules, rule)
+			} else {
+				domains = append(domains, rule)
+			}
+		case PolicyDirect:
+			proxyRules = append(proxyRules, RuleException+rule)
+		case PolicyReject:
+			rejectRules = append(rejectRules, rule)
+		case policyAllow:
+			rejectRules = append(rejectRules, RuleException+rule)
+		}
+	}
+	for rule, action := range ips {
+		ipRules = append(ipRules, rule+","+action)
+	}
+	sort.Strings(domains)
+	sort.Strings(proxyRules)
+	sort.Strings(rejectRules)
+	sort.Strings(ipRules)
+
+	if _, err := NewMatcher(proxyRules); err != nil {
+		return err
+	}
+	if _, err := NewMatcher(rejectRules); err != nil {
+		return err
+	}
+	if _, err := geoip.ParseRules(ipRules); err != nil {
+		return err
+	}
+
+	added, removed, err := m.Store.UpdateSet(internal.GetRedisProxyDomainSetKey(),
+		internal.GetRedisSubscriptionLoadedKey(), domains)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = m.Store.UpdateSet(internal.GetRedisProxyRuleSetKey(),
+		internal.GetRedisSubscriptionRuleLoadedKey(), proxyRules); err != nil {
+		return err
+	}
+
+	if _, _, err = m.Store.UpdateSet(internal.GetRedisGeoIPRuleKey(),
+		internal.GetRedisSubscriptionGeoIPLoadedKey(), ipRules); err != nil {
+		return err
+	}
+
+	mode := m.Mode
+	if mode == "" {
+		mode = internal.RejectModeZero
+	}
+	if err = m.Store.Set(internal.GetRedisRejectModeKey(internal.SubscriptionReject), mode, 0); err != nil {
+		return err
+	}
+
+	if _, _, err = m.Store.UpdateSet(internal.GetRedisRejectKey(internal.SubscriptionReject),
+		internal.GetRedisRejectLoadedKey(internal.SubscriptionReject), rejectRules); err != nil {
+		return err
+	}
+
+	m.Store.Publish(internal.GetRedisProxyDomainChannelKey(), "subscription")
+
+	log.Info("apply subscriptions: %d, domains: %d, added: %d, removed: %d, pattern rules: %d, reject rules: %d, ip rules: %d",
+		len(subs), len(domains), added, removed, len(proxyRules), len(rejectRules), len(ipRules))
+	return nil
+}
+
+// merge the rules of the subscriptions, the policy of the same rule in multiple subscriptions
+// is of the highest priority, the first one if the same priority
+func merge(subs []*subscription) (rules map[string]string, ips map[string]string) {
+	rules = make(map[string]string)
+	ips = make(map[string]string)
+	rulePriority := make(map[string]int)
+	ipPriority := make(map[string]int)
+
+	for _, sub := range subs {
+		priority := sub.source.Priority
+		for rule, policy := range sub.rules {
+			if p, ok := rulePriority[rule]; !ok || priority > p {
+				rules[rule] = policy
+				rulePriority[rule] = priority
+			}
+		}
+		for rule, action := range sub.ips {
+			if p, ok := ipPriority[rule]; !ok || priority > p {
+				ips[rule] = action
+				ipPriority[rule] = priority
+			}
+		}
+	}
+	return
+}
+
+// fetch the source with the etag of the last response, false if not modified
+func (s *subscription) fetch() (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, s.source.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	if s.etag != "" && s.loaded {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("fetch %s fail, status: %s", s.source.URL, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	if err = s.parse(data); err != nil {
+		return false, err
+	}
+	s.etag = resp.Header.Get("ETag")
+
+	if err = s.saveCache(data); err != nil {
+		log.Warning("save cache of subscription %s error, %v", s.source.Name, err)
+	}
+	return true, nil
+}
+
+// parse the list by the type of the source
+func (s *subscription) parse(data []byte) error {
+	typ := s.source.Type
+	if typ == "" || typ == "gfwlist" {
+		if IsClash(data) {
+			typ = "clash"
+		}
+	}
+
+	policy := strings.ToUpper(s.source.Policy)
+	if policy == "" {
+		policy = PolicyProxy
+		if typ == "adblock" {
+			policy = PolicyReject
+		}
+	}
+
+	rules := make(map[string]string)
+	ips := make(map[string]string)
+
+	switch typ {
+	case "clash":
+		c, err := ParseClash(data, policy)
+		if err != nil {
+			return err
+		}
+		for _, list := range []struct {
+			rules  []string
+			policy string
+		}{{c.Proxy, PolicyProxy}, {c.Direct, PolicyDirect}, {c.Reject, PolicyReject}} {
+			for _, rule := range list.rules {
+				rules[rule] = list.policy
+			}
+		}
+		for _, rule := range c.IP {
+			i := strings.LastIndex(rule, ",")
+			ips[rule[:i]] = rule[i+1:]
+		}
+	default:
+		var domains, patterns []string
+		var err error
+		if typ == "adblock" {
+			domains, patterns, err = ParseBlocklist(bytes.NewReader(data))
+		} else {
+			domains, patterns, err = Parse(bytes.NewReader(Decode(data)))
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, rule := range append(domains, patterns...) {
+			if strings.HasPrefix(rule, RuleException) {
+				rules[strings.TrimPrefix(rule, RuleException)] = exceptPolicy(policy)
+			} else {
+				rules[rule] = policy
+			}
+		}
+	}
+
+	if len(rules) == 0 && len(ips) == 0 {
+		return fmt.Errorf("no rule found in %s", s.source.URL)
+	}
+
+	s.rules = rules
+	s.ips = ips
+	s.loaded = true
+	log.Info("load subscription %s, rules: %d, ip rules: %d", s.source.Name, len(rules), len(ips))
+	return nil
+}
+
+// exceptPolicy return the policy of the exception rules of the list
+func exceptPolicy(policy string) string {
+	switch policy {
+	case PolicyDirect:
+		return PolicyProxy
+	case PolicyReject:
+		return policyAllow
+	}
+	return PolicyDirect
+}
+
+// loadCache parse the cached list and the etag, nothing if the cache not exists
+func (s *subscription) loadCache() error {
+	if s.source.Cache == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.source.Cache)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err = s.parse(data); err != nil {
+		return err
+	}
+
+	if etag, err := ioutil.ReadFile(s.source.Cache + ".etag"); err == nil {
+		s.etag = strings.TrimSpace(string(etag))
+	}
+	return nil
+}
+
+// saveCache write the list and the etag to the cache file
+func (s *subscription) saveCache(data []byte) error {
+	if s.source.Cache == "" {
+		return nil
+	}
+
+	tmp := s.source.Cache + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.source.Cache); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.source.Cache+".etag", []byte(s.etag), 0644)
+}
@@ -0,0 +1,83 @@
+package gfwlist
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/yinheli/kungfu/internal"
+)
+
+func TestSubscription(t *testing.T) {
+	lists := map[string]string{
+		"/gfwlist": "||google.com\n||example.com\n@@||google.cn\n",
+		"/clash":   "payload:\n  - DOMAIN-SUFFIX,example.com,DIRECT\n  - DOMAIN-SUFFIX,ads.example.org,REJECT\n  - IP-CIDR,1.0.0.0/8,DIRECT\n",
+		"/adblock": "||ads.example.net^\n@@||good.ads.example.net^\n",
+	}
+	fetched := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetched++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(lists[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "subscription")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := internal.NewMemoryStore(&internal.Memory{})
+	m := &Manager{
+		Store: store,
+		Sources: []internal.RuleSource{
+			{Name: "gfwlist", URL: server.URL + "/gfwlist", Cache: filepath.Join(dir, "gfwlist.txt")},
+			// the higher priority wins example.com
+			{Name: "clash", URL: server.URL + "/clash", Priority: 1},
+			{Name: "adblock", URL: server.URL + "/adblock", Type: "adblock"},
+		},
+	}
+	m.Start()
+	defer m.Stop()
+
+	assertSet := func(key string, expected ...string) {
+		members, err := store.SMembers(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(members)
+		if !reflect.DeepEqual(members, expected) {
+			t.Fatalf("unexpected %s: %v", key, members)
+		}
+	}
+
+	assertSet(internal.GetRedisProxyDomainSetKey(), "google.com")
+	assertSet(internal.GetRedisProxyRuleSetKey(), "@@example.com", "@@google.cn")
+	assertSet(internal.GetRedisRejectKey(internal.SubscriptionReject), "@@good.ads.example.net", "ads.example.net", "ads.example.org")
+	assertSet(internal.GetRedisGeoIPRuleKey(), "IP-CIDR,1.0.0.0/8,DIRECT")
+
+	// not modified
+	m.Refresh()
+	if fetched != 3 {
+		t.Fatal("unexpected fetched", fetched)
+	}
+
+	// the cache with the etag
+	sub := &subscription{source: m.Sources[0]}
+	if err := sub.loadCache(); err != nil {
+		t.Fatal(err)
+	}
+	if sub.etag != `"v1"` || sub.rules["google.com"] != PolicyProxy || sub.rules["google.cn"] != PolicyDirect {
+		t.Fatal("unexpected cache", sub.etag, sub.rules)
+	}
+}
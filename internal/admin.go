@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// AuthEnabled return true if the token or the basic auth of the admin http apis configured
+func (a Admin) AuthEnabled() bool {
+	return a.Token != "" || a.Username != ""
+}
+
+// Protect require the bearer token or the basic auth of the admin http apis, the probes
+// /healthz and /readyz are not protected, h is returned if no auth configured
+func (a Admin) Protect(h http.Handler) http.Handler {
+	if !a.AuthEnabled() {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || a.authorized(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if a.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="kungfu"`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"unauthorized"}` + "\n"))
+	})
+}
+
+// authorized return true if the request has the token or the basic auth configured
+func (a Admin) authorized(r *http.Request) bool {
+	return a.Authorized(r.Header.Get("Authorization"))
+}
+
+// Authorized return true if the Authorization header has the token or the basic auth
+// configured, for the apis not http, e.g. the authorization metadata of the gRPC control api
+func (a Admin) Authorized(auth string) bool {
+	if a.Token != "" && strings.HasPrefix(auth, "Bearer ") {
+		return secureEqual(strings.TrimPrefix(auth, "Bearer "), a.Token)
+	}
+
+	if a.Username != "" {
+		r := &http.Request{Header: http.Header{"Authorization": {auth}}}
+		username, password, ok := r.BasicAuth()
+		return ok && secureEqual(username, a.Username) && secureEqual(password, a.Password)
+	}
+	return false
+}
+
+func secureEqual(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// Authorize set the credentials of the admin http apis to the request, the token if both
+// configured
+func (a Admin) Authorize(r *http.Request) {
+	if a.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+a.Token)
+	} else if a.Username != "" {
+		r.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// URL return the url of the admin address, https if the tls configured and the scheme omitted
+func (a Admin) URL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	if a.Cert != "" {
+		return "https://" + addr
+	}
+	return "http://" + addr
+}
+
+// Transport return the transport of the clients of the admin http apis, the certificate
+// configured is trusted besides the system roots, so the self-signed certificate works
+func (a Admin) Transport() (http.RoundTripper, error) {
+	if a.Cert == "" {
+		return http.DefaultTransport, nil
+	}
+
+	data, err := ioutil.ReadFile(a.Cert)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in %s", a.Cert)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminProtect(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(h http.Handler, path string, auth func(r *http.Request)) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != nil {
+			auth(r)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve(Admin{}.Protect(ok), "/api/stats", nil); code != http.StatusOK {
+		t.Fatal("admin api without auth configured should be open", code)
+	}
+
+	admin := Admin{Token: "secret", Username: "admin", Password: "pass"}
+	h := admin.Protect(ok)
+	if code := serve(h, "/api/stats", nil); code != http.StatusUnauthorized {
+		t.Fatal("request without credentials should be unauthorized", code)
+	}
+	if code := serve(h, "/healthz", nil); code != http.StatusOK {
+		t.Fatal("probe should not be protected", code)
+	}
+	if code := serve(h, "/api/stats", admin.Authorize); code != http.StatusOK {
+		t.Fatal("request with the token should be authorized", code)
+	}
+	if code := serve(h, "/api/stats", func(r *http.Request) { r.SetBasicAuth("admin", "pass") }); code != http.StatusOK {
+		t.Fatal("request with the basic auth should be authorized", code)
+	}
+	if code := serve(h, "/api/stats", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }); code != http.StatusUnauthorized {
+		t.Fatal("request with the wrong password should be unauthorized", code)
+	}
+	if code := serve(h, "/api/stats", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }); code != http.StatusUnauthorized {
+		t.Fatal("request with the wrong token should be unauthorized", code)
+	}
+
+	// the authorization of the apis not http
+	if !admin.Authorized("Bearer secret") || !admin.Authorized("Basic YWRtaW46cGFzcw==") {
+		t.Fatal("the token and the basic auth should be authorized")
+	}
+	if admin.Authorized("") || admin.Authorized("Basic YWRtaW46d3Jvbmc=") {
+		t.Fatal("the missing and the wrong credentials should be unauthorized")
+	}
+}
+
+func TestAdminURL(t *testing.T) {
+	if u := (Admin{}).URL("127.0.0.1:9155"); u != "http://127.0.0.1:9155" {
+		t.Fatal("unexpected url", u)
+	}
+	if u := (Admin{Cert: "admin.crt"}).URL("127.0.0.1:9155"); u != "https://127.0.0.1:9155" {
+		t.Fatal("url should be https if tls configured", u)
+	}
+	if u := (Admin{Cert: "admin.crt"}).URL("http://router:9155/"); u != "http://router:9155" {
+		t.Fatal("scheme given should be kept", u)
+	}
+
+	if _, err := (Admin{Cert: "not-exists.crt"}).Transport(); err == nil {
+		t.Fatal("transport should fail if the cert not readable")
+	}
+}
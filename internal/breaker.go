@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStoreUnavailable is returned without calling the store when the circuit breaker is open
+var ErrStoreUnavailable = errors.New("kungfu: store unavailable")
+
+const (
+	// breakerFailures open the breaker after the consecutive connection failures
+	breakerFailures = 5
+	// breakerCooldown is the time the breaker stay open before a probe call is let through
+	breakerCooldown = time.Duration(time.Second * 5)
+	// retryCost is the budget of a retry, each success call earn 1, so the retries are at
+	// most 10% of the success calls
+	retryCost = 10
+	// retryBudgetMax is the max budget saved up
+	retryBudgetMax = retryCost * 10
+)
+
+// the state of the circuit breaker
+const (
+	breakerClosed = iota
+	breakerOpen
+	// breakerHalfOpen a probe call is in flight after the cooldown
+	breakerHalfOpen
+)
+
+// breakerStore wrap the store with a circuit breaker, the calls fail fast with
+// ErrStoreUnavailable after the store is unreachable for consecutive calls, and the failed
+// read calls are retried within the retry budget, so the callers degrade at once instead of
+// waiting for the timeout of every call
+type breakerStore struct {
+	Store
+	cooldown time.Duration
+
+	lock     sync.Mutex
+	state    int
+	failures int
+	opened   time.Time
+	budget   int
+}
+
+func newBreakerStore(store Store) *breakerStore {
+	return &breakerStore{Store: store, cooldown: breakerCooldown}
+}
+
+// allow check the breaker before the call
+func (s *breakerStore) allow() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch s.state {
+	case breakerOpen:
+		if time.Since(s.opened) < s.cooldown {
+			return ErrStoreUnavailable
+		}
+		s.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return ErrStoreUnavailable
+	}
+	return nil
+}
+
+// done record the result of the call, return true if the failed call can be retried
+func (s *breakerStore) done(err error, retryable bool) bool {
+	failed := isConnError(err)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !failed {
+		if s.state != breakerClosed {
+			log.Info("store recovered, circuit breaker closed")
+		}
+		s.state = breakerClosed
+		s.failures = 0
+		if s.budget < retryBudgetMax {
+			s.budget++
+		}
+		return false
+	}
+
+	s.failures++
+	if s.state == breakerHalfOpen || (s.state == breakerClosed && s.failures >= breakerFailures) {
+		if s.state == breakerClosed {
+			log.Error("store unavailable, circuit breaker open, %v", err)
+		}
+		s.state = breakerOpen
+		s.opened = time.Now()
+		return false
+	}
+
+	if !retryable || s.state != breakerClosed || s.budget < retryCost {
+		return false
+	}
+	s.budget -= retryCost
+	return true
+}
+
+// call the store through the breaker, the read calls are retried once within the budget
+func (s *breakerStore) call(retryable bool, fn func() error) error {
+	for {
+		if err := s.allow(); err != nil {
+			return err
+		}
+
+		err := fn()
+		if !s.done(err, retryable) {
+			return err
+		}
+		retryable = false
+	}
+}
+
+// isConnError return true if the error is the store unreachable, not the error reply
+func isConnError(err error) bool {
+	if err == nil || err == ErrNil {
+		return false
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	// the pool timeout and closed errors of the redis client
+	msg := err.Error()
+	return strings.HasPrefix(msg, "redis: connection pool") || msg == "redis: client is closed"
+}
+
+func (s *breakerStore) Get(key string) (v string, err error) {
+	err = s.call(true, func() error {
+		v, err = s.Store.Get(key)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) Set(key string, value string, expiration time.Duration) error {
+	return s.call(false, func() error {
+		return s.Store.Set(key, value, expiration)
+	})
+}
+
+func (s *breakerStore) SetNX(key string, value string, expiration time.Duration) (ok bool, err error) {
+	err = s.call(false, func() error {
+		ok, err = s.Store.SetNX(key, value, expiration)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) Del(keys ...string) error {
+	return s.call(false, func() error {
+		return s.Store.Del(keys...)
+	})
+}
+
+func (s *breakerStore) Rename(key string, newKey string) error {
+	return s.call(false, func() error {
+		return s.Store.Rename(key, newKey)
+	})
+}
+
+func (s *breakerStore) TTL(key string) (ttl time.Duration, err error) {
+	err = s.call(true, func() error {
+		ttl, err = s.Store.TTL(key)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) Expire(key string, expiration time.Duration) (ok bool, err error) {
+	err = s.call(false, func() error {
+		ok, err = s.Store.Expire(key, expiration)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) Incr(key string) (v int64, err error) {
+	err = s.call(false, func() error {
+		v, err = s.Store.Incr(key)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) Keys(pattern string) (keys []string, err error) {
+	err = s.call(true, func() error {
+		keys, err = s.Store.Keys(pattern)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) Scan(cursor uint64, pattern string, count int64) (keys []string, next uint64, err error) {
+	err = s.call(true, func() error {
+		keys, next, err = s.Store.Scan(cursor, pattern, count)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) MapDomain(domainKey string, ipKey string, domain string, ip string, ttl time.Duration) (v string, err error) {
+	err = s.call(false, func() error {
+		v, err = s.Store.MapDomain(domainKey, ipKey, domain, ip, ttl)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) UpdateSet(key string, loadedKey string, members []string) (added int, removed int, err error) {
+	err = s.call(false, func() error {
+		added, removed, err = s.Store.UpdateSet(key, loadedKey, members)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) SAdd(key string, members ...string) error {
+	return s.call(false, func() error {
+		return s.Store.SAdd(key, members...)
+	})
+}
+
+func (s *breakerStore) SRem(key string, members ...string) error {
+	return s.call(false, func() error {
+		return s.Store.SRem(key, members...)
+	})
+}
+
+func (s *breakerStore) SIsMember(key string, member string) (ok bool, err error) {
+	err = s.call(true, func() error {
+		ok, err = s.Store.SIsMember(key, member)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) SMembers(key string) (members []string, err error) {
+	err = s.call(true, func() error {
+		members, err = s.Store.SMembers(key)
+		return err
+	})
+	return
+}
+
+func (s *breakerStore) XAdd(stream string, maxLen int64, values map[string]string) error {
+	return s.call(false, func() error {
+		return s.Store.XAdd(stream, maxLen, values)
+	})
+}
+
+func (s *breakerStore) Publish(channel string, message string) error {
+	return s.call(false, func() error {
+		return s.Store.Publish(channel, message)
+	})
+}
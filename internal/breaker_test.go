@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// flakyStore fail the Get calls with connection error while down
+type flakyStore struct {
+	Store
+	down  bool
+	calls int
+}
+
+func (s *flakyStore) Get(key string) (string, error) {
+	s.calls++
+	if s.down {
+		return "", &net.OpError{Op: "dial", Net: "tcp", Err: net.UnknownNetworkError("refused")}
+	}
+	return s.Store.Get(key)
+}
+
+func TestBreakerStore(t *testing.T) {
+	flaky := &flakyStore{Store: NewMemoryStore(&Memory{})}
+	store := newBreakerStore(flaky)
+	store.cooldown = time.Millisecond * 10
+
+	if err := store.Set("a", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get("missing"); err != ErrNil {
+		t.Fatal("nil reply should pass through", err)
+	}
+
+	flaky.down = true
+	for i := 0; i < breakerFailures; i++ {
+		if _, err := store.Get("a"); err == nil || err == ErrStoreUnavailable {
+			t.Fatal("connection error should be returned before the breaker open", err)
+		}
+	}
+
+	calls := flaky.calls
+	if _, err := store.Get("a"); err != ErrStoreUnavailable || flaky.calls != calls {
+		t.Fatal("open breaker should fail fast without calling the store", err)
+	}
+
+	// the probe after cooldown fail, open again
+	time.Sleep(store.cooldown * 2)
+	if _, err := store.Get("a"); err == ErrStoreUnavailable {
+		t.Fatal("probe should be let through after cooldown")
+	}
+
+	if _, err := store.Get("a"); err != ErrStoreUnavailable {
+		t.Fatal("failed probe should open the breaker again", err)
+	}
+
+	flaky.down = false
+	time.Sleep(store.cooldown * 2)
+	if v, err := store.Get("a"); err != nil || v != "1" {
+		t.Fatal("probe should close the breaker", err)
+	}
+
+	if _, err := store.Get("a"); err != nil {
+		t.Fatal("closed breaker should let calls through", err)
+	}
+}
+
+func TestBreakerStoreRetryBudget(t *testing.T) {
+	flaky := &flakyStore{Store: NewMemoryStore(&Memory{})}
+	store := newBreakerStore(flaky)
+
+	flaky.down = true
+	store.Get("a")
+	if flaky.calls != 1 {
+		t.Fatal("should not retry without budget, calls", flaky.calls)
+	}
+
+	flaky.down = false
+	for i := 0; i < retryCost; i++ {
+		store.Get("a")
+	}
+
+	flaky.down = true
+	flaky.calls = 0
+	store.Get("a")
+	if flaky.calls != 2 {
+		t.Fatal("read should be retried once within budget, calls", flaky.calls)
+	}
+}
@@ -2,20 +2,37 @@ package internal
 
 import (
 	"os"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/yinheli/kungfu"
 )
 
 var (
-	log = kungfu.GetLog()
+	log = kungfu.GetModuleLog("internal")
 )
 
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
+// defaultRedisTimeout is the default dial, read and write timeout of redis
+const defaultRedisTimeout = time.Duration(time.Second)
+
 // NewRedisClient is for create new redis client via config
 func NewRedisClient(config *Redis) (client *redis.Client) {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+
 	client = redis.NewClient(&redis.Options{
-		Addr:     config.Addr,
-		Password: config.Password,
+		Addr:         config.Addr,
+		Password:     config.Password,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
 	})
 
 	if err := client.Ping().Err(); err != nil {
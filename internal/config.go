@@ -5,68 +5,1055 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net"
+	"net/url"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/yinheli/kungfu"
+	"github.com/yinheli/kungfu/metrics"
+	"github.com/yinheli/kungfu/tracing"
 )
 
 const (
 	// NAMESPACE is for redis preifx(namespace)
 	NAMESPACE = "kungfu"
+
+	minNetworkPrefix = 8
+	maxNetworkPrefix = 30
+
+	// ConfigVersion is the latest version of config.yml, the unknown fields are rejected since
+	// version 1, the config without version is parsed as before
+	ConfigVersion = 1
 )
 
+// reservedNetworks is the ranges suitable for the fake ip network, private (RFC 1918),
+// shared address space (RFC 6598) and benchmarking (RFC 2544)
+var reservedNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"198.18.0.0/15",
+}
+
+// DefaultLocalDomains is the local domains of dns.local if not configured, mDNS (RFC 6762),
+// the common home router domain and the home network (RFC 8375)
+var DefaultLocalDomains = []string{"local", "lan", "home.arpa"}
+
 // Redis is config.yml redis struct
 type Redis struct {
 	Addr     string
 	Password string
+	// Timeout is the dial, read and write timeout, default 1s
+	Timeout time.Duration
 }
 
 func (r *Redis) String() string {
 	return fmt.Sprintln("addr:", r.Addr, "Password:", r.Password)
 }
 
+// Memory is config.yml memory store struct, the initial data of memory store
+type Memory struct {
+	Keys map[string]string
+	Sets map[string][]string
+}
+
+// Gfwlist is config.yml gfwlist struct
+type Gfwlist struct {
+	// Source is the gfwlist file path or http(s) url, empty to disable
+	Source string
+	// Interval is the update interval of url source, 0 to disable
+	Interval time.Duration
+}
+
+// GeoIP is config.yml geoip struct
+type GeoIP struct {
+	// Database is the MaxMind DB (mmdb) file path of the countries, empty to disable
+	Database string
+	// URL is the http(s) url to download the database, empty to disable update
+	URL string
+	// Interval is the update interval of the url, 0 to download only if the database not exists
+	Interval time.Duration
+}
+
+// ChnRoutes is config.yml chnroutes struct
+type ChnRoutes struct {
+	// Source is the china ip list file path or http(s) url, APNIC delegated stats or cidr per
+	// line, empty to disable
+	Source string
+	// Interval is the update interval of url source, 0 to disable
+	Interval time.Duration
+}
+
+// RejectList is config.yml reject list struct, the blocked domains of the list source
+type RejectList struct {
+	// Name is the name of the list, the domains are loaded into kungfu:reject:<name>
+	Name string
+	// Source is the adblock list (AdBlock Plus, hosts file or domain per line) file path or
+	// http(s) url
+	Source string
+	// Mode is how the domains blocked, zero (default) or reset
+	Mode string
+	// Interval is the update interval of url source, 0 to disable
+	Interval time.Duration
+	// Schedule is the time windows the list is active, e.g. mon-fri 09:00-18:00, empty for always
+	Schedule string
+}
+
+// the modes of the reject lists
+const (
+	// RejectModeZero answer 0.0.0.0 and :: to the blocked domains
+	RejectModeZero = "zero"
+	// RejectModeReset answer the fake ip to the blocked domains, the gateway reset the connections
+	RejectModeReset = "reset"
+)
+
+// RuleSubscription is config.yml subscription struct, the remote rule lists merged by the priority
+type RuleSubscription struct {
+	// Mode is how the rejected domains of the subscriptions blocked, zero (default) or reset
+	Mode string
+	// Sources is the rule lists
+	Sources []RuleSource
+}
+
+// RuleSource is the remote rule list of the subscription
+type RuleSource struct {
+	// Name is the name of the source, unique
+	Name string
+	// URL is the http(s) url of the list
+	URL string
+	// Type is the format of the list, gfwlist (default, the clash rules detected), clash or adblock
+	Type string
+	// Policy is the policy of the rules without policy, proxy (default, reject of adblock),
+	// direct or reject
+	Policy string
+	// Priority decide the policy of the rule in multiple sources, the higher wins
+	Priority int
+	// Interval is the update interval, 0 to fetch on start only
+	Interval time.Duration
+	// Cache is the local file caching the list, loaded on start and if the url is unreachable,
+	// empty to disable
+	Cache string
+}
+
+// SubscriptionReject is the name of the reject list the rejected domains of the subscriptions
+// loaded into
+const SubscriptionReject = "subscription"
+
+// Pool is config.yml pool struct, the fake ip networks written to kungfu:network and
+// kungfu:network6 on start, the store is kept if empty
+type Pool struct {
+	// Network is the ipv4 fake ip network in CIDR, e.g. 10.85.0.1/16
+	Network string
+	// Network6 is the ipv6 fake ip network in CIDR, e.g. fd00:6b75:6e67:6675::1/64
+	Network6 string
+}
+
+// Upstream is config.yml upstream struct, written to kungfu:upstream-nameserver on start,
+// the store is kept if empty
+type Upstream struct {
+	// Nameservers is the upstream nameservers, e.g. 119.29.29.29, tls://1.1.1.1 or
+	// https://dns.google/dns-query
+	Nameservers []string
+}
+
+// Outbound is config.yml outbound struct, written to kungfu:outbound:<name>,
+// kungfu:outbound-strategy:<name> and kungfu:outbound-rule:<name> on start, the outbound named
+// default is written to kungfu:proxy and kungfu:proxy-strategy
+type Outbound struct {
+	Name string
+	// Proxies is the proxy urls, e.g. socks5://127.0.0.1:1080
+	Proxies []string
+	// Strategy is how the proxy is picked, failover (default), round-robin or lowest-latency
+	Strategy string
+	// Rules is the domains relayed through the outbound, ignored of the default outbound
+	Rules []string
+	// Via is the name of the outbound the connections to the proxies are relayed through, e.g.
+	// the shadowsocks over the socks5 jump host, written to kungfu:outbound-via:<name>, not
+	// allowed of the default outbound
+	Via string
+}
+
+// OutboundDefault is the name of the outbound of kungfu:proxy
+const OutboundDefault = "default"
+
+// ViaChain follow the vias, the outbound name to the name of the outbound it relayed through,
+// from the name, return the names of the chain and false if the chain is a cycle
+func ViaChain(name string, vias map[string]string) ([]string, bool) {
+	chain := []string{name}
+	seen := map[string]bool{name: true}
+	for {
+		via, ok := vias[name]
+		if !ok || via == "" {
+			return chain, true
+		}
+		chain = append(chain, via)
+		if seen[via] {
+			return chain, false
+		}
+		seen[via] = true
+		name = via
+	}
+}
+
+// Hosts is config.yml hosts struct, static dns records in hosts file style
+type Hosts struct {
+	// File is the hosts file path
+	File string
+	// Records is the hosts file style lines, e.g. "192.168.1.1 router.lan"
+	Records []string
+}
+
+// DNS is config.yml dns struct, the dns server listeners
+type DNS struct {
+	// Listen is the ipv4 listen address of the plain dns over udp and tcp, default 0.0.0.0:53
+	Listen string
+	// ReusePort listen with SO_REUSEPORT, a new process can take over the ports before the old one shutdown
+	ReusePort bool
+	// TLS is the dns over tls listener, disabled if cert or key is empty
+	TLS DNSTLS
+	// HTTPS is the dns over https listener, serve on path /dns-query
+	HTTPS DNSHTTPS
+	// RateLimit limit the queries of each client ip
+	RateLimit DNSRateLimit
+	// ACL allow or deny the clients by subnet
+	ACL DNSACL
+	// DNSSEC validate the upstream answers
+	DNSSEC DNSSEC
+	// IPSet answer the real ips of the proxied domains and add them to the set, instead of the
+	// fake ips
+	IPSet DNSIPSet
+	// DNS64 synthesize the AAAA answers from the A answers for the ipv6 only clients behind NAT64
+	DNS64 DNS64
+	// Local is the local domains never answered the fake ip or sent to the upstream nameservers
+	Local DNSLocal
+	// Clients is the client groups resolved by the profiles of the groups, the group of the device
+	// mac or the first group contains the client ip is applied, the other clients are resolved by
+	// all the rules
+	Clients []DNSClientGroup
+	// DHCPLeases is the dhcp lease file to identify the devices of the client groups by mac,
+	// dnsmasq or isc dhcpd format, reloaded if changed
+	DHCPLeases string
+}
+
+// DNSClientGroup is config.yml dns clients struct, the rule profile of the clients in the subnets
+type DNSClientGroup struct {
+	Name string
+	// Subnets is the client subnets in CIDR or single ip
+	Subnets []string
+	// Direct resolve all the domains by the upstream nameservers, no fake ip or proxied query
+	Direct bool
+	// MACs is the mac addresses of the devices in the group, identified by the dhcp leases
+	MACs []string
+	// Rejects is the reject lists applied to the clients, empty for all the lists, none for no list
+	Rejects []string
+	// NoLog skip the query log and the query events of the clients
+	NoLog bool
+}
+
+// ClientRejectNone is the rejects of the client group to apply no reject list
+const ClientRejectNone = "none"
+
+// DNSLocal is config.yml dns local struct, the queries of the local domains are forwarded to the
+// local nameserver, or answered NXDOMAIN if not configured
+type DNSLocal struct {
+	// Domains is the local domains in the rule format of the proxy domains, default local, lan
+	// and home.arpa
+	Domains []string
+	// Nameserver is the local nameserver, e.g. the router 192.168.1.1:53, empty to answer NXDOMAIN
+	Nameserver string
+}
+
+// DNS64 is config.yml dns dns64 struct
+type DNS64 struct {
+	// Prefix is the NAT64 prefix embedded the ipv4, e.g. 64:ff9b::/96, the length is 32, 40, 48,
+	// 56, 64 or 96, empty to disable
+	Prefix string
+}
+
+// DNSIPSet is config.yml dns ipset struct, the real ips of the proxied domains are added to the
+// ipset or nftables set for the policy routing
+type DNSIPSet struct {
+	// Backend is ipset or nft, empty to disable
+	Backend string
+	// Name is the set of the ipv4 ips
+	Name string
+	// Name6 is the set of the ipv6 ips, empty to skip the ipv6 ips
+	Name6 string
+	// Table is the nftables table of the sets, default inet kungfu, nft only
+	Table string
+	// Interval is the flush interval of the batch, default 1s
+	Interval time.Duration
+	// MinTimeout is the minimum timeout of the ips in the set, default 5m, the answer ttl otherwise
+	MinTimeout time.Duration
+}
+
+// DNSSEC is config.yml dns dnssec struct, the validating of the upstream answers
+type DNSSEC struct {
+	// Enable validate the upstream answers, set AD if secure and answer SERVFAIL if bogus
+	Enable bool
+	// TrustAnchor is the file of DS or DNSKEY records in zone file format, default the root KSK
+	TrustAnchor string
+}
+
+// DNSRateLimit is config.yml dns rate limit struct, the queries exceed the limit are dropped
+type DNSRateLimit struct {
+	// QPS is the queries per second of each client ip, 0 to disable
+	QPS float64
+	// Burst is the max queries at once, default QPS
+	Burst int
+}
+
+// DNSACL is config.yml dns client acl struct, subnet in CIDR or single ip, the denied clients
+// are refused, all the clients are allowed if Allow is empty
+type DNSACL struct {
+	Allow []string
+	Deny  []string
+}
+
+// DNSTLS is config.yml dns over tls listener struct
+type DNSTLS struct {
+	// Listen is the listen address, default 0.0.0.0:853
+	Listen string
+	// Cert is the certificate file path in PEM format
+	Cert string
+	// Key is the private key file path in PEM format
+	Key string
+}
+
+// DNSHTTPS is config.yml dns over https listener struct
+type DNSHTTPS struct {
+	// Listen is the listen address, empty to disable
+	Listen string
+	// Cert is the certificate file path in PEM format, serve plain http if empty (e.g. behind reverse proxy)
+	Cert string
+	// Key is the private key file path in PEM format
+	Key string
+}
+
+// Metrics is config.yml metrics struct, the listen address of /metrics, empty to disable
+type Metrics struct {
+	DNS     string
+	Gateway string
+	// Push push the metrics in the influx line protocol to InfluxDB or VictoriaMetrics
+	Push MetricsPush
+}
+
+// MetricsPush is config.yml metrics push struct, disabled if url is empty
+type MetricsPush struct {
+	// URL is the write api url, e.g. http://127.0.0.1:8086/write?db=kungfu,
+	// http://127.0.0.1:8086/api/v2/write?org=home&bucket=kungfu or http://127.0.0.1:8428/write
+	URL string
+	// Token is the api token of InfluxDB 2, empty to skip
+	Token string
+	// Interval is the push interval, default 10s
+	Interval time.Duration
+}
+
+// NewPusher create the metrics pusher of the service (dns or gateway), the series are tagged
+// with the host and the service, nil if the push url is empty
+func (m Metrics) NewPusher(service string) *metrics.Pusher {
+	if m.Push.URL == "" {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	return &metrics.Pusher{
+		URL:      m.Push.URL,
+		Token:    m.Push.Token,
+		Interval: m.Push.Interval,
+		Tags:     map[string]string{"host": host, "service": service},
+	}
+}
+
+// Gateway is config.yml gateway struct, the settings of the host the gateway running on
+type Gateway struct {
+	// Mode is how the connections to the fake ip network are intercepted, tun (default) or
+	// tproxy, the tproxy mode need the TPROXY rules of iptables, only supported on linux
+	Mode string
+	// SystemDNS is the dns server set to the system while the gateway running, restored on
+	// shutdown, empty to disable, only supported on macOS and windows, on windows the dns
+	// queries of the interfaces other than the tun are blocked by WFP
+	SystemDNS string
+	// Firewall is the iptables rules installed with -setup-firewall
+	Firewall GatewayFirewall
+	// Socket is the options of the tcp sockets of the relay listeners and the outbound dialers
+	Socket GatewaySocket
+}
+
+// the interception modes of the gateway
+const (
+	// GatewayModeTun route the fake ip network to the tun, the connections are redirected to
+	// the relay servers by the userspace nat
+	GatewayModeTun = "tun"
+	// GatewayModeTProxy redirect the fake ip network to the relay servers by the TPROXY of
+	// iptables, the original destination is kept by the sockets
+	GatewayModeTProxy = "tproxy"
+)
+
+// GatewayFirewall is config.yml gateway firewall struct
+type GatewayFirewall struct {
+	// DNS is the kungfu dns server ip:port the dns queries of the clients redirected to,
+	// empty to disable
+	DNS string
+	// Interface is the lan interface of the clients, empty for all
+	Interface string
+}
+
+// GatewaySocket is config.yml gateway socket struct, the options of the tcp sockets of the
+// relay listeners (the clients side) and the dialers of the proxy servers and the direct
+// relays, the zero values keep the defaults of the system, only the keepalive is supported on
+// other systems than linux
+type GatewaySocket struct {
+	// FastOpen enable TCP Fast Open of the listeners and the dialers, the net.ipv4.tcp_fastopen
+	// sysctl should allow it
+	FastOpen bool
+	// KeepAlive is the idle time before the keepalive probes, default 15s, negative to disable
+	KeepAlive time.Duration
+	// KeepAliveInterval is the interval of the keepalive probes
+	KeepAliveInterval time.Duration
+	// KeepAliveCount is the count of the unanswered probes before the connection dropped
+	KeepAliveCount int
+	// UserTimeout is how long the sent data may stay unacknowledged before the connection
+	// dropped (TCP_USER_TIMEOUT)
+	UserTimeout time.Duration
+	// ReadBuffer and WriteBuffer are the bytes of SO_RCVBUF and SO_SNDBUF
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// QueryLog is config.yml query log struct
+type QueryLog struct {
+	// Sink is where the query log write to, file, syslog or redis (stream), empty to disable
+	Sink string
+	// Sample is the ratio of queries to log, 0 to log all
+	Sample float64
+	// File is the log file path of file sink
+	File string
+	// MaxSize is the max size in MB of the log file before rotate, default 100
+	MaxSize int
+	// MaxBackups is the count of rotated files to keep, default 3
+	MaxBackups int
+	// Stream is the stream key of redis sink, default kungfu:querylog
+	Stream string
+	// MaxLen is the approximate max length of the stream, default 100000
+	MaxLen int64
+}
+
+// Admin is config.yml admin api struct
+type Admin struct {
+	// Listen is the listen address of admin http api, empty to disable
+	Listen string
+	// Gateway is the listen address of the gateway admin http api, empty to disable
+	Gateway string
+	// GRPC is the listen address of the gateway control api (gRPC, api/kungfu.proto), empty to
+	// disable, the auth and the tls are the same as the admin http apis
+	GRPC string
+	// DebugToken guard the pprof, expvar and dump endpoints under /debug/ of the admin http apis,
+	// empty to disable
+	DebugToken string
+	// Token is the bearer token required by the admin and the metrics http apis, empty to skip
+	Token string
+	// Username and Password is the basic auth required by the admin and the metrics http apis,
+	// empty to skip, either the token or the basic auth is accepted if both configured
+	Username string
+	Password string
+	// Cert and Key is the certificate and the private key file path in PEM format, the admin and
+	// the metrics http apis serve https if configured
+	Cert string
+	Key  string
+}
+
+// Log is config.yml log struct
+type Log struct {
+	// Level is the log level of all the modules, debug, info(default), warning or error,
+	// the -d flag takes precedence on start
+	Level string
+	// Format is the output format, text(default) or json
+	Format string
+	// Modules is the level of the modules (dns, gateway, gfwlist, internal, metrics, netfilter), override Level
+	Modules map[string]string
+}
+
+// Network is the ipv4 fake ip network
+type Network struct {
+	// Subnet is the network in CIDR
+	Subnet *net.IPNet
+	// RelayIp is the ip of the gateway tun, excluded from the pool
+	RelayIp net.IP
+	// First is the first host of the network
+	First net.IP
+	// Size is the count of the hosts, the network and broadcast address excluded
+	Size uint64
+}
+
+// RelayCIDR is the relay ip with the prefix length, e.g. 198.18.0.1/15
+func (n *Network) RelayCIDR() string {
+	ones, _ := n.Subnet.Mask.Size()
+	return fmt.Sprintf("%s/%d", n.RelayIp, ones)
+}
+
 // Config is struct commom config.yml
 type Config struct {
-	Redis Redis
+	// Version is the version of the config schema, 0 (the config without version) or 1
+	Version int
+	// Store is the storage backend, redis(default) or memory
+	Store        string
+	Log          Log
+	Redis        Redis
+	Memory       Memory
+	Gfwlist      Gfwlist
+	GeoIP        GeoIP
+	ChnRoutes    ChnRoutes
+	Reject       []RejectList
+	Subscription RuleSubscription
+	Hosts        Hosts
+	Pool         Pool
+	Upstream     Upstream
+	Outbounds    []Outbound
+	DNS          DNS
+	Gateway      Gateway
+	Metrics      Metrics
+	QueryLog     QueryLog
+	Admin        Admin
+	Stats        Stats
+	Tracing      Tracing
+}
+
+// Tracing is config.yml tracing struct, the spans of the queries and the relayed connections are
+// exported to the OpenTelemetry collector, disabled if url is empty
+type Tracing struct {
+	// URL is the OTLP/HTTP traces endpoint, e.g. http://127.0.0.1:4318/v1/traces
+	URL string
+	// Sample is the ratio of the queries and the connections traced, all if 0 or 1
+	Sample float64
+}
+
+// NewTracer create the tracer of the service (dns or gateway), the service name is kungfu-<service>,
+// nil if the url is empty
+func (t Tracing) NewTracer(service string) *tracing.Tracer {
+	if t.URL == "" {
+		return nil
+	}
+	return &tracing.Tracer{URL: t.URL, Service: kungfu.Name + "-" + service, Sample: t.Sample}
+}
+
+// Stats is config.yml stats struct, the hourly query counts, blocked and proxied domains and the
+// relayed bytes kept in kungfu:stats:<kind>:<hour>
+type Stats struct {
+	// Retention is how long the hourly stats kept, default 168h
+	Retention time.Duration
 }
 
 func (config *Config) String() string {
 	return fmt.Sprintln(
+		"store:", config.Store,
 		"redis:", config.Redis)
 }
 
-// ParseConfig parse the config file
-func ParseConfig(file string) *Config {
+// ParseConfig parse the config file, apply the env vars and the -set flags over it, then validate
+func ParseConfig(file string) (*Config, error) {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Error("%v", err)
-		os.Exit(1)
+		return nil, err
 	}
+	return LoadConfig(data, file)
+}
 
+// LoadConfig parse the config data of the named source, empty data is the default config,
+// apply the env vars and the -set flags over it, then validate
+func LoadConfig(data []byte, file string) (*Config, error) {
 	config := new(Config)
 
-	err = yaml.Unmarshal(data, config)
+	err := yaml.Unmarshal(data, config)
+	if err == nil && config.Version >= ConfigVersion {
+		// reject the unknown fields, the typos are silently ignored otherwise
+		config = new(Config)
+		err = yaml.UnmarshalStrict(data, config)
+	}
 
 	if err != nil {
-		log.Error("get config error, %v", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("parse config %s error, %v", file, err)
+	}
+
+	if err = overrideConfig(config); err != nil {
+		return nil, err
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s, %v", file, err)
 	}
 
-	return config
+	return config, nil
 }
 
-// ParseNetwork parse the network get minip maxip
-func ParseNetwork(network string) (minIp uint32, maxIp uint32, err error) {
+// Validate check the config values
+func (config *Config) Validate() error {
+	if config.Version < 0 || config.Version > ConfigVersion {
+		return fmt.Errorf("unsupported config version %d, the latest is %d", config.Version, ConfigVersion)
+	}
+
+	switch config.Store {
+	case "", "redis", "memory":
+	default:
+		return fmt.Errorf("unsupported store %s", config.Store)
+	}
+
+	if config.Log.Level != "" {
+		if err := kungfu.ParseLogLevel(config.Log.Level); err != nil {
+			return err
+		}
+	}
+
+	for module, level := range config.Log.Modules {
+		if err := kungfu.ParseLogLevel(level); err != nil {
+			return fmt.Errorf("invalid log level of module %s, %v", module, err)
+		}
+	}
+
+	switch config.Log.Format {
+	case "", kungfu.FormatText, kungfu.FormatJSON:
+	default:
+		return fmt.Errorf("unsupported log format %s", config.Log.Format)
+	}
+
+	if config.Redis.Timeout < 0 {
+		return fmt.Errorf("invalid redis timeout %v", config.Redis.Timeout)
+	}
+
+	if config.Gfwlist.Interval < 0 {
+		return fmt.Errorf("invalid gfwlist interval %v", config.Gfwlist.Interval)
+	}
+
+	if config.GeoIP.Interval < 0 {
+		return fmt.Errorf("invalid geoip interval %v", config.GeoIP.Interval)
+	}
+
+	if config.ChnRoutes.Interval < 0 {
+		return fmt.Errorf("invalid chnroutes interval %v", config.ChnRoutes.Interval)
+	}
+
+	names := make(map[string]bool, len(config.Reject))
+	for _, list := range config.Reject {
+		if list.Name == "" || strings.ContainsAny(list.Name, ":*") || names[list.Name] || list.Name == SubscriptionReject {
+			return fmt.Errorf("invalid reject list name %q, should be unique without : and *", list.Name)
+		}
+		names[list.Name] = true
+
+		if list.Source == "" {
+			return fmt.Errorf("reject list %s source is required", list.Name)
+		}
+
+		switch list.Mode {
+		case "", RejectModeZero, RejectModeReset:
+		default:
+			return fmt.Errorf("unsupported mode %s of reject list %s", list.Mode, list.Name)
+		}
+
+		if list.Interval < 0 {
+			return fmt.Errorf("invalid interval %v of reject list %s", list.Interval, list.Name)
+		}
+
+		if _, err := ParseSchedule(list.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule of reject list %s, %v", list.Name, err)
+		}
+	}
+
+	switch config.Subscription.Mode {
+	case "", RejectModeZero, RejectModeReset:
+	default:
+		return fmt.Errorf("unsupported subscription mode %s", config.Subscription.Mode)
+	}
+
+	names = make(map[string]bool, len(config.Subscription.Sources))
+	for _, source := range config.Subscription.Sources {
+		if source.Name == "" || names[source.Name] {
+			return fmt.Errorf("invalid subscription name %q, should be unique", source.Name)
+		}
+		names[source.Name] = true
+
+		if !strings.HasPrefix(source.URL, "http://") && !strings.HasPrefix(source.URL, "https://") {
+			return fmt.Errorf("invalid url %q of subscription %s, should be http(s)", source.URL, source.Name)
+		}
+
+		switch source.Type {
+		case "", "gfwlist", "clash", "adblock":
+		default:
+			return fmt.Errorf("unsupported type %s of subscription %s", source.Type, source.Name)
+		}
+
+		switch source.Policy {
+		case "", "proxy", "direct", "reject":
+		default:
+			return fmt.Errorf("unsupported policy %s of subscription %s", source.Policy, source.Name)
+		}
+
+		if source.Interval < 0 {
+			return fmt.Errorf("invalid interval %v of subscription %s", source.Interval, source.Name)
+		}
+	}
+
+	if config.Pool.Network != "" {
+		if _, err := ParseNetwork(config.Pool.Network); err != nil {
+			return fmt.Errorf("invalid pool network %s, %v", config.Pool.Network, err)
+		}
+	}
+
+	if config.Pool.Network6 != "" {
+		if _, _, _, err := ParseNetwork6(config.Pool.Network6); err != nil {
+			return fmt.Errorf("invalid pool network6 %s, %v", config.Pool.Network6, err)
+		}
+	}
+
+	for _, nameserver := range config.Upstream.Nameservers {
+		if strings.TrimSpace(nameserver) == "" || strings.Contains(nameserver, ",") {
+			return fmt.Errorf("invalid upstream nameserver %q", nameserver)
+		}
+	}
+
+	names = make(map[string]bool, len(config.Outbounds))
+	for _, outbound := range config.Outbounds {
+		if outbound.Name == "" || strings.ContainsAny(outbound.Name, ":*") || names[outbound.Name] || outbound.Name == "direct" {
+			return fmt.Errorf("invalid outbound name %q, should be unique without : and *", outbound.Name)
+		}
+		names[outbound.Name] = true
+
+		if len(outbound.Proxies) == 0 {
+			return fmt.Errorf("outbound %s proxies is required", outbound.Name)
+		}
+
+		for _, proxy := range outbound.Proxies {
+			u, err := url.Parse(proxy)
+			if err != nil || u.Scheme == "" || u.Host == "" || strings.Contains(proxy, ",") {
+				return fmt.Errorf("invalid proxy %q of outbound %s", proxy, outbound.Name)
+			}
+
+			switch u.Scheme {
+			case "ss":
+				if _, _, err := ParseShadowsocksUser(u); err != nil {
+					return fmt.Errorf("invalid proxy %q of outbound %s, %v", proxy, outbound.Name, err)
+				}
+			case "wireguard", "wg":
+				if _, err := ParseWireguard(u); err != nil {
+					return fmt.Errorf("invalid proxy %q of outbound %s, %v", proxy, outbound.Name, err)
+				}
+				// the endpoint is udp, can't be connected through the proxy of other outbound
+				if outbound.Via != "" {
+					return fmt.Errorf("wireguard proxy of outbound %s can't be relayed via other outbound", outbound.Name)
+				}
+			}
+		}
+
+		switch outbound.Strategy {
+		case "", "failover", "round-robin", "lowest-latency":
+		default:
+			return fmt.Errorf("unsupported strategy %s of outbound %s", outbound.Strategy, outbound.Name)
+		}
+
+		if outbound.Via != "" && outbound.Name == OutboundDefault {
+			return fmt.Errorf("outbound %s can't be relayed via other outbound", OutboundDefault)
+		}
+	}
+
+	vias := make(map[string]string, len(config.Outbounds))
+	for _, outbound := range config.Outbounds {
+		if outbound.Via == "" {
+			continue
+		}
+		if !names[outbound.Via] && outbound.Via != OutboundDefault {
+			return fmt.Errorf("outbound %s via unknown outbound %s", outbound.Name, outbound.Via)
+		}
+		vias[outbound.Name] = outbound.Via
+	}
+	for name := range vias {
+		if chain, ok := ViaChain(name, vias); !ok {
+			return fmt.Errorf("outbound %s via cycle %s", name, strings.Join(chain, " -> "))
+		}
+	}
+
+	if config.GeoIP.URL != "" && config.GeoIP.Database == "" {
+		return fmt.Errorf("geoip database is required to download %s", config.GeoIP.URL)
+	}
+
+	switch config.QueryLog.Sink {
+	case "", "file", "syslog", "redis":
+	default:
+		return fmt.Errorf("unsupported query log sink %s", config.QueryLog.Sink)
+	}
+
+	if config.QueryLog.Sample < 0 || config.QueryLog.Sample > 1 {
+		return fmt.Errorf("invalid query log sample %v, should be in [0, 1]", config.QueryLog.Sample)
+	}
+
+	if config.DNS.RateLimit.QPS < 0 || config.DNS.RateLimit.Burst < 0 {
+		return fmt.Errorf("invalid dns rate limit, qps %v, burst %d",
+			config.DNS.RateLimit.QPS, config.DNS.RateLimit.Burst)
+	}
+
+	switch config.DNS.IPSet.Backend {
+	case "":
+	case "ipset", "nft":
+		if config.DNS.IPSet.Name == "" {
+			return fmt.Errorf("dns ipset name is required")
+		}
+		if config.DNS.IPSet.Interval < 0 || config.DNS.IPSet.MinTimeout < 0 {
+			return fmt.Errorf("invalid dns ipset interval %v, min timeout %v",
+				config.DNS.IPSet.Interval, config.DNS.IPSet.MinTimeout)
+		}
+	default:
+		return fmt.Errorf("unsupported dns ipset backend %s", config.DNS.IPSet.Backend)
+	}
+
+	if config.DNS.DNS64.Prefix != "" {
+		ip, prefix, err := net.ParseCIDR(config.DNS.DNS64.Prefix)
+		if err != nil || ip.To4() != nil {
+			return fmt.Errorf("invalid dns64 prefix %s, should be ipv6 cidr", config.DNS.DNS64.Prefix)
+		}
+		switch ones, _ := prefix.Mask.Size(); ones {
+		case 32, 40, 48, 56, 64, 96:
+		default:
+			return fmt.Errorf("invalid dns64 prefix length %d, should be 32, 40, 48, 56, 64 or 96", ones)
+		}
+	}
+
+	if strings.Contains(config.DNS.Local.Nameserver, ",") {
+		return fmt.Errorf("invalid dns local nameserver %q, should be one nameserver", config.DNS.Local.Nameserver)
+	}
+	for _, domain := range config.DNS.Local.Domains {
+		if strings.TrimSpace(domain) == "" {
+			return fmt.Errorf("invalid dns local domain %q", domain)
+		}
+	}
+
+	if config.Metrics.Push.URL != "" {
+		u, err := url.Parse(config.Metrics.Push.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid metrics push url %s, should be http(s) url", config.Metrics.Push.URL)
+		}
+	}
+	if config.Metrics.Push.Interval < 0 {
+		return fmt.Errorf("invalid metrics push interval %v", config.Metrics.Push.Interval)
+	}
+
+	if config.Admin.Username != "" && config.Admin.Password == "" {
+		return fmt.Errorf("admin password is required by the basic auth of %s", config.Admin.Username)
+	}
+	if (config.Admin.Cert == "") != (config.Admin.Key == "") {
+		return fmt.Errorf("admin cert and key should be configured together")
+	}
+
+	if config.Tracing.URL != "" {
+		u, err := url.Parse(config.Tracing.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid tracing url %s, should be http(s) url", config.Tracing.URL)
+		}
+	}
+	if config.Tracing.Sample < 0 || config.Tracing.Sample > 1 {
+		return fmt.Errorf("invalid tracing sample %v, should be 0 to 1", config.Tracing.Sample)
+	}
+
+	if config.Stats.Retention < 0 {
+		return fmt.Errorf("invalid stats retention %v", config.Stats.Retention)
+	}
+
+	names = make(map[string]bool, len(config.DNS.Clients))
+	for _, group := range config.DNS.Clients {
+		if group.Name == "" || names[group.Name] {
+			return fmt.Errorf("invalid dns client group name %q, should be unique", group.Name)
+		}
+		names[group.Name] = true
+
+		if len(group.Subnets) == 0 && len(group.MACs) == 0 {
+			return fmt.Errorf("dns client group %s has no subnet or mac", group.Name)
+		}
+		if len(group.MACs) > 0 && config.DNS.DHCPLeases == "" {
+			return fmt.Errorf("dns client group %s identify the devices by mac, dhcpleases is required", group.Name)
+		}
+		for _, mac := range group.MACs {
+			if _, err := net.ParseMAC(mac); err != nil {
+				return fmt.Errorf("invalid dns client group %s mac %s", group.Name, mac)
+			}
+		}
+		if _, err := ParseSubnets(group.Subnets); err != nil {
+			return fmt.Errorf("invalid dns client group %s subnets, %v", group.Name, err)
+		}
+		for _, name := range group.Rejects {
+			if name == ClientRejectNone && len(group.Rejects) > 1 {
+				return fmt.Errorf("invalid dns client group %s rejects, none should be the only list", group.Name)
+			}
+		}
+	}
+
+	if _, err := ParseSubnets(config.DNS.ACL.Allow); err != nil {
+		return fmt.Errorf("invalid dns acl allow, %v", err)
+	}
+
+	if _, err := ParseSubnets(config.DNS.ACL.Deny); err != nil {
+		return fmt.Errorf("invalid dns acl deny, %v", err)
+	}
+
+	switch config.Gateway.Mode {
+	case "", GatewayModeTun, GatewayModeTProxy:
+	default:
+		return fmt.Errorf("unsupported gateway mode %s", config.Gateway.Mode)
+	}
+
+	if config.Gateway.SystemDNS != "" && net.ParseIP(config.Gateway.SystemDNS) == nil {
+		return fmt.Errorf("invalid gateway system dns %s", config.Gateway.SystemDNS)
+	}
+
+	socket := config.Gateway.Socket
+	if socket.KeepAliveInterval < 0 || socket.KeepAliveCount < 0 || socket.UserTimeout < 0 ||
+		socket.ReadBuffer < 0 || socket.WriteBuffer < 0 {
+		return fmt.Errorf("invalid gateway socket options, %+v", socket)
+	}
+
+	if dns := config.Gateway.Firewall.DNS; dns != "" {
+		if host, _, err := net.SplitHostPort(dns); err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid gateway firewall dns %s, should be ip:port", dns)
+		}
+	}
+
+	return nil
+}
+
+// ParseSubnets parse the subnets in CIDR, the single ip is parsed as /32 or /128
+func ParseSubnets(subnets []string) ([]*net.IPNet, error) {
+	r := make([]*net.IPNet, 0, len(subnets))
+	for _, s := range subnets {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid subnet %s", s)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			r = append(r, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, subnet)
+	}
+	return r, nil
+}
+
+// ParseNetwork parse the ipv4 fake ip network in CIDR, e.g. 198.18.0.0/15 or 10.85.0.1/16,
+// the relay ip is the ip in config, or the first host if the network address is configured
+func ParseNetwork(network string) (*Network, error) {
+	ip, subnet, err := net.ParseCIDR(strings.TrimSpace(network))
+	if err != nil {
+		return nil, err
+	}
+
+	if ip = ip.To4(); ip == nil {
+		return nil, fmt.Errorf("invalid network %s, not ipv4", network)
+	}
+
+	ones, _ := subnet.Mask.Size()
+	if ones < minNetworkPrefix || ones > maxNetworkPrefix {
+		return nil, fmt.Errorf("invalid network %s, prefix length should be in [%d, %d]",
+			network, minNetworkPrefix, maxNetworkPrefix)
+	}
+
+	size := (uint64(1) << uint(32-ones)) - 2
+	first := AddToIp(subnet.IP.To4(), 1)
+	broadcast := AddToIp(first, size)
+
+	if ip.Equal(broadcast) {
+		return nil, fmt.Errorf("invalid network %s, relay ip is the broadcast address", network)
+	}
+
+	relayIp := ip
+	if ip.Equal(subnet.IP) {
+		relayIp = first
+	}
+
+	return &Network{
+		Subnet:  subnet,
+		RelayIp: relayIp,
+		First:   first,
+		Size:    size,
+	}, nil
+}
+
+// CheckNetwork check the network does not overlap the local networks, the relay ip on
+// the tun of the gateway is ignored
+func CheckNetwork(n *Network) error {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		ip, local, err := net.ParseCIDR(addr.String())
+		if err != nil || ip.To4() == nil || ip.Equal(n.RelayIp) {
+			continue
+		}
+
+		if n.Subnet.Contains(ip) || local.Contains(n.Subnet.IP) {
+			return fmt.Errorf("network %s overlaps the local network %s", n.Subnet, addr)
+		}
+	}
+
+	if !isReservedNetwork(n.Subnet) {
+		log.Warning("network %s is not a private or benchmarking range, "+
+			"the real addresses in it are unreachable, 198.18.0.0/15 is recommended", n.Subnet)
+	}
+
+	return nil
+}
+
+// isReservedNetwork return true if the network is in the private, shared or benchmarking ranges
+func isReservedNetwork(subnet *net.IPNet) bool {
+	for _, cidr := range reservedNetworks {
+		_, reserved, _ := net.ParseCIDR(cidr)
+		ones, _ := subnet.Mask.Size()
+		reservedOnes, _ := reserved.Mask.Size()
+		if ones >= reservedOnes && reserved.Contains(subnet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetwork6 parse the ipv6 network get the relay ip, first pool ip and pool size, the relay
+// ip can be in the pool and should be reserved
+func ParseNetwork6(network string) (relayIp net.IP, minIp net.IP, size uint64, err error) {
 	ip, subnet, err := net.ParseCIDR(network)
 	if err != nil {
 		return
 	}
 
-	if ip = ip.To4(); ip == nil || ip[3] == 0 {
+	if ip.To4() != nil || ip.Equal(subnet.IP) {
 		err = fmt.Errorf("invalid network %s", network)
 		return
 	}
 
-	minIp = Ipv4ToInt(subnet.IP) + 1
-	maxIp = minIp + ^Ipv4ToInt(net.IP(subnet.Mask)) - 1
+	ones, bits := subnet.Mask.Size()
+	hostBits := uint(bits - ones)
+	if hostBits < 2 {
+		err = fmt.Errorf("network too small %s", network)
+		return
+	}
+
+	// cap the pool, a /64 is far more than we will ever allocate
+	if hostBits > 32 {
+		hostBits = 32
+	}
+
+	// no broadcast address in ipv6, the pool is all the ips but the subnet
+	relayIp = ip
+	minIp = AddToIp(subnet.IP, 1)
+	size = (uint64(1) << hostBits) - 1
 
 	return
 }
@@ -81,21 +1068,116 @@ func GetRedisNetworkKey() string {
 	return GetRedisKey("network")
 }
 
+// GetRedisNetwork6Key get ipv6 network config key
+func GetRedisNetwork6Key() string {
+	return GetRedisKey("network6")
+}
+
 // GetRedisUpstreamNameserverKey get upstream nameserver config key
 func GetRedisUpstreamNameserverKey() string {
 	return GetRedisKey("upstream-nameserver")
 }
 
+// GetRedisUpstreamRaceKey get the count of upstream nameservers to query concurrently config key
+func GetRedisUpstreamRaceKey() string {
+	return GetRedisKey("upstream-race")
+}
+
+// GetRedisQueryLogKey get the default query log stream key
+func GetRedisQueryLogKey() string {
+	return GetRedisKey("querylog")
+}
+
+// GetRedisUpstreamTCPKey get the config key of query plain upstream nameservers over tcp only
+func GetRedisUpstreamTCPKey() string {
+	return GetRedisKey("upstream-tcp")
+}
+
+// GetRedisStickyIpKey get the config key of deriving fake ip from the hash of domain
+func GetRedisStickyIpKey() string {
+	return GetRedisKey("sticky-ip")
+}
+
+// GetRedisPrefetchKey get the config key of the count of the popular domains refreshed before expire
+func GetRedisPrefetchKey() string {
+	return GetRedisKey("prefetch")
+}
+
+// GetRedisUpstreamTrustedKey get the trusted upstream nameserver config key, for re-resolve poisoned answers
+func GetRedisUpstreamTrustedKey() string {
+	return GetRedisKey("upstream-trusted")
+}
+
+// GetRedisPoisonAutoProxyKey get the config key of adding poisoned domain to proxy domain set
+func GetRedisPoisonAutoProxyKey() string {
+	return GetRedisKey("poison-auto-proxy")
+}
+
+// GetRedisBogusIpSetKey get the known bogus ip set key
+func GetRedisBogusIpSetKey() string {
+	return GetRedisKey("bogus-ip")
+}
+
+// GetRedisUpstreamEcsKey get upstream edns0 client subnet config key
+func GetRedisUpstreamEcsKey() string {
+	return GetRedisKey("upstream-ecs")
+}
+
+// GetRedisNegativeCacheTTLKey get negative cache max ttl (seconds) config key
+func GetRedisNegativeCacheTTLKey() string {
+	return GetRedisKey("negative-cache-ttl")
+}
+
+// GetRedisStaleTTLKey get the period (seconds) to serve the expired answers if redis or upstreams fail config key
+func GetRedisStaleTTLKey() string {
+	return GetRedisKey("stale-ttl")
+}
+
+// GetRedisAnswerTTLKey get the max ttl (seconds) of fake ip answers returned to clients config key
+func GetRedisAnswerTTLKey() string {
+	return GetRedisKey("answer-ttl")
+}
+
+// GetRedisMappingTTLKey get the ttl (seconds) of domain <-> fake ip mapping config key
+func GetRedisMappingTTLKey() string {
+	return GetRedisKey("mapping-ttl")
+}
+
+// GetRedisMappingIdleKey get the idle period (seconds) to release the mapping without access config key
+func GetRedisMappingIdleKey() string {
+	return GetRedisKey("mapping-idle")
+}
+
+// GetRedisUpstreamMinTTLKey get the min ttl (seconds) of upstream answers config key
+func GetRedisUpstreamMinTTLKey() string {
+	return GetRedisKey("upstream-min-ttl")
+}
+
+// GetRedisUpstreamMaxTTLKey get the max ttl (seconds) of upstream answers config key
+func GetRedisUpstreamMaxTTLKey() string {
+	return GetRedisKey("upstream-max-ttl")
+}
+
 // GetRedisDomainKey get domain config key
 func GetRedisDomainKey(domain string) string {
 	return GetRedisKey(fmt.Sprintf("cache:domain-%s", domain))
 }
 
+// GetRedisDomain6Key get domain ipv6 config key
+func GetRedisDomain6Key(domain string) string {
+	return GetRedisKey(fmt.Sprintf("cache:domain6-%s", domain))
+}
+
 // GetRedisIpKey get redis ip cache config
 func GetRedisIpKey(ip string) string {
 	return GetRedisKey(fmt.Sprintf("cache:ip-%s", ip))
 }
 
+// GetRedisAccessKey get redis key of the last access time of the fake ip
+func GetRedisAccessKey(ip string) string {
+	return GetRedisKey(fmt.Sprintf("cache:access-%s", ip))
+}
+
 // GetRedisRealIpKey get redis real ip cache key
 func GetRedisRealIpKey(ip string) string {
 	return GetRedisKey(fmt.Sprintf("cache:ip-real-%s", ip))
@@ -106,6 +1188,158 @@ func GetRedisProxyKey() string {
 	return GetRedisKey("proxy")
 }
 
+// GetRedisOutboundKey get the proxy config key of the named outbound
+func GetRedisOutboundKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("outbound:%s", name))
+}
+
+// GetRedisOutboundRuleKey get redis domain set key of the named outbound
+func GetRedisOutboundRuleKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("outbound-rule:%s", name))
+}
+
+// GetRedisOutboundStrategyKey get the strategy config key of selecting the proxy of the named outbound
+func GetRedisOutboundStrategyKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("outbound-strategy:%s", name))
+}
+
+// GetRedisOutboundViaKey get the config key of the outbound the named outbound relayed through
+func GetRedisOutboundViaKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("outbound-via:%s", name))
+}
+
+// GetRedisProxyStrategyKey get the strategy config key of selecting the proxy
+func GetRedisProxyStrategyKey() string {
+	return GetRedisKey("proxy-strategy")
+}
+
+// GetRedisProxyCheckKey get the health check config key of the proxies, tcp or the url
+func GetRedisProxyCheckKey() string {
+	return GetRedisKey("proxy-check")
+}
+
+// GetRedisProxyCheckIntervalKey get the health check interval config key of the proxies
+func GetRedisProxyCheckIntervalKey() string {
+	return GetRedisKey("proxy-check-interval")
+}
+
+// GetRedisDirectRaceKey get the rules set key of the domains racing the direct route and the
+// proxy, the format is the same as gfwlist
+func GetRedisDirectRaceKey() string {
+	return GetRedisKey("direct-race")
+}
+
+// GetRedisGeoIPRuleKey get the geoip rules set key, e.g. GEOIP,CN,DIRECT and MATCH,PROXY
+func GetRedisGeoIPRuleKey() string {
+	return GetRedisKey("geoip-rule")
+}
+
+// GetRedisGeoIPRuleLoadedKey get the key of the geoip rules loaded from the clash rules source
+func GetRedisGeoIPRuleLoadedKey() string {
+	return GetRedisKey("geoip-rule-loaded")
+}
+
+// GetRedisSubscriptionLoadedKey get the key of the proxy domains loaded from the subscriptions
+func GetRedisSubscriptionLoadedKey() string {
+	return GetRedisKey("subscription-loaded")
+}
+
+// GetRedisSubscriptionRuleLoadedKey get the key of the pattern rules loaded from the subscriptions
+func GetRedisSubscriptionRuleLoadedKey() string {
+	return GetRedisKey("subscription-rule-loaded")
+}
+
+// GetRedisSubscriptionGeoIPLoadedKey get the key of the geoip rules loaded from the subscriptions
+func GetRedisSubscriptionGeoIPLoadedKey() string {
+	return GetRedisKey("subscription-geoip-loaded")
+}
+
+// GetRedisRejectKey get the rules set key of the reject list, the format is the same as gfwlist
+func GetRedisRejectKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("reject:%s", name))
+}
+
+// GetRedisRejectLoadedKey get the key of the rules loaded from the reject list source
+func GetRedisRejectLoadedKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("reject-loaded:%s", name))
+}
+
+// GetRedisRejectModeKey get the mode config key of the reject list, zero (default) or reset
+func GetRedisRejectModeKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("reject-mode:%s", name))
+}
+
+// GetRedisRejectScheduleKey get the schedule config key of the reject list, the list is active in
+// the time windows, always if not set
+func GetRedisRejectScheduleKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("reject-schedule:%s", name))
+}
+
+// GetRedisRejectedKey get the key of the fake ip answered to the domain of the reset mode reject
+// list, the value is the list name
+func GetRedisRejectedKey(ip string) string {
+	return GetRedisKey(fmt.Sprintf("rejected:%s", ip))
+}
+
+// GetRedisAdaptiveKey get the config key of learning the domains whose direct route repeatedly
+// fails while racing
+func GetRedisAdaptiveKey() string {
+	return GetRedisKey("adaptive")
+}
+
+// GetRedisAdaptiveThresholdKey get the config key of the direct route failures to learn the domain
+func GetRedisAdaptiveThresholdKey() string {
+	return GetRedisKey("adaptive-threshold")
+}
+
+// GetRedisAdaptiveTTLKey get the config key of the seconds the learned domain is proxied
+func GetRedisAdaptiveTTLKey() string {
+	return GetRedisKey("adaptive-ttl")
+}
+
+// GetRedisLearnedKey get the learned domain key, expired after the adaptive ttl
+func GetRedisLearnedKey(domain string) string {
+	return GetRedisKey(fmt.Sprintf("learned:%s", domain))
+}
+
+// GetRedisLearnLogKey get the stream key of the learned domain events
+func GetRedisLearnLogKey() string {
+	return GetRedisKey("learned-log")
+}
+
+// GetRedisBandwidthKey get the bandwidth limit hash key of the kind (client or outbound), the
+// field is the client subnet or the outbound name and the value is the bytes per second
+func GetRedisBandwidthKey(kind string) string {
+	return GetRedisKey(fmt.Sprintf("bandwidth:%s", kind))
+}
+
+// GetRedisStatsKey get the hourly stats hash key of the kind (queries, blocked, proxied or bytes)
+// and the hour (yyyymmddhh in the local time), the field is the outcome or the domain
+func GetRedisStatsKey(kind string, hour string) string {
+	return GetRedisKey(fmt.Sprintf("stats:%s:%s", kind, hour))
+}
+
+// GetRedisTrafficKey get the traffic hash key of the kind (domain or client) and the direction
+// (upload or download), the field is the domain or the client ip and the value is the bytes
+func GetRedisTrafficKey(kind string, direction string) string {
+	return GetRedisKey(fmt.Sprintf("traffic:%s:%s", kind, direction))
+}
+
+// GetRedisProxyUDPKey get the config key of relaying udp through the socks5 proxy
+func GetRedisProxyUDPKey() string {
+	return GetRedisKey("proxy-udp")
+}
+
+// GetRedisProxiedQueryKey get the policy config key of the other query types (ANY, TXT, MX ...) of proxied domains
+func GetRedisProxiedQueryKey() string {
+	return GetRedisKey("proxied-query")
+}
+
+// GetRedisProxyNameserverKey get the nameserver config key queried through the proxy
+func GetRedisProxyNameserverKey() string {
+	return GetRedisKey("proxy-nameserver")
+}
+
 // GetRedisRelayPortKey get redis relay-port config key
 func GetRedisRelayPortKey() string {
 	return GetRedisKey("relay-port")
@@ -116,11 +1350,51 @@ func GetRedisProxyDomainSetKey() string {
 	return GetRedisKey("gfwlist")
 }
 
+// GetRedisProxyDomainLoadedSetKey get redis key of the domains loaded from gfwlist source
+func GetRedisProxyDomainLoadedSetKey() string {
+	return GetRedisKey("gfwlist-loaded")
+}
+
+// GetRedisProxyRuleSetKey get redis proxy pattern rule (wildcard, keyword, regex) set key
+func GetRedisProxyRuleSetKey() string {
+	return GetRedisKey("gfwlist-rule")
+}
+
+// GetRedisProxyRuleLoadedSetKey get redis key of the pattern rules loaded from gfwlist source
+func GetRedisProxyRuleLoadedSetKey() string {
+	return GetRedisKey("gfwlist-rule-loaded")
+}
+
+// GetRedisProxyDomainUpdatedKey get redis key of the gfwlist last update timestamp
+func GetRedisProxyDomainUpdatedKey() string {
+	return GetRedisKey("gfwlist-updated")
+}
+
+// GetRedisLeaderKey get the leader lease key of the background job
+func GetRedisLeaderKey(name string) string {
+	return GetRedisKey(fmt.Sprintf("leader:%s", name))
+}
+
 // GetRedisNetworkChannelKey get redis network channel key
 func GetRedisNetworkChannelKey() string {
 	return GetRedisKey("network-channel")
 }
 
+// GetRedisNetwork6ChannelKey get redis ipv6 network channel key
+func GetRedisNetwork6ChannelKey() string {
+	return GetRedisKey("network6-channel")
+}
+
+// GetRedisProxyDomainChannelKey get redis proxy domain (gfwlist) changed channel key
+func GetRedisProxyDomainChannelKey() string {
+	return GetRedisKey("gfwlist-channel")
+}
+
+// GetRedisCacheChannelKey get redis domain mapping flushed channel key, to invalidate the local cache of other instances
+func GetRedisCacheChannelKey() string {
+	return GetRedisKey("cache-channel")
+}
+
 // GetRedisProxyChannelKey get redis proxy channel key
 func GetRedisProxyChannelKey() string {
 	return GetRedisKey("proxy-channel")
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EnvPrefix is the prefix of the env vars overriding the config values, the rest is the
+	// config key in upper case with _ instead of ., e.g. KUNGFU_REDIS_ADDR for redis.addr
+	EnvPrefix = "KUNGFU_"
+
+	// maxOverrideIndex is the max index of the list items created by the overrides
+	maxOverrideIndex = 255
+)
+
+// errUnknownKey is the key not in the config
+var errUnknownKey = errors.New("unknown config key")
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ConfigOverrides is the config values of the -set flags, applied after the env vars on
+// parsing the config file
+var ConfigOverrides Overrides
+
+// Overrides is the config values of key=value, e.g. redis.addr=127.0.0.1:6379, implement
+// flag.Value for the repeatable flag
+type Overrides []string
+
+func (o *Overrides) String() string {
+	if o == nil {
+		return ""
+	}
+	return strings.Join(*o, " ")
+}
+
+// Set add the key=value
+func (o *Overrides) Set(value string) error {
+	if i := strings.Index(value, "="); i <= 0 {
+		return fmt.Errorf("invalid override %q, should be key=value", value)
+	}
+	*o = append(*o, value)
+	return nil
+}
+
+// Override apply the env vars and then the overrides to the config, the env vars of unknown
+// keys are ignored (e.g. KUNGFU_SERVICE_HOST of kubernetes), the unknown keys of overrides are
+// invalid
+func (config *Config) Override(environ []string, overrides []string) error {
+	for _, env := range environ {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], EnvPrefix) {
+			continue
+		}
+
+		key := strings.ToLower(strings.Replace(strings.TrimPrefix(kv[0], EnvPrefix), "_", ".", -1))
+		err := config.Set(key, kv[1])
+		if err == errUnknownKey {
+			log.Debug("ignore env %s, %v", kv[0], err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid env %s, %v", kv[0], err)
+		}
+	}
+
+	for _, override := range overrides {
+		kv := strings.SplitN(override, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid override %q, should be key=value", override)
+		}
+		if err := config.Set(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("invalid override %s, %v", kv[0], err)
+		}
+	}
+	return nil
+}
+
+// Set set the config value of the key, the keys are the same as config.yml separated by .,
+// e.g. dns.ratelimit.qps, the list items by index, e.g. outbounds.0.proxies, the map values
+// by the map key, e.g. log.modules.dns, the lists of string are separated by comma
+func (config *Config) Set(key string, value string) error {
+	return setValue(reflect.ValueOf(config).Elem(), strings.Split(key, "."), value)
+}
+
+func setValue(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 {
+		return parseValue(v, value)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		name := strings.ToLower(path[0])
+		for i := 0; i < v.NumField(); i++ {
+			if strings.ToLower(v.Type().Field(i).Name) == name {
+				return setValue(v.Field(i), path[1:], value)
+			}
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			break
+		}
+
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i > maxOverrideIndex {
+			return fmt.Errorf("invalid index %s", path[0])
+		}
+		if i >= v.Len() {
+			grown := reflect.MakeSlice(v.Type(), i+1, i+1)
+			reflect.Copy(grown, v)
+			v.Set(grown)
+		}
+		return setValue(v.Index(i), path[1:], value)
+	case reflect.Map:
+		if len(path) != 1 {
+			break
+		}
+
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := parseValue(elem, value); err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		v.SetMapIndex(reflect.ValueOf(path[0]), elem)
+		return nil
+	}
+	return errUnknownKey
+}
+
+// parseValue parse the value of the type of v, string, bool, number, duration or list of string
+func parseValue(v reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		items := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = reflect.Append(items, reflect.ValueOf(item))
+			}
+		}
+		v.Set(items)
+	default:
+		return errors.New("should be set by the fields")
+	}
+	return nil
+}
+
+// overrideConfig apply the env vars of the process and the -set flags
+func overrideConfig(config *Config) error {
+	return config.Override(os.Environ(), ConfigOverrides)
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigOverride(t *testing.T) {
+	config := &Config{Redis: Redis{Addr: "127.0.0.1:6379"}, DNS: DNS{Listen: "0.0.0.0:53"}}
+
+	environ := []string{
+		"PATH=/usr/bin",
+		"KUNGFU_REDIS_ADDR=redis:6379",
+		"KUNGFU_DNS_LISTEN=0.0.0.0:5353",
+		"KUNGFU_DNS_RATELIMIT_QPS=20",
+		"KUNGFU_REDIS_TIMEOUT=3s",
+		"KUNGFU_LOG_MODULES_DNS=debug",
+		"KUNGFU_UPSTREAM_NAMESERVERS=119.29.29.29, tls://1.1.1.1",
+		// injected by kubernetes for the service named kungfu
+		"KUNGFU_SERVICE_HOST=10.0.0.1",
+	}
+	overrides := []string{
+		"dns.listen=127.0.0.1:53",
+		"outbounds.1.name=video",
+		"outbounds.1.proxies=socks5://127.0.0.1:1080",
+		"outbounds.0.name=default",
+	}
+	if err := config.Override(environ, overrides); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Redis.Addr != "redis:6379" || config.Redis.Timeout != time.Second*3 {
+		t.Fatal("unexpected redis", config.Redis)
+	}
+
+	// the flags take precedence over the env vars
+	if config.DNS.Listen != "127.0.0.1:53" || config.DNS.RateLimit.QPS != 20 {
+		t.Fatal("unexpected dns", config.DNS.Listen, config.DNS.RateLimit)
+	}
+
+	if config.Log.Modules["dns"] != "debug" {
+		t.Fatal("unexpected log modules", config.Log.Modules)
+	}
+
+	if len(config.Upstream.Nameservers) != 2 || config.Upstream.Nameservers[1] != "tls://1.1.1.1" {
+		t.Fatal("unexpected nameservers", config.Upstream.Nameservers)
+	}
+
+	if len(config.Outbounds) != 2 || config.Outbounds[0].Name != "default" || config.Outbounds[1].Proxies[0] != "socks5://127.0.0.1:1080" {
+		t.Fatal("unexpected outbounds", config.Outbounds)
+	}
+
+	if err := config.Override(nil, []string{"dns.lsiten=:53"}); err == nil {
+		t.Fatal("unknown key of override should be invalid")
+	}
+
+	if err := config.Override([]string{"KUNGFU_DNS_RATELIMIT_QPS=fast"}, nil); err == nil {
+		t.Fatal("invalid number should be invalid")
+	}
+}
@@ -2,14 +2,286 @@ package internal
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 )
 
 func TestParseConfig(t *testing.T) {
-	config, err := ParseConfig("../dns/server/config.yml")
+	config, err := ParseConfig("../config-example.yml")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	fmt.Printf("config: %v\n", config)
 }
+
+func TestValidateConfig(t *testing.T) {
+	config := &Config{Store: "etcd"}
+	if err := config.Validate(); err == nil {
+		t.Fatal("unsupported store should be invalid")
+	}
+
+	config = &Config{QueryLog: QueryLog{Sample: 2}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("query log sample greater than 1 should be invalid")
+	}
+
+	config = &Config{Gateway: Gateway{SystemDNS: "localhost"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("system dns should be ip")
+	}
+
+	config = &Config{Gateway: Gateway{Mode: "redirect"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("unsupported gateway mode should be invalid")
+	}
+
+	config = &Config{GeoIP: GeoIP{URL: "https://example.com/Country.mmdb"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("geoip url without database should be invalid")
+	}
+
+	config = &Config{Reject: []RejectList{
+		{Name: "ads", Source: "/etc/kungfu/ads.txt"},
+		{Name: "ads", Source: "/etc/kungfu/trackers.txt", Mode: RejectModeReset},
+	}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("duplicated reject list name should be invalid")
+	}
+
+	config = &Config{Subscription: RuleSubscription{Sources: []RuleSource{
+		{Name: "gfwlist", URL: "/etc/kungfu/gfwlist.txt"},
+	}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("subscription url should be http(s)")
+	}
+
+	config = &Config{DNS: DNS{IPSet: DNSIPSet{Backend: "nft"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("dns ipset without name should be invalid")
+	}
+
+	config = &Config{DNS: DNS{DNS64: DNS64{Prefix: "64:ff9b::/80"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("dns64 prefix length 80 should be invalid")
+	}
+
+	config = &Config{DNS: DNS{DNS64: DNS64{Prefix: "10.0.0.0/8"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("ipv4 dns64 prefix should be invalid")
+	}
+
+	config = &Config{DNS: DNS{Local: DNSLocal{Nameserver: "192.168.1.1,192.168.1.2"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("multiple dns local nameservers should be invalid")
+	}
+
+	config = &Config{DNS: DNS{Local: DNSLocal{Domains: []string{"lan", " "}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("empty dns local domain should be invalid")
+	}
+
+	config = &Config{DNS: DNS{Clients: []DNSClientGroup{{Name: "iot", Subnets: []string{"192.168.20.0/24"}}, {Name: "iot", Subnets: []string{"192.168.30.0/24"}}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("duplicate dns client group name should be invalid")
+	}
+
+	config = &Config{DNS: DNS{Clients: []DNSClientGroup{{Name: "iot"}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("dns client group without subnet should be invalid")
+	}
+
+	config = &Config{DNS: DNS{Clients: []DNSClientGroup{{Name: "tv", MACs: []string{"aa:bb:cc:dd:ee:ff"}}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("dns client group mac without dhcpleases should be invalid")
+	}
+
+	config = &Config{DNS: DNS{DHCPLeases: "/tmp/dhcp.leases", Clients: []DNSClientGroup{{Name: "tv", MACs: []string{"aa:bb:cc"}}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("invalid dns client group mac should be invalid")
+	}
+
+	config = &Config{DNS: DNS{Clients: []DNSClientGroup{{Name: "iot", Subnets: []string{"192.168.20.0/33"}}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("invalid dns client group subnet should be invalid")
+	}
+
+	config = &Config{DNS: DNS{Clients: []DNSClientGroup{{Name: "iot", Subnets: []string{"192.168.20.0/24"}, Rejects: []string{"none", "ads"}}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("none with other reject lists should be invalid")
+	}
+
+	config = &Config{Reject: []RejectList{{Name: "streaming", Source: "streaming.txt", Schedule: "weekday 09:00-18:00"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("invalid reject list schedule should be invalid")
+	}
+
+	config = &Config{Metrics: Metrics{Push: MetricsPush{URL: "127.0.0.1:8086/write"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("metrics push url without scheme should be invalid")
+	}
+
+	config = &Config{Admin: Admin{Username: "admin"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("admin basic auth without password should be invalid")
+	}
+
+	config = &Config{Admin: Admin{Cert: "admin.crt"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("admin cert without key should be invalid")
+	}
+
+	config = &Config{Tracing: Tracing{URL: "udp://127.0.0.1:4318"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("tracing url not http should be invalid")
+	}
+
+	config = &Config{Tracing: Tracing{URL: "http://127.0.0.1:4318/v1/traces", Sample: 1.5}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("tracing sample greater than 1 should be invalid")
+	}
+
+	config = &Config{Gateway: Gateway{Socket: GatewaySocket{KeepAlive: -1, ReadBuffer: -1}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("negative gateway socket buffer should be invalid")
+	}
+
+	config = &Config{Version: ConfigVersion + 1}
+	if err := config.Validate(); err == nil {
+		t.Fatal("unsupported config version should be invalid")
+	}
+
+	config = &Config{Pool: Pool{Network: "10.85.0.1"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("pool network without prefix should be invalid")
+	}
+
+	config = &Config{Outbounds: []Outbound{{Name: "video"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("outbound without proxies should be invalid")
+	}
+
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{"127.0.0.1:1080"}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("outbound proxy without scheme should be invalid")
+	}
+
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{"ss://rc4-md5:pass@1.2.3.4:8388"}}}}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "unsupported cipher") {
+		t.Fatal("outbound proxy of the unknown cipher should be invalid", err)
+	}
+
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{"ss://CHACHA20-IETF-POLY1305:pass@1.2.3.4:8388"}}}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := "wg://" + strings.Repeat("A", 43) + "%3D@1.2.3.4:51820?publickey=" + strings.Repeat("B", 43) + "%3D&address=10.0.0.2"
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{wg}}}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{"wireguard://key@1.2.3.4:51820"}}}}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "invalid private key") {
+		t.Fatal("wireguard proxy of the invalid key should be invalid", err)
+	}
+
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{wg}, Via: "jump"}, {Name: "jump", Proxies: []string{"socks5://10.0.0.1:1080"}}}}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "can't be relayed") {
+		t.Fatal("wireguard proxy relayed via other outbound should be invalid", err)
+	}
+
+	jump := Outbound{Name: "jump", Proxies: []string{"socks5://10.0.0.1:1080"}, Via: "video"}
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{"ss://aes-128-gcm:pass@1.2.3.4:8388"}, Via: "jump"}, jump}}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatal("outbound via cycle should be invalid", err)
+	}
+
+	jump.Via = ""
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{"ss://aes-128-gcm:pass@1.2.3.4:8388"}, Via: "jump"}, jump}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config = &Config{Outbounds: []Outbound{{Name: "video", Proxies: []string{"socks5://127.0.0.1:1080"}, Via: "unknown"}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("outbound via unknown outbound should be invalid")
+	}
+}
+
+func TestParseConfigVersion(t *testing.T) {
+	file, err := ioutil.TempFile("", "kungfu-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	// the unknown fields are ignored without version
+	if err = ioutil.WriteFile(file.Name(), []byte("stroe: memory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseConfig(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(file.Name(), []byte("version: 1\nstroe: memory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseConfig(file.Name()); err == nil {
+		t.Fatal("unknown field of version 1 should be invalid")
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	n, err := ParseNetwork("198.18.0.0/15")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.RelayIp.String() != "198.18.0.1" || n.First.String() != "198.18.0.1" || n.Size != 1<<17-2 {
+		t.Fatalf("unexpected network relay: %s, first: %s, size: %d", n.RelayIp, n.First, n.Size)
+	}
+
+	if n.RelayCIDR() != "198.18.0.1/15" {
+		t.Fatalf("unexpected relay cidr %s", n.RelayCIDR())
+	}
+
+	n, err = ParseNetwork("10.85.0.1/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.RelayIp.String() != "10.85.0.1" || n.Size != 65534 {
+		t.Fatalf("unexpected network relay: %s, size: %d", n.RelayIp, n.Size)
+	}
+
+	for _, network := range []string{"10.85.0.255/24", "10.0.0.1/31", "10.0.0.0/4", "fd00::1/64", "10.85.0.1"} {
+		if _, err := ParseNetwork(network); err == nil {
+			t.Fatalf("network %s should be invalid", network)
+		}
+	}
+}
+
+func TestParseNetwork6(t *testing.T) {
+	relayIp, minIp, size, err := ParseNetwork6("fd00::5/120")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relayIp.String() != "fd00::5" || minIp.String() != "fd00::1" || size != 255 {
+		t.Fatalf("unexpected network relay: %s, min: %s, size: %d", relayIp, minIp, size)
+	}
+
+	// the pool is capped
+	if _, _, size, err = ParseNetwork6("fd00::1/64"); err != nil || size != 1<<32-1 {
+		t.Fatal("unexpected pool size", size, err)
+	}
+
+	for _, network := range []string{"fd00::/64", "fd00::1/127", "10.85.0.1/16", "fd00::1"} {
+		if _, _, _, err := ParseNetwork6(network); err == nil {
+			t.Fatalf("network %s should be invalid", network)
+		}
+	}
+}
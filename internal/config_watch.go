@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/yinheli/kungfu"
+)
+
+// configWatchInterval is the interval to check the config file changed
+const configWatchInterval = time.Duration(time.Second * 5)
+
+// WatchConfig reload the config file on SIGHUP or the file changed, fn is called with the
+// validated config, the invalid config is ignored and the running config is kept
+func WatchConfig(file string, fn func(config *Config)) {
+	var modTime time.Time
+	if info, err := os.Stat(file); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ch:
+			log.Info("receive SIGHUP, reload config %s", file)
+		case <-ticker.C:
+			info, err := os.Stat(file)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			log.Info("config %s changed, reload", file)
+		}
+
+		config, err := ParseConfig(file)
+		if err != nil {
+			log.Error("reload config error, keep the running config, %v", err)
+			continue
+		}
+
+		fn(config)
+	}
+}
+
+// ApplyLog set the log level, the level of the modules and the output format of the config
+func ApplyLog(config *Config) {
+	if err := kungfu.SetLogFormat(config.Log.Format); err != nil {
+		log.Error("%v", err)
+	}
+
+	if config.Log.Level != "" {
+		if err := kungfu.SetLogLevel("", config.Log.Level); err != nil {
+			log.Error("%v", err)
+		}
+	}
+
+	if err := kungfu.SetModuleLogLevels(config.Log.Modules); err != nil {
+		log.Error("%v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// processStart is the start time of the process for the uptime of the dump
+var processStart = time.Now()
+
+// DebugDump is the json of the runtime dump
+type DebugDump struct {
+	Uptime     string `json:"uptime"`
+	Goroutines int    `json:"goroutines"`
+	// HeapAlloc and HeapObjects are the bytes and the count of the allocated heap objects
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapObjects uint64 `json:"heapObjects"`
+	// Sys is the bytes obtained from the system
+	Sys   uint64 `json:"sys"`
+	NumGC uint32 `json:"numGC"`
+	// Extra is the state of the service, e.g. the relayed connections of the gateway
+	Extra interface{} `json:"extra,omitempty"`
+}
+
+// DebugHandler serve the runtime debug endpoints under /debug/, guarded by the token in the
+// token query or the Authorization: Bearer <token> header, not found if the token is empty
+//
+//	GET    /debug/pprof/          the pprof profiles, e.g. /debug/pprof/heap and
+//	                              /debug/pprof/goroutine?debug=2 for the stacks
+//	GET    /debug/vars            the expvar variables, the cmdline and the memstats
+//	GET    /debug/dump            the goroutines, the heap and the state of the service
+//
+// extra return the state of the service in the dump, nil to skip
+func DebugHandler(token string, extra func() interface{}) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/dump", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		dump := DebugDump{
+			Uptime:      time.Since(processStart).Round(time.Second).String(),
+			Goroutines:  runtime.NumGoroutine(),
+			HeapAlloc:   mem.HeapAlloc,
+			HeapObjects: mem.HeapObjects,
+			Sys:         mem.Sys,
+			NumGC:       mem.NumGC,
+		}
+		if extra != nil {
+			dump.Extra = extra()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dump)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// the token query takes precedence, the header may be the token of the admin http apis
+		given := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); given == "" && strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
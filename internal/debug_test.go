@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	get := func(h http.Handler, url string, auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		if auth != "" {
+			r.Header.Set("Authorization", "Bearer "+auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get(DebugHandler("", nil), "/debug/dump", ""); w.Code != http.StatusNotFound {
+		t.Fatal("debug endpoints should be disabled without the token", w.Code)
+	}
+
+	h := DebugHandler("secret", func() interface{} { return map[string]int{"conns": 2} })
+	if w := get(h, "/debug/dump", ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("request without the token should be unauthorized", w.Code)
+	}
+	if w := get(h, "/debug/dump", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatal("request with the wrong token should be unauthorized", w.Code)
+	}
+
+	w := get(h, "/debug/dump", "secret")
+	var dump struct {
+		Goroutines int            `json:"goroutines"`
+		Extra      map[string]int `json:"extra"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&dump); err != nil {
+		t.Fatal(err)
+	}
+	if dump.Goroutines == 0 || dump.Extra["conns"] != 2 {
+		t.Fatalf("unexpected dump %+v", dump)
+	}
+
+	if w := get(h, "/debug/pprof/goroutine?debug=1&token=secret", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Fatal("goroutine profile should be served by the token query", w.Code)
+	}
+	if w := get(h, "/debug/vars", "secret"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "memstats") {
+		t.Fatal("expvar should be served", w.Code)
+	}
+}
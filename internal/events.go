@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// eventBufferSize is the pending events of a subscriber, the events are dropped if full
+const eventBufferSize = 256
+
+// Event is the event streamed to the websocket clients
+type Event struct {
+	// Type is the type of the event, e.g. query, rule, conn.open and conn.close
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// eventSub is a subscriber of the events of the types, all types if empty
+type eventSub struct {
+	types  map[string]bool
+	events chan *Event
+}
+
+func (s *eventSub) accept(typ string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	// conn matches conn.open and conn.close
+	if i := strings.Index(typ, "."); i > 0 && s.types[typ[:i]] {
+		return true
+	}
+	return s.types[typ]
+}
+
+// EventHub broadcast the events to the subscribers, the slow subscribers miss the events, nil
+// hub publishes nothing
+type EventHub struct {
+	lock sync.RWMutex
+	subs map[*eventSub]bool
+}
+
+// NewEventHub create the event hub
+func NewEventHub() *EventHub {
+	return &EventHub{subs: make(map[*eventSub]bool)}
+}
+
+// Active report whether any subscriber accept the type, for skipping the costly events
+func (h *EventHub) Active(typ string) bool {
+	if h == nil {
+		return false
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for sub := range h.subs {
+		if sub.accept(typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish send the event to the subscribers accept the type
+func (h *EventHub) Publish(typ string, data interface{}) {
+	if h == nil {
+		return
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if len(h.subs) == 0 {
+		return
+	}
+
+	event := &Event{Type: typ, Time: time.Now(), Data: data}
+	for sub := range h.subs {
+		if !sub.accept(typ) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+// subscribe the events of the types, all types if empty, the cancel func must be called
+func (h *EventHub) subscribe(types []string) (*eventSub, func()) {
+	sub := &eventSub{types: make(map[string]bool), events: make(chan *Event, eventBufferSize)}
+	for _, typ := range types {
+		if typ != "" {
+			sub.types[typ] = true
+		}
+	}
+
+	h.lock.Lock()
+	h.subs[sub] = true
+	h.lock.Unlock()
+
+	return sub, func() {
+		h.lock.Lock()
+		delete(h.subs, sub)
+		h.lock.Unlock()
+	}
+}
+
+// Subscribe the events of the types for the streaming apis not websocket, all types if empty,
+// the cancel func must be called
+func (h *EventHub) Subscribe(types ...string) (<-chan *Event, func()) {
+	sub, cancel := h.subscribe(types)
+	return sub.events, cancel
+}
+
+// Handler is the websocket handler streaming the events as json messages, the types are
+// filtered by ?types=query,rule, the browsers of other origins are refused
+func (h *EventHub) Handler() http.Handler {
+	return websocket.Server{
+		Handshake: checkEventOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			sub, cancel := h.subscribe(strings.Split(ws.Request().URL.Query().Get("types"), ","))
+			defer cancel()
+
+			// the client closed
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case event := <-sub.events:
+					if err := websocket.JSON.Send(ws, event); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// checkEventOrigin allow the clients without origin (not browser) and the same origin only,
+// the admin api has no authentication
+func checkEventOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != r.Host {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	config.Origin = u
+	return nil
+}
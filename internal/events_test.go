@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/events?types=conn,rule"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// wait for the subscription
+	for i := 0; i < 100 && !hub.Active("rule"); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if hub.Active("query") || !hub.Active("conn.open") {
+		t.Fatal("unexpected active types")
+	}
+
+	hub.Publish("query", "skipped")
+	hub.Publish("conn.open", map[string]int{"id": 1})
+
+	var event struct {
+		Type string
+		Data map[string]int
+	}
+	if err := websocket.JSON.Receive(ws, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "conn.open" || event.Data["id"] != 1 {
+		t.Fatal("unexpected event", event)
+	}
+
+	if _, err := websocket.Dial(url, "", "http://example.com"); err == nil {
+		t.Fatal("other origin should be refused")
+	}
+
+	// the subscriber not websocket
+	events, cancel := hub.Subscribe("query")
+	hub.Publish("rule", "skipped")
+	hub.Publish("query", "a.com")
+	if event := <-events; event.Type != "query" || event.Data != "a.com" {
+		t.Fatal("unexpected event", event)
+	}
+	cancel()
+	if hub.Active("query") {
+		t.Fatal("the canceled subscriber should be removed")
+	}
+
+	var nilHub *EventHub
+	nilHub.Publish("query", nil)
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// leaderLeaseTTL is the lease of the leader, other instance take over after it expired
+const leaderLeaseTTL = time.Duration(time.Second * 30)
+
+// Elector elect one leader among the instances sharing the store via a lease key,
+// for the background jobs should run only once, e.g. refreshing gfwlist
+type Elector struct {
+	store Store
+	key   string
+	id    string
+	ttl   time.Duration
+
+	lock   sync.Mutex
+	leader bool
+	stop   chan struct{}
+}
+
+// NewElector create the elector of the job name, the instance is identified by hostname and pid
+func NewElector(store Store, name string) *Elector {
+	hostname, _ := os.Hostname()
+	return &Elector{
+		store: store,
+		key:   GetRedisLeaderKey(name),
+		id:    fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		ttl:   leaderLeaseTTL,
+		stop:  make(chan struct{}),
+	}
+}
+
+// Start campaign for the leader and keep renewing the lease
+func (e *Elector) Start() {
+	e.Campaign()
+
+	go func() {
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ticker.C:
+				e.Campaign()
+			}
+		}
+	}()
+}
+
+// Stop renewing and resign the leader, other instance take over at the next campaign
+func (e *Elector) Stop() {
+	close(e.stop)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.leader {
+		if id, err := e.store.Get(e.key); err == nil && id == e.id {
+			e.store.Del(e.key)
+		}
+		e.leader = false
+	}
+}
+
+// IsLeader report whether this instance holds the lease
+func (e *Elector) IsLeader() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.leader
+}
+
+// Campaign acquire the lease if it's free, or renew it if held by this instance
+func (e *Elector) Campaign() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	leader, err := e.campaign()
+	if err != nil {
+		log.Error("campaign leader %s error, %v", e.key, err)
+	}
+
+	if leader != e.leader {
+		if leader {
+			log.Info("become leader of %s, id: %s", e.key, e.id)
+		} else {
+			log.Info("lose leader of %s, id: %s", e.key, e.id)
+		}
+	}
+
+	e.leader = leader
+	return leader
+}
+
+func (e *Elector) campaign() (bool, error) {
+	ok, err := e.store.SetNX(e.key, e.id, e.ttl)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	id, err := e.store.Get(e.key)
+	if err == ErrNil {
+		return false, nil
+	}
+
+	if err != nil || id != e.id {
+		return false, err
+	}
+
+	_, err = e.store.Expire(e.key, e.ttl)
+	return err == nil, err
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestElector(t *testing.T) {
+	store := NewMemoryStore(&Memory{})
+
+	a := NewElector(store, "test")
+	b := NewElector(store, "test")
+	b.id = a.id + "-b"
+
+	if !a.Campaign() {
+		t.Fatal("a should be the leader")
+	}
+
+	if b.Campaign() {
+		t.Fatal("b should not be the leader while a holds the lease")
+	}
+
+	// renew
+	if !a.Campaign() {
+		t.Fatal("a should keep the leader")
+	}
+
+	// the lease expired, b take over
+	store.Expire(GetRedisLeaderKey("test"), time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+	if !b.Campaign() {
+		t.Fatal("b should take over the expired lease")
+	}
+
+	if a.Campaign() {
+		t.Fatal("a should lose the leader")
+	}
+
+	b.Stop()
+	if b.IsLeader() {
+		t.Fatal("b should resign")
+	}
+
+	if !a.Campaign() {
+		t.Fatal("a should take over the resigned lease")
+	}
+}
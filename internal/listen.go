@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"context"
+	"net"
+)
+
+func listenConfig(reuse bool) *net.ListenConfig {
+	if !reuse {
+		return &net.ListenConfig{}
+	}
+	return &net.ListenConfig{Control: reusePort}
+}
+
+// Listen announce on the stream network address, with SO_REUSEPORT if reuse
+func Listen(network, addr string, reuse bool) (net.Listener, error) {
+	return listenConfig(reuse).Listen(context.Background(), network, addr)
+}
+
+// ListenPacket announce on the packet network address, with SO_REUSEPORT if reuse
+func ListenPacket(network, addr string, reuse bool) (net.PacketConn, error) {
+	return listenConfig(reuse).ListenPacket(context.Background(), network, addr)
+}
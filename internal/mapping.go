@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMappingTTL is the default ttl of the domain <-> fake ip mapping
+	DefaultMappingTTL = time.Duration(time.Hour * 3)
+	// TouchInterval is the min interval to refresh the same mapping
+	TouchInterval = time.Duration(time.Minute)
+	// touchTrackLimit prune the refresh time tracking if it grows beyond
+	touchTrackLimit = 4096
+)
+
+// Toucher refresh the ttl of the fake ip mapping on access, so the mapping in use never
+// expire, and record the access time for releasing the idle mapping
+type Toucher struct {
+	store Store
+
+	lock sync.Mutex
+	ttl  time.Duration
+	idle time.Duration
+	last map[string]time.Time
+}
+
+// NewToucher create the toucher, Load should be called to apply the config in store
+func NewToucher(store Store) *Toucher {
+	return &Toucher{
+		store: store,
+		ttl:   DefaultMappingTTL,
+		last:  make(map[string]time.Time),
+	}
+}
+
+// Load the mapping ttl and the idle period from store
+func (t *Toucher) Load() {
+	if t == nil {
+		return
+	}
+
+	ttl := loadSeconds(t.store, GetRedisMappingTTLKey(), DefaultMappingTTL)
+	if ttl <= 0 {
+		ttl = DefaultMappingTTL
+	}
+	idle := loadSeconds(t.store, GetRedisMappingIdleKey(), 0)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.ttl = ttl
+	t.idle = idle
+}
+
+// TTL return the ttl of the mapping
+func (t *Toucher) TTL() time.Duration {
+	if t == nil {
+		return DefaultMappingTTL
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.ttl
+}
+
+// Idle return the idle period to release the mapping, 0 if disabled
+func (t *Toucher) Idle() time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.idle
+}
+
+// Touch extend the ttl of the mapping of the fake ip, the same ip is refreshed at most
+// once in TouchInterval
+func (t *Toucher) Touch(ip net.IP) {
+	if t == nil {
+		return
+	}
+
+	key := ip.String()
+	now := time.Now()
+
+	t.lock.Lock()
+	if now.Sub(t.last[key]) < TouchInterval {
+		t.lock.Unlock()
+		return
+	}
+
+	if len(t.last) >= touchTrackLimit {
+		for k, last := range t.last {
+			if now.Sub(last) >= TouchInterval {
+				delete(t.last, k)
+			}
+		}
+	}
+
+	t.last[key] = now
+	ttl, idle := t.ttl, t.idle
+	t.lock.Unlock()
+
+	err := TouchMapping(t.store, ip, ttl, idle > 0)
+	if err != nil && err != ErrNil && err != ErrStoreUnavailable {
+		log.Warning("touch mapping of %s error, %v", ip, err)
+	}
+}
+
+// TouchMapping extend the ttl of the domain key and the reverse ip key of the fake ip, and
+// record the access time if access is true
+func TouchMapping(store Store, ip net.IP, ttl time.Duration, access bool) error {
+	ipKey := GetRedisIpKey(ip.String())
+	domain, err := store.Get(ipKey)
+	if err != nil {
+		return err
+	}
+
+	domainKey := GetRedisDomainKey(domain + ".")
+	if ip.To4() == nil {
+		domainKey = GetRedisDomain6Key(domain + ".")
+	}
+
+	// the domain may be mapped to other ip after the ip is released
+	mapped, err := store.Get(domainKey)
+	if err != nil {
+		return err
+	}
+
+	if mapped != ip.String() {
+		return fmt.Errorf("domain %s is mapped to %s", domain, mapped)
+	}
+
+	if _, err = store.Expire(domainKey, ttl); err != nil {
+		return err
+	}
+
+	if _, err = store.Expire(ipKey, ttl); err != nil {
+		return err
+	}
+
+	if access {
+		return store.Set(GetRedisAccessKey(ip.String()), strconv.FormatInt(time.Now().Unix(), 10), ttl)
+	}
+	return nil
+}
+
+// loadSeconds get the duration config in seconds from store
+func loadSeconds(store Store, key string, def time.Duration) time.Duration {
+	value, err := store.Get(key)
+	if err != nil {
+		if err != ErrNil {
+			log.Error("get config %s error, %v", key, err)
+		}
+		return def
+	}
+
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || seconds < 0 {
+		log.Error("invalid config %s: %s, use default %v", key, value, def)
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestToucher(t *testing.T) {
+	store := NewMemoryStore(&Memory{})
+	store.Set(GetRedisMappingIdleKey(), "600", 0)
+	store.Set(GetRedisDomainKey("google.com."), "10.85.0.2", time.Second*10)
+	store.Set(GetRedisIpKey("10.85.0.2"), "google.com", time.Second*10)
+
+	toucher := NewToucher(store)
+	toucher.Load()
+	if toucher.Idle() != time.Minute*10 {
+		t.Fatalf("idle should be 10m, got %v", toucher.Idle())
+	}
+
+	toucher.Touch(net.ParseIP("10.85.0.2"))
+
+	for _, key := range []string{GetRedisDomainKey("google.com."), GetRedisIpKey("10.85.0.2")} {
+		if ttl, _ := store.TTL(key); ttl <= time.Second*10 {
+			t.Fatalf("ttl of %s should be extended, got %v", key, ttl)
+		}
+	}
+
+	if _, err := store.Get(GetRedisAccessKey("10.85.0.2")); err != nil {
+		t.Fatalf("access time should be recorded, %v", err)
+	}
+
+	// throttled
+	store.Expire(GetRedisIpKey("10.85.0.2"), time.Second*10)
+	toucher.Touch(net.ParseIP("10.85.0.2"))
+	if ttl, _ := store.TTL(GetRedisIpKey("10.85.0.2")); ttl > time.Second*10 {
+		t.Fatal("touch should be throttled")
+	}
+
+	// the domain is mapped to other ip
+	store.Set(GetRedisIpKey("10.85.0.3"), "google.com", time.Second*10)
+	if err := TouchMapping(store, net.ParseIP("10.85.0.3"), time.Hour, false); err == nil {
+		t.Fatal("touch the released ip should fail")
+	}
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"sync"
+
+	"github.com/yinheli/kungfu/metrics"
+)
+
+var (
+	bufferPoolGetsTotal = metrics.NewCounter("kungfu_buffer_pool_gets_total",
+		"buffers got from the pool by pool", "pool")
+	bufferPoolAllocsTotal = metrics.NewCounter("kungfu_buffer_pool_allocs_total",
+		"buffers allocated by the pool empty by pool, the hit ratio is 1 - allocs / gets", "pool")
+)
+
+// BufferPool is the sync.Pool of the byte buffers of the size, the buffers of the dns messages
+// and the relays are reused instead of allocated for each, less garbage for the gc of the
+// routers with the little memory
+type BufferPool struct {
+	name string
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool create the pool of the buffers of the size, the name is the label of the metrics
+func NewBufferPool(name string, size int) *BufferPool {
+	p := &BufferPool{name: name, size: size}
+	p.pool.New = func() interface{} {
+		bufferPoolAllocsTotal.Inc(name)
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+// Get return the buffer of the size, put back after used
+func (p *BufferPool) Get() *[]byte {
+	bufferPoolGetsTotal.Inc(p.name)
+	return p.pool.Get().(*[]byte)
+}
+
+// Put the buffer back, the buffer resliced is restored to the size, the buffer of the other
+// capacity is dropped
+func (p *BufferPool) Put(b *[]byte) {
+	if b == nil || cap(*b) != p.size {
+		return
+	}
+	*b = (*b)[:p.size]
+	p.pool.Put(b)
+}
@@ -0,0 +1,32 @@
+package internal
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	p := NewBufferPool("test", 1024)
+
+	b := p.Get()
+	if len(*b) != 1024 {
+		t.Fatal("unexpected buffer size", len(*b))
+	}
+
+	// the resliced buffer is restored
+	*b = (*b)[:10]
+	p.Put(b)
+	b = p.Get()
+	if len(*b) != 1024 {
+		t.Fatal("the buffer should be restored to the size", len(*b))
+	}
+
+	// the buffer of the other size is dropped
+	other := make([]byte, 10, 2048)
+	p.Put(&other)
+	p.Put(nil)
+
+	if gets := bufferPoolGetsTotal.Value("test"); gets != 2 {
+		t.Fatal("unexpected gets", gets)
+	}
+	if allocs := bufferPoolAllocsTotal.Value("test"); allocs < 1 || allocs > 2 {
+		t.Fatal("unexpected allocs", allocs)
+	}
+}
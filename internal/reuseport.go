@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePort set SO_REUSEPORT on the socket, multiple process can listen on the same port,
+// the new process take over the port before the old one shutdown
+func reusePort(network, address string, conn syscall.RawConn) error {
+	var err error
+	e := conn.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if e != nil {
+		return e
+	}
+	return err
+}
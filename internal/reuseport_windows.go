@@ -0,0 +1,10 @@
+package internal
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on windows")
+}
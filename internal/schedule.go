@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is the time windows in the local time the rules are active, in the format of the
+// days and the time range, multiple windows separated by ;
+//
+//	mon-fri 09:00-18:00
+//	sat,sun 20:00-23:00; 22:00-06:00
+//
+// the days are mon, tue, wed, thu, fri, sat and sun, ranges and lists allowed, every day if
+// omitted, the time range cross midnight is the night of the days
+type Schedule struct {
+	windows []scheduleWindow
+}
+
+type scheduleWindow struct {
+	// days is the bits of the weekdays, bit 0 is sunday
+	days uint8
+	// start and end are the minutes of the day
+	start int
+	end   int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parse the schedule expression, nil if empty
+func ParseSchedule(expr string) (*Schedule, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	s := &Schedule{}
+	for _, part := range strings.Split(expr, ";") {
+		fields := strings.Fields(part)
+		var w scheduleWindow
+		switch len(fields) {
+		case 1:
+			w.days = 0x7f
+		case 2:
+			days, err := parseWeekdays(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			w.days = days
+		default:
+			return nil, fmt.Errorf("invalid schedule %q, should be [days] hh:mm-hh:mm", strings.TrimSpace(part))
+		}
+
+		var err error
+		if w.start, w.end, err = parseTimeRange(fields[len(fields)-1]); err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+func parseWeekdays(s string) (uint8, error) {
+	var days uint8
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		from, to := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			from, to = item[:i], item[i+1:]
+		}
+
+		start, ok := weekdays[from]
+		if !ok {
+			return 0, fmt.Errorf("invalid schedule day %q", from)
+		}
+		end, ok := weekdays[to]
+		if !ok {
+			return 0, fmt.Errorf("invalid schedule day %q", to)
+		}
+
+		for d := start; ; d = (d + 1) % 7 {
+			days |= 1 << uint(d)
+			if d == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeRange(s string) (int, int, error) {
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid schedule time %q, should be hh:mm-hh:mm", s)
+	}
+
+	start, err := time.Parse("15:04", s[:i])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid schedule time %q, should be hh:mm-hh:mm", s)
+	}
+	end, err := time.Parse("15:04", s[i+1:])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid schedule time %q, should be hh:mm-hh:mm", s)
+	}
+
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from == to {
+		return 0, 0, fmt.Errorf("invalid schedule time %q, empty range", s)
+	}
+	return from, to, nil
+}
+
+// Active return true if the time is in any window of the schedule, nil schedule is always active
+func (s *Schedule) Active(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	today := uint8(1) << uint(t.Weekday())
+	yesterday := uint8(1) << uint((t.Weekday()+6)%7)
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days&today != 0 && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+
+		// cross midnight, the early hours belong to the night of yesterday
+		if (w.days&today != 0 && minute >= w.start) || (w.days&yesterday != 0 && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
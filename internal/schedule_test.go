@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	s, err := ParseSchedule("mon-fri 09:00-18:00; sat,sun 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2026-10-16 is friday
+	cases := map[string]bool{
+		"2026-10-16 09:00": true,
+		"2026-10-16 17:59": true,
+		"2026-10-16 18:00": false,
+		"2026-10-16 08:59": false,
+		"2026-10-17 12:00": false,
+		"2026-10-17 23:00": true,
+		"2026-10-18 03:00": true,
+		"2026-10-19 03:00": true,
+		"2026-10-17 03:00": false,
+		"2026-10-19 10:00": true,
+	}
+	for value, expect := range cases {
+		ts, _ := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+		if s.Active(ts) != expect {
+			t.Fatalf("schedule active at %s should be %v", value, expect)
+		}
+	}
+
+	if s, err = ParseSchedule("fri-mon 20:00-21:00"); err != nil {
+		t.Fatal(err)
+	}
+	ts, _ := time.ParseInLocation("2006-01-02 15:04", "2026-10-18 20:30", time.Local)
+	if !s.Active(ts) {
+		t.Fatal("day range should wrap the week")
+	}
+
+	var always *Schedule
+	if !always.Active(time.Now()) {
+		t.Fatal("nil schedule should be always active")
+	}
+	if s, _ = ParseSchedule(" "); s != nil {
+		t.Fatal("empty schedule should be nil")
+	}
+
+	for _, expr := range []string{"mon-fri", "09:00-09:00", "xyz 09:00-18:00", "mon 9-18", "mon 09:00-25:00", "mon tue 09:00-18:00"} {
+		if _, err = ParseSchedule(expr); err == nil {
+			t.Fatalf("schedule %q should be invalid", expr)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Normalize fill the default values of the config, the same as the services applied, for
+// printing the effective config
+func (config *Config) Normalize() {
+	if config.Version == 0 {
+		config.Version = ConfigVersion
+	}
+	if config.Store == "" {
+		config.Store = "redis"
+	}
+	if config.Log.Level == "" {
+		config.Log.Level = "info"
+	}
+	if config.Log.Format == "" {
+		config.Log.Format = "text"
+	}
+	if config.Redis.Timeout == 0 {
+		config.Redis.Timeout = defaultRedisTimeout
+	}
+
+	for i := range config.Reject {
+		if config.Reject[i].Mode == "" {
+			config.Reject[i].Mode = RejectModeZero
+		}
+	}
+
+	if config.Subscription.Mode == "" {
+		config.Subscription.Mode = RejectModeZero
+	}
+	for i := range config.Subscription.Sources {
+		source := &config.Subscription.Sources[i]
+		if source.Type == "" {
+			source.Type = "gfwlist"
+		}
+		if source.Policy == "" {
+			source.Policy = "proxy"
+			if source.Type == "adblock" {
+				source.Policy = "reject"
+			}
+		}
+	}
+
+	for i := range config.Outbounds {
+		if config.Outbounds[i].Strategy == "" {
+			config.Outbounds[i].Strategy = "failover"
+		}
+	}
+
+	if config.DNS.Listen == "" {
+		config.DNS.Listen = "0.0.0.0:53"
+	}
+	if config.DNS.TLS.Listen == "" {
+		config.DNS.TLS.Listen = "0.0.0.0:853"
+	}
+	if config.DNS.RateLimit.QPS > 0 && config.DNS.RateLimit.Burst == 0 {
+		config.DNS.RateLimit.Burst = int(config.DNS.RateLimit.QPS)
+		if config.DNS.RateLimit.Burst < 1 {
+			config.DNS.RateLimit.Burst = 1
+		}
+	}
+	if config.Stats.Retention == 0 {
+		config.Stats.Retention = DefaultStatsRetention
+	}
+	if len(config.DNS.Local.Domains) == 0 {
+		config.DNS.Local.Domains = DefaultLocalDomains
+	}
+	if config.DNS.IPSet.Backend != "" {
+		if config.DNS.IPSet.Table == "" {
+			config.DNS.IPSet.Table = "inet kungfu"
+		}
+		if config.DNS.IPSet.Interval == 0 {
+			config.DNS.IPSet.Interval = time.Duration(time.Second)
+		}
+		if config.DNS.IPSet.MinTimeout == 0 {
+			config.DNS.IPSet.MinTimeout = time.Duration(time.Minute * 5)
+		}
+	}
+
+	if config.Gateway.Mode == "" {
+		config.Gateway.Mode = GatewayModeTun
+	}
+
+	if config.QueryLog.Sink != "" {
+		if config.QueryLog.MaxSize == 0 {
+			config.QueryLog.MaxSize = 100
+		}
+		if config.QueryLog.MaxBackups == 0 {
+			config.QueryLog.MaxBackups = 3
+		}
+		if config.QueryLog.Stream == "" {
+			config.QueryLog.Stream = GetRedisQueryLogKey()
+		}
+		if config.QueryLog.MaxLen == 0 {
+			config.QueryLog.MaxLen = 100000
+		}
+	}
+}
+
+// Redacted return the copy of the config with the passwords masked, for printing
+func (config *Config) Redacted() *Config {
+	c := *config
+	if c.Redis.Password != "" {
+		c.Redis.Password = "xxxxxx"
+	}
+	if c.Metrics.Push.Token != "" {
+		c.Metrics.Push.Token = "xxxxxx"
+	}
+	if c.Admin.DebugToken != "" {
+		c.Admin.DebugToken = "xxxxxx"
+	}
+	if c.Admin.Token != "" {
+		c.Admin.Token = "xxxxxx"
+	}
+	if c.Admin.Password != "" {
+		c.Admin.Password = "xxxxxx"
+	}
+
+	c.Outbounds = make([]Outbound, len(config.Outbounds))
+	for i, outbound := range config.Outbounds {
+		outbound.Proxies = make([]string, len(config.Outbounds[i].Proxies))
+		for j, proxy := range config.Outbounds[i].Proxies {
+			if u, err := url.Parse(proxy); err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					u.User = url.UserPassword(u.User.Username(), "xxxxxx")
+				}
+				proxy = u.String()
+			}
+			outbound.Proxies[j] = proxy
+		}
+		c.Outbounds[i] = outbound
+	}
+	return &c
+}
+
+// Seed write the pool, the upstream nameservers and the outbounds of the config to the store,
+// the keys not configured are kept, the services are notified of the changed network and
+// outbounds by the channels
+func (config *Config) Seed(store Store) error {
+	if config.Pool.Network != "" {
+		changed, err := seedKey(store, GetRedisNetworkKey(), config.Pool.Network)
+		if err != nil {
+			return err
+		}
+		if changed {
+			store.Publish(GetRedisNetworkChannelKey(), config.Pool.Network)
+		}
+	}
+
+	if config.Pool.Network6 != "" {
+		changed, err := seedKey(store, GetRedisNetwork6Key(), config.Pool.Network6)
+		if err != nil {
+			return err
+		}
+		if changed {
+			store.Publish(GetRedisNetwork6ChannelKey(), config.Pool.Network6)
+		}
+	}
+
+	if len(config.Upstream.Nameservers) > 0 {
+		// the dns server read the nameservers on start and reload
+		if _, err := seedKey(store, GetRedisUpstreamNameserverKey(), strings.Join(config.Upstream.Nameservers, ",")); err != nil {
+			return err
+		}
+	}
+
+	outboundsChanged := false
+	for _, outbound := range config.Outbounds {
+		proxyKey, strategyKey := GetRedisOutboundKey(outbound.Name), GetRedisOutboundStrategyKey(outbound.Name)
+		if outbound.Name == OutboundDefault {
+			proxyKey, strategyKey = GetRedisProxyKey(), GetRedisProxyStrategyKey()
+		}
+
+		changed, err := seedKey(store, proxyKey, strings.Join(outbound.Proxies, ","))
+		if err != nil {
+			return err
+		}
+		outboundsChanged = outboundsChanged || changed
+
+		changed, err = seedKey(store, strategyKey, outbound.Strategy)
+		if err != nil {
+			return err
+		}
+		outboundsChanged = outboundsChanged || changed
+
+		if outbound.Name == OutboundDefault {
+			continue
+		}
+
+		changed, err = seedSet(store, GetRedisOutboundRuleKey(outbound.Name), outbound.Rules)
+		if err != nil {
+			return err
+		}
+		outboundsChanged = outboundsChanged || changed
+
+		changed, err = seedKey(store, GetRedisOutboundViaKey(outbound.Name), outbound.Via)
+		if err != nil {
+			return err
+		}
+		outboundsChanged = outboundsChanged || changed
+	}
+
+	if outboundsChanged {
+		store.Publish(GetRedisProxyChannelKey(), "config")
+	}
+	return nil
+}
+
+// seedKey set the key if the value changed, the empty value delete the key
+func seedKey(store Store, key string, value string) (bool, error) {
+	current, err := store.Get(key)
+	if err != nil && err != ErrNil {
+		return false, err
+	}
+	if current == value {
+		return false, nil
+	}
+
+	if value == "" {
+		return true, store.Del(key)
+	}
+	return true, store.Set(key, value, 0)
+}
+
+// seedSet replace the members of the set if changed
+func seedSet(store Store, key string, members []string) (bool, error) {
+	current, err := store.SMembers(key)
+	if err != nil {
+		return false, err
+	}
+
+	want := make(map[string]bool, len(members))
+	for _, m := range members {
+		want[m] = true
+	}
+	have := make(map[string]bool, len(current))
+	for _, m := range current {
+		have[m] = true
+	}
+	if reflect.DeepEqual(want, have) {
+		return false, nil
+	}
+
+	if err = store.Del(key); err != nil {
+		return true, err
+	}
+	if len(members) == 0 {
+		return true, nil
+	}
+	return true, store.SAdd(key, members...)
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ShadowsocksCiphers is the supported aead ciphers of the shadowsocks proxy and the key size
+var ShadowsocksCiphers = map[string]int{
+	"aes-128-gcm":            16,
+	"aes-192-gcm":            24,
+	"aes-256-gcm":            32,
+	"chacha20-ietf-poly1305": 32,
+}
+
+// ParseShadowsocksUser return the lowercase cipher and the password of the shadowsocks proxy
+// url, ss://method:password@host:port or the SIP002 format with the base64 encoded userinfo,
+// the cipher not supported is reported
+func ParseShadowsocksUser(u *url.URL) (string, string, error) {
+	if u.User == nil {
+		return "", "", errors.New("shadowsocks: method and password required")
+	}
+
+	method := u.User.Username()
+	password, ok := u.User.Password()
+	if !ok {
+		userinfo, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(method, "="))
+		if err != nil {
+			return "", "", fmt.Errorf("shadowsocks: invalid userinfo, %v", err)
+		}
+		parts := strings.SplitN(string(userinfo), ":", 2)
+		if len(parts) != 2 {
+			return "", "", errors.New("shadowsocks: method and password required")
+		}
+		method, password = parts[0], parts[1]
+	}
+
+	method = strings.ToLower(method)
+	if _, ok := ShadowsocksCiphers[method]; !ok {
+		return "", "", fmt.Errorf("shadowsocks: unsupported cipher %s", method)
+	}
+	return method, password, nil
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the kinds of the hourly stats, the fields of the hash
+const (
+	// StatsQueries is the queries by outcome
+	StatsQueries = "queries"
+	// StatsBlocked is the queries of the domains blocked by the reject lists
+	StatsBlocked = "blocked"
+	// StatsProxied is the queries of the domains answered the fake ip
+	StatsProxied = "proxied"
+	// StatsBytes is the bytes relayed by the gateway per domain
+	StatsBytes = "bytes"
+)
+
+// DefaultStatsRetention is the retention of the hourly stats if not configured
+const DefaultStatsRetention = time.Duration(7 * 24 * time.Hour)
+
+// statsHourFormat is the hour of the stats key in the local time
+const statsHourFormat = "2006010215"
+
+// StatsHour return the hour of the stats key of the time
+func StatsHour(t time.Time) string {
+	return t.Format(statsHourFormat)
+}
+
+// StatsHours return the hours of the stats keys from the hour of from to the hour of to
+func StatsHours(from time.Time, to time.Time) []string {
+	var hours []string
+	for t := startOfHour(from); !t.After(to); t = t.Add(time.Hour) {
+		hours = append(hours, StatsHour(t))
+	}
+	return hours
+}
+
+// startOfHour return the start of the hour of the time in its location
+func startOfHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// HourlyStats accumulate the counts of the current hour, the accumulated counts are added to
+// the hashes of the hours on flush, expire after the retention
+type HourlyStats struct {
+	retention time.Duration
+
+	lock sync.Mutex
+	// counts is the hour of the kind to the field to the count
+	counts map[statsHour]map[string]int64
+}
+
+type statsHour struct {
+	kind string
+	hour time.Time
+}
+
+// NewHourlyStats create the hourly stats, the default retention if 0
+func NewHourlyStats(retention time.Duration) *HourlyStats {
+	if retention <= 0 {
+		retention = DefaultStatsRetention
+	}
+	return &HourlyStats{retention: retention, counts: make(map[statsHour]map[string]int64)}
+}
+
+// Add the count of the field of the kind in the hour of now, nil stats is no-op
+func (s *HourlyStats) Add(kind string, field string, n int64, now time.Time) {
+	if s == nil || n == 0 || field == "" {
+		return
+	}
+
+	key := statsHour{kind: kind, hour: startOfHour(now)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fields := s.counts[key]
+	if fields == nil {
+		fields = make(map[string]int64)
+		s.counts[key] = fields
+	}
+	fields[field] += n
+}
+
+// Flush add the accumulated counts to the store, the counts failed to add are dropped, nil
+// stats is no-op
+func (s *HourlyStats) Flush(store Store) error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	counts := s.counts
+	s.counts = make(map[statsHour]map[string]int64)
+	s.lock.Unlock()
+
+	var lastErr error
+	for h, fields := range counts {
+		key := GetRedisStatsKey(h.kind, StatsHour(h.hour))
+		for field, n := range fields {
+			if err := store.HIncrBy(key, field, n); err != nil {
+				lastErr = err
+			}
+		}
+
+		// the hour is complete after an hour, kept for the retention since then, the expired
+		// hour is deleted for the store not expire the hashes
+		if _, err := store.Expire(key, s.retention+time.Hour); err != nil {
+			lastErr = err
+		}
+		if err := store.Del(GetRedisStatsKey(h.kind, StatsHour(h.hour.Add(-s.retention-time.Hour)))); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// StatsCount is the count of the field of the hourly stats
+type StatsCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// TopStats sum the counts of the fields of the kind in the hours, return the top n in the order
+// of the count desc, all if n is 0
+func TopStats(store Store, kind string, hours []string, n int) ([]StatsCount, error) {
+	sums := make(map[string]int64)
+	for _, hour := range hours {
+		fields, err := store.HGetAll(GetRedisStatsKey(kind, hour))
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range fields {
+			count, _ := strconv.ParseInt(value, 10, 64)
+			sums[field] += count
+		}
+	}
+
+	list := make([]StatsCount, 0, len(sums))
+	for name, count := range sums {
+		list = append(list, StatsCount{Name: name, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list, nil
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHourlyStats(t *testing.T) {
+	store := NewMemoryStore(&Memory{})
+	stats := NewHourlyStats(24 * time.Hour)
+
+	now := time.Date(2026, 10, 16, 10, 30, 0, 0, time.Local)
+	before := now.Add(-time.Hour)
+	stats.Add(StatsProxied, "google.com", 3, now)
+	stats.Add(StatsProxied, "youtube.com", 1, now)
+	stats.Add(StatsProxied, "youtube.com", 5, before)
+	stats.Add(StatsProxied, "", 1, now)
+	if err := stats.Flush(store); err != nil {
+		t.Fatal(err)
+	}
+
+	stats.Add(StatsProxied, "google.com", 1, now)
+	stats.Flush(store)
+
+	hours := StatsHours(before, now)
+	if len(hours) != 2 || hours[0] != "2026101609" || hours[1] != "2026101610" {
+		t.Fatal("unexpected hours", hours)
+	}
+
+	top, err := TopStats(store, StatsProxied, hours, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0] != (StatsCount{Name: "youtube.com", Count: 6}) {
+		t.Fatal("unexpected top", top)
+	}
+
+	top, _ = TopStats(store, StatsProxied, hours[1:], 0)
+	if len(top) != 2 || top[0] != (StatsCount{Name: "google.com", Count: 4}) {
+		t.Fatal("unexpected top of the hour", top)
+	}
+
+	// the hour expired is deleted on flush
+	stats.Add(StatsProxied, "google.com", 1, now.Add(25*time.Hour))
+	stats.Flush(store)
+	if top, _ = TopStats(store, StatsProxied, hours[1:], 0); len(top) != 0 {
+		t.Fatal("the expired hour should be deleted", top)
+	}
+
+	var empty *HourlyStats
+	empty.Add(StatsProxied, "google.com", 1, now)
+	if err = empty.Flush(store); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrNil is returned by Store.Get when the key does not exist
+var ErrNil = errors.New("kungfu: nil")
+
+// Store is the storage backend of config, domain set and ip cache
+type Store interface {
+	Get(key string) (string, error)
+	Set(key string, value string, expiration time.Duration) error
+	SetNX(key string, value string, expiration time.Duration) (bool, error)
+	Del(keys ...string) error
+	Rename(key string, newKey string) error
+	// TTL return -2s if the key does not exist, -1s if the key has no expiration
+	TTL(key string) (time.Duration, error)
+	Expire(key string, expiration time.Duration) (bool, error)
+	Incr(key string) (int64, error)
+	// Keys return the keys match the glob pattern, scan incrementally on redis
+	Keys(pattern string) ([]string, error)
+	// Scan return a page of about count keys match the glob pattern from the cursor and the
+	// cursor of the next page, the next cursor is 0 at the end
+	Scan(cursor uint64, pattern string, count int64) ([]string, uint64, error)
+	// MapDomain atomically map the domain to ip, both the domain key and the reverse ip key are
+	// set with ttl, return the ip already mapped if the domain key exists, or ErrNil if the ip
+	// is owned by other domain
+	MapDomain(domainKey string, ipKey string, domain string, ip string, ttl time.Duration) (string, error)
+
+	// UpdateSet atomically replace the members loaded last time (recorded in the loadedKey set)
+	// with the members, the members added to the set by others are kept, return the count of
+	// the members added and removed compared with the last time
+	UpdateSet(key string, loadedKey string, members []string) (added int, removed int, err error)
+
+	SAdd(key string, members ...string) error
+	SRem(key string, members ...string) error
+	SIsMember(key string, member string) (bool, error)
+	SMembers(key string) ([]string, error)
+
+	HSet(key string, field string, value string) error
+	HDel(key string, fields ...string) error
+	// HIncrBy increase the integer field of the hash
+	HIncrBy(key string, field string, incr int64) error
+	HGetAll(key string) (map[string]string, error)
+
+	// XAdd append the entry to the stream, trimmed to about maxLen entries
+	XAdd(stream string, maxLen int64, values map[string]string) error
+
+	Publish(channel string, message string) error
+	Subscribe(channels ...string) Subscription
+
+	// Close release the connections of the store
+	Close() error
+}
+
+// Subscription receive the messages of subscribed channels
+type Subscription interface {
+	ReceiveMessage() (*Message, error)
+	Close() error
+}
+
+// Message is the message published to channel
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// NewStore is for create the store backend via config
+func NewStore(config *Config) Store {
+	switch config.Store {
+	case "", "redis":
+		return NewRedisStore(NewRedisClient(&config.Redis))
+	case "memory":
+		return NewMemoryStore(&config.Memory)
+	}
+
+	log.Error("unsupported store %s", config.Store)
+	os.Exit(1)
+	return nil
+}
@@ -0,0 +1,496 @@
+package internal
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const memoryStoreSweepInterval = time.Duration(time.Minute)
+
+var errSubscriptionClosed = errors.New("kungfu: subscription closed")
+
+// memoryStore is the process local store, for running without redis
+type memoryStore struct {
+	lock        sync.RWMutex
+	values      map[string]*memoryValue
+	sets        map[string]map[string]bool
+	hashes      map[string]map[string]string
+	streams     map[string][]map[string]string
+	subscribers map[*memorySubscription]bool
+}
+
+type memoryValue struct {
+	value  string
+	expire time.Time
+}
+
+func (v *memoryValue) expired(now time.Time) bool {
+	return !v.expire.IsZero() && now.After(v.expire)
+}
+
+// NewMemoryStore create in memory store, seeded with the config
+func NewMemoryStore(config *Memory) Store {
+	s := &memoryStore{
+		values:      make(map[string]*memoryValue),
+		sets:        make(map[string]map[string]bool),
+		hashes:      make(map[string]map[string]string),
+		streams:     make(map[string][]map[string]string),
+		subscribers: make(map[*memorySubscription]bool),
+	}
+
+	for k, v := range config.Keys {
+		s.Set(GetRedisKey(k), v, 0)
+	}
+
+	for k, members := range config.Sets {
+		s.SAdd(GetRedisKey(k), members...)
+	}
+
+	go s.sweep()
+
+	return s
+}
+
+func (s *memoryStore) get(key string) *memoryValue {
+	v := s.values[key]
+	if v == nil || v.expired(time.Now()) {
+		return nil
+	}
+	return v
+}
+
+func (s *memoryStore) Get(key string) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	v := s.get(key)
+	if v == nil {
+		return "", ErrNil
+	}
+	return v.value, nil
+}
+
+func (s *memoryStore) set(key string, value string, expiration time.Duration) {
+	v := &memoryValue{value: value}
+	if expiration > 0 {
+		v.expire = time.Now().Add(expiration)
+	}
+	s.values[key] = v
+}
+
+func (s *memoryStore) Set(key string, value string, expiration time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.set(key, value, expiration)
+	return nil
+}
+
+func (s *memoryStore) SetNX(key string, value string, expiration time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.get(key) != nil {
+		return false, nil
+	}
+
+	s.set(key, value, expiration)
+	return true, nil
+}
+
+func (s *memoryStore) Del(keys ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, k := range keys {
+		delete(s.values, k)
+		delete(s.sets, k)
+		delete(s.hashes, k)
+	}
+	return nil
+}
+
+func (s *memoryStore) Rename(key string, newKey string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if v := s.get(key); v != nil {
+		delete(s.values, key)
+		delete(s.sets, newKey)
+		s.values[newKey] = v
+		return nil
+	}
+
+	if set, ok := s.sets[key]; ok {
+		delete(s.sets, key)
+		delete(s.values, newKey)
+		s.sets[newKey] = set
+		return nil
+	}
+
+	return errors.New("no such key")
+}
+
+func (s *memoryStore) MapDomain(domainKey string, ipKey string, domain string, ip string, ttl time.Duration) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if v := s.get(domainKey); v != nil {
+		return v.value, nil
+	}
+
+	if v := s.get(ipKey); v != nil && v.value != domain {
+		return "", ErrNil
+	}
+
+	s.set(ipKey, domain, ttl)
+	s.set(domainKey, ip, ttl)
+	return ip, nil
+}
+
+func (s *memoryStore) TTL(key string) (time.Duration, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	v := s.get(key)
+	if v == nil {
+		if _, ok := s.sets[key]; ok {
+			return -time.Second, nil
+		}
+		return -2 * time.Second, nil
+	}
+
+	if v.expire.IsZero() {
+		return -time.Second, nil
+	}
+
+	return time.Until(v.expire) / time.Second * time.Second, nil
+}
+
+func (s *memoryStore) Expire(key string, expiration time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v := s.get(key)
+	if v == nil {
+		return false, nil
+	}
+
+	v.expire = time.Now().Add(expiration)
+	return true, nil
+}
+
+func (s *memoryStore) Incr(key string) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var n int64
+	v := s.get(key)
+	if v != nil {
+		var err error
+		n, err = strconv.ParseInt(v.value, 10, 64)
+		if err != nil {
+			return 0, errors.New("value is not an integer")
+		}
+	}
+
+	n++
+	if v == nil {
+		s.set(key, strconv.FormatInt(n, 10), 0)
+	} else {
+		v.value = strconv.FormatInt(n, 10)
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Keys(pattern string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var keys []string
+	now := time.Now()
+	for k, v := range s.values {
+		if v.expired(now) {
+			continue
+		}
+
+		ok, err := path.Match(pattern, k)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+
+	for k := range s.sets {
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+
+	for k := range s.hashes {
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// Scan page the sorted matched keys, the cursor is the offset of the page
+func (s *memoryStore) Scan(cursor uint64, pattern string, count int64) ([]string, uint64, error) {
+	keys, err := s.Keys(pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Strings(keys)
+	if cursor >= uint64(len(keys)) {
+		return nil, 0, nil
+	}
+
+	end := cursor + uint64(count)
+	if count <= 0 || end >= uint64(len(keys)) {
+		return keys[cursor:], 0, nil
+	}
+	return keys[cursor:end], end, nil
+}
+
+func (s *memoryStore) UpdateSet(key string, loadedKey string, members []string) (added int, removed int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current := make(map[string]bool, len(members))
+	for _, m := range members {
+		current[m] = true
+	}
+
+	last := s.sets[loadedKey]
+	set := s.sets[key]
+	for m := range last {
+		if !current[m] {
+			delete(set, m)
+			removed++
+		}
+	}
+
+	if len(current) == 0 {
+		delete(s.sets, loadedKey)
+		return 0, removed, nil
+	}
+
+	if set == nil {
+		set = make(map[string]bool, len(current))
+		s.sets[key] = set
+	}
+	for m := range current {
+		if !last[m] {
+			added++
+		}
+		set[m] = true
+	}
+	s.sets[loadedKey] = current
+	return added, removed, nil
+}
+
+func (s *memoryStore) SAdd(key string, members ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	set := s.sets[key]
+	if set == nil {
+		set = make(map[string]bool, len(members))
+		s.sets[key] = set
+	}
+
+	for _, m := range members {
+		set[m] = true
+	}
+	return nil
+}
+
+func (s *memoryStore) SRem(key string, members ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	set := s.sets[key]
+	for _, m := range members {
+		delete(set, m)
+	}
+	return nil
+}
+
+func (s *memoryStore) SIsMember(key string, member string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.sets[key][member], nil
+}
+
+func (s *memoryStore) SMembers(key string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	set := s.sets[key]
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (s *memoryStore) HSet(key string, field string, value string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash := s.hashes[key]
+	if hash == nil {
+		hash = make(map[string]string)
+		s.hashes[key] = hash
+	}
+	hash[field] = value
+	return nil
+}
+
+func (s *memoryStore) HDel(key string, fields ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash := s.hashes[key]
+	for _, f := range fields {
+		delete(hash, f)
+	}
+	return nil
+}
+
+func (s *memoryStore) HIncrBy(key string, field string, incr int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash := s.hashes[key]
+	if hash == nil {
+		hash = make(map[string]string)
+		s.hashes[key] = hash
+	}
+
+	var n int64
+	if v, ok := hash[field]; ok {
+		var err error
+		n, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.New("hash value is not an integer")
+		}
+	}
+	hash[field] = strconv.FormatInt(n+incr, 10)
+	return nil
+}
+
+func (s *memoryStore) HGetAll(key string) (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	hash := make(map[string]string, len(s.hashes[key]))
+	for k, v := range s.hashes[key] {
+		hash[k] = v
+	}
+	return hash, nil
+}
+
+func (s *memoryStore) XAdd(stream string, maxLen int64, values map[string]string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries := append(s.streams[stream], values)
+	if maxLen > 0 && int64(len(entries)) > maxLen {
+		entries = append([]map[string]string(nil), entries[int64(len(entries))-maxLen:]...)
+	}
+	s.streams[stream] = entries
+	return nil
+}
+
+func (s *memoryStore) Publish(channel string, message string) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	m := &Message{Channel: channel, Payload: message}
+	for sub := range s.subscribers {
+		if sub.channels[channel] {
+			select {
+			case sub.messages <- m:
+			default:
+				log.Warning("memory store subscriber is slow, drop message of channel %s", channel)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Subscribe(channels ...string) Subscription {
+	sub := &memorySubscription{
+		store:    s,
+		channels: make(map[string]bool, len(channels)),
+		messages: make(chan *Message, 100),
+	}
+
+	for _, c := range channels {
+		sub.channels[c] = true
+	}
+
+	s.lock.Lock()
+	s.subscribers[sub] = true
+	s.lock.Unlock()
+
+	return sub
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) sweep() {
+	for now := range time.Tick(memoryStoreSweepInterval) {
+		s.removeExpired(now)
+	}
+}
+
+// removeExpired delete the values expired at now
+func (s *memoryStore) removeExpired(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for k, v := range s.values {
+		if v.expired(now) {
+			delete(s.values, k)
+		}
+	}
+}
+
+type memorySubscription struct {
+	store    *memoryStore
+	channels map[string]bool
+	messages chan *Message
+}
+
+func (sub *memorySubscription) ReceiveMessage() (*Message, error) {
+	m, ok := <-sub.messages
+	if !ok {
+		return nil, errSubscriptionClosed
+	}
+	return m, nil
+}
+
+func (sub *memorySubscription) Close() error {
+	sub.store.lock.Lock()
+	defer sub.store.lock.Unlock()
+
+	if sub.store.subscribers[sub] {
+		delete(sub.store.subscribers, sub)
+		close(sub.messages)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTTL(t *testing.T) {
+	s := NewMemoryStore(&Memory{Keys: map[string]string{"network": "10.85.0.1/16"}}).(*memoryStore)
+
+	if v, err := s.Get(GetRedisNetworkKey()); err != nil || v != "10.85.0.1/16" {
+		t.Fatal("the store should be seeded", v, err)
+	}
+
+	s.Set("a", "1", 0)
+	s.Set("b", "2", time.Hour)
+	s.Set("c", "3", time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	if ttl, _ := s.TTL("a"); ttl != -time.Second {
+		t.Fatal("the key without expiration should be -1s", ttl)
+	}
+	if ttl, _ := s.TTL("b"); ttl <= time.Minute*59 || ttl > time.Hour {
+		t.Fatal("unexpected ttl", ttl)
+	}
+	if ttl, _ := s.TTL("missing"); ttl != -2*time.Second {
+		t.Fatal("the missing key should be -2s", ttl)
+	}
+
+	// the expired key is missing until swept
+	if _, err := s.Get("c"); err != ErrNil {
+		t.Fatal("the expired key should be missing", err)
+	}
+	if ttl, _ := s.TTL("c"); ttl != -2*time.Second {
+		t.Fatal("the expired key should be -2s", ttl)
+	}
+	if keys, _ := s.Keys("?"); len(keys) != 2 {
+		t.Fatal("the expired key should not be listed", keys)
+	}
+	if s.values["c"] == nil {
+		t.Fatal("the expired key should be kept until swept")
+	}
+	s.removeExpired(time.Now())
+	if s.values["c"] != nil || s.values["b"] == nil {
+		t.Fatal("only the expired key should be swept")
+	}
+
+	// expire
+	if ok, _ := s.Expire("missing", time.Hour); ok {
+		t.Fatal("the missing key should not be expired")
+	}
+	if ok, _ := s.Expire("a", time.Millisecond); !ok {
+		t.Fatal("the key should be expired")
+	}
+	time.Sleep(time.Millisecond * 5)
+	if _, err := s.Get("a"); err != ErrNil {
+		t.Fatal("the key should be expired", err)
+	}
+}
+
+func TestMemoryStoreValues(t *testing.T) {
+	s := NewMemoryStore(&Memory{})
+
+	if ok, _ := s.SetNX("lock", "a", time.Millisecond); !ok {
+		t.Fatal("the key should be set")
+	}
+	if ok, _ := s.SetNX("lock", "b", time.Hour); ok {
+		t.Fatal("the existing key should not be set")
+	}
+	time.Sleep(time.Millisecond * 5)
+	if ok, _ := s.SetNX("lock", "b", time.Hour); !ok {
+		t.Fatal("the expired key should be set")
+	}
+	if v, _ := s.Get("lock"); v != "b" {
+		t.Fatal("unexpected value", v)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if n, err := s.Incr("counter"); err != nil || n != i {
+			t.Fatal("unexpected counter", n, err)
+		}
+	}
+	if _, err := s.Incr("lock"); err == nil {
+		t.Fatal("the value not integer should fail")
+	}
+
+	// rename the value and the set
+	s.SAdd("set", "x", "y")
+	if err := s.Rename("counter", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("renamed"); v != "3" {
+		t.Fatal("the value should be renamed", v)
+	}
+	if _, err := s.Get("counter"); err != ErrNil {
+		t.Fatal("the old key should be removed", err)
+	}
+	s.SAdd("loaded", "old")
+	if err := s.Rename("set", "loaded"); err != nil {
+		t.Fatal(err)
+	}
+	members, _ := s.SMembers("loaded")
+	sort.Strings(members)
+	if len(members) != 2 || members[0] != "x" || members[1] != "y" {
+		t.Fatal("the set should replace the new key", members)
+	}
+	if err := s.Rename("missing", "other"); err == nil {
+		t.Fatal("rename the missing key should fail")
+	}
+
+	s.Del("renamed", "loaded")
+	if keys, _ := s.Keys("*"); len(keys) != 1 || keys[0] != "lock" {
+		t.Fatal("the keys should be deleted", keys)
+	}
+}
+
+func TestMemoryStoreScan(t *testing.T) {
+	s := NewMemoryStore(&Memory{})
+	for _, k := range []string{"ip:1", "ip:2", "ip:3", "ip:4", "ip:5", "domain:a"} {
+		s.Set(k, "v", 0)
+	}
+
+	var keys []string
+	var cursor uint64
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("the scan should end", cursor)
+		}
+
+		page, next, err := s.Scan(cursor, "ip:*", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page) > 2 {
+			t.Fatal("the page should not exceed the count", page)
+		}
+
+		keys = append(keys, page...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	if len(keys) != 5 || keys[0] != "ip:1" || keys[4] != "ip:5" {
+		t.Fatal("the matched keys should be scanned", keys)
+	}
+}
+
+func TestMemoryStoreMapDomain(t *testing.T) {
+	s := NewMemoryStore(&Memory{})
+
+	if ip, err := s.MapDomain("domain:a.com", "ip:10.85.0.2", "a.com", "10.85.0.2", time.Hour); err != nil || ip != "10.85.0.2" {
+		t.Fatal("the domain should be mapped", ip, err)
+	}
+	// the domain already mapped return the ip mapped
+	if ip, err := s.MapDomain("domain:a.com", "ip:10.85.0.3", "a.com", "10.85.0.3", time.Hour); err != nil || ip != "10.85.0.2" {
+		t.Fatal("the ip mapped should be returned", ip, err)
+	}
+	// the ip owned by other domain
+	if _, err := s.MapDomain("domain:b.com", "ip:10.85.0.2", "b.com", "10.85.0.2", time.Hour); err != ErrNil {
+		t.Fatal("the ip of other domain should conflict", err)
+	}
+	if ttl, _ := s.TTL("ip:10.85.0.2"); ttl <= 0 {
+		t.Fatal("the reverse key should be set with ttl", ttl)
+	}
+
+	// the ip expired can be mapped again
+	s.MapDomain("domain:c.com", "ip:10.85.0.4", "c.com", "10.85.0.4", time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	if ip, err := s.MapDomain("domain:d.com", "ip:10.85.0.4", "d.com", "10.85.0.4", time.Hour); err != nil || ip != "10.85.0.4" {
+		t.Fatal("the expired ip should be mapped", ip, err)
+	}
+}
+
+func TestMemoryStoreSubscribe(t *testing.T) {
+	s := NewMemoryStore(&Memory{})
+
+	sub := s.Subscribe("a", "b")
+	s.Publish("c", "ignored")
+	s.Publish("b", "hello")
+
+	m, err := sub.ReceiveMessage()
+	if err != nil || m.Channel != "b" || m.Payload != "hello" {
+		t.Fatal("unexpected message", m, err)
+	}
+
+	sub.Close()
+	// closed twice and published after closed
+	sub.Close()
+	s.Publish("a", "dropped")
+	if _, err := sub.ReceiveMessage(); err != errSubscriptionClosed {
+		t.Fatal("the subscription should be closed", err)
+	}
+}
+
+func TestMemoryStoreUpdateSet(t *testing.T) {
+	s := NewMemoryStore(&Memory{})
+
+	if added, removed, err := s.UpdateSet("set", "loaded", []string{"a", "b"}); err != nil || added != 2 || removed != 0 {
+		t.Fatal("unexpected update", added, removed, err)
+	}
+	// the member added manually is kept
+	s.SAdd("set", "manual")
+
+	if added, removed, err := s.UpdateSet("set", "loaded", []string{"b", "c"}); err != nil || added != 1 || removed != 1 {
+		t.Fatal("unexpected update", added, removed, err)
+	}
+	members, _ := s.SMembers("set")
+	sort.Strings(members)
+	if strings.Join(members, ",") != "b,c,manual" {
+		t.Fatal("unexpected members", members)
+	}
+	loaded, _ := s.SMembers("loaded")
+	sort.Strings(loaded)
+	if strings.Join(loaded, ",") != "b,c" {
+		t.Fatal("unexpected loaded members", loaded)
+	}
+
+	if added, removed, err := s.UpdateSet("set", "loaded", nil); err != nil || added != 0 || removed != 2 {
+		t.Fatal("unexpected update", added, removed, err)
+	}
+	if members, _ = s.SMembers("set"); len(members) != 1 || members[0] != "manual" {
+		t.Fatal("only the manual member should be kept", members)
+	}
+	if keys, _ := s.Keys("loaded"); len(keys) != 0 {
+		t.Fatal("the loaded set should be removed", keys)
+	}
+}
@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/yinheli/kungfu/metrics"
+)
+
+var storeErrorsTotal = metrics.NewCounter("kungfu_store_errors_total",
+	"redis command errors by command", "command")
+
+// mapDomainScript set the domain key and the reverse ip key in one step, the ip owned by the
+// same domain (it's domain key expired first) is taken back
+var mapDomainScript = redis.NewScript(`
+local mapped = redis.call('GET', KEYS[1])
+if mapped then
+	return mapped
+end
+
+local owner = redis.call('GET', KEYS[2])
+if owner and owner ~= ARGV[1] then
+	return false
+end
+
+redis.call('SET', KEYS[2], ARGV[1], 'PX', ARGV[3])
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return ARGV[2]
+`)
+
+// updateSetScript replace the members loaded last time with the members in one step, the
+// members are added in chunks, unpack is limited by the stack of lua
+var updateSetScript = redis.NewScript(`
+redis.call('DEL', KEYS[3])
+for i = 1, #ARGV, 4096 do
+	redis.call('SADD', KEYS[3], unpack(ARGV, i, math.min(i + 4095, #ARGV)))
+end
+
+local removed = redis.call('SDIFF', KEYS[2], KEYS[3])
+for i = 1, #removed, 4096 do
+	redis.call('SREM', KEYS[1], unpack(removed, i, math.min(i + 4095, #removed)))
+end
+
+if #ARGV == 0 then
+	redis.call('DEL', KEYS[2])
+	return {0, #removed}
+end
+
+local added = redis.call('SDIFF', KEYS[3], KEYS[2])
+redis.call('SUNIONSTORE', KEYS[1], KEYS[1], KEYS[3])
+redis.call('RENAME', KEYS[3], KEYS[2])
+return {#added, #removed}
+`)
+
+type redisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore create store backed by redis, with the circuit breaker
+func NewRedisStore(client *redis.Client) Store {
+	client.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			err := process(cmd)
+			if err != nil && err != redis.Nil {
+				storeErrorsTotal.Inc(cmd.Name())
+			}
+			return err
+		}
+	})
+	return newBreakerStore(&redisStore{client: client})
+}
+
+func (s *redisStore) Get(key string) (string, error) {
+	v, err := s.client.Get(key).Result()
+	if err == redis.Nil {
+		err = ErrNil
+	}
+	return v, err
+}
+
+func (s *redisStore) Set(key string, value string, expiration time.Duration) error {
+	return s.client.Set(key, value, expiration).Err()
+}
+
+func (s *redisStore) SetNX(key string, value string, expiration time.Duration) (bool, error) {
+	return s.client.SetNX(key, value, expiration).Result()
+}
+
+func (s *redisStore) Del(keys ...string) error {
+	return s.client.Del(keys...).Err()
+}
+
+func (s *redisStore) Rename(key string, newKey string) error {
+	return s.client.Rename(key, newKey).Err()
+}
+
+func (s *redisStore) TTL(key string) (time.Duration, error) {
+	return s.client.TTL(key).Result()
+}
+
+func (s *redisStore) Expire(key string, expiration time.Duration) (bool, error) {
+	return s.client.Expire(key, expiration).Result()
+}
+
+func (s *redisStore) Incr(key string) (int64, error) {
+	return s.client.Incr(key).Result()
+}
+
+func (s *redisStore) Keys(pattern string) ([]string, error) {
+	var keys []string
+	var cursor uint64
+	for {
+		page, next, err := s.client.Scan(cursor, pattern, 1000).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, page...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+func (s *redisStore) Scan(cursor uint64, pattern string, count int64) ([]string, uint64, error) {
+	return s.client.Scan(cursor, pattern, count).Result()
+}
+
+func (s *redisStore) MapDomain(domainKey string, ipKey string, domain string, ip string, ttl time.Duration) (string, error) {
+	v, err := mapDomainScript.Run(s.client, []string{domainKey, ipKey},
+		domain, ip, int64(ttl/time.Millisecond)).Result()
+	if err == redis.Nil {
+		return "", ErrNil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	mapped, _ := v.(string)
+	return mapped, nil
+}
+
+func (s *redisStore) UpdateSet(key string, loadedKey string, members []string) (int, int, error) {
+	v, err := updateSetScript.Run(s.client, []string{key, loadedKey, loadedKey + ":tmp"},
+		toInterfaces(members)...).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	counts, _ := v.([]interface{})
+	if len(counts) != 2 {
+		return 0, 0, fmt.Errorf("unexpected result of update set %v", v)
+	}
+	added, _ := counts[0].(int64)
+	removed, _ := counts[1].(int64)
+	return int(added), int(removed), nil
+}
+
+func (s *redisStore) SAdd(key string, members ...string) error {
+	return s.client.SAdd(key, toInterfaces(members)...).Err()
+}
+
+func (s *redisStore) SRem(key string, members ...string) error {
+	return s.client.SRem(key, toInterfaces(members)...).Err()
+}
+
+func (s *redisStore) SIsMember(key string, member string) (bool, error) {
+	return s.client.SIsMember(key, member).Result()
+}
+
+func (s *redisStore) SMembers(key string) ([]string, error) {
+	return s.client.SMembers(key).Result()
+}
+
+func (s *redisStore) HSet(key string, field string, value string) error {
+	return s.client.HSet(key, field, value).Err()
+}
+
+func (s *redisStore) HDel(key string, fields ...string) error {
+	return s.client.HDel(key, fields...).Err()
+}
+
+func (s *redisStore) HIncrBy(key string, field string, incr int64) error {
+	return s.client.HIncrBy(key, field, incr).Err()
+}
+
+func (s *redisStore) HGetAll(key string) (map[string]string, error) {
+	return s.client.HGetAll(key).Result()
+}
+
+func (s *redisStore) XAdd(stream string, maxLen int64, values map[string]string) error {
+	args := []interface{}{"xadd", stream, "maxlen", "~", maxLen, "*"}
+	for k, v := range values {
+		args = append(args, k, v)
+	}
+
+	cmd := redis.NewCmd(args...)
+	s.client.Process(cmd)
+	return cmd.Err()
+}
+
+func (s *redisStore) Publish(channel string, message string) error {
+	return s.client.Publish(channel, message).Err()
+}
+
+func (s *redisStore) Subscribe(channels ...string) Subscription {
+	return &redisSubscription{pubsub: s.client.Subscribe(channels...)}
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+}
+
+func (s *redisSubscription) ReceiveMessage() (*Message, error) {
+	m, err := s.pubsub.ReceiveMessage()
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Channel: m.Channel, Payload: m.Payload}, nil
+}
+
+func (s *redisSubscription) Close() error {
+	return s.pubsub.Close()
+}
+
+func toInterfaces(values []string) []interface{} {
+	r := make([]interface{}, len(values))
+	for i, v := range values {
+		r[i] = v
+	}
+	return r
+}
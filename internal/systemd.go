@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sdListenFdsStart is the first fd passed by the systemd socket activation
+const sdListenFdsStart = 3
+
+var (
+	activationOnce      sync.Once
+	activationListeners []net.Listener
+	activationConns     []net.PacketConn
+)
+
+// SdNotify send the state to systemd, e.g. READY=1 or WATCHDOG=1, no-op if not started by
+// systemd with Type=notify ($NOTIFY_SOCKET not set)
+func SdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// the abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// SdWatchdog return the interval of the watchdog of the service (WatchdogSec), 0 if disabled
+func SdWatchdog() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// SdReady notify systemd the service is ready, and keep the watchdog alive at half the
+// interval while alive return true, a hang alive stop the pings and systemd restart the service
+func SdReady(status string, alive func() bool) {
+	if err := SdNotify("READY=1\nSTATUS=" + status); err != nil {
+		log.Warning("notify systemd ready error, %v", err)
+	}
+
+	interval := SdWatchdog()
+	if interval == 0 {
+		return
+	}
+
+	log.Info("systemd watchdog enabled, interval %v", interval)
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if alive != nil && !alive() {
+				log.Warning("service is not alive, skip the systemd watchdog ping")
+				continue
+			}
+			if err := SdNotify("WATCHDOG=1"); err != nil {
+				log.Warning("notify systemd watchdog error, %v", err)
+			}
+		}
+	}()
+}
+
+// ActivationSockets return the sockets passed by the systemd socket activation ($LISTEN_FDS),
+// the stream listeners and the datagram conns, only the first call get the sockets
+func ActivationSockets() ([]net.Listener, []net.PacketConn) {
+	activationOnce.Do(func() {
+		activationListeners, activationConns = activationSockets()
+	})
+
+	listeners, conns := activationListeners, activationConns
+	activationListeners, activationConns = nil, nil
+	return listeners, conns
+}
+
+func activationSockets() (listeners []net.Listener, conns []net.PacketConn) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// not inherited by the child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(sdListenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(sdListenFdsStart+i), name)
+		if l, err := net.FileListener(f); err == nil {
+			listeners = append(listeners, l)
+		} else if c, err := net.FilePacketConn(f); err == nil {
+			conns = append(conns, c)
+		} else {
+			log.Warning("unsupported activation socket %s, %v", name, err)
+		}
+		// the sockets are duplicated
+		f.Close()
+	}
+
+	log.Info("systemd socket activation, %d stream, %d datagram sockets", len(listeners), len(conns))
+	return listeners, conns
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	// no-op if not supervised
+	os.Unsetenv("NOTIFY_SOCKET")
+	if err := SdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "kungfu-systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unixgram not supported,", err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if err = SdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Fatalf("unexpected state %q", buf[:n])
+	}
+}
+
+func TestSdWatchdog(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if interval := SdWatchdog(); interval != time.Second*30 {
+		t.Fatal("unexpected watchdog interval", interval)
+	}
+
+	// the watchdog of other process
+	os.Setenv("WATCHDOG_PID", "1")
+	if interval := SdWatchdog(); interval != 0 {
+		t.Fatal("watchdog of other process should be disabled", interval)
+	}
+}
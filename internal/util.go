@@ -21,3 +21,17 @@ func Ipv4ToInt(ip net.IP) uint32 {
 func IntToIpv4(v uint32) net.IP {
 	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 }
+
+// AddToIp add n to ip (ipv4 or ipv6), carry over the whole address
+func AddToIp(ip net.IP, n uint64) net.IP {
+	r := make(net.IP, len(ip))
+	copy(r, ip)
+
+	for i := len(r) - 1; i >= 0 && n > 0; i-- {
+		v := uint64(r[i]) + (n & 0xff)
+		r[i] = byte(v)
+		n = (n >> 8) + (v >> 8)
+	}
+
+	return r
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// WireguardDefaultMTU is the mtu of the tunnel if not set, the same as wg-quick
+	WireguardDefaultMTU = 1420
+	// WireguardDefaultKeepalive is the persistent keepalive seconds if not set, keep the
+	// handshake fresh for the health check
+	WireguardDefaultKeepalive = 25
+)
+
+// WireguardDefaultDNS is the dns server resolving the targets through the tunnel if not set
+var WireguardDefaultDNS = netip.MustParseAddr("1.1.1.1")
+
+// Wireguard is the peer and the interface of the wireguard proxy url,
+// wireguard://<private key>@<endpoint host:port>?publickey=<peer public key>&address=<ip>[,<ip>]
+// with the optional presharedkey, dns, mtu and keepalive, the keys are base64 (the / of the
+// private key escaped as %2F)
+type Wireguard struct {
+	PrivateKey   []byte
+	PublicKey    []byte
+	PresharedKey []byte
+	Endpoint     string
+	// Addresses is the ips of the interface, the prefix length is ignored
+	Addresses []netip.Addr
+	DNS       []netip.Addr
+	MTU       int
+	// Keepalive is the persistent keepalive seconds, 0 to disable
+	Keepalive int
+}
+
+// ParseWireguard parse the wireguard proxy url, wireguard:// or wg://
+func ParseWireguard(u *url.URL) (*Wireguard, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("wireguard: private key required")
+	}
+
+	w := &Wireguard{Endpoint: u.Host, MTU: WireguardDefaultMTU, Keepalive: WireguardDefaultKeepalive}
+	if u.Port() == "" {
+		return nil, errors.New("wireguard: endpoint port required")
+	}
+
+	var err error
+	if w.PrivateKey, err = wireguardKey("private key", u.User.Username()); err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	if w.PublicKey, err = wireguardKey("public key", query.Get("publickey")); err != nil {
+		return nil, err
+	}
+
+	if s := query.Get("presharedkey"); s != "" {
+		if w.PresharedKey, err = wireguardKey("preshared key", s); err != nil {
+			return nil, err
+		}
+	}
+
+	if w.Addresses, err = wireguardAddrs("address", query.Get("address")); err != nil {
+		return nil, err
+	}
+	if len(w.Addresses) == 0 {
+		return nil, errors.New("wireguard: address required")
+	}
+
+	if w.DNS, err = wireguardAddrs("dns", query.Get("dns")); err != nil {
+		return nil, err
+	}
+	if len(w.DNS) == 0 {
+		w.DNS = []netip.Addr{WireguardDefaultDNS}
+	}
+
+	if s := query.Get("mtu"); s != "" {
+		if w.MTU, err = strconv.Atoi(s); err != nil || w.MTU < 576 || w.MTU > 65535 {
+			return nil, fmt.Errorf("wireguard: invalid mtu %s", s)
+		}
+	}
+
+	if s := query.Get("keepalive"); s != "" {
+		if w.Keepalive, err = strconv.Atoi(s); err != nil || w.Keepalive < 0 || w.Keepalive > 65535 {
+			return nil, fmt.Errorf("wireguard: invalid keepalive %s", s)
+		}
+	}
+	return w, nil
+}
+
+// wireguardKey decode the base64 key, the + of the query is unescaped to space
+func wireguardKey(name string, s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("wireguard: %s required", name)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.Replace(s, " ", "+", -1))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("wireguard: invalid %s", name)
+	}
+	return key, nil
+}
+
+// wireguardAddrs parse the comma separated ips, with or without the prefix length
+func wireguardAddrs(name string, s string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(v); err == nil {
+			addrs = append(addrs, prefix.Addr())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard: invalid %s %s", name, v)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseWireguard(t *testing.T) {
+	// the + of the key in the query is unescaped to space
+	key := strings.Repeat("+", 43) + "="
+	u, _ := url.Parse("wireguard://" + url.PathEscape(key) + "@example.com:51820?publickey=" + key +
+		"&presharedkey=" + url.QueryEscape(key) + "&address=10.0.0.2/32,fd00::2&dns=10.0.0.1&mtu=1280&keepalive=0")
+
+	w, err := ParseWireguard(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := base64.StdEncoding.DecodeString(key)
+	if !bytes.Equal(w.PrivateKey, want) || !bytes.Equal(w.PublicKey, want) || !bytes.Equal(w.PresharedKey, want) {
+		t.Fatal("unexpected keys", w.PrivateKey, w.PublicKey, w.PresharedKey)
+	}
+	if w.Endpoint != "example.com:51820" || len(w.Addresses) != 2 || w.Addresses[1].String() != "fd00::2" {
+		t.Fatal("unexpected interface", w.Endpoint, w.Addresses)
+	}
+	if len(w.DNS) != 1 || w.DNS[0].String() != "10.0.0.1" || w.MTU != 1280 || w.Keepalive != 0 {
+		t.Fatal("unexpected options", w.DNS, w.MTU, w.Keepalive)
+	}
+
+	// defaults
+	u, _ = url.Parse("wg://" + url.PathEscape(key) + "@1.2.3.4:51820?publickey=" + url.QueryEscape(key) + "&address=10.0.0.2")
+	if w, err = ParseWireguard(u); err != nil {
+		t.Fatal(err)
+	}
+	if w.PresharedKey != nil || w.DNS[0] != WireguardDefaultDNS || w.MTU != WireguardDefaultMTU || w.Keepalive != WireguardDefaultKeepalive {
+		t.Fatal("unexpected defaults", w)
+	}
+
+	for _, s := range []string{
+		"wg://1.2.3.4:51820?publickey=" + url.QueryEscape(key) + "&address=10.0.0.2",
+		"wg://" + url.PathEscape(key) + "@1.2.3.4?publickey=" + url.QueryEscape(key) + "&address=10.0.0.2",
+		"wg://" + url.PathEscape(key) + "@1.2.3.4:51820?publickey=short&address=10.0.0.2",
+		"wg://" + url.PathEscape(key) + "@1.2.3.4:51820?publickey=" + url.QueryEscape(key),
+		"wg://" + url.PathEscape(key) + "@1.2.3.4:51820?publickey=" + url.QueryEscape(key) + "&address=10.0.0.2&dns=bad",
+		"wg://" + url.PathEscape(key) + "@1.2.3.4:51820?publickey=" + url.QueryEscape(key) + "&address=10.0.0.2&mtu=100",
+	} {
+		u, _ = url.Parse(s)
+		if _, err := ParseWireguard(u); err == nil {
+			t.Fatal("should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Package ipsync add the real ips of the proxied domains to the ipset or nftables set, for the
+// policy routing of the deployments without the fake ips, the ips are added in batch with the
+// timeout of the answer ttl and expire in the set
+package ipsync
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinheli/kungfu"
+)
+
+// the backends of the sets
+const (
+	BackendIPSet = "ipset"
+	BackendNft   = "nft"
+)
+
+const (
+	defaultInterval = time.Duration(time.Second)
+	// defaultMinTimeout is the minimum timeout of the ips, the answer ttl may be very short
+	defaultMinTimeout = time.Duration(time.Minute * 5)
+	// maxBatch is the max ips flushed at once
+	maxBatch = 1000
+)
+
+var log = kungfu.GetModuleLog("ipsync")
+
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
+// run execute the command with the input, replaced in test
+var run = func(input string, name string, args ...string) error {
+	log.Debug("execute cmd %s %s", name, strings.Join(args, " "))
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v, %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Syncer add the ips to the sets in batch
+type Syncer struct {
+	// Backend is ipset or nft
+	Backend string
+	// Name is the set of the ipv4 ips
+	Name string
+	// Name6 is the set of the ipv6 ips, empty to skip the ipv6 ips
+	Name6 string
+	// Table is the nftables table of the sets, e.g. inet kungfu, nft only
+	Table string
+	// Interval is the flush interval of the batch, default 1s
+	Interval time.Duration
+	// MinTimeout is the minimum timeout of the ips, default 5m
+	MinTimeout time.Duration
+
+	lock sync.Mutex
+	// pending is the ips waiting for flush and the timeout
+	pending map[string]time.Duration
+	// expires is the expire time of the ips in the sets, the ips not near expiry are not added
+	expires map[string]time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Start create the sets if not exist and flush the ips periodically
+func (s *Syncer) Start() error {
+	if s.Interval <= 0 {
+		s.Interval = defaultInterval
+	}
+	if s.MinTimeout <= 0 {
+		s.MinTimeout = defaultMinTimeout
+	}
+	s.pending = make(map[string]time.Duration)
+	s.expires = make(map[string]time.Time)
+
+	if err := s.create(); err != nil {
+		return err
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop()
+
+	log.Info("sync the ips of the proxied domains to %s set %s %s", s.Backend, s.Name, s.Name6)
+	return nil
+}
+
+// Stop flush the pending ips and stop
+func (s *Syncer) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// Add queue the ips to add with the ttl of the answer, the timeout is at least MinTimeout
+func (s *Syncer) Add(ips []net.IP, ttl time.Duration) {
+	if ttl < s.MinTimeout {
+		ttl = s.MinTimeout
+	}
+
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, ip := range ips {
+		if ip.To4() == nil && s.Name6 == "" {
+			continue
+		}
+
+		key := ip.String()
+		// refresh the ips expiring in half of the timeout only
+		if expire, ok := s.expires[key]; ok && expire.Sub(now) > ttl/2 {
+			continue
+		}
+		if ttl > s.pending[key] {
+			s.pending[key] = ttl
+		}
+	}
+}
+
+func (s *Syncer) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush add the pending ips to the sets, the failed ips are dropped and added on the next answer
+func (s *Syncer) flush() {
+	now := time.Now()
+
+	s.lock.Lock()
+	pending := s.pending
+	s.pending = make(map[string]time.Duration)
+	for key, expire := range s.expires {
+		if now.After(expire) {
+			delete(s.expires, key)
+		}
+	}
+	s.lock.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	var v4, v6 []string
+	for key := range pending {
+		if net.ParseIP(key).To4() != nil {
+			v4 = append(v4, key)
+		} else {
+			v6 = append(v6, key)
+		}
+	}
+
+	added := make(map[string]time.Time, len(pending))
+	for _, batch := range []struct {
+		set string
+		ips []string
+	}{{s.Name, v4}, {s.Name6, v6}} {
+		for len(batch.ips) > 0 {
+			n := len(batch.ips)
+			if n > maxBatch {
+				n = maxBatch
+			}
+			ips := batch.ips[:n]
+			batch.ips = batch.ips[n:]
+
+			if err := s.add(batch.set, ips, pending); err != nil {
+				log.Error("add %d ips to set %s error, %v", len(ips), batch.set, err)
+				continue
+			}
+			for _, ip := range ips {
+				added[ip] = now.Add(pending[ip])
+			}
+		}
+	}
+
+	s.lock.Lock()
+	for key, expire := range added {
+		s.expires[key] = expire
+	}
+	s.lock.Unlock()
+
+	log.Debug("add %d ips to the sets", len(added))
+}
+
+// create the sets with the timeout support if not exist
+func (s *Syncer) create() error {
+	switch s.Backend {
+	case BackendIPSet:
+		if err := run("", "ipset", "create", s.Name, "hash:ip", "family", "inet", "timeout", "0", "-exist"); err != nil {
+			return err
+		}
+		if s.Name6 != "" {
+			return run("", "ipset", "create", s.Name6, "hash:ip", "family", "inet6", "timeout", "0", "-exist")
+		}
+		return nil
+	case BackendNft:
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "add table %s\n", s.Table)
+		fmt.Fprintf(&b, "add set %s %s { type ipv4_addr; flags timeout; }\n", s.Table, s.Name)
+		if s.Name6 != "" {
+			fmt.Fprintf(&b, "add set %s %s { type ipv6_addr; flags timeout; }\n", s.Table, s.Name6)
+		}
+		return run(b.String(), "nft", "-f", "-")
+	}
+	return fmt.Errorf("unsupported backend %s", s.Backend)
+}
+
+// add the ips to the set with the timeout in one command
+func (s *Syncer) add(set string, ips []string, timeouts map[string]time.Duration) error {
+	var b bytes.Buffer
+	switch s.Backend {
+	case BackendIPSet:
+		for _, ip := range ips {
+			fmt.Fprintf(&b, "add %s %s timeout %d\n", set, ip, int(timeouts[ip].Seconds()))
+		}
+		return run(b.String(), "ipset", "restore", "-exist")
+	case BackendNft:
+		elements := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			elements = append(elements, fmt.Sprintf("%s timeout %ds", ip, int(timeouts[ip].Seconds())))
+		}
+		fmt.Fprintf(&b, "add element %s %s { %s }\n", s.Table, set, strings.Join(elements, ", "))
+		return run(b.String(), "nft", "-f", "-")
+	}
+	return fmt.Errorf("unsupported backend %s", s.Backend)
+}
@@ -0,0 +1,75 @@
+package ipsync
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRun record the commands and the input
+func fakeRun(cmds *[]string) func(input string, name string, args ...string) error {
+	return func(input string, name string, args ...string) error {
+		*cmds = append(*cmds, name+" "+strings.Join(args, " ")+"\n"+input)
+		return nil
+	}
+}
+
+func TestIPSet(t *testing.T) {
+	var cmds []string
+	run = fakeRun(&cmds)
+
+	s := &Syncer{Backend: BackendIPSet, Name: "kungfu", Interval: time.Hour}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Add([]net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("2001:db8::1")}, time.Second)
+	s.flush()
+
+	all := strings.Join(cmds, "")
+	for _, expect := range []string{
+		"ipset create kungfu hash:ip family inet timeout 0 -exist",
+		"ipset restore -exist\nadd kungfu 1.1.1.1 timeout 300\n",
+	} {
+		if !strings.Contains(all, expect) {
+			t.Fatal("missing command", expect, all)
+		}
+	}
+
+	if strings.Contains(all, "2001:db8::1") {
+		t.Fatal("ipv6 ip should be skipped without ipv6 set")
+	}
+
+	// not near expiry
+	cmds = nil
+	s.Add([]net.IP{net.ParseIP("1.1.1.1")}, time.Minute*5)
+	s.Stop()
+	if len(cmds) != 0 {
+		t.Fatal("the ip in the set should not be added again", cmds)
+	}
+}
+
+func TestNft(t *testing.T) {
+	var cmds []string
+	run = fakeRun(&cmds)
+
+	s := &Syncer{Backend: BackendNft, Name: "kungfu", Name6: "kungfu6", Table: "inet kungfu", Interval: time.Hour}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Add([]net.IP{net.ParseIP("2001:db8::1")}, time.Hour)
+	s.Stop()
+
+	all := strings.Join(cmds, "")
+	for _, expect := range []string{
+		"add set inet kungfu kungfu { type ipv4_addr; flags timeout; }",
+		"add set inet kungfu kungfu6 { type ipv6_addr; flags timeout; }",
+		"add element inet kungfu kungfu6 { 2001:db8::1 timeout 3600s }",
+	} {
+		if !strings.Contains(all, expect) {
+			t.Fatal("missing command", expect, all)
+		}
+	}
+}
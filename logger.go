@@ -1,34 +1,251 @@
 package kungfu
 
 import (
-	"github.com/op/go-logging"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
 )
 
 const module = "kungfu"
 
+// the log levels
+const (
+	LevelDebug   = "debug"
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// the log output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Logger is the leveled logger of the modules, the messages are in printf style
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warning(format string, args ...interface{})
+	Error(format string, args ...interface{})
+	// DebugEnabled return true if the debug messages are logged, to skip the costly arguments
+	DebugEnabled() bool
+}
+
 var (
-	log          = logging.MustGetLogger(module)
-	levelBackend logging.LeveledBackend
+	log    = GetModuleLog(module)
+	levels = &moduleLevels{global: logging.INFO, modules: make(map[string]logging.Level)}
 )
 
 func init() {
+	levels.backend = newTextBackend(os.Stdout)
+	logging.SetBackend(levels)
+}
+
+// moduleLogger is the Logger backed by go-logging
+type moduleLogger struct {
+	*logging.Logger
+}
+
+func (l moduleLogger) DebugEnabled() bool {
+	return l.IsEnabledFor(logging.DEBUG)
+}
+
+// moduleLevels is the log level of all the modules, and the override of each module
+type moduleLevels struct {
+	lock    sync.RWMutex
+	backend logging.Backend
+	global  logging.Level
+	modules map[string]logging.Level
+}
+
+func (l *moduleLevels) GetLevel(module string) logging.Level {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if level, ok := l.modules[module]; ok {
+		return level
+	}
+	return l.global
+}
+
+// SetLevel set the level of the module, all the modules if module is empty
+func (l *moduleLevels) SetLevel(level logging.Level, module string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if module == "" {
+		l.global = level
+		return
+	}
+	l.modules[module] = level
+}
+
+func (l *moduleLevels) IsEnabledFor(level logging.Level, module string) bool {
+	return level <= l.GetLevel(module)
+}
+
+func (l *moduleLevels) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	l.lock.RLock()
+	backend := l.backend
+	l.lock.RUnlock()
+	return backend.Log(level, calldepth+1, rec)
+}
+
+func newTextBackend(w io.Writer) logging.Backend {
 	format := logging.MustStringFormatter(
 		`%{color}%{time:2006-01-02 15:04:05.000} [%{level:.4s}]%{color:reset} - %{message}`,
 	)
+	return logging.NewBackendFormatter(logging.NewLogBackend(w, "", 0), format)
+}
+
+// jsonBackend write the records in json lines
+type jsonBackend struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+type jsonRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Module  string `json:"module"`
+	Message string `json:"msg"`
+}
+
+func (b *jsonBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	line, err := json.Marshal(&jsonRecord{
+		Time:    rec.Time.Format("2006-01-02T15:04:05.000Z07:00"),
+		Level:   strings.ToLower(level.String()),
+		Module:  rec.Module,
+		Message: rec.Message(),
+	})
+	if err != nil {
+		return err
+	}
 
-	backend := logging.NewLogBackend(os.Stdout, "", 0)
-	levelBackend = logging.AddModuleLevel(logging.NewBackendFormatter(backend, format))
-	levelBackend.SetLevel(logging.INFO, module)
-	log.SetBackend(levelBackend)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, err = b.w.Write(append(line, '\n'))
+	return err
 }
 
 // GetLog is log util
-func GetLog() *logging.Logger {
+func GetLog() Logger {
 	return log
 }
 
+// GetModuleLog return the logger of the module, the level of each module can be set separately
+func GetModuleLog(name string) Logger {
+	return moduleLogger{logging.MustGetLogger(name)}
+}
+
+// SetLogFormat set the output format, text or json
+func SetLogFormat(format string) error {
+	var backend logging.Backend
+	switch format {
+	case "", FormatText:
+		backend = newTextBackend(os.Stdout)
+	case FormatJSON:
+		backend = &jsonBackend{w: os.Stdout}
+	default:
+		return fmt.Errorf("unsupported log format %s", format)
+	}
+
+	levels.lock.Lock()
+	defer levels.lock.Unlock()
+	levels.backend = backend
+	return nil
+}
+
+// ParseLogLevel check the level is debug, info, warning or error
+func ParseLogLevel(level string) error {
+	_, err := parseLevel(level)
+	return err
+}
+
+func parseLevel(level string) (logging.Level, error) {
+	switch strings.ToLower(level) {
+	case LevelDebug:
+		return logging.DEBUG, nil
+	case LevelInfo:
+		return logging.INFO, nil
+	case LevelWarning:
+		return logging.WARNING, nil
+	case LevelError:
+		return logging.ERROR, nil
+	}
+	return logging.INFO, fmt.Errorf("unsupported log level %s", level)
+}
+
+// SetLogLevel set the level of the module, all the modules if module is empty, the override
+// of the module is removed if level is empty
+func SetLogLevel(module string, level string) error {
+	if module != "" && level == "" {
+		levels.lock.Lock()
+		defer levels.lock.Unlock()
+		delete(levels.modules, module)
+		return nil
+	}
+
+	l, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	levels.SetLevel(l, module)
+	return nil
+}
+
+// SetModuleLogLevels replace the level overrides of the modules
+func SetModuleLogLevels(modules map[string]string) error {
+	parsed := make(map[string]logging.Level, len(modules))
+	for module, level := range modules {
+		l, err := parseLevel(level)
+		if err != nil {
+			return err
+		}
+		parsed[module] = l
+	}
+
+	levels.lock.Lock()
+	defer levels.lock.Unlock()
+	levels.modules = parsed
+	return nil
+}
+
+// GetLogLevel return the level of the module, the level of all the modules if module is empty
+func GetLogLevel(module string) string {
+	return strings.ToLower(levels.GetLevel(module).String())
+}
+
+// GetModuleLogLevels return the level overrides of the modules
+func GetModuleLogLevels() map[string]string {
+	levels.lock.RLock()
+	defer levels.lock.RUnlock()
+
+	r := make(map[string]string, len(levels.modules))
+	for module, level := range levels.modules {
+		r[module] = strings.ToLower(level.String())
+	}
+	return r
+}
+
 // SetLogLevelDebug is for setting global log level
 func SetLogLevelDebug() {
-	levelBackend.SetLevel(logging.DEBUG, module)
+	levels.SetLevel(logging.DEBUG, "")
+}
+
+// SetLogLevelInfo is for restore the default global log level
+func SetLogLevelInfo() {
+	levels.SetLevel(logging.INFO, "")
+}
+
+// IsLogLevelDebug return whether the debug log is enabled
+func IsLogLevelDebug() bool {
+	return levels.GetLevel("") == logging.DEBUG
 }
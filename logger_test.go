@@ -0,0 +1,64 @@
+package kungfu
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+func TestModuleLogLevel(t *testing.T) {
+	defer SetModuleLogLevels(nil)
+
+	logger := GetModuleLog("test")
+	if logger.DebugEnabled() {
+		t.Fatal("debug should be disabled by default")
+	}
+
+	if err := SetLogLevel("test", LevelDebug); err != nil {
+		t.Fatal(err)
+	}
+
+	if !logger.DebugEnabled() || IsLogLevelDebug() {
+		t.Fatal("debug should be enabled only for the module")
+	}
+
+	if err := SetLogLevel("test", ""); err != nil || logger.DebugEnabled() {
+		t.Fatal("module level should be removed", err)
+	}
+
+	if err := SetLogLevel("", "verbose"); err == nil {
+		t.Fatal("invalid level should fail")
+	}
+}
+
+func TestJSONBackend(t *testing.T) {
+	var buf bytes.Buffer
+
+	levels.lock.Lock()
+	backend := levels.backend
+	levels.backend = &jsonBackend{w: &buf}
+	levels.lock.Unlock()
+
+	defer func() {
+		levels.lock.Lock()
+		levels.backend = backend
+		levels.lock.Unlock()
+	}()
+
+	GetModuleLog("test").Warning("hello %s", "world")
+
+	var rec jsonRecord
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Level != LevelWarning || rec.Module != "test" || rec.Message != "hello world" {
+		t.Fatalf("unexpected record %s", buf.String())
+	}
+
+	if levels.IsEnabledFor(logging.DEBUG, "test") {
+		t.Fatal("debug should be disabled")
+	}
+}
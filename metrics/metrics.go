@@ -0,0 +1,443 @@
+// Package metrics is a minimal metrics registry, exported in the prometheus text format
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yinheli/kungfu"
+)
+
+var (
+	log = kungfu.GetModuleLog("metrics")
+
+	registryLock sync.RWMutex
+	registry     = make(map[string]collector)
+	collectHooks []func()
+)
+
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
+// DefaultBuckets is the default histogram buckets in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(buf *bytes.Buffer)
+	// writeLines write the series in the influx line protocol, tags is the extra tags
+	writeLines(buf *bytes.Buffer, tags string, ts int64)
+}
+
+// register the metric, panic on duplicate name which is a programming error
+func register(name string, c collector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("duplicate metric " + name)
+	}
+	registry[name] = c
+}
+
+// OnCollect add the hook called before each collect, e.g. update the gauges
+func OnCollect(fn func()) {
+	registryLock.Lock()
+	collectHooks = append(collectHooks, fn)
+	registryLock.Unlock()
+}
+
+// vec is the series of the metric keyed by label values
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	lock   sync.RWMutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]interface{}),
+		values: make(map[string][]string),
+	}
+}
+
+// get return the series of the label values, create by fn if missing
+func (v *vec) get(values []string, fn func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	v.lock.RLock()
+	s, ok := v.series[key]
+	v.lock.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = fn()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each iterate the series in label values order
+func (v *vec) each(fn func(labels string, s interface{})) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fn(formatLabels(v.labels, v.values[k]), v.series[k])
+	}
+}
+
+// eachTags iterate the series in label values order with the labels as the influx tags, the
+// labels of empty value are omitted
+func (v *vec) eachTags(fn func(tags string, s interface{})) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var tags strings.Builder
+		for i, name := range v.labels {
+			if value := v.values[k][i]; value != "" {
+				tags.WriteString("," + name + "=" + escapeTag(value))
+			}
+		}
+		fn(tags.String(), v.series[k])
+	}
+}
+
+func (v *vec) writeHeader(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.typ)
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%s", name, strconv.Quote(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel append the label to the formatted labels
+func withLabel(labels string, name string, value string) string {
+	pair := fmt.Sprintf("%s=%s", name, strconv.Quote(value))
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// escapeTag escape the tag key or value of the line protocol
+func escapeTag(s string) string {
+	return tagEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is the monotonic counter with labels
+type Counter struct {
+	vec
+}
+
+// NewCounter create and register the counter
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labels)}
+	register(name, c)
+	return c
+}
+
+// Inc increase the counter of the label values by 1
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increase the counter of the label values by n
+func (c *Counter) Add(n uint64, values ...string) {
+	v := c.get(values, func() interface{} { return new(uint64) }).(*uint64)
+	atomic.AddUint64(v, n)
+}
+
+// Value return the counter of the label values
+func (c *Counter) Value(values ...string) uint64 {
+	v := c.get(values, func() interface{} { return new(uint64) }).(*uint64)
+	return atomic.LoadUint64(v)
+}
+
+func (c *Counter) write(buf *bytes.Buffer) {
+	c.writeHeader(buf)
+	c.each(func(labels string, s interface{}) {
+		fmt.Fprintf(buf, "%s%s %d\n", c.name, labels, atomic.LoadUint64(s.(*uint64)))
+	})
+}
+
+func (c *Counter) writeLines(buf *bytes.Buffer, tags string, ts int64) {
+	c.eachTags(func(labels string, s interface{}) {
+		fmt.Fprintf(buf, "%s%s%s value=%di %d\n", c.name, labels, tags, atomic.LoadUint64(s.(*uint64)), ts)
+	})
+}
+
+// Gauge is the value can go up and down with labels
+type Gauge struct {
+	vec
+}
+
+// NewGauge create and register the gauge
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVec(name, help, "gauge", labels)}
+	register(name, g)
+	return g
+}
+
+// Set the gauge of the label values
+func (g *Gauge) Set(f float64, values ...string) {
+	v := g.get(values, func() interface{} { return new(uint64) }).(*uint64)
+	atomic.StoreUint64(v, math.Float64bits(f))
+}
+
+// Add delta to the gauge of the label values
+func (g *Gauge) Add(delta float64, values ...string) {
+	v := g.get(values, func() interface{} { return new(uint64) }).(*uint64)
+	for {
+		old := atomic.LoadUint64(v)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(v, old, next) {
+			return
+		}
+	}
+}
+
+// Value return the gauge of the label values
+func (g *Gauge) Value(values ...string) float64 {
+	v := g.get(values, func() interface{} { return new(uint64) }).(*uint64)
+	return math.Float64frombits(atomic.LoadUint64(v))
+}
+
+func (g *Gauge) write(buf *bytes.Buffer) {
+	g.writeHeader(buf)
+	g.each(func(labels string, s interface{}) {
+		f := math.Float64frombits(atomic.LoadUint64(s.(*uint64)))
+		fmt.Fprintf(buf, "%s%s %s\n", g.name, labels, formatFloat(f))
+	})
+}
+
+func (g *Gauge) writeLines(buf *bytes.Buffer, tags string, ts int64) {
+	g.eachTags(func(labels string, s interface{}) {
+		f := math.Float64frombits(atomic.LoadUint64(s.(*uint64)))
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// not supported by the line protocol
+			return
+		}
+		fmt.Fprintf(buf, "%s%s%s value=%s %d\n", g.name, labels, tags, strconv.FormatFloat(f, 'f', -1, 64), ts)
+	})
+}
+
+// Histogram count the observations in buckets with labels
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+type histogramSeries struct {
+	lock   sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram create and register the histogram, buckets are the upper bounds in increasing order
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{newVec(name, help, "histogram", labels), buckets}
+	register(name, h)
+	return h
+}
+
+// Observe add the observation of the label values
+func (h *Histogram) Observe(f float64, values ...string) {
+	s := h.get(values, func() interface{} {
+		return &histogramSeries{counts: make([]uint64, len(h.buckets))}
+	}).(*histogramSeries)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, bound := range h.buckets {
+		if f <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += f
+}
+
+func (h *Histogram) write(buf *bytes.Buffer) {
+	h.writeHeader(buf)
+	h.each(func(labels string, v interface{}) {
+		s := v.(*histogramSeries)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", h.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", h.name, labels, s.count)
+	})
+}
+
+func (h *Histogram) writeLines(buf *bytes.Buffer, tags string, ts int64) {
+	h.eachTags(func(labels string, v interface{}) {
+		s := v.(*histogramSeries)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s,le=%s%s value=%di %d\n", h.name, labels, formatFloat(bound), tags, s.counts[i], ts)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s,le=+Inf%s value=%di %d\n", h.name, labels, tags, s.count, ts)
+		fmt.Fprintf(buf, "%s_sum%s%s value=%s %d\n", h.name, labels, tags, strconv.FormatFloat(s.sum, 'f', -1, 64), ts)
+		fmt.Fprintf(buf, "%s_count%s%s value=%di %d\n", h.name, labels, tags, s.count, ts)
+	})
+}
+
+// Write all the registered metrics in the prometheus text format
+func Write(buf *bytes.Buffer) {
+	each(func(c collector) {
+		c.write(buf)
+	})
+}
+
+// WriteLines write all the registered metrics in the influx line protocol, the measurement is
+// the metric name, the labels and the tags are the tags, the field is value, ts is the timestamp
+// in nanoseconds
+func WriteLines(buf *bytes.Buffer, tags map[string]string, ts int64) {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var extra strings.Builder
+	for _, name := range names {
+		if tags[name] != "" {
+			extra.WriteString("," + escapeTag(name) + "=" + escapeTag(tags[name]))
+		}
+	}
+
+	each(func(c collector) {
+		c.writeLines(buf, extra.String(), ts)
+	})
+}
+
+// each call the collect hooks then iterate the registered metrics in name order
+func each(fn func(c collector)) {
+	registryLock.RLock()
+	hooks := append([]func(){}, collectHooks...)
+	registryLock.RUnlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fn(registry[name])
+	}
+}
+
+// Handler serve the metrics in the prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := new(bytes.Buffer)
+		Write(buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// Serve start the metrics http server on /metrics in background, empty addr to disable
+func Serve(addr string) {
+	ServeProtected(addr, nil, "", "")
+}
+
+// ServeProtected start the metrics http server like Serve, the handler is wrapped by protect for
+// the auth if not nil, serve https if cert and key configured
+func ServeProtected(addr string, protect func(http.Handler) http.Handler, cert, key string) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	var handler http.Handler = mux
+	if protect != nil {
+		handler = protect(mux)
+	}
+
+	go func() {
+		log.Info("metrics server listen on %s", addr)
+		var err error
+		if cert != "" && key != "" {
+			err = http.ListenAndServeTLS(addr, cert, key, handler)
+		} else {
+			err = http.ListenAndServe(addr, handler)
+		}
+		if err != nil {
+			log.Error("start metrics server fail, %v", err)
+		}
+	}()
+}
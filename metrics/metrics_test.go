@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_queries_total", "test queries", "qtype")
+	c.Inc("A")
+	c.Add(2, "A")
+	c.Inc("AAAA")
+
+	g := NewGauge("test_utilization", "test utilization")
+	OnCollect(func() { g.Set(0.5) })
+
+	h := NewHistogram("test_rtt_seconds", "test rtt", []float64{0.1, 1}, "upstream")
+	h.Observe(0.05, "8.8.8.8:53")
+	h.Observe(0.5, "8.8.8.8:53")
+
+	buf := new(bytes.Buffer)
+	Write(buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE test_queries_total counter",
+		`test_queries_total{qtype="A"} 3`,
+		`test_queries_total{qtype="AAAA"} 1`,
+		"test_utilization 0.5",
+		`test_rtt_seconds_bucket{upstream="8.8.8.8:53",le="0.1"} 1`,
+		`test_rtt_seconds_bucket{upstream="8.8.8.8:53",le="1"} 2`,
+		`test_rtt_seconds_bucket{upstream="8.8.8.8:53",le="+Inf"} 2`,
+		`test_rtt_seconds_count{upstream="8.8.8.8:53"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, out)
+		}
+	}
+}
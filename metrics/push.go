@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultPushInterval is the push interval if not set
+	DefaultPushInterval = time.Duration(10 * time.Second)
+	// pushTimeout is the timeout of a push request
+	pushTimeout = time.Duration(10 * time.Second)
+)
+
+// Pusher push the metrics in the influx line protocol periodically, to the write api of
+// InfluxDB or VictoriaMetrics, e.g. http://127.0.0.1:8086/write?db=kungfu (v1),
+// http://127.0.0.1:8086/api/v2/write?org=home&bucket=kungfu (v2) or http://127.0.0.1:8428/write
+type Pusher struct {
+	// URL is the write api url
+	URL string
+	// Token is the api token sent as Authorization: Token <token>, empty to skip
+	Token string
+	// Interval is the push interval, default 10s
+	Interval time.Duration
+	// Tags is the extra tags of all the series, e.g. the host
+	Tags map[string]string
+
+	client *http.Client
+	stop   chan struct{}
+}
+
+// Start push the metrics in background until stopped
+func (p *Pusher) Start() {
+	if p.Interval <= 0 {
+		p.Interval = DefaultPushInterval
+	}
+	p.client = &http.Client{Timeout: pushTimeout}
+	p.stop = make(chan struct{})
+
+	log.Info("push metrics to %s every %v", p.URL, p.Interval)
+	go func() {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				if err := p.Push(); err != nil {
+					log.Warning("push metrics to %s error, %v", p.URL, err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop pushing the metrics, nil pusher is no-op
+func (p *Pusher) Stop() {
+	if p != nil && p.stop != nil {
+		close(p.stop)
+	}
+}
+
+// Push the metrics now
+func (p *Pusher) Push() error {
+	buf := new(bytes.Buffer)
+	WriteLines(buf, p.Tags, time.Now().UnixNano())
+
+	req, err := http.NewRequest(http.MethodPost, p.URL, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Token "+p.Token)
+	}
+
+	client := p.client
+	if client == nil {
+		client = &http.Client{Timeout: pushTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %s, %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
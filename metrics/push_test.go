@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteLines(t *testing.T) {
+	c := NewCounter("test_lines_total", "test lines", "upstream", "empty")
+	c.Add(3, "tls://1.1.1.1 a,b=c", "")
+
+	g := NewGauge("test_lines_ratio", "test ratio")
+	g.Set(0.25)
+	NewGauge("test_lines_nan", "test nan").Set(math.NaN())
+
+	h := NewHistogram("test_lines_seconds", "test seconds", []float64{0.1})
+	h.Observe(0.05)
+
+	buf := new(bytes.Buffer)
+	WriteLines(buf, map[string]string{"host": "router", "service": "dns"}, 1700000000000000000)
+	out := buf.String()
+
+	for _, line := range []string{
+		`test_lines_total,upstream=tls://1.1.1.1\ a\,b\=c,host=router,service=dns value=3i 1700000000000000000`,
+		"test_lines_ratio,host=router,service=dns value=0.25 1700000000000000000",
+		"test_lines_seconds_bucket,le=0.1,host=router,service=dns value=1i 1700000000000000000",
+		"test_lines_seconds_bucket,le=+Inf,host=router,service=dns value=1i 1700000000000000000",
+		"test_lines_seconds_sum,host=router,service=dns value=0.05 1700000000000000000",
+		"test_lines_seconds_count,host=router,service=dns value=1i 1700000000000000000",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "test_lines_nan") {
+		t.Fatal("NaN should not be written")
+	}
+}
+
+func TestPush(t *testing.T) {
+	NewCounter("test_push_total", "test push").Inc()
+
+	var body, auth string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body, auth = string(data), r.Header.Get("Authorization")
+		w.WriteHeader(status)
+		w.Write([]byte("bad request"))
+	}))
+	defer srv.Close()
+
+	p := &Pusher{URL: srv.URL + "/api/v2/write?org=home&bucket=kungfu", Token: "secret"}
+	if err := p.Push(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "test_push_total value=1i ") || auth != "Token secret" {
+		t.Fatalf("unexpected push %q, %q", body, auth)
+	}
+
+	status = http.StatusBadRequest
+	if err := p.Push(); err == nil || !strings.Contains(err.Error(), "bad request") {
+		t.Fatalf("push should fail with the response, %v", err)
+	}
+
+	var empty *Pusher
+	empty.Stop()
+}
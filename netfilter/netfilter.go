@@ -0,0 +1,237 @@
+// Package netfilter install the iptables rules of the gateway host, the rules are in the
+// chains owned by kungfu and the jumps are tagged by comment, so the leftover of a crashed
+// process can be removed before install
+package netfilter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/yinheli/kungfu"
+)
+
+const (
+	// Chain is the chain of kungfu in nat, mangle and filter table
+	Chain = "KUNGFU"
+	// tag is the comment of the jump rules to the kungfu chain
+	tag = "kungfu"
+
+	// tproxyMark is the fwmark of the packets redirected by TPROXY, the marked packets are
+	// delivered to local by the routes of tproxyTable
+	tproxyMark  = "0x4b46"
+	tproxyTable = "19270"
+)
+
+var log = kungfu.GetModuleLog("netfilter")
+
+// SetLogger replace the logger of the package, should be called before setup
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
+// run execute the command and return the output, replaced in test
+var run = func(name string, args ...string) (string, error) {
+	log.Debug("execute cmd %s %s", name, strings.Join(args, " "))
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %v, %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// writeFile write the sysctl, replaced in test
+var writeFile = ioutil.WriteFile
+
+// Rules is the rules of the gateway host
+type Rules struct {
+	// Network is the fake ip network in CIDR, forwarded to the tun
+	Network string
+	// Network6 is the ipv6 fake ip network in CIDR, empty if not configured
+	Network6 string
+	// DNS is the kungfu dns server ip:port, the dns queries of the forwarded clients are
+	// redirected to it, empty to disable
+	DNS string
+	// Interface is the lan interface the clients come from, empty for all
+	Interface string
+	// TProxyPort is the port the fake ip network is redirected to by TPROXY, 0 if the fake
+	// ip network is routed to the tun
+	TProxyPort int
+}
+
+// chain is a table and the built-in chain jumping to the kungfu chain
+type chain struct {
+	table string
+	from  string
+}
+
+var chains = []chain{
+	{table: "nat", from: "PREROUTING"},
+	{table: "mangle", from: "PREROUTING"},
+	{table: "filter", from: "FORWARD"},
+}
+
+// sysctls return the forwarding switches need to be enabled
+func sysctls(r *Rules) []string {
+	files := []string{"/proc/sys/net/ipv4/ip_forward"}
+	if r.Network6 != "" {
+		files = append(files, "/proc/sys/net/ipv6/conf/all/forwarding")
+	}
+	return files
+}
+
+// Setup remove the leftover rules, then enable the forwarding and install the rules
+func Setup(r *Rules) error {
+	cmds, err := commands(r)
+	if err != nil {
+		return err
+	}
+
+	Cleanup()
+
+	for _, file := range sysctls(r) {
+		if err := writeFile(file, []byte("1"), 0644); err != nil {
+			return fmt.Errorf("enable forwarding error, %v", err)
+		}
+	}
+
+	for _, cmd := range cmds {
+		if _, err := run(cmd[0], cmd[1:]...); err != nil {
+			return err
+		}
+	}
+
+	log.Info("firewall rules installed, network: %s, network6: %s, dns: %s, tproxy port: %d",
+		r.Network, r.Network6, r.DNS, r.TProxyPort)
+	return nil
+}
+
+// Script return the shell script doing the same as Setup, for installing the rules by hand
+func Script(r *Rules) (string, error) {
+	cmds, err := commands(r)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, file := range sysctls(r) {
+		fmt.Fprintf(&b, "echo 1 > %s\n", file)
+	}
+	for _, cmd := range cmds {
+		b.WriteString(strings.Join(cmd, " "))
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// Cleanup remove the jumps tagged, the kungfu chains and the tproxy routes, include the rules
+// installed by a crashed process with other options, the missing rules are ignored
+func Cleanup() {
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		for _, c := range chains {
+			out, err := run(cmd, "-t", c.table, "-S", c.from)
+			if err != nil {
+				continue
+			}
+
+			for _, line := range strings.Split(out, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) < 2 || fields[0] != "-A" || !strings.Contains(line, "--comment "+tag+" ") {
+					continue
+				}
+				fields[0] = "-D"
+				run(cmd, append([]string{"-t", c.table}, fields...)...)
+			}
+
+			run(cmd, "-t", c.table, "-F", Chain)
+			run(cmd, "-t", c.table, "-X", Chain)
+		}
+	}
+
+	for _, family := range []string{"-4", "-6"} {
+		run("ip", family, "rule", "del", "fwmark", tproxyMark, "lookup", tproxyTable)
+		run("ip", family, "route", "flush", "table", tproxyTable)
+	}
+}
+
+// commands return the commands installing the rules, each is the name and the args
+func commands(r *Rules) ([][]string, error) {
+	cmds, err := install("iptables", "-4", r.Network, r.DNS, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Network6 == "" {
+		return cmds, nil
+	}
+
+	// the dns is redirected by the ipv4 rules only
+	cmds6, err := install("ip6tables", "-6", r.Network6, "", r)
+	if err != nil {
+		return nil, err
+	}
+	return append(cmds, cmds6...), nil
+}
+
+func install(cmd string, family string, network string, dns string, r *Rules) ([][]string, error) {
+	if _, _, err := net.ParseCIDR(network); err != nil {
+		return nil, fmt.Errorf("invalid network %s, %v", network, err)
+	}
+
+	var cmds [][]string
+	for _, c := range chains {
+		cmds = append(cmds, []string{cmd, "-t", c.table, "-N", Chain})
+	}
+
+	// accept the forwarding of the fake ip network, the default policy may be drop
+	cmds = append(cmds,
+		[]string{cmd, "-t", "filter", "-A", Chain, "-d", network, "-j", "ACCEPT"},
+		[]string{cmd, "-t", "filter", "-A", Chain, "-s", network, "-j", "ACCEPT"},
+	)
+
+	if dns != "" {
+		host, _, err := net.SplitHostPort(dns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns %s, %v", dns, err)
+		}
+		// the queries of the dns server itself are not redirected
+		for _, proto := range []string{"udp", "tcp"} {
+			cmds = append(cmds, []string{cmd, "-t", "nat", "-A", Chain, "!", "-s", host, "-p", proto,
+				"--dport", "53", "-j", "DNAT", "--to-destination", dns})
+		}
+	}
+
+	if r.TProxyPort > 0 {
+		port := strconv.Itoa(r.TProxyPort)
+		for _, proto := range []string{"tcp", "udp"} {
+			cmds = append(cmds, []string{cmd, "-t", "mangle", "-A", Chain, "-d", network, "-p", proto,
+				"-j", "TPROXY", "--on-port", port, "--tproxy-mark", tproxyMark + "/" + tproxyMark})
+		}
+
+		local := "0.0.0.0/0"
+		if family == "-6" {
+			local = "::/0"
+		}
+		cmds = append(cmds,
+			[]string{"ip", family, "rule", "add", "fwmark", tproxyMark, "lookup", tproxyTable},
+			[]string{"ip", family, "route", "add", "local", local, "dev", "lo", "table", tproxyTable},
+		)
+	}
+
+	for _, c := range chains {
+		cmds = append(cmds, append([]string{cmd, "-t", c.table, "-I", c.from}, jump(r.Interface)...))
+	}
+	return cmds, nil
+}
+
+// jump is the tagged rule jump to the kungfu chain
+func jump(ifce string) []string {
+	var rule []string
+	if ifce != "" {
+		rule = append(rule, "-i", ifce)
+	}
+	return append(rule, "-m", "comment", "--comment", tag, "-j", Chain)
+}
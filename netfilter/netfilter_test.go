@@ -0,0 +1,78 @@
+package netfilter
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeRun record the commands, the chains listed have the leftover rules
+func fakeRun(cmds *[]string) func(name string, args ...string) (string, error) {
+	return func(name string, args ...string) (string, error) {
+		cmd := name + " " + strings.Join(args, " ")
+		*cmds = append(*cmds, cmd)
+		if strings.Contains(cmd, "-S PREROUTING") {
+			return "-P PREROUTING ACCEPT\n" +
+				"-A PREROUTING -i eth1 -m comment --comment kungfu -j KUNGFU\n" +
+				"-A PREROUTING -i eth0 -j DOCKER\n", nil
+		}
+		return "", nil
+	}
+}
+
+func TestSetup(t *testing.T) {
+	var cmds []string
+	run = fakeRun(&cmds)
+	writeFile = func(string, []byte, os.FileMode) error { return nil }
+
+	err := Setup(&Rules{Network: "10.85.0.0/16", DNS: "10.0.0.2:53", Interface: "eth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := strings.Join(cmds, "\n")
+	for _, expect := range []string{
+		"iptables -t nat -D PREROUTING -i eth1 -m comment --comment kungfu -j KUNGFU",
+		"iptables -t nat -X KUNGFU",
+		"iptables -t filter -A KUNGFU -d 10.85.0.0/16 -j ACCEPT",
+		"iptables -t nat -A KUNGFU ! -s 10.0.0.2 -p udp --dport 53 -j DNAT --to-destination 10.0.0.2:53",
+		"iptables -t nat -I PREROUTING -i eth0 -m comment --comment kungfu -j KUNGFU",
+		"iptables -t filter -I FORWARD -i eth0 -m comment --comment kungfu -j KUNGFU",
+	} {
+		if !strings.Contains(all, expect) {
+			t.Fatal("missing command", expect)
+		}
+	}
+
+	if strings.Contains(all, "DOCKER") {
+		t.Fatal("rules not tagged should be kept")
+	}
+
+	if strings.Contains(all, "ip6tables -t filter -A") {
+		t.Fatal("ipv6 rules should not be installed without ipv6 network")
+	}
+
+	if err := Setup(&Rules{Network: "10.85.0.0", DNS: "10.0.0.2:53"}); err == nil {
+		t.Fatal("invalid network should fail")
+	}
+}
+
+func TestScriptTProxy(t *testing.T) {
+	script, err := Script(&Rules{Network: "10.85.0.0/16", Network6: "fd00:85::/64", TProxyPort: 1080})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expect := range []string{
+		"echo 1 > /proc/sys/net/ipv6/conf/all/forwarding",
+		"iptables -t mangle -A KUNGFU -d 10.85.0.0/16 -p udp -j TPROXY --on-port 1080 --tproxy-mark 0x4b46/0x4b46",
+		"ip6tables -t mangle -A KUNGFU -d fd00:85::/64 -p tcp -j TPROXY --on-port 1080 --tproxy-mark 0x4b46/0x4b46",
+		"ip -4 rule add fwmark 0x4b46 lookup 19270",
+		"ip -6 route add local ::/0 dev lo table 19270",
+		"iptables -t mangle -I PREROUTING -m comment --comment kungfu -j KUNGFU",
+	} {
+		if !strings.Contains(script, expect) {
+			t.Fatal("missing command", expect)
+		}
+	}
+}
@@ -16,6 +16,7 @@ RELEASE_DIR="$CURRENT_DIR/release"
 
 BIN_DNS_SERVER="kungfu-dns-server"
 BIN_GATEWAY_SERVER="kungfu-gateway-server"
+BIN_CLI="kungfu"
 
 echo "GOPATH: $GOPATH"
 export GOPATH="$GOPATH"
@@ -28,6 +29,7 @@ fi
 
 go build -o "$RELEASE_DIR/$BIN_DNS_SERVER" -ldflags="-X main.build=$GIT_HASH -s -w" dns/server/main.go
 go build -o "$RELEASE_DIR/$BIN_GATEWAY_SERVER" -ldflags="-X main.build=$GIT_HASH -s -w" gateway/server/main.go
+go build -o "$RELEASE_DIR/$BIN_CLI" -ldflags="-X main.build=$GIT_HASH -s -w" cli/main.go
 cp "$CURRENT_DIR/config-example.yml" "$RELEASE_DIR/config.yml"
 
 echo "Done!"
@@ -0,0 +1,376 @@
+// Package tracing is a minimal tracer, the spans are exported in the OTLP/HTTP json format to the
+// OpenTelemetry collector, Jaeger or Tempo
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinheli/kungfu"
+)
+
+var log = kungfu.GetModuleLog("tracing")
+
+// SetLogger replace the logger of the package, should be called before start
+func SetLogger(logger kungfu.Logger) {
+	log = logger
+}
+
+// the kinds of the spans
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+const (
+	// exportInterval is the interval of exporting the ended spans
+	exportInterval = time.Duration(5 * time.Second)
+	// exportBatchSize export the spans at once if the batch is full
+	exportBatchSize = 512
+	// queueSize is the max spans waiting for export, the spans are dropped if full
+	queueSize = 4096
+	// exportTimeout is the timeout of an export request
+	exportTimeout = time.Duration(10 * time.Second)
+)
+
+// Tracer create the spans sampled by the ratio and export the ended spans in batches
+type Tracer struct {
+	// URL is the OTLP/HTTP traces endpoint, e.g. http://127.0.0.1:4318/v1/traces
+	URL string
+	// Service is the service.name of the resource
+	Service string
+	// Sample is the ratio of the root spans sampled, all if 0 or 1
+	Sample float64
+
+	queue   chan *Span
+	dropped uint64
+	client  *http.Client
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Start export the spans in background until stopped
+func (t *Tracer) Start() {
+	t.queue = make(chan *Span, queueSize)
+	t.client = &http.Client{Timeout: exportTimeout}
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+
+	log.Info("export traces of %s to %s, sample %v", t.Service, t.URL, t.Sample)
+	go t.run()
+}
+
+// Stop export the pending spans and stop, nil tracer is no-op
+func (t *Tracer) Stop() {
+	if t == nil || t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+// StartSpan start the root span of a new trace, nil if the tracer is nil or not sampled
+func (t *Tracer) StartSpan(name string, kind int) *Span {
+	if t == nil || t.queue == nil {
+		return nil
+	}
+	if t.Sample > 0 && t.Sample < 1 && mrand.Float64() >= t.Sample {
+		return nil
+	}
+
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	rand.Read(s.traceID[:])
+	rand.Read(s.spanID[:])
+	return s
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, exportBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			log.Warning("export %d spans to %s error, %v", len(batch), t.URL, err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			if n := atomic.SwapUint64(&t.dropped, 0); n > 0 {
+				log.Warning("drop %d spans, the export queue is full", n)
+			}
+		case <-t.stop:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+					continue
+				default:
+				}
+				break
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// end queue the ended span for export, dropped if the queue is full
+func (t *Tracer) end(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// export post the spans in the OTLP/HTTP json format
+func (t *Tracer) export(spans []*Span) error {
+	data, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %s, %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// Span is a timed operation of the trace, all the methods of nil span are no-op, so the spans
+// not sampled cost nothing
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	lock  sync.Mutex
+	end   time.Time
+	attrs []attribute
+	err   string
+	ended bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Child start the child span, nil if the span is nil
+func (s *Span) Child(name string, kind int) *Span {
+	if s == nil {
+		return nil
+	}
+
+	c := &Span{tracer: s.tracer, traceID: s.traceID, parentID: s.spanID, name: name, kind: kind, start: time.Now()}
+	rand.Read(c.spanID[:])
+	return c
+}
+
+// SetAttr set the attribute of the span, the value is string, bool, int, int64, float64 or
+// formatted by %v
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attribute{key: key, value: value})
+}
+
+// SetError mark the span failed with the error, nil error is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err.Error()
+}
+
+// End the span and queue it for export, the span ended is not changed
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.lock.Unlock()
+
+	s.tracer.end(s)
+}
+
+// TraceID return the trace id in hex, empty if the span is nil
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// the OTLP/HTTP json types of the export request
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	// Code is 0 unset, 1 ok or 2 error
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	}
+	s := fmt.Sprint(v)
+	return otlpValue{StringValue: &s}
+}
+
+func (t *Tracer) encode(spans []*Span) *otlpRequest {
+	list := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.lock.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, a := range s.attrs {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: a.key, Value: newValue(a.value)})
+		}
+		if s.err != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		s.lock.Unlock()
+		list = append(list, span)
+	}
+
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: newValue(t.Service)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: kungfu.Name, Version: kungfu.Version},
+			Spans: list,
+		}},
+	}}}
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracerExport(t *testing.T) {
+	requests := make(chan *otlpRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := new(otlpRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Error(err)
+		}
+		requests <- req
+	}))
+	defer srv.Close()
+
+	tracer := &Tracer{URL: srv.URL + "/v1/traces", Service: "kungfu-dns"}
+	tracer.Start()
+
+	root := tracer.StartSpan("dns query", KindServer)
+	root.SetAttr("dns.qname", "example.com.")
+	root.SetAttr("dns.qname", "www.example.com.")
+	root.SetAttr("relay.upload_bytes", int64(42))
+	child := root.Child("upstream exchange", KindClient)
+	child.SetError(errors.New("i/o timeout"))
+	child.End()
+	root.End()
+	root.End()
+	tracer.Stop()
+
+	req := <-requests
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if v := req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; v == nil || *v != "kungfu-dns" {
+		t.Fatal("service name should be the resource attribute")
+	}
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("should export 2 spans, got %d", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if c.TraceID != root.TraceID() || r.TraceID != root.TraceID() || c.ParentSpanID != r.SpanID || r.ParentSpanID != "" {
+		t.Fatalf("unexpected trace of the spans %+v", spans)
+	}
+	if c.Kind != KindClient || c.Status.Code != 2 || c.Status.Message != "i/o timeout" {
+		t.Fatalf("unexpected child span %+v", c)
+	}
+	if len(r.Attributes) != 2 || *r.Attributes[0].Value.StringValue != "www.example.com." || *r.Attributes[1].Value.IntValue != "42" {
+		t.Fatalf("unexpected attributes %+v", r.Attributes)
+	}
+}
+
+func TestTracerNil(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.StartSpan("dns query", KindServer)
+	if span != nil {
+		t.Fatal("nil tracer should not start span")
+	}
+	span.Child("store lookup", KindClient).End()
+	span.SetAttr("dns.qname", "example.com.")
+	span.SetError(errors.New("fail"))
+	span.End()
+	tracer.Stop()
+
+	// not started
+	if (&Tracer{URL: "http://127.0.0.1:4318/v1/traces"}).StartSpan("dns query", KindServer) != nil {
+		t.Fatal("tracer not started should not start span")
+	}
+}